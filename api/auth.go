@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// func Server.checkToken {{{
+
+// Returns codes.Unauthenticated unless the call includes our token, any call is allowed if no token is set.
+func (s *Server) checkToken(ctx context.Context) error {
+	if s.co.Token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, v := range md.Get("authorization") {
+		got := strings.TrimPrefix(v, "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.co.Token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "invalid token")
+} // }}}
+
+// func Server.unaryAuth {{{
+
+func (s *Server) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.checkToken(ctx); err != nil {
+		s.l.Debug().Str("func", "unaryAuth").Str("method", info.FullMethod).Msg("denied")
+		return nil, err
+	}
+
+	return handler(ctx, req)
+} // }}}
+
+// func Server.streamAuth {{{
+
+func (s *Server) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkToken(ss.Context()); err != nil {
+		s.l.Debug().Str("func", "streamAuth").Str("method", info.FullMethod).Msg("denied")
+		return err
+	}
+
+	return handler(srv, ss)
+} // }}}
+
+// func isLoopback {{{
+
+// Returns true if the listen address only accepts connections from this machine.
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+} // }}}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCheckToken(t *testing.T) {
+	s := &Server{co: &conf{Token: "secret"}}
+
+	tests := []struct {
+		Name     string
+		MD       metadata.MD
+		Expected bool
+	}{
+		{"none", nil, false},
+		{"bearer", metadata.Pairs("authorization", "Bearer secret"), true},
+		{"bare", metadata.Pairs("authorization", "secret"), true},
+		{"wrong", metadata.Pairs("authorization", "Bearer secrets"), false},
+		{"empty", metadata.Pairs("authorization", ""), false},
+	}
+
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.MD != nil {
+			ctx = metadata.NewIncomingContext(ctx, test.MD)
+		}
+
+		if got := s.checkToken(ctx) == nil; got != test.Expected {
+			t.Fatalf("checkToken %s Expected %v != Got %v", test.Name, test.Expected, got)
+		}
+	}
+
+	// No token, anything goes.
+	s.co.Token = ""
+
+	if err := s.checkToken(context.Background()); err != nil {
+		t.Fatalf("checkToken unset Expected nil != Got %v", err)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:50051":   true,
+		"127.0.0.1:50051":   true,
+		"[::1]:50051":       true,
+		":50051":            false,
+		"0.0.0.0:50051":     false,
+		"192.168.1.2:50051": false,
+		"invalid":           false,
+	}
+
+	for listen, expected := range tests {
+		if got := isLoopback(listen); got != expected {
+			t.Fatalf("isLoopback %s Expected %v != Got %v", listen, expected, got)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"frame/internal/redact"
+	"frame/yconf"
+)
+
+var ycCallers = yconf.Callers{
+	Empty: func() interface{} { return &conf{} },
+	Merge: yconfMerge,
+}
+
+// func Server.loadConf {{{
+
+// We only load our configuration once, changing where we listen while running would only drop every client.
+//
+// So any changes need a restart.
+func (s *Server) loadConf() error {
+	var err error
+
+	fl := s.l.With().Str("func", "loadConf").Logger()
+
+	if s.yc, err = yconf.New(s.cFile, ycCallers, &s.l, s.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	if err = s.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	// Get the loaded configuration
+	co, ok := s.yc.Get().(*conf)
+	if !ok || co == nil {
+		// This one should not really be possible, so this error needs to be sent.
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(co)).Send()
+
+	if co.Listen == "" {
+		co.Listen = "localhost:50051"
+	}
+
+	if co.ChunkSize < 1 {
+		co.ChunkSize = 64 * 1024
+	}
+
+	if co.MaxSize < 1 {
+		co.MaxSize = 8192
+	}
+
+	if co.TLS != nil && (co.TLS.Cert == "" || co.TLS.Key == "") {
+		err := errors.New("tls needs both cert and key")
+		fl.Err(err).Send()
+		return err
+	}
+
+	s.co = co
+
+	return nil
+} // }}}
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inB.Listen != "" {
+		inA.Listen = inB.Listen
+	}
+
+	if inB.ChunkSize != 0 {
+		inA.ChunkSize = inB.ChunkSize
+	}
+
+	if inB.MaxSize != 0 {
+		inA.MaxSize = inB.MaxSize
+	}
+
+	if inB.Token != "" {
+		inA.Token = inB.Token
+	}
+
+	if inB.TLS != nil {
+		inA.TLS = inB.TLS
+	}
+
+	return inA, nil
+} // }}}
@@ -0,0 +1,281 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FrameClient is the client API for Frame service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FrameClient interface {
+	// Random image IDs from a Weighter profile, see WeighterProfile.Get.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Loads an image from the CacheManager, streamed back in chunks of the encoded image.
+	LoadImage(ctx context.Context, in *LoadImageRequest, opts ...grpc.CallOption) (Frame_LoadImageClient, error)
+	// TagManager lookups, by name or by ID.
+	TagID(ctx context.Context, in *TagRequest, opts ...grpc.CallOption) (*Tag, error)
+	TagName(ctx context.Context, in *TagRequest, opts ...grpc.CallOption) (*Tag, error)
+	// How often each image, profile and tag was shown over time, see WeighterStats.UsageStats.
+	UsageStats(ctx context.Context, in *UsageStatsRequest, opts ...grpc.CallOption) (*UsageStatsResponse, error)
+}
+
+type frameClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFrameClient(cc grpc.ClientConnInterface) FrameClient {
+	return &frameClient{cc}
+}
+
+func (c *frameClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/frame.Frame/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *frameClient) LoadImage(ctx context.Context, in *LoadImageRequest, opts ...grpc.CallOption) (Frame_LoadImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Frame_ServiceDesc.Streams[0], "/frame.Frame/LoadImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &frameLoadImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Frame_LoadImageClient interface {
+	Recv() (*ImageChunk, error)
+	grpc.ClientStream
+}
+
+type frameLoadImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *frameLoadImageClient) Recv() (*ImageChunk, error) {
+	m := new(ImageChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *frameClient) TagID(ctx context.Context, in *TagRequest, opts ...grpc.CallOption) (*Tag, error) {
+	out := new(Tag)
+	err := c.cc.Invoke(ctx, "/frame.Frame/TagID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *frameClient) TagName(ctx context.Context, in *TagRequest, opts ...grpc.CallOption) (*Tag, error) {
+	out := new(Tag)
+	err := c.cc.Invoke(ctx, "/frame.Frame/TagName", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *frameClient) UsageStats(ctx context.Context, in *UsageStatsRequest, opts ...grpc.CallOption) (*UsageStatsResponse, error) {
+	out := new(UsageStatsResponse)
+	err := c.cc.Invoke(ctx, "/frame.Frame/UsageStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FrameServer is the server API for Frame service.
+// All implementations must embed UnimplementedFrameServer
+// for forward compatibility
+type FrameServer interface {
+	// Random image IDs from a Weighter profile, see WeighterProfile.Get.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Loads an image from the CacheManager, streamed back in chunks of the encoded image.
+	LoadImage(*LoadImageRequest, Frame_LoadImageServer) error
+	// TagManager lookups, by name or by ID.
+	TagID(context.Context, *TagRequest) (*Tag, error)
+	TagName(context.Context, *TagRequest) (*Tag, error)
+	// How often each image, profile and tag was shown over time, see WeighterStats.UsageStats.
+	UsageStats(context.Context, *UsageStatsRequest) (*UsageStatsResponse, error)
+	mustEmbedUnimplementedFrameServer()
+}
+
+// UnimplementedFrameServer must be embedded to have forward compatible implementations.
+type UnimplementedFrameServer struct {
+}
+
+func (UnimplementedFrameServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedFrameServer) LoadImage(*LoadImageRequest, Frame_LoadImageServer) error {
+	return status.Errorf(codes.Unimplemented, "method LoadImage not implemented")
+}
+func (UnimplementedFrameServer) TagID(context.Context, *TagRequest) (*Tag, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TagID not implemented")
+}
+func (UnimplementedFrameServer) TagName(context.Context, *TagRequest) (*Tag, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TagName not implemented")
+}
+func (UnimplementedFrameServer) UsageStats(context.Context, *UsageStatsRequest) (*UsageStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UsageStats not implemented")
+}
+func (UnimplementedFrameServer) mustEmbedUnimplementedFrameServer() {}
+
+// UnsafeFrameServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FrameServer will
+// result in compilation errors.
+type UnsafeFrameServer interface {
+	mustEmbedUnimplementedFrameServer()
+}
+
+func RegisterFrameServer(s grpc.ServiceRegistrar, srv FrameServer) {
+	s.RegisterService(&Frame_ServiceDesc, srv)
+}
+
+func _Frame_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FrameServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frame.Frame/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FrameServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Frame_LoadImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(LoadImageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FrameServer).LoadImage(m, &frameLoadImageServer{stream})
+}
+
+type Frame_LoadImageServer interface {
+	Send(*ImageChunk) error
+	grpc.ServerStream
+}
+
+type frameLoadImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *frameLoadImageServer) Send(m *ImageChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Frame_TagID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TagRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FrameServer).TagID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frame.Frame/TagID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FrameServer).TagID(ctx, req.(*TagRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Frame_TagName_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TagRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FrameServer).TagName(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frame.Frame/TagName",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FrameServer).TagName(ctx, req.(*TagRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Frame_UsageStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsageStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FrameServer).UsageStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frame.Frame/UsageStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FrameServer).UsageStats(ctx, req.(*UsageStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Frame_ServiceDesc is the grpc.ServiceDesc for Frame service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Frame_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "frame.Frame",
+	HandlerType: (*FrameServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Frame_Get_Handler,
+		},
+		{
+			MethodName: "TagID",
+			Handler:    _Frame_TagID_Handler,
+		},
+		{
+			MethodName: "TagName",
+			Handler:    _Frame_TagName_Handler,
+		},
+		{
+			MethodName: "UsageStats",
+			Handler:    _Frame_UsageStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "LoadImage",
+			Handler:       _Frame_LoadImage_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "frame.proto",
+}
@@ -0,0 +1,321 @@
+// An optional gRPC server letting other devices use frame as a backend.
+//
+// Exposes selecting images from a Weighter profile, loading the images themselves from the CacheManager,
+// TagManager lookups and how often images were shown. This way a viewer on another device can run its own slideshow
+// without sharing a filesystem with us.
+//
+// See frame.proto for the API itself.
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	fimg "frame/image"
+	"frame/internal/features"
+	"frame/types"
+	"image"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// func New {{{
+
+// Any of we, cm or tm can be nil if not loaded, the calls using them will then fail with codes.Unavailable.
+func New(confFile string, we types.Weighter, cm types.CacheManager, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (*Server, error) {
+	var err error
+
+	s := &Server{
+		l:     l.With().Str("mod", "api").Logger(),
+		we:    we,
+		cm:    cm,
+		tm:    tm,
+		cFile: confFile,
+		ctx:   ctx,
+	}
+
+	fl := s.l.With().Str("func", "New").Logger()
+
+	features.Log(&fl, "api")
+
+	// Load our configuration.
+	if err = s.loadConf(); err != nil {
+		return nil, err
+	}
+
+	if s.ln, err = net.Listen("tcp", s.co.Listen); err != nil {
+		fl.Err(err).Str("listen", s.co.Listen).Msg("Listen")
+		return nil, err
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryAuth),
+		grpc.StreamInterceptor(s.streamAuth),
+	}
+
+	if s.co.TLS != nil {
+		creds, err := credentials.NewServerTLSFromFile(s.co.TLS.Cert, s.co.TLS.Key)
+		if err != nil {
+			fl.Err(err).Msg("NewServerTLSFromFile")
+			s.ln.Close()
+			return nil, err
+		}
+
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	if s.co.Token == "" && !isLoopback(s.co.Listen) {
+		fl.Warn().Str("listen", s.co.Listen).Msg("no token set, anyone able to connect can use the api")
+	}
+
+	s.gs = grpc.NewServer(opts...)
+	RegisterFrameServer(s.gs, s)
+
+	go func() {
+		if err := s.gs.Serve(s.ln); err != nil {
+			fl.Err(err).Msg("Serve")
+		}
+	}()
+
+	// Background goroutine to watch the context and shut us down.
+	go func() {
+		<-s.ctx.Done()
+		s.close()
+	}()
+
+	fl.Info().Str("listen", s.co.Listen).Bool("tls", s.co.TLS != nil).Send()
+
+	return s, nil
+} // }}}
+
+// func Server.close {{{
+
+func (s *Server) close() {
+	fl := s.l.With().Str("func", "close").Logger()
+
+	// Stop closes the listener and any active connections.
+	//
+	// We do not use GracefulStop(), a client in the middle of a large LoadImage would only hold up our shutdown.
+	s.gs.Stop()
+
+	fl.Info().Msg("closed")
+} // }}}
+
+// func toStatus {{{
+
+// Converts our errors to a gRPC status.
+func toStatus(err error, code codes.Code) error {
+	if errors.Is(err, types.ErrShutdown) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if errors.Is(err, types.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return status.Error(code, err.Error())
+} // }}}
+
+// func Server.Get {{{
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	fl := s.l.With().Str("func", "Get").Str("profile", req.Profile).Logger()
+
+	if s.we == nil {
+		return nil, status.Error(codes.Unavailable, "no weighter")
+	}
+
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+
+	// Same limit as WeighterProfile.Get()
+	if count > 100 {
+		return nil, status.Error(codes.InvalidArgument, "count above 100")
+	}
+
+	wp, err := s.we.GetProfile(req.Profile)
+	if err != nil {
+		fl.Debug().Err(err).Msg("GetProfile")
+		return nil, toStatus(err, codes.NotFound)
+	}
+
+	ids, err := wp.Get(uint8(count))
+	if err != nil {
+		fl.Err(err).Msg("WeighterProfile.Get")
+		return nil, toStatus(err, codes.Internal)
+	}
+
+	return &GetResponse{Ids: ids}, nil
+} // }}}
+
+// func Server.LoadImage {{{
+
+func (s *Server) LoadImage(req *LoadImageRequest, stream Frame_LoadImageServer) error {
+	var err error
+
+	fl := s.l.With().Str("func", "LoadImage").Uint64("id", req.Id).Logger()
+
+	if s.cm == nil {
+		return status.Error(codes.Unavailable, "no cachemanager")
+	}
+
+	if req.Width < 0 || req.Height < 0 {
+		return status.Error(codes.InvalidArgument, "invalid size")
+	}
+
+	// Enlarge would otherwise happily allocate whatever we are asked for.
+	if int(req.Width) > s.co.MaxSize || int(req.Height) > s.co.MaxSize {
+		return status.Error(codes.InvalidArgument, "size above maxsize")
+	}
+
+	img, err := s.cm.LoadImage(req.Id, image.Point{int(req.Width), int(req.Height)}, req.Enlarge)
+	if err != nil {
+		fl.Debug().Err(err).Msg("LoadImage")
+		return toStatus(err, codes.NotFound)
+	}
+
+	buf := &bytes.Buffer{}
+
+	switch req.Format {
+	case "", "jpeg":
+		err = fimg.SaveImageJPEG(buf, img)
+	case "png":
+		err = fimg.SaveImagePNG(buf, img)
+	case "webp":
+		// Built without a WebP encoder, see the nowebp tag.
+		if !fimg.WebPEncoder {
+			return status.Error(codes.Unimplemented, "webp not supported by this build")
+		}
+
+		err = fimg.SaveImageWebP(buf, img)
+	default:
+		return status.Error(codes.InvalidArgument, "unknown format")
+	}
+
+	if err != nil {
+		fl.Err(err).Str("format", req.Format).Msg("encode")
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	data := buf.Bytes()
+
+	for len(data) > 0 {
+		n := s.co.ChunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+
+		if err := stream.Send(&ImageChunk{Data: data[:n]}); err != nil {
+			fl.Debug().Err(err).Msg("Send")
+			return err
+		}
+
+		data = data[n:]
+	}
+
+	return nil
+} // }}}
+
+// func Server.TagID {{{
+
+// Looks up the ID of the tag name.
+//
+// Only tags that already exist, an unknown name is codes.NotFound rather then being added.
+func (s *Server) TagID(ctx context.Context, req *TagRequest) (*Tag, error) {
+	tl, ok := s.tm.(types.TagLooker)
+	if !ok {
+		return nil, status.Error(codes.Unavailable, "no tag lookup")
+	}
+
+	id, err := tl.Lookup(req.Name)
+	if err != nil {
+		return nil, toStatus(err, codes.NotFound)
+	}
+
+	return &Tag{Name: req.Name, Id: id}, nil
+} // }}}
+
+// func Server.TagName {{{
+
+// Looks up the name of the tag ID.
+func (s *Server) TagName(ctx context.Context, req *TagRequest) (*Tag, error) {
+	if s.tm == nil {
+		return nil, status.Error(codes.Unavailable, "no tagmanager")
+	}
+
+	name, err := s.tm.Name(req.Id)
+	if err != nil {
+		return nil, toStatus(err, codes.NotFound)
+	}
+
+	return &Tag{Name: name, Id: req.Id}, nil
+} // }}}
+
+// func Server.UsageStats {{{
+
+// How often images, profiles and tags were shown, if the Weighter keeps track.
+func (s *Server) UsageStats(ctx context.Context, req *UsageStatsRequest) (*UsageStatsResponse, error) {
+	fl := s.l.With().Str("func", "UsageStats").Logger()
+
+	ws, ok := s.we.(types.WeighterStats)
+	if !ok {
+		return nil, status.Error(codes.Unavailable, "no weighter stats")
+	}
+
+	if req.Window < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid window")
+	}
+
+	to := time.Now()
+	if req.To != 0 {
+		to = time.Unix(req.To, 0)
+	}
+
+	from := to.Add(-7 * 24 * time.Hour)
+	if req.From != 0 {
+		from = time.Unix(req.From, 0)
+	}
+
+	windows, err := ws.UsageStats(from, to, time.Duration(req.Window)*time.Second)
+	if err != nil {
+		fl.Debug().Err(err).Msg("UsageStats")
+		return nil, toStatus(err, codes.InvalidArgument)
+	}
+
+	resp := &UsageStatsResponse{Windows: make([]*UsageWindow, 0, len(windows))}
+
+	for _, uw := range windows {
+		w := &UsageWindow{
+			Start:    uw.Start.Unix(),
+			End:      uw.End.Unix(),
+			Outputs:  uint32(uw.Outputs),
+			Images:   make(map[uint64]uint32, len(uw.Images)),
+			Profiles: make(map[string]uint32, len(uw.Profiles)),
+			Tags:     make(map[string]uint32, len(uw.Tags)),
+		}
+
+		for id, count := range uw.Images {
+			w.Images[id] = uint32(count)
+		}
+
+		for name, count := range uw.Profiles {
+			w.Profiles[name] = uint32(count)
+		}
+
+		for name, count := range uw.Tags {
+			w.Tags[name] = uint32(count)
+		}
+
+		resp.Windows = append(resp.Windows, w)
+	}
+
+	return resp, nil
+} // }}}
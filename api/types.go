@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"frame/types"
+	"frame/yconf"
+	"net"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+)
+
+// type conf struct {{{
+
+type conf struct {
+	// The address to listen on, anything net.Listen("tcp", ...) accepts, such as ":50051".
+	//
+	// Default if not set is "localhost:50051", so only this machine can connect.
+	Listen string `yaml:"listen"`
+
+	// Largest LoadImage chunk sent in a single message.
+	//
+	// Default if not set is 64KiB.
+	ChunkSize int `yaml:"chunksize"`
+
+	// Largest width or height a LoadImage may ask for, as the image is resized in memory before being sent.
+	//
+	// Default if not set is 8192.
+	MaxSize int `yaml:"maxsize"`
+
+	// Optional, when set every call must include the metadata "authorization: Bearer <token>".
+	Token string `yaml:"token" log:"redact"`
+
+	// Optional, serves TLS rather then plain text when set.
+	TLS *confTLS `yaml:"tls"`
+} // }}}
+
+// type confTLS struct {{{
+
+type confTLS struct {
+	// PEM encoded certificate (including any intermediates) and its private key.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+} // }}}
+
+// type Server struct {{{
+
+type Server struct {
+	UnimplementedFrameServer
+
+	l zerolog.Logger
+
+	yc *yconf.YConf
+	co *conf
+
+	// Any of these can be nil, the calls needing them then return codes.Unavailable.
+	we types.Weighter
+	cm types.CacheManager
+	tm types.TagManager
+
+	gs *grpc.Server
+	ln net.Listener
+
+	cFile string
+
+	// Lets us know to shutdown.
+	ctx context.Context
+} // }}}
@@ -0,0 +1,639 @@
+// Everything needed to run the frame pipeline from within another Go program.
+//
+// bin/frame is just a thin wrapper around this, adding signal handling and log rotation.
+//
+// Any of the optional modules can be left out of the Config, so you can embed the whole pipeline
+// or just the parts you want, such as only Render along with what it depends on.
+//
+// This is public API, see "Public packages" in README.md for what that promises. The modules themselves live under
+// internal/, so the accessors returning them (such as CacheManager()) are only for calling their methods.
+package app
+
+import (
+	"context"
+	"errors"
+	"frame/api"
+	"frame/internal/chaos"
+	"frame/internal/classify"
+	"frame/internal/cmanager"
+	"frame/internal/cmerge"
+	"frame/internal/faces"
+	"frame/internal/features"
+	"frame/internal/idmanager"
+	"frame/internal/imgproc"
+	"frame/internal/render"
+	"frame/internal/scheduler"
+	"frame/internal/screen"
+	"frame/internal/tagmanager"
+	"frame/internal/tracing"
+	"frame/internal/weighter"
+	"frame/types"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// type Config struct {{{
+
+// Note that at least one of the optional services must be enabled.
+//
+// Those being: ImageProc, CacheMerge or Weighter.
+//
+// Each is the File/Path to the configuration for that module, the same as passed to its New().
+type Config struct {
+	// File/Path to the TagManager configuration, passed in to tagmanager.New()
+	//
+	// This one is not optional.
+	TagManager string `yaml:"tagmanager"`
+
+	// Maps hashes <-> IDs (uint64).
+	//
+	// This one is not optional.
+	IDManager string `yaml:"idmanager"`
+
+	// Configuration path for ImageProc.
+	//
+	// Optional - If left empty ImageProc will not be loaded.
+	ImageProc string `yaml:"imageproc"`
+
+	// Configuration path for CMerge
+	//
+	// Optional - If left empty CMerge will not be loaded.
+	CacheMerge string `yaml:"cachemerge"`
+
+	// Configure the CacheManager.
+	//
+	// Required if either ImageProc or Renderer is configured.
+	CacheManager string `yaml:"cachemanager"`
+
+	// Configure path for Weighter
+	//
+	// Optional - If left empty Weighter will not be loaded.
+	Weighter string `yaml:"weighter"`
+
+	// Configure path for Classify, tagging images by what they show with a local model.
+	//
+	// Optional - If left empty Classify will not be loaded.
+	//
+	// Requires CacheManager.
+	Classify string `yaml:"classify"`
+
+	// Configure path for Faces, tagging the people in images once their face clusters are labeled.
+	//
+	// Optional - If left empty Faces will not be loaded.
+	//
+	// Requires CacheManager. Experimental, no images are checked unless the faces feature is enabled (see Features).
+	Faces string `yaml:"faces"`
+
+	// Configure path for Screen, flagging images with sensitive content in the bases opted in.
+	//
+	// Optional - If left empty Screen will not be loaded.
+	//
+	// Requires CacheManager.
+	Screen string `yaml:"screen"`
+
+	// Configure path for Render.
+	//
+	// Optional - If left empty Render will not be loaded.
+	//
+	// Requires Weighter and CacheManager.
+	Render string `yaml:"render"`
+
+	// Configure path for the gRPC API server.
+	//
+	// Optional - If left empty the API will not be loaded.
+	//
+	// Serves whatever of Weighter, CacheManager and TagManager are loaded.
+	API string `yaml:"api"`
+
+	// OpenTelemetry tracing of the scans, merges, queries and renders.
+	//
+	// Optional - Disabled unless tracing.endpoint is set.
+	Tracing tracing.Config `yaml:"tracing"`
+
+	// If this file exists all background work (scans, merges, polls and renders) is paused until it is removed.
+	//
+	// Handy for maintenance, such as reorganizing the directories of a base without a flood of disables and
+	// re-inserts. Checked every 10 seconds.
+	//
+	// Optional - See also App.Pause().
+	PauseFile string `yaml:"pausefile"`
+
+	// A directory checked every 10 seconds for requests to scan a base (or a path within it) now, such as after
+	// changing the tags of a directory. Written by "frame scan", see RequestScan().
+	//
+	// Optional - Requires ImageProc.
+	ScanRequests string `yaml:"scanrequests"`
+
+	// A backup hook run with all background work paused, see App.Backup().
+	//
+	// Optional.
+	Backup BackupConfig `yaml:"backup"`
+
+	// Turns the display on and off by a schedule and/or presence, holding Render while it is off.
+	//
+	// Optional - See DisplayConfig.
+	Display DisplayConfig `yaml:"display"`
+
+	// Experimental subsystems to enable, such as "faces: true". See the features package for those available.
+	//
+	// Optional - Everything not set is disabled.
+	Features map[string]bool `yaml:"features"`
+} // }}}
+
+// type App struct {{{
+
+type App struct {
+	l  zerolog.Logger
+	co Config
+
+	tm  types.TagManager
+	im  types.IDManager
+	ip  *imgproc.ImageProc
+	cm  *cmerge.CMerge
+	cma *cmanager.CManager
+	we  types.Weighter
+	cl  *classify.Classify
+	fa  *faces.Faces
+	sc  *screen.Screen
+	re  *render.Render
+	api *api.Server
+
+	// Watches the PauseFile and ScanRequests, runs the Backup and controls the Display, if any are set.
+	sch *scheduler.Scheduler
+
+	// nil unless Display is set.
+	disp *display
+
+	// If the PauseFile existed when last checked, only used by checkPauseFile().
+	pauseFile bool
+
+	// Held while Backup() runs.
+	bMut sync.Mutex
+
+	// Our own context, a child of the one given to New().
+	//
+	// Shutdown() cancels it, which shuts down all the modules.
+	ctx context.Context
+	can context.CancelFunc
+} // }}}
+
+// func New {{{
+
+// Loads and starts every module in the Config.
+//
+// Everything is shutdown when either the provided context is done or Shutdown() is called.
+//
+// If any module fails to load, any already loaded are shutdown and the error returned.
+//
+// The Config is checked with Validate() first, so nothing is loaded if it can not all be.
+func New(co *Config, l *zerolog.Logger, ctx context.Context) (*App, error) {
+	var err error
+
+	if co == nil {
+		return nil, errors.New("missing config")
+	}
+
+	if err = Validate(co); err != nil {
+		return nil, err
+	}
+
+	a := &App{
+		l:  l.With().Str("mod", "app").Logger(),
+		co: *co,
+	}
+
+	a.ctx, a.can = context.WithCancel(ctx)
+
+	if err = a.load(l); err != nil {
+		a.Shutdown()
+		return nil, err
+	}
+
+	a.l.Info().Msg("Startup Finished")
+
+	return a, nil
+} // }}}
+
+// func App.load {{{
+
+func (a *App) load(l *zerolog.Logger) error {
+	var err error
+
+	fl := a.l.With().Str("func", "load").Logger()
+
+	co := &a.co
+
+	if chaos.Enabled() {
+		fl.Warn().Msg("FRAME_CHAOS is set, failures are being injected on purpose")
+	}
+
+	// Before any module, they check them as they start.
+	if err = features.Set(co.Features); err != nil {
+		fl.Err(err).Msg("Features")
+		return err
+	}
+
+	features.Log(&fl, "app")
+
+	// Before anything else, so every module has its spans sent.
+	if err = tracing.Start(&co.Tracing, l, a.ctx); err != nil {
+		fl.Err(err).Msg("Tracing")
+		return err
+	}
+
+	if co.TagManager == "" {
+		err = errors.New("Missing tagmanager configuration")
+		fl.Err(err).Send()
+		return err
+	}
+
+	// Now we need the TagManager.
+	if a.tm, err = tagmanager.New(co.TagManager, l, a.ctx); err != nil {
+		a.tm = nil
+		fl.Err(err).Msg("TagManager")
+		return err
+	}
+
+	if co.IDManager == "" {
+		err = errors.New("Missing idmanager configuration")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if a.im, err = idmanager.New(co.IDManager, l, a.ctx); err != nil {
+		a.im = nil
+		fl.Err(err).Msg("IDManager")
+		return err
+	}
+
+	if co.CacheManager != "" {
+		if a.cma, err = cmanager.New(co.CacheManager, a.im, l, a.ctx); err != nil {
+			a.cma = nil
+			fl.Err(err).Msg("CacheManager")
+			return err
+		}
+	}
+
+	// Do we load the ImageProc?
+	if co.ImageProc != "" {
+		if a.cma == nil {
+			err = errors.New("imageproc requires cachemanager")
+			fl.Err(err).Send()
+			return err
+		}
+
+		// And next is our real core, the one doing all the real work here, ImageProc.
+		if a.ip, err = imgproc.New(co.ImageProc, a.tm, a.cma, l, a.ctx); err != nil {
+			a.ip = nil
+			fl.Err(err).Msg("ImageProc")
+			return err
+		}
+	}
+
+	// Load CacheMerge?
+	if co.CacheMerge != "" {
+		if a.cm, err = cmerge.New(co.CacheMerge, a.tm, l, a.ctx); err != nil {
+			a.cm = nil
+			fl.Err(err).Msg("CMerge")
+			return err
+		}
+	}
+
+	// Load the Weighter?
+	if co.Weighter != "" {
+		if a.we, err = weighter.New(co.Weighter, a.tm, l, a.ctx); err != nil {
+			a.we = nil
+			fl.Err(err).Msg("Weighter")
+			return err
+		}
+
+		// Warn about profiles wanting what CMerge blocks.
+		if wb, ok := a.we.(types.WeighterBlocker); ok && a.cm != nil {
+			wb.SetBlockTags(a.cm.BlockTags)
+		}
+	}
+
+	// New files from the imgproc fast lane are merged and given to the weighter right away.
+	if a.ip != nil && (a.cm != nil || a.we != nil) {
+		a.ip.SetFastLane(a.fastLane)
+	}
+
+	if co.Classify != "" {
+		if a.cma == nil {
+			err = errors.New("classify requires cachemanager")
+			fl.Err(err).Send()
+			return err
+		}
+
+		if a.cl, err = classify.New(co.Classify, a.cma, a.tm, l, a.ctx); err != nil {
+			a.cl = nil
+			fl.Err(err).Msg("Classify")
+			return err
+		}
+	}
+
+	if co.Faces != "" {
+		if a.cma == nil {
+			err = errors.New("faces requires cachemanager")
+			fl.Err(err).Send()
+			return err
+		}
+
+		if a.fa, err = faces.New(co.Faces, a.cma, a.tm, l, a.ctx); err != nil {
+			a.fa = nil
+			fl.Err(err).Msg("Faces")
+			return err
+		}
+	}
+
+	if co.Screen != "" {
+		if a.cma == nil {
+			err = errors.New("screen requires cachemanager")
+			fl.Err(err).Send()
+			return err
+		}
+
+		if a.sc, err = screen.New(co.Screen, a.cma, a.tm, l, a.ctx); err != nil {
+			a.sc = nil
+			fl.Err(err).Msg("Screen")
+			return err
+		}
+	}
+
+	if co.Render != "" {
+		if a.we == nil {
+			err = errors.New("render requires weighter")
+			fl.Err(err).Send()
+			return err
+		}
+
+		if a.cma == nil {
+			err = errors.New("render requires cachemanager")
+			fl.Err(err).Send()
+			return err
+		}
+
+		if a.re, err = render.New(co.Render, a.we, a.cma, l, a.ctx); err != nil {
+			a.re = nil
+			fl.Err(err).Msg("Render")
+			return err
+		}
+	}
+
+	if a.disp, err = newDisplay(co.Display); err != nil {
+		fl.Err(err).Msg("Display")
+		return err
+	}
+
+	if co.ScanRequests != "" && a.ip == nil {
+		err = errors.New("scanrequests requires imageproc")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if co.PauseFile != "" || co.ScanRequests != "" || co.Backup.Interval > 0 || a.disp != nil {
+		a.sch = scheduler.New(a.ctx)
+		a.sch.IgnorePause()
+	}
+
+	if co.PauseFile != "" {
+		a.checkPauseFile()
+
+		if err = a.sch.Add("pausefile", 10*time.Second, a.checkPauseFile); err != nil {
+			fl.Err(err).Msg("Add")
+			return err
+		}
+	}
+
+	if co.ScanRequests != "" {
+		if err = a.sch.Add("scanrequests", 10*time.Second, a.checkScanRequests); err != nil {
+			fl.Err(err).Msg("Add")
+			return err
+		}
+	}
+
+	if co.Backup.Interval > 0 {
+		if err = a.sch.Add("backup", time.Duration(co.Backup.Interval), a.tickBackup); err != nil {
+			fl.Err(err).Msg("Add")
+			return err
+		}
+	}
+
+	if a.disp != nil {
+		if err = a.startDisplay(); err != nil {
+			fl.Err(err).Msg("startDisplay")
+			return err
+		}
+	}
+
+	if co.API != "" {
+		// Only pass the CacheManager if we have one, a nil *CManager within the interface is not nil.
+		var cm types.CacheManager
+		if a.cma != nil {
+			cm = a.cma
+		}
+
+		if a.api, err = api.New(co.API, a.we, cm, a.tm, l, a.ctx); err != nil {
+			a.api = nil
+			fl.Err(err).Msg("API")
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func App.Run {{{
+
+// Does not return until the App is shutdown, either from Shutdown() or the context given to New().
+func (a *App) Run() {
+	<-a.ctx.Done()
+} // }}}
+
+// func App.Shutdown {{{
+
+// Signals all the modules to shutdown.
+//
+// Safe to call more then once.
+func (a *App) Shutdown() {
+	if a.ctx.Err() != nil {
+		return
+	}
+
+	// Signal it all to shutdown.
+	a.can()
+
+	a.l.Info().Msg("Shutting down")
+
+	// This time delay gives the above just a little more time to shutdown properly.
+	time.Sleep(300 * time.Millisecond)
+} // }}}
+
+// func App.Pause {{{
+
+// Pauses all background work of every module, scans, merges, polls and renders, until Resume() is called.
+//
+// Anything already running is left to finish. Requests such as from Render or the API are still answered.
+func (a *App) Pause() {
+	if scheduler.Paused() {
+		return
+	}
+
+	scheduler.Pause()
+	a.l.Info().Msg("Paused")
+} // }}}
+
+// func App.Resume {{{
+
+func (a *App) Resume() {
+	if !scheduler.Paused() {
+		return
+	}
+
+	scheduler.Resume()
+	a.l.Info().Msg("Resumed")
+} // }}}
+
+// func App.Paused {{{
+
+func (a *App) Paused() bool {
+	return scheduler.Paused()
+} // }}}
+
+// func App.checkPauseFile {{{
+
+// Pauses or resumes when the PauseFile is created or removed.
+//
+// Only acts on changes to the file, so Pause() and Resume() still work while it is left alone.
+func (a *App) checkPauseFile() {
+	_, err := os.Stat(a.co.PauseFile)
+	exists := err == nil
+
+	if exists == a.pauseFile {
+		return
+	}
+
+	a.pauseFile = exists
+
+	if exists {
+		a.Pause()
+	} else {
+		a.Resume()
+	}
+} // }}}
+
+// func App.fastLane {{{
+
+// Given the hashes of new files by the imgproc fast lane, merges them and then has the weighter load them.
+func (a *App) fastLane(ids []uint64) {
+	fl := a.l.With().Str("func", "fastLane").Int("hashes", len(ids)).Logger()
+
+	if a.cm != nil {
+		if err := a.cm.PollHashes(ids); err != nil {
+			fl.Err(err).Msg("cmerge")
+			return
+		}
+	}
+
+	if hp, ok := a.we.(types.WeighterHashPoller); ok {
+		if err := hp.PollHashes(ids); err != nil {
+			fl.Err(err).Msg("weighter")
+			return
+		}
+	}
+
+	fl.Debug().Send()
+} // }}}
+
+// func App.SetFeatures {{{
+
+// Replaces the enabled features, see Config.Features.
+//
+// Modules check them each time they would use one, so this takes effect without a restart.
+func (a *App) SetFeatures(flags map[string]bool) error {
+	if err := features.Set(flags); err != nil {
+		return err
+	}
+
+	a.l.Info().Strs("features", features.List()).Msg("Features changed")
+
+	return nil
+} // }}}
+
+// func App.TagManager {{{
+
+func (a *App) TagManager() types.TagManager {
+	return a.tm
+} // }}}
+
+// func App.IDManager {{{
+
+func (a *App) IDManager() types.IDManager {
+	return a.im
+} // }}}
+
+// func App.CacheManager {{{
+
+// Returns nil if not configured.
+func (a *App) CacheManager() *cmanager.CManager {
+	return a.cma
+} // }}}
+
+// func App.ImageProc {{{
+
+// Returns nil if not configured.
+func (a *App) ImageProc() *imgproc.ImageProc {
+	return a.ip
+} // }}}
+
+// func App.CMerge {{{
+
+// Returns nil if not configured.
+func (a *App) CMerge() *cmerge.CMerge {
+	return a.cm
+} // }}}
+
+// func App.Weighter {{{
+
+// Returns nil if not configured.
+func (a *App) Weighter() types.Weighter {
+	return a.we
+} // }}}
+
+// func App.Classify {{{
+
+// Returns nil if not configured.
+func (a *App) Classify() *classify.Classify {
+	return a.cl
+} // }}}
+
+// func App.Faces {{{
+
+// Returns nil if not configured.
+func (a *App) Faces() *faces.Faces {
+	return a.fa
+} // }}}
+
+// func App.Screen {{{
+
+// Returns nil if not configured.
+func (a *App) Screen() *screen.Screen {
+	return a.sc
+} // }}}
+
+// func App.Render {{{
+
+// Returns nil if not configured.
+func (a *App) Render() *render.Render {
+	return a.re
+} // }}}
+
+// func App.API {{{
+
+// Returns nil if not configured.
+func (a *App) API() *api.Server {
+	return a.api
+} // }}}
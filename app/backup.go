@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"frame/internal/scheduler"
+	"frame/yconf"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// type BackupConfig struct {{{
+
+// A hook to run while everything is quiet, so a backup of the database and the image cache are consistent with
+// each other.
+type BackupConfig struct {
+	// The command to run, such as a script calling pg_dump and then rsync of the image cache.
+	//
+	// Split on whitespace and run directly, not through a shell - So use a script for anything more involved.
+	//
+	// Optional - Without it App.Backup() only fails.
+	Command string `yaml:"command"`
+
+	// If set the backup is run every interval, otherwise only when App.Backup() is called.
+	Interval yconf.Duration `yaml:"interval"`
+
+	// How long to wait for any work already running, such as a scan, to finish before giving up on the backup.
+	//
+	// Default if not set is 5 minutes.
+	Wait yconf.Duration `yaml:"wait"`
+
+	// How long the command can run before being killed, with processing resumed.
+	//
+	// Default if not set is 1 hour.
+	Timeout yconf.Duration `yaml:"timeout"`
+} // }}}
+
+// func App.Backup {{{
+
+// Pauses all background work and waits for anything running to finish, runs the backup command, then resumes.
+//
+// Requests such as from Render or the API are still answered throughout, they only read.
+//
+// If we were already paused, such as by the PauseFile, we stay paused afterwards.
+func (a *App) Backup(ctx context.Context) error {
+	fl := a.l.With().Str("func", "Backup").Logger()
+
+	bc := a.co.Backup
+
+	args := strings.Fields(bc.Command)
+	if len(args) == 0 {
+		return errors.New("no backup command configured")
+	}
+
+	wait := time.Duration(bc.Wait)
+	if wait <= 0 {
+		wait = 5 * time.Minute
+	}
+
+	timeout := time.Duration(bc.Timeout)
+	if timeout <= 0 {
+		timeout = time.Hour
+	}
+
+	// Only one backup at a time.
+	a.bMut.Lock()
+	defer a.bMut.Unlock()
+
+	paused := scheduler.Paused()
+
+	wctx, can := context.WithTimeout(ctx, wait)
+	err := scheduler.Quiesce(wctx)
+	can()
+
+	if !paused {
+		defer scheduler.Resume()
+	}
+
+	if err != nil {
+		fl.Err(err).Msg("Quiesce")
+		return fmt.Errorf("waiting on running work: %w", err)
+	}
+
+	fl.Info().Str("command", args[0]).Msg("Running")
+
+	start := time.Now()
+
+	cctx, can := context.WithTimeout(ctx, timeout)
+	defer can()
+
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(cctx, args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = append(os.Environ(), "FRAME_BACKUP_STARTED="+start.Format(time.RFC3339))
+
+	if err := cmd.Run(); err != nil {
+		// Whatever the command had to say about it is generally more useful then the exit status.
+		msg := strings.TrimSpace(out.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+
+		fl.Err(err).Str("output", msg).Msg("Run")
+		return fmt.Errorf("%s: %s: %s", args[0], err, msg)
+	}
+
+	fl.Info().Dur("took", time.Since(start)).Msg("Done")
+
+	return nil
+} // }}}
+
+// func App.tickBackup {{{
+
+// Run by the scheduler when Backup.Interval is set.
+func (a *App) tickBackup() {
+	// Errors are already logged.
+	a.Backup(a.ctx)
+} // }}}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"frame/internal/features"
+	"os"
+	"strings"
+)
+
+// type module struct {{{
+
+// A module within the Config and what it needs loaded along with it.
+type module struct {
+	name string
+
+	// The configuration path, empty if not loaded.
+	path func(co *Config) string
+
+	// Must always be loaded.
+	required bool
+
+	// Not a configuration of its own, so there is nothing to find on disk.
+	setting bool
+
+	needs []string
+} // }}}
+
+// Every module New() can load, in the order it loads them.
+var modules = []module{
+	{name: "tagmanager", path: func(co *Config) string { return co.TagManager }, required: true},
+	{name: "idmanager", path: func(co *Config) string { return co.IDManager }, required: true},
+	{name: "cachemanager", path: func(co *Config) string { return co.CacheManager }, needs: []string{"idmanager"}},
+	{name: "imageproc", path: func(co *Config) string { return co.ImageProc }, needs: []string{"tagmanager", "cachemanager"}},
+	{name: "cachemerge", path: func(co *Config) string { return co.CacheMerge }, needs: []string{"tagmanager"}},
+	{name: "weighter", path: func(co *Config) string { return co.Weighter }, needs: []string{"tagmanager"}},
+	{name: "classify", path: func(co *Config) string { return co.Classify }, needs: []string{"tagmanager", "cachemanager"}},
+	{name: "faces", path: func(co *Config) string { return co.Faces }, needs: []string{"tagmanager", "cachemanager"}},
+	{name: "screen", path: func(co *Config) string { return co.Screen }, needs: []string{"tagmanager", "cachemanager"}},
+	{name: "render", path: func(co *Config) string { return co.Render }, needs: []string{"weighter", "cachemanager"}},
+	{name: "api", path: func(co *Config) string { return co.API }},
+	{name: "scanrequests", path: func(co *Config) string { return co.ScanRequests }, setting: true, needs: []string{"imageproc"}},
+}
+
+// type ConfigError struct {{{
+
+// Returned by Validate(), every problem found with the Config rather then just the first.
+type ConfigError struct {
+	Problems []error
+} // }}}
+
+// func ConfigError.Error {{{
+
+func (ce *ConfigError) Error() string {
+	msgs := make([]string, 0, len(ce.Problems))
+
+	for _, err := range ce.Problems {
+		msgs = append(msgs, err.Error())
+	}
+
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+} // }}}
+
+// func Validate {{{
+
+// Checks the modules requested by the Config can all be loaded together before anything is, returning a *ConfigError
+// listing every problem found.
+//
+// Such as Render without a Weighter, or a module whose configuration does not exist. Otherwise each would only be
+// found once everything before it had loaded, one at a time.
+//
+// Called by New(), but can be called first to report on a Config without loading anything.
+func Validate(co *Config) error {
+	var problems []error
+
+	if co == nil {
+		return errors.New("missing config")
+	}
+
+	loaded := make(map[string]bool, len(modules))
+
+	for _, mod := range modules {
+		path := mod.path(co)
+		if path == "" {
+			if mod.required {
+				problems = append(problems, fmt.Errorf("%s is required", mod.name))
+			}
+
+			continue
+		}
+
+		loaded[mod.name] = true
+
+		for _, need := range mod.needs {
+			if !loaded[need] {
+				problems = append(problems, fmt.Errorf("%s requires %s", mod.name, need))
+			}
+		}
+
+		if mod.setting {
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", mod.name, err))
+		}
+	}
+
+	if err := features.Check(co.Features); err != nil {
+		problems = append(problems, err)
+	}
+
+	if _, err := newDisplay(co.Display); err != nil {
+		problems = append(problems, err)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+} // }}}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	conf := filepath.Join(dir, "conf.yaml")
+	if err := ioutil.WriteFile(conf, []byte("{}"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	co := &Config{TagManager: conf, IDManager: conf, CacheManager: conf, Weighter: conf, Render: conf}
+	if err := Validate(co); err != nil {
+		t.Fatalf("Validate: %s", err)
+	}
+
+	// Every problem at once, not just the first.
+	co = &Config{
+		IDManager:    conf,
+		Render:       conf,
+		ImageProc:    filepath.Join(dir, "missing.yaml"),
+		ScanRequests: dir,
+		Features:     map[string]bool{"nosuchfeature": true},
+	}
+
+	var ce *ConfigError
+
+	if err := Validate(co); !errors.As(err, &ce) {
+		t.Fatalf("Validate Expected *ConfigError != Got %v", err)
+	}
+
+	// tagmanager, imageproc x2 (tagmanager, cachemanager), imageproc missing, render x2, feature.
+	if len(ce.Problems) != 7 {
+		t.Fatalf("Validate Expected 7 problems != Got %d: %s", len(ce.Problems), ce)
+	}
+}
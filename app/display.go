@@ -0,0 +1,381 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"frame/yconf"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// type DisplayConfig struct {{{
+
+// Turns the display itself on and off, by a schedule and/or presence.
+//
+// While the display is off Render is held (see render.Render.Hold()), so nothing is rendered that nobody would see.
+type DisplayConfig struct {
+	// How to turn the display on and off -
+	//
+	//  cec     - HDMI-CEC through cec-client (from libcec), the display being CECAddress.
+	//  gpio    - A GPIO pin through /sys/class/gpio, such as for a relay. High is on, unless GPIOActiveLow.
+	//  command - Runs OnCommand or OffCommand.
+	//
+	// Optional - If empty there is no display control at all.
+	Method string `yaml:"method"`
+
+	// The CEC logical address of the display, default if not set is 0 (the TV).
+	CECAddress string `yaml:"cecaddress"`
+
+	GPIOPin       int  `yaml:"gpiopin"`
+	GPIOActiveLow bool `yaml:"gpioactivelow"`
+
+	// Split on whitespace and run directly, not through a shell.
+	OnCommand  string `yaml:"oncommand"`
+	OffCommand string `yaml:"offcommand"`
+
+	// The local time of day such as "07:00" and "23:00" to turn the display on and off, wrapping past midnight when
+	// OffAt is the earlier.
+	//
+	// Generally the same as the quiet hours of Render, just the other way around. Both or neither must be set.
+	OnAt  string `yaml:"onat"`
+	OffAt string `yaml:"offat"`
+
+	// Listens for a presence webhook, such as from a motion sensor or home automation, on this address.
+	//
+	// Any POST to /presence keeps the display on for PresenceTimeout (default 15 minutes), after which it is
+	// turned off until the next. Combined with OnAt and OffAt the display is only on when both agree, so it stays
+	// off overnight no matter the presence.
+	PresenceListen  string         `yaml:"presencelisten"`
+	PresenceTimeout yconf.Duration `yaml:"presencetimeout"`
+} // }}}
+
+// What the display was last set to.
+const (
+	displayUnknown = iota
+	displayOn
+	displayOff
+)
+
+// type display struct {{{
+
+type display struct {
+	co DisplayConfig
+
+	// Minutes after midnight, local time, for OnAt and OffAt. Both -1 if there is no schedule.
+	onAt  int
+	offAt int
+
+	// Protects everything below.
+	mut sync.Mutex
+
+	state int
+
+	// When presence was last seen.
+	seen time.Time
+
+	srv *http.Server
+} // }}}
+
+// func newDisplay {{{
+
+// Checks the configuration, returning nil if there is no display control.
+func newDisplay(co DisplayConfig) (*display, error) {
+	var err error
+
+	if co.Method == "" {
+		return nil, nil
+	}
+
+	d := &display{
+		co:    co,
+		onAt:  -1,
+		offAt: -1,
+	}
+
+	switch co.Method {
+	case "cec":
+		if d.co.CECAddress == "" {
+			d.co.CECAddress = "0"
+		}
+	case "gpio":
+		if co.GPIOPin < 0 {
+			return nil, errors.New("display gpiopin can not be negative")
+		}
+	case "command":
+		if len(strings.Fields(co.OnCommand)) == 0 || len(strings.Fields(co.OffCommand)) == 0 {
+			return nil, errors.New("display oncommand and offcommand are both required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown display method %q", co.Method)
+	}
+
+	if co.OnAt != "" || co.OffAt != "" {
+		if d.onAt, err = parseClock(co.OnAt); err != nil {
+			return nil, errors.New("invalid display onat")
+		}
+
+		if d.offAt, err = parseClock(co.OffAt); err != nil {
+			return nil, errors.New("invalid display offat")
+		}
+
+		if d.onAt == d.offAt {
+			return nil, errors.New("display onat and offat are the same")
+		}
+	}
+
+	if co.PresenceListen == "" && d.onAt == -1 {
+		return nil, errors.New("display needs either onat and offat, or presencelisten")
+	}
+
+	if d.co.PresenceTimeout <= 0 {
+		d.co.PresenceTimeout = yconf.Duration(15 * time.Minute)
+	}
+
+	return d, nil
+} // }}}
+
+// func parseClock {{{
+
+// Parses a time of day such as "23:00", returning the minutes after midnight.
+func parseClock(in string) (int, error) {
+	t, err := time.Parse("15:04", in)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+} // }}}
+
+// func display.wanted {{{
+
+// Returns if the display should be on at now, with presence last seen at seen.
+func (d *display) wanted(now, seen time.Time) bool {
+	if d.onAt != -1 {
+		m := now.Hour()*60 + now.Minute()
+
+		var on bool
+
+		// Wraps past midnight?
+		if d.onAt > d.offAt {
+			on = m >= d.onAt || m < d.offAt
+		} else {
+			on = m >= d.onAt && m < d.offAt
+		}
+
+		if !on {
+			return false
+		}
+	}
+
+	if d.co.PresenceListen != "" {
+		return now.Sub(seen) < time.Duration(d.co.PresenceTimeout)
+	}
+
+	return true
+} // }}}
+
+// func App.startDisplay {{{
+
+// Sets the display as it should be now, then keeps it that way every minute and from any presence.
+func (a *App) startDisplay() error {
+	fl := a.l.With().Str("func", "startDisplay").Logger()
+
+	d := a.disp
+
+	a.checkDisplay()
+
+	if err := a.sch.Add("display", time.Minute, a.checkDisplay); err != nil {
+		fl.Err(err).Msg("Add")
+		return err
+	}
+
+	if d.co.PresenceListen == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/presence", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		a.Presence()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	d.srv = &http.Server{
+		Addr:              d.co.PresenceListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := d.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fl.Err(err).Msg("ListenAndServe")
+		}
+	}()
+
+	go func() {
+		<-a.ctx.Done()
+
+		ctx, can := context.WithTimeout(context.Background(), time.Second)
+		defer can()
+
+		d.srv.Shutdown(ctx)
+	}()
+
+	return nil
+} // }}}
+
+// func App.Presence {{{
+
+// Records that someone is present, turning the display on if it should now be.
+//
+// Called by the presence webhook, see DisplayConfig.PresenceListen. Does nothing without display control.
+func (a *App) Presence() {
+	if a.disp == nil {
+		return
+	}
+
+	a.disp.mut.Lock()
+	a.disp.seen = time.Now()
+	a.disp.mut.Unlock()
+
+	a.checkDisplay()
+} // }}}
+
+// func App.DisplayOn {{{
+
+// Returns true unless the display was turned off, always true without display control.
+func (a *App) DisplayOn() bool {
+	if a.disp == nil {
+		return true
+	}
+
+	a.disp.mut.Lock()
+	defer a.disp.mut.Unlock()
+
+	return a.disp.state != displayOff
+} // }}}
+
+// func App.checkDisplay {{{
+
+// Turns the display on or off if it is not already as it should be.
+//
+// Should that fail it is tried again next time, the state is only changed once it works.
+func (a *App) checkDisplay() {
+	d := a.disp
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	state := displayOff
+	if d.wanted(time.Now(), d.seen) {
+		state = displayOn
+	}
+
+	if state == d.state {
+		return
+	}
+
+	fl := a.l.With().Str("func", "checkDisplay").Bool("on", state == displayOn).Logger()
+
+	if err := d.set(a.ctx, state == displayOn); err != nil {
+		fl.Err(err).Msg("set")
+		return
+	}
+
+	d.state = state
+
+	if a.re != nil {
+		a.re.Hold(state == displayOff)
+	}
+
+	fl.Info().Msg("Display")
+} // }}}
+
+// func display.set {{{
+
+func (d *display) set(ctx context.Context, on bool) error {
+	ctx, can := context.WithTimeout(ctx, 30*time.Second)
+	defer can()
+
+	switch d.co.Method {
+	case "cec":
+		cmd := "standby " + d.co.CECAddress
+		if on {
+			cmd = "on " + d.co.CECAddress
+		}
+
+		// Single command mode, reading the command from stdin.
+		return runDisplay(ctx, []string{"cec-client", "-s", "-d", "1"}, cmd+"\n")
+	case "gpio":
+		return setGPIO(d.co.GPIOPin, on != d.co.GPIOActiveLow)
+	case "command":
+		args := strings.Fields(d.co.OffCommand)
+		if on {
+			args = strings.Fields(d.co.OnCommand)
+		}
+
+		return runDisplay(ctx, args, "")
+	}
+
+	return fmt.Errorf("unknown display method %q", d.co.Method)
+} // }}}
+
+// func runDisplay {{{
+
+func runDisplay(ctx context.Context, args []string, stdin string) error {
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		// Whatever the command had to say about it is generally more useful then the exit status.
+		msg := strings.TrimSpace(out.String())
+		if len(msg) > 200 {
+			msg = msg[len(msg)-200:]
+		}
+
+		return fmt.Errorf("%s: %s: %s", args[0], err, msg)
+	}
+
+	return nil
+} // }}}
+
+// func setGPIO {{{
+
+// Sets a GPIO pin through the sysfs interface, exporting it as an output first if needed.
+func setGPIO(pin int, high bool) error {
+	dir := filepath.Join("/sys/class/gpio", "gpio"+strconv.Itoa(pin))
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := ioutil.WriteFile("/sys/class/gpio/export", []byte(strconv.Itoa(pin)), 0200); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, "direction"), []byte("out"), 0200); err != nil {
+			return err
+		}
+	}
+
+	val := "0"
+	if high {
+		val = "1"
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "value"), []byte(val), 0200)
+} // }}}
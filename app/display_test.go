@@ -0,0 +1,76 @@
+package app
+
+import (
+	"frame/yconf"
+	"testing"
+	"time"
+)
+
+func TestDisplayWanted(t *testing.T) {
+	at := func(clock string) time.Time {
+		c, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return time.Date(2021, 6, 1, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+
+	// On from 07:00 until 23:00.
+	d, err := newDisplay(DisplayConfig{Method: "command", OnCommand: "on", OffCommand: "off", OnAt: "07:00", OffAt: "23:00"})
+	if err != nil {
+		t.Fatalf("newDisplay: %s", err)
+	}
+
+	tests := []struct {
+		Clock    string
+		Expected bool
+	}{
+		{"06:59", false},
+		{"07:00", true},
+		{"22:59", true},
+		{"23:00", false},
+		{"02:00", false},
+	}
+
+	for _, test := range tests {
+		if got := d.wanted(at(test.Clock), time.Time{}); got != test.Expected {
+			t.Errorf("schedule %s Expected %v != Got %v", test.Clock, test.Expected, got)
+		}
+	}
+
+	// Overnight, wrapping past midnight, along with presence.
+	d, err = newDisplay(DisplayConfig{Method: "cec", OnAt: "22:00", OffAt: "06:00", PresenceListen: ":0", PresenceTimeout: yconf.Duration(10 * time.Minute)})
+	if err != nil {
+		t.Fatalf("newDisplay: %s", err)
+	}
+
+	now := at("23:30")
+
+	if !d.wanted(now, now.Add(-5*time.Minute)) {
+		t.Errorf("present within the schedule should be on")
+	}
+
+	if d.wanted(now, now.Add(-15*time.Minute)) {
+		t.Errorf("presence timed out should be off")
+	}
+
+	if noon := at("12:00"); d.wanted(noon, noon) {
+		t.Errorf("present outside the schedule should be off")
+	}
+
+	// Misconfigurations.
+	bad := []DisplayConfig{
+		{Method: "hdmi", OnAt: "07:00", OffAt: "23:00"},
+		{Method: "cec"},
+		{Method: "cec", OnAt: "07:00"},
+		{Method: "cec", OnAt: "07:00", OffAt: "07:00"},
+		{Method: "command", OnCommand: "on", OnAt: "07:00", OffAt: "23:00"},
+	}
+
+	for _, co := range bad {
+		if _, err := newDisplay(co); err == nil {
+			t.Errorf("newDisplay accepted %+v", co)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frame/internal/imgproc"
+	"frame/internal/scheduler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// type ScanRequest struct {{{
+
+// A request for ImageProc to scan a base (or just a path within it) now, see RequestScan().
+type ScanRequest struct {
+	Base int `json:"base"`
+
+	// Only this path and everything below it, see ImageProc.ScanPath().
+	Path string `json:"path,omitempty"`
+
+	// A full scan of the base rather then a partial, ignored with Path.
+	Full bool `json:"full,omitempty"`
+} // }}}
+
+// func RequestScan {{{
+
+// Writes the request to dir (the ScanRequests of a running App), for it to pick up within 10 seconds.
+//
+// Used by "frame scan", as the App is within another process.
+func RequestScan(dir string, sr ScanRequest) error {
+	if sr.Base == 0 {
+		return errors.New("missing base")
+	}
+
+	data, err := json.Marshal(sr)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	// Renamed once written, so a half written request is never read.
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name+".json")
+} // }}}
+
+// func App.checkScanRequests {{{
+
+// Starts the scans requested within the ScanRequests directory, oldest first.
+//
+// Each request is removed once started (or if it can never be). A base already being scanned keeps its requests
+// for the next check, as does everything while paused.
+func (a *App) checkScanRequests() {
+	fl := a.l.With().Str("func", "checkScanRequests").Logger()
+
+	if scheduler.Paused() {
+		return
+	}
+
+	dir := a.co.ScanRequests
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fl.Err(err).Msg("ReadDir")
+		return
+	}
+
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".json") {
+			names = append(names, fi.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+
+		err := a.startScan(file)
+		if errors.Is(err, imgproc.ErrScanRunning) {
+			continue
+		}
+
+		if err != nil {
+			fl.Err(err).Str("file", name).Msg("startScan")
+		}
+
+		if err := os.Remove(file); err != nil {
+			fl.Err(err).Str("file", name).Msg("Remove")
+		}
+	}
+} // }}}
+
+// func App.startScan {{{
+
+func (a *App) startScan(file string) error {
+	var sr ScanRequest
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &sr); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	if sr.Path != "" {
+		err = a.ip.ScanPath(sr.Base, sr.Path)
+	} else {
+		err = a.ip.ScanBase(sr.Base, sr.Full)
+	}
+
+	if err == nil {
+		a.l.Info().Int("base", sr.Base).Str("path", sr.Path).Bool("full", sr.Full).Msg("Scan requested")
+	}
+
+	return err
+} // }}}
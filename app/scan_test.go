@@ -0,0 +1,47 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestScan(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := RequestScan(dir, ScanRequest{}); err == nil {
+		t.Fatalf("RequestScan accepted a request without a base")
+	}
+
+	expected := ScanRequest{Base: 2, Path: "2019/beach"}
+
+	if err := RequestScan(dir, expected); err != nil {
+		t.Fatalf("RequestScan: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+
+	// Only the request itself, nothing temporary left behind.
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), ".json") {
+		t.Fatalf("RequestScan Expected 1 .json file != Got %d", len(files))
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+
+	var got ScanRequest
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+
+	if got != expected {
+		t.Fatalf("RequestScan Expected %+v != Got %+v", expected, got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"frame/app"
+	"frame/internal/cmerge"
+	"frame/internal/tagmanager"
+	"frame/internal/weighter"
+	"frame/tags"
+	"frame/yconf"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// func configCheck {{{
+
+// Handles "frame config-check", loading the TagManager and Weighter and listing every tag the profiles or TagRules
+// refer to that no image has (such as from a typo), and profiles wanting tags the CacheMerge BlockTags block. Returns
+// 1 if there are any.
+//
+// Anything app.Validate() finds, such as unknown features or Render without a Weighter, is an error before anything
+// is loaded.
+//
+// CacheMerge checks its TagRules and BlockTags the same way, but only logs them when running as it writes to the
+// merged table while loading. Only its configuration is loaded here.
+func configCheck(args []string) int {
+	fs := flag.NewFlagSet("config-check", flag.ExitOnError)
+	conf := fs.String("conf", "", "The main configuration, the same as given to frame -conf")
+	fs.Parse(args)
+
+	if *conf == "" {
+		fmt.Fprintln(os.Stderr, "config-check: -conf is required")
+		return 1
+	}
+
+	// The Weighter logs the same warnings we print, so only errors.
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger().Level(zerolog.ErrorLevel)
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+
+	yc, err := yconf.New(*conf, pathsConf, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-check: %s\n", err)
+		return 1
+	}
+
+	if err = yc.CheckConf(); err != nil {
+		fmt.Fprintf(os.Stderr, "config-check: %s\n", err)
+		return 1
+	}
+
+	co, ok := yc.Get().(*confFile)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "config-check: invalid config loaded")
+		return 1
+	}
+
+	// Includes the features.
+	if err = app.Validate(&co.Config); err != nil {
+		writeConfigError(os.Stderr, err)
+		return 1
+	}
+
+	if co.Weighter == "" {
+		fmt.Fprintln(os.Stderr, "config-check: no weighter configured, nothing to check")
+		return 1
+	}
+
+	tm, err := tagmanager.New(co.TagManager, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-check: tagmanager: %s\n", err)
+		return 1
+	}
+
+	// Loads every image and makes the profiles, which is when the tags are checked.
+	we, err := weighter.New(co.Weighter, tm, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-check: weighter: %s\n", err)
+		return 1
+	}
+
+	if co.CacheMerge != "" {
+		block, err := cmerge.LoadBlockTags(co.CacheMerge, tm, &l, ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config-check: cachemerge: %s\n", err)
+			return 1
+		}
+
+		we.SetBlockTags(func() tags.Tags { return block })
+	}
+
+	warnings := we.TagWarnings()
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+
+	if len(warnings) > 0 {
+		fmt.Printf("%d tags not on any image\n", len(warnings))
+		return 1
+	}
+
+	fmt.Println("ok")
+
+	return 0
+} // }}}
+
+// func writeConfigError {{{
+
+// Writes each problem app.Validate() found on its own line.
+func writeConfigError(w io.Writer, err error) {
+	var ce *app.ConfigError
+
+	if !errors.As(err, &ce) {
+		fmt.Fprintf(w, "configuration: %s\n", err)
+		return
+	}
+
+	fmt.Fprintf(w, "configuration has %d problems:\n", len(ce.Problems))
+
+	for _, p := range ce.Problems {
+		fmt.Fprintf(w, "\t%s\n", p)
+	}
+} // }}}
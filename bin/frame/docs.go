@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"frame/internal/confdoc"
+	"os"
+)
+
+// func configDocs {{{
+
+// Handles "frame config-docs", writing the configuration reference for every module to STDOUT.
+//
+// This reads the source, so needs to be run from within the source tree or be given -src.
+func configDocs(args []string) int {
+	fs := flag.NewFlagSet("config-docs", flag.ExitOnError)
+	src := fs.String("src", ".", "Source root, the directory containing go.mod")
+	format := fs.String("format", "md", "Output format, md for the markdown reference or yaml for a commented example configuration")
+	fs.Parse(args)
+
+	g, err := confdoc.New(*src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-docs: %s\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "md":
+		err = g.Markdown(os.Stdout)
+	case "yaml":
+		err = g.YAML(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "config-docs: unknown format %q\n", *format)
+		return 1
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-docs: %s\n", err)
+		return 1
+	}
+
+	return 0
+} // }}}
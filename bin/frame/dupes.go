@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"frame/internal/secrets"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The default query for "frame dupes", see the files.duplicates view in sql/table.sql.
+//
+// Must return the hash, how many copies, the locations and the tag names.
+const dupesQuery = `SELECT hash, copies, locations, tags.get_tagnames(tags) FROM files.duplicates ORDER BY copies DESC, hash`
+
+// type dupe struct {{{
+
+type dupe struct {
+	Hash      string
+	Copies    int64
+	Locations []string
+	Tags      []string
+} // }}}
+
+// func dupes {{{
+
+// Handles "frame dupes", listing every image found more then once along with where and the combined tags.
+func dupes(args []string) int {
+	fs := flag.NewFlagSet("dupes", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN, the same as the imageproc database (secret references are allowed)")
+	query := fs.String("query", dupesQuery, "Query returning the hash, copies, locations and tag names of each duplicate")
+	fs.Parse(args)
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "dupes: -db is required")
+		return 1
+	}
+
+	dsn, err := secrets.Resolve(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dupes: %s\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dupes: %s\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	list, err := loadDupes(ctx, pool, *query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dupes: %s\n", err)
+		return 1
+	}
+
+	writeDupes(os.Stdout, list)
+
+	return 0
+} // }}}
+
+// func loadDupes {{{
+
+func loadDupes(ctx context.Context, pool *pgxpool.Pool, query string) ([]dupe, error) {
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []dupe
+
+	for rows.Next() {
+		var d dupe
+
+		if err := rows.Scan(&d.Hash, &d.Copies, &d.Locations, &d.Tags); err != nil {
+			return nil, err
+		}
+
+		list = append(list, d)
+	}
+
+	return list, rows.Err()
+} // }}}
+
+// func writeDupes {{{
+
+// Writes the report, each hash followed by its combined tags and the location of each copy.
+func writeDupes(w io.Writer, list []dupe) {
+	var copies int64
+
+	for _, d := range list {
+		fmt.Fprintf(w, "%s (%d copies)\n", d.Hash, d.Copies)
+		fmt.Fprintf(w, "\ttags: %s\n", strings.Join(d.Tags, ", "))
+
+		for _, loc := range d.Locations {
+			fmt.Fprintf(w, "\t%s\n", loc)
+		}
+
+		fmt.Fprintln(w)
+
+		// The first copy is the one being kept, anything after is redundant.
+		copies += d.Copies - 1
+	}
+
+	fmt.Fprintf(w, "%d duplicated images, %d redundant copies\n", len(list), copies)
+} // }}}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"frame/internal/secrets"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The archive written by "frame export" is a gzipped tar holding libName first, followed by the image cache
+// under libCache if it was included.
+//
+// libName is NDJSON, one libLine per line. The header first, then every base, path, file and merged row in
+// that order so an import can stream it.
+//
+// Database IDs are not portable so tags are by name and images by hash, only the base IDs are kept as the
+// configuration refers to them. The cache files are named by hash so are portable as-is.
+const (
+	libName    = "library.ndjson"
+	libCache   = "cache/"
+	libVersion = 1
+)
+
+// type libLine struct {{{
+
+// Only one of these is set on each line.
+type libLine struct {
+	Header *libHeader `json:"header,omitempty"`
+	Base   *libBase   `json:"base,omitempty"`
+	Path   *libPath   `json:"path,omitempty"`
+	File   *libFile   `json:"file,omitempty"`
+	Merged *libMerged `json:"merged,omitempty"`
+} // }}}
+
+// type libHeader struct {{{
+
+type libHeader struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+} // }}}
+
+// type libBase struct {{{
+
+type libBase struct {
+	Base        int64  `json:"base"`
+	Description string `json:"description"`
+} // }}}
+
+// type libPath struct {{{
+
+type libPath struct {
+	Base    int64     `json:"base"`
+	Name    string    `json:"name"`
+	PathTS  time.Time `json:"pathts"`
+	SideTS  time.Time `json:"sidets"`
+	Tags    []string  `json:"tags,omitempty"`
+	Enabled bool      `json:"enabled"`
+} // }}}
+
+// type libFile struct {{{
+
+type libFile struct {
+	Base     int64     `json:"base"`
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Hash     string    `json:"hash"`
+	FileTS   time.Time `json:"filets"`
+	SideTS   time.Time `json:"sidets"`
+	SideTags []string  `json:"sidetags,omitempty"`
+	Tags     []string  `json:"tags"`
+	Enabled  bool      `json:"enabled"`
+} // }}}
+
+// type libMerged struct {{{
+
+type libMerged struct {
+	Hash    string   `json:"hash"`
+	Tags    []string `json:"tags"`
+	Blocked bool     `json:"blocked"`
+	Enabled bool     `json:"enabled"`
+} // }}}
+
+// func export {{{
+
+// Handles "frame export", writing the whole library, and optionally the image cache, to an archive.
+//
+// Nothing is locked, so best done while frame is not running (or paused) to get a consistent archive.
+func export(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN (secret references are allowed)")
+	out := fs.String("out", "", "The archive to write")
+	cache := fs.String("cache", "", "Optional, the imagecache directory to include in the archive")
+	fs.Parse(args)
+
+	if *db == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "export: -db and -out are required")
+		return 1
+	}
+
+	if err := exportLib(*db, *out, *cache); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %s\n", err)
+		return 1
+	}
+
+	return 0
+} // }}}
+
+// func importLib {{{
+
+// Handles "frame import", loading an archive written by "frame export" into the database, and the cache
+// files (if any) into the imagecache directory.
+//
+// Rows already in the database are updated from the archive, so an import can be safely run again.
+func importLib(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN (secret references are allowed)")
+	in := fs.String("in", "", "The archive to read")
+	cache := fs.String("cache", "", "Optional, the imagecache directory to extract any cache files into")
+	fs.Parse(args)
+
+	if *db == "" || *in == "" {
+		fmt.Fprintln(os.Stderr, "import: -db and -in are required")
+		return 1
+	}
+
+	if err := readLib(*db, *in, *cache); err != nil {
+		fmt.Fprintf(os.Stderr, "import: %s\n", err)
+		return 1
+	}
+
+	return 0
+} // }}}
+
+// func libConnect {{{
+
+func libConnect(ctx context.Context, db string) (*pgxpool.Pool, error) {
+	dsn, err := secrets.Resolve(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgxpool.Connect(ctx, dsn)
+} // }}}
+
+// func exportLib {{{
+
+func exportLib(db, out, cache string) error {
+	ctx := context.Background()
+
+	pool, err := libConnect(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	// The tar header needs the size up front, so the library is written to a temporary file first.
+	tmp, err := ioutil.TempFile("", "frame-export-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bw := bufio.NewWriter(tmp)
+
+	if err := writeLib(ctx, pool, json.NewEncoder(bw)); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	f, err := os.Create(out + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	if err := tarFile(tw, tmp.Name(), libName); err != nil {
+		return err
+	}
+
+	if cache != "" {
+		err = filepath.Walk(cache, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+
+			rel, err := filepath.Rel(cache, path)
+			if err != nil {
+				return err
+			}
+
+			return tarFile(tw, path, libCache+filepath.ToSlash(rel))
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out+".tmp", out)
+} // }}}
+
+// func tarFile {{{
+
+func tarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+} // }}}
+
+// func writeLib {{{
+
+// Writes every libLine, see libName.
+func writeLib(ctx context.Context, pool *pgxpool.Pool, enc *json.Encoder) error {
+	if err := enc.Encode(libLine{Header: &libHeader{Version: libVersion, Created: time.Now()}}); err != nil {
+		return err
+	}
+
+	rows, err := pool.Query(ctx, `SELECT bid, description FROM files.base ORDER BY bid`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var lb libBase
+
+		if err := rows.Scan(&lb.Base, &lb.Description); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := enc.Encode(libLine{Base: &lb}); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = pool.Query(ctx, `SELECT bid, name, pathts, sidets, tags.get_tagnames(tags), enabled FROM files.paths ORDER BY bid, name`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var lp libPath
+
+		if err := rows.Scan(&lp.Base, &lp.Name, &lp.PathTS, &lp.SideTS, &lp.Tags, &lp.Enabled); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := enc.Encode(libLine{Path: &lp}); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = pool.Query(ctx, `SELECT p.bid, p.name, f.name, h.hash, f.filets, f.sidets, tags.get_tagnames(f.sidetags), tags.get_tagnames(f.tags), f.enabled
+		FROM files.files f JOIN files.paths p ON p.pid = f.pid JOIN files.hashes h ON h.hid = f.hid ORDER BY p.bid, p.name, f.name`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var lf libFile
+
+		if err := rows.Scan(&lf.Base, &lf.Path, &lf.Name, &lf.Hash, &lf.FileTS, &lf.SideTS, &lf.SideTags, &lf.Tags, &lf.Enabled); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := enc.Encode(libLine{File: &lf}); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = pool.Query(ctx, `SELECT h.hash, tags.get_tagnames(m.tags), m.blocked, m.enabled FROM files.merged m JOIN files.hashes h ON h.hid = m.hid ORDER BY h.hash`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var lm libMerged
+
+		if err := rows.Scan(&lm.Hash, &lm.Tags, &lm.Blocked, &lm.Enabled); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := enc.Encode(libLine{Merged: &lm}); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	rows.Close()
+
+	return rows.Err()
+} // }}}
+
+// type libImport struct {{{
+
+// The IDs looked up while importing, so each is only looked up once.
+type libImport struct {
+	tx pgx.Tx
+
+	tags   map[string]int64
+	hashes map[string]int64
+
+	// The pid of each path, by base then name.
+	paths map[int64]map[string]int64
+
+	// How many of each were imported.
+	bases, npaths, files, merged int
+} // }}}
+
+// func readLib {{{
+
+func readLib(db, in, cache string) error {
+	ctx := context.Background()
+
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+
+	if hdr.Name != libName {
+		return fmt.Errorf("%s does not start with %s, not an export?", in, libName)
+	}
+
+	pool, err := libConnect(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	// All or nothing, rather then leaving a half imported library.
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	li := &libImport{
+		tx:     tx,
+		tags:   make(map[string]int64),
+		hashes: make(map[string]int64),
+		paths:  make(map[int64]map[string]int64),
+	}
+
+	if err := li.read(ctx, tr); err != nil {
+		return err
+	}
+
+	// The base IDs were inserted as-is, so move the sequence past them.
+	if _, err := tx.Exec(ctx, `SELECT setval('files.base_bid_seq', (SELECT max(bid) FROM files.base))`); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d bases, %d paths, %d files, %d merged\n", li.bases, li.npaths, li.files, li.merged)
+
+	// Now the cache, if wanted.
+	if cache == "" {
+		return nil
+	}
+
+	var count int
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(hdr.Name, libCache) {
+			continue
+		}
+
+		// Never anything outside of the cache directory.
+		rel := filepath.FromSlash(strings.TrimPrefix(hdr.Name, libCache))
+		if rel = filepath.Clean(rel); rel == "." || filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("invalid cache file %q", hdr.Name)
+		}
+
+		if err := extractFile(tr, filepath.Join(cache, rel), hdr.ModTime); err != nil {
+			return err
+		}
+
+		count++
+	}
+
+	fmt.Printf("imported %d cache files\n", count)
+
+	return nil
+} // }}}
+
+// func extractFile {{{
+
+func extractFile(r io.Reader, path string, mtime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	os.Chtimes(path+".tmp", mtime, mtime)
+
+	return os.Rename(path+".tmp", path)
+} // }}}
+
+// func libImport.read {{{
+
+func (li *libImport) read(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	var line libLine
+
+	if err := dec.Decode(&line); err != nil {
+		return err
+	}
+
+	if line.Header == nil || line.Header.Version != libVersion {
+		return errors.New("unsupported export version")
+	}
+
+	for {
+		line = libLine{}
+
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line.Base != nil:
+			err = li.base(ctx, line.Base)
+		case line.Path != nil:
+			err = li.path(ctx, line.Path)
+		case line.File != nil:
+			err = li.file(ctx, line.File)
+		case line.Merged != nil:
+			err = li.merge(ctx, line.Merged)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+} // }}}
+
+// func libImport.base {{{
+
+func (li *libImport) base(ctx context.Context, lb *libBase) error {
+	_, err := li.tx.Exec(ctx, `INSERT INTO files.base (bid, description) VALUES ($1, $2) ON CONFLICT (bid) DO UPDATE SET description = $2`, lb.Base, lb.Description)
+	if err != nil {
+		return fmt.Errorf("base %d: %w", lb.Base, err)
+	}
+
+	li.bases++
+
+	return nil
+} // }}}
+
+// func libImport.path {{{
+
+func (li *libImport) path(ctx context.Context, lp *libPath) error {
+	var pid int64
+
+	tgs, err := li.tagIDs(ctx, lp.Tags)
+	if err != nil {
+		return err
+	}
+
+	err = li.tx.QueryRow(ctx, `INSERT INTO files.paths (bid, name, pathts, sidets, tags, enabled) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (bid, name) DO UPDATE SET pathts = $3, sidets = $4, tags = $5, enabled = $6 RETURNING pid`,
+		lp.Base, lp.Name, lp.PathTS, lp.SideTS, tgs, lp.Enabled).Scan(&pid)
+	if err != nil {
+		return fmt.Errorf("path %d:%s: %w", lp.Base, lp.Name, err)
+	}
+
+	if li.paths[lp.Base] == nil {
+		li.paths[lp.Base] = make(map[string]int64)
+	}
+
+	li.paths[lp.Base][lp.Name] = pid
+	li.npaths++
+
+	return nil
+} // }}}
+
+// func libImport.file {{{
+
+func (li *libImport) file(ctx context.Context, lf *libFile) error {
+	pid, ok := li.paths[lf.Base][lf.Path]
+	if !ok {
+		return fmt.Errorf("file %d:%s/%s: path not in export", lf.Base, lf.Path, lf.Name)
+	}
+
+	hid, err := li.hashID(ctx, lf.Hash)
+	if err != nil {
+		return err
+	}
+
+	side, err := li.tagIDs(ctx, lf.SideTags)
+	if err != nil {
+		return err
+	}
+
+	tgs, err := li.tagIDs(ctx, lf.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = li.tx.Exec(ctx, `INSERT INTO files.files (pid, name, hid, filets, sidets, sidetags, tags, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (pid, name) DO UPDATE SET hid = $3, filets = $4, sidets = $5, sidetags = $6, tags = $7, enabled = $8`,
+		pid, lf.Name, hid, lf.FileTS, lf.SideTS, side, tgs, lf.Enabled)
+	if err != nil {
+		return fmt.Errorf("file %d:%s/%s: %w", lf.Base, lf.Path, lf.Name, err)
+	}
+
+	li.files++
+
+	return nil
+} // }}}
+
+// func libImport.merge {{{
+
+func (li *libImport) merge(ctx context.Context, lm *libMerged) error {
+	hid, err := li.hashID(ctx, lm.Hash)
+	if err != nil {
+		return err
+	}
+
+	tgs, err := li.tagIDs(ctx, lm.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = li.tx.Exec(ctx, `INSERT INTO files.merged (hid, tags, blocked, enabled) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (hid) DO UPDATE SET tags = $2, blocked = $3, enabled = $4`, hid, tgs, lm.Blocked, lm.Enabled)
+	if err != nil {
+		return fmt.Errorf("merged %s: %w", lm.Hash, err)
+	}
+
+	li.merged++
+
+	return nil
+} // }}}
+
+// func libImport.tagIDs {{{
+
+// Returns the IDs for the tag names, creating any that do not yet exist.
+//
+// No names returns nil, a NULL for the nullable columns.
+func (li *libImport) tagIDs(ctx context.Context, names []string) ([]int64, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(names))
+
+	for _, name := range names {
+		id, ok := li.tags[name]
+		if !ok {
+			if err := li.tx.QueryRow(ctx, `SELECT tags.get_tagid($1)`, name).Scan(&id); err != nil {
+				return nil, fmt.Errorf("tag %q: %w", name, err)
+			}
+
+			li.tags[name] = id
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+} // }}}
+
+// func libImport.hashID {{{
+
+func (li *libImport) hashID(ctx context.Context, hash string) (int64, error) {
+	if id, ok := li.hashes[hash]; ok {
+		return id, nil
+	}
+
+	var id int64
+
+	if err := li.tx.QueryRow(ctx, `SELECT files.get_hashid($1)`, hash).Scan(&id); err != nil {
+		return 0, fmt.Errorf("hash %s: %w", hash, err)
+	}
+
+	li.hashes[hash] = id
+
+	return id, nil
+} // }}}
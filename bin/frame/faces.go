@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"frame/internal/secrets"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Every face cluster, largest first, along with the hash of its first face so it can be looked at.
+const facesListQuery = `SELECT c.fcid, c.label, c.faces, COALESCE((SELECT h.hash FROM files.faces f JOIN files.hashes h ON h.hid = f.hid WHERE f.fcid = c.fcid ORDER BY f.faid LIMIT 1), '')
+	FROM files.faceclusters c WHERE c.faces >= $1 ORDER BY c.faces DESC, c.fcid`
+
+const facesLabelQuery = `UPDATE files.faceclusters SET label = $2 WHERE fcid = $1`
+
+// type faceCluster struct {{{
+
+type faceCluster struct {
+	ID    uint64
+	Label string
+	Faces int
+	Hash  string
+} // }}}
+
+// func faces {{{
+
+// Handles "frame faces list|label", listing the face clusters found by the faces module or giving one a label.
+//
+// Every hash with a face in a labeled cluster is tagged the next time the faces module runs. Give several clusters the
+// same label if the same person was split over them, or an empty label (-clear) to stop tagging a cluster.
+func faces(args []string) int {
+	if len(args) < 1 || (args[0] != "list" && args[0] != "label") {
+		fmt.Fprintln(os.Stderr, "faces: list or label is required")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("faces "+args[0], flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN, the same as the faces database (secret references are allowed)")
+	min := fs.Int("min", 1, "Only list clusters with at least this many faces")
+	unlabeled := fs.Bool("unlabeled", false, "Only list clusters without a label")
+	id := fs.Uint64("cluster", 0, "The cluster to label")
+	clear := fs.Bool("clear", false, "Remove the label of the cluster")
+	fs.Parse(args[1:])
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "faces: -db is required")
+		return 1
+	}
+
+	label := strings.TrimSpace(strings.Join(fs.Args(), " "))
+
+	if args[0] == "label" && (*id == 0 || (label == "") == !*clear) {
+		fmt.Fprintln(os.Stderr, "faces: label needs -cluster and either a label or -clear")
+		return 1
+	}
+
+	dsn, err := secrets.Resolve(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "faces: %s\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "faces: %s\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	if args[0] == "label" {
+		tag, err := pool.Exec(ctx, facesLabelQuery, *id, label)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "faces: %s\n", err)
+			return 1
+		}
+
+		if tag.RowsAffected() == 0 {
+			fmt.Fprintf(os.Stderr, "faces: no cluster %d\n", *id)
+			return 1
+		}
+
+		return 0
+	}
+
+	list, err := loadFaceClusters(ctx, pool, *min)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "faces: %s\n", err)
+		return 1
+	}
+
+	writeFaceClusters(os.Stdout, list, *unlabeled)
+
+	return 0
+} // }}}
+
+// func loadFaceClusters {{{
+
+func loadFaceClusters(ctx context.Context, pool *pgxpool.Pool, min int) ([]faceCluster, error) {
+	rows, err := pool.Query(ctx, facesListQuery, min)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []faceCluster
+
+	for rows.Next() {
+		var fc faceCluster
+
+		if err := rows.Scan(&fc.ID, &fc.Label, &fc.Faces, &fc.Hash); err != nil {
+			return nil, err
+		}
+
+		list = append(list, fc)
+	}
+
+	return list, rows.Err()
+} // }}}
+
+// func writeFaceClusters {{{
+
+// Writes a line per cluster, the ID, how many faces, the label and the hash of an image to look at.
+func writeFaceClusters(w io.Writer, list []faceCluster, unlabeled bool) {
+	var shown int
+
+	for _, fc := range list {
+		if unlabeled && fc.Label != "" {
+			continue
+		}
+
+		label := fc.Label
+		if label == "" {
+			label = "-"
+		}
+
+		fmt.Fprintf(w, "%d\t%d faces\t%s\t%s\n", fc.ID, fc.Faces, label, fc.Hash)
+
+		shown++
+	}
+
+	fmt.Fprintf(w, "%d clusters\n", shown)
+} // }}}
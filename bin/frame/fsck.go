@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The queries for "frame fsck", see sql/table.sql.
+const (
+	// Every hash the IDManager knows, which is also the ID of the image.
+	fsckHashes = `SELECT hid, hash FROM files.hashes`
+
+	// Enabled merged rows that no enabled file has the hash of any longer, so cmerge should have disabled them.
+	fsckMergedOrphans = `SELECT m.hid FROM files.merged m WHERE m.enabled AND NOT EXISTS (
+		SELECT 1 FROM files.files f JOIN files.paths p ON p.pid = f.pid WHERE f.hid = m.hid AND f.enabled AND p.enabled )`
+
+	// The other way, enabled files whose hash has no enabled merged row so can never be shown.
+	fsckUnmerged = `SELECT DISTINCT f.hid FROM files.files f JOIN files.paths p ON p.pid = f.pid WHERE f.enabled AND p.enabled AND NOT EXISTS (
+		SELECT 1 FROM files.merged m WHERE m.hid = f.hid AND m.enabled )`
+
+	// Hashes nothing refers to, including the changes log so a rollback can still restore them.
+	fsckUnused = `SELECT h.hid FROM files.hashes h WHERE
+		NOT EXISTS ( SELECT 1 FROM files.files f WHERE f.hid = h.hid ) AND
+		NOT EXISTS ( SELECT 1 FROM files.merged m WHERE m.hid = h.hid ) AND
+		NOT EXISTS ( SELECT 1 FROM files.changes c WHERE c.old_hid = h.hid OR c.new_hid = h.hid )`
+
+	// Enabled merged rows, which need to be in the imagecache to be shown.
+	fsckMerged = `SELECT hid FROM files.merged WHERE enabled`
+
+	fsckDisableMerged = `UPDATE files.merged SET enabled = false WHERE hid = $1 AND enabled`
+
+	// cmerge polls files by updated, so touching them is enough to get them merged again.
+	fsckTouchFiles = `UPDATE files.files SET updated = NOW() WHERE hid = $1 AND enabled`
+
+	fsckDeleteHash = `DELETE FROM files.hashes WHERE hid = $1`
+)
+
+// type fsckImage struct {{{
+
+type fsckImage struct {
+	ID   uint64
+	Hash string
+} // }}}
+
+// type fsckReport struct {{{
+
+// Everything "frame fsck" found out of place.
+type fsckReport struct {
+	// Enabled merged rows with no enabled files.
+	MergedOrphans []fsckImage
+
+	// Enabled files with no enabled merged row.
+	Unmerged []fsckImage
+
+	// Hashes (IDs) nothing uses.
+	Unused []fsckImage
+
+	// Enabled merged rows with nothing in the imagecache.
+	Uncached []fsckImage
+
+	// Files within the imagecache (or thumbnails) with no hash in the database.
+	CacheOrphans []string
+} // }}}
+
+// func fsck {{{
+
+// Handles "frame fsck", cross-checking the files, merged and hashes tables against each other and the imagecache.
+//
+// Years of crashes and manual SQL can leave them disagreeing in ways nothing else notices, such as an image that can
+// never be shown or a cache file for an image long gone.
+//
+// With -fix, merged rows with no files are disabled, files with no merged row are touched for cmerge to merge them
+// again, unused hashes are deleted and cache files with no hash are removed. Images missing from the imagecache are
+// only reported, a full scan ("frame scan -full") caches them again.
+//
+// Frame should be stopped first, or a scan or merge running at the same time can look like drift.
+func fsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN (secret references are allowed)")
+	cache := fs.String("cache", "", "Optional, the imagecache directory to check")
+	thumbs := fs.String("thumbs", "", "Optional, the thumbcache directory if not \"thumbs\" within the imagecache")
+	fix := fs.Bool("fix", false, "Fix what was found rather then only reporting it")
+	fs.Parse(args)
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "fsck: -db is required")
+		return 1
+	}
+
+	if *cache != "" && *thumbs == "" {
+		if fi, err := os.Stat(filepath.Join(*cache, "thumbs")); err == nil && fi.IsDir() {
+			*thumbs = filepath.Join(*cache, "thumbs")
+		}
+	}
+
+	ctx := context.Background()
+
+	pool, err := libConnect(ctx, *db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %s\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	rep, err := loadFsck(ctx, pool, *cache, *thumbs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %s\n", err)
+		return 1
+	}
+
+	writeFsck(os.Stdout, rep)
+
+	if !*fix {
+		return 0
+	}
+
+	if err := fixFsck(ctx, pool, rep); err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %s\n", err)
+		return 1
+	}
+
+	fmt.Println("fixed")
+
+	return 0
+} // }}}
+
+// func loadFsck {{{
+
+func loadFsck(ctx context.Context, pool *pgxpool.Pool, cache, thumbs string) (*fsckReport, error) {
+	rep := &fsckReport{}
+
+	hashes := make(map[uint64]string)
+	known := make(map[string]bool)
+
+	rows, err := pool.Query(ctx, fsckHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var id uint64
+		var hash string
+
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		hashes[id] = hash
+		known[strings.ToLower(hash)] = true
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rep.MergedOrphans, err = fsckIDs(ctx, pool, fsckMergedOrphans, hashes); err != nil {
+		return nil, err
+	}
+
+	if rep.Unmerged, err = fsckIDs(ctx, pool, fsckUnmerged, hashes); err != nil {
+		return nil, err
+	}
+
+	if rep.Unused, err = fsckIDs(ctx, pool, fsckUnused, hashes); err != nil {
+		return nil, err
+	}
+
+	if cache == "" {
+		return rep, nil
+	}
+
+	cached, err := cacheFiles(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := fsckIDs(ctx, pool, fsckMerged, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range merged {
+		if _, ok := cached[fi.Hash]; !ok {
+			rep.Uncached = append(rep.Uncached, fi)
+		}
+	}
+
+	roots := []map[string]string{cached}
+
+	// Thumbnails are only made when first wanted, so only orphans matter.
+	if thumbs != "" {
+		tc, err := cacheFiles(thumbs)
+		if err != nil {
+			return nil, err
+		}
+
+		roots = append(roots, tc)
+	}
+
+	for _, files := range roots {
+		for hash, file := range files {
+			if !known[hash] {
+				rep.CacheOrphans = append(rep.CacheOrphans, file)
+			}
+		}
+	}
+
+	sort.Strings(rep.CacheOrphans)
+
+	return rep, nil
+} // }}}
+
+// func fsckIDs {{{
+
+// Runs one of the fsck queries returning a list of IDs, adding the hash of each.
+func fsckIDs(ctx context.Context, pool *pgxpool.Pool, query string, hashes map[uint64]string) ([]fsckImage, error) {
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []fsckImage
+
+	for rows.Next() {
+		var id uint64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		list = append(list, fsckImage{ID: id, Hash: hashes[id]})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list, rows.Err()
+} // }}}
+
+// func cacheFiles {{{
+
+// Returns every cached image within root by hash, using the layout of the CacheManager ("a/b/ab....webp").
+//
+// Anything else (such as .tmp files, or the thumbnails within the imagecache) is not ours to judge so is skipped.
+func cacheFiles(root string) (map[string]string, error) {
+	files := make(map[string]string)
+
+	outer, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range outer {
+		if !a.IsDir() || len(a.Name()) != 1 {
+			continue
+		}
+
+		inner, err := ioutil.ReadDir(filepath.Join(root, a.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range inner {
+			if !b.IsDir() || len(b.Name()) != 1 {
+				continue
+			}
+
+			dir := filepath.Join(root, a.Name(), b.Name())
+
+			list, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, fi := range list {
+				hash := strings.TrimSuffix(fi.Name(), ".webp")
+
+				if !fi.Mode().IsRegular() || hash == fi.Name() || !strings.HasPrefix(hash, a.Name()+b.Name()) {
+					continue
+				}
+
+				files[hash] = filepath.Join(dir, fi.Name())
+			}
+		}
+	}
+
+	return files, nil
+} // }}}
+
+// func writeFsck {{{
+
+func writeFsck(w io.Writer, rep *fsckReport) {
+	images := func(title string, list []fsckImage) {
+		if len(list) == 0 {
+			return
+		}
+
+		fmt.Fprintf(w, "%s:\n", title)
+
+		for _, fi := range list {
+			fmt.Fprintf(w, "\t%d\t%s\n", fi.ID, fi.Hash)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	images("merged with no files (disable)", rep.MergedOrphans)
+	images("files not merged (merge again)", rep.Unmerged)
+	images("hashes with no files (delete)", rep.Unused)
+	images("merged with no cache file (rescan)", rep.Uncached)
+
+	if len(rep.CacheOrphans) > 0 {
+		fmt.Fprintln(w, "cache files with no hash (remove):")
+
+		for _, file := range rep.CacheOrphans {
+			fmt.Fprintf(w, "\t%s\n", file)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "%d merged with no files, %d files not merged, %d unused hashes, %d not cached, %d cache orphans\n",
+		len(rep.MergedOrphans), len(rep.Unmerged), len(rep.Unused), len(rep.Uncached), len(rep.CacheOrphans))
+} // }}}
+
+// func fixFsck {{{
+
+// Fixes the database within a single transaction, then removes the orphaned cache files.
+//
+// The cache files are only removed once the database is done, so a failure leaves the cache as it was.
+func fixFsck(ctx context.Context, pool *pgxpool.Pool, rep *fsckReport) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	steps := []struct {
+		query string
+		list  []fsckImage
+	}{
+		{fsckDisableMerged, rep.MergedOrphans},
+		{fsckTouchFiles, rep.Unmerged},
+		{fsckDeleteHash, rep.Unused},
+	}
+
+	for _, step := range steps {
+		for _, fi := range step.list {
+			if _, err := tx.Exec(ctx, step.query, fi.ID); err != nil {
+				tx.Rollback(ctx)
+				return fmt.Errorf("id %d: %w", fi.ID, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, file := range rep.CacheOrphans {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
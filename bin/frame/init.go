@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"frame/app"
+	"frame/internal/secrets"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// type initOpts struct {{{
+
+// Everything "frame init" needs, from the flags or asked for.
+type initOpts struct {
+	Dir     string
+	DB      string
+	Photos  string
+	Profile string
+	Tag     string
+	Cache   string
+	Output  string
+	Width   int
+	Height  int
+
+	// Set once the schema is applied, see initDatabase().
+	Base int64
+} // }}}
+
+// func initConfig {{{
+
+// Handles "frame init", going from nothing to a working configuration directory and a first collage.
+//
+// Anything not given as a flag is asked for on stdin, unless -yes in which case the defaults are used. The database
+// must be PostgreSQL with a "frame" role (see sql/table.sql), there is no embedded database yet.
+//
+// Like config-docs the schema is read from the source tree, so run it from there or give -schema.
+func initConfig(args []string) int {
+	o := &initOpts{}
+
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.StringVar(&o.Dir, "dir", "frame-conf", "Directory to write the configuration (and by default the cache) to")
+	fs.StringVar(&o.DB, "db", "", "Database URI or DSN (secret references are allowed)")
+	fs.StringVar(&o.Photos, "photos", "", "Directory of photos to use as the first base")
+	fs.StringVar(&o.Profile, "profile", "default", "Name of the profile to create")
+	fs.StringVar(&o.Tag, "tag", "photos", "Tag given to every photo in the base, used by the profile")
+	fs.StringVar(&o.Cache, "cache", "", "Image cache directory (default <dir>/cache)")
+	fs.StringVar(&o.Output, "output", "", "Where the profile writes its collage (default <dir>/frame.webp)")
+	size := fs.String("size", "1920x1080", "Size of the collage")
+	schema := fs.String("schema", filepath.Join("sql", "table.sql"), "The schema to apply, empty to skip")
+	yes := fs.Bool("yes", false, "Do not ask for anything, use the flags and defaults as-is")
+	run := fs.Bool("run", true, "Run the first scan and render once the configuration is written")
+	wait := fs.Duration("wait", 15*time.Minute, "How long the first run may take")
+	fs.Parse(args)
+
+	if !*yes {
+		in := bufio.NewReader(os.Stdin)
+
+		for _, q := range []struct {
+			label string
+			val   *string
+		}{
+			{"Configuration directory", &o.Dir},
+			{"Database (PostgreSQL DSN or URI)", &o.DB},
+			{"Photo directory", &o.Photos},
+			{"Profile name", &o.Profile},
+			{"Tag for the photos", &o.Tag},
+			{"Collage size", size},
+		} {
+			if err := initAsk(in, os.Stdout, q.label, q.val); err != nil {
+				fmt.Fprintf(os.Stderr, "init: %s\n", err)
+				return 1
+			}
+		}
+	}
+
+	if err := o.check(*size); err != nil {
+		fmt.Fprintf(os.Stderr, "init: %s\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	if err := o.initDatabase(ctx, *schema); err != nil {
+		fmt.Fprintf(os.Stderr, "init: %s\n", err)
+		return 1
+	}
+
+	if err := o.writeFiles(); err != nil {
+		fmt.Fprintf(os.Stderr, "init: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Configuration written to %s\n", o.Dir)
+
+	if *run {
+		if err := o.firstRun(ctx, *wait); err != nil {
+			fmt.Fprintf(os.Stderr, "init: %s\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("Start it with: %s -conf %s\n", os.Args[0], filepath.Join(o.Dir, "frame"))
+
+	return 0
+} // }}}
+
+// func initAsk {{{
+
+// Asks for a single value, keeping what it already has (shown as the default) if the answer is empty.
+func initAsk(in *bufio.Reader, out io.Writer, label string, val *string) error {
+	if *val != "" {
+		fmt.Fprintf(out, "%s [%s]: ", label, *val)
+	} else {
+		fmt.Fprintf(out, "%s: ", label)
+	}
+
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return err
+	}
+
+	if line = strings.TrimSpace(line); line != "" {
+		*val = line
+	}
+
+	return nil
+} // }}}
+
+// func initOpts.check {{{
+
+// Validates the options, making every path absolute so the configuration works from anywhere.
+func (o *initOpts) check(size string) error {
+	var err error
+
+	if o.DB == "" {
+		return errors.New("a database is required")
+	}
+
+	if o.Photos == "" {
+		return errors.New("a photo directory is required")
+	}
+
+	if o.Profile == "" || o.Tag == "" {
+		return errors.New("a profile and tag are required")
+	}
+
+	if _, err := fmt.Sscanf(size, "%dx%d", &o.Width, &o.Height); err != nil || o.Width < 1 || o.Height < 1 {
+		return fmt.Errorf("invalid size %q", size)
+	}
+
+	if o.Dir, err = filepath.Abs(o.Dir); err != nil {
+		return err
+	}
+
+	if o.Photos, err = filepath.Abs(o.Photos); err != nil {
+		return err
+	}
+
+	if st, err := os.Stat(o.Photos); err != nil {
+		return err
+	} else if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", o.Photos)
+	}
+
+	// Never overwrite someone's configuration.
+	if _, err := os.Stat(filepath.Join(o.Dir, "frame")); err == nil {
+		return fmt.Errorf("%s already has a configuration", o.Dir)
+	}
+
+	if o.Cache == "" {
+		o.Cache = filepath.Join(o.Dir, "cache")
+	}
+
+	if o.Output == "" {
+		o.Output = filepath.Join(o.Dir, "frame.webp")
+	}
+
+	if o.Cache, err = filepath.Abs(o.Cache); err != nil {
+		return err
+	}
+
+	if o.Output, err = filepath.Abs(o.Output); err != nil {
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func initOpts.initDatabase {{{
+
+// Applies the schema unless it is already there, then finds or adds the base for the photo directory.
+func (o *initOpts) initDatabase(ctx context.Context, schema string) error {
+	dsn, err := secrets.Resolve(o.DB)
+	if err != nil {
+		return err
+	}
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	// The triggers in the schema can not be created twice, so only apply it to an empty database.
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT to_regclass('files.merged') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+
+	switch {
+	case exists:
+		fmt.Println("Schema already exists, leaving it as-is")
+	case schema == "":
+		return errors.New("schema is missing and -schema is empty")
+	default:
+		sql, err := ioutil.ReadFile(schema)
+		if err != nil {
+			return fmt.Errorf("%w (run from the source tree or set -schema)", err)
+		}
+
+		// Without any arguments this is sent as a simple query, which allows the many statements within.
+		if _, err := pool.Exec(ctx, string(sql)); err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+
+		fmt.Printf("Applied %s\n", schema)
+	}
+
+	err = pool.QueryRow(ctx, `SELECT bid FROM files.base WHERE description = $1 ORDER BY bid LIMIT 1`, o.Photos).Scan(&o.Base)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	return pool.QueryRow(ctx, `INSERT INTO files.base ( description ) VALUES ( $1 ) RETURNING bid`, o.Photos).Scan(&o.Base)
+} // }}}
+
+// func initOpts.writeFiles {{{
+
+// Writes the configuration of every module, each to its own directory the same as example-conf.
+//
+// The main configuration is within "frame" as yconf loads every directory below the one given.
+//
+// The photo directory gets a tags.txt with the tag, unless it already has one.
+func (o *initOpts) writeFiles() error {
+	qdb := yamlQuote(o.DB)
+	poll := "1m"
+
+	files := []struct {
+		name string
+		data string
+	}{
+		{"frame/frame.yaml", fmt.Sprintf(initFrame,
+			yamlQuote(filepath.Join(o.Dir, "tagmanager")),
+			yamlQuote(filepath.Join(o.Dir, "idmanager")),
+			yamlQuote(filepath.Join(o.Dir, "cachemanager")),
+			yamlQuote(filepath.Join(o.Dir, "imgproc")),
+			yamlQuote(filepath.Join(o.Dir, "cachemerge")),
+			yamlQuote(filepath.Join(o.Dir, "weighter")),
+			yamlQuote(filepath.Join(o.Dir, "render")))},
+		{"tagmanager/tagmanager.yaml", fmt.Sprintf(initTagManager, qdb)},
+		{"idmanager/idmanager.yaml", fmt.Sprintf(initIDManager, qdb)},
+		{"cachemanager/cachemanager.yaml", fmt.Sprintf(initCacheManager, yamlQuote(o.Cache))},
+		{"imgproc/imgproc.yaml", fmt.Sprintf(initImageProc, qdb, yamlQuote(o.Photos), o.Base)},
+		{"cachemerge/cachemerge.yaml", fmt.Sprintf(initCacheMerge, qdb, poll)},
+		{"weighter/weighter.yaml", fmt.Sprintf(initWeighter, qdb, poll, yamlQuote(o.Profile), yamlQuote(o.Tag))},
+		{"render/render.yaml", fmt.Sprintf(initRender, yamlQuote(o.Profile), o.Width, o.Height, yamlQuote(o.Output), poll)},
+	}
+
+	for _, f := range files {
+		name := filepath.Join(o.Dir, filepath.FromSlash(f.name))
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+
+		// Holds the database, which can have a password.
+		if err := ioutil.WriteFile(name, []byte(f.data), 0600); err != nil {
+			return err
+		}
+	}
+
+	tagFile := filepath.Join(o.Photos, "tags.txt")
+
+	if _, err := os.Stat(tagFile); err == nil {
+		fmt.Printf("%s already exists, make sure it has the tag %q\n", tagFile, o.Tag)
+		return nil
+	}
+
+	return ioutil.WriteFile(tagFile, []byte(o.Tag+"\n"), 0644)
+} // }}}
+
+// func initOpts.firstRun {{{
+
+// Starts everything the same as "frame -conf", waiting until the first collage is written.
+func (o *initOpts) firstRun(ctx context.Context, wait time.Duration) error {
+	l := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+
+	dir := func(name string) string { return filepath.Join(o.Dir, name) }
+
+	co := &app.Config{
+		TagManager:   dir("tagmanager"),
+		IDManager:    dir("idmanager"),
+		CacheManager: dir("cachemanager"),
+		ImageProc:    dir("imgproc"),
+		CacheMerge:   dir("cachemerge"),
+		Weighter:     dir("weighter"),
+		Render:       dir("render"),
+	}
+
+	ctx, can := context.WithTimeout(ctx, wait)
+	defer can()
+
+	a, err := app.New(co, &l, ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Shutdown()
+
+	fmt.Printf("Scanning %s and rendering the first collage, this can take a while\n", o.Photos)
+
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	var scanned bool
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no collage written within %s, check the logs above", wait)
+		case <-tick.C:
+		}
+
+		if runs := a.ImageProc().ScanRuns(); !scanned && len(runs) > 0 {
+			scanned = true
+
+			run := runs[len(runs)-1]
+			fmt.Printf("Scan finished, %d files seen and %d added\n", run.Seen, run.Added)
+
+			if run.Seen == 0 {
+				return fmt.Errorf("no images found in %s", o.Photos)
+			}
+		}
+
+		if _, err := os.Stat(o.Output); err == nil {
+			fmt.Printf("First collage written to %s\n", o.Output)
+			return nil
+		}
+	}
+} // }}}
+
+// func yamlQuote {{{
+
+// Returns the string as a YAML scalar, quoted if it needs to be.
+func yamlQuote(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return strings.TrimSpace(string(out))
+} // }}}
+
+// The files written by "frame init", see initOpts.writeFiles().
+//
+// The queries match sql/table.sql, the intervals are short so the first collage does not take long.
+const (
+	initFrame = `# Written by "frame init", see example-conf for everything else that can be set.
+tagmanager: %s
+idmanager: %s
+cachemanager: %s
+imageproc: %s
+cachemerge: %s
+weighter: %s
+render: %s
+`
+
+	initTagManager = `database: %s
+`
+
+	initIDManager = `database: %s
+
+queries:
+  getid: 'SELECT files.get_hashid($1)'
+  gethash: 'SELECT hash FROM files.hashes WHERE hid = $1'
+  rehash: 'UPDATE files.hashes SET hash = $2 WHERE hid = $1'
+`
+
+	initCacheManager = `maxresolution: "3840x3840"
+imagecache: %s
+`
+
+	initImageProc = `database: %s
+
+# Every photo gets the tags in tags.txt within the base, and any directory below can have its own.
+bases:
+  %s:
+    base: %d
+    checkinterval: "1h"
+
+queries:
+  paths-select: 'SELECT pid, name, pathts, tags, sidets FROM files.paths WHERE bid = $1 AND enabled'
+  paths-insert: 'INSERT INTO files.paths ( bid, name, pathts, tags, sidets ) VALUES ( $1, $2, $3, $4, $5 ) ON CONFLICT ON CONSTRAINT "paths_bid_name_key" DO UPDATE SET pathts = EXCLUDED.pathts, tags = EXCLUDED.tags, sidets = EXCLUDED.sidets, enabled = true RETURNING pid'
+  paths-update: 'UPDATE files.paths SET pathts = $2, tags = $3, sidets = $4 WHERE pid = $1'
+  paths-disable: 'UPDATE files.paths SET enabled = false WHERE pid = $1'
+  files-select: 'SELECT fid, name, filets, hid, sidets, sidetags, tags FROM files.files WHERE pid = $1 AND enabled'
+  files-insert: 'INSERT INTO files.files ( pid, name, filets, hid, sidets, sidetags, tags ) VALUES ( $1, $2, $3, $4, $5, $6, $7 ) ON CONFLICT ON CONSTRAINT "files_pid_name_key" DO UPDATE SET filets = EXCLUDED.filets, hid = EXCLUDED.hid, sidets = EXCLUDED.sidets, sidetags = EXCLUDED.sidetags, tags = EXCLUDED.tags, enabled = true RETURNING fid'
+  files-update: 'UPDATE files.files SET filets = $2, hid = $3, sidets = $4, sidetags = $5, tags = $6 WHERE fid = $1'
+  files-disable: 'UPDATE files.files SET enabled = false WHERE fid = $1'
+  checkpoint-select: 'SELECT loop, path FROM files.checkpoints WHERE bid = $1'
+  checkpoint-update: 'INSERT INTO files.checkpoints ( bid, loop, path ) VALUES ( $1, $2, $3 ) ON CONFLICT ( bid ) DO UPDATE SET loop = EXCLUDED.loop, path = EXCLUDED.path, updated = NOW()'
+  runs-insert: 'INSERT INTO files.scan_runs ( bid, started, took, full_scan, seen, added, updated, disabled, errors, error ) VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10 )'
+  changes-insert: 'INSERT INTO files.changes ( fid, bid, action, old_hid, new_hid, old_tags, new_tags ) VALUES ( $1, $2, $3, $4, $5, $6, $7 )'
+`
+
+	initCacheMerge = `database: %s
+
+pollinterval: %q
+fullinterval: "1h"
+
+queries:
+  full: 'SELECT fid, hid, tags FROM files.files WHERE enabled'
+  poll: 'SELECT fid, hid, tags, enabled FROM files.files WHERE updated >= NOW() - interval ''5 minutes'''
+  poll-hashes: 'SELECT fid, hid, tags, enabled FROM files.files WHERE hid = ANY($1)'
+  select: 'SELECT hid, tags, blocked FROM files.merged WHERE enabled'
+  insert: 'INSERT INTO files.merged ( hid, tags, blocked ) VALUES ( $1, $2, $3 ) ON CONFLICT ON CONSTRAINT "merged_hid_key" DO UPDATE SET tags = EXCLUDED.tags, blocked = EXCLUDED.blocked, enabled = true'
+  update: 'UPDATE files.merged SET tags = $1, blocked = $2 WHERE hid = $3'
+  disable: 'UPDATE files.merged SET enabled = false WHERE hid = $1'
+`
+
+	initWeighter = `database: %s
+
+pollinterval: %q
+fullinterval: "1h"
+
+queries:
+  full: 'SELECT hid, tags FROM files.merged WHERE enabled AND NOT blocked'
+  poll: 'SELECT hid, tags, enabled FROM files.merged WHERE updated >= NOW() - interval ''5 minutes'''
+  poll-hashes: 'SELECT hid, tags, enabled FROM files.merged WHERE hid = ANY($1)'
+
+profile:
+  %s:
+    weights:
+      %s: 10
+`
+
+	initRender = `profiles:
+  - tagprofile: %s
+    width: %d
+    height: %d
+    outputfile: %s
+    writeinterval: %q
+`
+)
@@ -5,17 +5,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"frame/cmanager"
-	"frame/cmerge"
-	"frame/idmanager"
-	"frame/imgproc"
-	"frame/render"
-	"frame/tagmanager"
-	"frame/types"
-	"frame/weighter"
+	"frame/app"
+	"frame/internal/redact"
 	"frame/yconf"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -26,59 +21,42 @@ import (
 // func usage {{{
 
 func usage() {
-	fmt.Printf("usage: %s\n", os.Args[0])
+	fmt.Printf("usage: %s -conf <path>\n", os.Args[0])
+	fmt.Printf("       %s config-check -conf <path>\n", os.Args[0])
+	fmt.Printf("       %s config-docs [-src <dir>] [-format md|yaml]\n", os.Args[0])
+	fmt.Printf("       %s config-migrate -conf <path> [-src <dir>] [-dry-run]\n", os.Args[0])
+	fmt.Printf("       %s dupes -db <database> [-query <query>]\n", os.Args[0])
+	fmt.Printf("       %s export -db <database> -out <archive> [-cache <imagecache>]\n", os.Args[0])
+	fmt.Printf("       %s faces list|label -db <database> [-min <faces>] [-unlabeled] [-cluster <id> <label>|-clear]\n", os.Args[0])
+	fmt.Printf("       %s fsck -db <database> [-cache <imagecache>] [-thumbs <thumbcache>] [-fix]\n", os.Args[0])
+	fmt.Printf("       %s init [-dir <path>] [-db <database>] [-photos <dir>] [-profile <name>] [-yes]\n", os.Args[0])
+	fmt.Printf("       %s import -db <database> -in <archive> [-cache <imagecache>]\n", os.Args[0])
+	fmt.Printf("       %s rollback -db <database> -since <duration> [-dry-run]\n", os.Args[0])
+	fmt.Printf("       %s scan -conf <path> -base <n> [-path <dir>] [-full]\n", os.Args[0])
+	fmt.Printf("       %s service install|remove -conf <path> (Windows only)\n", os.Args[0])
+	fmt.Printf("       %s tag add|remove -conf <path> -base <n> -path <pattern> [-manifest] [-rescan] [-dry-run] <tag>...\n", os.Args[0])
+	fmt.Printf("       %s tagstats -db <database> [-min <images>] [-ratio <0-1>] [-limit <n>]\n", os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(-1)
 } // }}}
 
 // type confFile struct {{{
 
-// Note that at least one of the optional services must be enabled.
-//
-// Those being: ImageProc, CacheMerge or Weighter.
+// The modules to load are the same as app.Config, we only add what is specific to running as a program.
 type confFile struct {
-	// File/Path to the TagManager configuration, passed in to tagmanager.New()
-	//
-	// This one is not optional.
-	TagManager string `yaml:"tagmanager"`
-
-	// Maps hashes <-> IDs (uint64).
-	//
-	// This one is not optional.
-	IDManager string `yaml:"idmanager"`
-
-	// Configuration path for ImageProc.
-	//
-	// Optional - If left empty ImageProc will not be loaded.
-	ImageProc string `yaml:"imageproc"`
-
-	// Configuration path for CMerge
-	//
-	// Optional - If left empty CMerge will not be loaded.
-	CacheMerge string `yaml:"cachemerge"`
-
-	// Configure the CacheManager.
-	//
-	// Required if either ImageProc or Renderer is configured.
-	CacheManager string `yaml:"cachemanager"`
-
-	// Configure path for Weighter
-	//
-	// Optional - If left empty Weighter will not be loaded.
-	Weighter string `yaml:"weighter"`
-
-	// Configure path for Render.
-	//
-	// Optional - If left empty Render will not be loaded.
-	//
-	// Requires Weighter and CacheManager.
-	Render string `yaml:"render"`
+	app.Config `yaml:",inline"`
 
 	// The path for the hourly log file to be written.
 	// STDOUT and STDERR will be redirected to this file.
 	//
 	// Optional - If left empty then STDOUT and STDERR will get all output.
 	LogPath string `yaml:"logpath"`
+
+	// When run by systemd with WatchdogSec, how long any one scheduled task can run before we stop telling systemd
+	// we are alive, so it restarts us.
+	//
+	// Should be longer then the longest full scan or merge. Defaults to 1 hour.
+	WatchdogStall yconf.Duration `yaml:"watchdogstall"`
 } // }}}
 
 // type frame struct {{{
@@ -87,13 +65,7 @@ type frame struct {
 	l     zerolog.Logger
 	cFile string
 	co    *confFile
-	tm    types.TagManager
-	im    types.IDManager
-	ip    *imgproc.ImageProc
-	cm    *cmerge.CMerge
-	cma   *cmanager.CManager
-	we    types.Weighter
-	re    *render.Render
+	app   *app.App
 	yc    *yconf.YConf
 	ctx   context.Context
 	can   context.CancelFunc
@@ -118,7 +90,7 @@ func (f *frame) Wait() {
 	fl := f.l.With().Str("func", "Wait").Logger()
 
 	// And now we just loop waiting for a signal.
-	endSig := make(chan os.Signal)
+	endSig := make(chan os.Signal, 1)
 	signal.Notify(endSig, os.Interrupt, syscall.SIGTERM)
 
 	fl.Info().Msg("Waiting on signal")
@@ -132,56 +104,35 @@ func (f *frame) Wait() {
 // func frame.close {{{
 
 func (f *frame) close() {
-	// Signal it all to shutdown.
-	f.can()
-
-	f.l.Info().Msg("Shutting down")
+	// The App handles shutting down everything it loaded.
+	if f.app != nil {
+		f.app.Shutdown()
+	}
 
-	// This time delay gives the above just a little more time to shutdown properly.
-	time.Sleep(300 * time.Millisecond)
+	// Signal anything else of ours to shutdown.
+	f.can()
 } // }}}
 
-// func main {{{
+// func frame.start {{{
 
-func main() {
+// Loads the configuration and everything in it.
+//
+// Errors are already logged, the caller only needs to close() and exit.
+func (f *frame) start() error {
 	var err error
 
-	// Set the time logging format
-	zerolog.TimeFieldFormat = time.RFC3339
-
-	f := &frame{
-		// Set to an invalid hour to ensure it rotates the first time.
-		curHour: 50,
-	}
-
-	// Get our shutdown context
-	f.ctx, f.can = context.WithCancel(context.Background())
-
-	// New zerolog that we share with everyone.
-	//
-	// This function handles differences between different systems.
-	f.l = f.newLog()
-
-	// Lets load our flags.
-	flag.StringVar(&f.cFile, "conf", "", "YAML Configuration directory")
-	flag.Parse()
-
-	if f.cFile == "" {
-		usage()
-	}
-
 	f.yc, err = yconf.New(f.cFile, pathsConf, &f.l, f.ctx)
 	if err != nil {
 		f.l.Err(err).Msg("yconf.New")
-		os.Exit(-1)
+		return err
 	}
 
 	if err = f.yc.CheckConf(); err != nil {
 		f.l.Err(err).Msg("yc.CheckConf")
-		os.Exit(-1)
+		return err
 	}
 
-	f.l.Debug().Interface("conf", f.yc.Get()).Send()
+	f.l.Debug().Interface("conf", redact.Conf(f.yc.Get())).Send()
 
 	// Get the loaded configuration
 	if lconf, ok := f.yc.Get().(*confFile); ok {
@@ -189,127 +140,122 @@ func main() {
 	}
 
 	if f.co == nil {
-		f.l.Err(nil).Msg("No paths loaded from configuration")
-		os.Exit(-1)
+		err = errors.New("no paths loaded from configuration")
+		f.l.Err(err).Send()
+		return err
 	}
 
 	if f.co.LogPath != "" {
 		if err := f.logRotate(); err != nil {
 			f.l.Err(err).Msg("rotate")
-			os.Exit(-1)
+			return err
 		}
 
 		// Log rotation good.
 		go f.logLoopy()
 	}
 
-	f.l.Debug().Interface("yc", f.co).Send()
+	f.l.Debug().Interface("yc", redact.Conf(f.co)).Send()
 
-	if f.co.TagManager == "" {
-		f.l.Error().Msg("Missing tagmanager configuration")
-		os.Exit(-1)
+	// Check everything can be loaded before loading any of it, so every problem is reported together.
+	if err = app.Validate(&f.co.Config); err != nil {
+		writeConfigError(os.Stderr, err)
+		f.l.Err(err).Msg("Validate")
+		return err
 	}
 
-	// Now we need the TagManager.
-	f.tm, err = tagmanager.New(f.co.TagManager, &f.l, f.ctx)
+	// Load everything.
+	f.app, err = app.New(&f.co.Config, &f.l, f.ctx)
 	if err != nil {
-		f.l.Err(err).Msg("TagManager")
-		f.tm = nil
-		f.close()
-		os.Exit(-1)
+		f.l.Err(err).Msg("app.New")
+		return err
 	}
 
-	if f.co.IDManager == "" {
-		f.l.Error().Msg("Missing idmanager configuration")
-		os.Exit(-1)
-	}
+	// Pause and resume on SIGUSR1 and SIGUSR2.
+	f.pauseSignals()
 
-	f.im, err = idmanager.New(f.co.IDManager, &f.l, f.ctx)
-	if err != nil {
-		f.l.Err(err).Msg("IDManager")
-		f.im = nil
-		f.close()
-		os.Exit(-1)
-	}
+	return nil
+} // }}}
 
-	if f.co.CacheManager != "" {
-		f.cma, err = cmanager.New(f.co.CacheManager, f.im, &f.l, f.ctx)
-		if err != nil {
-			f.cma = nil
-			f.l.Err(err).Msg("CacheManager")
-			f.close()
-			os.Exit(-1)
+// func main {{{
+
+func main() {
+	// Other modes that do not run anything.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config-check":
+			os.Exit(configCheck(os.Args[2:]))
+		case "config-docs":
+			os.Exit(configDocs(os.Args[2:]))
+		case "config-migrate":
+			os.Exit(configMigrate(os.Args[2:]))
+		case "dupes":
+			os.Exit(dupes(os.Args[2:]))
+		case "export":
+			os.Exit(export(os.Args[2:]))
+		case "faces":
+			os.Exit(faces(os.Args[2:]))
+		case "fsck":
+			os.Exit(fsck(os.Args[2:]))
+		case "init":
+			os.Exit(initConfig(os.Args[2:]))
+		case "import":
+			os.Exit(importLib(os.Args[2:]))
+		case "rollback":
+			os.Exit(rollback(os.Args[2:]))
+		case "scan":
+			os.Exit(scan(os.Args[2:]))
+		case "service":
+			os.Exit(service(os.Args[2:]))
+		case "tag":
+			os.Exit(tagFiles(os.Args[2:]))
+		case "tagstats":
+			os.Exit(tagStats(os.Args[2:]))
 		}
 	}
 
-	// Do we load the ImageProc?
-	if f.co.ImageProc != "" {
-		if f.cma == nil {
-			f.l.Err(errors.New("imageproc requires cachemanager")).Send()
-			f.close()
-			os.Exit(-1)
-		}
+	// Set the time logging format
+	zerolog.TimeFieldFormat = time.RFC3339
 
-		// And next is our real core, the one doing all the real work here, ImageProc.
-		f.ip, err = imgproc.New(f.co.ImageProc, f.tm, f.cma, &f.l, f.ctx)
-		if err != nil {
-			f.ip = nil
-			f.l.Err(err).Msg("ImageProc")
-			f.close()
-			os.Exit(-1)
-		}
+	f := &frame{
+		// Set to an invalid hour to ensure it rotates the first time.
+		curHour: 50,
 	}
 
-	// Load CacheMerge?
-	if f.co.CacheMerge != "" {
-		f.cm, err = cmerge.New(f.co.CacheMerge, f.tm, &f.l, f.ctx)
-		if err != nil {
-			f.cm = nil
-			f.l.Err(err).Msg("CMerge")
-			f.close()
-			os.Exit(-1)
-		}
-	}
+	// Get our shutdown context
+	f.ctx, f.can = context.WithCancel(context.Background())
 
-	// Load the Weighter?
-	if f.co.Weighter != "" {
-		f.we, err = weighter.New(f.co.Weighter, f.tm, &f.l, f.ctx)
-		if err != nil {
-			f.cm = nil
-			f.l.Err(err).Msg("Weighter")
-			f.close()
-			os.Exit(-1)
-		}
-	}
+	// New zerolog that we share with everyone.
+	//
+	// This function handles differences between different systems.
+	f.l = f.newLog()
 
-	if f.co.Render != "" {
-		if f.we == nil {
-			f.l.Err(errors.New("render requires weighter")).Send()
-			f.close()
-			os.Exit(-1)
-		}
+	// Lets load our flags.
+	flag.StringVar(&f.cFile, "conf", "", "YAML Configuration directory")
+	flag.Parse()
 
-		if f.cma == nil {
-			f.l.Err(errors.New("render requires cachemanager")).Send()
-			f.close()
-			os.Exit(-1)
-		}
+	if f.cFile == "" {
+		usage()
+	}
 
-		f.re, err = render.New(f.co.Render, f.we, f.cma, &f.l, f.ctx)
-		if err != nil {
-			f.re = nil
-			f.l.Err(err).Msg("Render")
-			f.close()
-			os.Exit(-1)
-		}
+	// Running as a Windows service? Then the service control manager tells us when to start and stop.
+	if ok, code := f.runService(); ok {
+		os.Exit(code)
+	}
+
+	if err := f.start(); err != nil {
+		f.close()
+		os.Exit(-1)
 	}
 
-	f.l.Info().Msg("Startup Finished")
+	// Let systemd know we are up, if it is watching.
+	f.notifyReady()
 
 	// Now we just wait until something tells us to shutdown.
 	f.Wait()
 
-	f.l.Info().Msg("Shutting down")
+	sdNotify("STOPPING=1")
+
 	f.close()
 } // }}}
 
@@ -366,7 +312,7 @@ func (f *frame) logRotate() error {
 
 	path := f.co.LogPath
 	fileName := "frame." + now.Format("2006-01-02.15") + ".log"
-	fullName := path + "/" + fileName
+	fullName := filepath.Join(path, fileName)
 
 	lf, err := os.OpenFile(fullName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -382,7 +328,7 @@ func (f *frame) logRotate() error {
 	atomic.StoreInt32(&f.curHour, hour)
 
 	// Create the symlink if needed.
-	// Does nothing on Windows.
+	// A text file naming the log on Windows.
 	f.link(fileName)
 
 	return nil
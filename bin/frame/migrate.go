@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"frame/internal/confdoc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// type migrateFile struct {{{
+
+type migrateFile struct {
+	name string
+	doc  yaml.Node
+} // }}}
+
+// func configMigrate {{{
+
+// Handles "frame config-migrate", bringing the main configuration and that of every module it lists up to date.
+//
+// See confdoc.Generator.Migrate() for what is changed. Each file changed is first copied to a .bak (which yconf
+// ignores), and JSON files are only reported as we would otherwise rewrite them as YAML.
+//
+// Like config-docs this reads the source to know the current configuration, so needs to be run from within the
+// source tree or be given -src.
+func configMigrate(args []string) int {
+	fs := flag.NewFlagSet("config-migrate", flag.ExitOnError)
+	conf := fs.String("conf", "", "The main configuration, the same as given to frame -conf")
+	src := fs.String("src", ".", "Source root, the directory containing go.mod")
+	dry := fs.Bool("dry-run", false, "Only report, do not change anything")
+	fs.Parse(args)
+
+	if *conf == "" {
+		fmt.Fprintln(os.Stderr, "config-migrate: -conf is required")
+		return 1
+	}
+
+	g, err := confdoc.New(*src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-migrate: %s\n", err)
+		return 1
+	}
+
+	modules := make(map[string]confdoc.Module, len(confdoc.Modules))
+	for _, m := range confdoc.Modules {
+		modules[m.Name] = m
+	}
+
+	var changes []confdoc.Change
+	var failed bool
+
+	// The main configuration first, as it tells us where the rest are.
+	mainFiles, err := loadMigrateFiles(*conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config-migrate: %s\n", err)
+		return 1
+	}
+
+	paths := make(map[string]string)
+
+	for _, mf := range mainFiles {
+		ch, err := migrateOne(g, modules["frame"], mf, *dry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config-migrate: %s: %s\n", mf.name, err)
+			failed = true
+		}
+
+		changes = append(changes, ch...)
+
+		// Keys are fixed up by now, so any module path can be found.
+		for name, path := range modulePaths(&mf.doc, modules) {
+			paths[name] = path
+		}
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		files, err := loadMigrateFiles(paths[name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config-migrate: %s: %s\n", name, err)
+			failed = true
+			continue
+		}
+
+		for _, mf := range files {
+			ch, err := migrateOne(g, modules[name], mf, *dry)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "config-migrate: %s: %s\n", mf.name, err)
+				failed = true
+			}
+
+			changes = append(changes, ch...)
+		}
+	}
+
+	var fixed, left int
+
+	for _, ch := range changes {
+		if ch.Fixed {
+			fixed++
+			fmt.Printf("fixed  %s\n", ch)
+		} else {
+			left++
+			fmt.Printf("check  %s\n", ch)
+		}
+	}
+
+	verb := "Fixed"
+	if *dry {
+		verb = "Would fix"
+	}
+
+	fmt.Printf("%s %d, %d left to check by hand\n", verb, fixed, left)
+
+	if failed || left > 0 {
+		return 1
+	}
+
+	return 0
+} // }}}
+
+// func loadMigrateFiles {{{
+
+// Loads the same files yconf would from path, either the single file or every YAML or JSON file within.
+func loadMigrateFiles(path string) ([]*migrateFile, error) {
+	var files []*migrateFile
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	if fi.IsDir() {
+		err = filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip anything starting with '.', the same as yconf.
+			if name != path && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			switch strings.ToLower(filepath.Ext(name)) {
+			case ".yaml", ".json":
+				if info.Mode().IsRegular() {
+					names = append(names, name)
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		names = append(names, path)
+	}
+
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		mf := &migrateFile{name: name}
+
+		if err := yaml.Unmarshal(data, &mf.doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		files = append(files, mf)
+	}
+
+	return files, nil
+} // }}}
+
+// func migrateOne {{{
+
+// Migrates a single file, writing it back out (after a backup) if anything was fixed.
+func migrateOne(g *confdoc.Generator, m confdoc.Module, mf *migrateFile, dry bool) ([]confdoc.Change, error) {
+	changes, err := g.Migrate(m, mf.name, &mf.doc)
+	if err != nil {
+		return nil, err
+	}
+
+	fixed := false
+	for _, ch := range changes {
+		fixed = fixed || ch.Fixed
+	}
+
+	if !fixed || dry {
+		return changes, nil
+	}
+
+	if strings.ToLower(filepath.Ext(mf.name)) == ".json" {
+		// Tell them rather then rewriting it as YAML.
+		for i := range changes {
+			changes[i].Fixed = false
+		}
+
+		return changes, nil
+	}
+
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(&mf.doc); err != nil {
+		return changes, err
+	}
+
+	enc.Close()
+
+	fi, err := os.Stat(mf.name)
+	if err != nil {
+		return changes, err
+	}
+
+	old, err := ioutil.ReadFile(mf.name)
+	if err != nil {
+		return changes, err
+	}
+
+	// The .bak extension means yconf ignores it.
+	backup := mf.name + "." + time.Now().Format("20060102-150405") + ".bak"
+	if err := ioutil.WriteFile(backup, old, fi.Mode().Perm()); err != nil {
+		return changes, err
+	}
+
+	return changes, ioutil.WriteFile(mf.name, buf.Bytes(), fi.Mode().Perm())
+} // }}}
+
+// func modulePaths {{{
+
+// Returns the configuration path of each module set within the main configuration.
+func modulePaths(doc *yaml.Node, modules map[string]confdoc.Module) map[string]string {
+	paths := make(map[string]string)
+
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+
+	if doc.Kind != yaml.MappingNode {
+		return paths
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, val := doc.Content[i].Value, doc.Content[i+1]
+
+		if _, ok := modules[key]; !ok || key == "frame" || val.Kind != yaml.ScalarNode || val.Value == "" {
+			continue
+		}
+
+		paths[key] = val.Value
+	}
+
+	return paths
+} // }}}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"frame/internal/errlog"
+	"frame/internal/features"
+	"frame/internal/scheduler"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Used when WatchdogStall is not set.
+const defaultWatchdogStall = time.Hour
+
+// func sdNotify {{{
+
+// Sends the state to systemd, such as "READY=1", if we were started with Type=notify.
+//
+// Does nothing when NOTIFY_SOCKET is not set, such as when not run by systemd at all.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	// An abstract socket.
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+} // }}}
+
+// func watchdogInterval {{{
+
+// Returns how often systemd wants to hear from us (WatchdogSec), or 0 if it does not.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// Meant for another process, such as one that started us.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+} // }}}
+
+// func frame.notifyReady {{{
+
+// Lets systemd know we have started, then keeps the watchdog fed and the status up to date until shutdown.
+//
+// The watchdog is only fed while no scheduled task has been running for longer then WatchdogStall. Every task of a
+// module runs one after the other, so one stuck (such as from a deadlock) stops the module entirely and systemd
+// restarting us is the best we can hope for.
+func (f *frame) notifyReady() {
+	fl := f.l.With().Str("func", "notifyReady").Logger()
+
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	if err := sdNotify("READY=1\nSTATUS=" + f.status(nil)); err != nil {
+		fl.Err(err).Msg("sdNotify")
+		return
+	}
+
+	interval := watchdogInterval()
+	if interval == 0 {
+		interval = time.Minute
+	}
+
+	stall := time.Duration(f.co.WatchdogStall)
+	if stall <= 0 {
+		stall = defaultWatchdogStall
+	}
+
+	// Twice per interval as systemd suggests, so a late tick is not a restart.
+	go f.watchdog(interval/2, stall)
+} // }}}
+
+// func frame.watchdog {{{
+
+func (f *frame) watchdog(every, stall time.Duration) {
+	fl := f.l.With().Str("func", "watchdog").Logger()
+
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+
+	feed := watchdogInterval() > 0
+
+	for {
+		select {
+		case <-tick.C:
+		case <-f.ctx.Done():
+			return
+		}
+
+		stalled := scheduler.Stalled(stall)
+
+		state := "STATUS=" + f.status(stalled)
+		if feed && len(stalled) == 0 {
+			state = "WATCHDOG=1\n" + state
+		}
+
+		if len(stalled) > 0 {
+			fl.Warn().Strs("tasks", stalled).Msg("stalled")
+		}
+
+		if err := sdNotify(state); err != nil {
+			fl.Err(err).Msg("sdNotify")
+		}
+	}
+} // }}}
+
+// func frame.status {{{
+
+// Returns a single line summary of how everything is doing, for systemctl status.
+func (f *frame) status(stalled []string) string {
+	var parts []string
+
+	if len(stalled) > 0 {
+		parts = append(parts, "stalled: "+strings.Join(stalled, ", "))
+	}
+
+	if f.app.Paused() {
+		parts = append(parts, "paused")
+	}
+
+	// The most recent run of each base.
+	if ip := f.app.ImageProc(); ip != nil {
+		last := make(map[int]time.Time)
+		bad := make(map[int]string)
+
+		for _, sr := range ip.ScanRuns() {
+			if !sr.Start.After(last[sr.Base]) {
+				continue
+			}
+
+			last[sr.Base] = sr.Start
+
+			switch {
+			case sr.Error != "":
+				bad[sr.Base] = "failing"
+			case sr.Stale:
+				bad[sr.Base] = "offline"
+			default:
+				delete(bad, sr.Base)
+			}
+		}
+
+		bases := make([]int, 0, len(bad))
+		for base := range bad {
+			bases = append(bases, base)
+		}
+
+		sort.Ints(bases)
+
+		for _, base := range bases {
+			parts = append(parts, fmt.Sprintf("base %d %s", base, bad[base]))
+		}
+	}
+
+	if we, ok := f.app.Weighter().(interface{ LowProfiles() map[string]int }); ok {
+		if low := we.LowProfiles(); len(low) > 0 {
+			parts = append(parts, fmt.Sprintf("%d profiles below minpool", len(low)))
+		}
+	}
+
+	if re := f.app.Render(); re != nil {
+		if quar := re.Quarantined(); len(quar) > 0 {
+			parts = append(parts, fmt.Sprintf("%d outputs quarantined", len(quar)))
+		}
+	}
+
+	// Since we started, along with whichever happened most.
+	var errs, most int64
+	var mostKey string
+
+	for key, n := range errlog.Totals() {
+		errs += n
+
+		if n > most || (n == most && key < mostKey) {
+			most, mostKey = n, key
+		}
+	}
+
+	if errs > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors, most %s (%d)", errs, mostKey, most))
+	}
+
+	if on := features.List(); len(on) > 0 {
+		parts = append(parts, "features: "+strings.Join(on, ", "))
+	}
+
+	if len(parts) == 0 {
+		return "running"
+	}
+
+	return strings.Join(parts, "; ")
+} // }}}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// func frame.pauseSignals {{{
+
+// SIGUSR1 pauses all background work, SIGUSR2 resumes it.
+func (f *frame) pauseSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(sig)
+
+		for {
+			select {
+			case s := <-sig:
+				if s == syscall.SIGUSR1 {
+					f.app.Pause()
+				} else {
+					f.app.Resume()
+				}
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+} // }}}
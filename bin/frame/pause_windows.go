@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// func frame.pauseSignals {{{
+
+func (f *frame) pauseSignals() {
+	// No SIGUSR1 or SIGUSR2 on Windows, use the pausefile instead.
+} // }}}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"frame/internal/secrets"
+	"frame/tags"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The queries for "frame rollback", see the files.changes table in sql/table.sql.
+const (
+	// The oldest change to each file since $1, the file is put back to how it was before it.
+	rollbackSelect = `SELECT DISTINCT ON ( fid ) fid, action, old_hid, old_tags FROM files.changes WHERE changed >= $1 ORDER BY fid, cid`
+
+	// Sets updated as well, enabling a file does not change it and cachemerge polls by it.
+	rollbackRestore = `UPDATE files.files SET hid = $2, tags = $3, enabled = true, updated = NOW() WHERE fid = $1`
+	rollbackRemove  = `UPDATE files.files SET enabled = false, updated = NOW() WHERE fid = $1`
+
+	// A file within a disabled path is ignored, so a restored file needs its path back as well.
+	rollbackPath = `UPDATE files.paths SET enabled = true WHERE pid = ( SELECT pid FROM files.files WHERE fid = $1 ) AND NOT enabled`
+)
+
+// type rollbackChange struct {{{
+
+// The oldest change to a file within the rollback, see rollbackSelect.
+type rollbackChange struct {
+	FID     uint64
+	Action  string
+	OldHID  *uint64
+	OldTags tags.Tags
+} // }}}
+
+// func rollback {{{
+
+// Handles "frame rollback", undoing every change imgproc logged to files.changes since a point in time.
+//
+// Every file changed since then is put back to how it was before its first change - Added files are disabled,
+// updated files get their old hash and tags back, and disabled files are enabled again.
+//
+// Frame needs to be stopped first, or imgproc carries on from what it has in memory and simply makes the same changes
+// again. Likewise whatever caused the changes (such as the configuration) needs to be fixed before it is started.
+func rollback(args []string) int {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN, the same as the imageproc database (secret references are allowed)")
+	since := fs.Duration("since", 0, "Undo every change made within this long, such as 2h")
+	dryRun := fs.Bool("dry-run", false, "Only list what would be undone")
+	fs.Parse(args)
+
+	if *db == "" || *since <= 0 {
+		fmt.Fprintln(os.Stderr, "rollback: -db and -since are required")
+		return 1
+	}
+
+	dsn, err := secrets.Resolve(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rollback: %s\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rollback: %s\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	list, err := loadRollback(ctx, pool, time.Now().Add(-*since))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rollback: %s\n", err)
+		return 1
+	}
+
+	if *dryRun {
+		writeRollback(os.Stdout, list)
+		return 0
+	}
+
+	if err := applyRollback(ctx, pool, list); err != nil {
+		fmt.Fprintf(os.Stderr, "rollback: %s\n", err)
+		return 1
+	}
+
+	writeRollback(os.Stdout, list)
+
+	return 0
+} // }}}
+
+// func loadRollback {{{
+
+func loadRollback(ctx context.Context, pool *pgxpool.Pool, since time.Time) ([]rollbackChange, error) {
+	rows, err := pool.Query(ctx, rollbackSelect, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []rollbackChange
+
+	for rows.Next() {
+		var rc rollbackChange
+
+		if err := rows.Scan(&rc.FID, &rc.Action, &rc.OldHID, &rc.OldTags); err != nil {
+			return nil, err
+		}
+
+		list = append(list, rc)
+	}
+
+	return list, rows.Err()
+} // }}}
+
+// func applyRollback {{{
+
+// Undoes every change within a single transaction, so either all of it is undone or none of it.
+func applyRollback(ctx context.Context, pool *pgxpool.Pool, list []rollbackChange) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, rc := range list {
+		if err := undoChange(ctx, tx, rc); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("fid %d: %w", rc.FID, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+} // }}}
+
+// func undoChange {{{
+
+func undoChange(ctx context.Context, tx pgx.Tx, rc rollbackChange) error {
+	// Did not exist before, so it goes away again.
+	if rc.Action == "add" {
+		_, err := tx.Exec(ctx, rollbackRemove, rc.FID)
+		return err
+	}
+
+	// A file can not be in the database without a hash or tags, so the log is missing something.
+	if rc.OldHID == nil || len(rc.OldTags) == 0 {
+		return errors.New("no old hash or tags to restore")
+	}
+
+	if _, err := tx.Exec(ctx, rollbackRestore, rc.FID, *rc.OldHID, rc.OldTags); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, rollbackPath, rc.FID)
+	return err
+} // }}}
+
+// func writeRollback {{{
+
+func writeRollback(w io.Writer, list []rollbackChange) {
+	counts := make(map[string]int)
+
+	for _, rc := range list {
+		switch rc.Action {
+		case "add":
+			fmt.Fprintf(w, "%d\tdisable (was added)\n", rc.FID)
+		case "disable":
+			fmt.Fprintf(w, "%d\tenable (was disabled)\n", rc.FID)
+		default:
+			fmt.Fprintf(w, "%d\trestore (was updated)\n", rc.FID)
+		}
+
+		counts[rc.Action]++
+	}
+
+	fmt.Fprintf(w, "%d files, %d added, %d updated, %d disabled\n", len(list), counts["add"], counts["update"], counts["disable"])
+} // }}}
@@ -4,9 +4,10 @@ package main
 
 import (
 	"os"
-	"syscall"
+	"path/filepath"
 
 	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
 )
 
 // type logWrite struct {{{
@@ -23,7 +24,7 @@ func (f *frame) link(fileName string) {
 	path := f.co.LogPath
 
 	// Is there a link?
-	linkFile := path + "/frame.current"
+	linkFile := filepath.Join(path, "frame.current")
 
 	// Create our new temporary symlink
 	if err := os.Symlink(fileName, linkFile+".tmp"); err != nil {
@@ -45,9 +46,11 @@ func (f *frame) newLog() zerolog.Logger {
 
 func (f *frame) logFile(lf *os.File) {
 	// Replace STDOUT and STDERR, which is what the log file actually points to.
+	//
+	// x/sys rather then syscall, as there is no Dup2 on linux/arm64.
 	fd := int(lf.Fd())
-	syscall.Dup2(fd, 1)
-	syscall.Dup2(fd, 2)
+	unix.Dup2(fd, 1)
+	unix.Dup2(fd, 2)
 
 	// Original file no longer needed.
 	lf.Close()
@@ -3,7 +3,9 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/rs/zerolog"
@@ -35,8 +37,19 @@ func (lw *logWrite) Write(p []byte) (n int, err error) {
 
 // func frame.link {{{
 
+// Symlinks need special privileges on Windows, so frame.current is instead a text file naming the current log.
 func (f *frame) link(fileName string) {
-	// Not supported on Windows.
+	fl := f.l.With().Str("func", "link").Logger()
+
+	linkFile := filepath.Join(f.co.LogPath, "frame.current")
+
+	if err := ioutil.WriteFile(linkFile+".tmp", []byte(fileName+"\r\n"), 0644); err != nil {
+		fl.Err(err).Msg("WriteFile")
+		return
+	}
+
+	// Atomic rename
+	os.Rename(linkFile+".tmp", linkFile)
 } // }}}
 
 // func frame.newLog {{{
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"frame/app"
+	"frame/yconf"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// func scan {{{
+
+// Handles "frame scan", asking the running frame to scan a base (or just a path within it) now.
+//
+// The request is written to the scanrequests directory of the configuration, which the running frame checks every 10
+// seconds. So this only works with scanrequests set, and returns before the scan has even started.
+func scan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	conf := fs.String("conf", "", "The main configuration, the same as given to frame -conf")
+	base := fs.Int("base", 0, "The base to scan")
+	path := fs.String("path", "", "Only scan this directory and everything below it, relative to the base")
+	full := fs.Bool("full", false, "Check every file of the base, rather then only changed directories")
+	fs.Parse(args)
+
+	if *conf == "" || *base == 0 {
+		fmt.Fprintln(os.Stderr, "scan: -conf and -base are required")
+		return 1
+	}
+
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger().Level(zerolog.ErrorLevel)
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+
+	yc, err := yconf.New(*conf, pathsConf, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scan: %s\n", err)
+		return 1
+	}
+
+	if err = yc.CheckConf(); err != nil {
+		fmt.Fprintf(os.Stderr, "scan: %s\n", err)
+		return 1
+	}
+
+	co, ok := yc.Get().(*confFile)
+	if !ok || co.ScanRequests == "" {
+		fmt.Fprintln(os.Stderr, "scan: no scanrequests configured")
+		return 1
+	}
+
+	if err := app.RequestScan(co.ScanRequests, app.ScanRequest{Base: *base, Path: *path, Full: *full}); err != nil {
+		fmt.Fprintf(os.Stderr, "scan: %s\n", err)
+		return 1
+	}
+
+	fmt.Println("requested")
+
+	return 0
+} // }}}
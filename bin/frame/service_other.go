@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// func frame.runService {{{
+
+// Only Windows has services, elsewhere use systemd or whatever else to run us.
+func (f *frame) runService() (bool, int) {
+	return false, 0
+} // }}}
+
+// func service {{{
+
+func service(args []string) int {
+	fmt.Fprintln(os.Stderr, "service: only supported on Windows")
+	return 1
+} // }}}
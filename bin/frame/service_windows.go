@@ -0,0 +1,178 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// The name we install and run as.
+const serviceName = "frame"
+
+// type winService struct {{{
+
+type winService struct {
+	f *frame
+
+	// Set if start() failed, the exit code reported to the service control manager.
+	code uint32
+} // }}}
+
+// func frame.runService {{{
+
+// If started by the service control manager, runs until it stops us and returns true along with the exit code.
+//
+// Returns false when run from a console, to run as normal.
+func (f *frame) runService() (bool, int) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, 0
+	}
+
+	ws := &winService{f: f}
+
+	if err := svc.Run(serviceName, ws); err != nil {
+		f.l.Err(err).Msg("svc.Run")
+		return true, -1
+	}
+
+	return true, int(ws.code)
+} // }}}
+
+// func winService.Execute {{{
+
+// Implements svc.Handler.
+func (ws *winService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	f := ws.f
+
+	status <- svc.Status{State: svc.StartPending}
+
+	if err := f.start(); err != nil {
+		f.close()
+		ws.code = 1
+		return false, ws.code
+	}
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue}
+
+	for {
+		select {
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Pause:
+				f.app.Pause()
+				status <- svc.Status{State: svc.Paused, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue}
+			case svc.Continue:
+				f.app.Resume()
+				status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue}
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				f.close()
+				return false, 0
+			}
+		case <-f.ctx.Done():
+			return false, 0
+		}
+	}
+} // }}}
+
+// func service {{{
+
+// Handles "frame service install" and "frame service remove".
+//
+// Installed to start automatically, using the configuration given with -conf. Set a logpath in it, as a service
+// has nowhere else to log.
+func service(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "service: install or remove")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	conf := fs.String("conf", "", "YAML Configuration directory")
+	fs.Parse(args[1:])
+
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service: %s\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+
+	switch args[0] {
+	case "install":
+		err = serviceInstall(m, *conf)
+	case "remove":
+		err = serviceRemove(m)
+	default:
+		err = fmt.Errorf("unknown %q, install or remove", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service: %s\n", err)
+		return 1
+	}
+
+	return 0
+} // }}}
+
+// func serviceInstall {{{
+
+func serviceInstall(m *mgr.Mgr, conf string) error {
+	if conf == "" {
+		return fmt.Errorf("-conf is required")
+	}
+
+	// The service control manager does not start us in any particular directory.
+	conf, err := filepath.Abs(conf)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("%s is already installed", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Frame",
+		Description: "Renders tagged images for digital photo frames and displays.",
+		StartType:   mgr.StartAutomatic,
+	}, "-conf", conf)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Restart us if we crash, rather then leaving the display stuck.
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}, 24*60*60)
+} // }}}
+
+// func serviceRemove {{{
+
+func serviceRemove(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("%s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	return s.Delete()
+} // }}}
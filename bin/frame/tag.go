@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"frame/internal/imgproc"
+	"frame/yconf"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// func tagFiles {{{
+
+// Handles "frame tag add|remove", adding or removing tags from every image within a base matching a pattern.
+//
+// The tags are written to the sidecar of each file, or with -manifest to the manifest of the base. ImageProc picks
+// them up on its next scan of the base, -rescan makes sure that is the next partial scan rather then the next full.
+func tagFiles(args []string) int {
+	if len(args) < 1 || (args[0] != "add" && args[0] != "remove") {
+		fmt.Fprintln(os.Stderr, "tag: add or remove is required")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("tag "+args[0], flag.ExitOnError)
+	conf := fs.String("conf", "", "The main configuration, the same as given to frame -conf")
+	base := fs.Int("base", 0, "The base the files are within")
+	pattern := fs.String("path", "", "Files to tag, relative to the base such as \"2019/*\" or \"*.png\"")
+	manifest := fs.Bool("manifest", false, "Write to the manifest of the base rather then sidecars")
+	rescan := fs.Bool("rescan", false, "Have the next partial scan check the changed files")
+	dryRun := fs.Bool("dry-run", false, "Only list the files that would change")
+	fs.Parse(args[1:])
+
+	names := fs.Args()
+
+	if *conf == "" || *base == 0 || *pattern == "" || len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "tag: -conf, -base, -path and at least one tag are required")
+		return 1
+	}
+
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger().Level(zerolog.ErrorLevel)
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+
+	yc, err := yconf.New(*conf, pathsConf, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+		return 1
+	}
+
+	if err = yc.CheckConf(); err != nil {
+		fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+		return 1
+	}
+
+	co, ok := yc.Get().(*confFile)
+	if !ok || co.ImageProc == "" {
+		fmt.Fprintln(os.Stderr, "tag: no imageproc configured")
+		return 1
+	}
+
+	te, err := imgproc.NewTagEditor(co.ImageProc, *base, &l, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+		return 1
+	}
+
+	files, err := te.Match(*pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+		return 1
+	}
+
+	var add, remove []string
+	if args[0] == "add" {
+		add = names
+	} else {
+		remove = names
+	}
+
+	var changed []string
+
+	if *manifest {
+		changed, err = te.Manifest(files, add, remove, *dryRun)
+	} else {
+		changed, err = te.Sidecars(files, add, remove, *dryRun)
+	}
+
+	for _, file := range changed {
+		fmt.Println(file)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+		return 1
+	}
+
+	if *rescan && !*dryRun {
+		if err := te.Touch(changed); err != nil {
+			fmt.Fprintf(os.Stderr, "tag: %s\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("%d of %d matching files changed\n", len(changed), len(files))
+
+	return 0
+} // }}}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"frame/internal/secrets"
+	"io"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Every pair of tags on the same images, where the first is on at least $1 images and the second is on at least
+// $2 (a fraction) of those.
+//
+// Only enabled and unblocked images are counted, the same as what can be shown.
+const tagStatsQuery = `WITH t AS (
+		SELECT hid, unnest(tags) AS tag FROM files.merged WHERE enabled AND NOT blocked
+	), c AS (
+		SELECT tag, count(*) AS images FROM t GROUP BY tag HAVING count(*) >= $1
+	)
+	SELECT ta.name, tb.name, c.images, count(*) AS together
+	FROM t a
+		JOIN c ON c.tag = a.tag
+		JOIN t b ON b.hid = a.hid AND b.tag <> a.tag
+		JOIN tags.tags ta ON ta.tid = a.tag
+		JOIN tags.tags tb ON tb.tid = b.tag
+	GROUP BY ta.name, tb.name, c.images
+	HAVING count(*) >= c.images * $2
+	ORDER BY count(*)::float / c.images DESC, c.images DESC, ta.name, tb.name
+	LIMIT $3`
+
+// type tagPair struct {{{
+
+type tagPair struct {
+	Tag  string
+	Also string
+
+	// How many images have Tag, and how many of those also have Also.
+	Images   int64
+	Together int64
+} // }}}
+
+// func tagStats {{{
+
+// Handles "frame tagstats", suggesting TagRules from how often tags are found together.
+//
+// Such as "beach" being on 500 images, 400 of which are also "summer". Perhaps a TagRule giving "summer" to
+// anything with "beach" is wanted, or those other 100 images are missing a tag.
+func tagStats(args []string) int {
+	fs := flag.NewFlagSet("tagstats", flag.ExitOnError)
+	db := fs.String("db", "", "Database URI or DSN, the same as the weighter database (secret references are allowed)")
+	min := fs.Int("min", 20, "Only tags on at least this many images")
+	ratio := fs.Float64("ratio", 0.8, "Only pairs found together at least this often, 0 to 1")
+	limit := fs.Int("limit", 50, "The most suggestions to list")
+	fs.Parse(args)
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "tagstats: -db is required")
+		return 1
+	}
+
+	if *ratio <= 0 || *ratio > 1 {
+		fmt.Fprintln(os.Stderr, "tagstats: -ratio must be above 0 and no more then 1")
+		return 1
+	}
+
+	dsn, err := secrets.Resolve(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tagstats: %s\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tagstats: %s\n", err)
+		return 1
+	}
+	defer pool.Close()
+
+	pairs, err := loadTagPairs(ctx, pool, *min, *ratio, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tagstats: %s\n", err)
+		return 1
+	}
+
+	writeTagPairs(os.Stdout, pairs)
+
+	return 0
+} // }}}
+
+// func loadTagPairs {{{
+
+func loadTagPairs(ctx context.Context, pool *pgxpool.Pool, min int, ratio float64, limit int) ([]tagPair, error) {
+	rows, err := pool.Query(ctx, tagStatsQuery, min, ratio, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []tagPair
+
+	for rows.Next() {
+		var tp tagPair
+
+		if err := rows.Scan(&tp.Tag, &tp.Also, &tp.Images, &tp.Together); err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, tp)
+	}
+
+	return pairs, rows.Err()
+} // }}}
+
+// func writeTagPairs {{{
+
+func writeTagPairs(w io.Writer, pairs []tagPair) {
+	if len(pairs) == 0 {
+		fmt.Fprintln(w, "No tags are found together often enough, try a lower -ratio or -min")
+		return
+	}
+
+	for _, tp := range pairs {
+		pct := float64(tp.Together) * 100 / float64(tp.Images)
+
+		fmt.Fprintf(w, "images tagged %q are %.0f%% also %q (%d of %d)\n", tp.Tag, pct, tp.Also, tp.Together, tp.Images)
+
+		// Always together? Then most likely a rule already gives it, or one is an alias of the other.
+		if tp.Together == tp.Images {
+			fmt.Fprintf(w, "\talways together, perhaps already given by a TagRule or %q is an alias\n", tp.Tag)
+			continue
+		}
+
+		fmt.Fprintf(w, "\tconsider a TagRule giving %q to any %q, or check the %d without it\n", tp.Also, tp.Tag, tp.Images-tp.Together)
+	}
+} // }}}
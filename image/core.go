@@ -8,20 +8,26 @@
 // To make this easier, the ImageToPrefer() function exists.
 //
 // This changes a image.Image to whatever internally we prefer, without the caller having to care.
+//
+// This is public API, see "Public packages" in README.md for what that promises.
 package image
 
 import (
+	"bytes"
 	"image"
+	"image/color"
 	"image/draw"
 	"image/png"
 	"io"
 	"math"
 	"os"
+	"sort"
+	"sync/atomic"
 	_ "image/gif"
 	_ "image/jpeg"
 
-	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/tiff"
 )
 
 // func Fit {{{
@@ -59,10 +65,42 @@ func Fit(ip, wp image.Point, enlarge bool) (image.Point, float64) {
 // Given an io.Reader attempt to load an image from it.
 //
 // The image will be rotated automatically if needed.
+//
+// 16 bit images (PNG and TIFF) are converted to 8 bits, see To8Bit().
+//
+// An image over the limits (see SetLimits()) is not decoded, returning a *LimitError instead.
 func LoadReader(r io.Reader) (image.Image, error) {
+	var lr *limitReader
+
+	if limit := atomic.LoadInt64(&maxBytes); limit > 0 {
+		lr = &limitReader{r: r, max: limit}
+		r = lr
+	}
+
+	// The header is read again by the decoder, so keep what DecodeConfig() reads.
+	head := &bytes.Buffer{}
+
+	// If the header can not be read then neither can the image, so leave that error to the decoder.
+	if ic, _, err := image.DecodeConfig(io.TeeReader(r, head)); err == nil {
+		if err := checkPixels(ic.Width, ic.Height); err != nil {
+			return nil, err
+		}
+	}
+
 	// As this uses image.Decode(), this will still work with any format registered with image, such as WebP above.
 	// Though the AutoOrientation only works with JPEG, even though the other formats do support EXIF.
-	return imaging.Decode(r, imaging.AutoOrientation(true))
+	img, err := imaging.Decode(io.MultiReader(head, r), imaging.AutoOrientation(true))
+	if err != nil {
+		// Decoders tend to turn a read error into their own, such as unexpected EOF.
+		if lr != nil && lr.over != nil {
+			return nil, lr.over
+		}
+
+		return nil, err
+	}
+
+	// Otherwise every later step (resizing, encoding) would just drop the low byte.
+	return To8Bit(img), nil
 } // }}}
 
 // func SaveImageJPEG {{{
@@ -77,12 +115,6 @@ func SaveImagePNG(w io.Writer, img image.Image) error {
 	return imaging.Encode(w, img, imaging.PNG, imaging.PNGCompressionLevel(png.DefaultCompression))
 } // }}}
 
-// func SaveImageWebP {{{
-
-func SaveImageWebP(w io.Writer, img image.Image) error {
-	return webp.Encode(w, img, &webp.Options{Lossless: true})
-} // }}}
-
 // func Open {{{
 
 // Given a file name attempt to load an image from it.
@@ -117,8 +149,41 @@ func Open(file string) (image.Image, error) {
 // So I am sticking with nfnt for resizing, as it works best across all platforms I care about.
 //
 // Difference? 1s vs 10m for 1 image, and 2s vs. 22m for another.
+//
+// Uses DefaultFilter, see ResizeFilter() to choose another.
 func Resize(img image.Image, size image.Point) image.Image {
-	return imaging.Resize(img, size.X, size.Y, imaging.Lanczos)
+	return ResizeFilter(img, size, DefaultFilter)
+} // }}}
+
+// The filters ResizeFilter() accepts, slowest and best first.
+var filters = map[string]imaging.ResampleFilter{
+	"lanczos":    imaging.Lanczos,
+	"catmullrom": imaging.CatmullRom,
+	"linear":     imaging.Linear,
+	"box":        imaging.Box,
+	"nearest":    imaging.NearestNeighbor,
+}
+
+// func ValidFilter {{{
+
+// Returns true if ResizeFilter() knows the filter, for checking configurations.
+func ValidFilter(name string) bool {
+	_, ok := filters[name]
+	return ok
+} // }}}
+
+// func ResizeFilter {{{
+
+// The same as Resize() using the named filter, one of "lanczos", "catmullrom", "linear", "box" or "nearest".
+//
+// An empty or unknown name uses DefaultFilter.
+func ResizeFilter(img image.Image, size image.Point, name string) image.Image {
+	filter, ok := filters[name]
+	if !ok {
+		filter = filters[DefaultFilter]
+	}
+
+	return imaging.Resize(img, size.X, size.Y, filter)
 } // }}}
 
 // func ImageToPrefer {{{
@@ -146,3 +211,97 @@ func ImageToPrefer(in image.Image) *image.NRGBA {
 
 	return nrgba
 } // }}}
+
+// func Format {{{
+
+// Returns the image format based on the first few bytes of the file.
+//
+// Only the formats we can actually decode are known, anything else is an empty string.
+func Format(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		return "gif"
+	case len(head) >= 12 && bytes.Equal(head[0:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "tiff"
+	case bytes.HasPrefix(head, []byte("BM")):
+		return "bmp"
+	}
+
+	return ""
+} // }}}
+
+// func DominantColors {{{
+
+// Returns up to num of the most common colors within the image, most common first.
+//
+// This is not meant to be exact, the image is shrunk down and the colors bucketed so that
+// similar colors count as the same.
+func DominantColors(img image.Image, num int) []color.NRGBA {
+	// 64x64 is plenty to get a feel for the colors, and quick.
+	small := imaging.Resize(img, 64, 64, imaging.Box)
+
+	// Bucket by the top 4 bits of each channel.
+	counts := make(map[uint16]int, 256)
+	sums := make(map[uint16][3]int, 256)
+
+	b := small.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := small.NRGBAAt(x, y)
+
+			// Mostly transparent pixels are not really a color we display.
+			if c.A < 128 {
+				continue
+			}
+
+			key := uint16(c.R>>4)<<8 | uint16(c.G>>4)<<4 | uint16(c.B>>4)
+			counts[key]++
+
+			sum := sums[key]
+			sum[0] += int(c.R)
+			sum[1] += int(c.G)
+			sum[2] += int(c.B)
+			sums[key] = sum
+		}
+	}
+
+	keys := make([]uint16, 0, len(counts))
+	for key, _ := range counts {
+		keys = append(keys, key)
+	}
+
+	// Most common first, ties broken by key so we are consistent.
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] == counts[keys[j]] {
+			return keys[i] < keys[j]
+		}
+
+		return counts[keys[i]] > counts[keys[j]]
+	})
+
+	if len(keys) > num {
+		keys = keys[:num]
+	}
+
+	// Each color is the average of everything in its bucket.
+	colors := make([]color.NRGBA, 0, len(keys))
+	for _, key := range keys {
+		cnt := counts[key]
+		sum := sums[key]
+
+		colors = append(colors, color.NRGBA{
+			R: uint8(sum[0] / cnt),
+			G: uint8(sum[1] / cnt),
+			B: uint8(sum[2] / cnt),
+			A: 255,
+		})
+	}
+
+	return colors
+} // }}}
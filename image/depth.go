@@ -0,0 +1,92 @@
+package image
+
+import (
+	"image"
+)
+
+// A 4x4 ordered dither, spreading the rounding of 16 bits down to 8 across neighbouring pixels.
+//
+// Without it a smooth gradient (such as the sky of a scanned print) collapses into visible bands, as every 16 bit
+// value within the same 1/256th becomes the exact same 8 bit value.
+var bayer4 = [4][4]uint32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// func To8Bit {{{
+
+// Returns the image with 8 bits per channel, converting 16 bit images (such as 16 bit PNG and TIFF scans) with
+// rounding and dithering rather then just dropping the low byte.
+//
+// Grayscale stays grayscale. Anything already 8 bits (or not one of the 16 bit types) is returned as is.
+func To8Bit(img image.Image) image.Image {
+	switch in := img.(type) {
+	case *image.Gray16:
+		b := in.Bounds()
+		out := image.NewGray(b)
+
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out.Pix[out.PixOffset(x, y)] = dither(uint32(in.Gray16At(x, y).Y), x, y)
+			}
+		}
+
+		return out
+	case *image.NRGBA64:
+		b := in.Bounds()
+		out := image.NewNRGBA(b)
+
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := in.NRGBA64At(x, y)
+				i := out.PixOffset(x, y)
+
+				out.Pix[i+0] = dither(uint32(c.R), x, y)
+				out.Pix[i+1] = dither(uint32(c.G), x, y)
+				out.Pix[i+2] = dither(uint32(c.B), x, y)
+				out.Pix[i+3] = dither(uint32(c.A), x, y)
+			}
+		}
+
+		return out
+	case *image.RGBA64:
+		b := in.Bounds()
+		out := image.NewNRGBA(b)
+
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := in.RGBA64At(x, y)
+				i := out.PixOffset(x, y)
+
+				if c.A == 0 {
+					continue
+				}
+
+				// Un-premultiplied while still 16 bits, so pixels that are mostly transparent keep their color.
+				a := uint32(c.A)
+
+				out.Pix[i+0] = dither(uint32(c.R)*0xffff/a, x, y)
+				out.Pix[i+1] = dither(uint32(c.G)*0xffff/a, x, y)
+				out.Pix[i+2] = dither(uint32(c.B)*0xffff/a, x, y)
+				out.Pix[i+3] = dither(a, x, y)
+			}
+		}
+
+		return out
+	}
+
+	return img
+} // }}}
+
+// func dither {{{
+
+// Returns the 16 bit value v as 8 bits, rounded up or down depending on where the pixel is.
+//
+// On average over the 4x4 this is v*255/65535, and 0 and 65535 are always exactly 0 and 255.
+func dither(v uint32, x, y int) uint8 {
+	t := (bayer4[y&3][x&3]*2 + 1) * 0xffff / 32
+
+	return uint8((v*255 + t) / 0xffff)
+} // }}}
@@ -0,0 +1,139 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// Returns a 64x64 16 bit grayscale gradient from lo to hi, left to right, such as a scanned sky.
+func gray16Gradient(lo, hi uint16) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetGray16(x, y, color.Gray16{lo + uint16(int(hi-lo)*x/63)})
+		}
+	}
+
+	return img
+}
+
+// Returns a 64x64 16 bit color image, mostly opaque with a nearly transparent corner.
+func rgba64Fixture() *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA64(x, y, color.RGBA64{uint16(x * 1000), uint16(y * 1000), 0x8080, 0xffff})
+		}
+	}
+
+	// Orange at 1/256th alpha, premultiplied.
+	img.SetRGBA64(0, 0, color.RGBA64{0xff, 0x80, 0, 0x100})
+
+	return img
+}
+
+// Encodes the image as a PNG or TIFF.
+func encodeFixture(t *testing.T, format string, img image.Image) []byte {
+	buf := &bytes.Buffer{}
+
+	var err error
+
+	if format == "png" {
+		err = png.Encode(buf, img)
+	} else {
+		err = tiff.Encode(buf, img, nil)
+	}
+
+	if err != nil {
+		t.Fatalf("Encode %s: %s", format, err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTo8Bit(t *testing.T) {
+	for _, format := range []string{"png", "tiff"} {
+		data := encodeFixture(t, format, gray16Gradient(0x4000, 0x4400))
+
+		if got := Format(data); got != format {
+			t.Fatalf("Format Expected %s != Got %s", format, got)
+		}
+
+		img, err := LoadReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s LoadReader: %s", format, err)
+		}
+
+		gray, ok := img.(*image.Gray)
+		if !ok {
+			t.Fatalf("%s Expected *image.Gray != Got %T", format, img)
+		}
+
+		// The gradient only covers 4 levels of 8 bits, so dropping the low byte would leave 4 bands. Dithered, the
+		// average of each 4x4 block follows the gradient.
+		for bx := 0; bx < 64; bx += 4 {
+			var sum, ideal float64
+
+			for y := 0; y < 4; y++ {
+				for x := bx; x < bx+4; x++ {
+					sum += float64(gray.GrayAt(x, y).Y)
+					ideal += float64(0x4000+0x400*x/63) * 255 / 0xffff
+				}
+			}
+
+			if math.Abs(sum-ideal)/16 > 0.5 {
+				t.Fatalf("%s block %d Expected %.2f != Got %.2f", format, bx, ideal/16, sum/16)
+			}
+		}
+	}
+
+	// Black and white stay exactly that.
+	for _, v := range []uint16{0, 0xffff} {
+		img := To8Bit(gray16Gradient(v, v)).(*image.Gray)
+
+		for _, p := range img.Pix {
+			if p != uint8(v>>8) {
+				t.Fatalf("To8Bit %#x Expected %d != Got %d", v, v>>8, p)
+			}
+		}
+	}
+
+	// Already 8 bits is left alone.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	if got := To8Bit(nrgba); got != image.Image(nrgba) {
+		t.Fatalf("To8Bit converted an 8 bit image")
+	}
+}
+
+func TestTo8BitColor(t *testing.T) {
+	for _, format := range []string{"png", "tiff"} {
+		img, err := LoadReader(bytes.NewReader(encodeFixture(t, format, rgba64Fixture())))
+		if err != nil {
+			t.Fatalf("%s LoadReader: %s", format, err)
+		}
+
+		nrgba, ok := img.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("%s Expected *image.NRGBA != Got %T", format, img)
+		}
+
+		// Opaque, within one of the 16 bit value.
+		c := nrgba.NRGBAAt(40, 20)
+		if d := int(c.R) - 40000*255/0xffff; d < -1 || d > 1 || c.A != 255 {
+			t.Fatalf("%s (40, 20) Expected R %d != Got %v", format, 40000*255/0xffff, c)
+		}
+
+		// Nearly transparent but still orange, rather then the black a premultiplied 8 bit copy would leave.
+		if c := nrgba.NRGBAAt(0, 0); c.R < 250 || c.G < 120 || c.G > 135 || c.B != 0 {
+			t.Fatalf("%s (0, 0) Expected orange != Got %v", format, c)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Everything we write is decoded from the original and encoded again, so no EXIF, XMP or anything else from the
+// original ever makes it through. That is what we want for images shown on displays others can see, GPS especially.
+//
+// KeepExif() allows a few harmless text fields to be carried over when wanted, such as when the date is taken.
+// Orientation is never one of them, as LoadReader() has already rotated the image.
+
+// Where within the EXIF an exifField is.
+const (
+	exifIFD0 = iota
+	exifSub
+)
+
+// The EXIF tag of the pointer from IFD0 to the Exif sub-IFD.
+const exifSubTag = 0x8769
+
+// The EXIF type of text fields.
+const exifASCII = 2
+
+// type exifField struct {{{
+
+type exifField struct {
+	ifd int
+	tag uint16
+} // }}}
+
+// The fields KeepExif() can keep, all of them text.
+var exifFields = map[string]exifField{
+	"imagedescription":  {exifIFD0, 0x010e},
+	"make":              {exifIFD0, 0x010f},
+	"model":             {exifIFD0, 0x0110},
+	"software":          {exifIFD0, 0x0131},
+	"datetime":          {exifIFD0, 0x0132},
+	"artist":            {exifIFD0, 0x013b},
+	"copyright":         {exifIFD0, 0x8298},
+	"datetimeoriginal":  {exifSub, 0x9003},
+	"datetimedigitized": {exifSub, 0x9004},
+}
+
+// type exifValue struct {{{
+
+type exifValue struct {
+	exifField
+	value []byte
+} // }}}
+
+// func ExifFields {{{
+
+// Checks the names are all fields KeepExif() can keep, such as from a configuration file.
+func ExifFields(names []string) error {
+	for _, name := range names {
+		if _, ok := exifFields[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("exif field %q can not be kept", name)
+		}
+	}
+
+	return nil
+} // }}}
+
+// func KeepExif {{{
+
+// Returns the EXIF (TIFF format, as within a WebP) with only the named fields from the JPEG that head is the start of.
+//
+// The EXIF of a JPEG is right at the start, so head only needs to be the first 64KiB or so.
+//
+// nil if none of the fields are in the original, it has no EXIF or is not a JPEG. Unknown names are ignored, see
+// ExifFields().
+func KeepExif(head []byte, names []string) []byte {
+	if len(names) == 0 {
+		return nil
+	}
+
+	tiff := jpegExif(head)
+	if tiff == nil {
+		return nil
+	}
+
+	found := readExif(tiff)
+
+	var keep []exifValue
+
+	for _, name := range names {
+		ef, ok := exifFields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+
+		if value, ok := found[ef]; ok {
+			keep = append(keep, exifValue{ef, value})
+		}
+	}
+
+	if len(keep) == 0 {
+		return nil
+	}
+
+	return writeExif(keep)
+} // }}}
+
+// func jpegExif {{{
+
+// Returns the TIFF data within the EXIF APP1 segment of the JPEG, nil if it has none.
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+
+		marker := data[i+1]
+
+		// Start of scan, the image data itself follows so no more metadata.
+		if marker == 0xda {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return nil
+		}
+
+		seg := data[i+4 : i+2+size]
+
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+
+		i += 2 + size
+	}
+
+	return nil
+} // }}}
+
+// func readExif {{{
+
+// Returns the text fields in IFD0 and the Exif sub-IFD of the TIFF data.
+func readExif(tiff []byte) map[exifField][]byte {
+	var bo binary.ByteOrder
+
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil
+	}
+
+	out := make(map[exifField][]byte)
+
+	read := func(ifd int, off uint32) (sub uint32) {
+		if uint64(off)+2 > uint64(len(tiff)) {
+			return 0
+		}
+
+		count := int(bo.Uint16(tiff[off:]))
+		pos := int(off) + 2
+
+		for i := 0; i < count && pos+12 <= len(tiff); i, pos = i+1, pos+12 {
+			tag := bo.Uint16(tiff[pos:])
+			typ := bo.Uint16(tiff[pos+2:])
+			n := bo.Uint32(tiff[pos+4:])
+
+			if ifd == exifIFD0 && tag == exifSubTag {
+				sub = bo.Uint32(tiff[pos+8:])
+				continue
+			}
+
+			if typ != exifASCII || n == 0 {
+				continue
+			}
+
+			// Up to 4 bytes are within the entry itself, otherwise it is the offset.
+			start := uint64(pos + 8)
+			if n > 4 {
+				start = uint64(bo.Uint32(tiff[pos+8:]))
+			}
+
+			if start+uint64(n) > uint64(len(tiff)) {
+				continue
+			}
+
+			out[exifField{ifd, tag}] = append([]byte(nil), tiff[start:start+uint64(n)]...)
+		}
+
+		return sub
+	}
+
+	if sub := read(exifIFD0, bo.Uint32(tiff[4:])); sub != 0 {
+		read(exifSub, sub)
+	}
+
+	return out
+} // }}}
+
+// func writeExif {{{
+
+// Returns little endian TIFF data with the values, adding the Exif sub-IFD if any are within it.
+func writeExif(values []exifValue) []byte {
+	var ifd0, sub []exifValue
+
+	for _, ev := range values {
+		if ev.ifd == exifSub {
+			sub = append(sub, ev)
+		} else {
+			ifd0 = append(ifd0, ev)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	binary.Write(buf, binary.LittleEndian, uint32(8))
+
+	// The pointer to the sub-IFD is one more entry within IFD0, its value is filled in once we know it.
+	pointer := len(sub) > 0
+	if pointer {
+		ifd0 = append(ifd0, exifValue{exifField{exifIFD0, exifSubTag}, nil})
+	}
+
+	at := writeIFD(buf, ifd0)
+
+	if pointer {
+		subStart := uint32(buf.Len())
+		writeIFD(buf, sub)
+
+		binary.LittleEndian.PutUint32(buf.Bytes()[at[exifSubTag]:], subStart)
+	}
+
+	return buf.Bytes()
+} // }}}
+
+// func writeIFD {{{
+
+// Writes the IFD with each value after it, returning where the value of each entry is for the pointer.
+func writeIFD(buf *bytes.Buffer, values []exifValue) map[uint16]int {
+	sort.Slice(values, func(i, j int) bool { return values[i].tag < values[j].tag })
+
+	at := make(map[uint16]int, len(values))
+
+	start := buf.Len()
+	data := uint32(start + 2 + 12*len(values) + 4)
+
+	var extra []byte
+
+	le := binary.LittleEndian
+	entry := make([]byte, 12)
+
+	binary.Write(buf, le, uint16(len(values)))
+
+	for _, ev := range values {
+		le.PutUint16(entry, ev.tag)
+		at[ev.tag] = buf.Len() + 8
+
+		if ev.tag == exifSubTag && ev.ifd == exifIFD0 {
+			// A LONG offset.
+			le.PutUint16(entry[2:], 4)
+			le.PutUint32(entry[4:], 1)
+			le.PutUint32(entry[8:], 0)
+			buf.Write(entry)
+			continue
+		}
+
+		le.PutUint16(entry[2:], exifASCII)
+		le.PutUint32(entry[4:], uint32(len(ev.value)))
+		le.PutUint32(entry[8:], 0)
+
+		if len(ev.value) <= 4 {
+			copy(entry[8:], ev.value)
+		} else {
+			le.PutUint32(entry[8:], data+uint32(len(extra)))
+			extra = append(extra, ev.value...)
+
+			// Values start on a word boundary.
+			if len(extra)%2 == 1 {
+				extra = append(extra, 0)
+			}
+		}
+
+		buf.Write(entry)
+	}
+
+	// No next IFD.
+	binary.Write(buf, le, uint32(0))
+
+	buf.Write(extra)
+
+	return at
+} // }}}
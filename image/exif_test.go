@@ -0,0 +1,218 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Returns a big endian TIFF with Make, Orientation, a GPS IFD and an Exif sub-IFD with DateTimeOriginal.
+func exifTIFF() []byte {
+	be := binary.BigEndian
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("MM\x00*")
+	binary.Write(buf, be, uint32(8))
+
+	entry := func(tag, typ uint16, count, value uint32) {
+		binary.Write(buf, be, tag)
+		binary.Write(buf, be, typ)
+		binary.Write(buf, be, count)
+		binary.Write(buf, be, value)
+	}
+
+	// IFD0 at 8, 4 entries, so its data starts at 8+2+48+4 = 62.
+	mk := "Canon EOS\x00"
+	dto := "2019:07:04 21:30:00\x00"
+
+	binary.Write(buf, be, uint16(4))
+	entry(0x010f, exifASCII, uint32(len(mk)), 62)
+	entry(0x0112, 3, 1, 6<<16)
+	entry(exifSubTag, 4, 1, 72)
+	entry(0x8825, 4, 1, 110)
+	binary.Write(buf, be, uint32(0))
+
+	// 62
+	buf.WriteString(mk)
+
+	// 72, the Exif sub-IFD with its data at 72+2+12+4 = 90.
+	binary.Write(buf, be, uint16(1))
+	entry(0x9003, exifASCII, uint32(len(dto)), 90)
+	binary.Write(buf, be, uint32(0))
+	buf.WriteString(dto)
+
+	// 110, the GPS IFD with GPSLatitudeRef.
+	binary.Write(buf, be, uint16(1))
+	entry(0x0001, exifASCII, 2, uint32('N')<<24)
+	binary.Write(buf, be, uint32(0))
+
+	return buf.Bytes()
+}
+
+// Returns a JPEG with the EXIF from exifTIFF().
+func exifJPEG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 10), 100, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := SaveImageJPEG(buf, img); err != nil {
+		t.Fatalf("SaveImageJPEG: %s", err)
+	}
+
+	jpg := buf.Bytes()
+
+	app1 := append([]byte("Exif\x00\x00"), exifTIFF()...)
+
+	out := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(out[4:], uint16(len(app1)+2))
+	out = append(out, app1...)
+
+	return append(out, jpg[2:]...)
+}
+
+// Returns the metadata chunks/segments within the WebP, PNG or JPEG.
+func metadataChunks(data []byte) []string {
+	var found []string
+
+	switch {
+	case bytes.HasPrefix(data, []byte("RIFF")):
+		for i := 12; i+8 <= len(data); {
+			id := string(data[i : i+4])
+			size := int(binary.LittleEndian.Uint32(data[i+4:]))
+
+			switch id {
+			case "EXIF", "XMP ", "ICCP":
+				found = append(found, id)
+			}
+
+			i += 8 + size + size%2
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		for i := 8; i+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[i:]))
+			id := string(data[i+4 : i+8])
+
+			switch id {
+			case "eXIf", "tEXt", "iTXt", "zTXt", "iCCP":
+				found = append(found, id)
+			}
+
+			i += 12 + size
+		}
+	case len(data) > 2 && data[0] == 0xff && data[1] == 0xd8:
+		for i := 2; i+4 <= len(data) && data[i] == 0xff && data[i+1] != 0xda; {
+			if data[i+1] >= 0xe1 && data[i+1] <= 0xef {
+				found = append(found, "APP")
+			}
+
+			i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		}
+	}
+
+	return found
+}
+
+func TestScrubMetadata(t *testing.T) {
+	orig := exifJPEG(t)
+
+	if got := metadataChunks(orig); len(got) != 1 {
+		t.Fatalf("original Expected [APP] != Got %v", got)
+	}
+
+	img, err := LoadReader(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("LoadReader: %s", err)
+	}
+
+	encoders := map[string]func(*bytes.Buffer) error{
+		"webp": func(buf *bytes.Buffer) error { return SaveImageWebP(buf, img) },
+		"png":  func(buf *bytes.Buffer) error { return SaveImagePNG(buf, img) },
+		"jpeg": func(buf *bytes.Buffer) error { return SaveImageJPEG(buf, img) },
+		"exif": func(buf *bytes.Buffer) error { return SaveImageWebPExif(buf, img, nil) },
+	}
+
+	for name, enc := range encoders {
+		buf := &bytes.Buffer{}
+		if err := enc(buf); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if got := metadataChunks(buf.Bytes()); len(got) != 0 {
+			t.Fatalf("%s Expected no metadata != Got %v", name, got)
+		}
+	}
+}
+
+func TestKeepExif(t *testing.T) {
+	orig := exifJPEG(t)
+
+	if err := ExifFields([]string{"Make", "datetimeoriginal"}); err != nil {
+		t.Fatalf("ExifFields: %s", err)
+	}
+
+	for _, name := range []string{"orientation", "gpslatitude"} {
+		if err := ExifFields([]string{name}); err == nil {
+			t.Fatalf("ExifFields accepted %q", name)
+		}
+	}
+
+	if got := KeepExif(orig, nil); got != nil {
+		t.Fatalf("KeepExif(nil) Expected nil != Got %q", got)
+	}
+
+	if got := KeepExif(orig, []string{"copyright"}); got != nil {
+		t.Fatalf("KeepExif(copyright) Expected nil != Got %q", got)
+	}
+
+	exif := KeepExif(orig, []string{"make", "DateTimeOriginal", "orientation"})
+
+	got := readExif(exif)
+
+	expected := map[exifField]string{
+		{exifIFD0, 0x010f}: "Canon EOS\x00",
+		{exifSub, 0x9003}:  "2019:07:04 21:30:00\x00",
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("KeepExif Expected %q != Got %q", expected, got)
+	}
+
+	for ef, value := range expected {
+		if string(got[ef]) != value {
+			t.Fatalf("KeepExif %v Expected %q != Got %q", ef, value, got[ef])
+		}
+	}
+
+	// Nothing else, such as GPS or the orientation.
+	if bytes.Contains(exif, []byte{0x88, 0x25}) || bytes.Contains(exif, []byte{0x25, 0x88}) {
+		t.Fatalf("KeepExif kept the GPS pointer")
+	}
+
+	if !WebPEncoder {
+		return
+	}
+
+	img, err := LoadReader(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("LoadReader: %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := SaveImageWebPExif(buf, img, exif); err != nil {
+		t.Fatalf("SaveImageWebPExif: %s", err)
+	}
+
+	if chunks := metadataChunks(buf.Bytes()); len(chunks) != 1 || chunks[0] != "EXIF" {
+		t.Fatalf("SaveImageWebPExif Expected [EXIF] != Got %v", chunks)
+	}
+
+	if _, err := LoadReader(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("SaveImageWebPExif LoadReader: %s", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build arm
+
+package image
+
+// Lanczos is painfully slow on 32-bit ARM such as the Pi Zero, linear is close enough for a frame.
+const DefaultFilter = "linear"
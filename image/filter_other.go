@@ -0,0 +1,6 @@
+//go:build !arm
+
+package image
+
+// The filter Resize() uses, see ResizeFilter() for the others.
+const DefaultFilter = "lanczos"
@@ -0,0 +1,114 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// A small file can claim to be an enormous image, such as a 100,000x100,000 PNG of a single color. Decoding it would
+// need 40GB of memory, so LoadReader() checks the size within the header against the limits first.
+
+// The largest image (in pixels) LoadReader() decodes unless changed by SetLimits(), 250 megapixels.
+//
+// About 1GB once decoded, far larger then any camera but leaves room for stitched panoramas.
+const DefaultMaxPixels = 250 * 1000 * 1000
+
+// Returned (wrapped in a *LimitError) by LoadReader() for an image over the limits.
+var ErrTooLarge = errors.New("image too large")
+
+// Set by SetLimits(), access only with atomics.
+var maxPixels int64 = DefaultMaxPixels
+var maxBytes int64
+
+// type LimitError struct {{{
+
+// Returned by LoadReader() when the image is over one of the limits, see SetLimits().
+//
+// errors.Is(err, ErrTooLarge) is true for any of them.
+type LimitError struct {
+	// Either "pixels" or "bytes".
+	Limit string
+
+	// What the image has, or for bytes how many were read before stopping.
+	Size int64
+	Max  int64
+} // }}}
+
+// func LimitError.Error {{{
+
+func (le *LimitError) Error() string {
+	return fmt.Sprintf("image too large, %d %s is over the limit of %d", le.Size, le.Limit, le.Max)
+} // }}}
+
+// func LimitError.Unwrap {{{
+
+func (le *LimitError) Unwrap() error {
+	return ErrTooLarge
+} // }}}
+
+// func SetLimits {{{
+
+// Sets the limits checked by LoadReader() before decoding, 0 for no limit.
+//
+// pixels is the width times the height, bytes the size of the encoded image read. Applies to every LoadReader()
+// from then on, such as after the CacheManager configuration is loaded.
+func SetLimits(pixels, bytes int64) error {
+	if pixels < 0 || bytes < 0 {
+		return errors.New("limits can not be negative")
+	}
+
+	atomic.StoreInt64(&maxPixels, pixels)
+	atomic.StoreInt64(&maxBytes, bytes)
+
+	return nil
+} // }}}
+
+// func checkPixels {{{
+
+// Returns a *LimitError if the size is over the limit.
+func checkPixels(width, height int) error {
+	limit := atomic.LoadInt64(&maxPixels)
+
+	if pixels := int64(width) * int64(height); limit > 0 && pixels > limit {
+		return &LimitError{Limit: "pixels", Size: pixels, Max: limit}
+	}
+
+	return nil
+} // }}}
+
+// type limitReader struct {{{
+
+// Stops reading with a *LimitError once more then max bytes have been read.
+type limitReader struct {
+	r    io.Reader
+	read int64
+	max  int64
+
+	// Set once over, as a decoder may replace the error with its own.
+	over *LimitError
+} // }}}
+
+// func limitReader.Read {{{
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.over != nil {
+		return 0, lr.over
+	}
+
+	// One more then allowed, so we know it is over rather then exactly at.
+	if left := lr.max + 1 - lr.read; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+
+	if lr.read > lr.max {
+		lr.over = &LimitError{Limit: "bytes", Size: lr.read, Max: lr.max}
+		return n, lr.over
+	}
+
+	return n, err
+} // }}}
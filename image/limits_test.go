@@ -0,0 +1,68 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// Returns a PNG header claiming to be width by height, with nothing after it.
+func pngBomb(t *testing.T, width, height uint32) []byte {
+	data := encodeFixture(t, "png", gray16Gradient(0, 0))
+
+	// The IHDR is always first, its data right after the 8 byte signature and 8 byte chunk header.
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	return data[:33]
+}
+
+func TestLimits(t *testing.T) {
+	defer SetLimits(DefaultMaxPixels, 0)
+
+	var le *LimitError
+
+	_, err := LoadReader(bytes.NewReader(pngBomb(t, 100000, 100000)))
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &le) || le.Limit != "pixels" {
+		t.Fatalf("LoadReader Expected pixels *LimitError != Got %v", err)
+	}
+
+	// Overflows an int on 32 bit.
+	bomb := int64(100000) * 100000
+
+	if le.Size != bomb || le.Max != DefaultMaxPixels {
+		t.Fatalf("LimitError Expected %d > %d != Got %d > %d", bomb, DefaultMaxPixels, le.Size, le.Max)
+	}
+
+	data := encodeFixture(t, "png", gray16Gradient(0x1000, 0xf000))
+
+	// Within both limits it loads the same as always.
+	if err := SetLimits(64*64, int64(len(data))); err != nil {
+		t.Fatalf("SetLimits: %s", err)
+	}
+
+	if _, err := LoadReader(bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadReader: %s", err)
+	}
+
+	// One pixel less.
+	SetLimits(64*64-1, 0)
+
+	if _, err := LoadReader(bytes.NewReader(data)); !errors.As(err, &le) || le.Limit != "pixels" {
+		t.Fatalf("LoadReader Expected pixels *LimitError != Got %v", err)
+	}
+
+	// Half the file.
+	SetLimits(0, int64(len(data)/2))
+
+	if _, err := LoadReader(bytes.NewReader(data)); !errors.As(err, &le) || le.Limit != "bytes" {
+		t.Fatalf("LoadReader Expected bytes *LimitError != Got %v", err)
+	}
+
+	if err := SetLimits(-1, 0); err == nil {
+		t.Fatalf("SetLimits accepted a negative limit")
+	}
+}
@@ -0,0 +1,181 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Binary PPM (P6) and PGM (P5) images, as written by dcraw and the netpbm tools.
+//
+// Only needed for the external decoders (see imgproc confBaseYAML.Commands), which commonly write these to stdout.
+func init() {
+	image.RegisterFormat("ppm", "P6", decodePNM, decodePNMConfig)
+	image.RegisterFormat("pgm", "P5", decodePNM, decodePNMConfig)
+}
+
+// type pnmHeader struct {{{
+
+type pnmHeader struct {
+	gray   bool
+	width  int
+	height int
+	maxval int
+} // }}}
+
+// func readPNMHeader {{{
+
+func readPNMHeader(br *bufio.Reader) (*pnmHeader, error) {
+	var vals [3]int
+
+	magic, err := pnmToken(br)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &pnmHeader{}
+
+	switch magic {
+	case "P5":
+		h.gray = true
+	case "P6":
+	default:
+		return nil, errors.New("pnm: not a binary PPM or PGM")
+	}
+
+	for i := range vals {
+		tok, err := pnmToken(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := fmt.Sscanf(tok, "%d", &vals[i]); err != nil || vals[i] < 1 {
+			return nil, errors.New("pnm: invalid header")
+		}
+	}
+
+	h.width, h.height, h.maxval = vals[0], vals[1], vals[2]
+
+	if h.maxval > 65535 {
+		return nil, errors.New("pnm: invalid maxval")
+	}
+
+	// Exactly one whitespace byte between the header and the pixels, which pnmToken() already consumed.
+	return h, nil
+} // }}}
+
+// func pnmToken {{{
+
+// Returns the next whitespace separated token of the header, skipping any comments.
+//
+// Consumes the single whitespace byte after the token.
+func pnmToken(br *bufio.Reader) (string, error) {
+	var tok []byte
+
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(tok) > 0 {
+				return string(tok), nil
+			}
+
+			return "", err
+		}
+
+		switch {
+		case c == '#' && len(tok) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, c)
+		}
+	}
+} // }}}
+
+// func decodePNMConfig {{{
+
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	cm := color.NRGBAModel
+	if h.gray {
+		cm = color.GrayModel
+	}
+
+	return image.Config{ColorModel: cm, Width: h.width, Height: h.height}, nil
+} // }}}
+
+// func decodePNM {{{
+
+func decodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	h, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	chans := 3
+	if h.gray {
+		chans = 1
+	}
+
+	// Values above 255 take 2 bytes, most significant first.
+	bps := 1
+	if h.maxval > 255 {
+		bps = 2
+	}
+
+	row := make([]byte, h.width*chans*bps)
+	img := image.NewNRGBA(image.Rect(0, 0, h.width, h.height))
+
+	// Scales a sample to 0-255.
+	sample := func(b []byte) uint8 {
+		v := int(b[0])
+		if bps == 2 {
+			v = v<<8 | int(b[1])
+		}
+
+		if v > h.maxval {
+			v = h.maxval
+		}
+
+		return uint8(v * 255 / h.maxval)
+	}
+
+	for y := 0; y < h.height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("pnm: %s", err)
+		}
+
+		pix := img.Pix[y*img.Stride:]
+
+		for x := 0; x < h.width; x++ {
+			s := row[x*chans*bps:]
+
+			if h.gray {
+				g := sample(s)
+				pix[x*4], pix[x*4+1], pix[x*4+2] = g, g, g
+			} else {
+				pix[x*4] = sample(s)
+				pix[x*4+1] = sample(s[bps:])
+				pix[x*4+2] = sample(s[2*bps:])
+			}
+
+			pix[x*4+3] = 0xff
+		}
+	}
+
+	return img, nil
+} // }}}
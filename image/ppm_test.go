@@ -0,0 +1,45 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDecodePNM(t *testing.T) {
+	// 2x1, a comment in the header, red and a half-bright blue.
+	ppm := append([]byte("P6\n# dcraw\n2 1\n255\n"), 255, 0, 0, 0, 0, 128)
+
+	img, format, err := image.Decode(bytes.NewReader(ppm))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+
+	if format != "ppm" || img.Bounds().Dx() != 2 || img.Bounds().Dy() != 1 {
+		t.Fatalf("Decode = %s %v", format, img.Bounds())
+	}
+
+	if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("pixel 0 = %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+
+	if _, _, b, _ := img.At(1, 0).RGBA(); b>>8 != 128 {
+		t.Errorf("pixel 1 blue = %d", b>>8)
+	}
+
+	// 16 bit gray, maxval 65535.
+	pgm := append([]byte("P5 1 1 65535\n"), 0xff, 0xff)
+
+	if img, _, err = image.Decode(bytes.NewReader(pgm)); err != nil {
+		t.Fatalf("Decode pgm: %s", err)
+	}
+
+	if r, _, _, _ := img.At(0, 0).RGBA(); r>>8 != 255 {
+		t.Errorf("pgm pixel = %d", r>>8)
+	}
+
+	// Truncated pixels.
+	if _, _, err := image.Decode(bytes.NewReader(ppm[:len(ppm)-1])); err == nil {
+		t.Errorf("Decode accepted a truncated image")
+	}
+}
@@ -0,0 +1,220 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+)
+
+// Returned by RawPreview() when no usable embedded preview could be found.
+var ErrNoPreview = errors.New("no embedded preview found")
+
+// TIFF tags we care about when hunting for an embedded preview.
+const (
+	tiffStripOffsets    = 0x0111
+	tiffStripByteCounts = 0x0117
+	tiffSubIFDs         = 0x014a
+	tiffJPEGOffset      = 0x0201
+	tiffJPEGLength      = 0x0202
+)
+
+// type rawTIFF struct {{{
+
+// Just enough of a TIFF parser to walk the IFDs of a RAW file.
+type rawTIFF struct {
+	data []byte
+	bo   binary.ByteOrder
+
+	// IFD offsets we have already walked, so a corrupt (or malicious) file can't loop us forever.
+	seen map[uint32]bool
+
+	// Candidate previews found, as offset/length pairs in data.
+	cands [][2]uint32
+} // }}}
+
+// func RawPreview {{{
+
+// Given a reader for a TIFF based RAW file (.cr2, .nef, .arw, .dng) this returns the
+// largest embedded JPEG preview found within.
+//
+// Nearly every camera embeds a full (or close to full) resolution JPEG in the RAW file for
+// its own display, which is more then good enough for us to display, so we use that rather
+// then trying to actually develop the RAW data ourselves.
+//
+// The whole file is read into memory, as the previews can be anywhere within the file.
+func RawPreview(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8 {
+		return nil, ErrNoPreview
+	}
+
+	rt := &rawTIFF{
+		data: data,
+		seen: make(map[uint32]bool, 4),
+	}
+
+	switch string(data[0:2]) {
+	case "II":
+		rt.bo = binary.LittleEndian
+	case "MM":
+		rt.bo = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF based RAW file")
+	}
+
+	// Walk IFD0 and everything chained off of it.
+	rt.walk(rt.bo.Uint32(data[4:8]), 0)
+
+	var best []byte
+	var bestArea int
+
+	// Now check each of the candidates, keeping the largest one that actually decodes.
+	//
+	// Note that the RAW data itself can be stored as a lossless JPEG, which also starts
+	// with the JPEG SOI marker. Go can not decode those, so DecodeConfig() weeds them out for us.
+	for _, cand := range rt.cands {
+		off, length := uint64(cand[0]), uint64(cand[1])
+		if length < 4 || off+length > uint64(len(data)) {
+			continue
+		}
+
+		jp := data[off : off+length]
+		if jp[0] != 0xff || jp[1] != 0xd8 {
+			continue
+		}
+
+		ic, err := jpeg.DecodeConfig(bytes.NewReader(jp))
+		if err != nil {
+			continue
+		}
+
+		if area := ic.Width * ic.Height; area > bestArea {
+			bestArea = area
+			best = jp
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoPreview
+	}
+
+	return best, nil
+} // }}}
+
+// func rawTIFF.walk {{{
+
+// Walks the IFD at off, any SubIFDs it contains and the next IFD in the chain.
+func (rt *rawTIFF) walk(off uint32, depth int) {
+	// Sanity - Nothing valid nests this deep.
+	if depth > 4 {
+		return
+	}
+
+	for off != 0 && !rt.seen[off] {
+		rt.seen[off] = true
+
+		// Do not bother with files having an absurd amount of IFDs.
+		if len(rt.seen) > 64 {
+			return
+		}
+
+		if uint64(off)+2 > uint64(len(rt.data)) {
+			return
+		}
+
+		count := uint64(rt.bo.Uint16(rt.data[off:]))
+		start := uint64(off) + 2
+
+		if start+count*12+4 > uint64(len(rt.data)) {
+			return
+		}
+
+		var jOff, jLen, sOff, sLen uint32
+		var subs []uint32
+
+		for i := uint64(0); i < count; i++ {
+			ent := rt.data[start+i*12 : start+i*12+12]
+			tag := rt.bo.Uint16(ent[0:])
+			typ := rt.bo.Uint16(ent[2:])
+			cnt := rt.bo.Uint32(ent[4:])
+
+			switch tag {
+			case tiffJPEGOffset:
+				jOff = rt.value(ent, typ)
+			case tiffJPEGLength:
+				jLen = rt.value(ent, typ)
+			case tiffStripOffsets:
+				// We only care about single strips, previews are never split.
+				if cnt == 1 {
+					sOff = rt.value(ent, typ)
+				}
+			case tiffStripByteCounts:
+				if cnt == 1 {
+					sLen = rt.value(ent, typ)
+				}
+			case tiffSubIFDs:
+				subs = rt.offsets(ent, cnt)
+			}
+		}
+
+		if jOff != 0 && jLen != 0 {
+			rt.cands = append(rt.cands, [2]uint32{jOff, jLen})
+		}
+
+		if sOff != 0 && sLen != 0 {
+			rt.cands = append(rt.cands, [2]uint32{sOff, sLen})
+		}
+
+		for _, sub := range subs {
+			rt.walk(sub, depth+1)
+		}
+
+		// Next IFD in the chain.
+		off = rt.bo.Uint32(rt.data[start+count*12:])
+	}
+} // }}}
+
+// func rawTIFF.value {{{
+
+// Returns the single SHORT or LONG value stored within an IFD entry.
+func (rt *rawTIFF) value(ent []byte, typ uint16) uint32 {
+	// 3 is SHORT, everything else we treat as a LONG.
+	if typ == 3 {
+		return uint32(rt.bo.Uint16(ent[8:]))
+	}
+
+	return rt.bo.Uint32(ent[8:])
+} // }}}
+
+// func rawTIFF.offsets {{{
+
+// Returns the list of LONG offsets in an IFD entry, such as the SubIFDs.
+func (rt *rawTIFF) offsets(ent []byte, cnt uint32) []uint32 {
+	if cnt == 0 || cnt > 16 {
+		return nil
+	}
+
+	// A single value fits within the entry itself.
+	if cnt == 1 {
+		return []uint32{rt.bo.Uint32(ent[8:])}
+	}
+
+	at := uint64(rt.bo.Uint32(ent[8:]))
+	if at+uint64(cnt)*4 > uint64(len(rt.data)) {
+		return nil
+	}
+
+	offs := make([]uint32, 0, cnt)
+	for i := uint64(0); i < uint64(cnt); i++ {
+		offs = append(offs, rt.bo.Uint32(rt.data[at+i*4:]))
+	}
+
+	return offs
+} // }}}
@@ -0,0 +1,85 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// Encodes a plain JPEG of the given size for embedding.
+func testJPEG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatalf("jpeg.Encode: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// Appends a single IFD entry.
+func testEntry(b []byte, tag, typ uint16, cnt, val uint32) []byte {
+	var ent [12]byte
+
+	binary.LittleEndian.PutUint16(ent[0:], tag)
+	binary.LittleEndian.PutUint16(ent[2:], typ)
+	binary.LittleEndian.PutUint32(ent[4:], cnt)
+	binary.LittleEndian.PutUint32(ent[8:], val)
+
+	return append(b, ent[:]...)
+}
+
+func TestRawPreview(t *testing.T) {
+	small := testJPEG(t, 8, 8)
+	large := testJPEG(t, 32, 16)
+
+	// Layout -
+	//
+	//   0: Header
+	//   8: IFD0 with a JPEGInterchangeFormat (small) and a SubIFD
+	//  46: SubIFD with a single strip (large)
+	//  72: small, followed by large
+	ifd0 := uint32(8)
+	sub := ifd0 + 2 + 3*12 + 4
+	smallOff := sub + 2 + 2*12 + 4
+	largeOff := smallOff + uint32(len(small))
+
+	raw := []byte{'I', 'I', 42, 0}
+	raw = append(raw, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(raw[4:], ifd0)
+
+	raw = append(raw, 3, 0)
+	raw = testEntry(raw, tiffSubIFDs, 4, 1, sub)
+	raw = testEntry(raw, tiffJPEGOffset, 4, 1, smallOff)
+	raw = testEntry(raw, tiffJPEGLength, 4, 1, uint32(len(small)))
+	raw = append(raw, 0, 0, 0, 0)
+
+	raw = append(raw, 2, 0)
+	raw = testEntry(raw, tiffStripOffsets, 4, 1, largeOff)
+	raw = testEntry(raw, tiffStripByteCounts, 4, 1, uint32(len(large)))
+	raw = append(raw, 0, 0, 0, 0)
+
+	raw = append(raw, small...)
+	raw = append(raw, large...)
+
+	preview, err := RawPreview(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("RawPreview: %s", err)
+	}
+
+	if !bytes.Equal(preview, large) {
+		t.Fatalf("Expected the largest preview (%d bytes), got %d bytes", len(large), len(preview))
+	}
+
+	// Not a TIFF at all.
+	if _, err := RawPreview(bytes.NewReader(small)); err == nil {
+		t.Fatalf("Expected error for non-TIFF input")
+	}
+
+	// A valid TIFF but without any preview.
+	if _, err := RawPreview(bytes.NewReader(raw[:smallOff])); err != ErrNoPreview {
+		t.Fatalf("Expected ErrNoPreview, got %v", err)
+	}
+}
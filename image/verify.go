@@ -0,0 +1,69 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Returned (wrapped) by Verify for an image that decoded without error, but is most likely not what the file should be.
+var ErrSuspect = errors.New("suspect image")
+
+// The widest (or tallest) an image can be relative to its other side before Verify considers it suspect.
+//
+// Even the widest panoramas are well under this, where as a truncated file decoded by something that does not
+// complain can leave only a sliver of rows.
+const MaxAspect = 32
+
+// Images smaller then this in either dimension are too small to judge as being a single color.
+const minUniform = 64
+
+// func Verify {{{
+
+// Checks an image that decoded without error actually looks like an image, rather then garbage that would only ever
+// be shown as a black square.
+//
+// Such as empty bounds, an extreme aspect ratio, or a single color throughout (what some decoders give for a
+// truncated or corrupt file).
+//
+// Returns an error wrapping ErrSuspect saying why, or nil if the image looks fine.
+func Verify(img image.Image) error {
+	size := img.Bounds().Size()
+
+	if size.X <= 0 || size.Y <= 0 {
+		return fmt.Errorf("%w: empty bounds %s", ErrSuspect, size)
+	}
+
+	if size.X/size.Y >= MaxAspect || size.Y/size.X >= MaxAspect {
+		return fmt.Errorf("%w: aspect ratio of %s", ErrSuspect, size)
+	}
+
+	if size.X >= minUniform && size.Y >= minUniform && uniform(img) {
+		return fmt.Errorf("%w: a single color throughout", ErrSuspect)
+	}
+
+	return nil
+} // }}}
+
+// func uniform {{{
+
+// Returns true if a 16x16 grid of pixels sampled across the image are all the same color.
+func uniform(img image.Image) bool {
+	b := img.Bounds()
+
+	r0, g0, b0, a0 := img.At(b.Min.X, b.Min.Y).RGBA()
+
+	for y := 0; y < 16; y++ {
+		py := b.Min.Y + y*(b.Dy()-1)/15
+
+		for x := 0; x < 16; x++ {
+			px := b.Min.X + x*(b.Dx()-1)/15
+
+			if r, g, b, a := img.At(px, py).RGBA(); r != r0 || g != g0 || b != b0 || a != a0 {
+				return false
+			}
+		}
+	}
+
+	return true
+} // }}}
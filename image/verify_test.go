@@ -0,0 +1,40 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	photo := image.NewNRGBA(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			photo.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	tests := []struct {
+		Name    string
+		Img     image.Image
+		Suspect bool
+	}{
+		{"photo", photo, false},
+		{"empty", image.NewNRGBA(image.Rect(0, 0, 0, 0)), true},
+		{"sliver", photo.SubImage(image.Rect(0, 0, 100, 3)), true},
+		{"tall", photo.SubImage(image.Rect(0, 0, 2, 80)), true},
+		{"black", image.NewNRGBA(image.Rect(0, 0, 100, 80)), true},
+
+		// Too small to call a single color suspect.
+		{"icon", image.NewNRGBA(image.Rect(0, 0, 16, 16)), false},
+	}
+
+	for _, test := range tests {
+		err := Verify(test.Img)
+
+		if got := errors.Is(err, ErrSuspect); got != test.Suspect {
+			t.Errorf("Verify %s Expected %v != Got %v (%v)", test.Name, test.Suspect, got, err)
+		}
+	}
+}
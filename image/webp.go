@@ -0,0 +1,50 @@
+//go:build !nowebp
+
+package image
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// If SaveImageWebP() actually writes WebP, see webp_nowebp.go.
+const WebPEncoder = true
+
+// func SaveImageWebP {{{
+
+func SaveImageWebP(w io.Writer, img image.Image) error {
+	return webp.Encode(w, img, &webp.Options{Lossless: true})
+} // }}}
+
+// func SaveImageWebPLossy {{{
+
+// The same as SaveImageWebP(), though lossy at the quality (0 to 100) to make the file smaller.
+func SaveImageWebPLossy(w io.Writer, img image.Image, quality float32) error {
+	return webp.Encode(w, img, &webp.Options{Quality: quality})
+} // }}}
+
+// func SaveImageWebPExif {{{
+
+// The same as SaveImageWebP(), with the EXIF (TIFF format, such as from KeepExif()) added. nil adds nothing.
+func SaveImageWebPExif(w io.Writer, img image.Image, exif []byte) error {
+	if len(exif) == 0 {
+		return SaveImageWebP(w, img)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := SaveImageWebP(buf, img); err != nil {
+		return err
+	}
+
+	data, err := webp.SetMetadata(buf.Bytes(), exif, "EXIF")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+} // }}}
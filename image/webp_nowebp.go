@@ -0,0 +1,41 @@
+//go:build nowebp
+
+package image
+
+import (
+	"image"
+	"image/png"
+	"io"
+
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+// Built with the "nowebp" tag, leaving out the cgo WebP library for smaller targets or those without a C
+// cross compiler.
+//
+// WebP is still decoded, by the pure Go decoder. It can not be encoded though, so SaveImageWebP() writes a PNG
+// instead. Everything reading it back uses LoadReader() which goes by the contents, so only the file names are
+// wrong, such as the .webp files in the imagecache.
+const WebPEncoder = false
+
+// func SaveImageWebP {{{
+
+func SaveImageWebP(w io.Writer, img image.Image) error {
+	// Speed matters more then size on the hardware this is built for.
+	return imaging.Encode(w, img, imaging.PNG, imaging.PNGCompressionLevel(png.BestSpeed))
+} // }}}
+
+// func SaveImageWebPLossy {{{
+
+// Without a WebP encoder there is no quality to lower, so this is the same as SaveImageWebP().
+func SaveImageWebPLossy(w io.Writer, img image.Image, quality float32) error {
+	return SaveImageWebP(w, img)
+} // }}}
+
+// func SaveImageWebPExif {{{
+
+// As SaveImageWebP() writes a PNG, the EXIF is left out.
+func SaveImageWebPExif(w io.Writer, img image.Image, exif []byte) error {
+	return SaveImageWebP(w, img)
+} // }}}
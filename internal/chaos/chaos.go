@@ -0,0 +1,290 @@
+// Fault injection for development and testing, so the handling of failures can be exercised rather then only
+// discovered in production.
+//
+// Only enabled by the FRAME_CHAOS environment variable (or Set() from a test), a comma separated list of -
+//
+//	fs=0.05        Fraction of file system operations (open, stat, readdir) of the bases that fail.
+//	dbdelay=500ms  Delay added to acquiring a database connection.
+//	dbdelayrate=1  Fraction of acquires that are delayed, default 1 (all of them) when dbdelay is set.
+//	dbdrop=0.01    Fraction of acquires that get a connection that was just dropped, so whatever it runs fails.
+//	seed=1         Seed for the random choices, so a run can be repeated. Default is the current time.
+//
+// Such as FRAME_CHAOS="fs=0.01,dbdelay=2s,dbdelayrate=0.1,dbdrop=0.02".
+//
+// Injected file system failures return ErrInjected within an *fs.PathError, database failures are whatever pgx
+// returns for a closed connection.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrInjected = errors.New("chaos: injected failure")
+
+// type Config struct {{{
+
+type Config struct {
+	FS float64
+
+	DBDelay     time.Duration
+	DBDelayRate float64
+	DBDrop      float64
+
+	Seed int64
+} // }}}
+
+var (
+	// Protects everything below.
+	mut sync.Mutex
+
+	// nil unless enabled.
+	conf *Config
+	r    *rand.Rand
+)
+
+// func init {{{
+
+func init() {
+	env := os.Getenv("FRAME_CHAOS")
+	if env == "" {
+		return
+	}
+
+	co, err := Parse(env)
+	if err != nil {
+		// Nowhere better to say so, no logger exists yet.
+		fmt.Fprintf(os.Stderr, "FRAME_CHAOS ignored: %s\n", err)
+		return
+	}
+
+	Set(co)
+} // }}}
+
+// func Parse {{{
+
+// Parses the FRAME_CHAOS format, see the package documentation.
+func Parse(in string) (*Config, error) {
+	var err error
+
+	co := &Config{
+		Seed:        time.Now().UnixNano(),
+		DBDelayRate: -1,
+	}
+
+	for _, part := range strings.Split(in, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		eq := strings.IndexByte(part, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("%q is not key=value", part)
+		}
+
+		key, val := part[:eq], part[eq+1:]
+
+		switch key {
+		case "fs":
+			co.FS, err = parseRate(val)
+		case "dbdelay":
+			co.DBDelay, err = time.ParseDuration(val)
+		case "dbdelayrate":
+			co.DBDelayRate, err = parseRate(val)
+		case "dbdrop":
+			co.DBDrop, err = parseRate(val)
+		case "seed":
+			co.Seed, err = strconv.ParseInt(val, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	if co.DBDelayRate == -1 {
+		co.DBDelayRate = 1
+	}
+
+	return co, nil
+} // }}}
+
+// func parseRate {{{
+
+func parseRate(in string) (float64, error) {
+	f, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if f < 0 || f > 1 {
+		return 0, errors.New("needs to be between 0 and 1")
+	}
+
+	return f, nil
+} // }}}
+
+// func Set {{{
+
+// Enables fault injection with the Config, or disables it if nil.
+//
+// Only affects what is wrapped after, FS() and Pool() do nothing while disabled.
+func Set(co *Config) {
+	mut.Lock()
+	defer mut.Unlock()
+
+	conf = co
+	r = nil
+
+	if co != nil {
+		r = rand.New(rand.NewSource(co.Seed))
+	}
+} // }}}
+
+// func Enabled {{{
+
+func Enabled() bool {
+	mut.Lock()
+	defer mut.Unlock()
+
+	return conf != nil
+} // }}}
+
+// func roll {{{
+
+// Returns true the fraction of the time rate (taken from the Config) says to.
+func roll(rate func(co *Config) float64) bool {
+	mut.Lock()
+	defer mut.Unlock()
+
+	if conf == nil {
+		return false
+	}
+
+	rt := rate(conf)
+
+	return rt > 0 && r.Float64() < rt
+} // }}}
+
+// func dbDelay {{{
+
+// Returns how long to delay acquiring a database connection, 0 for no delay.
+func dbDelay() time.Duration {
+	mut.Lock()
+	defer mut.Unlock()
+
+	if conf == nil || conf.DBDelay <= 0 || r.Float64() >= conf.DBDelayRate {
+		return 0
+	}
+
+	return conf.DBDelay
+} // }}}
+
+// func dbDrop {{{
+
+func dbDrop(co *Config) float64 {
+	return co.DBDrop
+} // }}}
+
+// type chaosFS struct {{{
+
+type chaosFS struct {
+	fsys fs.FS
+} // }}}
+
+// func FS {{{
+
+// Wraps a file system so its operations fail randomly, or returns it as is when disabled.
+//
+// The files themselves are not wrapped, so are still whatever fsys returns (such as supporting io.ReaderAt).
+func FS(fsys fs.FS) fs.FS {
+	if !Enabled() {
+		return fsys
+	}
+
+	return &chaosFS{fsys: fsys}
+} // }}}
+
+// func fsFail {{{
+
+func fsFail(co *Config) float64 {
+	return co.FS
+} // }}}
+
+// func chaosFS.Open {{{
+
+func (c *chaosFS) Open(name string) (fs.File, error) {
+	if roll(fsFail) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrInjected}
+	}
+
+	return c.fsys.Open(name)
+} // }}}
+
+// func chaosFS.Stat {{{
+
+func (c *chaosFS) Stat(name string) (fs.FileInfo, error) {
+	if roll(fsFail) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: ErrInjected}
+	}
+
+	return fs.Stat(c.fsys, name)
+} // }}}
+
+// func chaosFS.ReadDir {{{
+
+func (c *chaosFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if roll(fsFail) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrInjected}
+	}
+
+	return fs.ReadDir(c.fsys, name)
+} // }}}
+
+// func Pool {{{
+
+// Sets up the pool configuration so acquiring a connection is randomly delayed or gets a dropped connection, does
+// nothing when disabled.
+//
+// Any BeforeAcquire already set is kept, and runs after.
+func Pool(pc *pgxpool.Config) {
+	if !Enabled() {
+		return
+	}
+
+	prev := pc.BeforeAcquire
+
+	pc.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+		if delay := dbDelay(); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+		}
+
+		// Closing the network connection out from under pgx, the same as the server or network going away.
+		if roll(dbDrop) {
+			conn.PgConn().Conn().Close()
+		}
+
+		if prev != nil {
+			return prev(ctx, conn)
+		}
+
+		return true
+	}
+} // }}}
@@ -0,0 +1,62 @@
+package chaos
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	co, err := Parse("fs=0.05, dbdelay=500ms,dbdrop=0.01,seed=7")
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+
+	if co.FS != 0.05 || co.DBDelay != 500*time.Millisecond || co.DBDelayRate != 1 || co.DBDrop != 0.01 || co.Seed != 7 {
+		t.Fatalf("Parse Got %+v", co)
+	}
+
+	for _, bad := range []string{"fs", "fs=2", "dbdelay=soon", "bogus=1"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse accepted %q", bad)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	defer Set(nil)
+
+	base := fstest.MapFS{"a.jpg": &fstest.MapFile{}}
+
+	Set(nil)
+
+	// Disabled, so not even wrapped.
+	if _, ok := FS(base).(fstest.MapFS); !ok {
+		t.Fatalf("FS wrapped while disabled")
+	}
+
+	Set(&Config{FS: 1, Seed: 1})
+
+	cfs := FS(base)
+
+	if _, err := cfs.Open("a.jpg"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Open Expected ErrInjected != Got %v", err)
+	}
+
+	if _, err := fs.Stat(cfs, "a.jpg"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Stat Expected ErrInjected != Got %v", err)
+	}
+
+	if _, err := fs.ReadDir(cfs, "."); !errors.Is(err, ErrInjected) {
+		t.Errorf("ReadDir Expected ErrInjected != Got %v", err)
+	}
+
+	// Never failing, everything passes through.
+	Set(&Config{Seed: 1})
+
+	if _, err := FS(base).Open("a.jpg"); err != nil {
+		t.Errorf("Open Expected no error != Got %v", err)
+	}
+}
@@ -0,0 +1,562 @@
+// Tags images by what they show, using a local image classification model.
+//
+// Every Interval a batch of images not yet classified is loaded from the CacheManager and handed to the model runner
+// (see confYAML.Command). Each label scoring at least the Threshold becomes a tag such as "auto:dog", saved for the
+// hash as a whole in the files.autotags table. CMerge then combines them with the tags of the files (see its auto
+// queries), so they work with tag rules and profiles like any other tag.
+//
+// A library with no tags of its own can then still have meaningful profiles.
+package classify
+
+import (
+	"context"
+	"errors"
+	"frame/internal/modelrun"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/internal/scheduler"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inB.Database != "" {
+		inA.Database = inB.Database
+	}
+
+	if inB.Queries.Pending != "" {
+		inA.Queries.Pending = inB.Queries.Pending
+	}
+
+	if inB.Queries.Save != "" {
+		inA.Queries.Save = inB.Queries.Save
+	}
+
+	if len(inB.Command) > 0 {
+		inA.Command = inB.Command
+	}
+
+	// The rest always have a value once converted, so only one not the default replaces it.
+	if inB.Threshold != defThreshold {
+		inA.Threshold = inB.Threshold
+	}
+
+	if inB.BatchSize != defBatchSize {
+		inA.BatchSize = inB.BatchSize
+	}
+
+	if inB.Size != defSize {
+		inA.Size = inB.Size
+	}
+
+	if inB.Namespace != defNamespace {
+		inA.Namespace = inB.Namespace
+	}
+
+	if inB.Source != defSource {
+		inA.Source = inB.Source
+	}
+
+	if inB.Interval != defInterval {
+		inA.Interval = inB.Interval
+	}
+
+	if inB.Timeout != defTimeout {
+		inA.Timeout = inB.Timeout
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Database != newConf.Database || origConf.Queries != newConf.Queries {
+		return true
+	}
+
+	if strings.Join(origConf.Command, "\x00") != strings.Join(newConf.Command, "\x00") {
+		return true
+	}
+
+	if origConf.Threshold != newConf.Threshold || origConf.BatchSize != newConf.BatchSize || origConf.Size != newConf.Size {
+		return true
+	}
+
+	if origConf.Namespace != newConf.Namespace || origConf.Source != newConf.Source {
+		return true
+	}
+
+	if origConf.Interval != newConf.Interval || origConf.Timeout != newConf.Timeout {
+		return true
+	}
+
+	return false
+} // }}}
+
+// Defaults for everything in confYAML not set.
+const (
+	defThreshold = 0.5
+	defBatchSize = 16
+	defSize      = 224
+	defNamespace = "auto"
+	defSource    = "classify"
+	defInterval  = time.Minute
+	defTimeout   = 5 * time.Minute
+)
+
+// func yconfConvert {{{
+
+func yconfConvert(inInt interface{}) (interface{}, error) {
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		Database:  in.Database,
+		Queries:   in.Queries,
+		Threshold: in.Threshold,
+		BatchSize: in.BatchSize,
+		Size:      in.Size,
+		Namespace: strings.TrimSpace(in.Namespace),
+		Source:    strings.TrimSpace(in.Source),
+		Interval:  time.Duration(in.Interval),
+		Timeout:   time.Duration(in.Timeout),
+	}
+
+	for _, arg := range in.Command {
+		out.Command = append(out.Command, strings.ReplaceAll(arg, "{model}", in.Model))
+	}
+
+	if out.Threshold == 0 {
+		out.Threshold = defThreshold
+	}
+
+	if out.Threshold < 0 || out.Threshold > 1 {
+		return nil, errors.New("threshold must be between 0 and 1")
+	}
+
+	if out.BatchSize == 0 {
+		out.BatchSize = defBatchSize
+	}
+
+	if out.BatchSize < 1 || out.BatchSize > 1000 {
+		return nil, errors.New("batchsize must be between 1 and 1000")
+	}
+
+	if out.Size == 0 {
+		out.Size = defSize
+	}
+
+	if out.Size < 16 || out.Size > 4096 {
+		return nil, errors.New("size must be between 16 and 4096")
+	}
+
+	if out.Namespace == "" {
+		out.Namespace = defNamespace
+	}
+
+	if out.Source == "" {
+		out.Source = defSource
+	}
+
+	if out.Interval == 0 {
+		out.Interval = defInterval
+	}
+
+	if out.Interval < time.Second {
+		return nil, errors.New("interval too short")
+	}
+
+	if out.Timeout == 0 {
+		out.Timeout = defTimeout
+	}
+
+	return out, nil
+} // }}}
+
+// func New {{{
+
+// Loads the configuration at confPath and starts classifying every Interval.
+//
+// The CacheManager provides the images, the TagManager the IDs of the tags given.
+func New(confPath string, cm types.CacheManager, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (*Classify, error) {
+	var err error
+
+	cl := &Classify{
+		l:     l.With().Str("mod", "classify").Logger(),
+		cPath: confPath,
+		cm:    cm,
+		tm:    tm,
+		ctx:   ctx,
+	}
+
+	fl := cl.l.With().Str("func", "New").Logger()
+
+	cl.db = pgdb.New(&cl.l, ctx)
+
+	if err = cl.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// Start background processing to watch configuration for changes.
+	cl.yc.Start()
+
+	cl.sch = scheduler.New(cl.ctx)
+
+	if err = cl.sch.Add("classify", cl.getConf().Interval, cl.tickClassify); err != nil {
+		cl.close()
+		return nil, err
+	}
+
+	// Close down once we are done.
+	go func() {
+		<-cl.ctx.Done()
+		cl.close()
+	}()
+
+	fl.Debug().Send()
+
+	return cl, nil
+} // }}}
+
+// func Classify.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (cl *Classify) loadConf() error {
+	var err error
+
+	fl := cl.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		cl.notifyConf()
+	}
+
+	if cl.yc, err = yconf.New(cl.cPath, ycc, &cl.l, cl.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = cl.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(cl.yc.Get())).Send()
+
+	co, ok := cl.yc.Get().(*conf)
+	if !ok {
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = checkConf(co); err != nil {
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = cl.dbConnect(co); err != nil {
+		fl.Err(err).Msg("dbConnect")
+		return err
+	}
+
+	cl.co.Store(co)
+
+	return nil
+} // }}}
+
+// func Classify.notifyConf {{{
+
+func (cl *Classify) notifyConf() {
+	fl := cl.l.With().Str("func", "notifyConf").Logger()
+
+	co, ok := cl.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	if err := checkConf(co); err != nil {
+		fl.Warn().Err(err).Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	oldco := cl.getConf()
+
+	// The queries are prepared at connection, so either changing needs a new one.
+	if co.Database != oldco.Database || co.Queries != oldco.Queries {
+		if err := cl.dbConnect(co); err != nil {
+			fl.Err(err).Msg("dbConnect")
+			return
+		}
+	}
+
+	cl.co.Store(co)
+
+	if err := cl.sch.Reschedule("classify", co.Interval); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func checkConf {{{
+
+func checkConf(co *conf) error {
+	switch {
+	case co.Database == "":
+		return errors.New("missing database")
+	case co.Queries.Pending == "":
+		return errors.New("missing queries.pending")
+	case co.Queries.Save == "":
+		return errors.New("missing queries.save")
+	case len(co.Command) == 0:
+		return errors.New("missing command")
+	}
+
+	return nil
+} // }}}
+
+// func Classify.dbConnect {{{
+
+func (cl *Classify) dbConnect(co *conf) error {
+	return cl.db.Connect(&pgdb.Config{
+		Database: co.Database,
+		Statements: []pgdb.Statement{
+			{Name: "pending", Query: co.Queries.Pending},
+			{Name: "save", Query: co.Queries.Save},
+		},
+	})
+} // }}}
+
+// func Classify.getConf {{{
+
+func (cl *Classify) getConf() *conf {
+	if co, ok := cl.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	cl.l.Warn().Str("func", "getConf").Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func Classify.tickClassify {{{
+
+// Run by the scheduler, classifies a single batch.
+func (cl *Classify) tickClassify() {
+	fl := cl.l.With().Str("func", "tickClassify").Logger()
+
+	done, err := cl.classifyBatch(cl.getConf())
+	if err != nil {
+		fl.Err(err).Msg("classifyBatch")
+		return
+	}
+
+	if done > 0 {
+		fl.Info().Int("images", done).Msg("classified")
+	}
+} // }}}
+
+// func Classify.pending {{{
+
+// Returns the hash IDs of up to BatchSize images not yet classified.
+func (cl *Classify) pending(co *conf) ([]uint64, error) {
+	var ids []uint64
+
+	db, err := cl.db.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(cl.ctx, "pending", co.Source, co.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+} // }}}
+
+// func Classify.classifyBatch {{{
+
+// Classifies the next batch of pending images, returning how many were saved.
+func (cl *Classify) classifyBatch(co *conf) (int, error) {
+	var done int
+
+	fl := cl.l.With().Str("func", "classifyBatch").Logger()
+
+	if atomic.LoadUint32(&cl.closed) == 1 {
+		return 0, types.ErrShutdown
+	}
+
+	ids, err := cl.pending(co)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	imgs := make(map[uint64]image.Image, len(ids))
+
+	for _, id := range ids {
+		img, err := cl.cm.LoadImage(id, image.Point{co.Size, co.Size}, false)
+		if errors.Is(err, types.ErrNotFound) {
+			// Never going to be classified until cached, so saved without tags rather then asked for every batch.
+			fl.Debug().Uint64("id", id).Msg("not cached")
+
+			if err := cl.save(id, co.Source, nil); err != nil {
+				return done, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			fl.Err(err).Uint64("id", id).Msg("LoadImage")
+			continue
+		}
+
+		imgs[id] = img
+	}
+
+	if len(imgs) == 0 {
+		return done, nil
+	}
+
+	ctx, can := context.WithTimeout(cl.ctx, co.Timeout)
+	defer can()
+
+	var seen int
+
+	err = modelrun.Run(ctx, co.Command, imgs, func(id uint64, line []byte) error {
+		seen++
+
+		scores, err := parseLabels(line)
+		if err != nil {
+			fl.Err(err).Uint64("id", id).Msg("parseLabels")
+			return nil
+		}
+
+		tgs, err := cl.toTags(co, scores)
+		if err != nil {
+			fl.Err(err).Uint64("id", id).Msg("toTags")
+			return nil
+		}
+
+		if err := cl.save(id, co.Source, tgs); err != nil {
+			return err
+		}
+
+		done++
+
+		return nil
+	})
+	if err != nil {
+		return done, err
+	}
+
+	if missing := len(imgs) - seen; missing > 0 {
+		fl.Warn().Int("missing", missing).Msg("runner left out images, they are tried again next time")
+	}
+
+	return done, nil
+} // }}}
+
+// func Classify.toTags {{{
+
+// Converts every label at or above the Threshold into a tag within the Namespace.
+func (cl *Classify) toTags(co *conf, scores map[string]float64) (tags.Tags, error) {
+	var names []string
+
+	for _, label := range wanted(scores, co.Threshold) {
+		names = append(names, co.Namespace+":"+label)
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	return tags.StringsToTags(names, cl.tm)
+} // }}}
+
+// func Classify.save {{{
+
+func (cl *Classify) save(id uint64, source string, tgs tags.Tags) error {
+	db, err := cl.db.Get()
+	if err != nil {
+		return err
+	}
+
+	// Never NULL, an empty array marks it as done.
+	if tgs == nil {
+		tgs = tags.Tags{}
+	}
+
+	_, err = db.Exec(cl.ctx, "save", id, source, tgs)
+	return err
+} // }}}
+
+// func Classify.close {{{
+
+// Stops all background processing and disconnects from the database.
+func (cl *Classify) close() {
+	fl := cl.l.With().Str("func", "close").Logger()
+
+	if !atomic.CompareAndSwapUint32(&cl.closed, 0, 1) {
+		fl.Info().Msg("already closed")
+		return
+	}
+
+	cl.db.Close()
+
+	fl.Info().Msg("closed")
+} // }}}
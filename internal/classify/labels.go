@@ -0,0 +1,57 @@
+package classify
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// type runnerLine struct {{{
+
+// A single line of output from the runner, see confYAML.Command.
+type runnerLine struct {
+	File   string             `json:"file"`
+	Labels map[string]float64 `json:"labels"`
+} // }}}
+
+// func parseLabels {{{
+
+// Returns the score of each label from a line of runner output.
+func parseLabels(line []byte) (map[string]float64, error) {
+	var rl runnerLine
+
+	if err := json.Unmarshal(line, &rl); err != nil {
+		return nil, err
+	}
+
+	if rl.Labels == nil {
+		rl.Labels = map[string]float64{}
+	}
+
+	return rl.Labels, nil
+} // }}}
+
+// func wanted {{{
+
+// Returns the labels scoring at least threshold, sorted.
+//
+// Labels are trimmed and lower cased, with any spaces replaced by underscores so "golden retriever" is still a
+// single tag.
+func wanted(scores map[string]float64, threshold float64) []string {
+	var labels []string
+
+	for label, score := range scores {
+		if score < threshold {
+			continue
+		}
+
+		label = strings.Join(strings.Fields(strings.ToLower(label)), "_")
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+
+	sort.Strings(labels)
+
+	return labels
+} // }}}
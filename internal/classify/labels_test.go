@@ -0,0 +1,43 @@
+package classify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWanted(t *testing.T) {
+	scores := map[string]float64{
+		"dog":               0.93,
+		"Golden Retriever ": 0.71,
+		"beach":             0.41,
+		"  ":                0.99,
+		"cat":               0.5,
+	}
+
+	got := wanted(scores, 0.5)
+	want := []string{"cat", "dog", "golden_retriever"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted = %v, want %v", got, want)
+	}
+
+	if got := wanted(scores, 1); got != nil {
+		t.Errorf("wanted at 1 = %v, want nil", got)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	scores, err := parseLabels([]byte(`{"file": "/tmp/a/1.png", "labels": {"dog": 0.9}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]float64{"dog": 0.9}; !reflect.DeepEqual(scores, want) {
+		t.Errorf("parseLabels = %v, want %v", scores, want)
+	}
+
+	// Nothing found is still a result, just without tags.
+	if scores, err := parseLabels([]byte(`{"file": "/tmp/a/2.png"}`)); err != nil || scores == nil {
+		t.Errorf("parseLabels without labels = %v, %v", scores, err)
+	}
+}
@@ -0,0 +1,122 @@
+package classify
+
+import (
+	"context"
+	"frame/internal/pgdb"
+	"frame/internal/scheduler"
+	"frame/types"
+	"frame/yconf"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type confQueries struct {
+	// Returns the hash ID of images not yet classified by the source, given the source and how many are wanted.
+	Pending string `yaml:"pending"`
+
+	// Saves the tags of a hash, given the hash ID, source and tags.
+	Save string `yaml:"save"`
+}
+
+// type confYAML struct {{{
+
+type confYAML struct {
+	Database string `yaml:"database" log:"redact"`
+
+	Queries confQueries `yaml:"queries"`
+
+	// The model runner and its arguments, such as a small script using onnxruntime.
+	//
+	// Any "{model}" is replaced with Model. The image files of each batch are added to the end, and for each the
+	// runner writes a single line of JSON to stdout, such as -
+	//
+	//	{"file": "/tmp/classify123/42.png", "labels": {"dog": 0.93, "beach": 0.41}}
+	//
+	// Images it has nothing to say about can be left out, they are tried again next time.
+	Command []string `yaml:"command"`
+
+	// The model file, such as a MobileNet or CLIP-style tagger exported as ONNX.
+	Model string `yaml:"model"`
+
+	// The lowest score (0 to 1) a label needs to be given as a tag. Default if not set is 0.5.
+	Threshold float64 `yaml:"threshold"`
+
+	// How many images the runner is given at once. Default if not set is 16.
+	BatchSize int `yaml:"batchsize"`
+
+	// The size each image is scaled down to fit within before the runner sees it. Default if not set is 224.
+	Size int `yaml:"size"`
+
+	// Each label is given as this, a colon and then the label, such as "auto:dog". Default if not set is "auto".
+	Namespace string `yaml:"namespace"`
+
+	// Who the tags are saved as, see the files.autotags table. Default if not set is "classify".
+	//
+	// Changing it (such as for a new model) classifies every image again.
+	Source string `yaml:"source"`
+
+	// How often a batch is classified. Default if not set is 1 minute.
+	Interval yconf.Duration `yaml:"interval"`
+
+	// The longest the runner can take for a single batch. Default if not set is 5 minutes.
+	Timeout yconf.Duration `yaml:"timeout"`
+} // }}}
+
+// type conf struct {{{
+
+type conf struct {
+	Database string `log:"redact"`
+
+	Queries confQueries
+
+	// See confYAML.Command, with Model already put in place.
+	Command []string
+
+	Threshold float64
+	BatchSize int
+	Size      int
+	Namespace string
+	Source    string
+	Interval  time.Duration
+	Timeout   time.Duration
+} // }}}
+
+// type Classify struct {{{
+
+type Classify struct {
+	l zerolog.Logger
+
+	// We use an atomic for the configuration since we might replace it at any time while another goroutine
+	// can be using it.
+	co atomic.Value
+
+	// Our configuration path.
+	cPath string
+
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
+
+	cm types.CacheManager
+	tm types.TagManager
+
+	yc *yconf.YConf
+
+	// Runs each batch.
+	sch *scheduler.Scheduler
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
+
+// Notify is set in New()
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Changed: yconfChanged,
+	Convert: yconfConvert,
+}
@@ -0,0 +1,80 @@
+package cmanager
+
+import (
+	"io"
+	"os"
+)
+
+// func conf.base {{{
+
+// Returns the MaxResolution and ImageCache to use for the images of the base, its own if set otherwise the global.
+//
+// A base of 0 (such as from CacheImageRaw()) is always the global.
+func (co *conf) base(id int) confBase {
+	cb := co.Bases[id]
+
+	if cb.MaxResolution.X == 0 {
+		cb.MaxResolution.X = co.MaxResolution.X
+	}
+
+	if cb.MaxResolution.Y == 0 {
+		cb.MaxResolution.Y = co.MaxResolution.Y
+	}
+
+	if cb.ImageCache == "" {
+		cb.ImageCache = co.ImageCache
+	}
+
+	return cb
+} // }}}
+
+// func conf.roots {{{
+
+// Returns every distinct ImageCache, the global first.
+func (co *conf) roots() []string {
+	roots := []string{co.ImageCache}
+	seen := map[string]bool{co.ImageCache: true}
+
+	for _, cb := range co.Bases {
+		if cb.ImageCache == "" || seen[cb.ImageCache] {
+			continue
+		}
+
+		seen[cb.ImageCache] = true
+		roots = append(roots, cb.ImageCache)
+	}
+
+	return roots
+} // }}}
+
+// func CManager.findFile {{{
+
+// Returns the cached file of the hash within whichever ImageCache has it.
+//
+// We only know the hash, not the base that cached it, so each is checked in turn. If none has it the file within
+// the global is returned, which does not exist.
+func (cm *CManager) findFile(co *conf, hash string) (string, error) {
+	roots := co.roots()
+
+	for _, root := range roots[1:] {
+		file, err := hashFile(root, hash)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+
+	return hashFile(roots[0], hash)
+} // }}}
+
+// func CManager.CacheImageRawBase {{{
+
+// Same as CacheImageRaw(), using the MaxResolution and ImageCache of the base if it has its own.
+//
+// Implements types.CacheBaser.
+func (cm *CManager) CacheImageRawBase(base int, f io.Reader) (uint64, error) {
+	return cm.cacheRaw(base, f, false)
+} // }}}
@@ -0,0 +1,397 @@
+package cmanager
+
+import (
+	"errors"
+	"fmt"
+	fimg "frame/image"
+	"frame/internal/redact"
+	"frame/yconf"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Convert: yconfConvert,
+	Changed: yconfChanged,
+}
+
+// func CManager.loadConf {{{
+
+func (cm *CManager) loadConf() error {
+	var err error
+
+	fl := cm.l.With().Str("func", "loadConf").Logger()
+
+	if cm.yc, err = yconf.New(cm.cFile, ycCallers, &cm.l, cm.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	if err = cm.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	// Get the loaded configuration
+	co, ok := cm.yc.Get().(*conf)
+	if !ok {
+		// This one should not really be possible, so this error needs to be sent.
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if co == nil {
+		err := errors.New("Missing conf")
+		fl.Err(err).Send()
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(co)).Send()
+
+	// Sane MaxResolution, no smaller then 720p, there is no upper bound.
+	// If its lower then 720, then we default it to 4k.
+	if co.MaxResolution.X < 720 {
+		co.MaxResolution.X = 3840
+	}
+
+	if co.MaxResolution.Y < 720 {
+		co.MaxResolution.Y = 3840
+	}
+
+	if co.ImageCache == "" {
+		err := errors.New("Missing imagecache")
+		fl.Err(err).Send()
+		return err
+	}
+
+	// Same for each base, though anything too small is the global rather then 4k.
+	for id, cb := range co.Bases {
+		if cb.MaxResolution.X < 720 {
+			cb.MaxResolution.X = 0
+		}
+
+		if cb.MaxResolution.Y < 720 {
+			cb.MaxResolution.Y = 0
+		}
+
+		co.Bases[id] = cb
+	}
+
+	if co.Metadata == "" {
+		co.Metadata = filepath.Join(co.ImageCache, "metadata.db")
+	}
+
+	if co.TmpAge <= 0 {
+		co.TmpAge = time.Hour
+	}
+
+	if co.Hash == "" {
+		co.Hash = defaultHash
+	}
+
+	if co.PreviousHash == co.Hash {
+		err := errors.New("previoushash is the same as hash")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if co.MaxPixels == 0 {
+		co.MaxPixels = fimg.DefaultMaxPixels
+	}
+
+	if err := fimg.SetLimits(co.MaxPixels, co.MaxBytes); err != nil {
+		fl.Err(err).Msg("SetLimits")
+		return err
+	}
+
+	cm.co.Store(co)
+
+	return nil
+} // }}}
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	// This means that for our various maps, inA will continue to grow as the number of files we process grow, but inB will always be just the
+	// most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inA.ImageCache != inB.ImageCache && inB.ImageCache != "" {
+		inA.ImageCache = inB.ImageCache
+	}
+
+	// Copy MaxResolution if needed.
+	if inA.MaxResolution != inB.MaxResolution {
+		if inB.MaxResolution.X > 0 {
+			inA.MaxResolution.X = inB.MaxResolution.X
+		}
+
+		if inB.MaxResolution.Y > 0 {
+			inA.MaxResolution.Y = inB.MaxResolution.Y
+		}
+	}
+
+	if inA.Metadata != inB.Metadata && inB.Metadata != "" {
+		inA.Metadata = inB.Metadata
+	}
+
+	if inB.FileMode != 0 {
+		inA.FileMode = inB.FileMode
+	}
+
+	if inB.DirMode != 0 {
+		inA.DirMode = inB.DirMode
+	}
+
+	if inB.TmpAge != 0 {
+		inA.TmpAge = inB.TmpAge
+	}
+
+	if inB.ThumbSize != 0 {
+		inA.ThumbSize = inB.ThumbSize
+	}
+
+	if inB.ThumbCache != "" {
+		inA.ThumbCache = inB.ThumbCache
+	}
+
+	if inB.Resize != "" {
+		inA.Resize = inB.Resize
+	}
+
+	if inB.Hash != "" {
+		inA.Hash = inB.Hash
+	}
+
+	if inB.PreviousHash != "" {
+		inA.PreviousHash = inB.PreviousHash
+	}
+
+	if len(inB.KeepExif) > 0 {
+		inA.KeepExif = inB.KeepExif
+	}
+
+	if inB.MaxPixels != 0 {
+		inA.MaxPixels = inB.MaxPixels
+	}
+
+	if inB.MaxBytes != 0 {
+		inA.MaxBytes = inB.MaxBytes
+	}
+
+	for id, cb := range inB.Bases {
+		if inA.Bases == nil {
+			inA.Bases = make(map[int]confBase, len(inB.Bases))
+		}
+
+		inA.Bases[id] = cb
+	}
+
+	if inB.UID != -1 {
+		inA.UID = inB.UID
+	}
+
+	if inB.GID != -1 {
+		inA.GID = inB.GID
+	}
+
+	// If any configuration file has benice set, we enable it.
+	if !inA.BeNice && inB.BeNice {
+		inA.BeNice = true
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.ImageCache != newConf.ImageCache {
+		return true
+	}
+
+	if origConf.MaxResolution != newConf.MaxResolution {
+		return true
+	}
+
+	if origConf.FileMode != newConf.FileMode || origConf.DirMode != newConf.DirMode {
+		return true
+	}
+
+	if origConf.UID != newConf.UID || origConf.GID != newConf.GID {
+		return true
+	}
+
+	if origConf.ThumbSize != newConf.ThumbSize || origConf.ThumbCache != newConf.ThumbCache {
+		return true
+	}
+
+	if origConf.Resize != newConf.Resize {
+		return true
+	}
+
+	if origConf.Hash != newConf.Hash || origConf.PreviousHash != newConf.PreviousHash {
+		return true
+	}
+
+	if strings.Join(origConf.KeepExif, ",") != strings.Join(newConf.KeepExif, ",") {
+		return true
+	}
+
+	if origConf.MaxPixels != newConf.MaxPixels || origConf.MaxBytes != newConf.MaxBytes {
+		return true
+	}
+
+	if len(origConf.Bases) != len(newConf.Bases) {
+		return true
+	}
+
+	for id, cb := range origConf.Bases {
+		if ncb, ok := newConf.Bases[id]; !ok || ncb != cb {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func yconfConvert {{{
+
+func yconfConvert(inInt interface{}) (interface{}, error) {
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		ImageCache: in.ImageCache,
+		BeNice: in.BeNice,
+		Metadata:   in.Metadata,
+		UID:        -1,
+		GID:        -1,
+		ThumbSize:  in.ThumbSize,
+		ThumbCache: in.ThumbCache,
+		Resize:     in.Resize,
+	}
+
+	if in.ThumbSize < 0 {
+		return nil, errors.New("invalid thumbsize")
+	}
+
+	if in.MaxMegapixels < 0 || in.MaxBytes < 0 {
+		return nil, errors.New("maxmegapixels and maxbytes can not be negative")
+	}
+
+	out.MaxPixels = int64(in.MaxMegapixels) * 1000 * 1000
+	out.MaxBytes = in.MaxBytes
+
+	if in.Resize != "" && !fimg.ValidFilter(in.Resize) {
+		return nil, fmt.Errorf("invalid resize %q", in.Resize)
+	}
+
+	for _, name := range []string{in.Hash, in.PreviousHash} {
+		if _, ok := hashes[name]; name != "" && !ok {
+			return nil, fmt.Errorf("invalid hash %q", name)
+		}
+	}
+
+	out.Hash = in.Hash
+	out.PreviousHash = in.PreviousHash
+
+	if err := fimg.ExifFields(in.KeepExif); err != nil {
+		return nil, err
+	}
+
+	for _, name := range in.KeepExif {
+		out.KeepExif = append(out.KeepExif, strings.ToLower(name))
+	}
+
+	if in.FileMode != "" {
+		m, err := strconv.ParseUint(in.FileMode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.New("invalid filemode")
+		}
+
+		out.FileMode = os.FileMode(m)
+	}
+
+	if in.DirMode != "" {
+		m, err := strconv.ParseUint(in.DirMode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.New("invalid dirmode")
+		}
+
+		out.DirMode = os.FileMode(m)
+	}
+
+	out.TmpAge = time.Duration(in.TmpAge)
+
+	if in.UID != nil {
+		out.UID = *in.UID
+	}
+
+	if in.GID != nil {
+		out.GID = *in.GID
+	}
+
+	// Convert MaxResolution, if set.
+	if in.MaxResolution != "" {
+		num, err := fmt.Sscanf(in.MaxResolution, "%dx%d", &out.MaxResolution.X, &out.MaxResolution.Y)
+		if err != nil || num != 2 {
+			return nil, errors.New("invalid MaxResolution")
+		}
+	}
+
+	for id, bc := range in.Bases {
+		var cb confBase
+
+		if id < 1 {
+			return nil, fmt.Errorf("invalid base %d", id)
+		}
+
+		if bc.MaxResolution != "" {
+			num, err := fmt.Sscanf(bc.MaxResolution, "%dx%d", &cb.MaxResolution.X, &cb.MaxResolution.Y)
+			if err != nil || num != 2 {
+				return nil, fmt.Errorf("invalid MaxResolution for base %d", id)
+			}
+		}
+
+		cb.ImageCache = bc.ImageCache
+
+		if out.Bases == nil {
+			out.Bases = make(map[int]confBase, len(in.Bases))
+		}
+
+		out.Bases[id] = cb
+	}
+
+	return out, nil
+} // }}}
@@ -0,0 +1,571 @@
+package cmanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash"
+	"encoding/hex"
+	"errors"
+	fimg "frame/image"
+	"frame/types"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type hashReader struct {
+	h hash.Hash
+	r io.Reader
+
+	// Only set when moving to a new hash, see confYAML.PreviousHash. h is then the previous.
+	next hash.Hash
+}
+
+// func hashReader.Read {{{
+
+// Basically an io.Reader that passes the read bytes for hashing before returning.
+//
+// Allows to read from an io.Reader while also hashing the contents at the same time.
+func (h *hashReader) Read(p []byte) (n int, err error) {
+	n, err = h.r.Read(p)
+
+	// Hash any returned bytes first.
+	if n > 0 {
+		// This is writing to a hash, so we do not really care about the amount written.
+		// An error however would mean we could not hash this file properly, so that would be bad.
+		if _, herr := h.h.Write(p[0:n]); herr != nil {
+			return n, herr
+		}
+
+		if h.next != nil {
+			if _, herr := h.next.Write(p[0:n]); herr != nil {
+				return n, herr
+			}
+		}
+	}
+
+	return n, err
+} // }}}
+
+// func New {{{
+
+func New(confFile string, im types.IDManager, l *zerolog.Logger, ctx context.Context) (*CManager, error) {
+	var err error
+
+	cm := &CManager{
+		l:     l.With().Str("mod", "cmanager").Logger(),
+		im:    im,
+		cFile: confFile,
+		ctx:   ctx,
+	}
+
+	// Create our buffer pool so we can reuse the buffers for hasing
+	// and rendering images.
+	//
+	// Almost the same as the sync.Pool documentation.
+	cm.bp = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+
+	fl := cm.l.With().Str("func", "New").Logger()
+
+	// Load our configuration.
+	if err = cm.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// The metadata database, which also handles closing itself at shutdown.
+	if err = cm.openMeta(cm.getConf()); err != nil {
+		return nil, err
+	}
+
+	// Before anything can load an image, as the names within the imagecache change.
+	if err = cm.finishRehash(cm.getConf()); err != nil {
+		return nil, err
+	}
+
+	// Start background configuration handling.
+	cm.yc.Start()
+
+	// Cleaning up after any crash can take a while with a large cache, so do it in the background.
+	go cm.cleanTmp(cm.getConf())
+
+	fl.Debug().Send()
+
+	return cm, nil
+} // }}}
+
+// func CManager.cleanTmp {{{
+
+// Removes any .tmp files within the imagecache older then TmpAge.
+//
+// We write each cached image to a .tmp first and then rename it, so anything left behind is from us crashing
+// or being killed part way through.
+//
+// The age is so that another copy of us sharing the same imagecache does not have a file removed while writing it.
+func (cm *CManager) cleanTmp(co *conf) {
+	var removed int
+
+	fl := cm.l.With().Str("func", "cleanTmp").Logger()
+
+	old := time.Now().Add(-co.TmpAge)
+
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Shutting down? No reason to continue.
+		if cm.ctx.Err() != nil {
+			return cm.ctx.Err()
+		}
+
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// Most likely just renamed out from under us.
+			return nil
+		}
+
+		if info.ModTime().After(old) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			fl.Warn().Err(err).Str("file", path).Msg("Remove")
+			return nil
+		}
+
+		fl.Info().Str("file", path).Time("modified", info.ModTime()).Msg("removed")
+		removed++
+
+		return nil
+	}
+
+	// Each base can have its own ImageCache.
+	for _, root := range co.roots() {
+		if err := filepath.WalkDir(root, walk); err != nil && cm.ctx.Err() == nil {
+			fl.Err(err).Str("imagecache", root).Msg("WalkDir")
+		}
+	}
+
+	fl.Debug().Int("removed", removed).Send()
+} // }}}
+
+// func CManager.getID {{{
+
+// Hashes the provided image and returns the ID as assigned by the IDManager.
+func (cm *CManager) getID(hr *hashReader) (uint64, string, error) {
+	fl := cm.l.With().Str("func", "getID").Logger()
+
+	// Get the string hex value.
+	tHash := hex.EncodeToString(hr.h.Sum(nil))
+
+	tID, err := cm.im.GetID(tHash)
+	if err != nil {
+		fl.Err(err).Msg("GetID")
+		return 0, "", err
+	}
+
+	return tID, tHash, nil
+} // }}}
+
+// func CManager.getConf {{{
+
+func (cm *CManager) getConf() *conf {
+	if co, ok := cm.co.Load().(*conf); ok {
+		return co
+	}
+
+	fl := cm.l.With().Str("func", "getconf").Logger()
+
+	// This should really never be able to happen.
+	//
+	// If this does, then there is a deeper issue.
+	fl.Warn().Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func CManager.getFileName {{{
+
+// Returns the full path and name of the file on the file that
+// should be written in the cache for the given hash.
+//
+// root is the top of the cache, either ImageCache or the thumbnail cache.
+func (cm *CManager) getFileName(root, hash string) (string, error) {
+	fl := cm.l.With().Str("func", "getFileName").Str("hash", hash).Logger()
+
+	co := cm.getConf()
+
+	// Get the full path to the hash they want to write.
+	file, err := hashFile(root, hash)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Dir(file)
+
+	// We only get called when someone wants to write a hash.
+	//
+	// Ensure the path exists so they can write.
+	if _, err := os.Stat(path); err != nil {
+		// We expect the path to not exist - Other errors though, we don't expect.
+		if os.IsNotExist(err) {
+			dirMode := co.DirMode
+			if dirMode == 0 {
+				dirMode = 0755
+			}
+
+			// Create the needed path(s)
+			if err := os.MkdirAll(path, dirMode); err != nil {
+				fl.Err(err).Msg("mkdirall")
+				return "", err
+			}
+
+			// Both directories could have been created, so set both.
+			for _, dir := range []string{filepath.Join(root, hash[0:1]), path} {
+				if err := setPerm(co, dir, true); err != nil {
+					fl.Err(err).Str("path", dir).Msg("setPerm")
+					return "", err
+				}
+			}
+			fl.Debug().Str("path", path).Msg("path created")
+		} else {
+			fl.Err(err).Str("path", path).Msg("exists check")
+			return "", err
+		}
+	}
+
+	fl.Debug().Str("file", file).Send()
+
+	return file, nil
+} // }}}
+
+// func hashFile {{{
+
+// Returns the file within root the hash is kept in, without creating anything unlike getFileName().
+func hashFile(root, hash string) (string, error) {
+	if len(hash) < 10 {
+		return "", errors.New("invalid hash")
+	}
+
+	// Our cache is stored as WebP.
+	return filepath.Join(root, hash[0:1], hash[1:2], hash+".webp"), nil
+} // }}}
+
+// func setPerm {{{
+
+// Sets the configured mode and ownership on a file or directory we created.
+//
+// OpenFile() and MkdirAll() are subject to the umask, so a configured mode is set exactly here.
+func setPerm(co *conf, path string, dir bool) error {
+	mode := co.FileMode
+	if dir {
+		mode = co.DirMode
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if co.UID != -1 || co.GID != -1 {
+		if err := os.Chown(path, co.UID, co.GID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func CManager.writeWebP {{{
+
+// Writes the image to the file as WebP, along with the EXIF if not nil (see KeepExif).
+//
+// Written to a temporary file first, so if we get an error we don't leave behind a partially written file
+// and potentially a broken image.
+func (cm *CManager) writeWebP(co *conf, file string, img image.Image, exif []byte) error {
+	fl := cm.l.With().Str("func", "writeWebP").Str("file", file).Logger()
+
+	fileMode := co.FileMode
+	if fileMode == 0 {
+		fileMode = 0644
+	}
+
+	fo, err := os.OpenFile(file+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		fl.Err(err).Msg("Create")
+		return err
+	}
+
+	if err := fimg.SaveImageWebPExif(fo, img, exif); err != nil {
+		fl.Err(err).Msg("Encode")
+		fo.Close()
+		os.Remove(file + ".tmp")
+		return err
+	}
+
+	// We do not defer the close since we want to ensure we close the file
+	// before we rename it.
+	fo.Close()
+
+	if err := setPerm(co, file+".tmp", false); err != nil {
+		fl.Err(err).Msg("setPerm")
+		return err
+	}
+
+	// File written without issue so rename it properly.
+	if err := os.Rename(file+".tmp", file); err != nil {
+		fl.Err(err).Msg("Rename")
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func CManager.CacheImage {{{
+
+func (cm *CManager) CacheImage(img image.Image) (uint64, error) {
+	return 0, errors.New("not done")
+} // }}}
+
+// func CManager.CacheImageRaw {{{
+
+func (cm *CManager) CacheImageRaw(f io.Reader) (uint64, error) {
+	return cm.cacheRaw(0, f, false)
+} // }}}
+
+// func CManager.cacheRaw {{{
+
+// Does the work of CacheImageRaw(), CacheImageRawBase() and CacheImagePixelsBase(), base is 0 for the global
+// MaxResolution and ImageCache.
+//
+// If pixels is set the ID is from the hash of the decoded pixels, see hashPixels(), rather then the file.
+func (cm *CManager) cacheRaw(base int, f io.Reader, pixels bool) (uint64, error) {
+	c := atomic.AddUint64(&cm.c, 1)
+	s := time.Now()
+
+	fl := cm.l.With().Str("func", "CacheImageRaw").Uint64("c", c).Int("base", base).Logger()
+
+	co := cm.getConf()
+	cb := co.base(base)
+
+	hr := &hashReader{
+		h: hashes[co.Hash](),
+		r: f,
+	}
+
+	// Moving to a new hash? The previous still names the image until the move is finished.
+	if co.PreviousHash != "" {
+		hr.h, hr.next = hashes[co.PreviousHash](), hr.h
+	}
+
+	// Get a lock to throttle our resource usage if we need one.
+	if co.BeNice {
+		cm.beNice.Lock()
+		defer cm.beNice.Unlock()
+	}
+
+	// We want the format for the metadata, so peek at the first few bytes.
+	//
+	// The decoder would wrap the reader with a bufio.Reader anyways if we didn't, so this does not
+	// change what gets read (and hashed) in any way.
+	br := bufio.NewReader(hr)
+
+	// Anything to keep of the EXIF? Its right at the start of a JPEG, so a larger peek gets all of it.
+	var exif []byte
+
+	if len(co.KeepExif) > 0 {
+		br = bufio.NewReaderSize(hr, exifPeek)
+		head, _ := br.Peek(exifPeek)
+		exif = fimg.KeepExif(head, co.KeepExif)
+	}
+
+	head, _ := br.Peek(16)
+	format := fimg.Format(head)
+
+	// Load the image from our buffer.
+	dStart := time.Now()
+	img, err := fimg.LoadReader(br)
+	if err != nil {
+		fl.Err(err).Msg("LoadReader")
+		return 0, err
+	}
+
+	dCost := time.Since(dStart)
+
+	// Decoded fine, but is it something worth caching?
+	//
+	// Better to fail the file (so it shows as an error) then cache garbage that only ever renders as a black square.
+	if err := fimg.Verify(img); err != nil {
+		fl.Warn().Err(err).Msg("Verify")
+		return 0, err
+	}
+
+	// Done before resizing, so a different MaxResolution gives the same hash.
+	if pixels {
+		if err := hashPixels(img, hr.h, hr.next); err != nil {
+			fl.Err(err).Msg("hashPixels")
+			return 0, err
+		}
+	}
+
+	// Get the dimensions to resize if needed.
+	size := img.Bounds().Size()
+
+	// Lets see if we need to resize the image or not.
+	newSize, _ := fimg.Fit(size, cb.MaxResolution, false)
+
+	// Is the size different?
+	if newSize != size {
+		start := time.Now()
+		img = fimg.ResizeFilter(img, newSize, co.Resize)
+		fl.Debug().Stringer("old", size).Stringer("new", newSize).Stringer("took", time.Since(start)).Msg("resize")
+	}
+
+	// Lets get the ID
+	id, hash, err := cm.getID(hr)
+	if err != nil {
+		fl.Err(err).Msg("getID")
+		return 0, err
+	}
+
+	if hr.next != nil {
+		if err := cm.setRehash(id, hex.EncodeToString(hr.next.Sum(nil))); err != nil {
+			// Not fatal, the image keeps its previous hash until seen again.
+			fl.Warn().Err(err).Uint64("id", id).Msg("setRehash")
+		}
+	}
+
+	// Already cached, within any ImageCache?
+	file, err := cm.findFile(co, hash)
+	if err != nil {
+		fl.Err(err).Msg("findFile")
+		return 0, err
+	}
+
+	// Record the metadata if we don't already have it.
+	//
+	// Images cached before we had metadata get it added the next time they are seen.
+	if !cm.hasMeta(id) {
+		md := &types.ImageMetadata{
+			Original:   size,
+			Cached:     img.Bounds().Size(),
+			Format:     format,
+			Colors:     fimg.DominantColors(img, 5),
+			DecodeCost: dCost,
+		}
+
+		if err := cm.setMeta(id, md); err != nil {
+			// Not fatal, the image itself is what matters.
+			fl.Warn().Err(err).Uint64("id", id).Msg("setMeta")
+		}
+	}
+
+	// The thumbnail as well, if enabled.
+	//
+	// Done even if the image is already cached, so images cached before thumbnails were enabled get one.
+	if err := cm.writeThumb(co, hash, img); err != nil {
+		// Not fatal, LoadThumb() can make it later.
+		fl.Warn().Err(err).Uint64("id", id).Msg("writeThumb")
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		// No error on stat, so the file exists.
+		// Nothing more for us to do.
+		fl.Debug().Uint64("id", id).Str("hash", hash).Msg("exists")
+		return id, nil
+	}
+
+	// Get the path the hash should be written to.
+	file, err = cm.getFileName(cb.ImageCache, hash)
+	if err != nil {
+		fl.Err(err).Msg("getFileName")
+		return 0, err
+	}
+
+	if err := cm.writeWebP(co, file, img, exif); err != nil {
+		fl.Err(err).Uint64("id", id).Str("hash", hash).Msg("writeWebP")
+		return id, err
+	}
+
+	fl.Debug().Uint64("id", id).Str("hash", hash).Stringer("took", time.Since(s)).Msg("cached")
+	return id, nil
+} // }}}
+
+// func CManager.LoadImage {{{
+
+func (cm *CManager) LoadImage(id uint64, fit image.Point, enlarge bool) (image.Image, error) {
+	var change float64
+
+	fl := cm.l.With().Str("func", "LoadImage").Uint64("id", id).Logger()
+
+	co := cm.getConf()
+
+	// Get a lock to throttle our resource usage if we need one.
+	if co.BeNice {
+		cm.beNice.Lock()
+		defer cm.beNice.Unlock()
+	}
+
+	// Lets get the hash for this ID.
+	hash, err := cm.im.GetHash(id)
+	if err != nil {
+		fl.Err(err).Msg("GetHash")
+		return nil, err
+	}
+
+	// Have the hash, now need the file name in our cache.
+	file, err := cm.findFile(co, hash)
+	if err != nil {
+		fl.Err(err).Msg("findFile")
+		return nil, err
+	}
+
+	// Open the file for reading.
+	f, err := os.Open(file)
+	if err != nil {
+		fl.Err(err).Str("file", file).Msg("Open")
+
+		// Let the caller know the image is gone, rather then just failing to load.
+		if os.IsNotExist(err) {
+			return nil, types.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	img, err := fimg.LoadReader(f)
+	if err != nil {
+		fl.Err(err).Str("file", file).Msg("LoadReader")
+		return nil, err
+	}
+
+	// Get the dimensions for resizing.
+	size := img.Bounds().Size()
+
+	newSize, change := fimg.Fit(size, fit, enlarge)
+
+	if change != 0 {
+		start := time.Now()
+
+		img = fimg.ResizeFilter(img, newSize, co.Resize)
+
+		fl.Debug().Stringer("old", size).Stringer("new", newSize).Stringer("wanted", fit).Float64("change", change).Stringer("took", time.Since(start)).Msg("resize")
+	}
+
+	return img, nil
+} // }}}
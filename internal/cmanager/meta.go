@@ -0,0 +1,138 @@
+package cmanager
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"frame/types"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bucket within the metadata database all metadata is stored in.
+var metaBucket = []byte("meta")
+
+// Returned by Metadata() when nothing is known about an ID.
+var ErrNoMetadata = errors.New("no metadata")
+
+// func CManager.openMeta {{{
+
+// Opens the metadata database, creating it if needed.
+//
+// This is done once at startup, changing the location requires a restart.
+func (cm *CManager) openMeta(co *conf) error {
+	fl := cm.l.With().Str("func", "openMeta").Str("file", co.Metadata).Logger()
+
+	// The timeout is so that a second copy of us using the same cache does not hang forever waiting on the lock.
+	fileMode := co.FileMode
+	if fileMode == 0 {
+		fileMode = 0644
+	}
+
+	db, err := bolt.Open(co.Metadata, fileMode, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		fl.Err(err).Msg("bolt.Open")
+		return err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(rehashBucket); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+
+	if err != nil {
+		fl.Err(err).Msg("CreateBucket")
+		db.Close()
+		return err
+	}
+
+	cm.meta = db
+
+	// We have no other background tasks, so just wait for the shutdown to close the database.
+	go func() {
+		<-cm.ctx.Done()
+		cm.meta.Close()
+	}()
+
+	fl.Debug().Send()
+
+	return nil
+} // }}}
+
+// func metaKey {{{
+
+func metaKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+} // }}}
+
+// func metaKeyID {{{
+
+func metaKeyID(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+} // }}}
+
+// func CManager.hasMeta {{{
+
+// Returns true if we already have metadata for the ID.
+func (cm *CManager) hasMeta(id uint64) bool {
+	var found bool
+
+	cm.meta.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(metaBucket).Get(metaKey(id)) != nil
+		return nil
+	})
+
+	return found
+} // }}}
+
+// func CManager.setMeta {{{
+
+func (cm *CManager) setMeta(id uint64, md *types.ImageMetadata) error {
+	data, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	return cm.meta.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(metaKey(id), data)
+	})
+} // }}}
+
+// func CManager.Metadata {{{
+
+// Returns the metadata recorded when the image was cached.
+//
+// Returns ErrNoMetadata if nothing is known, such as an image cached before we started recording metadata.
+func (cm *CManager) Metadata(id uint64) (*types.ImageMetadata, error) {
+	var data []byte
+
+	fl := cm.l.With().Str("func", "Metadata").Uint64("id", id).Logger()
+
+	cm.meta.View(func(tx *bolt.Tx) error {
+		// The returned slice is only valid within the transaction, so copy it.
+		if val := tx.Bucket(metaBucket).Get(metaKey(id)); val != nil {
+			data = append([]byte{}, val...)
+		}
+
+		return nil
+	})
+
+	if data == nil {
+		return nil, ErrNoMetadata
+	}
+
+	md := &types.ImageMetadata{}
+	if err := json.Unmarshal(data, md); err != nil {
+		fl.Err(err).Msg("Unmarshal")
+		return nil, err
+	}
+
+	return md, nil
+} // }}}
@@ -0,0 +1,82 @@
+package cmanager
+
+import (
+	"encoding/binary"
+	"hash"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// How many rows hashPixels() converts at a time, so a large image is not copied whole.
+const pixelRows = 64
+
+// func CManager.CacheImagePixelsBase {{{
+
+// Same as CacheImageRawBase(), but the image is identified by its decoded pixels rather then the bytes of the file.
+//
+// Implements types.CachePixelHasher.
+func (cm *CManager) CacheImagePixelsBase(base int, f io.Reader) (uint64, error) {
+	return cm.cacheRaw(base, f, true)
+} // }}}
+
+// func hashPixels {{{
+
+// Resets each hash (nil ones are skipped) and writes the size and pixels of the image to it.
+//
+// Decoders return different image types for the same pixels, so each is first converted to 8 bit RGBA. The size is
+// included so the same pixels in a different shape (2x8 and 4x4 for example) hash differently.
+func hashPixels(img image.Image, hs ...hash.Hash) error {
+	b := img.Bounds()
+
+	var size [8]byte
+	binary.BigEndian.PutUint32(size[0:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(size[4:8], uint32(b.Dy()))
+
+	write := func(p []byte) error {
+		for _, h := range hs {
+			if h == nil {
+				continue
+			}
+
+			if _, err := h.Write(p); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, h := range hs {
+		if h != nil {
+			h.Reset()
+		}
+	}
+
+	if err := write(size[:]); err != nil {
+		return err
+	}
+
+	rows := pixelRows
+	if b.Dy() < rows {
+		rows = b.Dy()
+	}
+
+	strip := image.NewRGBA(image.Rect(0, 0, b.Dx(), rows))
+
+	for y := b.Min.Y; y < b.Max.Y; y += rows {
+		n := rows
+		if y+n > b.Max.Y {
+			n = b.Max.Y - y
+		}
+
+		r := image.Rect(0, 0, b.Dx(), n)
+		draw.Draw(strip, r, img, image.Point{b.Min.X, y}, draw.Src)
+
+		if err := write(strip.Pix[:n*strip.Stride]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
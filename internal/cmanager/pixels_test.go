@@ -0,0 +1,77 @@
+package cmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	fimg "frame/image"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func pixelSum(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	h := sha256.New()
+	if err := hashPixels(img, h, nil); err != nil {
+		t.Fatalf("hashPixels: %s", err)
+	}
+
+	return h.Sum(nil)
+}
+
+func TestHashPixels(t *testing.T) {
+	// Taller then pixelRows, so more then one strip.
+	src := image.NewNRGBA(image.Rect(0, 0, 30, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 30; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 2), uint8(x + y), 255})
+		}
+	}
+
+	want := pixelSum(t, src)
+
+	// The same pixels encoded with a different compression decode the same.
+	for _, level := range []png.CompressionLevel{png.NoCompression, png.BestCompression} {
+		buf := &bytes.Buffer{}
+
+		enc := &png.Encoder{CompressionLevel: level}
+		if err := enc.Encode(buf, src); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := fimg.LoadReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := pixelSum(t, img); !bytes.Equal(got, want) {
+			t.Fatalf("hashPixels compression %d Expected %x != Got %x", level, want, got)
+		}
+	}
+
+	// As an RGBA rather then NRGBA, and within a larger image.
+	rgba := image.NewRGBA(image.Rect(-5, -5, 40, 110))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 30; x++ {
+			rgba.Set(x, y, src.At(x, y))
+		}
+	}
+
+	if got := pixelSum(t, rgba.SubImage(image.Rect(0, 0, 30, 100))); !bytes.Equal(got, want) {
+		t.Fatalf("hashPixels RGBA Expected %x != Got %x", want, got)
+	}
+
+	// Same bytes in a different shape.
+	reshaped := &image.NRGBA{Pix: src.Pix, Stride: 60 * 4, Rect: image.Rect(0, 0, 60, 50)}
+	if got := pixelSum(t, reshaped); bytes.Equal(got, want) {
+		t.Fatal("hashPixels Expected a different hash for a different size")
+	}
+
+	// A single pixel changed.
+	src.SetNRGBA(29, 99, color.NRGBA{0, 0, 0, 255})
+	if got := pixelSum(t, src); bytes.Equal(got, want) {
+		t.Fatal("hashPixels Expected a different hash for a changed pixel")
+	}
+}
@@ -0,0 +1,195 @@
+package cmanager
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"frame/types"
+	"hash"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bucket within the metadata database holding the new hash of each ID while moving to a new hash, see
+// confYAML.PreviousHash.
+var rehashBucket = []byte("rehash")
+
+// Returned by New() when finishing a move to a new hash and the IDManager can not change the hash of an ID.
+var errNoRehasher = errors.New("idmanager can not rehash")
+
+// The hash used when confYAML.Hash is not set, and what every imagecache used before it could be.
+const defaultHash = "sha256"
+
+// The hashes confYAML.Hash can be.
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// func CManager.setRehash {{{
+
+// Records the new hash of the ID.
+func (cm *CManager) setRehash(id uint64, hash string) error {
+	return cm.meta.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(rehashBucket).Put(metaKey(id), []byte(hash))
+	})
+} // }}}
+
+// func CManager.NeedsRehash {{{
+
+// Returns true while moving to a new hash and the new hash of the ID is not yet known.
+//
+// Implements types.CacheRehasher.
+func (cm *CManager) NeedsRehash(id uint64) bool {
+	if cm.getConf().PreviousHash == "" {
+		return false
+	}
+
+	var found bool
+
+	cm.meta.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(rehashBucket).Get(metaKey(id)) != nil
+		return nil
+	})
+
+	return !found
+} // }}}
+
+// func CManager.finishRehash {{{
+
+// Finishes moving to a new hash once PreviousHash is no longer set.
+//
+// Each ID with a recorded new hash has its image (and thumbnail) renamed within the imagecache, then the IDManager
+// changes its hash. Should the IDManager fail the rename is undone and the ID left for the next startup.
+//
+// Only returns an error if not a single ID could be moved, most likely the IDManager lacking its rehash query, as
+// carrying on would give every image not yet moved a new ID the next time it is hashed.
+func (cm *CManager) finishRehash(co *conf) error {
+	type rehash struct {
+		id   uint64
+		hash string
+	}
+
+	if co.PreviousHash != "" {
+		return nil
+	}
+
+	fl := cm.l.With().Str("func", "finishRehash").Str("hash", co.Hash).Logger()
+
+	var todo []rehash
+
+	cm.meta.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(rehashBucket).ForEach(func(k, v []byte) error {
+			if len(k) == 8 {
+				todo = append(todo, rehash{id: metaKeyID(k), hash: string(v)})
+			}
+
+			return nil
+		})
+	})
+
+	if len(todo) == 0 {
+		return nil
+	}
+
+	ir, ok := cm.im.(types.IDRehasher)
+	if !ok {
+		err := errNoRehasher
+		fl.Err(err).Int("ids", len(todo)).Send()
+		return err
+	}
+
+	fl.Info().Int("ids", len(todo)).Msg("starting")
+
+	var done, failed int
+
+	for _, rh := range todo {
+		if cm.ctx.Err() != nil {
+			break
+		}
+
+		if err := cm.rehashID(co, ir, rh.id, rh.hash); err != nil {
+			fl.Warn().Err(err).Uint64("id", rh.id).Msg("rehashID")
+			failed++
+			continue
+		}
+
+		done++
+	}
+
+	fl.Info().Int("done", done).Int("failed", failed).Msg("finished")
+
+	if done == 0 && failed > 0 {
+		err := fmt.Errorf("unable to move any of %d images to the new hash", failed)
+		fl.Err(err).Send()
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func CManager.rehashID {{{
+
+// Moves a single ID to its new hash, see finishRehash().
+func (cm *CManager) rehashID(co *conf, ir types.IDRehasher, id uint64, newHash string) error {
+	oldHash, err := cm.im.GetHash(id)
+	if err != nil {
+		return err
+	}
+
+	// Already done, such as the IDManager finishing but us not removing it.
+	if oldHash == newHash {
+		return cm.delRehash(id)
+	}
+
+	var moved [][2]string
+
+	undo := func() {
+		for _, m := range moved {
+			os.Rename(m[1], m[0])
+		}
+	}
+
+	for _, root := range append(co.roots(), thumbRoot(co)) {
+		oldFile, err := cm.getFileName(root, oldHash)
+		if err != nil {
+			undo()
+			return err
+		}
+
+		newFile, err := cm.getFileName(root, newHash)
+		if err != nil {
+			undo()
+			return err
+		}
+
+		// Thumbnails are optional, and a missing image is cached again the next time it is seen.
+		if err := os.Rename(oldFile, newFile); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			undo()
+			return err
+		}
+
+		moved = append(moved, [2]string{oldFile, newFile})
+	}
+
+	if err := ir.Rehash(id, newHash); err != nil {
+		undo()
+		return err
+	}
+
+	return cm.delRehash(id)
+} // }}}
+
+// func CManager.delRehash {{{
+
+func (cm *CManager) delRehash(id uint64) error {
+	return cm.meta.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(rehashBucket).Delete(metaKey(id))
+	})
+} // }}}
@@ -0,0 +1,96 @@
+package cmanager
+
+import (
+	"frame/types"
+	"image"
+	"os"
+)
+
+// func CManager.cachedFile {{{
+
+// Returns the file in the cache of the ID, which may or may not exist.
+func (cm *CManager) cachedFile(id uint64) (string, error) {
+	hash, err := cm.im.GetHash(id)
+	if err != nil {
+		return "", err
+	}
+
+	return cm.findFile(cm.getConf(), hash)
+} // }}}
+
+// func CManager.Has {{{
+
+// Returns true if the image of the ID is in the cache.
+//
+// Only checks the file exists, a corrupt file still fails to load.
+func (cm *CManager) Has(id uint64) bool {
+	file, err := cm.cachedFile(id)
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+
+	return info.Mode().IsRegular()
+} // }}}
+
+// func CManager.Stat {{{
+
+// Returns details of the cached image of the ID, without decoding it.
+//
+// The dimensions come from the metadata if recorded, otherwise from the header of the file itself.
+func (cm *CManager) Stat(id uint64) (types.CacheStat, error) {
+	var st types.CacheStat
+
+	fl := cm.l.With().Str("func", "Stat").Uint64("id", id).Logger()
+
+	file, err := cm.cachedFile(id)
+	if err != nil {
+		fl.Err(err).Msg("cachedFile")
+		return st, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, types.ErrNotFound
+		}
+
+		fl.Err(err).Str("file", file).Msg("Stat")
+		return st, err
+	}
+
+	st.Bytes = info.Size()
+	st.ModTime = info.ModTime()
+
+	if md, err := cm.Metadata(id); err == nil {
+		st.Size = md.Cached
+		st.Format = md.Format
+	}
+
+	if st.Size != (image.Point{}) {
+		return st, nil
+	}
+
+	// No metadata, so the header of the file it is.
+	f, err := os.Open(file)
+	if err != nil {
+		fl.Err(err).Str("file", file).Msg("Open")
+		return st, err
+	}
+
+	defer f.Close()
+
+	ic, _, err := image.DecodeConfig(f)
+	if err != nil {
+		fl.Err(err).Str("file", file).Msg("DecodeConfig")
+		return st, err
+	}
+
+	st.Size = image.Point{ic.Width, ic.Height}
+
+	return st, nil
+} // }}}
@@ -0,0 +1,101 @@
+package cmanager
+
+import (
+	fimg "frame/image"
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// func thumbRoot {{{
+
+// Returns the top of the thumbnail cache.
+func thumbRoot(co *conf) string {
+	if co.ThumbCache != "" {
+		return co.ThumbCache
+	}
+
+	return filepath.Join(co.ImageCache, "thumbs")
+} // }}}
+
+// func CManager.writeThumb {{{
+
+// Writes the thumbnail for the hash from img, if thumbnails are enabled and it does not already exist.
+func (cm *CManager) writeThumb(co *conf, hash string, img image.Image) error {
+	if co.ThumbSize <= 0 {
+		return nil
+	}
+
+	file, err := cm.getFileName(thumbRoot(co), hash)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+
+	size := img.Bounds().Size()
+
+	// Thumbnails are never enlarged, a small image is its own thumbnail.
+	if newSize, change := fimg.Fit(size, image.Point{co.ThumbSize, co.ThumbSize}, false); change != 0 {
+		img = fimg.ResizeFilter(img, newSize, co.Resize)
+	}
+
+	return cm.writeWebP(co, file, img, nil)
+} // }}}
+
+// func CManager.LoadThumb {{{
+
+// Returns a small version of the image, no larger then ThumbSize on either side.
+//
+// Much faster then LoadImage() as the thumbnail is already small. If the thumbnail does not exist yet it is made
+// from the cached image (and saved if thumbnails are enabled), so this always works for any cached ID.
+func (cm *CManager) LoadThumb(id uint64) (image.Image, error) {
+	fl := cm.l.With().Str("func", "LoadThumb").Uint64("id", id).Logger()
+
+	co := cm.getConf()
+
+	size := co.ThumbSize
+	if size <= 0 {
+		size = defaultThumbSize
+	}
+
+	hash, err := cm.im.GetHash(id)
+	if err != nil {
+		fl.Err(err).Msg("GetHash")
+		return nil, err
+	}
+
+	if co.ThumbSize > 0 {
+		file, err := cm.getFileName(thumbRoot(co), hash)
+		if err != nil {
+			fl.Err(err).Msg("getFileName")
+			return nil, err
+		}
+
+		if f, err := os.Open(file); err == nil {
+			defer f.Close()
+
+			img, err := fimg.LoadReader(f)
+			if err == nil {
+				return img, nil
+			}
+
+			// Remake it below.
+			fl.Warn().Err(err).Str("file", file).Msg("LoadReader")
+		}
+	}
+
+	// No thumbnail, so make one from the full image.
+	img, err := cm.LoadImage(id, image.Point{size, size}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cm.writeThumb(co, hash, img); err != nil {
+		fl.Warn().Err(err).Msg("writeThumb")
+	}
+
+	return img, nil
+} // }}}
@@ -0,0 +1,219 @@
+package cmanager
+
+import (
+	"context"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+)
+
+type confYAML struct {
+	MaxResolution string `yaml:"maxresolution"`
+	ImageCache    string `yaml:"imagecache"`
+
+	// This is a boolean setting that when enabled will throttle
+	// CacheManager to "be nice" to both the CPU and RAM.
+	//
+	// Specifically it will use a global mutex on all image Cache/Load
+	// functions.
+	//
+	// In my case while developing this I used a fairly old server that
+	// was doing other things beside just this.
+	//
+	// I also had multiple bases in image processor and multiple
+	// images being rendered at the same time.
+	//
+	// Initial loading of 500k of my images brought the server to a crawl.
+	// This throttles that, and once set can be disabled.
+	//
+	// This will not cause any issues if toggled on/off while running,
+	// other then with it off (default) expect more resources to be used.
+	BeNice bool `yaml:"benice"`
+
+	// Where to store the metadata database, see CManager.Metadata().
+	//
+	// Defaults to "metadata.db" within the imagecache.
+	Metadata string `yaml:"metadata"`
+
+	// Permissions and ownership of the files and directories we create within the imagecache.
+	//
+	// The modes are in octal such as "0640", default if not set is 0644 for files and 0755 for directories.
+	// Unlike the defaults, a set mode is applied exactly regardless of the umask.
+	//
+	// UID and GID default to whoever we are running as.
+	FileMode string `yaml:"filemode"`
+	DirMode  string `yaml:"dirmode"`
+	UID      *int   `yaml:"uid"`
+	GID      *int   `yaml:"gid"`
+
+	// At startup any .tmp files left behind in the imagecache (from a crash for example) older then
+	// this are removed.
+	//
+	// Default if not set is 1 hour.
+	TmpAge yconf.Duration `yaml:"tmpage"`
+
+	// If set, a thumbnail no larger then this (in pixels) on either side is also cached for each image,
+	// see CManager.LoadThumb().
+	//
+	// Default of 0 disables thumbnails.
+	ThumbSize int `yaml:"thumbsize"`
+
+	// Where the thumbnails are stored, using the same layout as the imagecache.
+	//
+	// Defaults to "thumbs" within the imagecache.
+	ThumbCache string `yaml:"thumbcache"`
+
+	// The filter used to resize images, one of "lanczos", "catmullrom", "linear", "box" or "nearest" from slowest
+	// and best to quickest.
+	//
+	// Default if not set is "lanczos", other then on 32-bit ARM where it is "linear".
+	Resize string `yaml:"resize"`
+
+	// How images are hashed, which gives their name in the imagecache and (through the IDManager) their ID.
+	// Either "sha256" (the default) or "sha512".
+	//
+	// Changing this alone would have every image cached again under a new ID, losing its merged tags and history.
+	// To move to a new hash safely set PreviousHash to the old one for a while. Images are then hashed with both,
+	// keeping the previous hash (and ID), while the new is recorded in the metadata database. ImageProc hashes every
+	// file again during this time, see types.CacheRehasher.
+	//
+	// Once done remove PreviousHash. At startup every image with a recorded new hash is renamed within the imagecache
+	// and its hash changed by the IDManager (which needs its rehash query), keeping its ID. Any image not yet seen
+	// keeps its previous hash until its file changes.
+	//
+	// Both require a restart to change.
+	Hash         string `yaml:"hash"`
+	PreviousHash string `yaml:"previoushash"`
+
+	// Cached images are encoded again from the decoded original, so carry no EXIF, GPS, XMP or anything else from
+	// it. These EXIF fields are kept from JPEG originals, such as [datetimeoriginal, copyright].
+	//
+	// Only text fields can be kept - imagedescription, make, model, software, datetime, artist, copyright,
+	// datetimeoriginal and datetimedigitized. Orientation is already applied to the image. Only applies to images
+	// cached after it is set.
+	KeepExif []string `yaml:"keepexif"`
+
+	// Limits checked before an image is decoded, so a corrupt or malicious file claiming to be enormous can not use
+	// up all the memory. An image over either fails, the same as one that can not be decoded.
+	//
+	// MaxMegapixels is the width times the height in millions, default if not set is 250. MaxBytes is the size of the
+	// file (or the output of a decoder command), default of 0 has no limit.
+	//
+	// These apply to every image decoded, not only those being cached.
+	MaxMegapixels int   `yaml:"maxmegapixels"`
+	MaxBytes      int64 `yaml:"maxbytes"`
+
+	// Overrides MaxResolution and ImageCache for the images of an ImageProc base, by base ID. Anything not set uses
+	// the global one above.
+	//
+	// Such as a base of panoramas cached at a higher resolution, or an archive cached on a different disk. An image
+	// found within more then one base is only cached once, by whichever base found it first.
+	//
+	// Images are looked for within every ImageCache, so a base can be moved to its own without caching it again by
+	// moving its files as well.
+	Bases map[int]confBaseYAML `yaml:"bases"`
+}
+
+// type confBaseYAML struct {{{
+
+type confBaseYAML struct {
+	MaxResolution string `yaml:"maxresolution"`
+	ImageCache    string `yaml:"imagecache"`
+} // }}}
+
+type conf struct {
+	MaxResolution image.Point
+	ImageCache    string
+	BeNice bool
+	Metadata      string
+
+	// 0 if not set.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// -1 if not set.
+	UID int
+	GID int
+
+	TmpAge time.Duration
+
+	ThumbSize  int
+	ThumbCache string
+
+	// Empty for the default.
+	Resize string
+
+	// PreviousHash is empty unless moving to a new hash.
+	Hash         string
+	PreviousHash string
+
+	// Lower case, empty to keep nothing.
+	KeepExif []string
+
+	// See confYAML.MaxMegapixels, MaxPixels is always set.
+	MaxPixels int64
+	MaxBytes  int64
+
+	// nil if no base has its own, see conf.base().
+	Bases map[int]confBase
+}
+
+// type confBase struct {{{
+
+// Zero for anything not set, which uses the global.
+type confBase struct {
+	MaxResolution image.Point
+	ImageCache    string
+} // }}}
+
+// How much of the start of each image is checked for EXIF to keep, see confYAML.KeepExif.
+const exifPeek = 64 * 1024
+
+// Size of the thumbnails LoadThumb() returns when ThumbSize is not set.
+const defaultThumbSize = 256
+
+// type CManager struct {{{
+
+type CManager struct {
+	l zerolog.Logger
+
+	yc *yconf.YConf
+
+	// Our configuration file.
+	cFile string
+
+	// We do not have any cache of ID or hashes, as if any repeat
+	// calls are made for the same ID/hash, IDManager has its own
+	// cache that we rely upon. No need to re-invent.
+
+	// Our configuration.
+	co atomic.Value
+
+	im types.IDManager
+
+	// Pool for our bytes.Buffer
+	bp sync.Pool
+
+	// Used to assign a uinque ID for each log line in the same function.
+	// Helps some confusion when figuring things out.
+	//
+	// Only accessed using atomics.
+	c uint64
+
+	// If the BeNice configuration option is set, this mutex
+	// is called around all Cache/Load functions.
+	beNice sync.Mutex
+
+	// Metadata for each ID, see meta.go.
+	meta *bolt.DB
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
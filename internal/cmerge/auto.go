@@ -0,0 +1,96 @@
+package cmerge
+
+import (
+	"frame/tags"
+)
+
+// Tags can also be given to a hash as a whole rather then any one file, such as those predicted by the classify
+// module. Each source has its own row per hash, see the files.autotags table.
+//
+// These come from the optional auto and auto-poll queries, and are combined with the tags of the files before the
+// tag rules are applied. A hash with no files is still disabled regardless of its auto tags.
+
+// func CMerge.autoQuery {{{
+
+// Loads the auto tags of every hash already in the cache with the auto (or with poll, auto-poll) query.
+//
+// With poll any hash whose auto tags changed is added to pollChanged.
+//
+// Does nothing if the query is not configured.
+func (cm *CMerge) autoQuery(poll bool) error {
+	var hid uint64
+	var source string
+
+	fl := cm.l.With().Str("func", "autoQuery").Bool("poll", poll).Logger()
+
+	co := cm.getConf()
+
+	name, query := "auto", co.Queries.Auto
+	if poll {
+		name, query = "auto-poll", co.Queries.AutoPoll
+	}
+
+	if query == "" {
+		return nil
+	}
+
+	db, err := cm.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return err
+	}
+
+	rows, err := db.Query(cm.ctx, name)
+	if err != nil {
+		fl.Err(err).Msg(name)
+		return err
+	}
+
+	defer rows.Close()
+
+	// Get our cache - locking is handled by our caller.
+	ca := cm.ca
+
+	if poll && ca.pollChanged == nil {
+		ca.pollChanged = make(map[uint64]*hashCache, 1)
+	}
+
+	for rows.Next() {
+		// Never reused, the tags are kept in the cache.
+		var tgs tags.Tags
+
+		// SELECT hid, source, tags FROM files.autotags
+		if err := rows.Scan(&hid, &source, &tgs); err != nil {
+			fl.Err(err).Msg("rows-scan")
+			return err
+		}
+
+		// Only hashes with files matter, anything else is not merged.
+		hc, ok := ca.hashes[hid]
+		if !ok {
+			continue
+		}
+
+		tgs = tgs.Fix()
+
+		if tgs.Equal(hc.Auto[source]) {
+			continue
+		}
+
+		if hc.Auto == nil {
+			hc.Auto = make(map[string]tags.Tags, 1)
+		}
+
+		if len(tgs) == 0 {
+			delete(hc.Auto, source)
+		} else {
+			hc.Auto[source] = tgs
+		}
+
+		if poll {
+			ca.pollChanged[hid] = hc
+		}
+	}
+
+	return rows.Err()
+} // }}}
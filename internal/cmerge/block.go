@@ -0,0 +1,48 @@
+package cmerge
+
+import (
+	"context"
+	"errors"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+
+	"github.com/rs/zerolog"
+)
+
+// func CMerge.BlockTags {{{
+
+// Returns the BlockTags currently configured, see types.WeighterBlocker.
+func (cm *CMerge) BlockTags() tags.Tags {
+	return cm.getConf().BlockTags.Copy()
+} // }}}
+
+// func LoadBlockTags {{{
+
+// Returns the BlockTags from the configuration at confPath (the same as given to New()), without loading anything
+// else. For "frame config-check", which can not run CMerge as it writes to the merged table.
+func LoadBlockTags(confPath string, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (tags.Tags, error) {
+	cm := &CMerge{
+		l:  l.With().Str("mod", "cmerge").Logger(),
+		tm: tm,
+	}
+
+	ycc := ycCallers
+	ycc.Convert = cm.yconfConvert
+
+	yc, err := yconf.New(confPath, ycc, &cm.l, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yc.CheckConf(); err != nil {
+		return nil, err
+	}
+
+	co, ok := yc.Get().(*conf)
+	if !ok {
+		return nil, errors.New("invalid config loaded")
+	}
+
+	return co.BlockTags, nil
+} // }}}
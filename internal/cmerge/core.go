@@ -4,6 +4,11 @@ package cmerge
 import (
 	"context"
 	"errors"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/internal/rules"
+	"frame/internal/scheduler"
+	"frame/internal/tracing"
 	"frame/tags"
 	"frame/types"
 	"frame/yconf"
@@ -11,11 +16,13 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/log/zerologadapter"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("frame/internal/cmerge")
+
 // func yconfMerge {{{
 
 func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
@@ -62,6 +69,22 @@ func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
 		inA.Queries.Disable = inB.Queries.Disable
 	}
 
+	if inA.Queries.Auto != inB.Queries.Auto && inB.Queries.Auto != "" {
+		inA.Queries.Auto = inB.Queries.Auto
+	}
+
+	if inA.Queries.AutoPoll != inB.Queries.AutoPoll && inB.Queries.AutoPoll != "" {
+		inA.Queries.AutoPoll = inB.Queries.AutoPoll
+	}
+
+	if inA.Queries.Dates != inB.Queries.Dates && inB.Queries.Dates != "" {
+		inA.Queries.Dates = inB.Queries.Dates
+	}
+
+	if inA.Queries.PollHashes != inB.Queries.PollHashes && inB.Queries.PollHashes != "" {
+		inA.Queries.PollHashes = inB.Queries.PollHashes
+	}
+
 	if len(inB.BlockTags) > 0 && !inA.BlockTags.Equal(inB.BlockTags) {
 		inA.BlockTags = inA.BlockTags.Combine(inB.BlockTags)
 	}
@@ -123,6 +146,18 @@ func yconfChanged(origConfInt, newConfInt interface{}) bool {
 		return true
 	}
 
+	if origConf.Queries.Auto != newConf.Queries.Auto || origConf.Queries.AutoPoll != newConf.Queries.AutoPoll {
+		return true
+	}
+
+	if origConf.Queries.Dates != newConf.Queries.Dates {
+		return true
+	}
+
+	if origConf.Queries.PollHashes != newConf.Queries.PollHashes {
+		return true
+	}
+
 	if !origConf.BlockTags.Equal(newConf.BlockTags) {
 		return true
 	}
@@ -160,6 +195,8 @@ func New(confPath string, tm types.TagManager, l *zerolog.Logger, ctx context.Co
 
 	fl := cm.l.With().Str("func", "New").Logger()
 
+	cm.db = pgdb.New(&cm.l, ctx)
+
 	// Load our configuration.
 	//
 	// This also handles connecting to the database.
@@ -177,8 +214,26 @@ func New(confPath string, tm types.TagManager, l *zerolog.Logger, ctx context.Co
 	// Start background processing to watch configuration for changes.
 	cm.yc.Start()
 
-	// Start the loop.
-	go cm.loopy()
+	// Start the regular polls and fulls.
+	co := cm.getConf()
+
+	cm.sch = scheduler.New(cm.ctx)
+
+	if err := cm.sch.Add("poll", co.PollInterval, cm.tickPoll); err != nil {
+		cm.close()
+		return nil, err
+	}
+
+	if err := cm.sch.Add("full", co.FullInterval, cm.tickFull); err != nil {
+		cm.close()
+		return nil, err
+	}
+
+	// Close down once we are done.
+	go func() {
+		<-cm.ctx.Done()
+		cm.close()
+	}()
 
 	fl.Debug().Send()
 
@@ -187,23 +242,34 @@ func New(confPath string, tm types.TagManager, l *zerolog.Logger, ctx context.Co
 
 // func CMerge.doPoll {{{
 
-func (cm *CMerge) doPoll() error {
+// Runs the named poll query with args, either "poll" or "poll-hashes" (see PollHashes()), and merges what changed.
+func (cm *CMerge) doPoll(name string, args ...interface{}) error {
 	fl := cm.l.With().Str("func", "doPoll").Logger()
 	fl.Debug().Send()
 
+	ctx, span := tracer.Start(cm.ctx, "cmerge.poll")
+	defer span.End()
+
 	ca := cm.ca
 
 	// Lock the cache
 	ca.cMut.Lock()
 	defer ca.cMut.Unlock()
 
-	db, err := cm.getDB()
+	db, err := cm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return err
 	}
 
-	if err := cm.pollQuery(); err != nil {
+	_, qs := tracer.Start(ctx, "query")
+	err = cm.pollQuery(name, args...)
+	if err == nil && name == "poll" {
+		err = cm.autoQuery(true)
+	}
+	tracing.End(qs, err)
+
+	if err != nil {
 		return err
 	}
 
@@ -214,7 +280,11 @@ func (cm *CMerge) doPoll() error {
 		return err
 	}
 
-	if err := cm.pollMerge(tx); err != nil {
+	_, ms := tracer.Start(ctx, "merge", trace.WithAttributes(attribute.Int("hashes", len(ca.pollChanged))))
+	err = cm.pollMerge(tx)
+	tracing.End(ms, err)
+
+	if err != nil {
 		fl.Err(err).Msg("pollMerge")
 		tx.Rollback(cm.ctx)
 		return err
@@ -227,11 +297,31 @@ func (cm *CMerge) doPoll() error {
 	return nil
 } // }}}
 
+// func CMerge.PollHashes {{{
+
+// Merges the hashes right away rather then waiting for the next poll, such as new files from the imgproc fast lane.
+//
+// Uses the poll-hashes query, if not set the regular poll is run instead.
+func (cm *CMerge) PollHashes(ids []uint64) error {
+	if atomic.LoadUint32(&cm.closed) != 0 {
+		return errors.New("cmerge closed")
+	}
+
+	if cm.getConf().Queries.PollHashes == "" {
+		return cm.doPoll("poll")
+	}
+
+	return cm.doPoll("poll-hashes", ids)
+} // }}}
+
 // func CMerge.doFull {{{
 
 func (cm *CMerge) doFull() error {
 	fl := cm.l.With().Str("func", "doFull").Logger()
 
+	ctx, span := tracer.Start(cm.ctx, "cmerge.full")
+	defer span.End()
+
 	ca := cm.ca
 
 	// Lock the cache
@@ -244,21 +334,33 @@ func (cm *CMerge) doFull() error {
 	}
 
 	ca.hashes = make(map[uint64]*hashCache, 1)
+	ca.loaded = false
 
 	// Get the existing merged table (if any) before anything else.
-	if err := cm.selectMerged(); err != nil {
+	_, ss := tracer.Start(ctx, "select")
+	err := cm.selectMerged()
+	tracing.End(ss, err)
+
+	if err != nil {
 		fl.Err(err).Msg("pull")
 		return err
 	}
 
 	// Pull all the files from the files table.
-	if err := cm.fullQuery(); err != nil {
+	_, qs := tracer.Start(ctx, "query")
+	err = cm.fullQuery()
+	if err == nil {
+		err = cm.autoQuery(false)
+	}
+	tracing.End(qs, err)
+
+	if err != nil {
 		return err
 	}
 
-	db, err := cm.getDB()
+	db, err := cm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return err
 	}
 
@@ -270,7 +372,11 @@ func (cm *CMerge) doFull() error {
 	}
 
 	// Merge the files into our file hash.
-	if err := cm.fullMerge(tx); err != nil {
+	_, ms := tracer.Start(ctx, "merge", trace.WithAttributes(attribute.Int("hashes", len(ca.hashes))))
+	err = cm.fullMerge(tx)
+	tracing.End(ms, err)
+
+	if err != nil {
 		fl.Err(err).Msg("fullMerge")
 		return err
 	}
@@ -280,6 +386,75 @@ func (cm *CMerge) doFull() error {
 		return err
 	}
 
+	// Every file is now in the cache.
+	ca.loaded = true
+
+	cm.lintTags(ca, cm.getConf())
+
+	return nil
+} // }}}
+
+// func CMerge.doRecompute {{{
+
+// Reruns the TagRules and BlockTags over the cache, pushing any hashes that change.
+//
+// The cache already has the tags of every file, so when only the rules change there is no need to read the
+// entire files table again like doFull() does.
+//
+// Falls back to doFull() if the cache has not been fully loaded yet.
+func (cm *CMerge) doRecompute() error {
+	fl := cm.l.With().Str("func", "doRecompute").Logger()
+
+	ca := cm.ca
+
+	// Lock the cache
+	ca.cMut.Lock()
+
+	if !ca.loaded {
+		ca.cMut.Unlock()
+
+		fl.Debug().Msg("cache not loaded, doing full")
+		return cm.doFull()
+	}
+
+	defer ca.cMut.Unlock()
+
+	ctx, span := tracer.Start(cm.ctx, "cmerge.recompute")
+	defer span.End()
+
+	db, err := cm.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return err
+	}
+
+	// Start a transaction.
+	tx, err := db.Begin(cm.ctx)
+	if err != nil {
+		fl.Err(err).Msg("Begin")
+		return err
+	}
+
+	// fullMerge() runs hashCheck() over every hash, which is all we need.
+	_, ms := tracer.Start(ctx, "merge", trace.WithAttributes(attribute.Int("hashes", len(ca.hashes))))
+	err = cm.fullMerge(tx)
+	tracing.End(ms, err)
+
+	if err != nil {
+		fl.Err(err).Msg("fullMerge")
+		tx.Rollback(cm.ctx)
+		return err
+	}
+
+	if err := tx.Commit(cm.ctx); err != nil {
+		fl.Err(err).Msg("commit")
+		return err
+	}
+
+	fl.Debug().Int("hashes", len(ca.hashes)).Send()
+
+	cm.lintTags(ca, cm.getConf())
+
 	return nil
 } // }}}
 
@@ -290,12 +465,13 @@ func (cm *CMerge) selectMerged() error {
 	var hid uint64
 	var tgs tags.Tags
 	var blocked bool
+	var earliest, latest *time.Time
 
 	fl := cm.l.With().Str("func", "selectMerged").Logger()
 
-	db, err := cm.getDB()
+	db, err := cm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return err
 	}
 
@@ -309,9 +485,15 @@ func (cm *CMerge) selectMerged() error {
 	// Get our cache - locking is handled by our caller.
 	ca := cm.ca
 
+	// The dates are optional, without them every hash with a date is written again by the first merge.
+	dest := []interface{}{&hid, &tgs, &blocked}
+	if len(fullRows.FieldDescriptions()) > len(dest) {
+		dest = append(dest, &earliest, &latest)
+	}
+
 	for fullRows.Next() {
 		// SELECT hid, tags, blocked FROM files.merged WHERE enabled
-		if err := fullRows.Scan(&hid, &tgs, &blocked); err != nil {
+		if err := fullRows.Scan(dest...); err != nil {
 			fullRows.Close()
 			fl.Err(err).Msg("select-rows-scan")
 			return err
@@ -327,10 +509,12 @@ func (cm *CMerge) selectMerged() error {
 		//
 		// As the hash is unique we also don't need to care about merging here.
 		ca.hashes[hid] = &hashCache{
-			ID:      hid,
-			Tags:    tgs,
-			Blocked: blocked,
-			merged:  true,
+			ID:       hid,
+			Tags:     tgs,
+			Blocked:  blocked,
+			Earliest: toTime(earliest),
+			Latest:   toTime(latest),
+			merged:   true,
 
 			// Create the empty Files hash, as we expect something to be adde when we do the full.
 			Files: make(map[uint64]*fileCache, 1),
@@ -344,23 +528,24 @@ func (cm *CMerge) selectMerged() error {
 
 // func CMerge.pollQuery {{{
 
-func (cm *CMerge) pollQuery() error {
+func (cm *CMerge) pollQuery(name string, args ...interface{}) error {
 	var fid, hid uint64
 	var changed, enabled bool
 	var tgs tags.Tags
+	var taken *time.Time
 
 	fl := cm.l.With().Str("func", "pollQuery").Logger()
 
-	db, err := cm.getDB()
+	db, err := cm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return err
 	}
 
 	// The query should already be prepared at connection.
-	pollRows, err := db.Query(cm.ctx, "poll")
+	pollRows, err := db.Query(cm.ctx, name, args...)
 	if err != nil {
-		fl.Err(err).Msg("poll")
+		fl.Err(err).Msg(name)
 		return err
 	}
 
@@ -376,6 +561,12 @@ func (cm *CMerge) pollQuery() error {
 		ca.pollChanged = make(map[uint64]*hashCache, 1)
 	}
 
+	// When the file was taken is optional, see dates.go.
+	dest := []interface{}{&fid, &hid, &tgs, &enabled}
+	if len(pollRows.FieldDescriptions()) > len(dest) {
+		dest = append(dest, &taken)
+	}
+
 	for pollRows.Next() {
 		// SELECT fid, hid, tags, enabled FROM files.files WHERE updated >= NOW() - interval '5 minutes'
 		//
@@ -391,7 +582,7 @@ func (cm *CMerge) pollQuery() error {
 		//
 		// So I opted to move the update tracking to the query itself, and only get recently changed rows based off
 		// the current time.
-		if err := pollRows.Scan(&fid, &hid, &tgs, &enabled); err != nil {
+		if err := pollRows.Scan(dest...); err != nil {
 			pollRows.Close()
 			fl.Err(err).Msg("poll-rows-scan")
 			return err
@@ -452,6 +643,11 @@ func (cm *CMerge) pollQuery() error {
 			changed = true
 		}
 
+		if t := toTime(taken); !t.Equal(fc.Taken) {
+			fc.Taken = t
+			changed = true
+		}
+
 		// If this hash changed in some way, add it to pollChanged.
 		//
 		// Note that duplicates are OK, we expect them to happen occasionally.
@@ -474,12 +670,13 @@ func (cm *CMerge) pollQuery() error {
 func (cm *CMerge) fullQuery() error {
 	var fid, hid uint64
 	var tgs tags.Tags
+	var taken *time.Time
 
 	fl := cm.l.With().Str("func", "fullQuery").Logger()
 
-	db, err := cm.getDB()
+	db, err := cm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return err
 	}
 
@@ -493,9 +690,15 @@ func (cm *CMerge) fullQuery() error {
 	// Get our cache - locking is handled by our caller.
 	ca := cm.ca
 
+	// When the file was taken is optional, see dates.go.
+	dest := []interface{}{&fid, &hid, &tgs}
+	if len(fullRows.FieldDescriptions()) > len(dest) {
+		dest = append(dest, &taken)
+	}
+
 	for fullRows.Next() {
 		// SELECT fid, hid, tags FROM files.files WHERE enabled
-		if err := fullRows.Scan(&fid, &hid, &tgs); err != nil {
+		if err := fullRows.Scan(dest...); err != nil {
 			fullRows.Close()
 			fl.Err(err).Msg("full-rows-scan")
 			return err
@@ -530,6 +733,8 @@ func (cm *CMerge) fullQuery() error {
 			fc.Tags = tgs
 		}
 
+		fc.Taken = toTime(taken)
+
 		// We don't calculate anything else here, we just load the rows and sync it up here.
 	}
 
@@ -577,26 +782,22 @@ func (cm *CMerge) hashCheck(hc *hashCache, co *conf) error {
 		tgs = tgs.Combine(fc.Tags)
 	}
 
-	// Now apply the rules in the order they were loaded.
-	//
-	// Note that we could use TagRules.Apply(), but we want to log when a rule is applied to know better whats going on.
-	// This is basically the same as that function though.
-	//
-	// If we ever remove the debug logging here? Then just switch to that function and remove the range here.
-	for _, tr := range co.TagRules {
-		if tr.Give(tgs) {
-
-			// For debugging we want the actual tagrule name rather then the uint64 ID, makes things a bit easier.
-			if fl.GetLevel() <= zerolog.DebugLevel {
-				name, err := cm.tm.Name(tr.Tag)
-				if err != nil {
-					fl.Debug().Uint64("tagruleid", tr.Tag).Send()
-				} else {
-					fl.Debug().Str("tagrule", name).Send()
-				}
-			}
+	for _, atgs := range hc.Auto {
+		tgs = tgs.Combine(atgs)
+	}
 
-			tgs = tgs.Add(tr.Tag)
+	// Now apply the rules, see the rules package for the order.
+	tgs, given := co.rules.Trace(tgs)
+
+	// For debugging we want the actual tagrule name rather then the uint64 ID, makes things a bit easier.
+	if fl.GetLevel() <= zerolog.DebugLevel {
+		for _, tag := range given {
+			name, err := cm.tm.Name(tag)
+			if err != nil {
+				fl.Debug().Uint64("tagruleid", tag).Send()
+			} else {
+				fl.Debug().Str("tagrule", name).Send()
+			}
 		}
 	}
 
@@ -607,6 +808,13 @@ func (cm *CMerge) hashCheck(hc *hashCache, co *conf) error {
 		hc.Tags = tgs
 	}
 
+	// Only worth writing if there is somewhere to write them.
+	if co.Queries.Dates != "" && hc.checkDates() {
+		fl.Debug().Msg("dates")
+		hc.Changed = true
+		hc.datesChanged = true
+	}
+
 	// Is this file blocked?
 	block = hc.Tags.Contains(co.BlockTags)
 	if block != hc.Blocked {
@@ -656,6 +864,10 @@ func (cm *CMerge) pushHash(hc *hashCache, tx pgx.Tx) error {
 			return err
 		}
 
+		if err := cm.pushDates(hc, tx); err != nil {
+			return err
+		}
+
 		// Changes written, so clear Changed.
 		hc.Changed = false
 		return nil
@@ -668,6 +880,10 @@ func (cm *CMerge) pushHash(hc *hashCache, tx pgx.Tx) error {
 		return err
 	}
 
+	if err := cm.pushDates(hc, tx); err != nil {
+		return err
+	}
+
 	// Changes written, so clear Changed.
 	hc.Changed = false
 
@@ -679,6 +895,25 @@ func (cm *CMerge) pushHash(hc *hashCache, tx pgx.Tx) error {
 	return nil
 } // }}}
 
+// func CMerge.pushDates {{{
+
+// Writes Earliest and Latest if they changed, after the row itself is written by pushHash().
+func (cm *CMerge) pushDates(hc *hashCache, tx pgx.Tx) error {
+	if !hc.datesChanged || cm.getConf().Queries.Dates == "" {
+		return nil
+	}
+
+	// UPDATE files.merged SET earliest = $2, latest = $3 WHERE hid = $1
+	if _, err := tx.Exec(cm.ctx, "dates", hc.ID, timeArg(hc.Earliest), timeArg(hc.Latest)); err != nil {
+		cm.l.Err(err).Str("func", "pushDates").Uint64("hid", hc.ID).Msg("dates")
+		return err
+	}
+
+	hc.datesChanged = false
+
+	return nil
+} // }}}
+
 // func CMerge.pollMerge {{{
 
 // Generally called after pollQuery(), runs through the cache and updates all the tags.
@@ -828,6 +1063,14 @@ func (cm *CMerge) checkConf(co *conf, reload bool) (bool, uint64) {
 		ucBits |= ucDBQuery
 	}
 
+	if co.Queries.Auto != oldco.Queries.Auto || co.Queries.AutoPoll != oldco.Queries.AutoPoll {
+		ucBits |= ucDBQuery
+	}
+
+	if co.Queries.Dates != oldco.Queries.Dates || co.Queries.PollHashes != oldco.Queries.PollHashes {
+		ucBits |= ucDBQuery
+	}
+
 	if !co.BlockTags.Equal(oldco.BlockTags) {
 		ucBits |= ucBlockTags
 	}
@@ -877,7 +1120,7 @@ func (cm *CMerge) loadConf() error {
 		return err
 	}
 
-	fl.Debug().Interface("conf", cm.yc.Get()).Send()
+	fl.Debug().Interface("conf", redact.Conf(cm.yc.Get())).Send()
 
 	// Get the loaded configuration
 	co, ok := cm.yc.Get().(*conf)
@@ -895,7 +1138,7 @@ func (cm *CMerge) loadConf() error {
 
 	// Yep, so go ahead and create a new connection and get it prepared to replace the existing one.
 	if err := cm.dbConnect(co); err != nil {
-		fl.Err(err).Str("db", co.Database).Msg("new dbConnect")
+		fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
 		return err
 	}
 
@@ -932,7 +1175,7 @@ func (cm *CMerge) notifyConf() {
 	// Since all our queries are prepared at connection time, this any issues having to rebind them.
 	if ucBits&(ucDBConn|ucDBQuery) != 0 {
 		if err := cm.dbConnect(co); err != nil {
-			fl.Err(err).Str("db", co.Database).Msg("new dbConnect")
+			fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
 			return
 		}
 	}
@@ -947,11 +1190,13 @@ func (cm *CMerge) notifyConf() {
 	// This has the side benefit of allowing us at runtime to connect to a new empty database and just carry
 	// on without issue.
 	//
-	// Obviously changing any of the TagRules or BlockTags would force another full, as skipping a full on these would
-	// mean only updated files would apply these new rules.
-	if ucBits&(ucDBConn|ucDBQuery|ucTagRules|ucBlockTags) != 0 {
+	// Changing only the TagRules or BlockTags does not need the files again, the cache already has them. So we
+	// just rerun the rules over the cache, otherwise only updated files would apply these new rules.
+	if ucBits&(ucDBConn|ucDBQuery) != 0 {
 		// Something changed that should force a full
 		go cm.doFull()
+	} else if ucBits&(ucTagRules|ucBlockTags) != 0 {
+		go cm.doRecompute()
 	}
 
 	// Note - We did not check ucPullInt here, thats handled in the loop and it will adjust on its next run.
@@ -993,22 +1238,24 @@ func (cm *CMerge) yconfConvert(inInt interface{}) (interface{}, error) {
 		}
 	}
 
+	out.rules = rules.New(out.TagRules, 0)
+
 	if in.PollInterval > 0 {
+		out.PollInterval = time.Duration(in.PollInterval)
+
 		// Some basic sanity, force at least 1 second.
-		if in.PollInterval < time.Second {
+		if out.PollInterval < time.Second {
 			return nil, errors.New("PollInterval too short")
 		}
-
-		out.PollInterval = in.PollInterval
 	}
 
 	if in.FullInterval > 0 {
+		out.FullInterval = time.Duration(in.FullInterval)
+
 		// Some basic sanity, force at least 1 minute.
-		if in.FullInterval < time.Minute {
+		if out.FullInterval < time.Minute {
 			return nil, errors.New("FullInterval too short")
 		}
-
-		out.FullInterval = in.FullInterval
 	}
 
 	return out, nil
@@ -1017,113 +1264,23 @@ func (cm *CMerge) yconfConvert(inInt interface{}) (interface{}, error) {
 // func CMerge.dbConnect {{{
 
 func (cm *CMerge) dbConnect(co *conf) error {
-	var err error
-	var db *pgxpool.Pool
-
-	poolConf, err := pgxpool.ParseConfig(co.Database)
-	if err != nil {
-		return err
-	}
-
-	// Set the log level properly.
-	cc := poolConf.ConnConfig
-	cc.LogLevel = pgx.LogLevelInfo
-	cc.Logger = zerologadapter.NewLogger(cm.l)
-
-	queries := &co.Queries
-
-	// So that each connection creates our prepared statements.
-	poolConf.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		if err := cm.setupDB(queries, conn); err != nil {
-			return err
-		}
-
-		return nil
-	}
-
-	if db, err = pgxpool.ConnectConfig(cm.ctx, poolConf); err != nil {
-		return err
-	}
-
-	// Get the old DB (if it exists, first time it won't be set).
-	oldDB, ok := cm.db.Load().(*pgxpool.Pool)
-
-	// Set the new DB (especially before we close the possible old connection)
-	cm.db.Store(db)
-
-	// Close the old DB if it was set, now that the new one has replaced it.
-	if ok {
-		// We do this in the background, as anyone who is using it will block the Close() from returning.
-		go oldDB.Close()
-	}
-
-	return nil
-} // }}}
-
-// func CMerge.setupDB {{{
-
-// This creates all prepared statements, and if everything goes OK replaces cm.db with this provided db.
-func (cm *CMerge) setupDB(qu *confQueries, db *pgx.Conn) error {
-	fl := cm.l.With().Str("func", "setupDB").Logger()
-
-	// No using the database after a shutdown.
-	if atomic.LoadUint32(&cm.closed) == 1 {
-		fl.Debug().Msg("called after shutdown")
-		return types.ErrShutdown
-	}
-
-	// Lets prepare all our statements
-	if _, err := db.Prepare(cm.ctx, "full", qu.Full); err != nil {
-		fl.Err(err).Msg("full")
-		return err
-	}
-
-	if _, err := db.Prepare(cm.ctx, "poll", qu.Poll); err != nil {
-		fl.Err(err).Msg("poll")
-		return err
-	}
-
-	if _, err := db.Prepare(cm.ctx, "select", qu.Select); err != nil {
-		fl.Err(err).Msg("select")
-		return err
-	}
-
-	if _, err := db.Prepare(cm.ctx, "insert", qu.Insert); err != nil {
-		fl.Err(err).Msg("insert")
-		return err
-	}
-
-	if _, err := db.Prepare(cm.ctx, "update", qu.Update); err != nil {
-		fl.Err(err).Msg("update")
-		return err
-	}
-
-	if _, err := db.Prepare(cm.ctx, "disable", qu.Disable); err != nil {
-		fl.Err(err).Msg("disable")
-		return err
-	}
-
-	fl.Debug().Msg("prepared")
-
-	return nil
-} // }}}
-
-// func CMerge.getDB {{{
-
-// Returns the current database pool.
-//
-// Loads it from an atomic value so that it can be replaced while running without causing issues.
-func (cm *CMerge) getDB() (*pgxpool.Pool, error) {
-	fl := cm.l.With().Str("func", "getDB").Logger()
-
-	db, ok := cm.db.Load().(*pgxpool.Pool)
-	if !ok {
-		err := errors.New("Not a pool")
-		fl.Warn().Err(err).Send()
-		return nil, err
-	}
-
-	return db, nil
+	qu := &co.Queries
+
+	return cm.db.Connect(&pgdb.Config{
+		Database: co.Database,
+		Statements: []pgdb.Statement{
+			{Name: "full", Query: qu.Full},
+			{Name: "poll", Query: qu.Poll},
+			{Name: "select", Query: qu.Select},
+			{Name: "insert", Query: qu.Insert},
+			{Name: "update", Query: qu.Update},
+			{Name: "disable", Query: qu.Disable},
+			{Name: "auto", Query: qu.Auto},
+			{Name: "auto-poll", Query: qu.AutoPoll},
+			{Name: "dates", Query: qu.Dates},
+			{Name: "poll-hashes", Query: qu.PollHashes},
+		},
+	})
 } // }}}
 
 // func CMerge.getConf {{{
@@ -1142,82 +1299,44 @@ func (cm *CMerge) getConf() *conf {
 	return &conf{}
 } // }}}
 
-// func CMerge.loopy {{{
+// func CMerge.tickPoll {{{
 
-// Handles our basic background tasks, full and poll queries.
-func (cm *CMerge) loopy() {
-	var errors uint32 = 0
+// Run by the scheduler to do the regular poll.
+//
+// On errors we back off on how frequently we poll, for the sanity of those hopefully trying to fix the problem.
+func (cm *CMerge) tickPoll() {
+	fl := cm.l.With().Str("func", "tickPoll").Logger()
 
-	fl := cm.l.With().Str("func", "loopy").Logger()
+	if err := cm.doPoll("poll"); err != nil {
+		fl.Err(err).Msg("doPoll")
+		cm.pollErrs++
+	} else {
+		// No error, so reset any possible error count.
+		cm.pollErrs = 0
+	}
 
-	// We need to know how often we poll.
+	// Handles both the PollInterval changing and the backoff.
+	//
+	// Does nothing if the interval is the same.
 	co := cm.getConf()
+	if err := cm.sch.Reschedule("poll", co.PollInterval*time.Duration(cm.pollErrs+1)); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+} // }}}
 
-	// Save the current PollInterval so we know if it changes.
-	pollInt := co.PollInterval
-	fullInt := co.FullInterval
-
-	nextPoll := time.NewTicker(pollInt)
-	nextFull := time.NewTicker(fullInt)
-
-	defer func() {
-		nextPoll.Stop()
-		nextFull.Stop()
-	}()
-
-	ctx := cm.ctx
-
-	for {
-		select {
-		case _, ok := <-ctx.Done():
-			if !ok {
-				cm.close()
-				return
-			}
-		case <-nextPoll.C:
-			// Get the configuration and check if PollInterval changed
-			co = cm.getConf()
-
-			if co.PollInterval != pollInt {
-				// It changed, so reset the ticker.
-				fl.Info().Msg("Updated PollInterval")
-				pollInt = co.PollInterval
-				nextPoll.Reset(pollInt)
-			}
-
-			// Run a pull.
-			if err := cm.doPoll(); err != nil {
-				fl.Err(err).Msg("doPoll")
+// func CMerge.tickFull {{{
 
-				// If we get a poll error, we back off on how frequently we run for sanity of those hopefully
-				// trying to fix the problem.
-				errors += 1
+// Run by the scheduler to do the regular full.
+func (cm *CMerge) tickFull() {
+	fl := cm.l.With().Str("func", "tickFull").Logger()
 
-				// Update the ticker to add the errors.
-				nextPoll.Reset(pollInt * time.Duration(time.Second*time.Duration(errors)))
-			} else {
-				// No error, so reset any possible error count.
-				if errors > 0 {
-					nextPoll.Reset(pollInt)
-					errors = 0
-				}
-			}
-		case <-nextFull.C:
-			// Get the configuration and check if PollInterval changed
-			co = cm.getConf()
-
-			if co.FullInterval != fullInt {
-				// It changed, so reset the ticker.
-				fl.Info().Msg("Updated FullInterval")
-				fullInt = co.FullInterval
-				nextFull.Reset(fullInt)
-			}
+	if err := cm.doFull(); err != nil {
+		fl.Err(err).Msg("doFull")
+	}
 
-			// Run a full.
-			if err := cm.doFull(); err != nil {
-				fl.Err(err).Msg("doFull")
-			}
-		}
+	co := cm.getConf()
+	if err := cm.sch.Reschedule("full", co.FullInterval); err != nil {
+		fl.Err(err).Msg("Reschedule")
 	}
 } // }}}
 
@@ -1233,9 +1352,7 @@ func (cm *CMerge) close() {
 		return
 	}
 
-	if db, err := cm.getDB(); err == nil {
-		db.Close()
-	}
+	cm.db.Close()
 
 	fl.Info().Msg("closed")
 } // }}}
@@ -0,0 +1,69 @@
+package cmerge
+
+import (
+	"time"
+)
+
+// Each file can have when it was taken (such as from its EXIF, see files.taken) as an optional last column of the full
+// and poll queries. The earliest and latest of every file sharing a hash are then written to the merged table with
+// the optional dates query, so the Weighter can go by when an image was taken without joining back to the files.
+
+// func hashCache.takenRange {{{
+
+// Returns the earliest and latest of when each file was taken, both zero if none are known.
+func (hc *hashCache) takenRange() (time.Time, time.Time) {
+	var earliest, latest time.Time
+
+	for _, fc := range hc.Files {
+		if fc.Taken.IsZero() {
+			continue
+		}
+
+		if earliest.IsZero() || fc.Taken.Before(earliest) {
+			earliest = fc.Taken
+		}
+
+		if latest.IsZero() || fc.Taken.After(latest) {
+			latest = fc.Taken
+		}
+	}
+
+	return earliest, latest
+} // }}}
+
+// func hashCache.checkDates {{{
+
+// Updates Earliest and Latest from the files, returning true if either changed.
+func (hc *hashCache) checkDates() bool {
+	earliest, latest := hc.takenRange()
+
+	if earliest.Equal(hc.Earliest) && latest.Equal(hc.Latest) {
+		return false
+	}
+
+	hc.Earliest, hc.Latest = earliest, latest
+
+	return true
+} // }}}
+
+// func toTime {{{
+
+// Returns the time a nullable column was scanned into, zero for NULL.
+func toTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return *t
+} // }}}
+
+// func timeArg {{{
+
+// Returns t as a query argument, NULL if zero.
+func timeArg(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+} // }}}
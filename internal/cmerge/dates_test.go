@@ -0,0 +1,39 @@
+package cmerge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDates(t *testing.T) {
+	jan := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	hc := &hashCache{
+		Files: map[uint64]*fileCache{
+			1: {ID: 1, Taken: jun},
+			2: {ID: 2},
+			3: {ID: 3, Taken: jan},
+		},
+	}
+
+	if !hc.checkDates() {
+		t.Fatal("checkDates = false, want true")
+	}
+
+	if !hc.Earliest.Equal(jan) || !hc.Latest.Equal(jun) {
+		t.Errorf("range = %s - %s, want %s - %s", hc.Earliest, hc.Latest, jan, jun)
+	}
+
+	if hc.checkDates() {
+		t.Error("checkDates = true without any change")
+	}
+
+	// Only the file without a date left.
+	delete(hc.Files, 1)
+	delete(hc.Files, 3)
+
+	if !hc.checkDates() || !hc.Earliest.IsZero() || !hc.Latest.IsZero() {
+		t.Errorf("range = %s - %s, want both zero", hc.Earliest, hc.Latest)
+	}
+}
@@ -0,0 +1,59 @@
+package cmerge
+
+import (
+	"fmt"
+	"frame/tags"
+)
+
+// func CMerge.lintTags {{{
+
+// Warns about every tag the TagRules or BlockTags refer to that no file has, once for each configuration.
+//
+// The tags checked against include those the rules give, so a rule wanting a tag another rule gives is fine.
+//
+// Needs the cMut lock.
+func (cm *CMerge) lintTags(ca *cache, co *conf) {
+	if ca.linted == co || len(ca.hashes) < 1 {
+		return
+	}
+
+	ca.linted = co
+
+	fl := cm.l.With().Str("func", "lintTags").Logger()
+
+	refs := make(tags.TagRefs)
+	refs.Add(co.BlockTags, "blocktags")
+
+	for i := range co.TagRules {
+		tr := &co.TagRules[i]
+		refs.Add(tr.Tags(), "tagrule "+cm.tagName(tr.Tag))
+	}
+
+	seen := make(map[uint64]bool, 100)
+	for _, hc := range ca.hashes {
+		if hc.Disabled {
+			continue
+		}
+
+		for _, tag := range hc.Tags {
+			seen[tag] = true
+		}
+	}
+
+	has := func(tag uint64) bool { return seen[tag] }
+
+	for _, w := range refs.Missing(has, cm.tagName) {
+		fl.Warn().Msg(w)
+	}
+} // }}}
+
+// func CMerge.tagName {{{
+
+// Returns the name of the tag, or the ID if that fails.
+func (cm *CMerge) tagName(tag uint64) string {
+	if name, err := cm.tm.Name(tag); err == nil {
+		return name
+	}
+
+	return fmt.Sprintf("#%d", tag)
+} // }}}
@@ -2,6 +2,9 @@ package cmerge
 
 import (
 	"context"
+	"frame/internal/pgdb"
+	"frame/internal/rules"
+	"frame/internal/scheduler"
 	"frame/tags"
 	"frame/types"
 	"frame/yconf"
@@ -19,10 +22,22 @@ type confQueries struct {
 	Insert  string `yaml:"insert"`
 	Update  string `yaml:"update"`
 	Disable string `yaml:"disable"`
+
+	// Optional - The tags each source (such as the classify module) gave each hash as a whole, returning the hash ID,
+	// source and tags. Auto is run along with Full, AutoPoll along with Poll.
+	Auto     string `yaml:"auto"`
+	AutoPoll string `yaml:"auto-poll"`
+
+	// Optional - Sets the earliest and latest of when the files of a hash were taken, given the hash ID, earliest and
+	// latest (either can be NULL). Only useful when Full and Poll return when each file was taken as a last column.
+	Dates string `yaml:"dates"`
+
+	// Optional - Same as Poll but for just the hash IDs given as its only argument (a bigint[]), see PollHashes().
+	PollHashes string `yaml:"poll-hashes"`
 }
 
 type confYAML struct {
-	Database string `yaml:"database"`
+	Database string `yaml:"database" log:"redact"`
 
 	Queries confQueries `yaml:"queries"`
 
@@ -33,10 +48,10 @@ type confYAML struct {
 	BlockTags []string
 
 	// Every interval we run the Poll query
-	PollInterval time.Duration `yaml:"pollinterval"`
+	PollInterval yconf.Duration `yaml:"pollinterval"`
 
 	// Every interval we run the Full query
-	FullInterval time.Duration `yaml:"fullinterval"`
+	FullInterval yconf.Duration `yaml:"fullinterval"`
 }
 
 // Updated configuration bits
@@ -50,13 +65,16 @@ const (
 )
 
 type conf struct {
-	Database string
+	Database string `log:"redact"`
 
 	Queries confQueries
 
 	// Our tag rules, which we apply when merging.
 	TagRules tags.TagRules
 
+	// Applies TagRules, made along with them.
+	rules *rules.Engine
+
 	// If a file contains any of these tags, they are flagged as blocked
 	BlockTags tags.Tags
 
@@ -70,6 +88,9 @@ type conf struct {
 type fileCache struct {
 	ID   uint64
 	Tags tags.Tags
+
+	// When the file was taken, zero if not known.
+	Taken time.Time
 }
 
 // type hashCache struct {{{
@@ -86,6 +107,16 @@ type hashCache struct {
 
 	Files map[uint64]*fileCache
 
+	// The tags of the hash as a whole by their source, see autoQuery().
+	Auto map[string]tags.Tags
+
+	// The earliest and latest of when the files were taken, see takenRange().
+	Earliest time.Time
+	Latest   time.Time
+
+	// If Earliest or Latest changed since last written.
+	datesChanged bool
+
 	// If this hash should be disabled or not.
 	//
 	// Once disabled in the DB then it will be removed from our cache.
@@ -110,6 +141,18 @@ type cache struct {
 	// This also requires having a lock on cMut to access, as these point to the same values
 	// in the hashes map above.
 	pollChanged map[uint64]*hashCache
+
+	// Set once a doFull() has loaded every file into hashes, cleared at the start of each.
+	//
+	// doRecompute() needs this, otherwise hashes missing their files would be disabled.
+	//
+	// Need cMut to access.
+	loaded bool
+
+	// The configuration lintTags() last checked, so each is only checked once.
+	//
+	// Need cMut to access.
+	linted *conf
 } // }}}
 
 // type CMerge struct {{{
@@ -120,10 +163,8 @@ type CMerge struct {
 	// Our cache, main reason we are all here.
 	ca *cache
 
-	// Stores the *pgxpool.Pool
-	//
-	// We use an atomic because we want to be able to replace the connection while we are running.
-	db atomic.Value
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
 
 	// We use an atomic for the configuration since we might replace it at any time while another goroutine
 	// can be using it.
@@ -143,6 +184,12 @@ type CMerge struct {
 
 	// Used to control shutting down background goroutines.
 	ctx context.Context
+
+	// Runs the regular poll and full queries.
+	sch *scheduler.Scheduler
+
+	// How many polls in a row have failed, only used by tickPoll().
+	pollErrs uint32
 } // }}}
 
 // Convert and Notify are set in New()
@@ -0,0 +1,690 @@
+// Generates the configuration reference for every module.
+//
+// Each module documents its YAML configuration in the comments of its confYAML (or conf) struct. That is the
+// only real documentation of it, and with the number of options now it is hard to find anything without reading
+// the code.
+//
+// We read the source rather then using reflect, as reflect can not see the comments which is where all the
+// documentation is. The structs are followed the same way yaml.v3 would, nested structs, lists, maps and inline.
+package confdoc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// type Module struct {{{
+
+// A module and the struct its YAML configuration is loaded into.
+type Module struct {
+	// Name used for the section, as well as the key within the main configuration.
+	Name string
+
+	// Directory of the package, relative to the source root.
+	Dir string
+
+	// The struct yconf loads the YAML into, the Empty() type in its yconf.Callers.
+	Type string
+
+	// Short description of the module and where its configuration path comes from.
+	Desc string
+} // }}}
+
+// Every module with a configuration, in the order they are documented.
+var Modules = []Module{
+	{"frame", "bin/frame", "confFile", "The main configuration given to bin/frame with -conf, listing the configuration path of every module to load."},
+	{"tagmanager", "internal/tagmanager", "conf", "Maps tag names to IDs."},
+	{"idmanager", "internal/idmanager", "conf", "Maps image hashes to IDs."},
+	{"cachemanager", "internal/cmanager", "confYAML", "The image cache."},
+	{"imageproc", "internal/imgproc", "confYAML", "Scans the bases for images and their tags, caching them and loading them into the database."},
+	{"cachemerge", "internal/cmerge", "confYAML", "Merges the files found by imageproc into a single row per image."},
+	{"weighter", "internal/weighter", "confYAML", "Loads the merged images and weighs them for each profile."},
+	{"classify", "internal/classify", "confYAML", "Tags images by what they show with a local model, see also the cachemerge auto queries."},
+	{"faces", "internal/faces", "confYAML", "Groups the faces found in images into clusters, tagging the people once labeled with \"frame faces\"."},
+	{"screen", "internal/screen", "confYAML", "Flags images with sensitive content in the bases opted in, so BlockTags can keep them off frames."},
+	{"render", "internal/render", "confYAML", "Renders the profiles into image files."},
+	{"api", "api", "conf", "The gRPC API."},
+} // }}}
+
+// type Field struct {{{
+
+// A single YAML key.
+type Field struct {
+	Key string
+
+	// Description of the type, such as "string", "list of string" or "map of string to int".
+	Type string
+
+	// The comment on the field within the struct.
+	Doc string
+
+	// Set for structs, or lists or maps of structs.
+	Fields []*Field
+
+	// How Fields are nested within the YAML.
+	//
+	// 0 for a plain struct, kindList for a list of them and kindMap for a map of them.
+	kind int
+} // }}}
+
+const (
+	kindStruct = iota
+	kindList
+	kindMap
+)
+
+// type pkgTypes struct {{{
+
+// The type declarations of a single package, along with their comments.
+type pkgTypes struct {
+	types map[string]*ast.TypeSpec
+	docs  map[string]string
+
+	// Import name to path, used to follow types in other packages.
+	imports map[string]string
+} // }}}
+
+// type Generator struct {{{
+
+type Generator struct {
+	// The source root, the directory containing go.mod.
+	root string
+
+	// The module path from go.mod, imports starting with this are within root.
+	modPath string
+
+	fset *token.FileSet
+	pkgs map[string]*pkgTypes
+} // }}}
+
+// func New {{{
+
+// Creates a Generator for the source tree at root.
+func New(root string) (*Generator, error) {
+	mod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Generator{
+		root: root,
+		fset: token.NewFileSet(),
+		pkgs: make(map[string]*pkgTypes),
+	}
+
+	for _, line := range strings.Split(string(mod), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+			g.modPath = fields[1]
+			break
+		}
+	}
+
+	if g.modPath == "" {
+		return nil, errors.New("no module in go.mod")
+	}
+
+	return g, nil
+} // }}}
+
+// func Generator.load {{{
+
+// Parses the package within dir (relative to root), only once.
+func (g *Generator) load(dir string) (*pkgTypes, error) {
+	if pt, ok := g.pkgs[dir]; ok {
+		return pt, nil
+	}
+
+	filter := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}
+
+	pkgs, err := parser.ParseDir(g.fset, filepath.Join(g.root, dir), filter, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	pt := &pkgTypes{
+		types:   make(map[string]*ast.TypeSpec),
+		docs:    make(map[string]string),
+		imports: make(map[string]string),
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, imp := range file.Imports {
+				path, _ := strconv.Unquote(imp.Path.Value)
+
+				name := filepath.Base(path)
+				if imp.Name != nil {
+					name = imp.Name.Name
+				}
+
+				pt.imports[name] = path
+			}
+
+			for _, decl := range file.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.TYPE {
+					continue
+				}
+
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					pt.types[ts.Name.Name] = ts
+
+					// Single type declarations have the comment on the GenDecl.
+					doc := ts.Doc
+					if doc == nil {
+						doc = gd.Doc
+					}
+
+					pt.docs[ts.Name.Name] = docText(doc)
+				}
+			}
+		}
+	}
+
+	g.pkgs[dir] = pt
+
+	return pt, nil
+} // }}}
+
+// func docText {{{
+
+// Returns the text of the comment, without our fold markers.
+func docText(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+
+	var lines []string
+
+	for _, line := range strings.Split(cg.Text(), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "type ") && strings.HasSuffix(line, "{{{") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+} // }}}
+
+// func Generator.Fields {{{
+
+// Returns the YAML keys of the module.
+func (g *Generator) Fields(m Module) ([]*Field, error) {
+	pt, err := g.load(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, ok := pt.types[m.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: no type %s", m.Dir, m.Type)
+	}
+
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is not a struct", m.Dir, m.Type)
+	}
+
+	return g.structFields(m.Dir, st, 0)
+} // }}}
+
+// func Generator.structFields {{{
+
+func (g *Generator) structFields(dir string, st *ast.StructType, depth int) ([]*Field, error) {
+	var out []*Field
+
+	// Recursive types would otherwise never end.
+	if depth > 10 {
+		return nil, nil
+	}
+
+	for _, af := range st.Fields.List {
+		var key string
+		var inline bool
+
+		if af.Tag != nil {
+			tag, _ := strconv.Unquote(af.Tag.Value)
+			parts := strings.Split(reflect.StructTag(tag).Get("yaml"), ",")
+			key = parts[0]
+
+			for _, opt := range parts[1:] {
+				if opt == "inline" {
+					inline = true
+				}
+			}
+		}
+
+		if key == "-" {
+			continue
+		}
+
+		// Inline structs have their fields added as if they were our own.
+		if inline {
+			fdir, fst, err := g.resolveStruct(dir, af.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			if fst == nil {
+				continue
+			}
+
+			fields, err := g.structFields(fdir, fst, depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, fields...)
+			continue
+		}
+
+		for _, name := range af.Names {
+			// yaml.v3 ignores unexported fields.
+			if !name.IsExported() {
+				continue
+			}
+
+			f := &Field{
+				Key: key,
+				Doc: docText(af.Doc),
+			}
+
+			// Same default as yaml.v3.
+			if f.Key == "" {
+				f.Key = strings.ToLower(name.Name)
+			}
+
+			if err := g.describe(dir, af.Type, f, depth); err != nil {
+				return nil, err
+			}
+
+			out = append(out, f)
+		}
+	}
+
+	return out, nil
+} // }}}
+
+// func Generator.lookup {{{
+
+// Finds the named type, either local to dir or within another package of ours.
+//
+// Returns nil if the type is not ours, such as time.Duration.
+func (g *Generator) lookup(dir string, expr ast.Expr) (string, *ast.TypeSpec, error) {
+	pt, err := g.load(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return dir, pt.types[t.Name], nil
+	case *ast.SelectorExpr:
+		pkg, ok := t.X.(*ast.Ident)
+		if !ok {
+			return "", nil, nil
+		}
+
+		path, ok := pt.imports[pkg.Name]
+		if !ok || !strings.HasPrefix(path, g.modPath+"/") {
+			return "", nil, nil
+		}
+
+		odir := strings.TrimPrefix(path, g.modPath+"/")
+
+		opt, err := g.load(odir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return odir, opt.types[t.Sel.Name], nil
+	}
+
+	return "", nil, nil
+} // }}}
+
+// func Generator.resolveStruct {{{
+
+// Returns the struct the expression refers to, nil if it is not a struct of ours.
+func (g *Generator) resolveStruct(dir string, expr ast.Expr) (string, *ast.StructType, error) {
+	if se, ok := expr.(*ast.StarExpr); ok {
+		expr = se.X
+	}
+
+	if st, ok := expr.(*ast.StructType); ok {
+		return dir, st, nil
+	}
+
+	tdir, ts, err := g.lookup(dir, expr)
+	if err != nil || ts == nil {
+		return "", nil, err
+	}
+
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return "", nil, nil
+	}
+
+	return tdir, st, nil
+} // }}}
+
+// func Generator.describe {{{
+
+// Fills in the Type (and Fields for structs) of the field from its Go type.
+func (g *Generator) describe(dir string, expr ast.Expr, f *Field, depth int) error {
+	var err error
+
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.describe(dir, t.X, f, depth)
+	case *ast.ArrayType:
+		if f.Type, err = g.elemType(dir, t.Elt, f, depth); err != nil {
+			return err
+		}
+
+		f.Type = "list of " + f.Type
+		if f.Fields != nil {
+			f.kind = kindList
+		}
+
+		return nil
+	case *ast.MapType:
+		key, err := g.typeName(dir, t.Key)
+		if err != nil {
+			return err
+		}
+
+		if f.Type, err = g.elemType(dir, t.Value, f, depth); err != nil {
+			return err
+		}
+
+		f.Type = "map of " + key + " to " + f.Type
+		if f.Fields != nil {
+			f.kind = kindMap
+		}
+
+		return nil
+	}
+
+	f.Type, err = g.elemType(dir, expr, f, depth)
+
+	return err
+} // }}}
+
+// func Generator.elemType {{{
+
+// Describes a type, adding any struct fields to f.
+func (g *Generator) elemType(dir string, expr ast.Expr, f *Field, depth int) (string, error) {
+	if se, ok := expr.(*ast.StarExpr); ok {
+		expr = se.X
+	}
+
+	// A struct of ours?
+	sdir, st, err := g.resolveStruct(dir, expr)
+	if err != nil {
+		return "", err
+	}
+
+	if st != nil {
+		if f.Fields, err = g.structFields(sdir, st, depth+1); err != nil {
+			return "", err
+		}
+
+		return "object", nil
+	}
+
+	// Some other named type of ours, such as tags.ConfTagWeights, describe what it is underneath.
+	tdir, ts, err := g.lookup(dir, expr)
+	if err != nil {
+		return "", err
+	}
+
+	if ts != nil {
+		if _, ok := ts.Type.(*ast.Ident); !ok {
+			sub := &Field{}
+			if err := g.describe(tdir, ts.Type, sub, depth+1); err != nil {
+				return "", err
+			}
+
+			f.Fields = sub.Fields
+			f.kind = sub.kind
+
+			return sub.Type, nil
+		}
+	}
+
+	return g.typeName(dir, expr)
+} // }}}
+
+// func Generator.typeName {{{
+
+// The YAML friendly name of a basic type.
+func (g *Generator) typeName(dir string, expr ast.Expr) (string, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "string", nil
+		case "bool":
+			return "bool", nil
+		case "float32", "float64":
+			return "number", nil
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+			return "int", nil
+		}
+
+		// A named type of ours, such as an int.
+		_, ts, err := g.lookup(dir, t)
+		if err != nil {
+			return "", err
+		}
+
+		if ts != nil {
+			return g.typeName(dir, ts.Type)
+		}
+
+		return t.Name, nil
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Duration" {
+			return "duration", nil
+		}
+
+		tdir, ts, err := g.lookup(dir, t)
+		if err != nil {
+			return "", err
+		}
+
+		if ts != nil {
+			return g.typeName(tdir, ts.Type)
+		}
+
+		return t.Sel.Name, nil
+	case *ast.StarExpr:
+		return g.typeName(dir, t.X)
+	}
+
+	return "value", nil
+} // }}}
+
+// func Generator.Markdown {{{
+
+// Writes the reference for every module as markdown.
+func (g *Generator) Markdown(w io.Writer) error {
+	fmt.Fprintf(w, "# Configuration Reference\n\n")
+	fmt.Fprintf(w, "Generated by `frame config-docs` from the configuration structs, do not edit by hand.\n\n")
+	fmt.Fprintf(w, "Durations are anything Go's time.ParseDuration() accepts, such as \"90s\" or \"5m\".\n")
+
+	for _, m := range Modules {
+		fields, err := g.Fields(m)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "\n## %s\n\n%s\n\n", m.Name, m.Desc)
+
+		writeMarkdown(w, fields, "")
+	}
+
+	return nil
+} // }}}
+
+// func writeMarkdown {{{
+
+func writeMarkdown(w io.Writer, fields []*Field, indent string) {
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s- `%s` (%s)\n", indent, f.Key, f.Type)
+
+		if f.Doc != "" {
+			fmt.Fprintf(w, "\n")
+
+			for _, line := range strings.Split(f.Doc, "\n") {
+				if line == "" {
+					fmt.Fprintf(w, "\n")
+					continue
+				}
+
+				fmt.Fprintf(w, "%s  %s\n", indent, line)
+			}
+
+			fmt.Fprintf(w, "\n")
+		}
+
+		if len(f.Fields) > 0 {
+			writeMarkdown(w, f.Fields, indent+"  ")
+		}
+	}
+} // }}}
+
+// func Generator.YAML {{{
+
+// Writes a fully commented example configuration for every module.
+//
+// Each module is a separate YAML document, as each module has its own configuration path.
+func (g *Generator) YAML(w io.Writer) error {
+	for i, m := range Modules {
+		fields, err := g.Fields(m)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			fmt.Fprintf(w, "---\n")
+		}
+
+		fmt.Fprintf(w, "# %s - %s\n\n", m.Name, m.Desc)
+
+		writeYAML(w, fields, "")
+	}
+
+	return nil
+} // }}}
+
+// func writeYAML {{{
+
+func writeYAML(w io.Writer, fields []*Field, indent string) {
+	for i, f := range fields {
+		// Blank line between documented keys, they are hard to read otherwise.
+		if i > 0 && f.Doc != "" {
+			fmt.Fprintf(w, "\n")
+		}
+
+		for _, line := range strings.Split(f.Doc, "\n") {
+			if line == "" {
+				if f.Doc != "" {
+					fmt.Fprintf(w, "%s#\n", indent)
+				}
+
+				continue
+			}
+
+			fmt.Fprintf(w, "%s# %s\n", indent, line)
+		}
+
+		if len(f.Fields) == 0 {
+			fmt.Fprintf(w, "%s%s: %s\n", indent, f.Key, example(f.Type))
+			continue
+		}
+
+		fmt.Fprintf(w, "%s%s:\n", indent, f.Key)
+
+		switch f.kind {
+		case kindList:
+			// The first key starts the list entry, the rest line up with it.
+			var b strings.Builder
+			writeYAML(&b, f.Fields, indent+"    ")
+
+			out := b.String()
+			out = strings.Replace(out, indent+"    "+f.Fields[0].Key, indent+"  - "+f.Fields[0].Key, 1)
+			io.WriteString(w, out)
+		case kindMap:
+			fmt.Fprintf(w, "%s  name:\n", indent)
+			writeYAML(w, f.Fields, indent+"    ")
+		default:
+			writeYAML(w, f.Fields, indent+"  ")
+		}
+	}
+} // }}}
+
+// func example {{{
+
+// An example (empty) value for the type.
+func example(typ string) string {
+	switch {
+	case typ == "string":
+		return `""`
+	case typ == "bool":
+		return "false"
+	case typ == "int", typ == "number":
+		return "0"
+	case typ == "duration":
+		return `"0s"`
+	case strings.HasPrefix(typ, "list of"):
+		return "[]"
+	case strings.HasPrefix(typ, "map of"):
+		return "{}"
+	}
+
+	return `""`
+} // }}}
+
+// func Generator.Keys {{{
+
+// Returns every key of the module flattened with dots, such as "queries.full", sorted.
+//
+// Mainly useful for checking nothing is missed.
+func (g *Generator) Keys(m Module) ([]string, error) {
+	var keys []string
+
+	fields, err := g.Fields(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var walk func(prefix string, fields []*Field)
+	walk = func(prefix string, fields []*Field) {
+		for _, f := range fields {
+			keys = append(keys, prefix+f.Key)
+			walk(prefix+f.Key+".", f.Fields)
+		}
+	}
+
+	walk("", fields)
+	sort.Strings(keys)
+
+	return keys, nil
+} // }}}
@@ -0,0 +1,223 @@
+package confdoc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testMod = "module frame\n\ngo 1.15\n"
+
+const testShared = `package shared
+
+type Rule struct {
+	Tag string ` + "`yaml:\"tag\"`" + `
+}
+
+type Weights map[string]int
+`
+
+const testPkg = `package mod
+
+import (
+	"frame/shared"
+	"time"
+)
+
+type Common struct {
+	// Inlined.
+	Database string ` + "`yaml:\"database\"`" + `
+}
+
+type query struct {
+	Full string ` + "`yaml:\"full\"`" + `
+}
+
+type confYAML struct {
+	Common ` + "`yaml:\",inline\"`" + `
+
+	// How often.
+	//
+	// Default of 1 minute.
+	Interval time.Duration ` + "`yaml:\"interval\"`" + `
+
+	Queries query ` + "`yaml:\"queries\"`" + `
+	Rules   []shared.Rule ` + "`yaml:\"rules\"`" + `
+	Weights shared.Weights ` + "`yaml:\"weights\"`" + `
+	Named   map[string]*query ` + "`yaml:\"named\"`" + `
+	Skip    string ` + "`yaml:\"-\"`" + `
+	NoTag   bool
+	hidden  int
+}
+`
+
+// func testGenerator {{{
+
+// Writes the test packages to a temporary source tree, returning a Generator for it.
+func testGenerator(t *testing.T) *Generator {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"go.mod":           testMod,
+		"shared/shared.go": testShared,
+		"mod/mod.go":       testPkg,
+	}
+
+	for name, data := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+} // }}}
+
+// func TestFields {{{
+
+func TestFields(t *testing.T) {
+	g := testGenerator(t)
+
+	m := Module{Name: "mod", Dir: "mod", Type: "confYAML"}
+
+	keys, err := g.Keys(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"database", "interval", "named", "named.full", "notag", "queries", "queries.full", "rules", "rules.tag", "weights"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys = %v, want %v", keys, want)
+	}
+
+	fields, err := g.Fields(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make(map[string]string)
+	for _, f := range fields {
+		types[f.Key] = f.Type
+	}
+
+	wantTypes := map[string]string{
+		"database": "string",
+		"interval": "duration",
+		"queries":  "object",
+		"rules":    "list of object",
+		"weights":  "map of string to int",
+		"named":    "map of string to object",
+		"notag":    "bool",
+	}
+
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("types = %v, want %v", types, wantTypes)
+	}
+
+	if fields[1].Doc != "How often.\n\nDefault of 1 minute." {
+		t.Errorf("interval doc = %q", fields[1].Doc)
+	}
+
+	var b strings.Builder
+	writeYAML(&b, fields, "")
+
+	if !strings.Contains(b.String(), "rules:\n  - tag: \"\"\n") {
+		t.Errorf("YAML list not as expected:\n%s", b.String())
+	}
+} // }}}
+
+// func TestModules {{{
+
+// Every module we document must still exist as documented.
+func TestModules(t *testing.T) {
+	g, err := New("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range Modules {
+		fields, err := g.Fields(m)
+		if err != nil {
+			t.Errorf("%s: %s", m.Name, err)
+			continue
+		}
+
+		if len(fields) == 0 {
+			t.Errorf("%s: no fields", m.Name)
+		}
+	}
+} // }}}
+
+// func TestMigrate {{{
+
+func TestMigrate(t *testing.T) {
+	g := testGenerator(t)
+
+	in := `database: db
+Interval: 300
+queries:
+  FULL: select
+rules:
+  - tag: a
+  - Tag: b
+bogus: true
+named:
+  one:
+    full: x
+    gone: y
+`
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(in), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := g.Migrate(Module{Name: "mod", Dir: "mod", Type: "confYAML"}, "test.yaml", &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ch := range changes {
+		got = append(got, fmt.Sprintf("%d %s %v", ch.Line, ch.Path, ch.Fixed))
+	}
+
+	want := []string{
+		"2 Interval true",
+		"2 interval true",
+		"4 queries.FULL true",
+		"7 rules[1].Tag true",
+		"8 bogus false",
+		"12 named.one.gone false",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Migrate changes = %v, want %v", got, want)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"interval: 5m0s", "  full: select", "  - tag: b"} {
+		if !strings.Contains(string(out), line+"\n") {
+			t.Errorf("Migrate output missing %q:\n%s", line, out)
+		}
+	}
+} // }}}
@@ -0,0 +1,244 @@
+package confdoc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// type Change struct {{{
+
+// Something Migrate changed within a YAML file, or a problem it found that it could not fix.
+type Change struct {
+	File string
+	Line int
+
+	// The key flattened with dots, such as "queries.full", with list items as "rules[2]".
+	Path string
+
+	Msg string
+
+	// False for problems left for the user, such as unknown keys.
+	Fixed bool
+} // }}}
+
+// func Change.String {{{
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", c.File, c.Line, c.Path, c.Msg)
+} // }}}
+
+// Keys of each module that no longer exist, and what to do about them instead.
+//
+// The keys are flattened with dots, with any map key as "*" and list items as "[]".
+var retired = map[string]map[string]string{
+	"imageproc": {
+		"maxresolution": "moved to the cachemanager configuration",
+		"imagecache":    "moved to the cachemanager configuration",
+		"bases.*.tags":  "removed, give the tags in the tagfile of the base instead",
+	},
+}
+
+// type migration struct {{{
+
+// Used by Migrate while it walks a single file.
+type migration struct {
+	file    string
+	retired map[string]string
+	changes []Change
+} // }}}
+
+// func Generator.Migrate {{{
+
+// Rewrites a YAML document of the module (as parsed by yaml.v3) to the current configuration, returning each change
+// made along with any problems left for the user.
+//
+// Keys that only differ from a current key by case, dashes or underscores (such as "pollInterval" or
+// "files_select" for "files-select") are renamed, durations given as a plain number are taken as seconds, and
+// any other key we do not know is reported.
+//
+// Only doc is changed, writing it back out is up to the caller.
+func (g *Generator) Migrate(m Module, file string, doc *yaml.Node) ([]Change, error) {
+	fields, err := g.Fields(m)
+	if err != nil {
+		return nil, err
+	}
+
+	mi := &migration{
+		file:    file,
+		retired: retired[m.Name],
+	}
+
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+
+		doc = doc.Content[0]
+	}
+
+	mi.mapping("", "", doc, fields)
+
+	return mi.changes, nil
+} // }}}
+
+// func migration.add {{{
+
+func (mi *migration) add(n *yaml.Node, path, msg string, fixed bool) {
+	mi.changes = append(mi.changes, Change{
+		File:  mi.file,
+		Line:  n.Line,
+		Path:  path,
+		Msg:   msg,
+		Fixed: fixed,
+	})
+} // }}}
+
+// func migration.mapping {{{
+
+// Checks every key of a mapping against the fields of a struct.
+//
+// prefix is the path so far, pattern is the same but as used by retired.
+func (mi *migration) mapping(prefix, pattern string, n *yaml.Node, fields []*Field) {
+	// Something else entirely, yaml.v3 will complain about it when loaded.
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		kn, vn := n.Content[i], n.Content[i+1]
+
+		f := findField(fields, kn.Value)
+		if f == nil {
+			if msg, ok := mi.retired[pattern+kn.Value]; ok {
+				mi.add(kn, prefix+kn.Value, msg, false)
+				continue
+			}
+
+			// Only rename if the proper key is not also there, otherwise which is wanted is anyones guess.
+			if f = similarField(fields, kn.Value); f == nil || hasKey(n, f.Key) {
+				mi.add(kn, prefix+kn.Value, "unknown key", false)
+				continue
+			}
+
+			mi.add(kn, prefix+kn.Value, "renamed to "+f.Key, true)
+			kn.Value = f.Key
+		}
+
+		mi.value(prefix+f.Key, pattern+f.Key, vn, f)
+	}
+} // }}}
+
+// func migration.value {{{
+
+func (mi *migration) value(path, pattern string, n *yaml.Node, f *Field) {
+	switch {
+	case f.Type == "duration":
+		mi.duration(path, n)
+	case f.Fields == nil:
+		// Nothing within to check.
+	case f.kind == kindList:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for i, item := range n.Content {
+			mi.mapping(fmt.Sprintf("%s[%d].", path, i), pattern+"[].", item, f.Fields)
+		}
+	case f.kind == kindMap:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			mi.mapping(path+"."+n.Content[i].Value+".", pattern+".*.", n.Content[i+1], f.Fields)
+		}
+	default:
+		mi.mapping(path+".", pattern+".", n, f.Fields)
+	}
+} // }}}
+
+// func migration.duration {{{
+
+// yaml.v3 only accepts durations as a string such as "5m", so a plain number is taken as seconds.
+func (mi *migration) duration(path string, n *yaml.Node) {
+	if n.Kind != yaml.ScalarNode {
+		return
+	}
+
+	switch n.ShortTag() {
+	case "!!int":
+		secs, err := strconv.ParseInt(n.Value, 0, 64)
+		if err != nil {
+			mi.add(n, path, "invalid duration", false)
+			return
+		}
+
+		d := (time.Duration(secs) * time.Second).String()
+
+		mi.add(n, path, fmt.Sprintf("%s is not a duration, assumed seconds and changed to %s", n.Value, d), true)
+
+		n.Value = d
+		n.Tag = "!!str"
+	case "!!str":
+		if _, err := time.ParseDuration(n.Value); err != nil {
+			mi.add(n, path, fmt.Sprintf("invalid duration %q, such as \"90s\" or \"5m\" is needed", n.Value), false)
+		}
+	}
+} // }}}
+
+// func findField {{{
+
+func findField(fields []*Field, key string) *Field {
+	for _, f := range fields {
+		if f.Key == key {
+			return f
+		}
+	}
+
+	return nil
+} // }}}
+
+// func similarField {{{
+
+// Returns the only field whose key is the same ignoring case, dashes and underscores, or nil.
+func similarField(fields []*Field, key string) *Field {
+	var found *Field
+
+	nkey := normalKey(key)
+
+	for _, f := range fields {
+		if normalKey(f.Key) != nkey {
+			continue
+		}
+
+		if found != nil {
+			return nil
+		}
+
+		found = f
+	}
+
+	return found
+} // }}}
+
+// func normalKey {{{
+
+func normalKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(key))
+} // }}}
+
+// func hasKey {{{
+
+func hasKey(n *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return true
+		}
+	}
+
+	return false
+} // }}}
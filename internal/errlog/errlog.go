@@ -0,0 +1,192 @@
+// Rate limited logging of repeated errors.
+//
+// When something goes wrong with a lot of files at once, such as a network mount going bad partway through a scan,
+// the same error happens for every single file. Logging each one made for gigabytes of the same line over and over.
+//
+// So identical errors are grouped. The first of a group is logged as normal, after that they are only counted until
+// the end of the interval, when a single line with how many times it repeated is logged.
+//
+// How many of each have happened since we started is also kept, by msg and the class of the error (see errClass()),
+// and included in the status of frame, see Totals().
+package errlog
+
+import (
+	"context"
+	"errors"
+	"frame/internal/scheduler"
+	"frame/types"
+	"io/fs"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Every error seen by any Aggregator, keyed by the Aggregators name, msg and errClass().
+//
+// Unlike the groups the error text is not part of the key, so this can not grow without bound.
+var (
+	totalsMut sync.Mutex
+	totals    = make(map[string]int64)
+)
+
+// type group struct {{{
+
+type group struct {
+	// The first error of the group this interval, exactly as it was.
+	sample string
+
+	// How many more of the group happened after the sample this interval.
+	repeats uint64
+} // }}}
+
+// type Aggregator struct {{{
+
+type Aggregator struct {
+	l    zerolog.Logger
+	name string
+
+	// Need mut to access groups.
+	mut    sync.Mutex
+	groups map[string]*group
+
+	sch *scheduler.Scheduler
+} // }}}
+
+// func New {{{
+
+// Creates a new Aggregator, logging the repeats every interval until ctx is done.
+//
+// The name is included in the Totals(), generally the module such as "imgproc".
+func New(name string, interval time.Duration, l *zerolog.Logger, ctx context.Context) (*Aggregator, error) {
+	a := &Aggregator{
+		l:      l.With().Str("sub", "errlog").Logger(),
+		name:   name,
+		groups: make(map[string]*group),
+		sch:    scheduler.New(ctx),
+	}
+
+	// Only logging, so no reason to stop while paused.
+	a.sch.IgnorePause()
+
+	if err := a.sch.Add("flush", interval, a.flush); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+} // }}}
+
+// func groupKey {{{
+
+// Returns what makes errors the same, for grouping them.
+//
+// Errors about files (fs.PathError) include the file name, the same problem with a different file is still the same
+// problem so those are grouped by the operation and the underlying error.
+func groupKey(msg string, err error) string {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return msg + ": " + pe.Op + ": " + pe.Err.Error()
+	}
+
+	return msg + ": " + err.Error()
+} // }}}
+
+// func errClass {{{
+
+// Returns a short description of the kind of error, from a fixed set so it can be used as a key.
+//
+// Such as "no such file or directory" for syscall errors, "timeout" or "other" for anything not known.
+func errClass(err error) string {
+	var errno syscall.Errno
+	var ne net.Error
+	var pe *fs.PathError
+
+	switch {
+	case errors.Is(err, types.ErrShutdown) || errors.Is(err, context.Canceled):
+		return "shutdown"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, types.ErrNotFound):
+		return "not found"
+	case errors.As(err, &errno):
+		return errno.Error()
+	case errors.Is(err, fs.ErrNotExist):
+		return "not exist"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.As(err, &ne):
+		if ne.Timeout() {
+			return "timeout"
+		}
+
+		return "network"
+	case errors.As(err, &pe):
+		return "path " + pe.Op
+	}
+
+	return "other"
+} // }}}
+
+// func Aggregator.Err {{{
+
+// Logs the error to l the same as l.Err(err).Msg(msg), unless it repeats one already logged this interval.
+//
+// Errors are the same if they have the same msg and error, see groupKey(). The msg should be fixed text (such as
+// "open"), as it is kept in the totals.
+func (a *Aggregator) Err(l *zerolog.Logger, msg string, err error) {
+	key := groupKey(msg, err)
+
+	totalsMut.Lock()
+	totals[a.name+": "+msg+": "+errClass(err)]++
+	totalsMut.Unlock()
+
+	a.mut.Lock()
+
+	if g, ok := a.groups[key]; ok {
+		g.repeats++
+		a.mut.Unlock()
+		return
+	}
+
+	a.groups[key] = &group{sample: err.Error()}
+	a.mut.Unlock()
+
+	l.Err(err).Msg(msg)
+} // }}}
+
+// func Aggregator.flush {{{
+
+// Logs how many times each group repeated this interval, starting a new interval.
+func (a *Aggregator) flush() {
+	a.mut.Lock()
+	groups := a.groups
+	a.groups = make(map[string]*group, len(groups))
+	a.mut.Unlock()
+
+	for key, g := range groups {
+		if g.repeats == 0 {
+			continue
+		}
+
+		a.l.Warn().Str("group", key).Str("sample", g.sample).Uint64("repeats", g.repeats).Msg("repeated errors")
+	}
+} // }}}
+
+// func Totals {{{
+
+// Returns how many times each msg and class of error (see errClass()) has happened since we started, for every
+// Aggregator.
+func Totals() map[string]int64 {
+	totalsMut.Lock()
+	defer totalsMut.Unlock()
+
+	out := make(map[string]int64, len(totals))
+
+	for key, n := range totals {
+		out[key] = n
+	}
+
+	return out
+} // }}}
@@ -0,0 +1,102 @@
+package errlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// func TestGroupKey {{{
+
+func TestGroupKey(t *testing.T) {
+	a := groupKey("open", &fs.PathError{Op: "open", Path: "a/1.jpg", Err: fs.ErrNotExist})
+	b := groupKey("open", &fs.PathError{Op: "open", Path: "b/2.jpg", Err: fs.ErrNotExist})
+
+	if a != b {
+		t.Errorf("different files not grouped, %q != %q", a, b)
+	}
+
+	if c := groupKey("open", &fs.PathError{Op: "open", Path: "a/1.jpg", Err: fs.ErrPermission}); c == a {
+		t.Errorf("different errors grouped, %q", c)
+	}
+
+	if c := groupKey("read", &fs.PathError{Op: "open", Path: "a/1.jpg", Err: fs.ErrNotExist}); c == a {
+		t.Errorf("different messages grouped, %q", c)
+	}
+
+	if c := groupKey("x", errors.New("boom")); c != "x: boom" {
+		t.Errorf("plain error key %q", c)
+	}
+} // }}}
+
+// func TestErrClass {{{
+
+func TestErrClass(t *testing.T) {
+	tests := []struct {
+		Err      error
+		Expected string
+	}{
+		{&fs.PathError{Op: "open", Path: "a", Err: syscall.ENOENT}, syscall.ENOENT.Error()},
+		{&fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}, "not exist"},
+		{&fs.PathError{Op: "read", Path: "a", Err: errors.New("a b c")}, "path read"},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "timeout"},
+		{errors.New("anything at all"), "other"},
+	}
+
+	for _, test := range tests {
+		if got := errClass(test.Err); got != test.Expected {
+			t.Errorf("errClass %v Expected %q != Got %q", test.Err, test.Expected, got)
+		}
+	}
+} // }}}
+
+// func TestAggregator {{{
+
+func TestAggregator(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := zerolog.New(&buf)
+
+	ctx, can := context.WithCancel(context.Background())
+	defer can()
+
+	// Long enough to never flush on its own, we call flush() ourselves.
+	a, err := New("test", time.Hour, &l, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		a.Err(&l, "open", &fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist})
+	}
+
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("logged %d lines before flush, want 1", n)
+	}
+
+	a.flush()
+
+	if !strings.Contains(buf.String(), `"repeats":4`) {
+		t.Errorf("no repeats logged: %s", buf.String())
+	}
+
+	// New interval, so the next one is logged again.
+	buf.Reset()
+	a.Err(&l, "open", &fs.PathError{Op: "open", Path: "b", Err: fs.ErrNotExist})
+
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("logged %d lines after flush, want 1", n)
+	}
+
+	if got := Totals()["test: open: not exist"]; got != 6 {
+		t.Errorf("total %d, want 6", got)
+	}
+} // }}}
@@ -0,0 +1,81 @@
+package faces
+
+import (
+	"math"
+)
+
+// Faces are clustered as they are found rather then all at once, each joining the most similar cluster (if similar
+// enough) or starting a new one. Not as good as clustering everything again each time, but it never needs more then
+// the centroids in memory and a cluster keeps its ID (and so its label) forever.
+
+// func normalize {{{
+
+// Scales v to a length of 1 in place, returning false if it has no length at all.
+func normalize(v []float32) bool {
+	var sum float64
+
+	for _, f := range v {
+		sum += float64(f) * float64(f)
+	}
+
+	if sum == 0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return false
+	}
+
+	l := math.Sqrt(sum)
+
+	for i := range v {
+		v[i] = float32(float64(v[i]) / l)
+	}
+
+	return true
+} // }}}
+
+// func similarity {{{
+
+// Returns the cosine similarity of two normalized vectors, -1 if they can not be compared.
+func similarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return -1
+	}
+
+	var dot float64
+
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+
+	return dot
+} // }}}
+
+// func nearest {{{
+
+// Returns the cluster most similar to the normalized embedding, nil if none are at least min.
+func nearest(clusters []*cluster, emb []float32, min float64) *cluster {
+	var best *cluster
+
+	bestSim := min
+
+	for _, cl := range clusters {
+		if sim := similarity(cl.Centroid, emb); sim >= bestSim {
+			best, bestSim = cl, sim
+		}
+	}
+
+	return best
+} // }}}
+
+// func cluster.add {{{
+
+// Adds the normalized embedding to the cluster, moving its centroid.
+func (cl *cluster) add(emb []float32) {
+	n := float32(cl.Faces)
+
+	for i := range cl.Centroid {
+		cl.Centroid[i] = (cl.Centroid[i]*n + emb[i]) / (n + 1)
+	}
+
+	normalize(cl.Centroid)
+
+	cl.Faces++
+} // }}}
@@ -0,0 +1,65 @@
+package faces
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	v := []float32{3, 4}
+
+	if !normalize(v) {
+		t.Fatal("normalize failed")
+	}
+
+	if math.Abs(float64(v[0])-0.6) > 1e-6 || math.Abs(float64(v[1])-0.8) > 1e-6 {
+		t.Errorf("normalize = %v, want [0.6 0.8]", v)
+	}
+
+	if normalize([]float32{0, 0}) {
+		t.Error("normalized a zero vector")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	a := &cluster{ID: 1, Centroid: []float32{1, 0}, Faces: 1}
+	b := &cluster{ID: 2, Centroid: []float32{0, 1}, Faces: 1}
+	clusters := []*cluster{a, b}
+
+	emb := []float32{0.9, 0.1}
+	normalize(emb)
+
+	if got := nearest(clusters, emb, 0.6); got != a {
+		t.Errorf("nearest = %v, want cluster 1", got)
+	}
+
+	// Halfway between is not similar enough to either.
+	emb = []float32{1, 1}
+	normalize(emb)
+
+	if got := nearest(clusters, emb, 0.8); got != nil {
+		t.Errorf("nearest = %v, want nil", got)
+	}
+
+	// From a different model entirely.
+	if got := nearest(clusters, []float32{1, 0, 0}, 0); got != nil {
+		t.Errorf("nearest of a different length = %v, want nil", got)
+	}
+}
+
+func TestClusterAdd(t *testing.T) {
+	cl := &cluster{Centroid: []float32{1, 0}, Faces: 1}
+
+	cl.add([]float32{0, 1})
+
+	if cl.Faces != 2 {
+		t.Errorf("faces = %d, want 2", cl.Faces)
+	}
+
+	// Now halfway between, and still normalized.
+	want := float32(math.Sqrt(0.5))
+
+	if math.Abs(float64(cl.Centroid[0]-want)) > 1e-6 || math.Abs(float64(cl.Centroid[1]-want)) > 1e-6 {
+		t.Errorf("centroid = %v, want [%f %f]", cl.Centroid, want, want)
+	}
+}
@@ -0,0 +1,864 @@
+// Finds the faces in each image and groups them into clusters, each (hopefully) a single person.
+//
+// Every Interval a batch of images not yet checked is loaded from the CacheManager and handed to the face detector
+// (see confYAML.Command), each face found joining the most similar cluster or starting a new one. Once a cluster is
+// given a label (see "frame faces") every hash with a face in it is tagged, such as "person:alice", saved in the
+// files.autotags table the same as the classify module. CMerge then combines them with the tags of the files (see its
+// auto queries), so people can be used in profiles like any other tag.
+//
+// Experimental, nothing is checked unless the faces feature is enabled (see the features package).
+package faces
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frame/internal/features"
+	"frame/internal/httpclient"
+	"frame/internal/modelrun"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/internal/scheduler"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inB.Database != "" {
+		inA.Database = inB.Database
+	}
+
+	if inB.Queries.Pending != "" {
+		inA.Queries.Pending = inB.Queries.Pending
+	}
+
+	if inB.Queries.Clusters != "" {
+		inA.Queries.Clusters = inB.Queries.Clusters
+	}
+
+	if inB.Queries.AddCluster != "" {
+		inA.Queries.AddCluster = inB.Queries.AddCluster
+	}
+
+	if inB.Queries.UpdateCluster != "" {
+		inA.Queries.UpdateCluster = inB.Queries.UpdateCluster
+	}
+
+	if inB.Queries.AddFace != "" {
+		inA.Queries.AddFace = inB.Queries.AddFace
+	}
+
+	if inB.Queries.People != "" {
+		inA.Queries.People = inB.Queries.People
+	}
+
+	if inB.Queries.Save != "" {
+		inA.Queries.Save = inB.Queries.Save
+	}
+
+	if len(inB.Command) > 0 {
+		inA.Command = inB.Command
+	}
+
+	if inB.URL != "" {
+		inA.URL = inB.URL
+	}
+
+	if inB.HTTP != (httpclient.Config{}) {
+		inA.HTTP = inB.HTTP
+	}
+
+	// The rest always have a value once converted, so only one not the default replaces it.
+	if inB.Similarity != defSimilarity {
+		inA.Similarity = inB.Similarity
+	}
+
+	if inB.BatchSize != defBatchSize {
+		inA.BatchSize = inB.BatchSize
+	}
+
+	if inB.Size != defSize {
+		inA.Size = inB.Size
+	}
+
+	if inB.Namespace != defNamespace {
+		inA.Namespace = inB.Namespace
+	}
+
+	if inB.Source != defSource {
+		inA.Source = inB.Source
+	}
+
+	if inB.Interval != defInterval {
+		inA.Interval = inB.Interval
+	}
+
+	if inB.Timeout != defTimeout {
+		inA.Timeout = inB.Timeout
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Database != newConf.Database || origConf.Queries != newConf.Queries {
+		return true
+	}
+
+	if strings.Join(origConf.Command, "\x00") != strings.Join(newConf.Command, "\x00") {
+		return true
+	}
+
+	if origConf.URL != newConf.URL || origConf.HTTP != newConf.HTTP {
+		return true
+	}
+
+	if origConf.Similarity != newConf.Similarity || origConf.BatchSize != newConf.BatchSize || origConf.Size != newConf.Size {
+		return true
+	}
+
+	if origConf.Namespace != newConf.Namespace || origConf.Source != newConf.Source {
+		return true
+	}
+
+	if origConf.Interval != newConf.Interval || origConf.Timeout != newConf.Timeout {
+		return true
+	}
+
+	return false
+} // }}}
+
+// Defaults for everything in confYAML not set.
+const (
+	defSimilarity = 0.6
+	defBatchSize  = 8
+	defSize       = 640
+	defNamespace  = "person"
+	defSource     = "faces"
+	defInterval   = time.Minute
+	defTimeout    = 5 * time.Minute
+)
+
+// func yconfConvert {{{
+
+func yconfConvert(inInt interface{}) (interface{}, error) {
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		Database:   in.Database,
+		Queries:    in.Queries,
+		Similarity: in.Similarity,
+		BatchSize:  in.BatchSize,
+		Size:       in.Size,
+		Namespace:  strings.TrimSpace(in.Namespace),
+		Source:     strings.TrimSpace(in.Source),
+		Interval:   time.Duration(in.Interval),
+		Timeout:    time.Duration(in.Timeout),
+	}
+
+	for _, arg := range in.Command {
+		out.Command = append(out.Command, strings.ReplaceAll(arg, "{model}", in.Model))
+	}
+
+	out.URL = strings.ReplaceAll(strings.TrimSpace(in.URL), "{model}", in.Model)
+	out.HTTP = in.HTTP
+
+	if out.Similarity == 0 {
+		out.Similarity = defSimilarity
+	}
+
+	if out.Similarity < 0 || out.Similarity > 1 {
+		return nil, errors.New("similarity must be between 0 and 1")
+	}
+
+	if out.BatchSize == 0 {
+		out.BatchSize = defBatchSize
+	}
+
+	if out.BatchSize < 1 || out.BatchSize > 1000 {
+		return nil, errors.New("batchsize must be between 1 and 1000")
+	}
+
+	if out.Size == 0 {
+		out.Size = defSize
+	}
+
+	if out.Size < 64 || out.Size > 4096 {
+		return nil, errors.New("size must be between 64 and 4096")
+	}
+
+	if out.Namespace == "" {
+		out.Namespace = defNamespace
+	}
+
+	if out.Source == "" {
+		out.Source = defSource
+	}
+
+	if out.Interval == 0 {
+		out.Interval = defInterval
+	}
+
+	if out.Interval < time.Second {
+		return nil, errors.New("interval too short")
+	}
+
+	if out.Timeout == 0 {
+		out.Timeout = defTimeout
+	}
+
+	return out, nil
+} // }}}
+
+// func New {{{
+
+// Loads the configuration at confPath and starts looking for faces every Interval.
+//
+// The CacheManager provides the images, the TagManager the IDs of the tags given.
+func New(confPath string, cm types.CacheManager, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (*Faces, error) {
+	var err error
+
+	fa := &Faces{
+		l:     l.With().Str("mod", "faces").Logger(),
+		cPath: confPath,
+		cm:    cm,
+		tm:    tm,
+		ctx:   ctx,
+	}
+
+	fl := fa.l.With().Str("func", "New").Logger()
+
+	features.Log(&fl, "faces")
+
+	// Still loaded, so it can be enabled without a restart.
+	if !features.Enabled(features.Faces) {
+		fl.Warn().Msg("faces feature not enabled, no images are checked until it is")
+	}
+
+	fa.db = pgdb.New(&fa.l, ctx)
+
+	if err = fa.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// Start background processing to watch configuration for changes.
+	fa.yc.Start()
+
+	fa.sch = scheduler.New(fa.ctx)
+
+	if err = fa.sch.Add("faces", fa.getConf().Interval, fa.tickFaces); err != nil {
+		fa.close()
+		return nil, err
+	}
+
+	// Close down once we are done.
+	go func() {
+		<-fa.ctx.Done()
+		fa.close()
+	}()
+
+	fl.Debug().Send()
+
+	return fa, nil
+} // }}}
+
+// func Faces.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (fa *Faces) loadConf() error {
+	var err error
+
+	fl := fa.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		fa.notifyConf()
+	}
+
+	if fa.yc, err = yconf.New(fa.cPath, ycc, &fa.l, fa.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = fa.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(fa.yc.Get())).Send()
+
+	co, ok := fa.yc.Get().(*conf)
+	if !ok {
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = checkConf(co); err != nil {
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = fa.newClient(co, nil); err != nil {
+		fl.Err(err).Msg("newClient")
+		return err
+	}
+
+	if err = fa.dbConnect(co); err != nil {
+		fl.Err(err).Msg("dbConnect")
+		return err
+	}
+
+	if err = fa.loadClusters(); err != nil {
+		fl.Err(err).Msg("loadClusters")
+		return err
+	}
+
+	fa.co.Store(co)
+
+	return nil
+} // }}}
+
+// func Faces.notifyConf {{{
+
+func (fa *Faces) notifyConf() {
+	fl := fa.l.With().Str("func", "notifyConf").Logger()
+
+	co, ok := fa.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	if err := checkConf(co); err != nil {
+		fl.Warn().Err(err).Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	oldco := fa.getConf()
+
+	if err := fa.newClient(co, oldco); err != nil {
+		fl.Warn().Err(err).Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	// The queries are prepared at connection, so either changing needs a new one.
+	if co.Database != oldco.Database || co.Queries != oldco.Queries {
+		if err := fa.dbConnect(co); err != nil {
+			fl.Err(err).Msg("dbConnect")
+			return
+		}
+
+		// Could well be a different database.
+		if err := fa.loadClusters(); err != nil {
+			fl.Err(err).Msg("loadClusters")
+		}
+	}
+
+	// A different namespace means every tag changes.
+	if co.Namespace != oldco.Namespace {
+		fa.cMut.Lock()
+		fa.since = time.Time{}
+		fa.cMut.Unlock()
+	}
+
+	fa.co.Store(co)
+
+	if err := fa.sch.Reschedule("faces", co.Interval); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func checkConf {{{
+
+func checkConf(co *conf) error {
+	qu := co.Queries
+
+	switch {
+	case co.Database == "":
+		return errors.New("missing database")
+	case qu.Pending == "":
+		return errors.New("missing queries.pending")
+	case qu.Clusters == "":
+		return errors.New("missing queries.clusters")
+	case qu.AddCluster == "":
+		return errors.New("missing queries.addcluster")
+	case qu.UpdateCluster == "":
+		return errors.New("missing queries.updatecluster")
+	case qu.AddFace == "":
+		return errors.New("missing queries.addface")
+	case qu.People == "":
+		return errors.New("missing queries.people")
+	case qu.Save == "":
+		return errors.New("missing queries.save")
+	case len(co.Command) == 0 && co.URL == "":
+		return errors.New("missing command or url")
+	case len(co.Command) > 0 && co.URL != "":
+		return errors.New("only one of command and url can be set")
+	}
+
+	return nil
+} // }}}
+
+// func Faces.newClient {{{
+
+// Sets the client of co when it uses a URL, see confYAML.URL.
+//
+// The client of old is kept if the HTTP settings are the same, so the rate limit carries on across changes.
+func (fa *Faces) newClient(co, old *conf) error {
+	if co.URL == "" {
+		return nil
+	}
+
+	if old != nil && old.client != nil && old.HTTP == co.HTTP {
+		co.client = old.client
+		return nil
+	}
+
+	c, err := httpclient.New("faces", co.HTTP, &fa.l)
+	if err != nil {
+		return err
+	}
+
+	co.client = c
+
+	return nil
+} // }}}
+
+// func runModel {{{
+
+// Hands the images to the model, either running the Command or sending them to the URL.
+func runModel(ctx context.Context, co *conf, imgs map[uint64]image.Image, fn func(id uint64, line []byte) error) error {
+	if co.URL != "" {
+		return modelrun.Post(ctx, co.client, co.URL, imgs, fn)
+	}
+
+	return modelrun.Run(ctx, co.Command, imgs, fn)
+} // }}}
+
+// func Faces.dbConnect {{{
+
+func (fa *Faces) dbConnect(co *conf) error {
+	qu := co.Queries
+
+	return fa.db.Connect(&pgdb.Config{
+		Database: co.Database,
+		Statements: []pgdb.Statement{
+			{Name: "pending", Query: qu.Pending},
+			{Name: "clusters", Query: qu.Clusters},
+			{Name: "addcluster", Query: qu.AddCluster},
+			{Name: "updatecluster", Query: qu.UpdateCluster},
+			{Name: "addface", Query: qu.AddFace},
+			{Name: "people", Query: qu.People},
+			{Name: "save", Query: qu.Save},
+		},
+	})
+} // }}}
+
+// func Faces.getConf {{{
+
+func (fa *Faces) getConf() *conf {
+	if co, ok := fa.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	fa.l.Warn().Str("func", "getConf").Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func Faces.loadClusters {{{
+
+// Replaces the clusters with those in the database, checking every label again.
+func (fa *Faces) loadClusters() error {
+	var clusters []*cluster
+
+	db, err := fa.db.Get()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(fa.ctx, "clusters")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		cl := &cluster{}
+
+		if err := rows.Scan(&cl.ID, &cl.Centroid, &cl.Faces); err != nil {
+			return err
+		}
+
+		clusters = append(clusters, cl)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fa.cMut.Lock()
+	fa.clusters = clusters
+	fa.since = time.Time{}
+	fa.cMut.Unlock()
+
+	return nil
+} // }}}
+
+// func Faces.tickFaces {{{
+
+// Run by the scheduler, checks a single batch for faces and then tags any changed.
+func (fa *Faces) tickFaces() {
+	fl := fa.l.With().Str("func", "tickFaces").Logger()
+
+	// Experimental, see features.Faces.
+	if !features.Enabled(features.Faces) {
+		return
+	}
+
+	co := fa.getConf()
+
+	done, err := fa.checkBatch(co)
+	if err != nil {
+		fl.Err(err).Msg("checkBatch")
+	} else if done > 0 {
+		fl.Info().Int("images", done).Msg("checked")
+	}
+
+	// Even if the batch failed, as labels can change regardless.
+	tagged, err := fa.tagPeople(co)
+	if err != nil {
+		fl.Err(err).Msg("tagPeople")
+		return
+	}
+
+	if tagged > 0 {
+		fl.Info().Int("images", tagged).Msg("tagged")
+	}
+} // }}}
+
+// func Faces.pending {{{
+
+// Returns the hash IDs of up to BatchSize images not yet checked.
+func (fa *Faces) pending(co *conf) ([]uint64, error) {
+	var ids []uint64
+
+	db, err := fa.db.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fa.ctx, "pending", co.Source, co.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+} // }}}
+
+// type detectorLine struct {{{
+
+// A single line of output from the detector, see confYAML.Command.
+type detectorLine struct {
+	File  string `json:"file"`
+	Faces []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"faces"`
+} // }}}
+
+// func Faces.checkBatch {{{
+
+// Checks the next batch of pending images for faces, returning how many were checked.
+//
+// Each is saved without tags, tagPeople() gives the tags of any labeled clusters.
+func (fa *Faces) checkBatch(co *conf) (int, error) {
+	var done, seen int
+
+	fl := fa.l.With().Str("func", "checkBatch").Logger()
+
+	if atomic.LoadUint32(&fa.closed) == 1 {
+		return 0, types.ErrShutdown
+	}
+
+	ids, err := fa.pending(co)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	imgs := make(map[uint64]image.Image, len(ids))
+
+	for _, id := range ids {
+		img, err := fa.cm.LoadImage(id, image.Point{co.Size, co.Size}, false)
+		if errors.Is(err, types.ErrNotFound) {
+			// Never going to be checked until cached, so saved as done rather then asked for every batch.
+			fl.Debug().Uint64("id", id).Msg("not cached")
+
+			if err := fa.save(id, co.Source, nil); err != nil {
+				return done, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			fl.Err(err).Uint64("id", id).Msg("LoadImage")
+			continue
+		}
+
+		imgs[id] = img
+	}
+
+	if len(imgs) == 0 {
+		return done, nil
+	}
+
+	ctx, can := context.WithTimeout(fa.ctx, co.Timeout)
+	defer can()
+
+	err = runModel(ctx, co, imgs, func(id uint64, line []byte) error {
+		var dl detectorLine
+
+		seen++
+
+		if err := json.Unmarshal(line, &dl); err != nil {
+			fl.Err(err).Uint64("id", id).Msg("Unmarshal")
+			return nil
+		}
+
+		for _, face := range dl.Faces {
+			if !normalize(face.Embedding) {
+				fl.Warn().Uint64("id", id).Msg("face without a usable embedding")
+				continue
+			}
+
+			if err := fa.addFace(co, id, face.Embedding); err != nil {
+				return err
+			}
+		}
+
+		if err := fa.save(id, co.Source, nil); err != nil {
+			return err
+		}
+
+		done++
+
+		return nil
+	})
+	if err != nil {
+		return done, err
+	}
+
+	if missing := len(imgs) - seen; missing > 0 {
+		fl.Warn().Int("missing", missing).Msg("detector left out images, they are tried again next time")
+	}
+
+	return done, nil
+} // }}}
+
+// func Faces.addFace {{{
+
+// Adds the face to the most similar cluster, or a new one if none are similar enough.
+func (fa *Faces) addFace(co *conf, hid uint64, emb []float32) error {
+	db, err := fa.db.Get()
+	if err != nil {
+		return err
+	}
+
+	fa.cMut.Lock()
+	defer fa.cMut.Unlock()
+
+	cl := nearest(fa.clusters, emb, co.Similarity)
+
+	if cl == nil {
+		cl = &cluster{Centroid: append([]float32(nil), emb...), Faces: 1}
+
+		if err = db.QueryRow(fa.ctx, "addcluster", cl.Centroid, cl.Faces).Scan(&cl.ID); err != nil {
+			return err
+		}
+
+		fa.clusters = append(fa.clusters, cl)
+	} else {
+		cl.add(emb)
+
+		if _, err = db.Exec(fa.ctx, "updatecluster", cl.ID, cl.Centroid, cl.Faces); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(fa.ctx, "addface", hid, cl.ID, emb)
+	return err
+} // }}}
+
+// func Faces.tagPeople {{{
+
+// Tags every hash with a face added or in a cluster labeled since last time, returning how many were saved.
+func (fa *Faces) tagPeople(co *conf) (int, error) {
+	var hids []uint64
+	var labels [][]string
+
+	fa.cMut.Lock()
+	since := fa.since
+	fa.cMut.Unlock()
+
+	// Allow for the clocks of the database and ourselves not quite agreeing, saving the same tags again is harmless.
+	start := time.Now().Add(-time.Minute)
+
+	db, err := fa.db.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query(fa.ctx, "people", since)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read everything first, saving while the rows are open would hold a second connection for the whole time.
+	for rows.Next() {
+		var hid uint64
+		var hl []string
+
+		if err := rows.Scan(&hid, &hl); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		hids = append(hids, hid)
+		labels = append(labels, hl)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, hid := range hids {
+		tgs, err := fa.toTags(co, labels[i])
+		if err != nil {
+			return i, err
+		}
+
+		if err := fa.save(hid, co.Source, tgs); err != nil {
+			return i, err
+		}
+	}
+
+	fa.cMut.Lock()
+	// Unless loadClusters() reset it meanwhile.
+	if fa.since.Equal(since) {
+		fa.since = start
+	}
+	fa.cMut.Unlock()
+
+	return len(hids), nil
+} // }}}
+
+// func Faces.toTags {{{
+
+// Converts every label into a tag within the Namespace, skipping unlabeled clusters.
+func (fa *Faces) toTags(co *conf, labels []string) (tags.Tags, error) {
+	var names []string
+
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label != "" {
+			names = append(names, co.Namespace+":"+label)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	return tags.StringsToTags(names, fa.tm)
+} // }}}
+
+// func Faces.save {{{
+
+func (fa *Faces) save(id uint64, source string, tgs tags.Tags) error {
+	db, err := fa.db.Get()
+	if err != nil {
+		return err
+	}
+
+	// Never NULL, an empty array marks it as done.
+	if tgs == nil {
+		tgs = tags.Tags{}
+	}
+
+	_, err = db.Exec(fa.ctx, "save", id, source, tgs)
+	return err
+} // }}}
+
+// func Faces.close {{{
+
+// Stops all background processing and disconnects from the database.
+func (fa *Faces) close() {
+	fl := fa.l.With().Str("func", "close").Logger()
+
+	if !atomic.CompareAndSwapUint32(&fa.closed, 0, 1) {
+		fl.Info().Msg("already closed")
+		return
+	}
+
+	fa.db.Close()
+
+	fl.Info().Msg("closed")
+} // }}}
@@ -0,0 +1,180 @@
+package faces
+
+import (
+	"context"
+	"frame/internal/httpclient"
+	"frame/internal/pgdb"
+	"frame/internal/scheduler"
+	"frame/types"
+	"frame/yconf"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type confQueries struct {
+	// Returns the hash ID of images not yet checked for faces by the source, given the source and how many are wanted.
+	Pending string `yaml:"pending"`
+
+	// Returns every cluster, the ID, centroid and how many faces are in it.
+	Clusters string `yaml:"clusters"`
+
+	// Adds a cluster given the centroid and how many faces, returning the new ID.
+	AddCluster string `yaml:"addcluster"`
+
+	// Updates a cluster given the ID, centroid and how many faces.
+	UpdateCluster string `yaml:"updatecluster"`
+
+	// Adds a face given the hash ID, cluster ID and embedding.
+	AddFace string `yaml:"addface"`
+
+	// Returns the hash ID and the labels of every cluster its faces are in, for every hash with a face added or in a
+	// cluster whose label changed since the time given.
+	People string `yaml:"people"`
+
+	// Saves the tags of a hash, given the hash ID, source and tags.
+	Save string `yaml:"save"`
+}
+
+// type confYAML struct {{{
+
+type confYAML struct {
+	Database string `yaml:"database" log:"redact"`
+
+	Queries confQueries `yaml:"queries"`
+
+	// The face detector and its arguments, run the same as the classify runner (see the modelrun package). This can
+	// be a local model, or a small script handing the images to an external service.
+	//
+	// Any "{model}" is replaced with Model. For each image the detector writes a single line of JSON to stdout, with
+	// an embedding for each face found, such as -
+	//
+	//	{"file": "/tmp/modelrun123/42.png", "faces": [{"embedding": [0.12, -0.03, ...]}]}
+	//
+	// Every embedding must be the same length, changing the model means clearing the clusters.
+	Command []string `yaml:"command"`
+
+	// Instead of Command, the images are sent to the model behind this web service, see modelrun.Post(). Any "{model}"
+	// is replaced with Model.
+	URL string `yaml:"url" log:"redact"`
+
+	// The rate limit, retries and proxy for URL.
+	HTTP httpclient.Config `yaml:"http"`
+
+	// The model file, such as an ArcFace style recognizer exported as ONNX.
+	Model string `yaml:"model"`
+
+	// How similar (cosine, 0 to 1) a face needs to be to the centroid of a cluster to join it. Default if not set is
+	// 0.6.
+	//
+	// Too low and different people end up together, too high and the same person is spread over many clusters.
+	// The latter is easier to live with, simply give each cluster the same label.
+	Similarity float64 `yaml:"similarity"`
+
+	// How many images the detector is given at once. Default if not set is 8.
+	BatchSize int `yaml:"batchsize"`
+
+	// The size each image is scaled down to fit within before the detector sees it. Default if not set is 640.
+	Size int `yaml:"size"`
+
+	// Each label is given as this, a colon and then the label, such as "person:alice". Default if not set is
+	// "person".
+	Namespace string `yaml:"namespace"`
+
+	// Who the tags are saved as, see the files.autotags table. Default if not set is "faces".
+	Source string `yaml:"source"`
+
+	// How often a batch is checked for faces and any newly labeled clusters tagged. Default if not set is 1 minute.
+	Interval yconf.Duration `yaml:"interval"`
+
+	// The longest the detector can take for a single batch. Default if not set is 5 minutes.
+	Timeout yconf.Duration `yaml:"timeout"`
+} // }}}
+
+// type conf struct {{{
+
+type conf struct {
+	Database string `log:"redact"`
+
+	Queries confQueries
+
+	// See confYAML.Command, with Model already put in place.
+	Command []string
+
+	// See confYAML.URL, with Model already put in place.
+	URL  string `log:"redact"`
+	HTTP httpclient.Config
+
+	// Sends to URL, set once the configuration is loaded, see newClient().
+	client *httpclient.Client
+
+	Similarity float64
+	BatchSize  int
+	Size       int
+	Namespace  string
+	Source     string
+	Interval   time.Duration
+	Timeout    time.Duration
+} // }}}
+
+// type cluster struct {{{
+
+// A group of faces that are (hopefully) all the same person.
+type cluster struct {
+	ID uint64
+
+	// The average of every face in the cluster, normalized.
+	Centroid []float32
+
+	Faces int
+} // }}}
+
+// type Faces struct {{{
+
+type Faces struct {
+	l zerolog.Logger
+
+	// We use an atomic for the configuration since we might replace it at any time while another goroutine
+	// can be using it.
+	co atomic.Value
+
+	// Our configuration path.
+	cPath string
+
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
+
+	cm types.CacheManager
+	tm types.TagManager
+
+	yc *yconf.YConf
+
+	// Runs each batch.
+	sch *scheduler.Scheduler
+
+	// Protects clusters and since.
+	cMut sync.Mutex
+
+	// Every cluster, loaded on connecting to the database. We are the only one changing them other then their labels,
+	// which only the database needs to know.
+	clusters []*cluster
+
+	// When the labels were last checked for changes, zero to check them all.
+	since time.Time
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
+
+// Notify is set in New()
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Changed: yconfChanged,
+	Convert: yconfConvert,
+}
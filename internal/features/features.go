@@ -0,0 +1,152 @@
+// Toggles for experimental subsystems, so they can be tried out from the configuration rather then needing a
+// rebuild.
+//
+// Set from the features: section of the main configuration, such as -
+//
+//	features:
+//	  faces: true
+//
+// Every feature belongs to a module, which checks Enabled() each time it would use it so the toggles can change while
+// running (see App.SetFeatures()). Anything not set is disabled, and unknown names are an error so a typo is not
+// silently ignored.
+//
+// A feature is only added to known along with the code checking it, so none can be turned on that does nothing.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Every feature that can be set.
+const (
+	// Face detection and person tags, see the faces module. Until enabled it still loads, but checks nothing.
+	Faces = "faces"
+)
+
+// Every feature that can be set, with the module it belongs to.
+var known = map[string]string{
+	Faces: "faces",
+}
+
+var (
+	// Protects enabled.
+	mut sync.RWMutex
+
+	// Only those set to true.
+	enabled map[string]bool
+)
+
+// func Check {{{
+
+// Checks every name is a known feature, names are not case sensitive.
+func Check(flags map[string]bool) error {
+	var unknown []string
+
+	for name := range flags {
+		if _, ok := known[strings.ToLower(name)]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+
+	if len(known) == 0 {
+		return fmt.Errorf("unknown features %s, none are available", strings.Join(unknown, ", "))
+	}
+
+	return fmt.Errorf("unknown features %s, known are %s", strings.Join(unknown, ", "), strings.Join(Known(), ", "))
+} // }}}
+
+// func Set {{{
+
+// Replaces the enabled features with those set to true in flags, nil disables them all.
+//
+// Nothing is changed if any name is unknown, see Check().
+func Set(flags map[string]bool) error {
+	if err := Check(flags); err != nil {
+		return err
+	}
+
+	on := make(map[string]bool, len(flags))
+
+	for name, ok := range flags {
+		if ok {
+			on[strings.ToLower(name)] = true
+		}
+	}
+
+	mut.Lock()
+	enabled = on
+	mut.Unlock()
+
+	return nil
+} // }}}
+
+// func Enabled {{{
+
+// Returns true if the feature is enabled, name is one of the constants above.
+func Enabled(name string) bool {
+	mut.RLock()
+	defer mut.RUnlock()
+
+	return enabled[name]
+} // }}}
+
+// func List {{{
+
+// Returns every enabled feature, sorted.
+func List() []string {
+	return For("")
+} // }}}
+
+// func For {{{
+
+// Returns the enabled features belonging to the module (such as "render"), sorted. Every module if mod is empty.
+func For(mod string) []string {
+	var out []string
+
+	mut.RLock()
+	for name := range enabled {
+		if mod == "" || known[name] == mod {
+			out = append(out, name)
+		}
+	}
+	mut.RUnlock()
+
+	sort.Strings(out)
+
+	return out
+} // }}}
+
+// func Known {{{
+
+// Returns every feature that can be set, sorted.
+func Known() []string {
+	out := make([]string, 0, len(known))
+
+	for name := range known {
+		out = append(out, name)
+	}
+
+	sort.Strings(out)
+
+	return out
+} // }}}
+
+// func Log {{{
+
+// Logs the enabled features of the module, if any. Called by each module as it starts.
+func Log(l *zerolog.Logger, mod string) {
+	if on := For(mod); len(on) > 0 {
+		l.Info().Strs("features", on).Msg("Experimental features enabled")
+	}
+} // }}}
@@ -0,0 +1,73 @@
+package features
+
+import (
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	// Our own, so the test does not change as real ones come and go.
+	saved := known
+	known = map[string]string{"alpha": "render", "beta": "imgproc", "gamma": "api"}
+
+	defer func() {
+		Set(nil)
+		known = saved
+	}()
+
+	if err := Set(map[string]bool{"Alpha": true, "beta": true, "gamma": false}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	if !Enabled("alpha") || !Enabled("beta") || Enabled("gamma") {
+		t.Fatalf("Enabled Got %v", List())
+	}
+
+	if got := List(); len(got) != 2 || got[0] != "alpha" || got[1] != "beta" {
+		t.Fatalf("List Expected [alpha beta] != Got %v", got)
+	}
+
+	if got := For("render"); len(got) != 1 || got[0] != "alpha" {
+		t.Fatalf("For Expected [alpha] != Got %v", got)
+	}
+
+	if got := For("api"); len(got) != 0 {
+		t.Fatalf("For Expected [] != Got %v", got)
+	}
+
+	// A typo changes nothing.
+	if err := Set(map[string]bool{"alhpa": true}); err == nil {
+		t.Fatalf("Set accepted an unknown feature")
+	}
+
+	if !Enabled("alpha") {
+		t.Fatalf("Set with an error changed the features")
+	}
+
+	if err := Set(nil); err != nil {
+		t.Fatalf("Set nil: %s", err)
+	}
+
+	if got := List(); len(got) != 0 {
+		t.Fatalf("Set nil Expected [] != Got %v", got)
+	}
+}
+
+func TestKnown(t *testing.T) {
+	if err := Set(map[string]bool{"Faces": true}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	defer Set(nil)
+
+	if !Enabled(Faces) {
+		t.Fatalf("Enabled Expected %s != Got %v", Faces, List())
+	}
+
+	if got := For("faces"); len(got) != 1 || got[0] != Faces {
+		t.Fatalf("For Expected [%s] != Got %v", Faces, got)
+	}
+
+	if err := Set(map[string]bool{"watcher": true}); err == nil {
+		t.Fatalf("Set accepted an unknown feature")
+	}
+}
@@ -0,0 +1,144 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"frame/yconf"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		// The body has to be there every attempt, not only the first.
+		if string(body) != "hello" {
+			t.Errorf("attempt %d body %q", atomic.LoadInt32(&calls)+1, body)
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	l := zerolog.Nop()
+
+	c, err := New("test-retry", Config{Backoff: yconf.Duration(time.Millisecond)}, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "no such thing", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	l := zerolog.Nop()
+
+	c, err := New("test-noretry", Config{Backoff: yconf.Duration(time.Millisecond)}, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get(context.Background(), srv.URL)
+
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound || se.Body != "no such thing" {
+		t.Errorf("err = %v, want a 404 StatusError", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	l := zerolog.Nop()
+
+	c, err := New("test-limit", Config{Rate: 20, Burst: 2}, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	// The burst is immediate, the next 2 wait 50ms each.
+	for i := 0; i < 4; i++ {
+		if err := c.limit(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if took := time.Since(start); took < 90*time.Millisecond {
+		t.Errorf("took %s, want at least 100ms", took)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		val  string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Tue, 01 Jun 2021 12:00:30 GMT", 30 * time.Second},
+		{"Tue, 01 Jun 2021 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.val, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	l := zerolog.Nop()
+
+	if _, err := New("test-new", Config{Proxy: "::"}, &l); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+
+	if _, err := New("test-new", Config{Backoff: yconf.Duration(time.Minute), MaxBackoff: yconf.Duration(time.Second)}, &l); err == nil {
+		t.Error("expected an error for maxbackoff less then backoff")
+	}
+}
@@ -2,6 +2,8 @@ package idmanager
 
 import (
 	"errors"
+	"fmt"
+	"frame/internal/redact"
 	"frame/yconf"
 )
 
@@ -37,7 +39,7 @@ func (im *IDManager) loadConf() error {
 		return err
 	}
 
-	fl.Debug().Interface("conf", co).Send()
+	fl.Debug().Interface("conf", redact.Conf(co)).Send()
 
 	if co == nil || co.Database == "" {
 		err := errors.New("Missing database")
@@ -57,14 +59,18 @@ func (im *IDManager) loadConf() error {
 		return err
 	}
 
+	if co.TokenKey != "" && len(co.TokenKey) < minTokenKey {
+		err := fmt.Errorf("tokenkey needs to be at least %d characters", minTokenKey)
+		fl.Err(err).Send()
+		return err
+	}
+
 	// We need a new database connection before we can add the cache.
-	db, err := im.dbConnect(co)
-	if err != nil {
-		fl.Err(err).Str("db", co.Database).Msg("new dbConnect")
+	if err := im.dbConnect(co); err != nil {
+		fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
 		return err
 	}
 
-	im.db.Store(db)
 	im.co.Store(co)
 
 	return nil
@@ -96,6 +102,14 @@ func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
 		inA.Queries.GetHash = inB.Queries.GetHash
 	}
 
+	if inA.Queries.Rehash != inB.Queries.Rehash && inB.Queries.Rehash != "" {
+		inA.Queries.Rehash = inB.Queries.Rehash
+	}
+
+	if inB.TokenKey != "" {
+		inA.TokenKey = inB.TokenKey
+	}
+
 	// First ensure A has the database if not empty.
 	if inA.Database != inB.Database && inB.Database != "" {
 		// Since inB is always the latest file opened, overwrite whatever is in inA.
@@ -131,5 +145,13 @@ func yconfChanged(origConfInt, newConfInt interface{}) bool {
 		return true
 	}
 
+	if origConf.Queries.Rehash != newConf.Queries.Rehash {
+		return true
+	}
+
+	if origConf.TokenKey != newConf.TokenKey {
+		return true
+	}
+
 	return false
 } // }}}
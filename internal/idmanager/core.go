@@ -3,13 +3,11 @@ package idmanager
 import (
 	"context"
 	"errors"
+	"frame/internal/pgdb"
 	"frame/types"
 	"strings"
 	"sync/atomic"
 
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/log/zerologadapter"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
 )
 
@@ -26,6 +24,8 @@ func New(confFile string, l *zerolog.Logger, ctx context.Context) (*IDManager, e
 
 	fl := im.l.With().Str("func", "New").Logger()
 
+	im.db = pgdb.New(&im.l, ctx)
+
 	// Load our configuration.
 	if err = im.loadConf(); err != nil {
 		return nil, err
@@ -45,84 +45,22 @@ func New(confFile string, l *zerolog.Logger, ctx context.Context) (*IDManager, e
 	return im, nil
 } // }}}
 
-// func IDManager.setupDB {{{
-
-// This creates all prepared statements, and if everything goes OK replaces ip.db with this provided db.
-func (im *IDManager) setupDB(co *conf, db *pgx.Conn) error {
-	fl := im.l.With().Str("func", "setupDB").Str("db", co.Database).Logger()
-
-	// No using the database after a shutdown.
-	if atomic.LoadUint32(&im.closed) == 1 {
-		fl.Debug().Msg("called after shutdown")
-		return types.ErrShutdown
-	}
-
-	queries := co.Queries
-
-	// Lets prepare all our statements
-	if _, err := db.Prepare(im.ctx, "get-id", queries.GetID); err != nil {
-		fl.Err(err).Msg("get-id")
-		return err
-	}
-
-	if _, err := db.Prepare(im.ctx, "get-hash", queries.GetHash); err != nil {
-		fl.Err(err).Msg("get-hash")
-		return err
-	}
-
-	fl.Debug().Msg("prepared")
-
-	return nil
-} // }}}
-
 // func IDManager.dbConnect {{{
 
-func (im *IDManager) dbConnect(co *conf) (*pgxpool.Pool, error) {
-	var err error
-	var db *pgxpool.Pool
-
-	poolConf, err := pgxpool.ParseConfig(co.Database)
-	if err != nil {
-		return nil, err
-	}
-
-	// Set the log level properly.
-	cc := poolConf.ConnConfig
-	cc.LogLevel = pgx.LogLevelInfo
-	cc.Logger = zerologadapter.NewLogger(im.l)
-
-	// So that each connection creates our prepared statements.
-	poolConf.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		if err := im.setupDB(co, conn); err != nil {
-			return err
-		}
-
-		return nil
+func (im *IDManager) dbConnect(co *conf) error {
+	stmts := []pgdb.Statement{
+		{Name: "get-id", Query: co.Queries.GetID},
+		{Name: "get-hash", Query: co.Queries.GetHash},
 	}
 
-	if db, err = pgxpool.ConnectConfig(im.ctx, poolConf); err != nil {
-		return nil, err
+	if co.Queries.Rehash != "" {
+		stmts = append(stmts, pgdb.Statement{Name: "rehash", Query: co.Queries.Rehash})
 	}
 
-	return db, nil
-} // }}}
-
-// func IDManager.getDB {{{
-
-// Returns the current database pool.
-//
-// Loads it from an atomic value so that it can be replaced while running without causing issues.
-func (im *IDManager) getDB() (*pgxpool.Pool, error) {
-	fl := im.l.With().Str("func", "getDB").Logger()
-
-	db, ok := im.db.Load().(*pgxpool.Pool)
-	if !ok {
-		err := errors.New("Not a pool")
-		fl.Warn().Err(err).Send()
-		return nil, err
-	}
-
-	return db, nil
+	return im.db.Connect(&pgdb.Config{
+		Database:   co.Database,
+		Statements: stmts,
+	})
 } // }}}
 
 // func IDManager.close {{{
@@ -139,11 +77,7 @@ func (im *IDManager) close() {
 
 	fl.Info().Msg("closed")
 
-	if db, err := im.getDB(); err == nil {
-		if db != nil {
-			db.Close()
-		}
-	}
+	im.db.Close()
 } // }}}
 
 // func IDManager.GetHash {{{
@@ -173,13 +107,19 @@ func (im *IDManager) GetHash(in uint64) (string, error) {
 		}
 	}
 
-	db, err := im.getDB()
+	db, err := im.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return "", err
 	}
 
 	if err := db.QueryRow(im.ctx, "get-hash", in).Scan(&hash); err != nil {
+		// No such ID is not really an error on our part.
+		if pgdb.Classify(err) == pgdb.ErrClassNoRows {
+			fl.Debug().Msg("not found")
+			return "", types.ErrNotFound
+		}
+
 		fl.Err(err).Msg("db-GetHash")
 		return "", err
 	}
@@ -219,9 +159,9 @@ func (im *IDManager) GetID(in string) (uint64, error) {
 		}
 	}
 
-	db, err := im.getDB()
+	db, err := im.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return 0, err
 	}
 
@@ -235,3 +175,49 @@ func (im *IDManager) GetID(in string) (uint64, error) {
 
 	return id, nil
 } // }}}
+
+// func IDManager.Rehash {{{
+
+// Changes the hash of an existing ID, keeping the ID itself.
+//
+// Implements types.IDRehasher, returns ErrNoRehash without the rehash query.
+func (im *IDManager) Rehash(id uint64, hash string) error {
+	fl := im.l.With().Str("func", "Rehash").Uint64("id", id).Logger()
+
+	if atomic.LoadUint32(&im.closed) == 1 {
+		fl.Info().Msg("called after shutdown")
+		return types.ErrShutdown
+	}
+
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if id == 0 || hash == "" {
+		return errors.New("Empty id or hash")
+	}
+
+	if co, ok := im.co.Load().(*conf); !ok || co.Queries.Rehash == "" {
+		return ErrNoRehash
+	}
+
+	db, err := im.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return err
+	}
+
+	if _, err := db.Exec(im.ctx, "rehash", id, hash); err != nil {
+		fl.Err(err).Str("hash", hash).Msg("db-Rehash")
+		return err
+	}
+
+	// The old hash no longer exists.
+	if old, ok := im.hcache.Load(id); ok {
+		im.cache.Delete(old)
+	}
+
+	im.hcache.Store(id, hash)
+	im.cache.Store(hash, id)
+
+	fl.Debug().Str("hash", hash).Send()
+
+	return nil
+} // }}}
@@ -0,0 +1,127 @@
+package idmanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"frame/types"
+)
+
+// Returned by TokenFor() and IDFor() when there is no token key.
+var ErrNoTokenKey = errors.New("no tokenkey")
+
+// The shortest tokenkey accepted.
+const minTokenKey = 16
+
+// Tokens are a single AES block of the ID followed by the first 8 bytes of its HMAC, each with keys derived from the
+// tokenkey.
+//
+// So the same ID always gives the same token (handy for caching a URL), nothing about the ID can be seen from the
+// token, and the HMAC lets us know a token is one of ours without needing the database.
+
+// func tokenKeys {{{
+
+// Derives the keys for the AES block and the HMAC from the configured key.
+func tokenKeys(key string) (cipher.Block, []byte, error) {
+	if key == "" {
+		return nil, nil, ErrNoTokenKey
+	}
+
+	derive := func(use string) []byte {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(use))
+		return mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(derive("frame token block")[:16])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, derive("frame token mac"), nil
+} // }}}
+
+// func tokenMAC {{{
+
+func tokenMAC(macKey []byte, id []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(id)
+	return mac.Sum(nil)[:8]
+} // }}}
+
+// func IDManager.tokenKeys {{{
+
+func (im *IDManager) tokenKeys() (cipher.Block, []byte, error) {
+	co, ok := im.co.Load().(*conf)
+	if !ok {
+		return nil, nil, ErrNoTokenKey
+	}
+
+	return tokenKeys(co.TokenKey)
+} // }}}
+
+// func IDManager.TokenFor {{{
+
+// Returns the opaque token for the ID, see types.IDTokener.
+//
+// Changing the tokenkey changes every token, so any given out before are no longer known.
+func (im *IDManager) TokenFor(id uint64) (string, error) {
+	if id == 0 {
+		return "", errors.New("Empty id")
+	}
+
+	block, macKey, err := im.tokenKeys()
+	if err != nil {
+		return "", err
+	}
+
+	return makeToken(block, macKey, id), nil
+} // }}}
+
+// func IDManager.IDFor {{{
+
+// Returns the ID of a token from TokenFor(), types.ErrNotFound if it is not a token we gave out.
+//
+// The ID is not checked to exist, only that the token was given for it.
+func (im *IDManager) IDFor(token string) (uint64, error) {
+	block, macKey, err := im.tokenKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	return readToken(block, macKey, token)
+} // }}}
+
+// func makeToken {{{
+
+func makeToken(block cipher.Block, macKey []byte, id uint64) string {
+	buf := make([]byte, aes.BlockSize)
+
+	binary.BigEndian.PutUint64(buf, id)
+	copy(buf[8:], tokenMAC(macKey, buf[:8]))
+
+	block.Encrypt(buf, buf)
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+} // }}}
+
+// func readToken {{{
+
+func readToken(block cipher.Block, macKey []byte, token string) (uint64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != aes.BlockSize {
+		return 0, types.ErrNotFound
+	}
+
+	block.Decrypt(buf, buf)
+
+	if !hmac.Equal(buf[8:], tokenMAC(macKey, buf[:8])) {
+		return 0, types.ErrNotFound
+	}
+
+	return binary.BigEndian.Uint64(buf), nil
+} // }}}
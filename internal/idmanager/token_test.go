@@ -0,0 +1,123 @@
+package idmanager
+
+import (
+	"encoding/base64"
+	"errors"
+	"frame/types"
+	"testing"
+)
+
+// Returns an IDManager with only the tokenkey set, all the token functions need.
+func tokenIM(key string) *IDManager {
+	im := &IDManager{}
+	im.co.Store(&conf{TokenKey: key})
+
+	return im
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	im := tokenIM("0123456789abcdef")
+
+	for _, id := range []uint64{1, 2, 1 << 40, ^uint64(0)} {
+		token, err := im.TokenFor(id)
+		if err != nil {
+			t.Fatalf("TokenFor %d: %s", id, err)
+		}
+
+		// Always the same token.
+		if again, _ := im.TokenFor(id); again != token {
+			t.Fatalf("TokenFor %d Expected %s != Got %s", id, token, again)
+		}
+
+		got, err := im.IDFor(token)
+		if err != nil {
+			t.Fatalf("IDFor %s: %s", token, err)
+		}
+
+		if got != id {
+			t.Fatalf("IDFor Expected %d != Got %d", id, got)
+		}
+	}
+
+	if _, err := im.TokenFor(0); err == nil {
+		t.Fatalf("TokenFor 0 Expected error != Got nil")
+	}
+}
+
+func TestTokenTampered(t *testing.T) {
+	im := tokenIM("0123456789abcdef")
+
+	token, err := im.TokenFor(42)
+	if err != nil {
+		t.Fatalf("TokenFor: %s", err)
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatalf("DecodeString: %s", err)
+	}
+
+	// Every single bit flipped on its own.
+	for i := 0; i < len(buf)*8; i++ {
+		bad := append([]byte(nil), buf...)
+		bad[i/8] ^= 1 << (i % 8)
+
+		if _, err := im.IDFor(base64.RawURLEncoding.EncodeToString(bad)); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("IDFor bit %d Expected %v != Got %v", i, types.ErrNotFound, err)
+		}
+	}
+}
+
+func TestTokenInvalid(t *testing.T) {
+	im := tokenIM("0123456789abcdef")
+
+	token, err := im.TokenFor(42)
+	if err != nil {
+		t.Fatalf("TokenFor: %s", err)
+	}
+
+	tests := map[string]string{
+		"empty":   "",
+		"short":   token[:len(token)-2],
+		"long":    token + "AA",
+		"base64":  token[:len(token)-1] + "!",
+		"padded":  base64.URLEncoding.EncodeToString(make([]byte, 16)),
+		"garbage": "not a token at all",
+	}
+
+	for name, bad := range tests {
+		if _, err := im.IDFor(bad); !errors.Is(err, types.ErrNotFound) {
+			t.Fatalf("IDFor %s Expected %v != Got %v", name, types.ErrNotFound, err)
+		}
+	}
+}
+
+func TestTokenKeyChanged(t *testing.T) {
+	im := tokenIM("0123456789abcdef")
+
+	token, err := im.TokenFor(42)
+	if err != nil {
+		t.Fatalf("TokenFor: %s", err)
+	}
+
+	im.co.Store(&conf{TokenKey: "fedcba9876543210"})
+
+	if _, err := im.IDFor(token); !errors.Is(err, types.ErrNotFound) {
+		t.Fatalf("IDFor old token Expected %v != Got %v", types.ErrNotFound, err)
+	}
+
+	if again, _ := im.TokenFor(42); again == token {
+		t.Fatalf("TokenFor Expected a new token != Got the old %s", token)
+	}
+
+	// No key at all.
+	im.co.Store(&conf{})
+
+	if _, err := im.TokenFor(42); !errors.Is(err, ErrNoTokenKey) {
+		t.Fatalf("TokenFor Expected %v != Got %v", ErrNoTokenKey, err)
+	}
+
+	if _, err := im.IDFor(token); !errors.Is(err, ErrNoTokenKey) {
+		t.Fatalf("IDFor Expected %v != Got %v", ErrNoTokenKey, err)
+	}
+}
@@ -2,6 +2,8 @@ package idmanager
 
 import (
 	"context"
+	"errors"
+	"frame/internal/pgdb"
 	"frame/yconf"
 	"sync"
 	"sync/atomic"
@@ -9,14 +11,27 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Returned by Rehash() when there is no rehash query.
+var ErrNoRehash = errors.New("no rehash query")
+
 type conf struct {
-	Database string      `yaml:"database"`
+	Database string      `yaml:"database" log:"redact"`
 	Queries  confQueries `yaml:"queries"`
+
+	// Optional - The secret the tokens of TokenFor() are made with, at least 16 characters.
+	//
+	// Without it there are no tokens. Best kept out of the file with a secret reference, such as "${env:FRAME_TOKENKEY}".
+	TokenKey string `yaml:"tokenkey" log:"redact"`
 }
 
 type confQueries struct {
 	GetID   string `yaml:"getid"`
 	GetHash string `yaml:"gethash"`
+
+	// Optional - Changes the hash of an existing ID, given the ID and the new hash.
+	//
+	// Needed to finish moving the CacheManager to a new hash, see IDManager.Rehash().
+	Rehash string `yaml:"rehash"`
 }
 
 // type IDManager struct {{{
@@ -34,10 +49,8 @@ type IDManager struct {
 	// a reverse lookup is not typical from the same program.
 	hcache sync.Map
 
-	// Stores the *pgxpool.Pool
-	//
-	// We use an atomic because we want to be able to replace the connection while we are running.
-	db atomic.Value
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
 
 	cFile string
 
@@ -0,0 +1,194 @@
+package imgproc
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// func ImageProc.removeBases {{{
+
+// Drops every base no longer within the new configuration, disabling all its paths and files in the database.
+//
+// Must be called before the new configuration is stored and connectBases() closes the database of any removed base
+// with its own, as both are still needed to disable it.
+//
+// A base being checked is waited for, so the check does not carry on writing to the database after.
+func (ip *ImageProc) removeBases(co *conf) {
+	var removed []*baseCache
+
+	fl := ip.l.With().Str("func", "removeBases").Logger()
+
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	for id, bc := range ca.bases {
+		if _, ok := co.Bases[id]; !ok {
+			removed = append(removed, bc)
+			delete(ca.bases, id)
+		}
+	}
+	ca.cMut.Unlock()
+
+	oldco := ip.getConf()
+
+	for _, bc := range removed {
+		ip.sch.Remove("base " + strconv.Itoa(bc.Base))
+
+		cb, ok := oldco.Bases[bc.Base]
+		if !ok {
+			continue
+		}
+
+		if err := ip.dropBase(bc, cb); err != nil {
+			fl.Err(err).Int("base", bc.Base).Msg("dropBase")
+			continue
+		}
+
+		fl.Info().Int("base", bc.Base).Msg("base removed")
+	}
+} // }}}
+
+// func ImageProc.dropBase {{{
+
+// Disables every path and file of the base in a single transaction.
+//
+// Takes the check "lock" and never gives it back, the baseCache is no longer used.
+func (ip *ImageProc) dropBase(bc *baseCache, cb *confBase) error {
+	fl := ip.l.With().Str("func", "dropBase").Int("base", bc.Base).Logger()
+
+	for !atomic.CompareAndSwapUint32(&bc.checkRun, 0, 1) {
+		fl.Debug().Msg("waiting for check")
+
+		select {
+		case <-ip.ctx.Done():
+			return context.Canceled
+		case <-time.After(time.Second):
+		}
+	}
+
+	bc.bMut.Lock()
+	defer bc.bMut.Unlock()
+
+	db, err := ip.baseDB(bc.Base)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ip.ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ip.ctx)
+
+	// Only for logChange().
+	cr := &checkRun{cb: cb, bc: bc}
+
+	var paths, files int
+
+	for _, pc := range bc.Paths {
+		for _, fc := range pc.Files {
+			if fc.id == 0 || fc.disabled {
+				continue
+			}
+
+			if _, err := tx.Exec(ip.ctx, "files-disable", fc.id); err != nil {
+				return err
+			}
+
+			if err := ip.logChange(tx, cr, changeDisable, fc); err != nil {
+				return err
+			}
+
+			files++
+		}
+
+		if pc.id == 0 || pc.disabled {
+			continue
+		}
+
+		if _, err := tx.Exec(ip.ctx, "paths-disable", pc.id); err != nil {
+			return err
+		}
+
+		paths++
+	}
+
+	if err := tx.Commit(ip.ctx); err != nil {
+		return err
+	}
+
+	fl.Info().Int("paths", paths).Int("files", files).Msg("disabled")
+
+	return nil
+} // }}}
+
+// func ImageProc.addBases {{{
+
+// Adds a baseCache for every base within the configuration that does not have one, then starts its first check.
+//
+// A base already loaded by loadCache() (such as when the database changed at the same time) is only checked.
+func (ip *ImageProc) addBases(co *conf, added []int) {
+	fl := ip.l.With().Str("func", "addBases").Logger()
+
+	ca := ip.ca
+
+	for _, id := range added {
+		cb, ok := co.Bases[id]
+		if !ok {
+			continue
+		}
+
+		ca.cMut.Lock()
+		bc, ok := ca.bases[id]
+
+		if !ok {
+			db, err := ip.baseDB(id)
+			if err != nil {
+				ca.cMut.Unlock()
+				fl.Err(err).Int("base", id).Msg("baseDB")
+				continue
+			}
+
+			if err := ip.addBaseCache(cb, ca, db); err != nil {
+				// Otherwise it would be checked with only part of its cache, adding everything else again.
+				delete(ca.bases, id)
+				ca.cMut.Unlock()
+				fl.Err(err).Int("base", id).Msg("addBaseCache")
+				continue
+			}
+
+			bc = ca.bases[id]
+		}
+		ca.cMut.Unlock()
+
+		fl.Info().Int("base", id).Str("path", cb.Path).Msg("base added")
+
+		// Same as at startup, a full first check.
+		bc.bMut.Lock()
+		bc.force = true
+		bc.bMut.Unlock()
+
+		go ip.checkBase(bc, "")
+	}
+} // }}}
+
+// func newBases {{{
+
+// Returns the bases within co that are not in oldco, sorted.
+func newBases(oldco, co *conf) []int {
+	var added []int
+
+	for id := range co.Bases {
+		if _, ok := oldco.Bases[id]; !ok {
+			added = append(added, id)
+		}
+	}
+
+	sort.Ints(added)
+
+	return added
+} // }}}
@@ -0,0 +1,59 @@
+package imgproc
+
+import (
+	"github.com/jackc/pgx/v4"
+)
+
+// The action of each row in the change log, see confQueries.ChangesInsert.
+const (
+	changeAdd     = "add"
+	changeUpdate  = "update"
+	changeDisable = "disable"
+)
+
+// func ImageProc.logChange {{{
+
+// Adds the change to the file to the change log within tx, if the base has the changes-insert query.
+//
+// The old hash and tags are what the database last had (fileCache.dbID and dbTags), the new are what is being
+// written. A disable has no new, an add has no old.
+//
+// An update that only changed timestamps is left out, as neither the hash or tags changed.
+func (ip *ImageProc) logChange(tx pgx.Tx, cr *checkRun, action string, fc *fileCache) error {
+	var oldID, newID uint64
+
+	if _, qu := ip.getConf().baseTarget(cr.cb); qu.ChangesInsert == "" {
+		return nil
+	}
+
+	oldTags, newTags := fc.dbTags, fc.CTags
+
+	switch action {
+	case changeAdd:
+		newID = fc.ID
+		oldTags = nil
+	case changeUpdate:
+		if fc.ID == fc.dbID && fc.CTags.Equal(fc.dbTags) {
+			return nil
+		}
+
+		oldID, newID = fc.dbID, fc.ID
+	case changeDisable:
+		oldID = fc.dbID
+		newTags = nil
+	}
+
+	_, err := tx.Exec(ip.ctx, "changes-insert", fc.id, cr.bc.Base, action, nullID(oldID), nullID(newID), oldTags, newTags)
+	return err
+} // }}}
+
+// func nullID {{{
+
+// Returns nil for an ID of 0, so it is NULL in the database rather then a hash ID that does not exist.
+func nullID(id uint64) interface{} {
+	if id == 0 {
+		return nil
+	}
+
+	return id
+} // }}}
@@ -0,0 +1,1116 @@
+package imgproc
+
+import (
+	"errors"
+	"frame/internal/chaos"
+	"frame/internal/redact"
+	"frame/yconf"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/log/zerologadapter"
+)
+
+// This file contains all functions related to the loading of our configuration files.
+
+// func yconfConvert {{{
+
+func (ip *ImageProc) yconfConvert(inInt interface{}) (interface{}, error) {
+	var err error
+
+	fl := ip.l.With().Str("func", "yconfConvert").Logger()
+
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		// No conversion needed here.
+		Database: in.Database,
+	}
+
+	if in.Queries != nil {
+		// We use the same structure between both, so just copy.
+		out.Queries = in.Queries
+	}
+
+	if in.Workers != nil {
+		out.Workers = &confWorkers{
+			Name:        in.Workers.Name,
+			Coordinator: in.Workers.Coordinator,
+			Heartbeat:   time.Duration(in.Workers.Heartbeat),
+			Expire:      time.Duration(in.Workers.Expire),
+		}
+
+		if out.Workers.Name == "" {
+			if out.Workers.Name, err = os.Hostname(); err != nil {
+				fl.Err(err).Msg("workers name")
+				return nil, err
+			}
+		}
+
+		if out.Workers.Heartbeat == 0 {
+			out.Workers.Heartbeat = 30 * time.Second
+		}
+
+		if out.Workers.Expire == 0 {
+			out.Workers.Expire = 3 * out.Workers.Heartbeat
+		}
+	}
+
+	// Any file system base paths defined?
+	if in.Bases != nil && len(in.Bases) > 0 {
+		out.Bases = make(map[int]*confBase, len(in.Bases))
+		for path, baseYAML := range in.Bases {
+			outBP := &confBase{
+				Base:      baseYAML.Base,
+				Path:      path,
+				EnableRaw: baseYAML.EnableRaw,
+
+				StrictDecode: baseYAML.StrictDecode,
+
+				Fingerprint: baseYAML.Fingerprint,
+				FingerBytes: baseYAML.FingerBytes,
+
+				HashPixels:    baseYAML.HashPixels,
+				HashPixelsMin: baseYAML.HashPixelsMin,
+
+				FastLane: baseYAML.FastLane,
+
+				StaleFraction: baseYAML.StaleFraction,
+
+				FollowSymlinks: baseYAML.FollowSymlinks,
+				OneFilesystem:  baseYAML.OneFilesystem,
+				MaxDepth:       baseYAML.MaxDepth,
+
+				TombstoneSample: baseYAML.TombstoneSample,
+				FullEvery:       baseYAML.FullEvery,
+
+				Manifest: baseYAML.Manifest,
+
+				Database: baseYAML.Database,
+				Queries:  baseYAML.Queries,
+
+				// Default the TagFile here.
+				TagFile: "tags.txt",
+			}
+
+			// Replace the default TagFile if set.
+			if baseYAML.TagFile != "" {
+				outBP.TagFile = baseYAML.TagFile
+			}
+
+			if outBP.Commands, err = parseCommands(baseYAML.Commands); err != nil {
+				fl.Err(err).Str("path", path).Msg("commands")
+				return nil, err
+			}
+
+			if outBP.Exts, err = parseExts(baseYAML.Extensions, baseYAML.Decoders, outBP.Commands); err != nil {
+				fl.Err(err).Str("path", path).Msg("extensions")
+				return nil, err
+			}
+
+			if outBP.NameTags, err = parseNameTags(baseYAML.FilenameBrackets, baseYAML.FilenameTags); err != nil {
+				fl.Err(err).Str("path", path).Msg("filenametags")
+				return nil, err
+			}
+
+			if outBP.Manifest != "" {
+				if _, err = manifestFormat(outBP.Manifest); err != nil {
+					fl.Err(err).Str("path", path).Msg("manifest")
+					return nil, err
+				}
+			}
+
+			if outBP.HashPixelsMin < 0 {
+				err = errors.New("invalid hashpixelsmin")
+				fl.Err(err).Str("path", path).Send()
+				return nil, err
+			}
+
+			// Default the fingerprint size to 64KiB
+			if outBP.FingerBytes <= 0 {
+				outBP.FingerBytes = 64 * 1024
+			}
+
+			outBP.DisableLoops = baseYAML.DisableLoops
+			outBP.DisableAfter = time.Duration(baseYAML.DisableAfter)
+
+			if outBP.DisableAfter < 0 {
+				err = errors.New("invalid disableafter")
+				fl.Err(err).Stringer("disableafter", baseYAML.DisableAfter).Send()
+				return nil, err
+			}
+
+			// If no check interval, default to 5 minutes
+			outBP.CheckInt = time.Duration(baseYAML.CheckInt)
+			if outBP.CheckInt == 0 {
+				outBP.CheckInt = 5 * time.Minute
+			}
+
+			// Set the map in the output base.
+			out.Bases[baseYAML.Base] = outBP
+		}
+	}
+
+	ip.l.Debug().Str("func", "yconfConvert").Interface("out", redact.Conf(out)).Send()
+	return out, nil
+} // }}}
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	var skipBase bool = false
+
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	// This means that for our various maps, inA will continue to grow as the number of files we process grow, but inB will always be just the
+	// most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	// Merge the queries if needed.
+	if inA.Queries != inB.Queries && inB.Queries != nil {
+		inA.Queries = inB.Queries
+	} else if inA.Queries != nil && inB.Queries != nil {
+		// Both have queries, so update any ones that have changed
+		if inA.Queries.FilesSelect != inB.Queries.FilesSelect && inB.Queries.FilesSelect != "" {
+			inA.Queries.FilesSelect = inB.Queries.FilesSelect
+		}
+
+		if inA.Queries.FilesInsert != inB.Queries.FilesInsert && inB.Queries.FilesInsert != "" {
+			inA.Queries.FilesInsert = inB.Queries.FilesInsert
+		}
+
+		if inA.Queries.FilesUpdate != inB.Queries.FilesUpdate && inB.Queries.FilesUpdate != "" {
+			inA.Queries.FilesUpdate = inB.Queries.FilesUpdate
+		}
+
+		if inA.Queries.FilesDisable != inB.Queries.FilesDisable && inB.Queries.FilesDisable != "" {
+			inA.Queries.FilesDisable = inB.Queries.FilesDisable
+		}
+
+		if inA.Queries.PathsSelect != inB.Queries.PathsSelect && inB.Queries.PathsSelect != "" {
+			inA.Queries.PathsSelect = inB.Queries.PathsSelect
+		}
+
+		if inA.Queries.PathsInsert != inB.Queries.PathsInsert && inB.Queries.PathsInsert != "" {
+			inA.Queries.PathsInsert = inB.Queries.PathsInsert
+		}
+
+		if inA.Queries.PathsUpdate != inB.Queries.PathsUpdate && inB.Queries.PathsUpdate != "" {
+			inA.Queries.PathsUpdate = inB.Queries.PathsUpdate
+		}
+
+		if inA.Queries.PathsDisable != inB.Queries.PathsDisable && inB.Queries.PathsDisable != "" {
+			inA.Queries.PathsDisable = inB.Queries.PathsDisable
+		}
+
+		if inA.Queries.RunsInsert != inB.Queries.RunsInsert && inB.Queries.RunsInsert != "" {
+			inA.Queries.RunsInsert = inB.Queries.RunsInsert
+		}
+
+		if inA.Queries.ChangesInsert != inB.Queries.ChangesInsert && inB.Queries.ChangesInsert != "" {
+			inA.Queries.ChangesInsert = inB.Queries.ChangesInsert
+		}
+
+		if inA.Queries.CheckpointSelect != inB.Queries.CheckpointSelect && inB.Queries.CheckpointSelect != "" {
+			inA.Queries.CheckpointSelect = inB.Queries.CheckpointSelect
+		}
+
+		if inA.Queries.CheckpointUpdate != inB.Queries.CheckpointUpdate && inB.Queries.CheckpointUpdate != "" {
+			inA.Queries.CheckpointUpdate = inB.Queries.CheckpointUpdate
+		}
+
+		if inA.Queries.WorkersRegister != inB.Queries.WorkersRegister && inB.Queries.WorkersRegister != "" {
+			inA.Queries.WorkersRegister = inB.Queries.WorkersRegister
+		}
+
+		if inA.Queries.WorkersAssigned != inB.Queries.WorkersAssigned && inB.Queries.WorkersAssigned != "" {
+			inA.Queries.WorkersAssigned = inB.Queries.WorkersAssigned
+		}
+
+		if inA.Queries.WorkersLive != inB.Queries.WorkersLive && inB.Queries.WorkersLive != "" {
+			inA.Queries.WorkersLive = inB.Queries.WorkersLive
+		}
+
+		if inA.Queries.WorkersCurrent != inB.Queries.WorkersCurrent && inB.Queries.WorkersCurrent != "" {
+			inA.Queries.WorkersCurrent = inB.Queries.WorkersCurrent
+		}
+
+		if inA.Queries.WorkersAssign != inB.Queries.WorkersAssign && inB.Queries.WorkersAssign != "" {
+			inA.Queries.WorkersAssign = inB.Queries.WorkersAssign
+		}
+	}
+
+	// The workers are only ever in a single file, so the latest replaces it whole.
+	if inB.Workers != nil {
+		inA.Workers = inB.Workers
+	}
+
+	// First ensure A has the database if not empty.
+	if inA.Database != inB.Database && inB.Database != "" {
+		// Since inB is always the latest file opened, overwrite whatever is in inA.
+		inA.Database = inB.Database
+	}
+
+	// If inA has no Bases, but inB does - Just copy the map directly.
+	if inA.Bases == nil && inB.Bases != nil {
+		inA.Bases = inB.Bases
+		skipBase = true
+	}
+
+	// Does inB have any base paths?
+	if !skipBase && inB.Bases != nil && len(inB.Bases) > 0 {
+		// Run through inB, merging anything in it.
+		for id, base := range inB.Bases {
+			if baseA, ok := inA.Bases[id]; ok {
+				// Fix the ID, which should not really ever be wrong, but ya know ... Sanity be good.
+				if baseA.Base != id {
+					baseA.Base = id
+				}
+
+				// It is possible for paths within the base to be added before the base itself.
+				//
+				// This results in an empty main path for the base itself.
+				//
+				// We account for that here.
+				if baseA.Path == "" {
+					baseA.Path = base.Path
+				}
+
+				// TagFile changed?
+				if base.TagFile != baseA.TagFile {
+					baseA.TagFile = base.TagFile
+				}
+
+				// The CheckInterval can be 0, same type of logic as above.
+				// Paths added before the main base create an otherwise empty base.
+				if baseA.CheckInt == 0 {
+					baseA.CheckInt = base.CheckInt
+				}
+
+				if base.EnableRaw {
+					baseA.EnableRaw = true
+				}
+
+				if base.Exts != nil {
+					baseA.Exts = base.Exts
+				}
+
+				if base.Commands != nil {
+					baseA.Commands = base.Commands
+				}
+
+				if base.StrictDecode {
+					baseA.StrictDecode = true
+				}
+
+				if base.NameTags != nil {
+					baseA.NameTags = base.NameTags
+				}
+
+				if base.Fingerprint {
+					baseA.Fingerprint = true
+					baseA.FingerBytes = base.FingerBytes
+				}
+
+				if base.HashPixels {
+					baseA.HashPixels = true
+					baseA.HashPixelsMin = base.HashPixelsMin
+				}
+
+				if base.StaleFraction != 0 {
+					baseA.StaleFraction = base.StaleFraction
+				}
+
+				if base.DisableLoops != 0 {
+					baseA.DisableLoops = base.DisableLoops
+				}
+
+				if base.DisableAfter != 0 {
+					baseA.DisableAfter = base.DisableAfter
+				}
+
+				if base.FastLane {
+					baseA.FastLane = true
+				}
+
+				if base.FollowSymlinks {
+					baseA.FollowSymlinks = true
+				}
+
+				if base.OneFilesystem {
+					baseA.OneFilesystem = true
+				}
+
+				if base.MaxDepth != 0 {
+					baseA.MaxDepth = base.MaxDepth
+				}
+
+				if base.TombstoneSample != 0 {
+					baseA.TombstoneSample = base.TombstoneSample
+				}
+
+				if base.FullEvery != 0 {
+					baseA.FullEvery = base.FullEvery
+				}
+
+				if base.Manifest != "" {
+					baseA.Manifest = base.Manifest
+				}
+
+				if base.Database != "" {
+					baseA.Database = base.Database
+				}
+
+				if base.Queries != nil {
+					baseA.Queries = base.Queries
+				}
+
+				continue
+			}
+
+			// Doesn't exist in inA, so just copy it as-is
+			inA.Bases[id] = base
+		}
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Database != newConf.Database {
+		return true
+	}
+
+	// Queries change?
+	if origConf.Queries.FilesSelect != newConf.Queries.FilesSelect {
+		return true
+	}
+
+	if origConf.Queries.FilesInsert != newConf.Queries.FilesInsert {
+		return true
+	}
+
+	if origConf.Queries.FilesUpdate != newConf.Queries.FilesUpdate {
+		return true
+	}
+
+	if origConf.Queries.FilesDisable != newConf.Queries.FilesDisable {
+		return true
+	}
+
+	if origConf.Queries.PathsSelect != newConf.Queries.PathsSelect {
+		return true
+	}
+
+	if origConf.Queries.PathsInsert != newConf.Queries.PathsInsert {
+		return true
+	}
+
+	if origConf.Queries.PathsUpdate != newConf.Queries.PathsUpdate {
+		return true
+	}
+
+	if origConf.Queries.PathsDisable != newConf.Queries.PathsDisable {
+		return true
+	}
+
+	if origConf.Queries.RunsInsert != newConf.Queries.RunsInsert {
+		return true
+	}
+
+	if origConf.Queries.ChangesInsert != newConf.Queries.ChangesInsert {
+		return true
+	}
+
+	if origConf.Queries.CheckpointSelect != newConf.Queries.CheckpointSelect {
+		return true
+	}
+
+	if origConf.Queries.CheckpointUpdate != newConf.Queries.CheckpointUpdate {
+		return true
+	}
+
+	if origConf.Queries.WorkersRegister != newConf.Queries.WorkersRegister || origConf.Queries.WorkersAssigned != newConf.Queries.WorkersAssigned {
+		return true
+	}
+
+	if origConf.Queries.WorkersLive != newConf.Queries.WorkersLive || origConf.Queries.WorkersCurrent != newConf.Queries.WorkersCurrent {
+		return true
+	}
+
+	if origConf.Queries.WorkersAssign != newConf.Queries.WorkersAssign {
+		return true
+	}
+
+	if (origConf.Workers == nil) != (newConf.Workers == nil) {
+		return true
+	}
+
+	if origConf.Workers != nil && *origConf.Workers != *newConf.Workers {
+		return true
+	}
+
+	if len(origConf.Bases) != len(newConf.Bases) {
+		return true
+	}
+
+	// Run through the bases
+	for _, origBase := range origConf.Bases {
+		newBase, ok := newConf.Bases[origBase.Base]
+		if !ok {
+			return true
+		}
+
+		if origBase.TagFile != newBase.TagFile {
+			return true
+		}
+
+		if origBase.EnableRaw != newBase.EnableRaw {
+			return true
+		}
+
+		if !sameExts(origBase.Exts, newBase.Exts) || !sameCommands(origBase.Commands, newBase.Commands) {
+			return true
+		}
+
+		if origBase.StrictDecode != newBase.StrictDecode {
+			return true
+		}
+
+		if !sameNameTags(origBase.NameTags, newBase.NameTags) {
+			return true
+		}
+
+		if origBase.Fingerprint != newBase.Fingerprint || origBase.FingerBytes != newBase.FingerBytes {
+			return true
+		}
+
+		if origBase.HashPixels != newBase.HashPixels || origBase.HashPixelsMin != newBase.HashPixelsMin {
+			return true
+		}
+
+		if origBase.FastLane != newBase.FastLane {
+			return true
+		}
+
+		if origBase.StaleFraction != newBase.StaleFraction {
+			return true
+		}
+
+		if origBase.DisableLoops != newBase.DisableLoops || origBase.DisableAfter != newBase.DisableAfter {
+			return true
+		}
+
+		if origBase.FollowSymlinks != newBase.FollowSymlinks || origBase.OneFilesystem != newBase.OneFilesystem {
+			return true
+		}
+
+		if origBase.MaxDepth != newBase.MaxDepth {
+			return true
+		}
+
+		if origBase.TombstoneSample != newBase.TombstoneSample || origBase.FullEvery != newBase.FullEvery {
+			return true
+		}
+
+		if origBase.Manifest != newBase.Manifest {
+			return true
+		}
+
+		if origBase.Database != newBase.Database || !sameQueries(origBase.Queries, newBase.Queries) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func sameQueries {{{
+
+// Returns true if both are the same queries, nil is only the same as nil.
+func sameQueries(a, b *confQueries) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+} // }}}
+
+// func conf.baseTarget {{{
+
+// Returns the database and queries used by the base.
+//
+// Its own where it has them, otherwise the global ones. A nil cb is just the global ones.
+func (co *conf) baseTarget(cb *confBase) (string, confQueries) {
+	var qu confQueries
+
+	if co.Queries != nil {
+		qu = *co.Queries
+	}
+
+	if cb == nil {
+		return co.Database, qu
+	}
+
+	database := co.Database
+	if cb.Database != "" {
+		database = cb.Database
+	}
+
+	bq := cb.Queries
+	if bq == nil {
+		return database, qu
+	}
+
+	if bq.FilesSelect != "" {
+		qu.FilesSelect = bq.FilesSelect
+	}
+
+	if bq.FilesInsert != "" {
+		qu.FilesInsert = bq.FilesInsert
+	}
+
+	if bq.FilesUpdate != "" {
+		qu.FilesUpdate = bq.FilesUpdate
+	}
+
+	if bq.FilesDisable != "" {
+		qu.FilesDisable = bq.FilesDisable
+	}
+
+	if bq.PathsSelect != "" {
+		qu.PathsSelect = bq.PathsSelect
+	}
+
+	if bq.PathsInsert != "" {
+		qu.PathsInsert = bq.PathsInsert
+	}
+
+	if bq.PathsUpdate != "" {
+		qu.PathsUpdate = bq.PathsUpdate
+	}
+
+	if bq.PathsDisable != "" {
+		qu.PathsDisable = bq.PathsDisable
+	}
+
+	if bq.RunsInsert != "" {
+		qu.RunsInsert = bq.RunsInsert
+	}
+
+	if bq.ChangesInsert != "" {
+		qu.ChangesInsert = bq.ChangesInsert
+	}
+
+	if bq.CheckpointSelect != "" {
+		qu.CheckpointSelect = bq.CheckpointSelect
+	}
+
+	if bq.CheckpointUpdate != "" {
+		qu.CheckpointUpdate = bq.CheckpointUpdate
+	}
+
+	return database, qu
+} // }}}
+
+// func ImageProc.getConf {{{
+
+func (ip *ImageProc) getConf() *conf {
+	fl := ip.l.With().Str("func", "getconf").Logger()
+
+	if co, ok := ip.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	//
+	// If this does, then there is a deeper issue.
+	fl.Warn().Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func ImageProc.checkConf {{{
+
+// Bool is true if everything checked out OK, false otherwise.
+// The uint64 are the bits that changed in the configuration.
+func (ip *ImageProc) checkConf(co *conf, reload bool) (bool, uint64) {
+	var ucBits uint64
+
+	fl := ip.l.With().Str("func", "checkConf").Bool("reload", reload).Logger()
+
+	if co == nil {
+		fl.Warn().Msg("no configuration loaded")
+		return false, ucBits
+	}
+
+	// We need at least one base, else we have nothing to actually do.
+	if len(co.Bases) < 1 {
+		fl.Warn().Msg("no bases loaded")
+		return false, ucBits
+	}
+
+	// Basic sanity checks on each base.
+	for id, bc := range co.Bases {
+		if id == 0 {
+			fl.Warn().Msg("Base ID 0 is not valid")
+			return false, ucBits
+		}
+
+		if bc.Path == "" {
+			fl.Warn().Int("base", id).Msg("Base has no path")
+			return false, ucBits
+		}
+
+		if bc.TagFile == "" {
+			fl.Warn().Int("base", id).Msg("Base has no tagfile")
+			return false, ucBits
+		}
+
+		if bc.CheckInt < time.Second*10 {
+			fl.Warn().Int("base", id).Msg("Base checkinterval needs to be 10 seconds or more")
+			return false, ucBits
+		}
+
+		if bc.StaleFraction < 0 || bc.StaleFraction > 1 {
+			fl.Warn().Int("base", id).Msg("Base stalefraction needs to be between 0 and 1")
+			return false, ucBits
+		}
+
+		if bc.MaxDepth < 0 {
+			fl.Warn().Int("base", id).Msg("Base maxdepth can not be negative")
+			return false, ucBits
+		}
+
+		if bc.TombstoneSample < 0 {
+			fl.Warn().Int("base", id).Msg("Base tombstonesample can not be negative")
+			return false, ucBits
+		}
+	}
+
+	// We have our queries?
+	if co.Queries == nil {
+		fl.Warn().Msg("Missing queries")
+		return false, ucBits
+	}
+
+	if co.Queries.PathsSelect == "" {
+		fl.Warn().Msg("Missing queries.paths-select")
+		return false, ucBits
+	}
+
+	if co.Queries.PathsInsert == "" {
+		fl.Warn().Msg("Missing queries.paths-insert")
+		return false, ucBits
+	}
+
+	if co.Queries.PathsUpdate == "" {
+		fl.Warn().Msg("Missing queries.paths-update")
+		return false, ucBits
+	}
+
+	if co.Queries.PathsDisable == "" {
+		fl.Warn().Msg("Missing queries.paths-disable")
+		return false, ucBits
+	}
+
+	if co.Queries.FilesSelect == "" {
+		fl.Warn().Msg("Missing queries.files-select")
+		return false, ucBits
+	}
+
+	if co.Queries.FilesInsert == "" {
+		fl.Warn().Msg("Missing queries.files-insert")
+		return false, ucBits
+	}
+
+	if co.Queries.FilesUpdate == "" {
+		fl.Warn().Msg("Missing queries.files-update")
+		return false, ucBits
+	}
+
+	if co.Queries.FilesDisable == "" {
+		fl.Warn().Msg("Missing queries.files-disable")
+		return false, ucBits
+	}
+
+	// The checkpoint queries are optional, but need both or neither.
+	//
+	// Checked for each base, as a base with its own queries could have only one.
+	for id, cb := range co.Bases {
+		_, qu := co.baseTarget(cb)
+		if (qu.CheckpointSelect == "") != (qu.CheckpointUpdate == "") {
+			fl.Warn().Int("base", id).Msg("Need both queries.checkpoint-select and queries.checkpoint-update")
+			return false, ucBits
+		}
+	}
+
+	if wo := co.Workers; wo != nil {
+		if co.Queries.WorkersRegister == "" || co.Queries.WorkersAssigned == "" {
+			fl.Warn().Msg("Workers need queries.workers-register and queries.workers-assigned")
+			return false, ucBits
+		}
+
+		if wo.Coordinator && (co.Queries.WorkersLive == "" || co.Queries.WorkersCurrent == "" || co.Queries.WorkersAssign == "") {
+			fl.Warn().Msg("Coordinator needs queries.workers-live, queries.workers-current and queries.workers-assign")
+			return false, ucBits
+		}
+
+		if wo.Heartbeat < 5*time.Second {
+			fl.Warn().Msg("Workers heartbeat needs to be 5 seconds or more")
+			return false, ucBits
+		}
+
+		if wo.Expire <= wo.Heartbeat {
+			fl.Warn().Msg("Workers expire needs to be longer then the heartbeat")
+			return false, ucBits
+		}
+	}
+
+	// Everything below here checks for changes between existing and new configuration.
+	//
+	// If there is no existing then we have nothing to compare against, so work is done.
+	oldco, ok := ip.co.Load().(*conf)
+
+	if !ok {
+		return true, ucBits
+	}
+
+	// Now we check to see what parts of the configuration changed.
+
+	if oldco.Database != co.Database {
+		ucBits |= ucDBConn
+	}
+
+	if oldco.Queries != co.Queries {
+		ucBits |= ucDBQuery
+	}
+
+	for id, base := range co.Bases {
+		if oldBase, ok := oldco.Bases[id]; ok && (oldBase.EnableRaw != base.EnableRaw || !sameExts(oldBase.Exts, base.Exts)) {
+			ucBits |= ucBaseRaw
+		}
+
+		if oldBase, ok := oldco.Bases[id]; ok && !sameNameTags(oldBase.NameTags, base.NameTags) {
+			ucBits |= ucBaseName
+		}
+	}
+
+	// If the connection changed, we want to do a quick test of it here to ensure we can connect
+	// before we accept it as valid.
+	if ucBits&ucDBConn != 0 {
+		// Ensure we have a database, and perform a basic connection test.
+		if co.Database == "" {
+			fl.Warn().Msg("Missing database")
+			return false, ucBits
+		}
+
+		dbConf, err := pgx.ParseConfig(co.Database)
+		if err != nil {
+			fl.Err(err).Msg("db conf test")
+			return false, ucBits
+		}
+
+		// Set the log level properly.
+		dbConf.LogLevel = pgx.LogLevelInfo
+		dbConf.Logger = zerologadapter.NewLogger(ip.l)
+
+		db, err := pgx.ConnectConfig(ip.ctx, dbConf)
+		if err != nil {
+			fl.Err(err).Msg("db conn test")
+			return false, ucBits
+		}
+
+		// Ok, disconnect now that we know that works.
+		if err = db.Ping(ip.ctx); err != nil {
+			fl.Err(err).Msg("db ping test")
+			db.Close(ip.ctx)
+			return false, ucBits
+		}
+
+		// Disconnect our test
+		db.Close(ip.ctx)
+	}
+
+	return true, ucBits
+} // }}}
+
+// func ImageProc.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (ip *ImageProc) loadConf() error {
+	var err error
+
+	fl := ip.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		ip.notifyConf()
+	}
+
+	ycc.Convert = func(in interface{}) (interface{}, error) {
+		return ip.yconfConvert(in)
+	}
+
+	if ip.yc, err = yconf.New(ip.cPath, ycc, &ip.l, ip.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = ip.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	// Get the loaded configuration
+	co, ok := ip.yc.Get().(*conf)
+	if !ok {
+		// This one should not really be possible, so this error needs to be sent.
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(co)).Send()
+
+	// We don't care about the changed bits here, because we know this is the first load.
+	good, _ := ip.checkConf(co, false)
+	if !good {
+		err := errors.New("bad config")
+		fl.Err(err).Send()
+		return err
+	}
+
+	// We need a new database connection before we can add the cache.
+	if err := ip.dbConnect(co); err != nil {
+		fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
+		return err
+	}
+
+	// Along with any bases that have their own.
+	if _, err := ip.connectBases(co); err != nil {
+		return err
+	}
+
+	// Get the cache so we can add the bases to it.
+	ca := ip.ca
+
+	// As we are going to potentially be adding to the bases map, we need the lock.
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	for _, base := range co.Bases {
+		db, err := ip.baseDB(base.Base)
+		if err != nil {
+			fl.Err(err).Int("base", base.Base).Msg("baseDB")
+			return err
+		}
+
+		// Ensure we have a base cache
+		if err := ip.addBaseCache(base, ca, db); err != nil {
+			fl.Err(err).Msg("base-check")
+			return err
+		}
+
+		// This should not be able to fail.
+		bc, ok := ca.bases[base.Base]
+		if !ok {
+			err := errors.New("missing cb.bases")
+			fl.Err(err).Send()
+			return err
+		}
+
+		bc.bMut.Lock()
+
+		if bc.tagFile != base.TagFile {
+			fl.Info().Int("base", base.Base).Msg("TagFile Updated")
+			bc.tagFile = base.TagFile
+		}
+
+		if base.Path != bc.path {
+			fl.Info().Str("path", base.Path).Msg("Path updated")
+			bc.path = base.Path
+			bc.bfs = chaos.FS(os.DirFS(bc.path))
+			bc.force = true
+		}
+
+		// Release the lock
+		bc.bMut.Unlock()
+	}
+
+	// Store the configuration.
+	ip.co.Store(co)
+
+	return nil
+} // }}}
+
+// func ImageProc.notifyConf {{{
+
+func (ip *ImageProc) notifyConf() {
+	fl := ip.l.With().Str("func", "notifyConf").Logger()
+
+	// Update our configuration.
+	co, ok := ip.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	good, ucBits := ip.checkConf(co, true)
+	if !good {
+		fl.Warn().Msg("invalid configuration - running off old configuration")
+		return
+	}
+
+	added := newBases(ip.getConf(), co)
+
+	// First, while the database of each is still the one its rows are within.
+	ip.removeBases(co)
+
+	// Set if the cache needs to be loaded again from the database.
+	var reload bool
+
+	if ucBits&(ucDBConn|ucDBQuery) != 0 {
+		// Replaces the old DB once the new one is working.
+		if err := ip.dbConnect(co); err != nil {
+			fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
+			return
+		}
+
+		// Since the database bits have been taken care of, clear those out.
+		if ucBits&ucDBConn != 0 {
+			ucBits ^= ucDBConn
+		}
+
+		if ucBits&ucDBQuery != 0 {
+			ucBits ^= ucDBQuery
+		}
+
+		reload = true
+	}
+
+	// Bases with their own database, any that changed have their cache refreshed below.
+	changed, err := ip.connectBases(co)
+	if err != nil {
+		fl.Err(err).Msg("connectBases")
+		return
+	}
+
+	if reload || changed {
+		// As something changed with the database, we need to refresh our cache.
+		if err := ip.loadCache(co); err != nil {
+			fl.Err(err).Msg("refreshing cache")
+		}
+	}
+
+	// Store the new configuration
+	ip.co.Store(co)
+
+	// Before scheduling them, so the first check is not run twice.
+	ip.addBases(co, added)
+
+	// Any base added or check interval changed.
+	ip.scheduleBases(co)
+	ip.scheduleWorkers(co)
+
+	// If RAW support was turned on or off for a base, the partial scans will not notice
+	// any files that need to be added or removed, so force a full on the next check.
+	if ucBits&ucBaseRaw != 0 {
+		ip.forceRaw(co)
+	}
+
+	// Likewise if the file name tags changed, every file needs its tags recalculated.
+	if ucBits&ucBaseName != 0 {
+		ip.forceRetag(co)
+	}
+
+	// Store the update bits
+	atomic.StoreUint64(&ip.ucBits, ucBits)
+
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func ImageProc.forceRaw {{{
+
+// Forces a full check on every base that had enableraw or its extensions changed.
+func (ip *ImageProc) forceRaw(co *conf) {
+	fl := ip.l.With().Str("func", "forceRaw").Logger()
+
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	for id, bc := range ca.bases {
+		cb, ok := co.Bases[id]
+		if !ok {
+			continue
+		}
+
+		bc.bMut.Lock()
+		if bc.enableRaw != cb.EnableRaw || !sameExts(bc.exts, cb.Exts) {
+			fl.Info().Int("base", id).Bool("enableraw", cb.EnableRaw).Msg("forcing full")
+			bc.enableRaw = cb.EnableRaw
+			bc.exts = cb.Exts
+			bc.force = true
+		}
+		bc.bMut.Unlock()
+	}
+} // }}}
+
+// func ImageProc.forceRetag {{{
+
+// Forces a full check that recalculates the tags of every file, on every base that had its file name tags changed.
+func (ip *ImageProc) forceRetag(co *conf) {
+	fl := ip.l.With().Str("func", "forceRetag").Logger()
+
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	for id, bc := range ca.bases {
+		cb, ok := co.Bases[id]
+		if !ok {
+			continue
+		}
+
+		bc.bMut.Lock()
+		if !sameNameTags(bc.nameTags, cb.NameTags) {
+			fl.Info().Int("base", id).Msg("forcing full retag")
+			bc.nameTags = cb.NameTags
+			bc.retag = true
+			bc.force = true
+		}
+		bc.bMut.Unlock()
+	}
+} // }}}
@@ -0,0 +1,2784 @@
+// Frame image processing.
+//
+// The package handles loading of all tags as well as caching of all images.
+//
+// The image cache is created and images are automatically rotated and resized as needed based on the "maxresolution" configuration option.
+//
+// Processes images found in configured paths, processes the files based on maximum configured sizes and loads the data into the database.
+//
+// Note - If you get bugs "no such file or directory" when reading from a network?
+//
+//  https://github.com/golang/go/issues/39237
+//
+// I got this during development, set GODEBUG=asyncpreemptoff=1
+package imgproc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	fimg "frame/image"
+	"frame/internal/chaos"
+	"frame/internal/errlog"
+	"frame/internal/features"
+	"frame/internal/pgdb"
+	"frame/internal/scheduler"
+	"frame/internal/tracing"
+	"frame/tags"
+	"frame/types"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("frame/internal/imgproc")
+
+var emptyTime = time.Time{}
+var noTagsPath = errors.New("No tags for path")
+
+// Returned by setFileHash() for a file of zero bytes.
+var errEmptyFile = errors.New("empty file")
+
+// How long an external decoder (see confBaseYAML.Commands) has to decode a single file.
+const commandTimeout = 2 * time.Minute
+
+// Decoders that can be used for an extension, and the file type (see getFileType) each results in.
+//
+// Everything other then RAW is decoded by image.Decode() so the decoder name is more for the users benefit,
+// but it does let us reject extensions we would never be able to decode.
+var decoderTypes = map[string]int{
+	"jpeg": 1,
+	"png":  1,
+	"gif":  1,
+	"webp": 1,
+	"tiff": 1,
+	"bmp":  1,
+	"raw":  3,
+}
+
+// The decoder for extensions we know about, so they can be listed in a bases extensions without also needing a decoder.
+var knownExts = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"jpe":  "jpeg",
+	"jfif": "jpeg",
+	"png":  "png",
+	"gif":  "gif",
+	"webp": "webp",
+	"tif":  "tiff",
+	"tiff": "tiff",
+	"bmp":  "bmp",
+	"cr2":  "raw",
+	"nef":  "raw",
+	"arw":  "raw",
+	"dng":  "raw",
+}
+
+// The extensions used when a base does not have any configured.
+var defaultExts = map[string]int{
+	".jpg":  1,
+	".jpeg": 1,
+	".gif":  1,
+	".png":  1,
+	".webp": 1,
+	".tif":  1,
+	".tiff": 1,
+	".cr2":  3,
+	".nef":  3,
+	".arw":  3,
+	".dng":  3,
+}
+
+// func getFileType {{{
+
+// Returns if the file is an image or sidecar.
+//
+// The extensions (with leading dot) mapped to their file type are given with exts, if nil then defaultExts is used.
+//
+// If its an image, 1 is returned and the 2nd value can be ignored.
+//
+// If its a sidecar 2 (txt) is returned, and the name of the base image (removing the .txt) is returned.
+//
+// If its a RAW camera file 3 is returned, these are only used when the base has enableraw set.
+//
+// This has potential to be expanded, such as with .xmp files.
+// I had that at one point, but there were so many variances I decided to just simplify this
+// code and read only a .txt file, with a single tag per line.
+//
+// Figure users can convert any other tag formats to a simple line-per text file easily enough.
+//
+// Returns 0 if the file is none of the above.
+func getFileType(file string, exts map[string]int) (int, string) {
+	// If the name is too short it can't match.
+	//
+	// Shortest we can match is 5 bytes, something like "1.jpg".
+	if len(file) < 5 {
+		return 0, ""
+	}
+
+	if exts == nil {
+		exts = defaultExts
+	}
+
+	// Get the extension.
+	ext := strings.ToLower(filepath.Ext(file))
+
+	if ext == ".txt" {
+		// Its a sidecar - But is it for an image?
+		// If its for example, 1.mp4.txt, we don't really care.
+		nfile := file[:len(file)-4]
+		if ft, _ := getFileType(nfile, exts); ft == 1 || ft == 3 {
+			return 2, nfile
+		}
+
+		// Not a valid sidecar
+		return 0, ""
+	}
+
+	// Anything not in exts is 0.
+	return exts[ext], ""
+} // }}}
+
+// func parseExts {{{
+
+// Converts the extensions, decoders and commands from a bases configuration into what getFileType() wants.
+//
+// Extensions are not case sensitive, and may or may not have the leading dot.
+//
+// Any extension with a command (see parseCommands) is an image, added to the defaults if no extensions are given.
+func parseExts(exts []string, decoders map[string]string, commands map[string][]string) (map[string]int, error) {
+	if len(exts) == 0 && len(commands) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]int, len(exts)+len(commands))
+
+	if len(exts) == 0 {
+		for ext, ft := range defaultExts {
+			out[ext] = ft
+		}
+	}
+
+	for ext := range commands {
+		out[ext] = 1
+	}
+
+	for _, ext := range exts {
+		ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+		if ext == "" || ext == "txt" {
+			return nil, fmt.Errorf("invalid extension %q", ext)
+		}
+
+		// A command takes care of it.
+		if _, ok := commands["."+ext]; ok {
+			continue
+		}
+
+		// Configured decoders override any we know about.
+		dec, ok := decoders[ext]
+		if !ok {
+			if dec, ok = knownExts[ext]; !ok {
+				return nil, fmt.Errorf("no decoder for extension %q", ext)
+			}
+		}
+
+		ft, ok := decoderTypes[strings.ToLower(dec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown decoder %q for extension %q", dec, ext)
+		}
+
+		out["."+ext] = ft
+	}
+
+	return out, nil
+} // }}}
+
+// func parseCommands {{{
+
+// Converts the commands from a bases configuration, mapping the extension (with leading dot) to the command and
+// its arguments.
+func parseCommands(commands map[string]string) (map[string][]string, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string][]string, len(commands))
+
+	for ext, command := range commands {
+		ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+		if ext == "" || ext == "txt" {
+			return nil, fmt.Errorf("invalid extension %q", ext)
+		}
+
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty command for extension %q", ext)
+		}
+
+		if !strings.Contains(command, "{file}") {
+			return nil, fmt.Errorf("command for extension %q has no {file}", ext)
+		}
+
+		out["."+ext] = args
+	}
+
+	return out, nil
+} // }}}
+
+// func sameCommands {{{
+
+func sameCommands(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for ext, args := range a {
+		bargs, ok := b[ext]
+		if !ok || len(args) != len(bargs) {
+			return false
+		}
+
+		for i := range args {
+			if args[i] != bargs[i] {
+				return false
+			}
+		}
+	}
+
+	return true
+} // }}}
+
+// func checkRun.command {{{
+
+// Returns the command and its arguments to decode the file with, or nil if the base has none for it.
+func (cr *checkRun) command(file string) []string {
+	if cr.cb == nil || cr.cb.Commands == nil {
+		return nil
+	}
+
+	return cr.cb.Commands[strings.ToLower(filepath.Ext(file))]
+} // }}}
+
+// func ImageProc.runCommand {{{
+
+// Runs the command to decode the file at path, returning what it wrote to stdout.
+//
+// Also returns anything the command wrote to stderr when it succeeded, which for most decoders are warnings.
+func (ip *ImageProc) runCommand(ctx context.Context, args []string, path string) ([]byte, string, error) {
+	ctx, can := context.WithTimeout(ctx, commandTimeout)
+	defer can()
+
+	cargs := make([]string, len(args))
+	for i, arg := range args {
+		cargs[i] = strings.ReplaceAll(arg, "{file}", path)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, cargs[0], cargs[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	// Whatever the command had to say is generally more useful then the exit status.
+	msg := strings.TrimSpace(stderr.String())
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s: %s", cargs[0], err, msg)
+	}
+
+	if stdout.Len() == 0 {
+		return nil, "", fmt.Errorf("%s: no output", cargs[0])
+	}
+
+	return stdout.Bytes(), msg, nil
+} // }}}
+
+// func sameExts {{{
+
+func sameExts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for ext, ft := range a {
+		if bft, ok := b[ext]; !ok || bft != ft {
+			return false
+		}
+	}
+
+	return true
+} // }}}
+
+// The bracketed tag list enabled by filenamebrackets, such as "IMG_1234 [beach,family].jpg"
+var bracketTags = regexp.MustCompile(`\[([^\[\]]*)\]`)
+
+// func parseNameTags {{{
+
+// Compiles the file name tag patterns of a base, nil if it has none.
+func parseNameTags(brackets bool, patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+
+	if brackets {
+		out = append(out, bracketTags)
+	}
+
+	for _, pat := range patterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, fmt.Errorf("filenametags(%s): %w", pat, err)
+		}
+
+		// Without a capture group there is nothing to tag.
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("filenametags(%s): no capture group", pat)
+		}
+
+		out = append(out, re)
+	}
+
+	return out, nil
+} // }}}
+
+// func sameNameTags {{{
+
+func sameNameTags(a, b []*regexp.Regexp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+
+	return true
+} // }}}
+
+// func filenameTags {{{
+
+// Returns the tag names found in the file name by the patterns.
+//
+// The extension is removed first, then every match of each pattern is used. Each capture group that matched is
+// split on commas, with every non-empty part being a tag.
+func filenameTags(name string, res []*regexp.Regexp) []string {
+	var out []string
+
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	for _, re := range res {
+		for _, match := range re.FindAllStringSubmatch(name, -1) {
+			// The first is the whole match, we only want the captures.
+			for _, capture := range match[1:] {
+				for _, tag := range strings.Split(capture, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						out = append(out, tag)
+					}
+				}
+			}
+		}
+	}
+
+	return out
+} // }}}
+
+// func ImageProc.nameTags {{{
+
+// Returns the tags from the file name, if the base tags from file names.
+func (ip *ImageProc) nameTags(cr *checkRun, name string) tags.Tags {
+	fl := ip.l.With().Str("func", "nameTags").Int("base", cr.bc.Base).Str("file", name).Logger()
+
+	if cr.cb == nil || len(cr.cb.NameTags) == 0 {
+		return nil
+	}
+
+	strs := filenameTags(name, cr.cb.NameTags)
+	if len(strs) == 0 {
+		return nil
+	}
+
+	tgs, err := tags.StringsToTags(strs, ip.tm)
+	if err != nil {
+		// Still have the path and sidecar tags, so just carry on without these.
+		fl.Warn().Err(err).Strs("tags", strs).Msg("StringsToTags")
+		return nil
+	}
+
+	return tgs
+} // }}}
+
+// func checkRun.exts {{{
+
+// The extensions for the base being checked, nil (the defaults) if it has none.
+func (cr *checkRun) exts() map[string]int {
+	if cr.cb == nil {
+		return nil
+	}
+
+	return cr.cb.Exts
+} // }}}
+
+// func checkRun.hashPixels {{{
+
+// Returns true if the file (fi from its Stat(), nil if that failed) should be identified by its pixels, see
+// confBaseYAML.HashPixels.
+func (cr *checkRun) hashPixels(fi os.FileInfo) bool {
+	if cr.cb == nil || !cr.cb.HashPixels {
+		return false
+	}
+
+	if cr.cb.HashPixelsMin == 0 {
+		return true
+	}
+
+	return fi != nil && fi.Size() >= cr.cb.HashPixelsMin
+} // }}}
+
+// func nextLoop {{{
+
+// Just picks the next loop number to use, some random number range I picked.
+// The number doesn't really matter, just want to avoid small numbers and wrap around to small numbers to keep some sanity.
+func nextLoop(old uint32) uint32 {
+	if old < 10 || old > 50000000 {
+		return 10
+	}
+
+	return old + 1
+} // }}}
+
+// func New {{{
+
+// Creates a new ImageProc.
+//
+// Checks the configuration, database and loads the cache but does not do any actual processing until Start() is called.
+func New(confPath string, tm types.TagManager, cma types.CacheManager, l *zerolog.Logger, ctx context.Context) (*ImageProc, error) {
+	ip := &ImageProc{
+		l:     l.With().Str("mod", "imgproc").Logger(),
+		tm:    tm,
+		cma:   cma,
+		ctx:   ctx,
+		cPath: confPath,
+	}
+
+	fl := ip.l.With().Str("func", "New").Logger()
+
+	features.Log(&fl, "imgproc")
+
+	ip.db = pgdb.New(&ip.l, ctx)
+
+	errs, err := errlog.New("imgproc", time.Minute, &ip.l, ctx)
+	if err != nil {
+		fl.Err(err).Msg("errlog.New")
+		return nil, err
+	}
+
+	ip.errs = errs
+
+	// Set an empty cache.
+	ip.ca = &cache{
+		bases: make(map[int]*baseCache, 1),
+	}
+
+	// Load our configuration.
+	//
+	// This also ensures that ip.getConf() can not fail.
+	//
+	// This also loads the initial cache from the database.
+	if err := ip.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// Our checks are run by this, needs to exist before the configuration can change.
+	ip.sch = scheduler.New(ip.ctx)
+
+	// All seems well, so lets start the real work before we return.
+	//
+	// Start background processing to watch configuration for changes.
+	ip.yc.Start()
+
+	// For the first run at startup always force a full check on all paths.
+	//
+	// This avoids problems where we were in the middle of a run and we got interupted.
+	//
+	// This can cause some paths to be in the database but not others, leaving to the possibility of orphaned paths
+	// just not being checked if a full wasn't forced.
+	//
+	// If the last run was interrupted part way through a full scan, this first one is allowed to resume it.
+	for _, bc := range ip.ca.bases {
+		bc.force = true
+		bc.resume = true
+	}
+
+	// When using workers, find out which bases are ours before checking any.
+	ip.syncWorkers(false)
+
+	// Start the first check()
+	ip.checkAll()
+
+	// Background maintenance
+	ip.scheduleBases(ip.getConf())
+	ip.scheduleWorkers(ip.getConf())
+
+	go func() {
+		<-ip.ctx.Done()
+		ip.close()
+	}()
+
+	fl.Debug().Send()
+
+	return ip, nil
+} // }}}
+
+// func ImageProc.dbConnect {{{
+
+func (ip *ImageProc) dbConnect(co *conf) error {
+	return ip.dbConnectTo(ip.db, co.Database, co.Queries)
+} // }}}
+
+// func ImageProc.dbConnectTo {{{
+
+// Connects d to the database, preparing the queries.
+func (ip *ImageProc) dbConnectTo(d *pgdb.DB, database string, qu *confQueries) error {
+	return d.Connect(&pgdb.Config{
+		Database: database,
+		Setup:    []string{"SET TIMEZONE TO UTC"},
+		Statements: []pgdb.Statement{
+			{Name: "paths-select", Query: qu.PathsSelect},
+			{Name: "paths-insert", Query: qu.PathsInsert},
+			{Name: "paths-update", Query: qu.PathsUpdate},
+			{Name: "paths-disable", Query: qu.PathsDisable},
+			{Name: "files-select", Query: qu.FilesSelect},
+			{Name: "files-insert", Query: qu.FilesInsert},
+			{Name: "files-update", Query: qu.FilesUpdate},
+			{Name: "files-disable", Query: qu.FilesDisable},
+
+			// Optional, skipped if empty.
+			{Name: "runs-insert", Query: qu.RunsInsert},
+			{Name: "changes-insert", Query: qu.ChangesInsert},
+			{Name: "checkpoint-select", Query: qu.CheckpointSelect},
+			{Name: "checkpoint-update", Query: qu.CheckpointUpdate},
+			{Name: "workers-register", Query: qu.WorkersRegister},
+			{Name: "workers-assigned", Query: qu.WorkersAssigned},
+			{Name: "workers-live", Query: qu.WorkersLive},
+			{Name: "workers-current", Query: qu.WorkersCurrent},
+			{Name: "workers-assign", Query: qu.WorkersAssign},
+		},
+	})
+} // }}}
+
+// func ImageProc.connectBases {{{
+
+// Connects the database for every base that has its own, see conf.baseTarget().
+//
+// Only bases whose database or queries changed are connected again, and any base no longer having its own goes
+// back to using the global database. Returns true if any base changed database, meaning the cache needs to be
+// loaded again.
+func (ip *ImageProc) connectBases(co *conf) (bool, error) {
+	var changed bool
+
+	fl := ip.l.With().Str("func", "connectBases").Logger()
+
+	ip.dbMut.Lock()
+	defer ip.dbMut.Unlock()
+
+	if ip.baseDBs == nil {
+		ip.baseDBs = make(map[int]*baseDB)
+	}
+
+	for id, cb := range co.Bases {
+		old, hasOld := ip.baseDBs[id]
+
+		// Using the global database?
+		if cb.Database == "" && cb.Queries == nil {
+			if hasOld {
+				fl.Info().Int("base", id).Msg("using global database")
+				old.db.Close()
+				delete(ip.baseDBs, id)
+				changed = true
+			}
+
+			continue
+		}
+
+		database, qu := co.baseTarget(cb)
+
+		// Nothing changed?
+		if hasOld && old.database == database && old.queries == qu {
+			continue
+		}
+
+		bdb := &baseDB{
+			db:       pgdb.New(&ip.l, ip.ctx),
+			database: database,
+			queries:  qu,
+		}
+
+		if err := ip.dbConnectTo(bdb.db, database, &qu); err != nil {
+			fl.Err(err).Int("base", id).Msg("dbConnectTo")
+			bdb.db.Close()
+			return changed, err
+		}
+
+		if hasOld {
+			old.db.Close()
+		}
+
+		fl.Info().Int("base", id).Msg("connected base database")
+
+		ip.baseDBs[id] = bdb
+		changed = true
+	}
+
+	// Bases that were removed entirely.
+	for id, old := range ip.baseDBs {
+		if _, ok := co.Bases[id]; !ok {
+			old.db.Close()
+			delete(ip.baseDBs, id)
+		}
+	}
+
+	return changed, nil
+} // }}}
+
+// func ImageProc.baseDB {{{
+
+// Returns the database pool for the base, either its own or the global one.
+func (ip *ImageProc) baseDB(base int) (*pgxpool.Pool, error) {
+	ip.dbMut.Lock()
+	bdb, ok := ip.baseDBs[base]
+	ip.dbMut.Unlock()
+
+	if ok {
+		return bdb.db.Get()
+	}
+
+	return ip.db.Get()
+} // }}}
+
+// func ImageProc.loadTagFile {{{
+
+// Loads the tags from the provided tag file.
+//
+// On success it returns the new tags and no error.
+//
+// On failure returns no tags and an error.
+func (ip *ImageProc) loadTagFile(cr *checkRun, pc *pathCache, file, image string, modTime time.Time) error {
+	name := fsJoin(pc.Path, file)
+
+	fl := ip.l.With().Str("func", "loadTagFile").Int("base", cr.bc.Base).Str("file", name).Logger()
+
+	var newTags tags.Tags
+
+	// Get the fileCache first, also avoids reading sidecars for files that don't exist.
+	fc, err := ip.getFileCache(cr, pc, image, emptyTime)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		fl.Err(err).Send()
+		return err
+	}
+
+	// Update our loop
+	fc.loopS = pc.loop
+
+	// Did the time on the sidecar change?
+	ptime := modTime.UTC().Round(time.Second)
+	if ptime.Equal(fc.SideTS) {
+		// Time is the same, so nothing more to do.
+		return nil
+	}
+
+	fl.Info().Msg("Time changed")
+	fc.SideTS = ptime
+	pc.updated |= upPathFI
+	fc.updated |= upSideTS
+
+	// Load the tags from the tagfile.
+	newTags, err = tags.LoadTagFile(cr.bc.bfs, name, ip.tm)
+
+	// Did the tags change?
+	if !fc.SideTG.Equal(newTags) {
+		fc.SideTG = newTags
+		pc.updated |= upPathFI
+		fc.updated |= upSideTG
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.getFileCache {{{
+
+func (ip *ImageProc) getFileCache(cr *checkRun, pc *pathCache, file string, modTime time.Time) (*fileCache, error) {
+	name := fsJoin(pc.Path, file)
+
+	fl := ip.l.With().Str("func", "getFileCache").Int("base", cr.bc.Base).Str("file", name).Logger()
+
+	// Get the file cache.
+	fc, ok := pc.Files[file]
+	if !ok {
+		// Doesn't exist yet, so go ahead and create it.
+		//
+		// Note we don't update any bit flags or set the paths UpPathFI here, as
+		// if emptyTime is passed in we are being called from a sidecar.
+		//
+		// We only update those when we have a time of the file itself and not a sidecar.
+		fl.Debug().Msg("Created")
+		fc = &fileCache{
+			Name: file,
+		}
+		pc.Files[file] = fc
+	}
+
+	// If the file was seen this loop already then skip the below checks.
+	if fc.loopF == pc.loop {
+		return fc, nil
+	}
+
+	// Time provided?
+	if modTime == emptyTime {
+		// Nope, so just skip the rest of the checks below.
+		//
+		// We do not update the loop though, as since we don't have the modTime, we haven't actually seen
+		// the file itself (could be a sidecar we were called from).
+		return fc, nil
+	}
+
+	// Update the loop this was seen on
+	fc.loopF = pc.loop
+
+	// Seen again, so clear any grace period.
+	fc.missed = 0
+	fc.missedSince = emptyTime
+
+	// Update the last modified time?
+	ptime := modTime.UTC().Round(time.Second)
+	if ptime.Equal(fc.FileTS) {
+		return fc, nil
+	}
+
+	fl.Info().Msg("Time changed")
+	fc.FileTS = ptime
+	fc.updated |= upFileTS
+	pc.updated |= upPathFI
+
+	// The timestamp of the file changed.
+	//
+	// If it was an error, this can mean someone fixed the problem, so go ahead and clear the error.
+	if fc.fileError {
+		fc.fileError = false
+	}
+
+	return fc, nil
+} // }}}
+
+// func ImageProc.getPathCache {{{
+
+func (ip *ImageProc) getPathCache(cr *checkRun, path string, inheritTags tags.Tags) (*pathCache, error) {
+	fl := ip.l.With().Str("func", "getPathCache").Int("base", cr.bc.Base).Str("path", path).Logger()
+
+	var inherit bool
+	var pathTF string
+
+	// We can only inherit if we have inheritTags to inherit from.
+	//
+	// These are not set when being called during a partial path check
+	// or when called for the root (".") path.
+	if inheritTags != nil {
+		inherit = true
+	}
+
+	// Get the path cache.
+	pc, ok := cr.bc.Paths[path]
+	if !ok {
+		fl.Debug().Msg("Created")
+		pc = &pathCache{
+			Path:    path,
+			Tags:    inheritTags,
+			Files:   make(map[string]*fileCache, 1),
+			updated: upPathTG,
+		}
+		cr.bc.Paths[path] = pc
+	}
+
+	// Update the loop
+	pc.loop = cr.bc.loop
+
+	// Seen again, so clear any grace period.
+	pc.missed = 0
+	pc.missedSince = emptyTime
+
+	// We need the timestamp for our path first.
+	file, err := cr.bc.bfs.Open(path)
+	if err != nil {
+		fl.Err(err).Msg("open")
+		return nil, fmt.Errorf("open(%s): %w", path, err)
+	}
+
+	fstat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		fl.Err(err).Msg("stat")
+		return nil, fmt.Errorf("stat(%s): %w", path, err)
+	}
+
+	file.Close()
+
+	// Update the last modified time?
+	//
+	// Note that we round the ModTime() here to the millisecond, as I found that PostgreSQL does its own rounding of the number.
+	// This would cause the value we INSERT to be different in the SELECT, and thus cause the times to never match properly.
+	ptime := fstat.ModTime().UTC().Round(time.Second)
+	if !ptime.Equal(pc.Changed) {
+		fl.Info().Msg("Time changed")
+		pc.Changed = ptime
+		pc.updated |= upPathTS
+	}
+
+	// If we are the root path then its just the tagfile name.
+	// Otherwise we add the "path/" before the tagfile.
+	pathTF = fsJoin(path, cr.bc.tagFile)
+
+	// This path have a tag file in it?
+	tf, err := cr.bc.bfs.Open(pathTF)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fl.Err(err).Str("tagfile", pathTF).Msg("tfOpen")
+		return nil, err
+	}
+
+	// The error can still be ErrNotExist(), so just ensure we have
+	// no error before we go further along with the tag file.
+	if err == nil {
+		tfStat, err := tf.Stat()
+		if err != nil {
+			tf.Close()
+			fl.Err(err).Msg("tfstat")
+			return nil, fmt.Errorf("tfstat(%s): %w", path, err)
+		}
+
+		tfMTime := tfStat.ModTime().UTC().Round(time.Second)
+
+		if !tfMTime.Equal(pc.SideTS) {
+			// Load the tag file here!
+			tags, err := tags.LoadTagFile(cr.bc.bfs, pathTF, ip.tm)
+			if err != nil {
+				fl.Err(err).Msg("LoadTagFile")
+				return nil, err
+			}
+
+			fl.Info().Msg("TagFile changed")
+			pc.updated |= upPathTG
+			pc.SideTS = tfMTime
+
+			pc.Tags = tags
+		}
+
+		tf.Close()
+
+		// We got the tags from the file, so no inherit from our parent path.
+		inherit = false
+	}
+
+	// Do we inherit our parents tags?
+	if inherit {
+		// This should only happen when the base path "." itself has no tags.
+		if inheritTags == nil {
+			fl.Err(noTagsPath).Msg("inheritTags")
+			return nil, noTagsPath
+		}
+
+		// Did the tags change?
+		if !inheritTags.Equal(pc.Tags) {
+			fl.Info().Msg("Tags changed")
+			pc.updated |= upPathTG
+			pc.Tags = inheritTags
+		}
+	}
+
+	if pc.Tags == nil || len(pc.Tags) == 0 {
+		fl.Err(noTagsPath).Msg("base")
+		return nil, noTagsPath
+	}
+
+	fl.Debug().Send()
+
+	return pc, nil
+} // }}}
+
+// func ImageProc.checkPathPartial {{{
+
+func (ip *ImageProc) checkPathPartial(cr *checkRun, path string) error {
+	fl := ip.l.With().Str("func", "checkPathPartial").Int("base", cr.bc.Base).Str("path", path).Logger()
+
+	// We were given a path to check if it was modifed in any way to decide if we call checkBasePath()
+	// or not on it.
+	//
+	// Now lets see if the path has been modified or not.
+	pc, err := ip.getPathCache(cr, path, nil)
+	if err != nil {
+		fl.Err(err).Msg("getPathCache")
+		return err
+	}
+
+	// Did anything in the path change?
+	//
+	// Or has a file within been deleted without changing the path, see sampleTombstones(), or one missing come back,
+	// see missedReturned().
+	if pc.updated&(upPathTG|upPathTS) == 0 && !cr.tombstones[path] && !missedReturned(cr.bc.bfs, pc) {
+		// path has not changed.
+		//
+		// We assume all the files in this path in cache are still there and exactly the same.
+		//
+		// Any change to a file would also touch the directory modified time.
+		//
+		// Loop through all the files and update the loop so they don't disappear.
+		//
+		// Any new paths seen will be handled by checkBasePath() being recursive for new paths.
+		//
+		// NOTE: While this method is valid for all file systems I have tested, being that fs.FS is new it is possible some new type of
+		// fs.FS might not properly set the paths modified time when a file has been modified. If this actually happens then we need some
+		// way to force a full every loop for that FS type.
+		//
+		// However, be that has not happened yet, this is just a note how to handle something that hopefuly never happens in general.
+		for _, file := range pc.Files {
+			// A file already missing (within the disable grace period) is still missing, missedReturned() found none
+			// of them back.
+			//
+			// Flag the path so the grace period is still checked in the database update.
+			if file.missed > 0 {
+				pc.updated |= upPathFI
+				continue
+			}
+
+			file.loopF = pc.loop
+
+			// Does this file also have a sidecar?
+			if !file.SideTS.Equal(emptyTime) {
+				// Yep, so go ahead and set that loop as well.
+				file.loopS = pc.loop
+			}
+		}
+
+		fl.Debug().Msg("unchanged")
+		return nil
+	}
+
+	// The path changed, so hand off to checkBasePath()
+	return ip.checkBasePath(cr, pc, path, false)
+} // }}}
+
+// func ImageProc.checkBasePath {{{
+
+func (ip *ImageProc) checkBasePath(cr *checkRun, pc *pathCache, path string, full bool) error {
+	fl := ip.l.With().Str("func", "checkBasePath").Int("base", cr.bc.Base).Str("path", path).Logger()
+	fl.Debug().Send()
+
+	_, span := tracer.Start(cr.ctx, "path", trace.WithAttributes(attribute.String("path", path), attribute.Bool("full", full)))
+	defer span.End()
+
+	// The start of a walk, so the path is the first of the directories being walked, see walkDir().
+	if cr.walk == nil {
+		if err := ip.walkStart(cr, path); err != nil {
+			tracing.Fail(span, err)
+			return err
+		}
+
+		defer func() { cr.walk = nil }()
+	}
+
+	// Lets get all the files within this path.
+	files, err := fs.ReadDir(cr.bc.bfs, path)
+	if err != nil {
+		fl.Err(err).Msg("ReadDir")
+		tracing.Fail(span, err)
+		return err
+	}
+
+	for _, file := range files {
+		// Get the new path name
+		npath := fsJoin(path, file.Name())
+
+		isDir := file.IsDir()
+
+		// Symbolic links to directories are only walked if the base allows it.
+		//
+		// Anything else (including links that go nowhere) is treated as a file, same as if not following them.
+		if !isDir && file.Type()&fs.ModeSymlink != 0 && cr.cb != nil && cr.cb.FollowSymlinks {
+			if info, err := fs.Stat(cr.bc.bfs, npath); err == nil && info.IsDir() {
+				isDir = true
+			}
+		}
+
+		// Directory?
+		if isDir {
+
+			// Resuming an interrupted full scan, and this path was already completed by it?
+			if full && cr.resume != "" && scanDone(npath, cr.resume) && ip.markSeen(cr, npath) {
+				continue
+			}
+
+			// Is this a partial?
+			if !full {
+				// Is the path in the cache?
+				if _, ok := cr.bc.Paths[npath]; ok {
+					// Its a known path, which means our caller will handle it directly.
+					continue
+				}
+			}
+
+			// Are we allowed to walk it?
+			info, err := ip.walkDir(cr, npath)
+			if err != nil {
+				return err
+			}
+
+			if info == nil {
+				continue
+			}
+
+			// Either a full, or not in the cache.
+			npc, err := ip.getPathCache(cr, npath, pc.Tags)
+			if err != nil {
+				return err
+			}
+
+			cr.walk = append(cr.walk, info)
+			err = ip.checkBasePath(cr, npc, npath, full)
+			cr.walk = cr.walk[:len(cr.walk)-1]
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		nfl := fl.With().Str("file", file.Name()).Logger()
+
+		// Is this a file we care about?
+		ft, iname := getFileType(file.Name(), cr.exts())
+
+		// RAW files are only images if the base wants them.
+		//
+		// If not they are simply skipped, which also means any RAW file previously seen will be disabled.
+		if ft == 3 {
+			if cr.cb == nil || !cr.cb.EnableRaw {
+				continue
+			}
+
+			ft = 1
+		}
+
+		switch ft {
+		case 0:
+			continue
+		case 1:
+			// Load the file info to pass to getFileCache, so it doesn't have to do a Stat() call.
+			info, err := file.Info()
+			if err != nil {
+				nfl.Err(err).Msg("file.Info")
+				return err
+			}
+
+			// Everything we need to do is handled by requesting the file cache.
+			//
+			// Hashing and sizing happens in the next phase of check()
+			if _, err := ip.getFileCache(cr, pc, file.Name(), info.ModTime()); err != nil {
+				nfl.Err(err).Send()
+				return err
+			}
+		case 2:
+			// Load the file info to pass to loadTagFile, so it doesn't have to do a Stat() call.
+			info, err := file.Info()
+			if err != nil {
+				nfl.Err(err).Msg("file.Info")
+				return err
+			}
+
+			// Load the tags
+			if err := ip.loadTagFile(cr, pc, file.Name(), iname, info.ModTime()); err != nil {
+				nfl.Err(err).Send()
+				return err
+			}
+		default:
+			nfl.Warn().Str("image", iname).Int("type", ft).Msg("Unsupported filetype")
+			continue
+		}
+	}
+
+	// Files we have never seen before?
+	var fresh []*fileCache
+	if cr.fastLane {
+		fresh = newFiles(pc, cr.bc.loop)
+	}
+
+	// Checkpointing this scan?
+	//
+	// If so then this path and everything below it is done, so commit it to the database now rather then waiting
+	// for the entire base to be walked, and then save the checkpoint.
+	//
+	// The root path is left for checkHashTagsDB(), as its the last one done anyways and its the one that would be
+	// empty if the base went offline, see isStale().
+	if cr.checkpoint && path != "." {
+		if err := ip.checkPathHashTagsDB(cr, pc); err != nil {
+			return err
+		}
+
+		if err := ip.saveCheckpoint(cr, path); err != nil {
+			fl.Err(err).Msg("saveCheckpoint")
+			return err
+		}
+	} else if len(fresh) > 0 {
+		// Same for the fast lane, a path with new files can not have gone offline.
+		if err := ip.checkPathHashTagsDB(cr, pc); err != nil {
+			return err
+		}
+	}
+
+	if len(fresh) > 0 {
+		ip.fastLane(cr, fresh)
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.walkStart {{{
+
+// Starts a walk of the base at path, see walkDir().
+func (ip *ImageProc) walkStart(cr *checkRun, path string) error {
+	fl := ip.l.With().Str("func", "walkStart").Int("base", cr.bc.Base).Str("path", path).Logger()
+
+	// The device of the base itself only needs to be found once per run.
+	if !cr.devOK {
+		info, err := os.Stat(cr.bc.path)
+		if err != nil {
+			fl.Err(err).Msg("Stat")
+			return err
+		}
+
+		cr.dev, cr.devOK = device(info)
+	}
+
+	info, err := os.Stat(filepath.Join(cr.bc.path, filepath.FromSlash(path)))
+	if err != nil {
+		fl.Err(err).Msg("Stat")
+		return err
+	}
+
+	cr.walk = []fs.FileInfo{info}
+
+	return nil
+} // }}}
+
+// func ImageProc.walkDir {{{
+
+// Returns the info of the directory npath if it should be walked, or nil if not.
+//
+// Applies the walk policy of the base (see confBaseYAML.FollowSymlinks), and skips any directory already being
+// walked, which could only be reached again through a loop of symbolic links or bind mounts.
+func (ip *ImageProc) walkDir(cr *checkRun, npath string) (fs.FileInfo, error) {
+	fl := ip.l.With().Str("func", "walkDir").Int("base", cr.bc.Base).Str("path", npath).Logger()
+
+	cb := cr.cb
+
+	if cb != nil && cb.MaxDepth > 0 && strings.Count(npath, "/")+1 > cb.MaxDepth {
+		fl.Debug().Int("maxdepth", cb.MaxDepth).Msg("too deep")
+		return nil, nil
+	}
+
+	info, err := os.Stat(filepath.Join(cr.bc.path, filepath.FromSlash(npath)))
+	if err != nil {
+		fl.Err(err).Msg("Stat")
+		return nil, err
+	}
+
+	if cb != nil && cb.OneFilesystem && cr.devOK {
+		if dev, ok := device(info); ok && dev != cr.dev {
+			fl.Debug().Msg("other filesystem")
+			return nil, nil
+		}
+	}
+
+	for _, wi := range cr.walk {
+		if os.SameFile(wi, info) {
+			fl.Warn().Msg("directory loop, skipping")
+			return nil, nil
+		}
+	}
+
+	return info, nil
+} // }}}
+
+// func scanDone {{{
+
+// Returns true if path was already completed in a full scan that last completed the path last.
+//
+// A full scan walks the paths depth first in sorted order (fs.ReadDir() sorts), with a path
+// only completed after everything below it is. So a path is done if it is below last, or
+// if it sorts before last where they first differ.
+//
+// Parents of last are never done, as they complete after last.
+func scanDone(path, last string) bool {
+	if last == "" || path == "." {
+		return false
+	}
+
+	if last == "." {
+		return true
+	}
+
+	pa := strings.Split(path, "/")
+	la := strings.Split(last, "/")
+
+	for i := 0; i < len(pa) && i < len(la); i++ {
+		if pa[i] != la[i] {
+			return pa[i] < la[i]
+		}
+	}
+
+	// One is within the other, so done only if path is last or below it.
+	return len(pa) >= len(la)
+} // }}}
+
+// func ImageProc.markSeen {{{
+
+// Marks the cached path and everything below it as seen this loop, without touching the file system.
+//
+// Used when resuming an interrupted full scan for paths that were already completed.
+//
+// Returns false if the path is not in the cache or a missing file within has come back (see missedReturned()), in
+// which case the caller needs to walk it anyways.
+func (ip *ImageProc) markSeen(cr *checkRun, path string) bool {
+	if _, ok := cr.bc.Paths[path]; !ok {
+		return false
+	}
+
+	prefix := path + "/"
+
+	var within []*pathCache
+
+	for name, pc := range cr.bc.Paths {
+		if name != path && !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if missedReturned(cr.bc.bfs, pc) {
+			return false
+		}
+
+		within = append(within, pc)
+	}
+
+	for _, pc := range within {
+		markPath(cr, pc)
+	}
+
+	return true
+} // }}}
+
+// func missedReturned {{{
+
+// Returns true if any file of the path that is missing (within the disable grace period) exists again.
+//
+// Not every file system changes the modified time of the directory when a file is added back, so without this a file
+// that returns is never seen again by a partial and ends up disabled.
+func missedReturned(bfs fs.FS, pc *pathCache) bool {
+	for name, fc := range pc.Files {
+		if fc.missed == 0 {
+			continue
+		}
+
+		if _, err := fs.Stat(bfs, fsJoin(pc.Path, name)); err == nil {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func markPath {{{
+
+// Marks the cached path and the files within as seen this loop, without touching the file system.
+func markPath(cr *checkRun, pc *pathCache) {
+	loop := cr.bc.loop
+
+	pc.loop = loop
+
+	for _, fc := range pc.Files {
+		// Still missing, see checkPathPartial().
+		if fc.missed > 0 {
+			pc.updated |= upPathFI
+			continue
+		}
+
+		fc.loopF = loop
+
+		if !fc.SideTS.Equal(emptyTime) {
+			fc.loopS = loop
+		}
+	}
+} // }}}
+
+// func ImageProc.loadCheckpoint {{{
+
+// Returns the last completed path and loop of an interrupted full scan for the base, if there is one.
+func (ip *ImageProc) loadCheckpoint(cr *checkRun) (string, uint32, error) {
+	var path string
+	var loop uint32
+
+	db, err := ip.baseDB(cr.bc.Base)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := db.QueryRow(ip.ctx, "checkpoint-select", cr.bc.Base).Scan(&loop, &path); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, nil
+		}
+
+		return "", 0, err
+	}
+
+	return path, loop, nil
+} // }}}
+
+// func ImageProc.saveCheckpoint {{{
+
+// Saves the last completed path of a full scan.
+//
+// An empty path clears the checkpoint, which happens when the scan finishes.
+func (ip *ImageProc) saveCheckpoint(cr *checkRun, path string) error {
+	db, err := ip.baseDB(cr.bc.Base)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(ip.ctx, "checkpoint-update", cr.bc.Base, cr.bc.loop, path); err != nil {
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.checkHashTagsDB {{{
+
+// This calculates the file hash, creates the file in the hash path, and calculates the tags.
+func (ip *ImageProc) checkHashTagsDB(cr *checkRun) error {
+	fl := ip.l.With().Str("func", "checkHashTags").Int("base", cr.bc.Base).Logger()
+
+	loop := cr.bc.loop
+
+	// Run through the paths in the base
+	for _, pc := range cr.bc.Paths {
+		// First, if the path itself wasn't seen, no need to check the files - They were all basically removed.
+		//
+		// We don't delete the path here, that happens in cleanCache().
+		if pc.loop != loop {
+			pc.updated |= upPathNL
+
+			// Count this loop towards the disable grace period for the path and all files within.
+			if pc.missed == 0 {
+				pc.missedSince = time.Now()
+			}
+
+			pc.missed++
+
+			for _, fc := range pc.Files {
+				countMissed(fc, loop)
+			}
+
+			// Ensure the database removes the path (and files) properly.
+			if err := ip.updateDBPF(cr, pc); err != nil {
+				fl.Err(err).Msg("updateDBPF")
+				return err
+			}
+
+			fl.Info().Str("path", pc.Path).Msg("path removed - skipped")
+			continue
+		}
+
+		if err := ip.checkPathHashTagsDB(cr, pc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.checkPathHashTagsDB {{{
+
+// Same as checkHashTagsDB(), but for just a single path that was seen this loop.
+//
+// Also called directly by checkBasePath() when checkpointing a full scan, so each path is committed as soon as we are done with it.
+func (ip *ImageProc) checkPathHashTagsDB(cr *checkRun, pc *pathCache) error {
+	var pathTags bool
+
+	fl := ip.l.With().Str("func", "checkPathHashTagsDB").Int("base", cr.bc.Base).Str("path", pc.Path).Logger()
+
+	loop := cr.bc.loop
+
+	if pc.updated&upPathTG != 0 {
+		pathTags = true
+	} else {
+		pathTags = false
+	}
+
+	// Run through the files
+	for _, fc := range pc.Files {
+		// If this file wasn't seen this loop, then skip it - Needs to be removed.
+		if fc.loopF != loop {
+			// Only files in the database need to be disabled.
+			if fc.id != 0 && !fc.disabled {
+				countMissed(fc, loop)
+				pc.updated |= upPathFI
+			}
+
+			fl.Debug().Str("file", fc.Name).Msg("removed - skipped")
+			continue
+		}
+
+		// Any tags change?
+		//
+		// Or, does the file itself not have any tags at all?
+		if pathTags || cr.bc.retag || fc.updated&upSideTG != 0 || len(fc.CTags) == 0 {
+			// Lets calculate the new tags.
+			nTags := tags.Tags{}
+			nTags = nTags.Combine(pc.Tags)
+			nTags = nTags.Combine(fc.SideTG)
+			nTags = nTags.Combine(ip.nameTags(cr, fc.Name))
+			nTags = nTags.Combine(cr.bc.manifestTags[fsJoin(pc.Path, fc.Name)])
+
+			// Now did they actually change?
+			if !nTags.Equal(fc.CTags) {
+				fl.Info().Str("file", fc.Name).Msg("Tags changed")
+				fc.CTags = nTags
+
+				// Set that the calculated tags updated
+				fc.updated |= upFileCT
+				pc.updated |= upPathFI
+			}
+		}
+
+		// If a file has no tags, we consider this to be an error.
+		// All files must have at least 1 tag to be useful at all to us.
+		//
+		// You can add default tags just be adding path tags or tags to the
+		// base itself, so this really just means a misconfiguration typically.
+		//
+		// We do not bother doing any update or further check on the file
+		// when its missing its tags.
+		if len(fc.CTags) == 0 {
+			fl.Warn().Str("file", fc.Name).Msg("Has no tags")
+			continue
+		}
+
+		// Did the file timestamp change?
+		// Or, is there no hash already?
+		// Or, is the CacheManager moving to a new hash and needs to see the file again?
+		if fc.updated&upFileTS != 0 || fc.ID == 0 || ip.needsRehash(fc.ID) {
+			if err := ip.setFileHash(cr, pc, fc); err != nil {
+
+				// We want to ensure one bad file can't crash the entire application, so we log the error here but otherwise we continue.
+				// The file itself as flagged as being in an error state.
+				//
+				// Should the timestamp on the file change the error state will be cleared.
+				fc.fileError = true
+				cr.run.Errors++
+				ip.errs.Err(&fl, "setFileHash", err)
+
+				// If in shutdown we need to return.
+				if err == types.ErrShutdown {
+					return err
+				}
+			}
+		}
+	}
+
+	// Now update the database.
+	if err := ip.updateDBPF(cr, pc); err != nil {
+		fl.Err(err).Msg("updateDBPF")
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func sampleTombstones {{{
+
+// Stats num files chosen at random from those in paths, returning the paths with any that no longer exist.
+//
+// Partial scans only look within paths whose modified time changed, but not every file system changes it when a file
+// is deleted. So without this a deleted file would remain until the next full scan.
+//
+// Files already missing are left out, they are already known about.
+func sampleTombstones(bfs fs.FS, paths map[string]*pathCache, num int, r *rand.Rand) map[string]bool {
+	type sample struct {
+		path string
+		name string
+	}
+
+	// Reservoir sampling, so we don't need a list of every file first.
+	picked := make([]sample, 0, num)
+	seen := 0
+
+	for path, pc := range paths {
+		for name, fc := range pc.Files {
+			if fc.missed > 0 {
+				continue
+			}
+
+			seen++
+
+			if len(picked) < num {
+				picked = append(picked, sample{path, name})
+			} else if i := r.Intn(seen); i < num {
+				picked[i] = sample{path, name}
+			}
+		}
+	}
+
+	var gone map[string]bool
+
+	for _, sa := range picked {
+		if _, err := fs.Stat(bfs, fsJoin(sa.path, sa.name)); errors.Is(err, fs.ErrNotExist) {
+			if gone == nil {
+				gone = make(map[string]bool)
+			}
+
+			gone[sa.path] = true
+		}
+	}
+
+	return gone
+} // }}}
+
+// func countMissed {{{
+
+// Counts a loop a file was not seen towards the disable grace period.
+//
+// Only counts once per loop, no matter how many times we are called.
+func countMissed(fc *fileCache, loop uint32) {
+	if fc.missedLoop == loop {
+		return
+	}
+
+	if fc.missed == 0 {
+		fc.missedSince = time.Now()
+	}
+
+	fc.missed++
+	fc.missedLoop = loop
+} // }}}
+
+// func graceOver {{{
+
+// Returns true if something unseen for missed loops since the provided time can be disabled.
+//
+// See confBaseYAML.DisableLoops for details.
+func graceOver(cb *confBase, missed uint32, since time.Time) bool {
+	if cb == nil {
+		return true
+	}
+
+	if cb.DisableLoops > 0 && missed < cb.DisableLoops {
+		return false
+	}
+
+	if cb.DisableAfter > 0 && (since.Equal(emptyTime) || time.Since(since) < cb.DisableAfter) {
+		return false
+	}
+
+	return true
+} // }}}
+
+// func fsJoin {{{
+
+// Joins a name onto a path within a base.
+//
+// Bases are read as an fs.FS, so the paths always use "/" whatever the OS, the same as they are stored in the
+// database. Never use filepath here.
+func fsJoin(dir, name string) string {
+	return pathpkg.Join(dir, name)
+} // }}}
+
+// func ImageProc.needsRehash {{{
+
+// Returns true if the CacheManager is moving to a new hash and wants the image hashed again, see
+// types.CacheRehasher.
+func (ip *ImageProc) needsRehash(id uint64) bool {
+	cr, ok := ip.cma.(types.CacheRehasher)
+
+	return ok && id != 0 && cr.NeedsRehash(id)
+} // }}}
+
+// func ImageProc.setFileHash {{{
+
+// This updates the file hash and creates the physical resized file if it doesn't already exist
+func (ip *ImageProc) setFileHash(cr *checkRun, pc *pathCache, fc *fileCache) error {
+	name := fsJoin(pc.Path, fc.Name)
+
+	fl := ip.l.With().Str("func", "setFileHash").Int("base", cr.bc.Base).Str("path", pc.Path).Str("file", fc.Name).Logger()
+
+	// Lets open the file for reading.
+	f, err := cr.bc.bfs.Open(name)
+	if err != nil {
+		ip.errs.Err(&fl, "open", err)
+		return err
+	}
+
+	defer f.Close()
+
+	// An empty file is commonly one still being copied, or one a failed copy left behind.
+	//
+	// Either way there is nothing to decode, and should it be written to later its timestamp changes and it is looked
+	// at again.
+	fi, err := f.Stat()
+	if err == nil && fi.Size() == 0 {
+		fl.Warn().Msg("empty file")
+		return errEmptyFile
+	}
+
+	// Libraries that hard link each photo into several albums would otherwise read the same content for every link.
+	//
+	// So the first link is hashed as normal, and every other link this run is given the same ID.
+	var ik inodeKey
+	var linked bool
+
+	if err == nil {
+		if ik, linked = inode(fi); linked {
+			ik.ext = strings.ToLower(pathpkg.Ext(fc.Name))
+
+			if seen, ok := cr.inodes[ik]; ok {
+				fl.Debug().Msg("hard link")
+				cr.run.Linked++
+				fc.fprint = seen.fprint
+				setFileID(pc, fc, seen.id)
+				return nil
+			}
+		}
+	}
+
+	// Fingerprint enabled for this base?
+	//
+	// If so calculate it first, and if it has not changed since the last full hash we can skip reading the entire file.
+	var fprint string
+	if cr.cb != nil && cr.cb.Fingerprint {
+		if fprint, err = fingerprint(f, cr.cb.FingerBytes); err != nil {
+			// Not fatal, we just fall back to the full hash.
+			fl.Warn().Err(err).Msg("fingerprint")
+			fprint = ""
+		}
+
+		if fprint != "" && fc.ID != 0 && fprint == fc.fprint && !ip.needsRehash(fc.ID) {
+			fl.Debug().Msg("fingerprint unchanged")
+			cr.linkSeen(ik, linked, fc.ID, fprint)
+			return nil
+		}
+	}
+
+	var r io.Reader = f
+
+	// Files we can not decode directly, either an external command decodes them or for RAW files we use the
+	// embedded preview.
+	//
+	// This means the hash is of the decoded image, not the file itself, which is fine as that is what we display.
+	if args := cr.command(fc.Name); args != nil {
+		out, warn, err := ip.runCommand(cr.ctx, args, filepath.Join(cr.bc.path, filepath.FromSlash(name)))
+		if err != nil {
+			ip.errs.Err(&fl, "command", err)
+			return err
+		}
+
+		// Succeeded, but did it complain? Most likely about a truncated or corrupt file it decoded anyways.
+		if warn != "" {
+			if cr.cb.StrictDecode {
+				err := fmt.Errorf("%s: %s", args[0], warn)
+				ip.errs.Err(&fl, "command", err)
+				return err
+			}
+
+			fl.Warn().Str("stderr", warn).Msg("command")
+		}
+
+		r = bytes.NewReader(out)
+	} else if ft, _ := getFileType(fc.Name, cr.exts()); ft == 3 {
+		preview, err := fimg.RawPreview(f)
+		if err != nil {
+			ip.errs.Err(&fl, "RawPreview", err)
+			return err
+		}
+
+		r = bytes.NewReader(preview)
+	}
+
+	// Get the ID for this image, cached the way the base is if the CacheManager can.
+	var id uint64
+	var ph types.CachePixelHasher
+
+	if cr.hashPixels(fi) {
+		var ok bool
+		if ph, ok = ip.cma.(types.CachePixelHasher); !ok {
+			fl.Warn().Msg("hashpixels not supported by the CacheManager")
+		}
+	}
+
+	if ph != nil {
+		id, err = ph.CacheImagePixelsBase(cr.bc.Base, r)
+	} else if cb, ok := ip.cma.(types.CacheBaser); ok {
+		id, err = cb.CacheImageRawBase(cr.bc.Base, r)
+	} else {
+		id, err = ip.cma.CacheImageRaw(r)
+	}
+
+	if err != nil {
+		ip.errs.Err(&fl, "CacheImageRaw", err)
+		return err
+	}
+
+	// Only save the fingerprint once the full hash is done, so a failed hash is tried again next time.
+	fc.fprint = fprint
+
+	cr.linkSeen(ik, linked, id, fprint)
+
+	setFileID(pc, fc, id)
+
+	return nil
+} // }}}
+
+// func setFileID {{{
+
+// Sets the ID of the file, flagging it for the database if it changed.
+func setFileID(pc *pathCache, fc *fileCache, id uint64) {
+	// Did the ID change?
+	if id == fc.ID {
+		// Nope, no change.
+		return
+	}
+
+	// Update to the new ID
+	fc.ID = id
+
+	// Set the bit so the database is properly changed.
+	fc.updated |= upFileHS
+	pc.updated |= upPathFI
+} // }}}
+
+// func checkRun.linkSeen {{{
+
+// Remembers the ID of a hard linked file for any other links to it this run.
+func (cr *checkRun) linkSeen(ik inodeKey, linked bool, id uint64, fprint string) {
+	if !linked {
+		return
+	}
+
+	if cr.inodes == nil {
+		cr.inodes = make(map[inodeKey]linkSeen)
+	}
+
+	cr.inodes[ik] = linkSeen{id: id, fprint: fprint}
+} // }}}
+
+// func fingerprint {{{
+
+// Returns a fingerprint of the file, being the size and the first and last n bytes.
+//
+// The file needs to support io.ReaderAt, which anything from os.DirFS() does.
+//
+// As ReadAt() does not change the offset of the file, the file can still be read from the start after this.
+func fingerprint(f fs.File, n int64) (string, error) {
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return "", errors.New("file does not support ReadAt")
+	}
+
+	fstat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	size := fstat.Size()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", size)
+
+	// Small file? Then just the whole thing.
+	if size <= n*2 {
+		if _, err := io.Copy(h, io.NewSectionReader(ra, 0, size)); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := io.Copy(h, io.NewSectionReader(ra, 0, n)); err != nil {
+			return "", err
+		}
+
+		if _, err := io.Copy(h, io.NewSectionReader(ra, size-n, n)); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+} // }}}
+
+// func ImageProc.checkBase {{{
+
+// If path is set only it and everything below it is checked, see ScanPath().
+//
+// TODO Need to check if the database has the base setup, otherwise it just errors.
+func (ip *ImageProc) checkBase(bc *baseCache, path string) {
+	fl := ip.l.With().Str("func", "checkBase").Int("base", bc.Base).Logger()
+	start := time.Now()
+
+	// We do not allow multiple instances of ourself to run.
+	//
+	// Main reason - Directory scanning time.
+	//
+	// Without this you can easily get into a situation where the scans just never end. One scan starts
+	// on a directory with 100k files, a 2nd starts and slows down the first, eventually a 3rd slows
+	// down the others, and its a never-ending cycle.
+	//
+	// Think of older rpi's and slower SD cards and one can easily see how this can be an issue even with a small number
+	// of files.
+	if !atomic.CompareAndSwapUint32(&bc.checkRun, 0, 1) {
+		fl.Info().Msg("check already running")
+		return
+	}
+
+	// Ensure we release the "lock" when finished.
+	defer atomic.StoreUint32(&bc.checkRun, 0)
+
+	// Another worker scans it, see confWorkersYAML.
+	if !ip.ownsBase(bc.Base) {
+		fl.Debug().Msg("assigned to another worker")
+		return
+	}
+
+	bc.bMut.Lock()
+	defer bc.bMut.Unlock()
+
+	// Increase our loop
+	bc.loop = nextLoop(bc.loop)
+
+	// We need the base configuration as well.
+	co := ip.getConf()
+
+	cr := &checkRun{
+		cb: co.Bases[bc.Base],
+		bc: bc,
+		run: &ScanRun{
+			Base:  bc.Base,
+			Start: start,
+			Path:  path,
+		},
+	}
+
+	cr.ctx, cr.span = tracer.Start(ip.ctx, "imgproc.checkBase", trace.WithAttributes(attribute.Int("base", bc.Base)))
+
+	// No matter how we return, record the run.
+	defer ip.finishRun(cr)
+
+	// Before the scan, so any change to the manifest is applied to every file.
+	ip.checkManifest(cr)
+
+	// Simple check - No '.' path in the cache forces a full.
+	//
+	// Also the first scan of the base, where every file is new so there is no point in the fast lane.
+	if _, ok := bc.Paths["."]; !ok {
+		bc.force = true
+	} else if cr.cb != nil && cr.cb.FastLane {
+		cr.fastLane = true
+	}
+
+	// Been too many partials?
+	if cr.cb != nil && cr.cb.FullEvery > 0 && bc.partials >= cr.cb.FullEvery {
+		fl.Debug().Uint32("partials", bc.partials).Msg("fullevery")
+		bc.force = true
+	}
+
+	if path != "" {
+		// Only the one path, everything else is left as it is until the next scan.
+		if err := ip.checkSubtree(cr, path); err != nil {
+			fl.Err(err).Str("path", path).Msg("checkSubtree")
+			cr.run.Error = err.Error()
+			return
+		}
+	} else if bc.force {
+		// A forced full loop.
+		cr.run.Full = true
+
+		// If we have the checkpoint queries, then commit each path as we finish with it.
+		//
+		// For a huge base the first scan can take days, this way if we are interrupted not everything has to start over.
+		if _, qu := co.baseTarget(cr.cb); qu.CheckpointUpdate != "" {
+			cr.checkpoint = true
+
+			// Only the first scan after startup can resume.
+			if bc.resume {
+				last, oldLoop, err := ip.loadCheckpoint(cr)
+				if err != nil {
+					fl.Err(err).Msg("loadCheckpoint")
+					cr.run.Error = err.Error()
+					return
+				}
+
+				if last != "" {
+					fl.Info().Str("path", last).Uint32("loop", oldLoop).Msg("resuming interrupted scan")
+					cr.resume = last
+				}
+			}
+		}
+
+		bc.resume = false
+
+		// A full loop means check every path, every file (at least a stat for the modified time) for changes.
+		pc, err := ip.getPathCache(cr, ".", nil)
+		if err != nil {
+			fl.Err(err).Msg("getPathCache")
+			cr.run.Error = err.Error()
+			return
+		}
+
+		if err := ip.checkBasePath(cr, pc, ".", true); err != nil {
+			fl.Err(err).Msg("checkBasePath")
+			cr.run.Error = err.Error()
+			return
+		}
+
+		bc.force = false
+		bc.partials = 0
+	} else {
+		bc.partials++
+
+		// Not force, so lets do a partial scan.
+		//
+		// A partial scan is one where instead of looping every single path and checking every file, we assume
+		// the cache we have is valid and only check the path modified time.
+		//
+		// For more details, see checkPathPartial()
+		//
+		// To do this we loop through the cache paths instead of the paths themselves, and checkBasePath() (called by
+		// checkPathPartial()) becomes non-recursive (unless its a new path, then it will be recursive).
+		//
+		// Now this means that while we check all the paths the bc.Paths map can change.
+		//
+		// To avoid issues we copy the path names and loop through those names.
+		paths := make([]string, 0, len(bc.Paths))
+		for path, _ := range bc.Paths {
+			paths = append(paths, path)
+		}
+
+		// So its easier in the logs to follow whats going on we sort the paths.
+		sort.Strings(paths)
+
+		// Check a few files still exist, for file systems that do not change the path when one is deleted.
+		if cr.cb != nil && cr.cb.TombstoneSample > 0 {
+			if bc.r == nil {
+				bc.r = rand.New(rand.NewSource(time.Now().UnixNano()))
+			}
+
+			cr.tombstones = sampleTombstones(bc.bfs, bc.Paths, cr.cb.TombstoneSample, bc.r)
+			if len(cr.tombstones) > 0 {
+				fl.Info().Int("paths", len(cr.tombstones)).Msg("files deleted from unchanged paths")
+			}
+		}
+
+		for _, path := range paths {
+			if err := ip.checkPathPartial(cr, path); err != nil {
+				fl.Err(err).Msg("checkPathPartial")
+				cr.run.Error = err.Error()
+				return
+			}
+		}
+	}
+
+	// Before we touch the database, make sure the base did not just disappear on us.
+	if ip.isStale(cr) {
+		err := errors.New("probable stale mount, database not updated")
+		fl.Err(err).Msg("stale")
+		cr.run.Stale = true
+		cr.run.Error = err.Error()
+		return
+	}
+
+	// Ok, now we calculate both the tags and hashes, create the physical cache file,
+	// and update the database.
+	_, ds := tracer.Start(cr.ctx, "db")
+	err := ip.checkHashTagsDB(cr)
+	tracing.End(ds, err)
+
+	if err != nil {
+		fl.Err(err).Msg("checkHashTags")
+		cr.run.Error = err.Error()
+		return
+	}
+
+	// Every file has had its tags recalculated.
+	bc.retag = false
+
+	// Remove any cache entries that should no longer be there.
+	//
+	// We do this after the database so it can delete/disable any entries first before we clean them here.
+	if err := ip.cleanCache(cr); err != nil {
+		fl.Err(err).Msg("cleanCache")
+		cr.run.Error = err.Error()
+		return
+	}
+
+	// Finished a checkpointed scan, so clear it.
+	if cr.checkpoint {
+		if err := ip.saveCheckpoint(cr, ""); err != nil {
+			fl.Err(err).Msg("saveCheckpoint")
+		}
+	}
+
+	return
+} // }}}
+
+// func ImageProc.isStale {{{
+
+// Returns true if the base looks to have gone offline, such as an NFS mount dropping.
+//
+// When a mount disappears the path is typically still there, just empty. To us that looks exactly like
+// every file was removed, so without this every file would be disabled in the database.
+//
+// So we compare how many of the files we know about (enabled in the database) were seen this loop.
+func (ip *ImageProc) isStale(cr *checkRun) bool {
+	var known, seen int
+
+	fl := ip.l.With().Str("func", "isStale").Int("base", cr.bc.Base).Logger()
+
+	loop := cr.bc.loop
+
+	for _, pc := range cr.bc.Paths {
+		for _, fc := range pc.Files {
+			if fc.id == 0 || fc.disabled {
+				continue
+			}
+
+			known++
+
+			if fc.loopF == loop {
+				seen++
+			}
+		}
+	}
+
+	// Nothing known yet, so nothing to lose.
+	if known == 0 {
+		return false
+	}
+
+	if seen == 0 {
+		fl.Warn().Int("known", known).Msg("no known files seen")
+		return true
+	}
+
+	if cr.cb != nil && cr.cb.StaleFraction > 0 && float64(seen) < float64(known)*cr.cb.StaleFraction {
+		fl.Warn().Int("known", known).Int("seen", seen).Float64("stalefraction", cr.cb.StaleFraction).Msg("too few known files seen")
+		return true
+	}
+
+	return false
+} // }}}
+
+// func ImageProc.finishRun {{{
+
+// Completes the ScanRun for the check, saving it to the database and keeping it for ScanRuns().
+func (ip *ImageProc) finishRun(cr *checkRun) {
+	fl := ip.l.With().Str("func", "finishRun").Int("base", cr.bc.Base).Logger()
+
+	run := cr.run
+	run.Took = time.Since(run.Start)
+
+	// Count the files seen this loop.
+	for _, pc := range cr.bc.Paths {
+		for _, fc := range pc.Files {
+			if fc.loopF == cr.bc.loop {
+				run.Seen++
+			}
+		}
+	}
+
+	fl.Info().Str("took", run.Took.String()).Bool("full", run.Full).Str("path", run.Path).Int("seen", run.Seen).Int("added", run.Added).
+		Int("updated", run.Updated).Int("disabled", run.Disabled).Int("errors", run.Errors).Int("linked", run.Linked).Int("fastlane", run.FastLane).Str("error", run.Error).Send()
+
+	cr.span.SetAttributes(attribute.Bool("full", run.Full), attribute.Int("seen", run.Seen), attribute.Int("added", run.Added),
+		attribute.Int("updated", run.Updated), attribute.Int("disabled", run.Disabled), attribute.Int("errors", run.Errors))
+
+	if run.Error != "" {
+		tracing.Fail(cr.span, errors.New(run.Error))
+	}
+
+	cr.span.End()
+
+	ip.rMut.Lock()
+	ip.runs = append(ip.runs, *run)
+	if len(ip.runs) > maxScanRuns {
+		ip.runs = ip.runs[len(ip.runs)-maxScanRuns:]
+	}
+	ip.rMut.Unlock()
+
+	co := ip.getConf()
+
+	cb, ok := co.Bases[run.Base]
+	if !ok {
+		return
+	}
+
+	if _, qu := co.baseTarget(cb); qu.RunsInsert == "" {
+		return
+	}
+
+	db, err := ip.baseDB(run.Base)
+	if err != nil {
+		return
+	}
+
+	if _, err := db.Exec(ip.ctx, "runs-insert", run.Base, run.Start, run.Took.Milliseconds(), run.Full, run.Seen,
+		run.Added, run.Updated, run.Disabled, run.Errors, run.Error); err != nil {
+		fl.Err(err).Msg("runs-insert")
+	}
+} // }}}
+
+// func ImageProc.ScanRuns {{{
+
+// Returns the most recent check runs for all bases, newest first.
+func (ip *ImageProc) ScanRuns() []ScanRun {
+	ip.rMut.Lock()
+	defer ip.rMut.Unlock()
+
+	runs := make([]ScanRun, 0, len(ip.runs))
+	for i := len(ip.runs) - 1; i >= 0; i-- {
+		runs = append(runs, ip.runs[i])
+	}
+
+	return runs
+} // }}}
+
+// func ImageProc.cleanCache {{{
+
+// Cleans up the cache, removing any path or files that no longer exist (and are disabled in the database).
+func (ip *ImageProc) cleanCache(cr *checkRun) error {
+	fl := ip.l.With().Str("func", "cleanCache").Int("base", cr.bc.Base).Logger()
+
+	loop := cr.bc.loop
+
+	for path, pc := range cr.bc.Paths {
+		for file, fc := range pc.Files {
+			// Was the file seen this loop?
+			if fc.loopF == loop {
+				continue
+			}
+
+			// Does it exist in the database?
+			if fc.id != 0 && !fc.disabled {
+				continue
+			}
+
+			// Should be removed.
+			fl.Info().Str("path", path).Str("file", file).Msg("cleaned")
+			delete(pc.Files, file)
+		}
+
+		// Was this path seen this loop?
+		if pc.loop == loop {
+			// It was seen, so not possible to remove it.
+			continue
+		}
+
+		// Does it still have valid files within?
+		if len(pc.Files) > 0 {
+			// How does this happen?
+			//
+			// When a path containing at least one file that was in the database was removed.
+			//
+			// The file has to be removed from the database first, and then on the next loop we should clean
+			// both the file(s) and the path.
+			continue
+		}
+
+		// Ok, no files - Is this path in the database and still enabled?
+		if pc.id != 0 && !pc.disabled {
+			// Yep, still has to be removed from the database
+			continue
+		}
+
+		// No reason to keep the path - So remove it.
+		fl.Info().Str("path", path).Msg("cleaned")
+		delete(cr.bc.Paths, path)
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.updateDBPF {{{
+
+// Handles updating the path and all files within said path to the database.
+func (ip *ImageProc) updateDBPF(cr *checkRun, pc *pathCache) error {
+	fl := ip.l.With().Str("func", "updateDBPF").Int("base", cr.bc.Base).Str("path", pc.Path).Logger()
+
+	// Any changes to the path or the files within would update at least 1 bit in pc.updated.
+	//
+	// Even just a file change with no path change would set upPathFI.
+	if pc.updated == 0 {
+		return nil
+	}
+
+	// Need the database.
+	db, err := ip.baseDB(cr.bc.Base)
+	if err != nil {
+		fl.Err(err).Msg("baseDB")
+		return err
+	}
+
+	// Get our transaction
+	tx, err := db.Begin(ip.ctx)
+	if err != nil {
+		fl.Err(err).Msg("begin")
+		return err
+	}
+
+	// Handle database path work.
+	if err := ip.updateDBPath(tx, cr, pc); err != nil {
+		fl.Err(err).Msg("updateDBPath")
+		tx.Rollback(ip.ctx)
+		return err
+	}
+
+	// Run through the files
+	for _, fc := range pc.Files {
+		if err := ip.updateDBFile(tx, cr, pc.id, fc); err != nil {
+			fl.Err(err).Msg("updateDBFile")
+			tx.Rollback(ip.ctx)
+			return err
+		}
+	}
+
+	if err = tx.Commit(ip.ctx); err != nil {
+		fl.Err(err).Msg("commit")
+		return err
+	}
+
+	// Now that we have committed the work, we can clear the changed flags.
+	//
+	// We do not do this before the commit in case of database error, so we can try the transaction again
+	// later on.
+	pc.updated = 0
+
+	for _, fc := range pc.Files {
+		if fc.updated != 0 {
+			fc.updated = 0
+		}
+
+		fc.dbID, fc.dbTags = fc.ID, fc.CTags
+	}
+
+	return nil
+} // }}}
+
+// func ImageProc.updateDBFile {{{
+
+func (ip *ImageProc) updateDBFile(tx pgx.Tx, cr *checkRun, pid uint64, fc *fileCache) error {
+	fl := ip.l.With().Str("func", "updateDBFile").Uint64("pid", pid).Int("base", cr.bc.Base).Str("file", fc.Name).Logger()
+
+	// A file without any tags is of no value to the system, and can not be
+	// inserted into the database.
+	if len(fc.CTags) == 0 {
+		fl.Warn().Msg("Has no tags")
+		return nil
+	}
+
+	// Loop check - If we didn't see the file this loop we disable it.
+	//
+	// Note we don't check fileError yet, as this allows a previously errored file to be removed, and properly cleaned out here.
+	if fc.loopF != cr.bc.loop {
+		// File has no loop, does it have an ID?
+		if fc.id == 0 {
+			// No loop, and no ID?
+			//
+			// This happens if a sidecar exists without the file its meant to be the sidecar for.
+			//
+			// So does the sidecar loopS exist?
+			if fc.loopS == cr.bc.loop {
+				// Disable the file so its cleaned up and hopefully someone fixes it.
+				fc.disabled = true
+				return nil
+			}
+
+			// Ok, so the sidecar wasn't the cause, so this is a warning as this shouldn't happen.
+			err := errors.New("no loop, no id")
+			fl.Warn().Err(err).Send()
+			fc.disabled = true
+			return err
+		}
+
+		// Still within the grace period?
+		if !fc.disabled && !graceOver(cr.cb, fc.missed, fc.missedSince) {
+			fl.Debug().Uint32("missed", fc.missed).Msg("unseen, within grace period")
+			return nil
+		}
+
+		if fc.disabled {
+			// Is the file disabled?
+			//
+			// Should have been cleaned up already in the clearCache(), so looks like another warning.
+			err := errors.New("already disabled unseen file")
+			fl.Warn().Err(err).Send()
+			return err
+		}
+
+		// Lets update the database to disable the path
+		if _, err := tx.Exec(ip.ctx, "files-disable", fc.id); err != nil {
+			fl.Err(err).Uint64("fid", fc.id).Msg("disable file")
+			return err
+		}
+
+		if err := ip.logChange(tx, cr, changeDisable, fc); err != nil {
+			fl.Err(err).Uint64("fid", fc.id).Msg("logChange")
+			return err
+		}
+
+		fc.disabled = true
+		cr.run.Disabled++
+
+		return nil
+	}
+
+	if fc.fileError {
+		return nil
+	}
+
+	// Is this a new file?
+	if fc.id == 0 {
+		if err := tx.QueryRow(ip.ctx, "files-insert", pid, fc.Name, fc.FileTS, fc.ID, fc.SideTS, fc.SideTG, fc.CTags).Scan(&fc.id); err != nil {
+			fl.Err(err).Str("file", fc.Name).Msg("insert file")
+			return err
+		}
+
+		if err := ip.logChange(tx, cr, changeAdd, fc); err != nil {
+			fl.Err(err).Uint64("fid", fc.id).Msg("logChange")
+			return err
+		}
+
+		fl.Debug().Str("file", fc.Name).Uint64("id", fc.id).Send()
+		cr.run.Added++
+	} else {
+		// Existing path - So anything to update?
+		if fc.updated&(upFileTS|upFileCT|upFileHS|upSideTS|upSideTG) != 0 {
+			// Update the row
+			if _, err := tx.Exec(ip.ctx, "files-update", fc.id, fc.FileTS, fc.ID, fc.SideTS, fc.SideTG, fc.CTags); err != nil {
+				fl.Err(err).Uint64("fid", fc.id).Msg("update file")
+				return err
+			}
+
+			if err := ip.logChange(tx, cr, changeUpdate, fc); err != nil {
+				fl.Err(err).Uint64("fid", fc.id).Msg("logChange")
+				return err
+			}
+
+			cr.run.Updated++
+
+			fl.Info().Msg("updated")
+		}
+	}
+
+	// All looks good.
+	return nil
+} // }}}
+
+// func ImageProc.updateDBPath {{{
+
+func (ip *ImageProc) updateDBPath(tx pgx.Tx, cr *checkRun, pc *pathCache) error {
+	fl := ip.l.With().Str("func", "updateDBPath").Int("base", cr.bc.Base).Str("path", pc.Path).Logger()
+
+	// Loop check - If we didn't see the path this loop then we disable it.
+	if pc.loop != cr.bc.loop {
+		// If the path has no id then we don't have to update the database.
+		//
+		// Now exactly how a path shows up without an ID and not be seen? Thats a good question, it shouldn't.
+		//
+		// For a path to show up at all, the first time it has no ID but for that first loop we assign it an ID here.
+		// After that the path can be removed and it would not be seen in the current loop, and then we disable it, but it should still
+		// have an ID.
+		//
+		// So we log that it happened and let the caller handle this issue.
+		if pc.id == 0 {
+			err := errors.New("no loop, no id")
+			fl.Err(err).Send()
+			pc.disabled = true
+			return err
+		}
+
+		// Still within the grace period?
+		if !pc.disabled && !graceOver(cr.cb, pc.missed, pc.missedSince) {
+			fl.Debug().Uint32("missed", pc.missed).Msg("unseen, within grace period")
+			return nil
+		}
+
+		if pc.disabled {
+			// Path is already disabled?
+			//
+			// If it was disabled, the caller should have already removed it from memory the last time we disabled it, as
+			// we should be the only function to disable a path, and we leave it up to our caller to remove the pathCache from memory.
+			//
+			// If this happens, that means the caller didn't remove it.
+			//
+			// That, or the other option is when we loaded the cache from the database we loaded a disabled path when we should skip those.
+			//
+			// Check the SQL if its including disabled paths when loading or not?
+			err := errors.New("already disabled unseen path")
+			fl.Err(err).Send()
+			return err
+		}
+
+		// Lets update the database to disable the path
+		if _, err := tx.Exec(ip.ctx, "paths-disable", pc.id); err != nil {
+			fl.Err(err).Uint64("pid", pc.id).Msg("disable path")
+			return err
+		}
+
+		pc.disabled = true
+
+		return nil
+	}
+
+	// Is this a new path?
+	if pc.id == 0 {
+		if err := tx.QueryRow(ip.ctx, "paths-insert", cr.bc.Base, pc.Path, pc.Changed, pc.Tags, pc.SideTS).Scan(&pc.id); err != nil {
+			fl.Err(err).Str("path", pc.Path).Msg("insert path")
+			return err
+		}
+
+		fl.Debug().Str("path", pc.Path).Uint64("id", pc.id).Send()
+	} else {
+		// Existing path - So anything to update?
+		if pc.updated&(upPathTG|upPathTS) != 0 {
+			// Update the row
+			if _, err := tx.Exec(ip.ctx, "paths-update", pc.id, pc.Changed, pc.Tags, pc.SideTS); err != nil {
+				fl.Err(err).Uint64("pid", pc.id).Msg("update path")
+				return err
+			}
+		}
+	}
+
+	// All looks good.
+	return nil
+} // }}}
+
+// func ImageProc.loadCache {{{
+
+// Loads the cache from the database.
+//
+// Typically called whenever the datbase connection or queries change.
+//
+// Meant to flush the cache and re-create from scratch.
+//
+// Note that when this happens, if something messes up this puts us in a situation
+// or basically no automatic recovery.
+//
+// We can not continue to use the old cache since we may no longer be talking
+// to that database or using those queries.
+//
+// So the cache is wiped, and we attempt to load everything from scratch.
+func (ip *ImageProc) loadCache(co *conf) error {
+	fl := ip.l.With().Str("func", "loadCache").Logger()
+
+	ca := ip.ca
+
+	// We are pretty much replacing the entire cache here, so just get a lock over it until we are done.
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	// Just wipe the old cache, we are replacing the whole thing here.
+	ca.bases = make(map[int]*baseCache, 1)
+
+	// Lets load all the paths from the database.
+	for _, cb := range co.Bases {
+		db, err := ip.baseDB(cb.Base)
+		if err != nil {
+			fl.Err(err).Int("base", cb.Base).Msg("baseDB")
+			return err
+		}
+
+		if err := ip.addBaseCache(cb, ca, db); err != nil {
+			return err
+		}
+	}
+
+	fl.Debug().Msg("loaded")
+
+	return nil
+} // }}}
+
+// func ImageProc.checkAll {{{
+
+func (ip *ImageProc) checkAll() {
+	fl := ip.l.With().Str("func", "checkAll").Logger()
+
+	// Get the cache.
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	for _, bc := range ca.bases {
+		fl.Debug().Int("base", bc.Base).Send()
+
+		// Check the base in its own goroutine.
+		go ip.checkBase(bc, "")
+	}
+
+	return
+} // }}}
+
+// func ImageProc.addBaseCache {{{
+
+// This gets (or adds if not already there) a baseCache for the specific Base.
+//
+// This adds (if not in cache already) the baseCache and appropriate
+// path and file caches from the database.
+//
+// This will replace any possibly existing cache for the base.
+//
+// This assumes you already have a lock on the cache passed in.
+func (ip *ImageProc) addBaseCache(cb *confBase, ca *cache, db *pgxpool.Pool) error {
+	var inID, hID uint64
+	var name string
+	var changed, sidets time.Time
+	var tgs, sideTags tags.Tags
+
+	fl := ip.l.With().Str("func", "addBaseCache").Logger()
+
+	if ca == nil || cb == nil {
+		fl.Warn().Msg("Missing cb or ca")
+		return nil
+	}
+
+	fl = fl.With().Int("base", cb.Base).Logger()
+
+	// Don't care if the cache already exists or not, just replace.
+	//
+	// This can happen if we switch database or just want to refresh
+	// the whole thing.
+	bc := &baseCache{
+		Base:      cb.Base,
+		path:      cb.Path,
+		tagFile:   cb.TagFile,
+		enableRaw: cb.EnableRaw,
+		exts:      cb.Exts,
+		nameTags:  cb.NameTags,
+		Paths:     make(map[string]*pathCache, 1),
+	}
+
+	bc.bfs = chaos.FS(os.DirFS(cb.Path))
+
+	// Add to the cache.
+	ca.bases[bc.Base] = bc
+
+	// Load any paths already in the database.
+	pathRows, err := db.Query(ip.ctx, "paths-select", bc.Base)
+	if err != nil {
+		fl.Err(err).Msg("paths-select")
+		return err
+	}
+
+	// Loop through our paths
+	for pathRows.Next() {
+		// Get the values
+		if err := pathRows.Scan(&inID, &name, &changed, &tgs, &sidets); err != nil {
+			pathRows.Close()
+			fl.Err(err).Msg("paths-select-rows-scan")
+			return err
+		}
+
+		// Fix the tags first
+		tgs = tgs.Fix()
+
+		// Now add the path to our cache.
+		pc := &pathCache{
+			id:      inID,
+			Path:    name,
+			Tags:    tgs.Copy(),
+			SideTS:  sidets.UTC().Round(time.Second),
+			Changed: changed.UTC().Round(time.Second),
+			Files:   make(map[string]*fileCache, 1),
+		}
+
+		// Now add the path to our cache
+		bc.Paths[name] = pc
+	}
+
+	if pathRows.Err() != nil {
+		pathRows.Close()
+		err := pathRows.Err()
+		fl.Err(err).Msg("paths-select-rows-done")
+		return err
+	}
+
+	// Done with the paths
+	pathRows.Close()
+
+	// Now we loop through all the paths we just loaded and get all the files for each to cache.
+	for _, pc := range bc.Paths {
+		fileRows, err := db.Query(ip.ctx, "files-select", pc.id)
+		if err != nil {
+			fl.Err(err).Msg("files-select")
+			return err
+		}
+
+		// Loop through our paths
+		for fileRows.Next() {
+			// Get the values
+			//
+			// Default query I used for development -
+			//
+			//   SELECT fid, name, filets, hid, sidets, sidetags, tags FROM files.files WHERE pid = $1 AND enabled
+			if err := fileRows.Scan(&inID, &name, &changed, &hID, &sidets, &sideTags, &tgs); err != nil {
+				fileRows.Close()
+				fl.Err(err).Msg("files-select-rows-scan")
+				return err
+			}
+
+			// Fix our tags
+			sideTags = sideTags.Fix()
+			tgs = tgs.Fix()
+
+			// Create our file cache
+			fc := &fileCache{
+				id:     inID,
+				Name:   name,
+				ID:     hID,
+				FileTS: changed,
+				SideTS: sidets,
+				SideTG: sideTags.Copy(),
+				CTags:  tgs.Copy(),
+				dbID:   hID,
+				dbTags: tgs.Copy(),
+			}
+
+			pc.Files[name] = fc
+		}
+
+		if fileRows.Err() != nil {
+			fileRows.Close()
+			err := fileRows.Err()
+			fl.Err(err).Msg("files-select-rows-done")
+			return err
+		}
+
+		// Done with the files
+		fileRows.Close()
+	}
+
+	fl.Debug().Msg("Added base")
+
+	return nil
+} // }}}
+
+// func ImageProc.scheduleBases {{{
+
+// Sets up a scheduler task to check each base at its configured interval.
+//
+// Called at startup and each time the configuration changes. Bases whose interval has not changed are left as-is,
+// so a reload does not push back their next check.
+func (ip *ImageProc) scheduleBases(co *conf) {
+	fl := ip.l.With().Str("func", "scheduleBases").Logger()
+
+	for id, cb := range co.Bases {
+		name := "base " + strconv.Itoa(id)
+
+		if err := ip.sch.Reschedule(name, cb.CheckInt); err == nil {
+			continue
+		}
+
+		// Not yet scheduled, so add it.
+		//
+		// Copy the id so each task has its own.
+		bid := id
+		if err := ip.sch.Add(name, cb.CheckInt, func() { ip.tickBase(bid) }); err != nil {
+			fl.Err(err).Int("base", id).Msg("Add")
+		}
+	}
+} // }}}
+
+// func ImageProc.tickBase {{{
+
+// Run by the scheduler to check a single base.
+func (ip *ImageProc) tickBase(id int) {
+	fl := ip.l.With().Str("func", "tickBase").Int("base", id).Logger()
+
+	// Removed from the configuration?
+	if _, ok := ip.getConf().Bases[id]; !ok {
+		fl.Info().Msg("base removed")
+		ip.sch.Remove("base " + strconv.Itoa(id))
+		return
+	}
+
+	// Get the cache
+	ca := ip.ca
+
+	// Temporary lock
+	ca.cMut.Lock()
+	bc, ok := ca.bases[id]
+	ca.cMut.Unlock()
+
+	if !ok {
+		fl.Warn().Msg("no base cache")
+		return
+	}
+
+	fl.Debug().Msg("baseTick")
+
+	// Checking can take a long time, so do not hold up any other base.
+	go ip.checkBase(bc, "")
+} // }}}
+
+// func ImageProc.close {{{
+
+// Stops all background processing and disconnects from the database.
+//
+// This can block as it waits on the database to close.
+func (ip *ImageProc) close() {
+	fl := ip.l.With().Str("func", "close").Logger()
+
+	// Set closed
+	if !atomic.CompareAndSwapUint32(&ip.closed, 0, 1) {
+		fl.Info().Msg("already closed")
+		return
+	}
+
+	// Shutdown the database before we return.
+	ip.db.Close()
+
+	ip.dbMut.Lock()
+	for _, bdb := range ip.baseDBs {
+		bdb.db.Close()
+	}
+	ip.dbMut.Unlock()
+
+	fl.Info().Msg("closed")
+} // }}}
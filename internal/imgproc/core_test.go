@@ -0,0 +1,580 @@
+package imgproc
+
+import (
+	"frame/types"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type scanDoneTest struct {
+	Path     string
+	Last     string
+	Expected bool
+}
+
+func TestScanDone(t *testing.T) {
+	tests := []scanDoneTest{
+		// No checkpoint, nothing is done.
+		{"a", "", false},
+
+		// The root is never done until the scan is.
+		{".", "b/c", false},
+		{"b", ".", true},
+
+		// The checkpoint itself, and anything below it.
+		{"b/c", "b/c", true},
+		{"b/c/d", "b/c", true},
+
+		// Parents complete after their children.
+		{"b", "b/c", false},
+
+		// Sorts before or after where they differ.
+		{"a", "b/c", true},
+		{"a/z", "b/c", true},
+		{"b/b", "b/c", true},
+		{"b/d", "b/c", false},
+		{"c", "b/c", false},
+
+		// Component wise, not string wise.
+		{"b/c-d", "b/c/e", false},
+	}
+
+	for _, test := range tests {
+		if got := scanDone(test.Path, test.Last); got != test.Expected {
+			t.Fatalf("scanDone(%q, %q) Expected %v != Got %v", test.Path, test.Last, test.Expected, got)
+		}
+	}
+}
+
+func TestGraceOver(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		Base     *confBase
+		Missed   uint32
+		Since    time.Time
+		Expected bool
+	}{
+		// No grace period, disable right away.
+		{&confBase{}, 1, now, true},
+
+		// Loops only.
+		{&confBase{DisableLoops: 3}, 2, now, false},
+		{&confBase{DisableLoops: 3}, 3, now, true},
+
+		// Time only.
+		{&confBase{DisableAfter: time.Hour}, 10, now, false},
+		{&confBase{DisableAfter: time.Hour}, 1, now.Add(-2 * time.Hour), true},
+
+		// Both must be met.
+		{&confBase{DisableLoops: 3, DisableAfter: time.Hour}, 5, now, false},
+		{&confBase{DisableLoops: 3, DisableAfter: time.Hour}, 1, now.Add(-2 * time.Hour), false},
+		{&confBase{DisableLoops: 3, DisableAfter: time.Hour}, 3, now.Add(-2 * time.Hour), true},
+	}
+
+	for _, test := range tests {
+		if got := graceOver(test.Base, test.Missed, test.Since); got != test.Expected {
+			t.Fatalf("graceOver(%+v, %d, %s) Expected %v != Got %v", *test.Base, test.Missed, test.Since, test.Expected, got)
+		}
+	}
+}
+
+func TestGetFileType(t *testing.T) {
+	exts, err := parseExts([]string{"JPG", ".jpe", "tif", "scn"}, map[string]string{"scn": "jpeg"}, nil)
+	if err != nil {
+		t.Fatalf("parseExts: %s", err)
+	}
+
+	tests := []struct {
+		File     string
+		Exts     map[string]int
+		Expected int
+		Image    string
+	}{
+		// Defaults.
+		{"a.jpg", nil, 1, ""},
+		{"a.JPEG", nil, 1, ""},
+		{"a.tif", nil, 1, ""},
+		{"a.bmp", nil, 0, ""},
+		{"a.nef", nil, 3, ""},
+		{"a.jpg.txt", nil, 2, "a.jpg"},
+		{"a.mp4.txt", nil, 0, ""},
+
+		// Configured.
+		{"a.jpg", exts, 1, ""},
+		{"a.jpe", exts, 1, ""},
+		{"a.tif", exts, 1, ""},
+		{"a.scn", exts, 1, ""},
+		{"a.png", exts, 0, ""},
+		{"a.tif.txt", exts, 2, "a.tif"},
+	}
+
+	for _, test := range tests {
+		ft, iname := getFileType(test.File, test.Exts)
+		if ft != test.Expected || iname != test.Image {
+			t.Fatalf("getFileType(%q) Expected %d, %q != Got %d, %q", test.File, test.Expected, test.Image, ft, iname)
+		}
+	}
+
+	// Unknown extensions without a decoder, or unknown decoders, are errors.
+	if _, err := parseExts([]string{"xyz"}, nil, nil); err == nil {
+		t.Fatalf("parseExts accepted an extension without a decoder")
+	}
+
+	if _, err := parseExts([]string{"xyz"}, map[string]string{"xyz": "nope"}, nil); err == nil {
+		t.Fatalf("parseExts accepted an unknown decoder")
+	}
+
+	// Commands make an extension an image, on top of the defaults if no extensions are given.
+	cmds, err := parseCommands(map[string]string{"HEIC": "heif-dec {file} -o -"})
+	if err != nil {
+		t.Fatalf("parseCommands: %s", err)
+	}
+
+	if exts, err = parseExts(nil, nil, cmds); err != nil {
+		t.Fatalf("parseExts with commands: %s", err)
+	}
+
+	if ft, _ := getFileType("a.heic", exts); ft != 1 {
+		t.Fatalf("getFileType(a.heic) with commands Expected 1 != Got %d", ft)
+	}
+
+	if ft, _ := getFileType("a.jpg", exts); ft != 1 {
+		t.Fatalf("getFileType(a.jpg) with commands Expected 1 != Got %d", ft)
+	}
+
+	if _, err := parseCommands(map[string]string{"heic": "heif-dec"}); err == nil {
+		t.Fatalf("parseCommands accepted a command without {file}")
+	}
+}
+
+func TestFilenameTags(t *testing.T) {
+	res, err := parseNameTags(true, []string{`^(\d{4})-\d{2}-\d{2}`, `by ([a-z]+)`})
+	if err != nil {
+		t.Fatalf("parseNameTags: %s", err)
+	}
+
+	tests := []struct {
+		File     string
+		Expected []string
+	}{
+		{"IMG_1234 [beach,family].jpg", []string{"beach", "family"}},
+		{"IMG_1234 [ beach , ].jpg", []string{"beach"}},
+		{"IMG_1234 [a] [b].jpg", []string{"a", "b"}},
+		{"2019-07-04 fireworks [usa].jpg", []string{"usa", "2019"}},
+		{"2019-07-04 by alice.jpg", []string{"2019", "alice"}},
+		{"IMG_1234.jpg", nil},
+		{"[ext].jpg.[not]", []string{"ext"}},
+	}
+
+	for _, test := range tests {
+		got := filenameTags(test.File, res)
+		if len(got) != len(test.Expected) {
+			t.Fatalf("filenameTags(%q) Expected %q != Got %q", test.File, test.Expected, got)
+		}
+
+		for i := range got {
+			if got[i] != test.Expected[i] {
+				t.Fatalf("filenameTags(%q) Expected %q != Got %q", test.File, test.Expected, got)
+			}
+		}
+	}
+
+	// Patterns without a capture group would never tag anything.
+	if _, err := parseNameTags(false, []string{`^IMG_\d+`}); err == nil {
+		t.Fatalf("parseNameTags accepted a pattern without a capture group")
+	}
+
+	if _, err := parseNameTags(false, []string{`([`}); err == nil {
+		t.Fatalf("parseNameTags accepted an invalid pattern")
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	csvIn := "path,tags\n# a comment\n2019/beach.jpg, beach ,family;sun\n./top.jpg,one\nempty.jpg,\n"
+	jsonIn := `{"2019/beach.jpg": ["beach", "family", "sun"], "/top.jpg": ["one"], "empty.jpg": [" "]}`
+
+	for _, test := range []struct {
+		In     string
+		Format int
+	}{
+		{csvIn, manifestCSV},
+		{jsonIn, manifestJSON},
+	} {
+		got, err := parseManifest(strings.NewReader(test.In), test.Format)
+		if err != nil {
+			t.Fatalf("parseManifest(%d): %s", test.Format, err)
+		}
+
+		expected := map[string][]string{
+			"2019/beach.jpg": {"beach", "family", "sun"},
+			"top.jpg":        {"one"},
+		}
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("parseManifest(%d) Expected %q != Got %q", test.Format, expected, got)
+		}
+	}
+
+	if _, err := manifestFormat("tags.txt"); err == nil {
+		t.Fatalf("manifestFormat accepted a .txt")
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	entries := map[string][]string{
+		"2019/beach.jpg": {"beach", "family"},
+		"top.jpg":        {"one"},
+	}
+
+	for _, format := range []int{manifestCSV, manifestJSON} {
+		data, err := writeManifest(entries, format)
+		if err != nil {
+			t.Fatalf("writeManifest(%d): %s", format, err)
+		}
+
+		got, err := parseManifest(strings.NewReader(string(data)), format)
+		if err != nil {
+			t.Fatalf("parseManifest(%d): %s", format, err)
+		}
+
+		if !reflect.DeepEqual(got, entries) {
+			t.Fatalf("writeManifest(%d) Expected %q != Got %q", format, entries, got)
+		}
+	}
+}
+
+func TestEditNames(t *testing.T) {
+	tests := []struct {
+		Names    []string
+		Add      []string
+		Remove   []string
+		Expected []string
+		Changed  bool
+	}{
+		{nil, []string{"beach"}, nil, []string{"beach"}, true},
+		{[]string{"Beach", "sun"}, []string{" beach ", "family"}, nil, []string{"Beach", "sun", "family"}, true},
+		{[]string{"beach", "sun"}, []string{"beach"}, nil, []string{"beach", "sun"}, false},
+		{[]string{"beach", "SUN"}, nil, []string{"sun"}, []string{"beach"}, true},
+		{[]string{"beach"}, nil, []string{"beach"}, nil, true},
+		{[]string{"beach"}, nil, []string{"sun"}, []string{"beach"}, false},
+	}
+
+	for _, test := range tests {
+		got, changed := editNames(test.Names, test.Add, test.Remove)
+
+		if changed != test.Changed || !reflect.DeepEqual(got, test.Expected) {
+			t.Fatalf("editNames(%q, %q, %q) Expected %q %t != Got %q %t", test.Names, test.Add, test.Remove, test.Expected, test.Changed, got, changed)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		Pattern  string
+		Path     string
+		Expected bool
+	}{
+		{"*.png", "2019/july/a.png", true},
+		{"*.png", "2019/july/a.jpg", false},
+		{"2019/*", "2019/july/a.jpg", true},
+		{"2019", "2019/july/a.jpg", true},
+		{"2019/july/a.jpg", "2019/july/a.jpg", true},
+		{"2020/*", "2019/july/a.jpg", false},
+		{"*/july", "2019/july/a.jpg", true},
+		{"july", "2019/july/a.jpg", false},
+	}
+
+	for _, test := range tests {
+		if got := matchPath(test.Pattern, test.Path); got != test.Expected {
+			t.Fatalf("matchPath(%q, %q) Expected %t != Got %t", test.Pattern, test.Path, test.Expected, got)
+		}
+	}
+}
+
+func TestBaseTarget(t *testing.T) {
+	co := &conf{
+		Database: "global",
+		Queries: &confQueries{
+			FilesSelect: "files-select",
+			PathsSelect: "paths-select",
+		},
+	}
+
+	// No base, or a base without its own, gets the global ones.
+	for _, cb := range []*confBase{nil, &confBase{}} {
+		db, qu := co.baseTarget(cb)
+		if db != "global" || qu != *co.Queries {
+			t.Fatalf("baseTarget(%v) Got %q %+v", cb, db, qu)
+		}
+	}
+
+	// Only what the base sets replaces the global ones.
+	cb := &confBase{
+		Database: "archive",
+		Queries: &confQueries{
+			FilesSelect: "archive-select",
+		},
+	}
+
+	db, qu := co.baseTarget(cb)
+	if db != "archive" {
+		t.Fatalf("baseTarget database Expected archive != Got %q", db)
+	}
+
+	if qu.FilesSelect != "archive-select" || qu.PathsSelect != "paths-select" {
+		t.Fatalf("baseTarget queries Got %+v", qu)
+	}
+
+	// And the global ones are left alone.
+	if co.Queries.FilesSelect != "files-select" {
+		t.Fatalf("baseTarget changed the global queries")
+	}
+}
+
+func TestNewBases(t *testing.T) {
+	oldco := &conf{Bases: map[int]*confBase{1: {}, 2: {}}}
+	co := &conf{Bases: map[int]*confBase{1: {}, 3: {}, 5: {}}}
+
+	if got := newBases(oldco, co); !reflect.DeepEqual(got, []int{3, 5}) {
+		t.Fatalf("newBases Expected [3 5] != Got %v", got)
+	}
+
+	if got := newBases(co, co); len(got) != 0 {
+		t.Fatalf("newBases Expected none != Got %v", got)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b", "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a/b/up points back at a, a loop.
+	if err := os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "a", "b", "up")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	ip := &ImageProc{l: zerolog.Nop()}
+	cr := &checkRun{
+		cb: &confBase{FollowSymlinks: true, MaxDepth: 3},
+		bc: &baseCache{path: dir},
+	}
+
+	if err := ip.walkStart(cr, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"a", "a/b"} {
+		info, err := ip.walkDir(cr, path)
+		if err != nil || info == nil {
+			t.Fatalf("walkDir(%s) = %v, %v", path, info, err)
+		}
+
+		cr.walk = append(cr.walk, info)
+	}
+
+	if info, err := ip.walkDir(cr, "a/b/up"); err != nil || info != nil {
+		t.Errorf("walkDir(a/b/up) loop not skipped: %v, %v", info, err)
+	}
+
+	if info, err := ip.walkDir(cr, "a/b/c"); err != nil || info == nil {
+		t.Errorf("walkDir(a/b/c) = %v, %v", info, err)
+	}
+
+	cr.cb.MaxDepth = 2
+
+	if info, err := ip.walkDir(cr, "a/b/c"); err != nil || info != nil {
+		t.Errorf("walkDir(a/b/c) maxdepth not applied: %v, %v", info, err)
+	}
+}
+
+func TestSampleTombstones(t *testing.T) {
+	bfs := fstest.MapFS{
+		"a/1.jpg": &fstest.MapFile{},
+		"b/1.jpg": &fstest.MapFile{},
+	}
+
+	paths := map[string]*pathCache{
+		"a": {Path: "a", Files: map[string]*fileCache{"1.jpg": {Name: "1.jpg"}}},
+
+		// 2.jpg was deleted, 3.jpg is already known to be missing.
+		"b": {Path: "b", Files: map[string]*fileCache{"1.jpg": {Name: "1.jpg"}, "2.jpg": {Name: "2.jpg"}}},
+		"c": {Path: "c", Files: map[string]*fileCache{"3.jpg": {Name: "3.jpg", missed: 1}}},
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	// Enough to sample every file.
+	gone := sampleTombstones(bfs, paths, 10, r)
+	if len(gone) != 1 || !gone["b"] {
+		t.Fatalf("sampleTombstones Expected only b != Got %v", gone)
+	}
+
+	// Only 1 file at a time, b should still be found eventually.
+	found := false
+	for i := 0; i < 100 && !found; i++ {
+		gone = sampleTombstones(bfs, paths, 1, r)
+		if len(gone) > 1 || (len(gone) == 1 && !gone["b"]) {
+			t.Fatalf("sampleTombstones Expected nothing or b != Got %v", gone)
+		}
+
+		found = gone["b"]
+	}
+
+	if !found {
+		t.Fatalf("sampleTombstones never sampled the deleted file")
+	}
+}
+
+func TestMissedReturned(t *testing.T) {
+	bfs := fstest.MapFS{
+		"a/1.jpg": &fstest.MapFile{},
+		"a/2.jpg": &fstest.MapFile{},
+	}
+
+	pc := &pathCache{Path: "a", Files: map[string]*fileCache{
+		"1.jpg": {Name: "1.jpg"},
+		"2.jpg": {Name: "2.jpg"},
+		"3.jpg": {Name: "3.jpg", missed: 2},
+	}}
+
+	// 3.jpg is still gone.
+	if missedReturned(bfs, pc) {
+		t.Fatalf("missedReturned Expected false != Got true")
+	}
+
+	// 2.jpg was missing, but is back.
+	pc.Files["2.jpg"].missed = 1
+
+	if !missedReturned(bfs, pc) {
+		t.Fatalf("missedReturned Expected true != Got false")
+	}
+}
+
+// A CacheManager that only counts the images given to it, each getting the next ID.
+type countCM struct {
+	types.CacheManager
+	calls int
+}
+
+func (cm *countCM) CacheImageRaw(r io.Reader) (uint64, error) {
+	cm.calls++
+	return uint64(cm.calls), nil
+}
+
+func TestHardLinks(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, sub := range []string{"all", "album"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "all", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Link(filepath.Join(dir, "all", "a.jpg"), filepath.Join(dir, "album", "a.jpg")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	cm := &countCM{}
+	ip := &ImageProc{l: zerolog.Nop(), cma: cm}
+	cr := &checkRun{
+		cb:  &confBase{},
+		bc:  &baseCache{path: dir, bfs: os.DirFS(dir)},
+		run: &ScanRun{},
+	}
+
+	files := []struct {
+		path, name string
+		id         uint64
+	}{
+		{"all", "a.jpg", 1},
+		{"all", "b.jpg", 2},
+
+		// The same content as all/a.jpg, so not read again.
+		{"album", "a.jpg", 1},
+	}
+
+	for _, f := range files {
+		pc := &pathCache{Path: f.path}
+		fc := &fileCache{Name: f.name}
+
+		if err := ip.setFileHash(cr, pc, fc); err != nil {
+			t.Fatalf("setFileHash(%s/%s): %s", f.path, f.name, err)
+		}
+
+		if fc.ID != f.id || fc.updated&upFileHS == 0 {
+			t.Fatalf("%s/%s Expected ID %d != Got %d", f.path, f.name, f.id, fc.ID)
+		}
+	}
+
+	if cm.calls != 2 || cr.run.Linked != 1 {
+		t.Fatalf("Expected 2 hashed and 1 linked != Got %d and %d", cm.calls, cr.run.Linked)
+	}
+}
+
+// Same as countCM, along with counting those hashed by their pixels.
+type pixelCM struct {
+	countCM
+	pixels int
+}
+
+func (cm *pixelCM) CacheImagePixelsBase(base int, r io.Reader) (uint64, error) {
+	cm.pixels++
+	return cm.CacheImageRaw(r)
+}
+
+func TestHashPixels(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "small.jpg"), []byte("small"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "large.jpg"), make([]byte, 500), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := &pixelCM{}
+	ip := &ImageProc{l: zerolog.Nop(), cma: cm}
+	cr := &checkRun{
+		cb:  &confBase{HashPixels: true, HashPixelsMin: 100},
+		bc:  &baseCache{path: dir, bfs: os.DirFS(dir)},
+		run: &ScanRun{},
+	}
+
+	for _, name := range []string{"small.jpg", "large.jpg"} {
+		if err := ip.setFileHash(cr, &pathCache{}, &fileCache{Name: name}); err != nil {
+			t.Fatalf("setFileHash(%s): %s", name, err)
+		}
+	}
+
+	if cm.calls != 2 || cm.pixels != 1 {
+		t.Fatalf("Expected 2 hashed, 1 by pixels != Got %d, %d", cm.calls, cm.pixels)
+	}
+
+	// Not supported, so hashed as normal.
+	ip.cma = &countCM{}
+
+	if err := ip.setFileHash(cr, &pathCache{}, &fileCache{Name: "large.jpg"}); err != nil {
+		t.Fatalf("setFileHash without pixels: %s", err)
+	}
+}
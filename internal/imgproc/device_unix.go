@@ -0,0 +1,32 @@
+//go:build !windows
+
+package imgproc
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// func device {{{
+
+// Returns the device the file is on, used for confBase.OneFilesystem.
+func device(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(st.Dev), true
+} // }}}
+
+// func inode {{{
+
+// Returns the key of the file if it has other hard links, so the content only needs to be read once.
+func inode(info fs.FileInfo) (inodeKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inodeKey{}, false
+	}
+
+	return inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+} // }}}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package imgproc
+
+import (
+	"io/fs"
+)
+
+// func device {{{
+
+// Windows has no device numbers, so confBase.OneFilesystem is not supported.
+func device(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+} // }}}
+
+// func inode {{{
+
+// Hard links are not detected on Windows, so each is read the same as any other file.
+func inode(info fs.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+} // }}}
@@ -0,0 +1,115 @@
+package imgproc
+
+import (
+	"sort"
+)
+
+// func ImageProc.SetFastLane {{{
+
+// fn is given the hash IDs of new files as soon as they are committed by the fast lane (see confBaseYAML.FastLane),
+// such as to merge them and have the weighter poll for them right away.
+//
+// Called from its own goroutine, one call at a time. Any hashes committed while it runs are batched into the next call.
+func (ip *ImageProc) SetFastLane(fn func(ids []uint64)) {
+	ip.fMut.Lock()
+	ip.fastFn = fn
+	ip.fMut.Unlock()
+} // }}}
+
+// func newFiles {{{
+
+// Returns the files within the path seen this loop that are not yet in the database, sorted by name.
+func newFiles(pc *pathCache, loop uint32) []*fileCache {
+	var fresh []*fileCache
+
+	for _, fc := range pc.Files {
+		if fc.id == 0 && fc.loopF == loop && !fc.fileError {
+			fresh = append(fresh, fc)
+		}
+	}
+
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i].Name < fresh[j].Name })
+
+	return fresh
+} // }}}
+
+// func ImageProc.fastLane {{{
+
+// Queues the hashes of the new files just committed by checkBasePath(), see SetFastLane().
+//
+// Any that failed (such as having no tags) are simply left for the regular polls.
+func (ip *ImageProc) fastLane(cr *checkRun, fresh []*fileCache) {
+	var ids []uint64
+
+	for _, fc := range fresh {
+		if fc.id == 0 || fc.ID == 0 || fc.fileError {
+			continue
+		}
+
+		ids = append(ids, fc.ID)
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	cr.run.FastLane += len(ids)
+
+	ip.fMut.Lock()
+	defer ip.fMut.Unlock()
+
+	if ip.fastFn == nil {
+		return
+	}
+
+	if ip.fastIDs == nil {
+		ip.fastIDs = make(map[uint64]bool, len(ids))
+	}
+
+	for _, id := range ids {
+		ip.fastIDs[id] = true
+	}
+
+	// Already running, so it picks these up once done with its current batch.
+	if ip.fastRun {
+		return
+	}
+
+	ip.fastRun = true
+
+	go ip.runFastLane()
+} // }}}
+
+// func ImageProc.runFastLane {{{
+
+// Gives every queued hash to the SetFastLane() function, until none are left.
+func (ip *ImageProc) runFastLane() {
+	fl := ip.l.With().Str("func", "runFastLane").Logger()
+
+	for {
+		ip.fMut.Lock()
+
+		if len(ip.fastIDs) == 0 || ip.ctx.Err() != nil {
+			ip.fastIDs = nil
+			ip.fastRun = false
+			ip.fMut.Unlock()
+			return
+		}
+
+		fn := ip.fastFn
+
+		ids := make([]uint64, 0, len(ip.fastIDs))
+		for id := range ip.fastIDs {
+			ids = append(ids, id)
+		}
+
+		ip.fastIDs = nil
+		ip.fMut.Unlock()
+
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		fl.Debug().Int("hashes", len(ids)).Send()
+
+		fn(ids)
+	}
+} // }}}
@@ -0,0 +1,66 @@
+package imgproc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNewFiles(t *testing.T) {
+	pc := &pathCache{Files: map[string]*fileCache{
+		"b.jpg":     {Name: "b.jpg", loopF: 2},
+		"a.jpg":     {Name: "a.jpg", loopF: 2},
+		"known.jpg": {Name: "known.jpg", loopF: 2, id: 5},
+		"gone.jpg":  {Name: "gone.jpg", loopF: 1},
+		"bad.jpg":   {Name: "bad.jpg", loopF: 2, fileError: true},
+	}}
+
+	var got []string
+	for _, fc := range newFiles(pc, 2) {
+		got = append(got, fc.Name)
+	}
+
+	if want := []string{"a.jpg", "b.jpg"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("newFiles Expected %v != Got %v", want, got)
+	}
+}
+
+func TestFastLane(t *testing.T) {
+	ip := &ImageProc{l: zerolog.Nop(), ctx: context.Background()}
+	cr := &checkRun{run: &ScanRun{}}
+
+	fresh := []*fileCache{
+		{Name: "a.jpg", id: 1, ID: 30},
+		{Name: "b.jpg", id: 2, ID: 10},
+
+		// Same image as a.jpg.
+		{Name: "c.jpg", id: 3, ID: 30},
+
+		// Never made it to the database, such as having no tags.
+		{Name: "d.jpg", ID: 20},
+	}
+
+	// Nobody to give them to.
+	ip.fastLane(cr, fresh)
+
+	got := make(chan []uint64, 1)
+	ip.SetFastLane(func(ids []uint64) { got <- ids })
+
+	ip.fastLane(cr, fresh)
+
+	select {
+	case ids := <-got:
+		if want := []uint64{10, 30}; !reflect.DeepEqual(ids, want) {
+			t.Fatalf("fastLane Expected %v != Got %v", want, ids)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fastLane Expected a call != Got none")
+	}
+
+	if cr.run.FastLane != 6 {
+		t.Fatalf("fastLane run Expected 6 != Got %d", cr.run.FastLane)
+	}
+}
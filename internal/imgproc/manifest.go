@@ -0,0 +1,216 @@
+package imgproc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"frame/tags"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// The formats of a manifest, see confBaseYAML.Manifest.
+const (
+	manifestCSV = iota + 1
+	manifestJSON
+)
+
+// func manifestFormat {{{
+
+// Returns the format of the manifest from its extension.
+func manifestFormat(name string) (int, error) {
+	switch strings.ToLower(pathpkg.Ext(name)) {
+	case ".csv":
+		return manifestCSV, nil
+	case ".json":
+		return manifestJSON, nil
+	}
+
+	return 0, errors.New("manifest must be .csv or .json")
+} // }}}
+
+// func parseManifest {{{
+
+// Returns the tag names of each file in the manifest, keyed by its path within the base.
+func parseManifest(r io.Reader, format int) (map[string][]string, error) {
+	out := make(map[string][]string)
+
+	add := func(path string, names []string) {
+		path = pathpkg.Clean(strings.TrimLeft(strings.ReplaceAll(path, "\\", "/"), "/"))
+
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				out[path] = append(out[path], name)
+			}
+		}
+	}
+
+	if format == manifestJSON {
+		var in map[string][]string
+
+		if err := json.NewDecoder(r).Decode(&in); err != nil {
+			return nil, err
+		}
+
+		for path, names := range in {
+			add(path, names)
+		}
+
+		return out, nil
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for first := true; ; first = false {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rec) < 2 || rec[0] == "" || (first && strings.EqualFold(rec[0], "path")) {
+			continue
+		}
+
+		for _, col := range rec[1:] {
+			add(rec[0], strings.Split(col, ";"))
+		}
+	}
+
+	return out, nil
+} // }}}
+
+// func ImageProc.loadManifest {{{
+
+// Loads the manifest from the base, converting the tag names into tags.
+func (ip *ImageProc) loadManifest(bfs fs.FS, name string) (map[string]tags.Tags, error) {
+	format, err := manifestFormat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := bfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	names, err := parseManifest(f, format)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]tags.Tags, len(names))
+
+	for path, strs := range names {
+		tgs, err := tags.StringsToTags(strs, ip.tm)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tgs) > 0 {
+			out[path] = tgs
+		}
+	}
+
+	return out, nil
+} // }}}
+
+// func ImageProc.checkManifest {{{
+
+// Loads the manifest of the base if it changed since the last check, flagging every file to have its tags
+// recalculated if any of the tags within changed.
+//
+// Much like a sidecar only the modified time is checked, and a manifest that fails to load leaves the tags as they
+// were rather then failing the whole scan.
+func (ip *ImageProc) checkManifest(cr *checkRun) {
+	var name string
+
+	bc := cr.bc
+
+	if cr.cb != nil {
+		name = cr.cb.Manifest
+	}
+
+	fl := ip.l.With().Str("func", "checkManifest").Int("base", bc.Base).Str("manifest", name).Logger()
+
+	// Changed in the configuration, so forget the old.
+	if name != bc.manifest {
+		bc.manifest = name
+		bc.manifestTS = emptyTime
+
+		if bc.manifestTags != nil {
+			bc.manifestTags = nil
+			bc.retag = true
+		}
+	}
+
+	if name == "" {
+		return
+	}
+
+	info, err := fs.Stat(bc.bfs, name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fl.Err(err).Msg("Stat")
+			return
+		}
+
+		// Removed, so the files no longer have its tags.
+		if bc.manifestTags != nil {
+			fl.Info().Msg("removed")
+			bc.manifestTags = nil
+			bc.manifestTS = emptyTime
+			bc.retag = true
+		}
+
+		return
+	}
+
+	mtime := info.ModTime().UTC().Round(time.Second)
+	if mtime.Equal(bc.manifestTS) {
+		return
+	}
+
+	mt, err := ip.loadManifest(bc.bfs, name)
+	if err != nil {
+		fl.Err(err).Msg("loadManifest")
+		return
+	}
+
+	bc.manifestTS = mtime
+
+	if sameManifest(bc.manifestTags, mt) {
+		return
+	}
+
+	fl.Info().Int("files", len(mt)).Msg("changed")
+	bc.manifestTags = mt
+	bc.retag = true
+} // }}}
+
+// func sameManifest {{{
+
+func sameManifest(a, b map[string]tags.Tags) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, tgs := range a {
+		if !tgs.Equal(b[path]) {
+			return false
+		}
+	}
+
+	return true
+} // }}}
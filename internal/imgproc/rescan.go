@@ -0,0 +1,152 @@
+package imgproc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"strings"
+	"sync/atomic"
+)
+
+// Returned by ScanBase() and ScanPath() when the base is already being scanned.
+var ErrScanRunning = errors.New("scan already running")
+
+// Returned by ScanBase() and ScanPath() when the base is scanned by another worker, see confWorkersYAML.
+var ErrNotAssigned = errors.New("base assigned to another worker")
+
+// func ImageProc.ScanBase {{{
+
+// Starts a scan of the base now, rather then waiting for its next interval.
+//
+// With full every file is checked, otherwise it is the same partial scan as the interval would run. Returns once the
+// scan has started, see ScanRuns() for how it went.
+//
+// If the base is already being scanned nothing is done and ErrScanRunning is returned.
+func (ip *ImageProc) ScanBase(id int, full bool) error {
+	bc, err := ip.scanBaseCache(id)
+	if err != nil {
+		return err
+	}
+
+	// Checked first, as a running scan holds bMut until it finishes.
+	if atomic.LoadUint32(&bc.checkRun) != 0 {
+		return ErrScanRunning
+	}
+
+	if full {
+		bc.bMut.Lock()
+		bc.force = true
+		bc.bMut.Unlock()
+	}
+
+	go ip.checkBase(bc, "")
+
+	return nil
+} // }}}
+
+// func ImageProc.ScanPath {{{
+
+// Starts a full scan of just the path (relative to the base, forward slashes) and everything below it, such as after
+// changing the tags of a single directory.
+//
+// Every other path is left as it is, the same as if it had not changed. An empty path or "." is a full scan of the
+// whole base, see ScanBase().
+//
+// The directory containing path needs to have already been scanned, as its tags are inherited.
+func (ip *ImageProc) ScanPath(base int, path string) error {
+	path = strings.Trim(path, "/")
+	if path == "" || path == "." {
+		return ip.ScanBase(base, true)
+	}
+
+	path = pathpkg.Clean(path)
+	if !fs.ValidPath(path) {
+		return fmt.Errorf("invalid path %q", path)
+	}
+
+	bc, err := ip.scanBaseCache(base)
+	if err != nil {
+		return err
+	}
+
+	if atomic.LoadUint32(&bc.checkRun) != 0 {
+		return ErrScanRunning
+	}
+
+	go ip.checkBase(bc, path)
+
+	return nil
+} // }}}
+
+// func ImageProc.scanBaseCache {{{
+
+func (ip *ImageProc) scanBaseCache(id int) (*baseCache, error) {
+	if atomic.LoadUint32(&ip.closed) != 0 {
+		return nil, errors.New("imageproc closed")
+	}
+
+	if _, ok := ip.getConf().Bases[id]; !ok {
+		return nil, fmt.Errorf("no base %d", id)
+	}
+
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	bc, ok := ca.bases[id]
+	ca.cMut.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("base %d not loaded", id)
+	}
+
+	if !ip.ownsBase(id) {
+		return nil, ErrNotAssigned
+	}
+
+	return bc, nil
+} // }}}
+
+// func ImageProc.checkSubtree {{{
+
+// The scan for ScanPath(), run by checkBase() in place of a full or partial.
+//
+// Marks every cached path outside of path as seen (so nothing outside of it is disabled), then checks path the same as
+// a full scan would.
+func (ip *ImageProc) checkSubtree(cr *checkRun, path string) error {
+	parent, ok := cr.bc.Paths[pathpkg.Dir(path)]
+	if !ok || parent.disabled {
+		return fmt.Errorf("%s has not been scanned yet, scan the base first", pathpkg.Dir(path))
+	}
+
+	// The walk policy of the base still applies, such as maxdepth.
+	info, err := ip.walkDir(cr, path)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		return fmt.Errorf("%s is not walked by the base", path)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	prefix := path + "/"
+
+	for name, pc := range cr.bc.Paths {
+		if name == path || strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		markPath(cr, pc)
+	}
+
+	pc, err := ip.getPathCache(cr, path, parent.Tags)
+	if err != nil {
+		return err
+	}
+
+	return ip.checkBasePath(cr, pc, path, true)
+} // }}}
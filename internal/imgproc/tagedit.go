@@ -0,0 +1,360 @@
+package imgproc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frame/tags"
+	"frame/yconf"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// type TagEditor struct {{{
+
+// Changes the tags of files within a base from outside of ImageProc, by writing their sidecars or the manifest of the
+// base. Used by "frame tag".
+//
+// ImageProc picks up the changes the same as if they were made by hand.
+type TagEditor struct {
+	cb *confBase
+} // }}}
+
+// func NewTagEditor {{{
+
+// Loads the ImageProc configuration at confPath (the same as given to New()) for the base.
+func NewTagEditor(confPath string, base int, l *zerolog.Logger, ctx context.Context) (*TagEditor, error) {
+	ip := &ImageProc{
+		l: l.With().Str("mod", "imgproc").Logger(),
+	}
+
+	ycc := ycCallers
+	ycc.Convert = ip.yconfConvert
+
+	yc, err := yconf.New(confPath, ycc, &ip.l, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yc.CheckConf(); err != nil {
+		return nil, err
+	}
+
+	co, ok := yc.Get().(*conf)
+	if !ok {
+		return nil, errors.New("invalid config loaded")
+	}
+
+	cb, ok := co.Bases[base]
+	if !ok {
+		return nil, fmt.Errorf("no base %d", base)
+	}
+
+	return &TagEditor{cb: cb}, nil
+} // }}}
+
+// func TagEditor.Path {{{
+
+// Returns the path of the base.
+func (te *TagEditor) Path() string {
+	return te.cb.Path
+} // }}}
+
+// func TagEditor.Match {{{
+
+// Returns every image within the base matching the pattern, relative to the base using forward slashes.
+//
+// See matchPath() for how the pattern is matched.
+func (te *TagEditor) Match(pattern string) ([]string, error) {
+	var files []string
+
+	// Check the pattern itself, otherwise a bad one just matches nothing.
+	if _, err := pathpkg.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	err := fs.WalkDir(os.DirFS(te.cb.Path), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		ft, _ := getFileType(d.Name(), te.cb.Exts)
+		if ft != 1 && (ft != 3 || !te.cb.EnableRaw) {
+			return nil
+		}
+
+		if matchPath(pattern, path) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+} // }}}
+
+// func TagEditor.Sidecars {{{
+
+// Adds and removes the tags from the sidecar of each file, returning those that changed.
+//
+// A sidecar is created if needed, and removed if it no longer has any tags. Nothing is written with dryRun.
+func (te *TagEditor) Sidecars(files, add, remove []string, dryRun bool) ([]string, error) {
+	var changed []string
+
+	for _, file := range files {
+		side := filepath.Join(te.cb.Path, filepath.FromSlash(file)) + ".txt"
+
+		old, err := readSidecar(side)
+		if err != nil {
+			return changed, err
+		}
+
+		names, ok := editNames(old, add, remove)
+		if !ok {
+			continue
+		}
+
+		changed = append(changed, file)
+
+		if dryRun {
+			continue
+		}
+
+		if len(names) == 0 {
+			if err := os.Remove(side); err != nil {
+				return changed, err
+			}
+
+			continue
+		}
+
+		if err := ioutil.WriteFile(side, []byte(strings.Join(names, "\n")+"\n"), 0644); err != nil {
+			return changed, err
+		}
+	}
+
+	return changed, nil
+} // }}}
+
+// func TagEditor.Manifest {{{
+
+// Adds and removes the tags of each file within the manifest of the base, returning those that changed.
+//
+// The manifest is written back sorted by path, so any comments or ordering within are lost. Nothing is written with
+// dryRun.
+func (te *TagEditor) Manifest(files, add, remove []string, dryRun bool) ([]string, error) {
+	var changed []string
+
+	if te.cb.Manifest == "" {
+		return nil, errors.New("base has no manifest")
+	}
+
+	format, err := manifestFormat(te.cb.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(te.cb.Path, filepath.FromSlash(te.cb.Manifest))
+
+	entries := make(map[string][]string)
+
+	if data, err := ioutil.ReadFile(name); err == nil {
+		if entries, err = parseManifest(bytes.NewReader(data), format); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, file := range files {
+		names, ok := editNames(entries[file], add, remove)
+		if !ok {
+			continue
+		}
+
+		changed = append(changed, file)
+
+		if len(names) == 0 {
+			delete(entries, file)
+		} else {
+			entries[file] = names
+		}
+	}
+
+	if dryRun || len(changed) == 0 {
+		return changed, nil
+	}
+
+	data, err := writeManifest(entries, format)
+	if err != nil {
+		return changed, err
+	}
+
+	return changed, ioutil.WriteFile(name, data, 0644)
+} // }}}
+
+// func TagEditor.Touch {{{
+
+// Sets the modified time of the directory of each file to now, so the next partial scan of the base checks them.
+//
+// A sidecar being changed rather then created does not change the directory, so would otherwise wait for a full scan.
+func (te *TagEditor) Touch(files []string) error {
+	now := time.Now()
+	done := make(map[string]bool, len(files))
+
+	for _, file := range files {
+		dir := pathpkg.Dir(file)
+		if done[dir] {
+			continue
+		}
+
+		done[dir] = true
+
+		if err := os.Chtimes(filepath.Join(te.cb.Path, filepath.FromSlash(dir)), now, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func matchPath {{{
+
+// Returns if the path (relative to the base, forward slashes) matches the pattern.
+//
+// The pattern is matched the same as path.Match() against the whole path, and against each directory the file is
+// within, so "2019/*" matches every file below any directory within 2019. A pattern without a slash is also matched
+// against just the file name, so "*.png" matches within every directory.
+func matchPath(pattern, path string) bool {
+	pattern = strings.Trim(pattern, "/")
+
+	if !strings.Contains(pattern, "/") {
+		if ok, _ := pathpkg.Match(pattern, pathpkg.Base(path)); ok {
+			return true
+		}
+	}
+
+	for p := path; p != "." && p != "/"; p = pathpkg.Dir(p) {
+		if ok, _ := pathpkg.Match(pattern, p); ok {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func editNames {{{
+
+// Returns the tag names with add added and remove removed, along with if that changed anything.
+//
+// Names are compared normalized the same as the TagManager does (see tags.Normalize()), and keep their order.
+func editNames(names, add, remove []string) ([]string, bool) {
+	var out []string
+	var changed bool
+
+	has := make(map[string]bool, len(names)+len(add))
+
+	drop := make(map[string]bool, len(remove))
+	for _, name := range remove {
+		drop[tags.Normalize(name)] = true
+	}
+
+	for _, name := range names {
+		key := tags.Normalize(name)
+
+		if drop[key] {
+			changed = true
+			continue
+		}
+
+		has[key] = true
+		out = append(out, name)
+	}
+
+	for _, name := range add {
+		name = strings.TrimSpace(name)
+		key := tags.Normalize(name)
+
+		if name == "" || has[key] || drop[key] {
+			continue
+		}
+
+		has[key] = true
+		out = append(out, name)
+		changed = true
+	}
+
+	return out, changed
+} // }}}
+
+// func readSidecar {{{
+
+// Returns the tag names within the sidecar, none if it does not exist.
+func readSidecar(name string) ([]string, error) {
+	var names []string
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			names = append(names, line)
+		}
+	}
+
+	return names, sc.Err()
+} // }}}
+
+// func writeManifest {{{
+
+// The reverse of parseManifest(), sorted by path.
+func writeManifest(entries map[string][]string, format int) ([]byte, error) {
+	if format == manifestJSON {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+
+	paths := make([]string, 0, len(entries))
+	for path := range entries {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"path", "tags"})
+
+	for _, path := range paths {
+		cw.Write([]string{path, strings.Join(entries[path], ";")})
+	}
+
+	cw.Flush()
+
+	return buf.Bytes(), cw.Error()
+} // }}}
@@ -0,0 +1,745 @@
+package imgproc
+
+import (
+	"context"
+	"frame/internal/errlog"
+	"frame/internal/pgdb"
+	"frame/internal/scheduler"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"io/fs"
+	"math/rand"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type confBaseYAML struct {
+	Base int `yaml:"base"`
+
+	// The time between when we check the base for changes.
+	// Minimum is 30 seconds for sanity, no maximum.
+	//
+	// Default if not set is 5 minutes.
+	//
+	// Such as "90s" or "1h".
+	CheckInt yconf.Duration `yaml:"checkinterval"`
+
+	// The name of the file within the path that contains all tags
+	// for that path and any subdirectories within.
+	//
+	// Each subdirectory can have their own tag file that will replace
+	// all tags for that and further subdirectories.
+	//
+	// This is defaulted to "tags.txt" in yconfConvert() if not set.
+	//
+	// Each base *must* have at least 1 tagfile for its root path.
+	// Subdirectory tag files are optional.
+	TagFile string `yaml:"tagfile"`
+
+	// If set then RAW camera files (.cr2, .nef, .arw, .dng) are also processed.
+	//
+	// We do not develop the RAW data itself, rather we use the JPEG preview the camera embeds within the file.
+	EnableRaw bool `yaml:"enableraw"`
+
+	// The file extensions treated as images within this base, such as [jpg, jpeg, png, webp, tif].
+	//
+	// If not set the default is jpg, jpeg, gif, png, webp, tif and tiff, along with the RAW extensions above.
+	//
+	// Any RAW extensions listed here still need EnableRaw.
+	Extensions []string `yaml:"extensions"`
+
+	// Maps an extension to the decoder used for it, only needed for extensions we do not already know.
+	//
+	// Known extensions are jpg, jpeg, jpe, jfif, png, gif, webp, tif, tiff, bmp, cr2, nef, arw and dng.
+	//
+	// The decoders are jpeg, png, gif, webp, tiff, bmp and raw.
+	Decoders map[string]string `yaml:"decoders"`
+
+	// External commands to decode extensions we can not decode ourselves, such as HEIC.
+	//
+	// Maps the extension to the command, which is run directly (not through a shell) with "{file}" replaced by
+	// the full path of the file. Whatever it writes to stdout is then decoded as normal, so it must be one of
+	// jpeg, png, gif, webp or a binary PPM/PGM. Such as -
+	//
+	//   heic: "heif-dec {file} -o -"
+	//   cr3: "dcraw -c -w {file}"
+	//
+	// Extensions with a command are always images, they do not need to be in Extensions nor have a decoder.
+	// Commands also take priority over the embedded preview of RAW files, so a RAW extension can be given one.
+	Commands map[string]string `yaml:"commands"`
+
+	// If set then anything a command writes to stderr, even when it succeeds, fails the file.
+	//
+	// Some decoders only warn about truncated or corrupt files (such as "Premature end of JPEG file") and pad out
+	// the rest of the image, which would otherwise be cached as is. Without this such warnings are only logged.
+	StrictDecode bool `yaml:"strictdecode"`
+
+	// If set then when a file has changed we first check a cheap fingerprint of the file (its size along with the
+	// first and last FingerBytes of the file) before doing a full hash of the contents.
+	//
+	// If the fingerprint is the same as what we last seen, the file is considered unchanged and the full hash is skipped.
+	//
+	// The full hash is still always done the first time we see a file, as that is when the image is actually cached.
+	//
+	// Very useful for large bases over slow network mounts, where something touching the modified time of files
+	// would otherwise cause every file to be read in full again.
+	Fingerprint bool `yaml:"fingerprint"`
+
+	// How many bytes from both the start and end of the file to include in the fingerprint.
+	//
+	// Defaults to 64KiB if not set.
+	FingerBytes int64 `yaml:"fingerprintbytes"`
+
+	// If set the image is identified by its decoded pixels (after being rotated by its EXIF, before being resized)
+	// rather then the bytes of the file, for libraries exported again and again. The same photo saved with different
+	// metadata or compression, such as with its EXIF edited or a PNG optimized, is then only cached once.
+	//
+	// A lossy re-encode (such as a JPEG saved again) changes the pixels, so is still a different image.
+	//
+	// HashPixelsMin limits this to files of at least that many bytes, as the small ones are cheap to keep twice.
+	// Default of 0 is every file.
+	//
+	// Only files hashed after this is changed are affected, such as new or modified ones. Needs a CacheManager that
+	// supports it, otherwise the file is hashed as normal with a warning.
+	HashPixels    bool  `yaml:"hashpixels"`
+	HashPixelsMin int64 `yaml:"hashpixelsmin"`
+
+	// If set then any path with files we have never seen before is committed to the database as soon as its walked,
+	// rather then once the whole scan is done, and the new images are then merged and given to the weighter right
+	// away (see ImageProc.SetFastLane()).
+	//
+	// So the photos of today still show up within minutes while a huge base is in the middle of a full scan that
+	// could take hours. Not used for the first scan of a base, where every file is new.
+	FastLane bool `yaml:"fastlane"`
+
+	// Protection for network mounts going away.
+	//
+	// If a check sees fewer files then this fraction of the files we already know about, we assume the mount
+	// is stale/offline and skip updating the database entirely rather then disabling everything.
+	//
+	// A check seeing no files at all when we know of some is always treated this way, this lets you be stricter,
+	// such as 0.5 to require at least half of the known files be seen.
+	//
+	// Must be between 0 and 1.
+	StaleFraction float64 `yaml:"stalefraction"`
+
+	// Grace period before files and paths no longer seen are disabled in the database.
+	//
+	// DisableLoops is how many checks in a row it must not be seen, DisableAfter is how long (such as "24h") it must
+	// not be seen. If both are set then both must be met.
+	//
+	// Neither set (the default) disables right away, the first check something is not seen.
+	//
+	// Helps avoid thousands of rows flapping between enabled and disabled from some transient IO problem.
+	DisableLoops uint32         `yaml:"disableloops"`
+	DisableAfter yconf.Duration `yaml:"disableafter"`
+
+	// Tags taken from the file names, merged with the path and sidecar tags.
+	//
+	// FilenameBrackets takes a comma separated list within square brackets, so "IMG_1234 [beach,family].jpg"
+	// is tagged beach and family.
+	//
+	// FilenameTags is a list of regular expressions matched against the file name (without its extension), each
+	// capture group that matches is a tag. Like the brackets, a capture containing commas is split into multiple tags.
+	// Such as "^(\d{4})-\d{2}-\d{2}" to tag the year from "2019-07-04 fireworks.jpg".
+	FilenameBrackets bool     `yaml:"filenamebrackets"`
+	FilenameTags     []string `yaml:"filenametags"`
+
+	// A single file within the base giving the tags of many files, rather then a sidecar for each.
+	//
+	// The name is relative to the base, and must end in .csv or .json. A CSV has the path of each file (relative to
+	// the base, such as "2019/beach.jpg") followed by its tags, one per column or several within a column separated
+	// by semicolons. A header row starting with "path" and lines starting with # are skipped. JSON is an object of
+	// each path to a list of its tags.
+	//
+	// Merged with the path, sidecar and file name tags. Checked for changes every scan, same as sidecars.
+	Manifest string `yaml:"manifest"`
+
+	// How the base is walked.
+	//
+	// FollowSymlinks walks into symbolic links to directories, by default they are skipped. Symbolic links to files
+	// are always followed. Any link back to a directory already being walked is skipped with a warning, so loops
+	// are safe.
+	//
+	// OneFilesystem skips any directory on a different filesystem then the base itself, such as a mount point
+	// within the base. Not supported on Windows.
+	//
+	// MaxDepth is the most directories deep below the base to walk, 0 (the default) has no limit.
+	FollowSymlinks bool `yaml:"followsymlinks"`
+	OneFilesystem  bool `yaml:"onefilesystem"`
+	MaxDepth       int  `yaml:"maxdepth"`
+
+	// For file systems that do not update the modified time of a directory when a file within is deleted, such as
+	// some network file systems, which partial scans depend on.
+	//
+	// TombstoneSample is how many known files, chosen at random, to stat on each partial scan. Any path with a file
+	// found missing is then scanned as if it changed. Cheap enough to leave on, over time every file gets checked.
+	//
+	// FullEvery forces a full scan every this many scans, a slower but certain catch-all. 0 (the default) never
+	// forces one.
+	TombstoneSample int    `yaml:"tombstonesample"`
+	FullEvery       uint32 `yaml:"fullevery"`
+
+	// The database and queries for this base, if different from the global ones.
+	//
+	// Either or both can be set, and only the queries that differ need to be given. Anything not set uses the
+	// global one, so a base can write to a different server with the same queries, or to a different table
+	// on the same server.
+	Database string       `yaml:"database" log:"redact"`
+	Queries  *confQueries `yaml:"queries"`
+}
+
+type confQueries struct {
+	FilesSelect  string `yaml:"files-select"`
+	FilesInsert  string `yaml:"files-insert"`
+	FilesUpdate  string `yaml:"files-update"`
+	FilesDisable string `yaml:"files-disable"`
+
+	PathsSelect  string `yaml:"paths-select"`
+	PathsInsert  string `yaml:"paths-insert"`
+	PathsUpdate  string `yaml:"paths-update"`
+	PathsDisable string `yaml:"paths-disable"`
+
+	// Optional, saves a summary row after each check run.
+	RunsInsert string `yaml:"runs-insert"`
+
+	// Optional, saves a row for every file added, updated or disabled along with its old and new hash and tags.
+	//
+	// Written within the same transaction as the change itself, so the log only ever has what was committed.
+	ChangesInsert string `yaml:"changes-insert"`
+
+	// Optional, both are needed to enable resuming an interrupted full scan.
+	//
+	// See checkBase() and scanDone() for details.
+	CheckpointSelect string `yaml:"checkpoint-select"`
+	CheckpointUpdate string `yaml:"checkpoint-update"`
+
+	// Only used with workers, see confWorkersYAML and syncWorkers().
+	//
+	// Register and assigned are needed by every worker, live, current and assign only by the coordinator.
+	WorkersRegister string `yaml:"workers-register"`
+	WorkersAssigned string `yaml:"workers-assigned"`
+	WorkersLive     string `yaml:"workers-live"`
+	WorkersCurrent  string `yaml:"workers-current"`
+	WorkersAssign   string `yaml:"workers-assign"`
+}
+
+// Spreads the scanning of the bases over several instances sharing the same database and cache.
+//
+// Each instance registers itself as a worker along with the bases within its own configuration, which are the ones it
+// can reach. The coordinator then assigns each base to one live worker, and a worker only ever scans the bases
+// assigned to it. Several smaller bases (such as one per year of an archive) spread better then a single huge one.
+//
+// Exactly one instance should be the coordinator. Should a worker stop sending heartbeats its bases are given to
+// another that can reach them, and it gets them back (or others) if it returns.
+type confWorkersYAML struct {
+	// Unique to each worker, defaults to the host name.
+	Name string `yaml:"name"`
+
+	// If this instance assigns the bases, it is also a worker itself.
+	Coordinator bool `yaml:"coordinator"`
+
+	// How often the worker registers itself and checks which bases it has been assigned.
+	//
+	// Default if not set is 30 seconds.
+	Heartbeat yconf.Duration `yaml:"heartbeat"`
+
+	// How long after its last heartbeat a worker is no longer considered live, default is 3 heartbeats.
+	Expire yconf.Duration `yaml:"expire"`
+}
+
+// Pre-converted YAML-friendly configuration.
+type confYAML struct {
+	Database string                   `yaml:"database" log:"redact"`
+	Queries  *confQueries             `yaml:"queries"`
+	Bases    map[string]*confBaseYAML `yaml:"bases"`
+
+	// Not set (the default) scans every base, see confWorkersYAML.
+	Workers *confWorkersYAML `yaml:"workers"`
+}
+
+type confBase struct {
+	Base      int
+	Path      string
+	TagFile   string
+	CheckInt  time.Duration
+	EnableRaw bool
+
+	// Extension (with leading dot) to file type, see getFileType().
+	//
+	// nil if the base uses the defaults.
+	Exts map[string]int
+
+	// Extension (with leading dot) to the command and its arguments, see confBaseYAML.Commands.
+	Commands     map[string][]string
+	StrictDecode bool
+
+	Fingerprint bool
+	FingerBytes int64
+
+	HashPixels    bool
+	HashPixelsMin int64
+
+	FastLane bool
+
+	StaleFraction float64
+
+	DisableLoops uint32
+	DisableAfter time.Duration
+
+	// From FilenameBrackets and FilenameTags, see filenameTags().
+	//
+	// nil if the base does not tag from file names.
+	NameTags []*regexp.Regexp
+
+	// See confBaseYAML.Manifest, empty if the base has none.
+	Manifest string
+
+	FollowSymlinks bool
+	OneFilesystem  bool
+	MaxDepth       int
+
+	TombstoneSample int
+	FullEvery       uint32
+
+	// Only set if the base has its own, see conf.baseTarget().
+	Database string `log:"redact"`
+	Queries  *confQueries
+}
+
+type confWorkers struct {
+	Name        string
+	Coordinator bool
+	Heartbeat   time.Duration
+	Expire      time.Duration
+}
+
+type conf struct {
+	Bases    map[int]*confBase
+	Queries  *confQueries
+	Database string `log:"redact"`
+
+	// nil if not using workers.
+	Workers *confWorkers
+}
+
+// What is generally needed for the functions within the check() line.
+type checkRun struct {
+	hash      int
+	cachePath string
+	cb        *confBase
+	bc        *baseCache
+
+	// Set during a full scan when we commit each path as soon as its done, saving a checkpoint after each.
+	checkpoint bool
+
+	// Set when any path with new files is committed as soon as its done, see confBaseYAML.FastLane.
+	fastLane bool
+
+	// The last path completed by an interrupted full scan, anything before this in the walk is skipped.
+	resume string
+
+	// The report for this run, see ScanRun.
+	run *ScanRun
+
+	// The span covering the run, ended by finishRun(). Any spans for the run are children of ctx.
+	ctx  context.Context
+	span trace.Span
+
+	// The directories currently being walked by checkBasePath(), from the base down, for loop detection.
+	walk []fs.FileInfo
+
+	// The device of the base, for confBase.OneFilesystem.
+	dev   uint64
+	devOK bool
+
+	// Paths with a file found missing by sampleTombstones(), checked by a partial scan even if unchanged.
+	tombstones map[string]bool
+
+	// Files with hard links already hashed this run, see setFileHash().
+	inodes map[inodeKey]linkSeen
+}
+
+// type inodeKey struct {{{
+
+// A file with more then one hard link, see inode().
+type inodeKey struct {
+	dev uint64
+	ino uint64
+
+	// Lower case, as the extension decides how the file is decoded (see checkRun.command()) so the same content can
+	// still give different images.
+	ext string
+} // }}}
+
+// type linkSeen struct {{{
+
+type linkSeen struct {
+	id     uint64
+	fprint string
+} // }}}
+
+// type ScanRun struct {{{
+
+// A summary of a single check run of a base.
+//
+// Saved to the database if the runs-insert query is set, and the most recent are kept in memory for ScanRuns().
+type ScanRun struct {
+	Base  int
+	Start time.Time
+	Took  time.Duration
+
+	// If this was a full scan rather then a partial.
+	Full bool
+
+	// Set if only this path (and everything below it) was scanned, see ScanPath().
+	Path string
+
+	// Files seen this run, and what was done with them in the database.
+	Seen     int
+	Added    int
+	Updated  int
+	Disabled int
+
+	// Files that failed to process this run.
+	Errors int
+
+	// Files that were hard links to another already hashed this run, so were not read again.
+	Linked int
+
+	// New files committed early through the fast lane, see confBaseYAML.FastLane.
+	FastLane int
+
+	// The base looked to be offline (see stalefraction), so the database was not touched.
+	Stale bool
+
+	// If the run itself failed, why.
+	Error string
+} // }}}
+
+// How many ScanRuns we keep in memory.
+const maxScanRuns = 50
+
+// Convert and Notify are set in New(), as they need access to the loaded *ImageProc.
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Changed: yconfChanged,
+}
+
+// type ImageProc struct {{{
+
+type ImageProc struct {
+	l zerolog.Logger
+
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
+
+	// The last time gbGet() was called, a time.Time value is stored here.
+	//
+	// Check that function for details on why this exists.
+	dbTime atomic.Value
+
+	cPath string
+
+	co atomic.Value
+
+	ca *cache
+
+	yc *yconf.YConf
+
+	tm types.TagManager
+
+	cma types.CacheManager
+
+	// Runs the check for each base at its interval.
+	sch *scheduler.Scheduler
+
+	// Errors with individual files, grouped so a bad mount failing every file does not flood the log.
+	errs *errlog.Aggregator
+
+	// The database of each base that has its own, see conf.baseTarget().
+	//
+	// Bases not in here use db above. Need dbMut to access.
+	dbMut   sync.Mutex
+	baseDBs map[int]*baseDB
+
+	// The last configuration reload, the bits that changed.
+	//
+	// Use atomic functions to access and change this value as they are used in multiple locations.
+	//
+	// Avoding race conditions good.
+	ucBits uint64
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	// The most recent check runs, newest last.
+	//
+	// Need rMut to access.
+	rMut sync.Mutex
+	runs []ScanRun
+
+	// The bases assigned to us when using workers, a map[int]bool value is stored here, see ownsBase().
+	assigned atomic.Value
+
+	// The bases we had last heartbeat, to know which are newly assigned.
+	//
+	// Need wMut to access.
+	wMut  sync.Mutex
+	owned map[int]bool
+
+	// The hashes waiting on the fast lane, see SetFastLane().
+	//
+	// Need fMut to access.
+	fMut    sync.Mutex
+	fastFn  func([]uint64)
+	fastIDs map[uint64]bool
+	fastRun bool
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
+
+// const conf update bits {{{
+
+// Update bits used when the configuration reloads
+const (
+	ucDBConn   = 1 << iota // When the database connection has changed
+	ucDBQuery  = 1 << iota // When at least one of the database queries have changed
+	ucBaseCI   = 1 << iota // One of the base check intervals changed
+	ucBaseRaw  = 1 << iota // One of the bases enableraw or extensions changed
+	ucBaseName = 1 << iota // One of the bases file name tags changed
+) // }}}
+
+// const cache update bits {{{
+
+// Update bits use in fileCache
+const (
+	// Bits specific to the image files
+	upFileTS = 1 << iota // The file modified time
+	upFileCT = 1 << iota // The file calculated tags changed
+	upFileHS = 1 << iota // The file hash changed
+
+	// Bits specific to image sidecar files
+	upSideTS = 1 << iota // The sidecar modified time
+	upSideTG = 1 << iota // The sidecar tags
+
+	// Bits specific to pathCache.updated
+	upPathTG = 1 << iota // Tags for the path itself changed
+	upPathTS = 1 << iota // The directory modified time
+	upPathFI = 1 << iota // Files within a path changed
+	upPathNL = 1 << iota // Path not seen this loop, disable it
+) // }}}
+
+// type baseDB struct {{{
+
+// A database for a single base, along with what it was connected with to know when that changes.
+type baseDB struct {
+	db       *pgdb.DB
+	database string
+	queries  confQueries
+} // }}}
+
+// type fileCache struct {{{
+
+type fileCache struct {
+	// Name of the file
+	Name string
+
+	// Last updated time for the file itself
+	FileTS time.Time
+
+	// Last updated time of the sidecar
+	SideTS time.Time
+
+	// Any tags loaded from the sidecar, the .txt or .xmp file.
+	SideTG tags.Tags
+
+	// These are the calculated tags - They combine the path tags, and the above file and sidecar tags.
+	CTags tags.Tags
+
+	// The files calculated hash ID
+	ID uint64
+
+	// The hash ID and tags last committed to the database, the "old" side of the change log.
+	//
+	// Only used when the changes-insert query is set.
+	dbID   uint64
+	dbTags tags.Tags
+
+	// The last fingerprint calculated for the file, only used when the base has fingerprint enabled.
+	//
+	// This is not stored in the database, so after a restart the first change to a file will always do a full hash.
+	fprint string
+
+	// If this is set, then the file has some type of error and no further attempt to open it should be attempted.
+	//
+	// The file however will remain in memory and should the timestamp change, it will be looked at again.
+	//
+	// When a file is in error condition it also is ignored by any changes to the database.
+	// So any existing database status is left as-is.
+	//
+	// This is helpful for remote content that you have no control over, and are unable to "clean up" any invalid files, and
+	// don't want them to continue to produce errors.
+	fileError bool
+
+	// A bitflag that says what specifically was update this loop.
+	//
+	// Helps in knowing exactly what columns in the database changed, if we need to rehash, etc.
+	updated uint32
+
+	// What loop we last saw this file on
+	loopF uint32
+
+	// What loop we last saw the sidecar on
+	loopS uint32
+
+	// If the file is disabled in the database or not.
+	disabled bool
+
+	// The ID in the database for this specific file entry, used in UPDATE queries.
+	id uint64
+
+	// Used for the disable grace period, see confBaseYAML.DisableLoops.
+	//
+	// How many loops in a row the file has not been seen, when it was first missed, and which loop it
+	// was last counted on.
+	missed      uint32
+	missedSince time.Time
+	missedLoop  uint32
+} // }}}
+
+// type pathCache struct {{{
+
+// Per-path cache.
+type pathCache struct {
+	Path    string
+	Changed time.Time
+
+	// If there is a tagfile for this path.
+	SideTS time.Time
+
+	Tags tags.Tags
+
+	Files map[string]*fileCache
+
+	// If the path is disabled in the database or not.
+	disabled bool
+
+	// The ID in the database.
+	id uint64
+
+	// If a change is detected during check(), updated is set to what changed.
+	updated uint32
+
+	// What loop we last saw this path on
+	loop uint32
+
+	// Same as the fileCache fields, for the disable grace period.
+	missed      uint32
+	missedSince time.Time
+} // }}}
+
+// type baseCache struct {{{
+
+// Per-base cache.
+//
+// At some point I expect each base will have its own cache settings, but we are not there yet.
+//
+// This would make things easier for setting separate bases to different intervals, timeouts, etc.
+type baseCache struct {
+	// When updating the cache, only 1 goroutine at a time can touch the baseCache in any way.
+	//
+	// Normally every X-interval we launch another goroutine to update the cache, however the prior one may
+	// not have finished due to any number of reasons.
+	//
+	// Because of this, we do not use a Mutex (which will block a goroutine), but rather we use an atomic
+	// to get a "lock" via checkRun and setting the value to 1.
+	//
+	// If a goroutine is still running, it has the atomic and any future goroutines will simply return.
+	// This prevents any possibility of goroutines backing up.
+	//
+	// This has happened to me when running on an older Raspberry PI, where the interval was
+	// check every 30 seconds, but due to the number of files on the SD card it often took
+	// longer then 30 seconds when changes were found to finish.
+	//
+	// This resulted in the obvious backup of goroutines, hence no goroutine blocking Mutex here.
+	//
+	// Though once checkRun has been aquired, it still gets a Mutex lock because configuration changes
+	// still need to update the structure (Tags, path, etc).
+	checkRun uint32
+
+	bMut sync.Mutex
+
+	// Setting force will ensure that the next check run will check all files for changes.
+	// This typically happens if something in the configuration changes, like the path or tags.
+	force bool
+
+	// Set at startup, allows the first full scan to resume from a checkpoint left by an interrupted scan.
+	//
+	// We only ever resume the first scan, any later forced full is because something changed and
+	// needs everything checked.
+	resume bool
+
+	// Base ID
+	Base int
+
+	Checked time.Time
+
+	tagFile string
+
+	// If RAW files are processed for this base and the extensions it uses, used only to check for changes.
+	enableRaw bool
+	exts      map[string]int
+
+	// The file name tag patterns, used only to check for changes.
+	nameTags []*regexp.Regexp
+
+	// Set when the file name tags or manifest change, so the next check recalculates the tags of every file.
+	retag bool
+
+	// The manifest last loaded, when it was last changed and the tags of each file within, see checkManifest().
+	manifest     string
+	manifestTS   time.Time
+	manifestTags map[string]tags.Tags
+
+	// The original path to bfs from the configuration, used only to check for changes.
+	path string
+
+	// How to access the base itself.
+	bfs fs.FS
+
+	// Partial scans since the last full, for confBase.FullEvery.
+	partials uint32
+
+	// For sampleTombstones(), only created if the base uses it.
+	r *rand.Rand
+
+	// Which loop we are on.
+	//
+	// This changes every time check() is run, and lets us know which structures we have
+	// seen and which we haven't.
+	//
+	// Very useful for knowing what was removed.
+	//
+	// We do not care what this value is nor if this value wraps.
+	// The only thing that we care about is that its not the same as the last time.
+	loop uint32
+
+	// Paths within bfs
+	Paths map[string]*pathCache
+} // }}}
+
+// type cache struct {{{
+
+type cache struct {
+	cMut  sync.Mutex
+	bases map[int]*baseCache
+} // }}}
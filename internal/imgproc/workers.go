@@ -0,0 +1,386 @@
+package imgproc
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// The scheduler task for syncWorkers().
+const workersTask = "workers"
+
+// func ImageProc.ownsBase {{{
+
+// Returns true if we scan the base, always the case when not using workers.
+//
+// When using workers only the bases assigned to us at the last heartbeat, none until the first one succeeds.
+func (ip *ImageProc) ownsBase(id int) bool {
+	if ip.getConf().Workers == nil {
+		return true
+	}
+
+	assigned, _ := ip.assigned.Load().(map[int]bool)
+
+	return assigned[id]
+} // }}}
+
+// func ImageProc.scheduleWorkers {{{
+
+// Sets up (or removes) the scheduler task sending our heartbeat, called at startup and each time the configuration
+// changes.
+func (ip *ImageProc) scheduleWorkers(co *conf) {
+	fl := ip.l.With().Str("func", "scheduleWorkers").Logger()
+
+	if co.Workers == nil {
+		ip.sch.Remove(workersTask)
+		ip.stopWorkers(co)
+		return
+	}
+
+	if err := ip.sch.Reschedule(workersTask, co.Workers.Heartbeat); err == nil {
+		return
+	}
+
+	if err := ip.sch.Add(workersTask, co.Workers.Heartbeat, func() { ip.syncWorkers(true) }); err != nil {
+		fl.Err(err).Msg("Add")
+	}
+} // }}}
+
+// func ImageProc.syncWorkers {{{
+
+// Our heartbeat, registers us as a worker along with the bases we can reach and loads the bases assigned to us.
+//
+// If we are the coordinator every base is assigned first, see assignBases().
+//
+// With gained set any base newly assigned to us has its cache loaded again (another worker may have changed it since)
+// and a full check started. Only unset at startup, where the cache was just loaded and every base is checked anyway.
+func (ip *ImageProc) syncWorkers(gained bool) {
+	fl := ip.l.With().Str("func", "syncWorkers").Logger()
+
+	co := ip.getConf()
+	wo := co.Workers
+
+	if wo == nil {
+		return
+	}
+
+	ip.wMut.Lock()
+	defer ip.wMut.Unlock()
+
+	db, err := ip.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return
+	}
+
+	bases := make([]int64, 0, len(co.Bases))
+	for id := range co.Bases {
+		bases = append(bases, int64(id))
+	}
+
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	if _, err := db.Exec(ip.ctx, "workers-register", wo.Name, bases); err != nil {
+		fl.Err(err).Msg("workers-register")
+		return
+	}
+
+	if wo.Coordinator {
+		if err := ip.coordinate(wo); err != nil {
+			fl.Err(err).Msg("coordinate")
+		}
+	}
+
+	rows, err := db.Query(ip.ctx, "workers-assigned", wo.Name)
+	if err != nil {
+		fl.Err(err).Msg("workers-assigned")
+		return
+	}
+
+	assigned := make(map[int]bool)
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			fl.Err(err).Msg("workers-assigned-rows-scan")
+			return
+		}
+
+		// Assigned a base we do not have, such as one removed from our configuration since we last registered.
+		if _, ok := co.Bases[int(id)]; !ok {
+			continue
+		}
+
+		assigned[int(id)] = true
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		fl.Err(err).Msg("workers-assigned-rows-done")
+		return
+	}
+
+	var check []*baseCache
+
+	for id := range assigned {
+		if !gained || ip.owned[id] {
+			continue
+		}
+
+		bc := ip.gainBase(co, id)
+		if bc == nil {
+			// Not yet, so we try again next heartbeat.
+			delete(assigned, id)
+			continue
+		}
+
+		check = append(check, bc)
+	}
+
+	for id := range ip.owned {
+		if !assigned[id] {
+			fl.Info().Int("base", id).Msg("base assigned elsewhere")
+		}
+	}
+
+	ip.owned = assigned
+	ip.assigned.Store(assigned)
+
+	// Only once they are ours, see ownsBase().
+	for _, bc := range check {
+		go ip.checkBase(bc, "")
+	}
+} // }}}
+
+// func ImageProc.stopWorkers {{{
+
+// When workers are turned off every base is ours again, any we were not scanning has its cache loaded again and a full
+// check started.
+func (ip *ImageProc) stopWorkers(co *conf) {
+	ip.wMut.Lock()
+	defer ip.wMut.Unlock()
+
+	if ip.owned == nil {
+		return
+	}
+
+	for id := range co.Bases {
+		if ip.owned[id] {
+			continue
+		}
+
+		if bc := ip.gainBase(co, id); bc != nil {
+			go ip.checkBase(bc, "")
+		}
+	}
+
+	ip.owned = nil
+	ip.assigned.Store(map[int]bool(nil))
+} // }}}
+
+// func ImageProc.gainBase {{{
+
+// Loads the cache of a base newly assigned to us from the database, returning it ready for a full check.
+//
+// Returns nil if the base is still being checked (from before it was assigned elsewhere) or the cache could not be
+// loaded.
+func (ip *ImageProc) gainBase(co *conf, id int) *baseCache {
+	fl := ip.l.With().Str("func", "gainBase").Int("base", id).Logger()
+
+	ca := ip.ca
+
+	ca.cMut.Lock()
+	defer ca.cMut.Unlock()
+
+	// Take the check "lock" of the old cache so nothing is halfway through it.
+	//
+	// Never released, so anything still holding the old cache can not check it once replaced.
+	if old, ok := ca.bases[id]; ok {
+		if !atomic.CompareAndSwapUint32(&old.checkRun, 0, 1) {
+			fl.Debug().Msg("check still running")
+			return nil
+		}
+	}
+
+	db, err := ip.baseDB(id)
+	if err != nil {
+		fl.Err(err).Msg("baseDB")
+		return nil
+	}
+
+	if err := ip.addBaseCache(co.Bases[id], ca, db); err != nil {
+		delete(ca.bases, id)
+		fl.Err(err).Msg("addBaseCache")
+		return nil
+	}
+
+	bc := ca.bases[id]
+
+	// Same as at startup, including resuming a full scan another worker was interrupted in.
+	bc.force = true
+	bc.resume = true
+
+	fl.Info().Msg("base assigned")
+
+	return bc
+} // }}}
+
+// func ImageProc.coordinate {{{
+
+// Assigns every base to a live worker, only run by the coordinator.
+func (ip *ImageProc) coordinate(wo *confWorkers) error {
+	db, err := ip.db.Get()
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string][]int)
+
+	rows, err := db.Query(ip.ctx, "workers-live", wo.Expire.Seconds())
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var name string
+		var bases []int64
+
+		if err := rows.Scan(&name, &bases); err != nil {
+			rows.Close()
+			return err
+		}
+
+		for _, id := range bases {
+			live[name] = append(live[name], int(id))
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	current := make(map[int]string)
+
+	if rows, err = db.Query(ip.ctx, "workers-current"); err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var id int64
+		var name string
+
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+
+		current[int(id)] = name
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, name := range assignBases(current, live) {
+		if current[id] == name {
+			continue
+		}
+
+		ip.l.Info().Str("func", "coordinate").Int("base", id).Str("from", current[id]).Str("to", name).Msg("assigning")
+
+		if _, err := db.Exec(ip.ctx, "workers-assign", id, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func assignBases {{{
+
+// Returns which worker each base should be assigned to, from the current assignments and the bases each live worker
+// can reach.
+//
+// A base stays with its current worker where possible, as moving it means another full scan. Otherwise it goes to
+// the worker with the fewest bases, and is only moved off its current worker to even things out by 2 or more.
+//
+// Bases no live worker can reach are left out, they keep whatever they are assigned until one can.
+func assignBases(current map[int]string, live map[string][]int) map[int]string {
+	reach := make(map[int][]string)
+	count := make(map[string]int, len(live))
+
+	for name, bases := range live {
+		count[name] = 0
+
+		for _, id := range bases {
+			reach[id] = append(reach[id], name)
+		}
+	}
+
+	ids := make([]int, 0, len(reach))
+	for id, names := range reach {
+		sort.Strings(names)
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	out := make(map[int]string, len(ids))
+
+	// The fewest bases, ties going to the first by name.
+	least := func(names []string) string {
+		best := names[0]
+
+		for _, name := range names[1:] {
+			if count[name] < count[best] {
+				best = name
+			}
+		}
+
+		return best
+	}
+
+	for _, id := range ids {
+		owner, ok := current[id]
+		if !ok {
+			continue
+		}
+
+		for _, name := range reach[id] {
+			if name == owner {
+				out[id] = owner
+				count[owner]++
+				break
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if _, ok := out[id]; ok {
+			continue
+		}
+
+		best := least(reach[id])
+		out[id] = best
+		count[best]++
+	}
+
+	for _, id := range ids {
+		owner := out[id]
+		best := least(reach[id])
+
+		if count[owner]-count[best] >= 2 {
+			out[id] = best
+			count[owner]--
+			count[best]++
+		}
+	}
+
+	return out
+} // }}}
@@ -0,0 +1,65 @@
+package imgproc
+
+import (
+	"reflect"
+	"testing"
+)
+
+type assignBasesTest struct {
+	Name     string
+	Current  map[int]string
+	Live     map[string][]int
+	Expected map[int]string
+}
+
+func TestAssignBases(t *testing.T) {
+	tests := []assignBasesTest{
+		{
+			Name:     "empty",
+			Current:  map[int]string{},
+			Live:     map[string][]int{"a": {1, 2, 3, 4}, "b": {1, 2, 3, 4}},
+			Expected: map[int]string{1: "a", 2: "b", 3: "a", 4: "b"},
+		},
+		{
+			// Off by one is left alone.
+			Name:     "keep",
+			Current:  map[int]string{1: "b", 2: "b", 3: "a"},
+			Live:     map[string][]int{"a": {1, 2, 3}, "b": {1, 2, 3}},
+			Expected: map[int]string{1: "b", 2: "b", 3: "a"},
+		},
+		{
+			Name:     "balance",
+			Current:  map[int]string{1: "a", 2: "a", 3: "a", 4: "a"},
+			Live:     map[string][]int{"a": {1, 2, 3, 4}, "b": {1, 2, 3, 4}},
+			Expected: map[int]string{1: "b", 2: "b", 3: "a", 4: "a"},
+		},
+		{
+			// b is gone, and c can only reach 3.
+			Name:     "expired",
+			Current:  map[int]string{1: "a", 2: "b", 3: "b"},
+			Live:     map[string][]int{"a": {1, 2}, "c": {3}},
+			Expected: map[int]string{1: "a", 2: "a", 3: "c"},
+		},
+		{
+			// Only a can reach 2, and nobody 3.
+			Name:     "reach",
+			Current:  map[int]string{3: "b"},
+			Live:     map[string][]int{"a": {1, 2}, "c": {1}},
+			Expected: map[int]string{1: "c", 2: "a"},
+		},
+		{
+			// Current worker no longer has the base in its configuration.
+			Name:     "removed",
+			Current:  map[int]string{1: "a", 2: "a"},
+			Live:     map[string][]int{"a": {1}, "b": {1, 2}},
+			Expected: map[int]string{1: "a", 2: "b"},
+		},
+	}
+
+	for _, test := range tests {
+		got := assignBases(test.Current, test.Live)
+		if !reflect.DeepEqual(got, test.Expected) {
+			t.Fatalf("assignBases %s Expected %v != Got %v", test.Name, test.Expected, got)
+		}
+	}
+}
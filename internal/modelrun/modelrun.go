@@ -0,0 +1,194 @@
+// Runs an external model (such as a small script using onnxruntime, or one calling a web service) over a batch of
+// images.
+//
+// The images are written as PNG files to a temporary directory and added to the end of the command. For each the
+// command writes a single line of JSON to stdout naming the file, along with whatever the model had to say about it -
+//
+//	{"file": "/tmp/modelrun123/42.png", "labels": {"dog": 0.93}}
+//
+// Images it has nothing to say about can be left out. Used by the classify, screen and faces modules.
+//
+// A model behind a web service can be used with Post() instead, which sends the images in a single request and reads
+// the same lines of JSON back.
+package modelrun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frame/internal/httpclient"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// func Run {{{
+
+// Writes the images to a temporary directory and runs the command with them, calling fn with the image ID and the
+// line of JSON for each the command wrote about.
+//
+// An error from fn stops reading the output and is returned as is.
+func Run(ctx context.Context, command []string, imgs map[uint64]image.Image, fn func(id uint64, line []byte) error) error {
+	if len(command) == 0 {
+		return errors.New("no command")
+	}
+
+	dir, err := ioutil.TempDir("", "frame-modelrun")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	args := append([]string(nil), command[1:]...)
+	files := make(map[string]uint64, len(imgs))
+
+	for id, img := range imgs {
+		file := filepath.Join(dir, strconv.FormatUint(id, 10)+".png")
+
+		if err := writePNG(file, img); err != nil {
+			return err
+		}
+
+		args = append(args, file)
+		files[file] = id
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// Whatever the command had to say about it is generally more useful then the exit status.
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+
+		return fmt.Errorf("%s: %s: %s", command[0], err, msg)
+	}
+
+	return parse(&stdout, files, fn)
+} // }}}
+
+// func Post {{{
+
+// Sends the images to the web service at u instead of running a command, calling fn the same as Run().
+//
+// The images are sent as a multipart/form-data POST, each a PNG in a part named "image" with a file name such as
+// "42.png". The response is read the same as the output of a command, a line of JSON for each image with "file" being
+// the name of its part.
+func Post(ctx context.Context, c *httpclient.Client, u string, imgs map[uint64]image.Image, fn func(id uint64, line []byte) error) error {
+	if c == nil || u == "" {
+		return errors.New("no url")
+	}
+
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+	files := make(map[string]uint64, len(imgs))
+
+	for id, img := range imgs {
+		name := strconv.FormatUint(id, 10) + ".png"
+
+		part, err := mw.CreateFormFile("image", name)
+		if err != nil {
+			return err
+		}
+
+		if err := pngEncoder.Encode(part, img); err != nil {
+			return err
+		}
+
+		files[name] = id
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	// A bytes.Reader so the client can send it again on a retry.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	return parse(resp.Body, files, fn)
+} // }}}
+
+// Speed over size, the images are gone again as soon as the model is done with them.
+var pngEncoder = png.Encoder{CompressionLevel: png.NoCompression}
+
+// func writePNG {{{
+
+func writePNG(file string, img image.Image) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err := pngEncoder.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+} // }}}
+
+// func parse {{{
+
+// Reads the lines of JSON written by the command, which must only be about the files we gave it.
+func parse(r io.Reader, files map[string]uint64, fn func(id uint64, line []byte) error) error {
+	sc := bufio.NewScanner(r)
+
+	// Embeddings can make for rather long lines.
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for sc.Scan() {
+		var head struct {
+			File string `json:"file"`
+		}
+
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(line, &head); err != nil {
+			return fmt.Errorf("invalid output: %w", err)
+		}
+
+		id, ok := files[head.File]
+		if !ok {
+			return fmt.Errorf("output for unknown file %q", head.File)
+		}
+
+		if err := fn(id, line); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+} // }}}
@@ -0,0 +1,124 @@
+package modelrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frame/internal/httpclient"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParse(t *testing.T) {
+	files := map[string]uint64{
+		"/tmp/a/1.png": 1,
+		"/tmp/a/2.png": 2,
+		"/tmp/a/3.png": 3,
+	}
+
+	out := `{"file": "/tmp/a/1.png", "labels": {"dog": 0.9}}
+
+{"file": "/tmp/a/2.png"}
+`
+
+	var ids []uint64
+
+	err := parse(strings.NewReader(out), files, func(id uint64, line []byte) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []uint64{1, 2}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("parse ids = %v, want %v", ids, want)
+	}
+
+	nop := func(uint64, []byte) error { return nil }
+
+	if err := parse(strings.NewReader(`{"file": "/tmp/b/1.png"}`), files, nop); err == nil {
+		t.Error("expected an error for an unknown file")
+	}
+
+	if err := parse(strings.NewReader("not json"), files, nop); err == nil {
+		t.Error("expected an error for invalid output")
+	}
+
+	stop := errors.New("stop")
+
+	err = parse(strings.NewReader(out), files, func(uint64, []byte) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("parse = %v, want %v", err, stop)
+	}
+}
+
+func TestPost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, fh := range r.MultipartForm.File["image"] {
+			f, err := fh.Open()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			img, err := png.Decode(f)
+			f.Close()
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprintf(w, "{\"file\": %q, \"labels\": {\"width\": %d}}\n", fh.Filename, img.Bounds().Dx())
+		}
+	}))
+	defer srv.Close()
+
+	l := zerolog.Nop()
+
+	c, err := httpclient.New("test-modelrun", httpclient.Config{Retries: -1}, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imgs := map[uint64]image.Image{
+		1: image.NewGray(image.Rect(0, 0, 4, 4)),
+		2: image.NewGray(image.Rect(0, 0, 8, 8)),
+	}
+
+	got := make(map[uint64]string)
+
+	err = Post(context.Background(), c, srv.URL, imgs, func(id uint64, line []byte) error {
+		got[id] = string(line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint64]string{
+		1: `{"file": "1.png", "labels": {"width": 4}}`,
+		2: `{"file": "2.png", "labels": {"width": 8}}`,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Post = %v, want %v", got, want)
+	}
+
+	if err := Post(context.Background(), c, srv.URL+"/nope", map[uint64]image.Image{}, nil); err != nil {
+		t.Errorf("Post with no images = %v, want nil", err)
+	}
+}
@@ -0,0 +1,306 @@
+// Shared handling of the PostgreSQL connection pools.
+//
+// Every module that uses the database needs the same things - Connect with our logger, prepare its statements on
+// each new connection, swap in a new pool when the configuration changes without disrupting anyone still using the
+// old one, and refuse to hand out the pool after shutdown. Each used to have its own copy of this, each slightly
+// different, this replaces all of those.
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"frame/internal/chaos"
+	"frame/types"
+	"net"
+	"sync/atomic"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/log/zerologadapter"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+var ErrNotConnected = errors.New("not connected")
+
+// type Statement struct {{{
+
+// A single prepared statement, created on every new connection.
+type Statement struct {
+	Name  string
+	Query string
+} // }}}
+
+// type Config struct {{{
+
+type Config struct {
+	// The database URI or DSN, as given to pgxpool.ParseConfig().
+	Database string `log:"redact"`
+
+	// Run on every new connection before preparing any statements, such as "SET TIMEZONE TO UTC".
+	Setup []string
+
+	// Prepared on every new connection, in order.
+	//
+	// Any with an empty Query are skipped, so optional queries can just be included as-is.
+	Statements []Statement
+} // }}}
+
+// type DB struct {{{
+
+type DB struct {
+	l zerolog.Logger
+
+	// Stores the *pgxpool.Pool
+	//
+	// We use an atomic because we want to be able to replace the connection while we are running.
+	db atomic.Value
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	// Lets us know to shutdown.
+	ctx context.Context
+} // }}}
+
+// func New {{{
+
+// Creates a new DB, not yet connected, see Connect().
+//
+// The pool is closed once ctx is done, or Close() is called.
+func New(l *zerolog.Logger, ctx context.Context) *DB {
+	d := &DB{
+		l:   l.With().Str("sub", "pgdb").Logger(),
+		ctx: ctx,
+	}
+
+	// Background goroutine to watch the context and shut us down.
+	go func() {
+		<-d.ctx.Done()
+		d.Close()
+	}()
+
+	return d
+} // }}}
+
+// func DB.Connect {{{
+
+// Connects to the database and tests the connection, including that every statement prepares.
+//
+// Only once that all works is the new pool swapped in and any previous pool closed. So on error the previous pool
+// (if any) is left running as-is.
+func (d *DB) Connect(co *Config) error {
+	var err error
+	var db *pgxpool.Pool
+
+	fl := d.l.With().Str("func", "Connect").Logger()
+
+	// No connecting after a shutdown.
+	if atomic.LoadUint32(&d.closed) == 1 {
+		return types.ErrShutdown
+	}
+
+	poolConf, err := pgxpool.ParseConfig(co.Database)
+	if err != nil {
+		fl.Err(err).Msg("ParseConfig")
+		return err
+	}
+
+	// Set the log level properly.
+	cc := poolConf.ConnConfig
+	cc.LogLevel = pgx.LogLevelInfo
+	cc.Logger = zerologadapter.NewLogger(d.l)
+
+	// Copy, so changes to co after we return do not matter.
+	setup := append([]string(nil), co.Setup...)
+	stmts := append([]Statement(nil), co.Statements...)
+
+	// So that each connection creates our prepared statements.
+	poolConf.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		return d.setup(ctx, conn, setup, stmts)
+	}
+
+	// Only does anything in development, see the chaos package.
+	chaos.Pool(poolConf)
+
+	if db, err = pgxpool.ConnectConfig(d.ctx, poolConf); err != nil {
+		fl.Err(err).Msg("ConnectConfig")
+		return err
+	}
+
+	// Make sure we can actually use it, this also runs AfterConnect so any bad statements are caught now rather
+	// then on first use.
+	conn, err := db.Acquire(d.ctx)
+	if err != nil {
+		fl.Err(err).Msg("Acquire")
+		db.Close()
+		return err
+	}
+
+	err = conn.Conn().Ping(d.ctx)
+	conn.Release()
+
+	if err != nil {
+		fl.Err(err).Msg("Ping")
+		db.Close()
+		return err
+	}
+
+	// Get the old DB (if it exists, first time it won't be set).
+	oldDB, ok := d.db.Load().(*pgxpool.Pool)
+
+	// Set the new DB (especially before we close the possible old connection)
+	d.db.Store(db)
+
+	// Close the old DB if it was set, now that the new one has replaced it.
+	if ok {
+		// We do this in the background, as anyone who is using it will block the Close() from returning.
+		go oldDB.Close()
+	}
+
+	// Did we get closed while connecting? Then Close() may have missed the new one.
+	if atomic.LoadUint32(&d.closed) == 1 {
+		db.Close()
+		return types.ErrShutdown
+	}
+
+	fl.Debug().Int("statements", len(stmts)).Msg("connected")
+
+	return nil
+} // }}}
+
+// func DB.setup {{{
+
+// Run for every new connection in the pool.
+func (d *DB) setup(ctx context.Context, conn *pgx.Conn, setup []string, stmts []Statement) error {
+	fl := d.l.With().Str("func", "setup").Logger()
+
+	// No new connections after a shutdown.
+	if atomic.LoadUint32(&d.closed) == 1 {
+		fl.Debug().Msg("called after shutdown")
+		return types.ErrShutdown
+	}
+
+	for _, sql := range setup {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			fl.Err(err).Str("sql", sql).Msg("Exec")
+			return err
+		}
+	}
+
+	// Lets prepare all our statements
+	for _, st := range stmts {
+		if st.Query == "" {
+			continue
+		}
+
+		if _, err := conn.Prepare(ctx, st.Name, st.Query); err != nil {
+			fl.Err(err).Msg(st.Name)
+			return err
+		}
+	}
+
+	fl.Debug().Msg("prepared")
+
+	return nil
+} // }}}
+
+// func DB.Get {{{
+
+// Returns the current database pool.
+//
+// Loads it from an atomic value so that it can be replaced while running without causing issues.
+//
+// Returns types.ErrShutdown once closed, and ErrNotConnected if Connect() has yet to succeed.
+func (d *DB) Get() (*pgxpool.Pool, error) {
+	// No using the database after a shutdown.
+	if atomic.LoadUint32(&d.closed) == 1 {
+		return nil, types.ErrShutdown
+	}
+
+	db, ok := d.db.Load().(*pgxpool.Pool)
+	if !ok {
+		return nil, ErrNotConnected
+	}
+
+	return db, nil
+} // }}}
+
+// func DB.Close {{{
+
+// Closes the pool, after which Get() only returns types.ErrShutdown.
+//
+// Safe to call more then once.
+func (d *DB) Close() {
+	if !atomic.CompareAndSwapUint32(&d.closed, 0, 1) {
+		return
+	}
+
+	if db, ok := d.db.Load().(*pgxpool.Pool); ok {
+		db.Close()
+	}
+
+	d.l.Debug().Str("func", "Close").Msg("closed")
+} // }}}
+
+// Error classes, see Classify().
+const (
+	ErrClassNone       = iota // No error
+	ErrClassShutdown          // We are shutting down, or the context was cancelled
+	ErrClassNoRows            // The query returned no rows
+	ErrClassConnection        // Lost or unable to connect, generally worth retrying later
+	ErrClassTransient         // Serialization failures, deadlocks or out of resources, retry the query
+	ErrClassConstraint        // Violated a constraint, such as a duplicate key
+	ErrClassQuery             // Anything else, generally a bad query or configuration
+)
+
+// func Classify {{{
+
+// Sorts an error from the database into one of the ErrClass constants, so callers can decide whether to retry,
+// back off or give up without each knowing the details of pgx or PostgreSQL error codes.
+func Classify(err error) int {
+	if err == nil {
+		return ErrClassNone
+	}
+
+	if errors.Is(err, types.ErrShutdown) || errors.Is(err, context.Canceled) {
+		return ErrClassShutdown
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrClassNoRows
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) == 5 {
+		// The first 2 characters of the SQLSTATE are the class.
+		switch pgErr.Code[:2] {
+		case "08": // Connection exception
+			return ErrClassConnection
+		case "57": // Operator intervention, such as the server shutting down
+			if pgErr.Code == "57014" {
+				// Query cancelled, not the server going away.
+				return ErrClassTransient
+			}
+
+			return ErrClassConnection
+		case "40", "53": // Transaction rollback, insufficient resources
+			return ErrClassTransient
+		case "23": // Integrity constraint violation
+			return ErrClassConstraint
+		}
+
+		return ErrClassQuery
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return ErrClassConnection
+	}
+
+	if errors.Is(err, ErrNotConnected) {
+		return ErrClassConnection
+	}
+
+	return ErrClassQuery
+} // }}}
@@ -0,0 +1,65 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frame/types"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class int
+	}{
+		{nil, ErrClassNone},
+		{types.ErrShutdown, ErrClassShutdown},
+		{context.Canceled, ErrClassShutdown},
+		{pgx.ErrNoRows, ErrClassNoRows},
+		{fmt.Errorf("wrapped: %w", pgx.ErrNoRows), ErrClassNoRows},
+		{&pgconn.PgError{Code: "08006"}, ErrClassConnection},
+		{&pgconn.PgError{Code: "57P01"}, ErrClassConnection},
+		{&pgconn.PgError{Code: "57014"}, ErrClassTransient},
+		{&pgconn.PgError{Code: "40001"}, ErrClassTransient},
+		{&pgconn.PgError{Code: "40P01"}, ErrClassTransient},
+		{&pgconn.PgError{Code: "23505"}, ErrClassConstraint},
+		{&pgconn.PgError{Code: "42601"}, ErrClassQuery},
+		{context.DeadlineExceeded, ErrClassConnection},
+		{ErrNotConnected, ErrClassConnection},
+		{errors.New("something else"), ErrClassQuery},
+	}
+
+	for i, tt := range tests {
+		if got := Classify(tt.err); got != tt.class {
+			t.Fatalf("%d: %v Expected %d != Got %d", i, tt.err, tt.class, got)
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	l := zerolog.Nop()
+
+	ctx, can := context.WithCancel(context.Background())
+
+	d := New(&l, ctx)
+
+	if _, err := d.Get(); err != ErrNotConnected {
+		t.Fatalf("Get Expected ErrNotConnected != Got %v", err)
+	}
+
+	can()
+	d.Close()
+
+	if _, err := d.Get(); err != types.ErrShutdown {
+		t.Fatalf("Get Expected ErrShutdown != Got %v", err)
+	}
+
+	if err := d.Connect(&Config{Database: "postgres://localhost/frame"}); err != types.ErrShutdown {
+		t.Fatalf("Connect Expected ErrShutdown != Got %v", err)
+	}
+}
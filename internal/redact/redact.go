@@ -0,0 +1,202 @@
+// Redacting of secrets, such as database passwords, from configuration before it is logged.
+//
+// Any struct field tagged `log:"redact"` has its value replaced when passed through Conf(), so the configuration can
+// still be logged at debug level without the secrets in it.
+//
+//	type conf struct {
+//		Database string `yaml:"database" log:"redact"`
+//	}
+//
+//	fl.Debug().Interface("conf", redact.Conf(co)).Send()
+package redact
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// What a redacted field is replaced with.
+const Redacted = "[redacted]"
+
+// type typeCache struct {{{
+
+// Which types contain a redacted field somewhere within them.
+type typeCache struct {
+	mut   sync.Mutex
+	types map[reflect.Type]bool
+}
+
+var tc = typeCache{types: make(map[reflect.Type]bool)} // }}}
+
+// func Conf {{{
+
+// Returns v with every field tagged `log:"redact"` replaced with Redacted, for logging.
+//
+// Structs containing redacted fields are returned as maps of their exported fields, anything else is returned as is.
+// Fields that are not set are left alone, so it is still visible that they are not set.
+func Conf(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	return value(reflect.ValueOf(v))
+} // }}}
+
+// func Host {{{
+
+// Returns only the host (and port if set) of a PostgreSQL connection string, either a URL or key=value pairs, for
+// logging which database something is about without the password or anything else in it.
+//
+// Empty if there is no host (such as a unix socket by default), Redacted if the string can not be parsed.
+func Host(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// The error includes the string itself, so not returned.
+			return Redacted
+		}
+
+		return u.Host
+	}
+
+	var host, port string
+
+	for _, field := range strings.Fields(dsn) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "host":
+			host = strings.Trim(kv[1], "'")
+		case "port":
+			port = strings.Trim(kv[1], "'")
+		}
+	}
+
+	if host == "" || port == "" {
+		return host
+	}
+
+	return net.JoinHostPort(host, port)
+} // }}}
+
+// func value {{{
+
+func value(v reflect.Value) interface{} {
+	if !tc.has(v.Type()) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return value(v.Elem())
+	case reflect.Struct:
+		vt := v.Type()
+		out := make(map[string]interface{}, v.NumField())
+
+		for i := 0; i < v.NumField(); i++ {
+			sf := vt.Field(i)
+
+			// Unexported, same as encoding/json.
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			fv := v.Field(i)
+
+			if sf.Tag.Get("log") == "redact" && !fv.IsZero() {
+				out[sf.Name] = Redacted
+				continue
+			}
+
+			out[sf.Name] = value(fv)
+		}
+
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = value(v.Index(i))
+		}
+
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+
+		out := make(map[string]interface{}, v.Len())
+
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = value(iter.Value())
+		}
+
+		return out
+	}
+
+	return v.Interface()
+} // }}}
+
+// func typeCache.has {{{
+
+// Returns if t contains a redacted field anywhere within it.
+func (tc *typeCache) has(t reflect.Type) bool {
+	tc.mut.Lock()
+	defer tc.mut.Unlock()
+
+	return tc.hasLocked(t)
+} // }}}
+
+// func typeCache.hasLocked {{{
+
+func (tc *typeCache) hasLocked(t reflect.Type) bool {
+	if has, ok := tc.types[t]; ok {
+		return has
+	}
+
+	// Assume not while checking, for types that contain themselves.
+	tc.types[t] = false
+
+	has := false
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		has = tc.hasLocked(t.Elem())
+	case reflect.Map:
+		has = tc.hasLocked(t.Key()) || tc.hasLocked(t.Elem())
+	case reflect.Interface:
+		// Can't know until we see what is in it.
+		has = true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			if sf.Tag.Get("log") == "redact" || tc.hasLocked(sf.Type) {
+				has = true
+				break
+			}
+		}
+	}
+
+	tc.types[t] = has
+
+	return has
+} // }}}
@@ -0,0 +1,81 @@
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConf(t *testing.T) {
+	type base struct {
+		Path     string
+		Database string `log:"redact"`
+	}
+
+	type conf struct {
+		Database string `log:"redact"`
+		Empty    string `log:"redact"`
+		CheckInt time.Duration
+		Bases    map[int]*base
+		Headers  map[string]string `log:"redact"`
+		private  string
+	}
+
+	co := &conf{
+		Database: "postgres://frame:hunter2@db/frame",
+		CheckInt: time.Minute,
+		Bases: map[int]*base{
+			1: {Path: "/images", Database: "postgres://base:hunter2@db/base"},
+		},
+		Headers: map[string]string{"auth": "hunter2"},
+		private: "hunter2",
+	}
+
+	b, err := json.Marshal(Conf(co))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(b)
+
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("secret not redacted: %s", out)
+	}
+
+	for _, want := range []string{`"Path":"/images"`, `"Empty":""`, `"CheckInt":60000000000`, Redacted} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s: %s", want, out)
+		}
+	}
+
+	// Nothing to redact, so should be the same value.
+	type plain struct{ Path string }
+
+	p := &plain{Path: "/images"}
+	if Conf(p) != interface{}(p) {
+		t.Errorf("plain struct changed")
+	}
+
+	if Conf(nil) != nil {
+		t.Errorf("nil not nil")
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := map[string]string{
+		"postgres://frame:hunter2@db/frame":             "db",
+		"postgresql://frame:hunter2@db:5433/frame":      "db:5433",
+		"postgres://frame:hunter2@%zz/frame":            Redacted,
+		"host=db port=5433 user=frame password=hunter2": "db:5433",
+		"host='db' password=hunter2":                    "db",
+		"dbname=frame password=hunter2":                 "",
+		"":                                              "",
+	}
+
+	for dsn, expected := range tests {
+		if got := Host(dsn); got != expected {
+			t.Fatalf("Host %s Expected %s != Got %s", dsn, expected, got)
+		}
+	}
+}
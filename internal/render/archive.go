@@ -0,0 +1,282 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	fimg "frame/image"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The name of each day directory within the archive.
+const archiveDay = "2006-01-02"
+
+// The contact sheet written to each day directory, see confArchiveYAML.ContactSheet.
+const contactSheet = "contact.webp"
+
+// The size of each thumbnail on a contact sheet, with the label below it.
+var sheetThumb = image.Point{X: 200, Y: 150}
+
+const sheetLabel = 16
+
+// The most thumbnails on a single contact sheet, any more and only every so many are included.
+const sheetMax = 400
+
+// func parseArchive {{{
+
+func parseArchive(in *confArchiveYAML) (*confArchive, error) {
+	if in.Dir == "" {
+		return nil, errors.New("archive needs a dir")
+	}
+
+	if in.Keep < 0 {
+		return nil, errors.New("archive keep can not be negative")
+	}
+
+	ca := &confArchive{
+		Dir:          in.Dir,
+		Keep:         in.Keep,
+		ContactSheet: in.ContactSheet,
+	}
+
+	if ca.Keep == 0 {
+		ca.Keep = 30
+	}
+
+	return ca, nil
+} // }}}
+
+// func Render.archive {{{
+
+// Copies the image just written to file into the archive, if there is one.
+//
+// Only images that show something are copied, and a failure is only logged as the image has already been written.
+func (re *Render) archive(file string, ren *rendered, at time.Time) {
+	co := re.getConf()
+	if co.Archive == nil || len(ren.shown) == 0 {
+		return
+	}
+
+	fl := re.l.With().Str("func", "archive").Str("OutputFile", file).Logger()
+
+	dir := filepath.Join(co.Archive.Dir, at.Format(archiveDay))
+
+	mode := co.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+
+	if err := os.MkdirAll(dir, mode); err != nil {
+		fl.Err(err).Msg("MkdirAll")
+		return
+	}
+
+	name := filepath.Join(dir, at.Format("150405")+"-"+filepath.Base(file))
+
+	if err := ioutil.WriteFile(name, ren.data, 0644); err != nil {
+		fl.Err(err).Msg("WriteFile")
+	}
+} // }}}
+
+// func Render.tidyArchive {{{
+
+// Removes days from the archive older then Keep, and writes the contact sheet for any past day missing one.
+//
+// Run hourly by the scheduler. The work is done in the background so renders due at the same time are not held up.
+func (re *Render) tidyArchive() {
+	co := re.getConf()
+	if co.Archive == nil {
+		return
+	}
+
+	if !atomic.CompareAndSwapUint32(&re.aRun, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreUint32(&re.aRun, 0)
+
+		re.tidyDays(co.Archive, time.Now())
+	}()
+} // }}}
+
+// func Render.tidyDays {{{
+
+func (re *Render) tidyDays(ca *confArchive, now time.Time) {
+	fl := re.l.With().Str("func", "tidyDays").Str("dir", ca.Dir).Logger()
+
+	files, err := ioutil.ReadDir(ca.Dir)
+	if err != nil {
+		fl.Err(err).Msg("ReadDir")
+		return
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	oldest := today.AddDate(0, 0, 1-ca.Keep)
+
+	for _, fi := range files {
+		// Only our own day directories, anything else is left alone.
+		day, err := time.ParseInLocation(archiveDay, fi.Name(), time.Local)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(ca.Dir, fi.Name())
+
+		if day.Before(oldest) {
+			if err := os.RemoveAll(dir); err != nil {
+				fl.Err(err).Str("day", fi.Name()).Msg("RemoveAll")
+				continue
+			}
+
+			fl.Info().Str("day", fi.Name()).Msg("removed")
+			continue
+		}
+
+		if !ca.ContactSheet || !day.Before(today) {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, contactSheet)); err == nil {
+			continue
+		}
+
+		if err := re.writeSheet(dir); err != nil {
+			fl.Err(err).Str("day", fi.Name()).Msg("writeSheet")
+		}
+	}
+} // }}}
+
+// func Render.writeSheet {{{
+
+// Writes the contact sheet for the day directory.
+func (re *Render) writeSheet(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || name == contactSheet || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	// Each starts with the time, so this is the order they were shown.
+	sort.Strings(names)
+
+	if len(names) > sheetMax {
+		every := (len(names) + sheetMax - 1) / sheetMax
+
+		keep := names[:0]
+		for i := 0; i < len(names); i += every {
+			keep = append(keep, names[i])
+		}
+
+		names = keep
+	}
+
+	var thumbs []image.Image
+	var labels []string
+
+	for _, name := range names {
+		img, err := fimg.Open(filepath.Join(dir, name))
+		if err != nil {
+			re.l.Warn().Err(err).Str("func", "writeSheet").Str("file", name).Msg("Open")
+			continue
+		}
+
+		size, _ := fimg.Fit(img.Bounds().Size(), sheetThumb, true)
+
+		thumbs = append(thumbs, fimg.Resize(img, size))
+		labels = append(labels, sheetName(name))
+	}
+
+	if len(thumbs) == 0 {
+		return fmt.Errorf("no images could be loaded from %s", dir)
+	}
+
+	data, err := encodeImage(sheet(thumbs, labels), formatWebP)
+	if err != nil {
+		return err
+	}
+
+	return re.writeImage(filepath.Join(dir, contactSheet), data, filePerm{UID: -1, GID: -1})
+} // }}}
+
+// func sheetName {{{
+
+// Turns an archived file name such as "153000-frame.webp" into the label "15:30:00 frame".
+func sheetName(name string) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	if len(name) < 7 || name[6] != '-' {
+		return name
+	}
+
+	label := name[0:2] + ":" + name[2:4] + ":" + name[4:6] + " " + name[7:]
+
+	// The basic font is 7 pixels wide.
+	if limit := sheetThumb.X / 7; len(label) > limit {
+		label = label[:limit]
+	}
+
+	return label
+} // }}}
+
+// func sheet {{{
+
+// Lays out the thumbnails in a grid as close to square as possible, each with its label below.
+func sheet(thumbs []image.Image, labels []string) *image.RGBA {
+	cols := int(math.Ceil(math.Sqrt(float64(len(thumbs)))))
+	rows := (len(thumbs) + cols - 1) / cols
+
+	cell := image.Point{X: sheetThumb.X, Y: sheetThumb.Y + sheetLabel}
+
+	img := image.NewRGBA(image.Rect(0, 0, cols*cell.X, rows*cell.Y))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.RGBA{200, 200, 200, 255}),
+		Face: basicfont.Face7x13,
+	}
+
+	for i, thumb := range thumbs {
+		x := i % cols * cell.X
+		y := i / cols * cell.Y
+
+		// Centered within the space above the label.
+		b := thumb.Bounds()
+		at := image.Pt(x+(sheetThumb.X-b.Dx())/2, y+(sheetThumb.Y-b.Dy())/2)
+
+		draw.Draw(img, image.Rectangle{Min: at, Max: at.Add(b.Size())}, thumb, b.Min, draw.Src)
+
+		d.Dot = fixed.P(x+(cell.X-len(labels[i])*7)/2, y+cell.Y-3)
+		d.DrawString(labels[i])
+	}
+
+	return img
+} // }}}
@@ -0,0 +1,132 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestTidyDays(t *testing.T) {
+	dir := t.TempDir()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+
+	frame := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := 0; i < len(frame.Pix); i += 4 {
+		frame.Pix[i+1], frame.Pix[i+3] = 255, 255
+	}
+
+	days := []string{"2026-10-16", "2026-10-15", "2026-10-14", "2026-10-13"}
+
+	for _, day := range days {
+		if err := os.Mkdir(filepath.Join(dir, day), 0755); err != nil {
+			t.Fatalf("Mkdir: %s", err)
+		}
+
+		for _, name := range []string{"080000-frame.png", "090000-frame.png"} {
+			f, err := os.Create(filepath.Join(dir, day, name))
+			if err != nil {
+				t.Fatalf("Create: %s", err)
+			}
+
+			png.Encode(f, frame)
+			f.Close()
+		}
+	}
+
+	// Not ours, so left alone however old.
+	if err := os.Mkdir(filepath.Join(dir, "misc"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+
+	re := &Render{l: zerolog.Nop()}
+	re.tidyDays(&confArchive{Dir: dir, Keep: 3, ContactSheet: true}, now)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+
+	var got []string
+	for _, fi := range files {
+		got = append(got, fi.Name())
+	}
+
+	// Keep is 3 days including today.
+	want := []string{"2026-10-14", "2026-10-15", "2026-10-16", "misc"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v != Got %v", want, got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v != Got %v", want, got)
+		}
+	}
+
+	// Today is not over yet, so no sheet.
+	if _, err := os.Stat(filepath.Join(dir, "2026-10-16", contactSheet)); err == nil {
+		t.Fatalf("Contact sheet written for today")
+	}
+
+	f, err := os.Open(filepath.Join(dir, "2026-10-15", contactSheet))
+	if err != nil {
+		t.Fatalf("Contact sheet: %s", err)
+	}
+
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("DecodeConfig: %s", err)
+	}
+
+	// 2 images, so 2 columns of a single row.
+	if cfg.Width != sheetThumb.X*2 || cfg.Height != sheetThumb.Y+sheetLabel {
+		t.Fatalf("Size Expected %dx%d != Got %dx%d", sheetThumb.X*2, sheetThumb.Y+sheetLabel, cfg.Width, cfg.Height)
+	}
+}
+
+func TestSheet(t *testing.T) {
+	if got := sheetName("153000-frame.webp"); got != "15:30:00 frame" {
+		t.Fatalf("sheetName Expected 15:30:00 frame != Got %s", got)
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, 100, 150))
+	for i := 0; i < len(thumb.Pix); i += 4 {
+		thumb.Pix[i], thumb.Pix[i+3] = 255, 255
+	}
+
+	thumbs := []image.Image{thumb, thumb, thumb, thumb, thumb}
+	labels := []string{"a", "b", "c", "d", "e"}
+
+	img := sheet(thumbs, labels)
+
+	// 5 is 3 columns of 2 rows.
+	if got := img.Bounds().Size(); got != image.Pt(sheetThumb.X*3, (sheetThumb.Y+sheetLabel)*2) {
+		t.Fatalf("Size Expected %v != Got %v", image.Pt(sheetThumb.X*3, (sheetThumb.Y+sheetLabel)*2), got)
+	}
+
+	// The second row, second column is empty and the thumbnail centered in each cell.
+	red := color.RGBA{255, 0, 0, 255}
+	cellY := sheetThumb.Y + sheetLabel
+
+	if got := img.RGBAAt(sheetThumb.X+100, cellY+75); got != red {
+		t.Fatalf("(1, 1) Expected %v != Got %v", red, got)
+	}
+
+	if got := img.RGBAAt(sheetThumb.X+20, cellY+75); got == red {
+		t.Fatalf("(1, 1) Expected background left of the thumbnail")
+	}
+
+	if got := img.RGBAAt(sheetThumb.X*2+100, cellY+75); got == red {
+		t.Fatalf("(2, 1) Expected empty != Got %v", got)
+	}
+}
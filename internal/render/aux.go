@@ -0,0 +1,199 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"frame/types"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The default height of a filmstrip, see confProfileYAML.FilmstripHeight.
+const defaultStripHeight = 120
+
+// type auxOutputs struct {{{
+
+// The files written alongside OutputFile, see confProfileYAML.Filmstrip and Details.
+type auxOutputs struct {
+	// Height of the filmstrip in pixels (already scaled), 0 for none.
+	strip int
+
+	details bool
+} // }}}
+
+// type outputDetails struct {{{
+
+// What is written to the Details file.
+type outputDetails struct {
+	Output  string    `json:"output"`
+	Written time.Time `json:"written"`
+
+	// In the order drawn, empty if nothing was (such as during quiet hours).
+	Images []outputImage `json:"images"`
+} // }}}
+
+// type outputImage struct {{{
+
+type outputImage struct {
+	ID      uint64 `json:"id"`
+	Profile string `json:"profile"`
+
+	// Only known if the Weighter provides types.WeighterTags.
+	Tags []string `json:"tags,omitempty"`
+} // }}}
+
+// func parseAux {{{
+
+func parseAux(filmstrip bool, height int, details bool, st outputStyle) (auxOutputs, error) {
+	ao := auxOutputs{details: details}
+
+	if height < 0 {
+		return ao, errors.New("filmstripheight can not be negative")
+	}
+
+	if !filmstrip {
+		return ao, nil
+	}
+
+	if height == 0 {
+		height = defaultStripHeight
+	}
+
+	ao.strip = st.px(height)
+
+	return ao, nil
+} // }}}
+
+// func auxOutputs.files {{{
+
+// Returns the name of each file written alongside file.
+func (ao auxOutputs) files(file string) []string {
+	var files []string
+
+	if ao.strip > 0 {
+		files = append(files, stripFile(file))
+	}
+
+	if ao.details {
+		files = append(files, detailsFile(file))
+	}
+
+	return files
+} // }}}
+
+// func stripFile {{{
+
+// The filmstrip of file, such as "frame.strip.webp" for "frame.webp".
+func stripFile(file string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".strip" + ext
+} // }}}
+
+// func detailsFile {{{
+
+// The details of file, such as "frame.json" for "frame.webp".
+func detailsFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+} // }}}
+
+// func Render.renderStrip {{{
+
+// Renders the images side by side at the height, returning the encoded filmstrip.
+func (re *Render) renderStrip(ids []uint64, height int, st outputStyle) ([]byte, error) {
+	imgs := make([]*image.RGBA, 0, len(ids))
+
+	for _, id := range ids {
+		// Wide enough for any sensible panorama, still limited by the height.
+		img, err := re.cm.LoadImage(id, image.Point{X: height * 8, Y: height}, true)
+		if err != nil {
+			return nil, err
+		}
+
+		imgs = append(imgs, re.toRGBA(img))
+	}
+
+	return encodeImage(filmstrip(imgs, height, st), st.format)
+} // }}}
+
+// func filmstrip {{{
+
+// Places the images left to right with a small gap between, each centered vertically within height.
+func filmstrip(imgs []*image.RGBA, height int, st outputStyle) *image.RGBA {
+	gap := st.px(4)
+
+	width := 0
+	for i, img := range imgs {
+		if i > 0 {
+			width += gap
+		}
+
+		width += img.Bounds().Dx()
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	st.fillBackground(out)
+
+	x := 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		y := (height - b.Dy()) / 2
+
+		draw.Draw(out, image.Rect(x, y, x+b.Dx(), y+b.Dy()), img, b.Min, draw.Src)
+
+		x += b.Dx() + gap
+	}
+
+	return out
+} // }}}
+
+// func Render.outputFiles {{{
+
+// Returns OutputFile along with each of its auxiliary files, ready to be written.
+//
+// A filmstrip is only written when there are images in it, leaving the last one during quiet hours or after a failed
+// render. The details are always written, so they never describe an image no longer shown.
+func (re *Render) outputFiles(file string, ren *rendered, perm filePerm, ao auxOutputs) []commitFile {
+	files := []commitFile{{file: file, data: ren.data, perm: perm, shown: ren.shown}}
+
+	if ao.strip > 0 && ren.strip != nil {
+		files = append(files, commitFile{file: stripFile(file), data: ren.strip, perm: perm})
+	}
+
+	if ao.details {
+		data, err := re.details(file, ren.shown)
+		if err != nil {
+			re.l.Err(err).Str("func", "outputFiles").Str("OutputFile", file).Msg("details")
+		} else {
+			files = append(files, commitFile{file: detailsFile(file), data: data, perm: perm})
+		}
+	}
+
+	return files
+} // }}}
+
+// func Render.details {{{
+
+func (re *Render) details(file string, shown []types.ShownImage) ([]byte, error) {
+	od := outputDetails{
+		Output:  filepath.Base(file),
+		Written: time.Now(),
+		Images:  make([]outputImage, 0, len(shown)),
+	}
+
+	wt, _ := re.we.(types.WeighterTags)
+
+	for _, si := range shown {
+		oi := outputImage{ID: si.ID, Profile: si.Profile}
+
+		if wt != nil {
+			oi.Tags = wt.ImageTags(si.ID)
+		}
+
+		od.Images = append(od.Images, oi)
+	}
+
+	return json.MarshalIndent(&od, "", "  ")
+} // }}}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFilmstrip(t *testing.T) {
+	red := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	blue := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	for i := 0; i < len(red.Pix); i += 4 {
+		red.Pix[i], red.Pix[i+3] = 255, 255
+	}
+
+	for i := 0; i < len(blue.Pix); i += 4 {
+		blue.Pix[i+2], blue.Pix[i+3] = 255, 255
+	}
+
+	st := outputStyle{scale: 1}
+
+	img := filmstrip([]*image.RGBA{red, blue}, 20, st)
+
+	if got := img.Bounds().Size(); got != image.Pt(44, 20) {
+		t.Fatalf("Size Expected (44,20) != Got %v", got)
+	}
+
+	checks := []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 0, 0, 255}},
+		{29, 19, color.RGBA{255, 0, 0, 255}},
+
+		// The gap.
+		{31, 10, color.RGBA{0, 0, 0, 255}},
+
+		// Centered, so 5 pixels of background above and below.
+		{34, 4, color.RGBA{0, 0, 0, 255}},
+		{34, 5, color.RGBA{0, 0, 255, 255}},
+		{43, 14, color.RGBA{0, 0, 255, 255}},
+		{43, 15, color.RGBA{0, 0, 0, 255}},
+	}
+
+	for _, c := range checks {
+		if got := img.RGBAAt(c.x, c.y); got != c.c {
+			t.Fatalf("(%d, %d) Expected %v != Got %v", c.x, c.y, c.c, got)
+		}
+	}
+}
+
+func TestAuxFiles(t *testing.T) {
+	st := outputStyle{scale: 2}
+
+	ao, err := parseAux(true, 0, true, st)
+	if err != nil {
+		t.Fatalf("parseAux: %s", err)
+	}
+
+	if ao.strip != defaultStripHeight*2 {
+		t.Fatalf("strip Expected %d != Got %d", defaultStripHeight*2, ao.strip)
+	}
+
+	files := ao.files("/out/frame.webp")
+	if len(files) != 2 || files[0] != "/out/frame.strip.webp" || files[1] != "/out/frame.json" {
+		t.Fatalf("files Expected [/out/frame.strip.webp /out/frame.json] != Got %v", files)
+	}
+
+	// The height alone does nothing.
+	if ao, _ := parseAux(false, 50, false, st); len(ao.files("frame.webp")) != 0 {
+		t.Fatalf("files Expected none != Got %v", ao.files("frame.webp"))
+	}
+
+	if _, err := parseAux(true, -1, false, st); err == nil {
+		t.Fatalf("parseAux accepted a negative height")
+	}
+}
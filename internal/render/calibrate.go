@@ -0,0 +1,310 @@
+package render
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The largest LUT_3D_SIZE we load, 64 is already more then any display needs.
+const maxLUTSize = 128
+
+// type calibration struct {{{
+
+// Corrects the colors of a render for the display it is shown on, see confProfileYAML.LUT.
+type calibration struct {
+	// The 3D LUT, size entries a side with red changing fastest. Each entry is the red, green and blue from 0 to 1.
+	//
+	// Empty if only Gamma and Contrast are used.
+	size  int
+	table []float32
+
+	// Gamma and contrast together, indexed by the value of a channel.
+	curve [256]uint8
+
+	// If curve does nothing, so it can be skipped.
+	flat bool
+} // }}}
+
+// func parseCalibration {{{
+
+// Returns nil if there is nothing to correct.
+func parseCalibration(lut string, gamma, contrast float64) (*calibration, error) {
+	if lut == "" && gamma == 0 && contrast == 0 {
+		return nil, nil
+	}
+
+	if gamma == 0 {
+		gamma = 1
+	}
+
+	if contrast == 0 {
+		contrast = 1
+	}
+
+	if gamma < 0.1 || gamma > 10 {
+		return nil, errors.New("gamma needs to be between 0.1 and 10")
+	}
+
+	if contrast < 0.1 || contrast > 10 {
+		return nil, errors.New("contrast needs to be between 0.1 and 10")
+	}
+
+	ca := &calibration{flat: gamma == 1 && contrast == 1}
+
+	for i := range ca.curve {
+		v := math.Pow(float64(i)/255, 1/gamma)
+		v = (v-0.5)*contrast + 0.5
+
+		ca.curve[i] = clamp8(v)
+	}
+
+	if lut != "" {
+		f, err := os.Open(lut)
+		if err != nil {
+			return nil, fmt.Errorf("lut: %w", err)
+		}
+
+		defer f.Close()
+
+		if ca.size, ca.table, err = parseCube(f); err != nil {
+			return nil, fmt.Errorf("lut %s: %w", lut, err)
+		}
+	}
+
+	return ca, nil
+} // }}}
+
+// func parseCube {{{
+
+// Parses a 3D LUT in the .cube format, returning its size and table (see calibration).
+//
+// Any DOMAIN_MIN and DOMAIN_MAX is applied to the table, so it always goes from 0 to 1.
+func parseCube(r io.Reader) (int, []float32, error) {
+	var size int
+	var table []float32
+
+	dMin := [3]float64{0, 0, 0}
+	dMax := [3]float64{1, 1, 1}
+
+	sc := bufio.NewScanner(r)
+
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "TITLE":
+			continue
+		case "LUT_1D_SIZE":
+			return 0, nil, errors.New("only 3D LUTs are supported")
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return 0, nil, fmt.Errorf("line %d: invalid LUT_3D_SIZE", line)
+			}
+
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 2 || n > maxLUTSize {
+				return 0, nil, fmt.Errorf("line %d: LUT_3D_SIZE needs to be between 2 and %d", line, maxLUTSize)
+			}
+
+			size = n
+			table = make([]float32, 0, n*n*n*3)
+			continue
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			d := &dMin
+			if fields[0] == "DOMAIN_MAX" {
+				d = &dMax
+			}
+
+			if err := parseTriple(fields[1:], d); err != nil {
+				return 0, nil, fmt.Errorf("line %d: %s: %w", line, fields[0], err)
+			}
+
+			continue
+		}
+
+		// Anything else is an entry of the table.
+		if size == 0 {
+			return 0, nil, fmt.Errorf("line %d: entry before LUT_3D_SIZE", line)
+		}
+
+		var rgb [3]float64
+
+		if err := parseTriple(fields, &rgb); err != nil {
+			return 0, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if len(table) == cap(table) {
+			return 0, nil, fmt.Errorf("line %d: more then %d entries", line, size*size*size)
+		}
+
+		for c := 0; c < 3; c++ {
+			if len(table) == 0 && dMax[c] <= dMin[c] {
+				return 0, nil, errors.New("DOMAIN_MAX needs to be above DOMAIN_MIN")
+			}
+
+			table = append(table, float32((rgb[c]-dMin[c])/(dMax[c]-dMin[c])))
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if size == 0 {
+		return 0, nil, errors.New("no LUT_3D_SIZE")
+	}
+
+	if len(table) != cap(table) {
+		return 0, nil, fmt.Errorf("has %d entries, needs %d", len(table)/3, size*size*size)
+	}
+
+	return size, table, nil
+} // }}}
+
+// func parseTriple {{{
+
+func parseTriple(fields []string, out *[3]float64) error {
+	if len(fields) != 3 {
+		return errors.New("needs 3 values")
+	}
+
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q", field)
+		}
+
+		out[i] = v
+	}
+
+	return nil
+} // }}}
+
+// func calibration.apply {{{
+
+// Corrects the image in place, the LUT first and then Gamma and Contrast. Does nothing for nil.
+//
+// Fully transparent pixels are left alone, partly transparent ones are corrected as if they were opaque.
+func (ca *calibration) apply(img *image.RGBA) {
+	if ca == nil {
+		return
+	}
+
+	b := img.Bounds()
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+
+		for i := 0; i < len(row); i += 4 {
+			a := row[i+3]
+			if a == 0 {
+				continue
+			}
+
+			px := row[i : i+3 : i+3]
+
+			// RGBA is premultiplied, so undo that first.
+			if a != 0xff {
+				for c := range px {
+					px[c] = uint8(math.Min(float64(px[c])*0xff/float64(a)+0.5, 0xff))
+				}
+			}
+
+			if ca.size > 0 {
+				ca.lookup(px)
+			}
+
+			if !ca.flat {
+				for c := range px {
+					px[c] = ca.curve[px[c]]
+				}
+			}
+
+			if a != 0xff {
+				for c := range px {
+					px[c] = uint8(float64(px[c])*float64(a)/0xff + 0.5)
+				}
+			}
+		}
+	}
+} // }}}
+
+// func calibration.lookup {{{
+
+// Replaces the red, green and blue of px with what the LUT has for them, interpolated between the 8 nearest entries.
+func (ca *calibration) lookup(px []uint8) {
+	n := ca.size
+	max := float64(n - 1)
+
+	var idx [3]int
+	var frac [3]float64
+
+	for c := 0; c < 3; c++ {
+		v := float64(px[c]) / 255 * max
+
+		idx[c] = int(v)
+		if idx[c] >= n-1 {
+			idx[c] = n - 2
+		}
+
+		frac[c] = v - float64(idx[c])
+	}
+
+	var out [3]float64
+
+	for corner := 0; corner < 8; corner++ {
+		w := 1.0
+		at := 0
+		mul := 1
+
+		for c := 0; c < 3; c++ {
+			i := idx[c]
+
+			if corner&(1<<c) != 0 {
+				i++
+				w *= frac[c]
+			} else {
+				w *= 1 - frac[c]
+			}
+
+			at += i * mul
+			mul *= n
+		}
+
+		if w == 0 {
+			continue
+		}
+
+		for c := 0; c < 3; c++ {
+			out[c] += w * float64(ca.table[at*3+c])
+		}
+	}
+
+	for c := 0; c < 3; c++ {
+		px[c] = clamp8(out[c])
+	}
+} // }}}
+
+// func clamp8 {{{
+
+// Returns v from 0 to 1 as 0 to 255, rounded and clamped.
+func clamp8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 0xff
+	}
+
+	return uint8(v*0xff + 0.5)
+} // }}}
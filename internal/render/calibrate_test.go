@@ -0,0 +1,123 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Returns a .cube of the given size, each entry made by fn from the red, green and blue it is for.
+func makeCube(size int, fn func(r, g, b float64) (float64, float64, float64)) string {
+	var sb strings.Builder
+
+	sb.WriteString("# Made for testing\nTITLE \"test\"\nLUT_3D_SIZE " + fmt.Sprint(size) + "\n\n")
+
+	max := float64(size - 1)
+
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				ro, gr, bo := fn(float64(r)/max, float64(g)/max, float64(b)/max)
+				fmt.Fprintf(&sb, "%f %f %f\n", ro, gr, bo)
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+func TestParseCube(t *testing.T) {
+	ident := func(r, g, b float64) (float64, float64, float64) { return r, g, b }
+
+	size, table, err := parseCube(strings.NewReader(makeCube(3, ident)))
+	if err != nil || size != 3 || len(table) != 81 {
+		t.Fatalf("parseCube Expected 3, 81 != Got %d, %d, %v", size, len(table), err)
+	}
+
+	// Red changes fastest, so the second entry is half red.
+	if table[3] != 0.5 || table[4] != 0 || table[5] != 0 {
+		t.Fatalf("parseCube second entry Expected 0.5 0 0 != Got %v", table[3:6])
+	}
+
+	// The domain is scaled to 0 to 1.
+	_, table, err = parseCube(strings.NewReader("DOMAIN_MIN 0 0 0\nDOMAIN_MAX 2 2 2\n" + makeCube(2, func(r, g, b float64) (float64, float64, float64) { return r * 2, g * 2, b * 2 })))
+	if err != nil || table[len(table)-1] != 1 {
+		t.Fatalf("parseCube domain Expected 1 != Got %v, %v", table[len(table)-1], err)
+	}
+
+	bad := []string{
+		"",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1 1\n",
+		"LUT_3D_SIZE 1\n0 0 0\n",
+		"0 0 0\nLUT_3D_SIZE 2\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"LUT_3D_SIZE 2\n0 0\n",
+		makeCube(2, ident) + "1 1 1\n",
+	}
+
+	for _, in := range bad {
+		if _, _, err := parseCube(strings.NewReader(in)); err == nil {
+			t.Fatalf("parseCube(%q) Expected error != Got nil", in)
+		}
+	}
+}
+
+func TestCalibrationApply(t *testing.T) {
+	if ca, err := parseCalibration("", 0, 0); ca != nil || err != nil {
+		t.Fatalf("parseCalibration Expected nil != Got %v, %v", ca, err)
+	}
+
+	for _, gamma := range []float64{-1, 0.05, 11} {
+		if _, err := parseCalibration("", gamma, 0); err == nil {
+			t.Fatalf("parseCalibration(gamma %v) Expected error != Got nil", gamma)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{10, 128, 250, 255})
+	img.SetRGBA(1, 0, color.RGBA{0, 0, 0, 0})
+	img.SetRGBA(2, 0, color.RGBA{50, 50, 50, 128})
+
+	// A LUT swapping red and blue.
+	ca := &calibration{flat: true}
+
+	var err error
+	if ca.size, ca.table, err = parseCube(strings.NewReader(makeCube(5, func(r, g, b float64) (float64, float64, float64) { return b, g, r }))); err != nil {
+		t.Fatal(err)
+	}
+
+	ca.apply(img)
+
+	if got := img.RGBAAt(0, 0); got != (color.RGBA{250, 128, 10, 255}) {
+		t.Fatalf("apply Expected {250 128 10 255} != Got %v", got)
+	}
+
+	if got := img.RGBAAt(1, 0); got != (color.RGBA{}) {
+		t.Fatalf("apply transparent Expected unchanged != Got %v", got)
+	}
+
+	if got := img.RGBAAt(2, 0); got != (color.RGBA{50, 50, 50, 128}) {
+		t.Fatalf("apply grey Expected unchanged != Got %v", got)
+	}
+
+	// Brighter midtones, black and white stay put.
+	ca, err = parseCalibration("", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ca.curve[0] != 0 || ca.curve[255] != 255 || ca.curve[64] <= 64 {
+		t.Fatalf("gamma curve Expected 0, >64, 255 != Got %d, %d, %d", ca.curve[0], ca.curve[64], ca.curve[255])
+	}
+
+	// More contrast pushes away from the middle.
+	if ca, err = parseCalibration("", 0, 2); err != nil || ca.curve[64] >= 64 || ca.curve[192] <= 192 {
+		t.Fatalf("contrast curve Expected <64, >192 != Got %d, %d, %v", ca.curve[64], ca.curve[192], err)
+	}
+
+	if _, err := parseCalibration("/nonexistent/display.cube", 0, 0); err == nil {
+		t.Fatal("parseCalibration missing lut Expected error != Got nil")
+	}
+}
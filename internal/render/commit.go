@@ -0,0 +1,258 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"frame/types"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// type commitFile struct {{{
+
+// A single output file being committed.
+type commitFile struct {
+	file string
+	data []byte
+	perm filePerm
+
+	// The images within, see rendered.
+	shown []types.ShownImage
+} // }}}
+
+// func Render.renderCommit {{{
+
+// Renders all the provided profiles, and only once every one of them is ready are they all renamed into place
+// together, followed by updating the manifest.
+//
+// Any profile already being rendered is left out, as is any that fails to render.
+func (re *Render) renderCommit(profiles []*confProfile, mixed []*confProfileMixed, manFile string) {
+	var files []commitFile
+
+	fl := re.l.With().Str("func", "renderCommit").Logger()
+
+	now := time.Now()
+
+	// Everything we get the running lock for, so we can prerender and release them once done.
+	var gotProf []*confProfile
+	var gotMixed []*confProfileMixed
+
+	defer func() {
+		for _, prof := range gotProf {
+			atomic.StoreUint32(&prof.running, 0)
+		}
+
+		for _, prof := range gotMixed {
+			atomic.StoreUint32(&prof.running, 0)
+		}
+	}()
+
+	for _, prof := range profiles {
+		if !atomic.CompareAndSwapUint32(&prof.running, 0, 1) {
+			continue
+		}
+
+		gotProf = append(gotProf, prof)
+
+		// Quiet hours replace the normal image entirely, including any prerendered.
+		if prof.Quiet.active(now) {
+			re.dropNext(prof.OutputFile)
+			prof.next = nil
+
+			ren, err := re.quiet(prof.Quiet, prof.Size, prof.Style.format, func() (*rendered, error) { return re.renderSingle(prof) })
+			if err != nil {
+				fl.Err(err).Str("OutputFile", prof.OutputFile).Msg("quiet")
+				continue
+			}
+
+			if ren != nil {
+				files = append(files, re.outputFiles(prof.OutputFile, ren, prof.Perm, prof.Aux)...)
+			}
+
+			continue
+		}
+
+		// If we prerendered the image then just use it.
+		ren := re.takeNext(prof.OutputFile, prof.next)
+		prof.next = nil
+
+		if ren == nil {
+			var err error
+
+			if ren, err = re.renderSingle(prof); err != nil {
+				if errors.Is(err, types.ErrShutdown) {
+					fl.Info().Msg("in shutdown")
+					return
+				}
+
+				re.renderErr(prof.OutputFile, err)
+
+				// Placeholders are committed along with everything else.
+				if prof.OnError != onErrorPlaceholder {
+					re.renderFailed(prof.OutputFile, prof.Size, prof.Perm, prof.OnError, prof.Style.format, err)
+					continue
+				}
+
+				data, err := placeholder(prof.Size, prof.Style.format, err)
+				if err != nil {
+					fl.Err(err).Msg("placeholder")
+					continue
+				}
+
+				ren = &rendered{data: data}
+			} else {
+				re.renderOK(prof.OutputFile)
+			}
+		}
+
+		files = append(files, re.outputFiles(prof.OutputFile, ren, prof.Perm, prof.Aux)...)
+	}
+
+	for _, prof := range mixed {
+		if !atomic.CompareAndSwapUint32(&prof.running, 0, 1) {
+			continue
+		}
+
+		gotMixed = append(gotMixed, prof)
+
+		// Quiet hours replace the normal image entirely, including any prerendered.
+		if prof.Quiet.active(now) {
+			re.dropNext(prof.OutputFile)
+			prof.next = nil
+
+			ren, err := re.quiet(prof.Quiet, prof.Size, prof.Style.format, func() (*rendered, error) { return re.renderMixed(prof) })
+			if err != nil {
+				fl.Err(err).Str("OutputFile", prof.OutputFile).Msg("quiet")
+				continue
+			}
+
+			if ren != nil {
+				files = append(files, re.outputFiles(prof.OutputFile, ren, prof.Perm, prof.Aux)...)
+			}
+
+			continue
+		}
+
+		ren := re.takeNext(prof.OutputFile, prof.next)
+		prof.next = nil
+
+		if ren == nil {
+			var err error
+
+			if ren, err = re.renderMixed(prof); err != nil {
+				if errors.Is(err, types.ErrShutdown) {
+					fl.Info().Msg("in shutdown")
+					return
+				}
+
+				re.renderErr(prof.OutputFile, err)
+
+				// Placeholders are committed along with everything else.
+				if prof.OnError != onErrorPlaceholder {
+					re.renderFailed(prof.OutputFile, prof.Size, prof.Perm, prof.OnError, prof.Style.format, err)
+					continue
+				}
+
+				data, err := placeholder(prof.Size, prof.Style.format, err)
+				if err != nil {
+					fl.Err(err).Msg("placeholder")
+					continue
+				}
+
+				ren = &rendered{data: data}
+			} else {
+				re.renderOK(prof.OutputFile)
+			}
+		}
+
+		files = append(files, re.outputFiles(prof.OutputFile, ren, prof.Perm, prof.Aux)...)
+	}
+
+	if len(files) > 0 {
+		if err := re.commitFiles(files, manFile); err != nil {
+			fl.Err(err).Msg("commitFiles")
+		}
+	}
+
+	// Now get the next ones ready.
+	for _, prof := range gotProf {
+		if prof.Prerender && !prof.Quiet.active(now) {
+			ren, _ := re.renderSingle(prof)
+			prof.next = re.setNext(prof.OutputFile, ren)
+		}
+	}
+
+	for _, prof := range gotMixed {
+		if prof.Prerender && !prof.Quiet.active(now) {
+			ren, _ := re.renderMixed(prof)
+			prof.next = re.setNext(prof.OutputFile, ren)
+		}
+	}
+} // }}}
+
+// func Render.commitFiles {{{
+
+// Writes out all the files to their .tmp, then renames them into place and writes the manifest.
+//
+// If any file fails to write then none of them are renamed.
+func (re *Render) commitFiles(files []commitFile, manFile string) error {
+	fl := re.l.With().Str("func", "commitFiles").Logger()
+
+	for i, cf := range files {
+		if err := re.writeTemp(cf.file, cf.data, cf.perm); err != nil {
+			// Clean up the ones already written.
+			for _, wf := range files[:i] {
+				os.Remove(wf.file + ".tmp")
+			}
+
+			return err
+		}
+	}
+
+	// The manifest is only updated under the lock, so two commits can not interleave their renames with it.
+	re.mMut.Lock()
+	defer re.mMut.Unlock()
+
+	now := time.Now()
+
+	written := make(map[string][]types.ShownImage, len(files))
+
+	if re.man.Files == nil {
+		re.man.Files = make(map[string]time.Time, len(files))
+	}
+
+	for _, cf := range files {
+		if err := os.Rename(cf.file+".tmp", cf.file); err != nil {
+			// Not much we can do other then continue, the manifest will not list this one as updated.
+			fl.Err(err).Str("OutputFile", cf.file).Msg("Rename")
+			continue
+		}
+
+		re.man.Files[cf.file] = now
+		written[cf.file] = cf.shown
+
+		re.shown(cf.file, cf.shown, now)
+		re.archive(cf.file, &rendered{data: cf.data, shown: cf.shown}, now)
+	}
+
+	re.saveState(written, now)
+
+	re.man.Updated = now
+	re.man.Seq++
+
+	data, err := json.MarshalIndent(&re.man, "", "  ")
+	if err != nil {
+		fl.Err(err).Msg("Marshal")
+		return err
+	}
+
+	// Same permissions as the outputs, so whoever can read them can read the manifest.
+	if err := re.writeImage(manFile, data, files[0].perm); err != nil {
+		return err
+	}
+
+	fl.Debug().Int("files", len(files)).Uint64("seq", re.man.Seq).Send()
+
+	return nil
+} // }}}
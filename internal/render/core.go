@@ -0,0 +1,1611 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frame/internal/features"
+	"frame/internal/redact"
+	"frame/internal/scheduler"
+	"frame/internal/tracing"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("frame/internal/render")
+
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Convert: yconfConvert,
+	Changed: yconfChanged,
+}
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	// This means that for our various maps, inA will continue to grow as the number of files we process grow, but inB will always be just the
+	// most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	// Merge the profiles
+	if len(inA.Profiles) == 0 {
+		// If A has no profiles then just assign B.
+		inA.Profiles = inB.Profiles
+	} else {
+		// Merge in B's profiles.
+		for _, prof := range inB.Profiles {
+			inA.Profiles = append(inA.Profiles, prof)
+		}
+	}
+
+	if inB.Manifest != "" {
+		inA.Manifest = inB.Manifest
+	}
+
+	if inB.CreateDirs {
+		inA.CreateDirs = true
+	}
+
+	if inB.DirMode != 0 {
+		inA.DirMode = inB.DirMode
+	}
+
+	if inB.Archive != nil {
+		inA.Archive = inB.Archive
+	}
+
+	if inB.State != "" {
+		inA.State = inB.State
+	}
+
+	// Every file has the default unless set, so only one set to something else replaces it.
+	if inB.QuarantineAfter != 10 {
+		inA.QuarantineAfter = inB.QuarantineAfter
+	}
+
+	if len(inA.MixProfiles) == 0 {
+		inA.MixProfiles = inB.MixProfiles
+	} else {
+		for _, prof := range inB.MixProfiles {
+			inA.MixProfiles = append(inA.MixProfiles, prof)
+		}
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Manifest != newConf.Manifest || origConf.State != newConf.State || origConf.QuarantineAfter != newConf.QuarantineAfter {
+		return true
+	}
+
+	if origConf.CreateDirs != newConf.CreateDirs || origConf.DirMode != newConf.DirMode {
+		return true
+	}
+
+	if (origConf.Archive == nil) != (newConf.Archive == nil) {
+		return true
+	}
+
+	if origConf.Archive != nil && *origConf.Archive != *newConf.Archive {
+		return true
+	}
+
+	if len(origConf.Profiles) != len(newConf.Profiles) {
+		return true
+	}
+
+	// Both origConf and newConf.Profiles are the same length, so this
+	// is otherwise safe.
+	for i := 0; i < len(origConf.Profiles); i++ {
+		if origConf.Profiles[i] != newConf.Profiles[i] {
+			return true
+		}
+	}
+
+	if len(origConf.MixProfiles) != len(newConf.MixProfiles) {
+		return true
+	}
+
+	for i := 0; i < len(origConf.MixProfiles); i++ {
+		if origConf.MixProfiles[i] != newConf.MixProfiles[i] {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func yconfConvert {{{
+
+func yconfConvert(inInt interface{}) (interface{}, error) {
+	var err error
+
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		Manifest:        in.Manifest,
+		CreateDirs:      in.CreateDirs,
+		State:           in.State,
+		QuarantineAfter: in.QuarantineAfter,
+	}
+
+	switch {
+	case out.QuarantineAfter == 0:
+		out.QuarantineAfter = 10
+	case out.QuarantineAfter == -1:
+		out.QuarantineAfter = 0
+	case out.QuarantineAfter < 0:
+		return nil, errors.New("invalid quarantineafter")
+	}
+
+	if in.DirMode != "" {
+		m, err := strconv.ParseUint(in.DirMode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.New("invalid dirmode")
+		}
+
+		out.DirMode = os.FileMode(m)
+	}
+
+	if len(in.Profiles) < 1 && len(in.MixProfiles) < 1 {
+		return nil, errors.New("file has no profiles")
+	}
+
+	if in.Archive != nil {
+		if out.Archive, err = parseArchive(in.Archive); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, prof := range in.Profiles {
+		op := &confProfile{
+			Depth:         prof.MaxDepth,
+			TagProfile:    prof.TagProfile,
+			Rotate:        prof.Rotate,
+			RotateEvery:   prof.RotateEvery,
+			WriteInterval: time.Duration(prof.WriteInterval),
+			OutputFile:    prof.OutputFile,
+			Prerender:     prof.Prerender,
+		}
+
+		if op.Perm, err = parsePerm(prof.Mode, prof.UID, prof.GID); err != nil {
+			return nil, err
+		}
+
+		if op.OnError, err = parseOnError(prof.OnError); err != nil {
+			return nil, err
+		}
+
+		if op.Quiet, err = parseQuiet(prof.QuietStart, prof.QuietEnd, prof.QuietMode, prof.QuietDim); err != nil {
+			return nil, err
+		}
+
+		if op.Style, err = parseStyle(prof.Transparent, prof.Format, prof.Mask, prof.MaskRadius, prof.Scrapbook, prof.ScrapbookRotate); err != nil {
+			return nil, err
+		}
+
+		if prof.MaxBytes < 0 {
+			return nil, errors.New("maxbytes can not be negative")
+		}
+
+		op.Style.maxBytes = prof.MaxBytes
+
+		if op.Style.scale, err = parseScale(prof.Scale); err != nil {
+			return nil, err
+		}
+
+		if op.Style.zoom, err = parseZoom(prof.Zoom); err != nil {
+			return nil, err
+		}
+
+		if op.Style.calib, err = parseCalibration(prof.LUT, prof.Gamma, prof.Contrast); err != nil {
+			return nil, err
+		}
+
+		if op.Aux, err = parseAux(prof.Filmstrip, prof.FilmstripHeight, prof.Details, op.Style); err != nil {
+			return nil, err
+		}
+
+		// Assign defaults.
+		if op.Depth < 1 || op.Depth > 20 {
+			op.Depth = 6
+		}
+
+		if op.TagProfile == "" && len(op.Rotate) == 0 {
+			return nil, errors.New("no TagProfile")
+		}
+
+		for _, name := range op.Rotate {
+			if name == "" {
+				return nil, errors.New("empty profile in Rotate")
+			}
+		}
+
+		if op.RotateEvery < 1 {
+			op.RotateEvery = 1
+		}
+
+		if op.OutputFile == "" {
+			return nil, errors.New("no OutputFile")
+		}
+
+		if prof.Width == 0 || prof.Height == 0 {
+			return nil, errors.New("no Width or Height")
+		}
+
+		op.Size = op.Style.size(prof.Width, prof.Height)
+
+		// Default the writeInterval to 5 minutes (60s*5)
+		if op.WriteInterval < time.Second {
+			op.WriteInterval = time.Second * 300
+		}
+
+		// Append the profile.
+		out.Profiles = append(out.Profiles, op)
+	}
+
+	for _, prof := range in.MixProfiles {
+		op := &confProfileMixed{
+			WriteInterval: time.Duration(prof.WriteInterval),
+			OutputFile:    prof.OutputFile,
+			Prerender:     prof.Prerender,
+		}
+
+		if op.Perm, err = parsePerm(prof.Mode, prof.UID, prof.GID); err != nil {
+			return nil, err
+		}
+
+		if op.OnError, err = parseOnError(prof.OnError); err != nil {
+			return nil, err
+		}
+
+		if op.Quiet, err = parseQuiet(prof.QuietStart, prof.QuietEnd, prof.QuietMode, prof.QuietDim); err != nil {
+			return nil, err
+		}
+
+		if op.Style, err = parseStyle(prof.Transparent, prof.Format, prof.Mask, prof.MaskRadius, prof.Scrapbook, prof.ScrapbookRotate); err != nil {
+			return nil, err
+		}
+
+		if prof.MaxBytes < 0 {
+			return nil, errors.New("maxbytes can not be negative")
+		}
+
+		op.Style.maxBytes = prof.MaxBytes
+
+		if op.Style.scale, err = parseScale(prof.Scale); err != nil {
+			return nil, err
+		}
+
+		if op.Style.zoom, err = parseZoom(prof.Zoom); err != nil {
+			return nil, err
+		}
+
+		if op.Style.calib, err = parseCalibration(prof.LUT, prof.Gamma, prof.Contrast); err != nil {
+			return nil, err
+		}
+
+		if op.Aux, err = parseAux(prof.Filmstrip, prof.FilmstripHeight, prof.Details, op.Style); err != nil {
+			return nil, err
+		}
+
+		if op.OutputFile == "" {
+			return nil, errors.New("no OutputFile")
+		}
+
+		if prof.Width == 0 || prof.Height == 0 {
+			return nil, errors.New("no Width or Height")
+		}
+
+		op.Size = op.Style.size(prof.Width, prof.Height)
+
+		// Default the writeInterval to 5 minutes (60s*5)
+		if op.WriteInterval < time.Second {
+			op.WriteInterval = time.Second * 300
+		}
+
+		for _, pcount := range prof.Profiles {
+			cp := confProfileCounts{
+				TagProfile: pcount.TagProfile,
+				images:     pcount.Images,
+			}
+
+			op.Profiles = append(op.Profiles, cp)
+		}
+
+		// Append the profile.
+		out.MixProfiles = append(out.MixProfiles, op)
+	}
+
+	return out, nil
+} // }}}
+
+// func parsePerm {{{
+
+func parsePerm(mode string, uid, gid *int) (filePerm, error) {
+	perm := filePerm{
+		UID: -1,
+		GID: -1,
+	}
+
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return perm, errors.New("invalid mode")
+		}
+
+		perm.Mode = os.FileMode(m)
+	}
+
+	if uid != nil {
+		perm.UID = *uid
+	}
+
+	if gid != nil {
+		perm.GID = *gid
+	}
+
+	return perm, nil
+} // }}}
+
+// func New {{{
+
+func New(confPath string, we types.Weighter, cm types.CacheManager, l *zerolog.Logger, ctx context.Context) (*Render, error) {
+	var err error
+
+	re := &Render{
+		l:     l.With().Str("mod", "render").Logger(),
+		we:    we,
+		cm:    cm,
+		cPath: confPath,
+		ctx:   ctx,
+	}
+
+	fl := re.l.With().Str("func", "New").Logger()
+
+	features.Log(&fl, "render")
+
+	// Load our configuration.
+	if err = re.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// Start the scheduler that handles the profile intervals for writing out the profile images.
+	//
+	// Before watching the configuration, since notifyConf() updates it.
+	re.sch = scheduler.New(re.ctx)
+	re.sInts = make(map[time.Duration]bool)
+	re.schedule(re.getConf())
+
+	// Start background processing to watch configuration for changes.
+	re.yc.Start()
+
+	// Throw away prerendered images once anything they show is removed.
+	re.subscribe()
+
+	// We start by rendering an image for each profile.
+	co := re.getConf()
+
+	// Before that though, clean up after any crash.
+	re.cleanTmp(co)
+
+	// Nothing is quarantined after a restart.
+	re.releaseAll(co)
+
+	// The scheduler only tidies the archive hourly, so catch up on anything missed while we were not running.
+	re.tidyArchive()
+
+	// Anything still showing what it was before we restarted is left for its next interval.
+	skip := re.resume(co)
+
+	var profiles []*confProfile
+	var mixed []*confProfileMixed
+
+	for _, prof := range co.Profiles {
+		if !skip[prof.OutputFile] {
+			profiles = append(profiles, prof)
+		}
+	}
+
+	for _, prof := range co.MixProfiles {
+		if !skip[prof.OutputFile] {
+			mixed = append(mixed, prof)
+		}
+	}
+
+	if co.Manifest != "" {
+		go re.renderCommit(profiles, mixed, co.Manifest)
+		fl.Debug().Send()
+		return re, nil
+	}
+
+	for _, prof := range profiles {
+		go re.renderProfile(prof)
+	}
+
+	for _, prof := range mixed {
+		go re.renderProfileMixed(prof)
+	}
+
+	fl.Debug().Send()
+
+	return re, nil
+} // }}}
+
+// func Render.cleanTmp {{{
+
+// Removes the .tmp for each output file (and the manifest) if one was left behind, such as from a crash.
+//
+// We only look at our own .tmp files, as the output directories are often shared with other things.
+// Since we have not written anything yet, any that exist are stale.
+func (re *Render) cleanTmp(co *conf) {
+	fl := re.l.With().Str("func", "cleanTmp").Logger()
+
+	var files []string
+
+	for _, prof := range co.Profiles {
+		files = append(files, prof.OutputFile)
+		files = append(files, prof.Aux.files(prof.OutputFile)...)
+	}
+
+	for _, prof := range co.MixProfiles {
+		files = append(files, prof.OutputFile)
+		files = append(files, prof.Aux.files(prof.OutputFile)...)
+	}
+
+	if co.Manifest != "" {
+		files = append(files, co.Manifest)
+	}
+
+	if co.State != "" {
+		files = append(files, co.State)
+	}
+
+	for _, file := range files {
+		tmp := file + ".tmp"
+
+		info, err := os.Stat(tmp)
+		if err != nil {
+			continue
+		}
+
+		if err := os.Remove(tmp); err != nil {
+			fl.Warn().Err(err).Str("file", tmp).Msg("Remove")
+			continue
+		}
+
+		fl.Info().Str("file", tmp).Time("modified", info.ModTime()).Msg("removed")
+	}
+} // }}}
+
+// func Render.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (re *Render) loadConf() error {
+	var err error
+
+	fl := re.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		re.notifyConf()
+	}
+
+	if re.yc, err = yconf.New(re.cPath, ycc, &re.l, re.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = re.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(re.yc.Get())).Send()
+
+	// Get the loaded configuration
+	co, ok := re.yc.Get().(*conf)
+	if !ok {
+		// This one should not really be possible, so this error needs to be sent.
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	// Check the configuration sanity first.
+	if !re.checkConf(co) {
+		return errors.New("Invalid configuration")
+	}
+
+	// Looks good, go ahead and store it.
+	//
+	re.co.Store(co)
+
+	return nil
+} // }}}
+
+// func Render.notifyConf {{{
+
+func (re *Render) notifyConf() {
+	fl := re.l.With().Str("func", "notifyConf").Logger()
+
+	// Update our configuration.
+	co, ok := re.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	// Check the new configuration before we do anything.
+	if !re.checkConf(co) {
+		fl.Warn().Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	// Store the new configuration
+	re.co.Store(co)
+
+	// Update the intervals we render at.
+	re.schedule(co)
+
+	// Whatever was failing may have been fixed.
+	re.releaseAll(co)
+
+	// Note - We did not check ucPollInt here, thats handled in the partial loop and it will adjust on its next patial run.
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func Render.checkConf {{{
+
+func (re *Render) checkConf(co *conf) bool {
+	var err error
+
+	fl := re.l.With().Str("func", "checkConf").Logger()
+
+	if len(co.Profiles) < 1 {
+		fl.Warn().Msg("No profiles")
+		return false
+	}
+
+	if err = re.checkDirs(co); err != nil {
+		fl.Err(err).Msg("checkDirs")
+		return false
+	}
+
+	// Each profile we have configured must have a proper WeighterProfile
+	// for it as well.
+	for _, prof := range co.Profiles {
+		if prof.TagProfile != "" {
+			if prof.wp, err = re.getProfile(prof.TagProfile); err != nil {
+				fl.Err(err).Msg("getProfile")
+				return false
+			}
+		}
+
+		prof.wps = make([]types.WeighterProfile, len(prof.Rotate))
+
+		for i, name := range prof.Rotate {
+			if prof.wps[i], err = re.getProfile(name); err != nil {
+				fl.Err(err).Msg("getProfile")
+				return false
+			}
+		}
+	}
+
+	// Same as above, check the WeighterProfile for each MixProfile.
+	for _, prof := range co.MixProfiles {
+		// Note - prof.Profiles are not references, so access them differently.
+		for i := 0; i < len(prof.Profiles); i++ {
+			if prof.Profiles[i].wp, err = re.getProfile(prof.Profiles[i].TagProfile); err != nil {
+				fl.Err(err).Msg("getProfile")
+				return false
+			}
+		}
+	}
+
+	return true
+} // }}}
+
+// func Render.checkDirs {{{
+
+// Checks the directory of every OutputFile, the Manifest, the State and the Archive exists and is writable, creating it if CreateDirs is set.
+func (re *Render) checkDirs(co *conf) error {
+	var files []string
+
+	for _, prof := range co.Profiles {
+		files = append(files, prof.OutputFile)
+	}
+
+	for _, prof := range co.MixProfiles {
+		files = append(files, prof.OutputFile)
+	}
+
+	if co.Manifest != "" {
+		files = append(files, co.Manifest)
+	}
+
+	if co.State != "" {
+		files = append(files, co.State)
+	}
+
+	checked := make(map[string]bool, len(files))
+
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if checked[dir] {
+			continue
+		}
+
+		checked[dir] = true
+
+		if err := checkDir(dir, co); err != nil {
+			return fmt.Errorf("outputfile %s: %s", file, err)
+		}
+	}
+
+	if co.Archive != nil {
+		if err := checkDir(co.Archive.Dir, co); err != nil {
+			return fmt.Errorf("archive: %s", err)
+		}
+	}
+
+	return nil
+} // }}}
+
+// func checkDir {{{
+
+func checkDir(dir string, co *conf) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !co.CreateDirs {
+			return err
+		}
+
+		mode := co.DirMode
+		if mode == 0 {
+			mode = 0755
+		}
+
+		if err = os.MkdirAll(dir, mode); err != nil {
+			return err
+		}
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// The only real way to know we can write there is to do so.
+	f, err := ioutil.TempFile(dir, ".frame-check-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %s", dir, err)
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+
+	return nil
+} // }}}
+
+// func Render.getProfile {{{
+
+// Same as Weighter.GetProfile(), though if the profile can not be found the error lists those that can, so a typo
+// in a tagprofile is easy to spot.
+func (re *Render) getProfile(name string) (types.WeighterProfile, error) {
+	wp, err := re.we.GetProfile(name)
+	if err == nil {
+		return wp, nil
+	}
+
+	if wl, ok := re.we.(types.WeighterLister); ok {
+		err = fmt.Errorf("tagprofile %q: %s, available: %s", name, err, strings.Join(wl.Profiles(), ", "))
+	}
+
+	return nil, err
+} // }}}
+
+// func Render.getConf {{{
+
+func (re *Render) getConf() *conf {
+	fl := re.l.With().Str("func", "getConf").Logger()
+
+	if co, ok := re.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	//
+	// If this does, then there is a deeper issue.
+	fl.Warn().Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func Render.renderImage {{{
+
+// Renders the provided IDs into a new image of the provided size, returning the encoded image.
+//
+// Nothing is written out, see writeImage() for that.
+func (re *Render) renderImage(size image.Point, ids []uint64, st outputStyle) ([]byte, int, error) {
+	var err error
+
+	fl := re.l.With().Str("func", "renderImage").Logger()
+
+	// Used to determine the location of the next image.
+	// Top/Left or Bottom/Right.
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	start := time.Now()
+
+	// For very new profiles this can happen that no IDs are returned.
+	//
+	// Or images being taken disabled/deleted that cause a profile to no longer have any.
+	if len(ids) < 1 {
+		err = errors.New("no IDs provided")
+		fl.Err(err).Send()
+		return nil, 0, err
+	}
+
+	// Ok, we have all the IDs we need.
+	// Create a new blank image.
+	img := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	st.fillBackground(img)
+
+	// Create our sub image.
+	// This will be a smaller image within the main image, getting
+	// smaller each time a portion of the main image is filled.
+	sub := img
+
+	// How many of the IDs were drawn, the rest did not fit.
+	used := 0
+
+	// Scrapbook images are drawn once they are all placed.
+	var sb *scrapbook
+	if st.scrapbook {
+		sb = &scrapbook{rotate: st.rotate, r: r}
+	}
+
+	fl.Debug().Interface("ids", ids).Msg("check")
+
+	// Loop through all the IDs we have until we either out or have
+	// too few pixels to place the image within.
+	for _, id := range ids {
+		sub, err = re.fillImage(sub, id, r, st, sb)
+		if err != nil {
+			fl.Err(err).Msg("fillImage")
+			return nil, 0, err
+		}
+
+		used++
+
+		// If no sub is returned then we have not enough left over space on the image itself to put anymore.
+		if sub == nil {
+			fl.Debug().Interface("ids", ids).Uint64("id", id).Msg("no more")
+			break
+		}
+	}
+
+	if sb != nil {
+		sb.draw(img)
+	}
+
+	st.calib.apply(img)
+
+	// Encode the image, smaller if need be.
+	data, err := fitImage(img, st)
+	if err != nil {
+		fl.Err(err).Msg("fitImage")
+		return nil, 0, err
+	}
+
+	// Ok, image complete.
+	fl.Debug().Stringer("took", time.Since(start)).Send()
+
+	return data, used, nil
+} // }}}
+
+// func Render.writeImage {{{
+
+// Writes out an image from renderImage().
+func (re *Render) writeImage(file string, data []byte, perm filePerm) error {
+	fl := re.l.With().Str("func", "writeImage").Str("OutputFile", file).Logger()
+
+	if err := re.writeTemp(file, data, perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file+".tmp", file); err != nil {
+		fl.Err(err).Msg("Rename")
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func Render.writeRendered {{{
+
+// Writes out an image from renderSingle() or renderMixed() along with its auxiliary files, then lets the Weighter know
+// what was shown.
+//
+// The auxiliary files are written after, so a failure with one of them still leaves the image written.
+func (re *Render) writeRendered(file string, ren *rendered, perm filePerm, ao auxOutputs) error {
+	files := re.outputFiles(file, ren, perm, ao)
+
+	if err := re.writeImage(file, ren.data, perm); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	re.shown(file, ren.shown, now)
+	re.saveState(map[string][]types.ShownImage{file: ren.shown}, now)
+	re.archive(file, ren, now)
+
+	for _, cf := range files[1:] {
+		if err := re.writeImage(cf.file, cf.data, cf.perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func Render.shown {{{
+
+// Reports the images shown within the output file to the Weighter, if it wants to know.
+func (re *Render) shown(file string, images []types.ShownImage, at time.Time) {
+	if len(images) == 0 {
+		return
+	}
+
+	if wu, ok := re.we.(types.WeighterUsage); ok {
+		wu.Shown(file, images, at)
+	}
+} // }}}
+
+// func Render.writeTemp {{{
+
+// Writes the data out to file.tmp, its up to the caller to rename it into place.
+func (re *Render) writeTemp(file string, data []byte, perm filePerm) error {
+	fl := re.l.With().Str("func", "writeTemp").Str("OutputFile", file).Logger()
+
+	mode := perm.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	// Now we open the file to write out the image.
+	//
+	// We do not defer f.Close since we want to close it right away so we can rename it.
+	f, err := os.OpenFile(file+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		fl.Err(err).Msg("OpenFile")
+		return err
+	}
+
+	// OpenFile() is subject to the umask, so if a mode was configured set it exactly.
+	//
+	// Done on the .tmp so the rename is the only time anyone sees the file.
+	if perm.Mode != 0 {
+		if err := f.Chmod(perm.Mode); err != nil {
+			f.Close()
+			fl.Err(err).Msg("Chmod")
+			return err
+		}
+	}
+
+	if perm.UID != -1 || perm.GID != -1 {
+		if err := f.Chown(perm.UID, perm.GID); err != nil {
+			f.Close()
+			fl.Err(err).Int("uid", perm.UID).Int("gid", perm.GID).Msg("Chown")
+			return err
+		}
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		fl.Err(err).Msg("Write")
+		return err
+	}
+
+	f.Close()
+
+	return nil
+} // }}}
+
+// func Render.mixedIDs {{{
+
+// Gets the IDs to render for a mixed profile, along with the TagProfile each came from.
+//
+// Caller must have the running "lock" for the profile.
+func (re *Render) mixedIDs(prof *confProfileMixed) ([]uint64, []string, error) {
+	var ids []uint64
+	var from []string
+
+	fl := re.l.With().Str("func", "mixedIDs").Str("OutputFile", prof.OutputFile).Logger()
+
+	// Loop through the mixed profiles to get the IDs we want.
+	//
+	// Note - prof.Profiles are not references, so access them by index so a new wp is kept.
+	for i := 0; i < len(prof.Profiles); i++ {
+		cpc := &prof.Profiles[i]
+
+		// Lets get the image IDs we need, up to a max of Depth.
+		tids, err := cpc.wp.Get(cpc.images)
+		if err != nil {
+			// If Weighter was shutdown, jut return.
+			if errors.Is(err, types.ErrShutdown) {
+				return nil, nil, err
+			}
+
+			// Something went wrong, lets see if we can fix it by getting a new
+			// WeighterProfile.
+			cpc.wp, err = re.getProfile(cpc.TagProfile)
+			if err != nil {
+				fl.Err(err).Msg("getProfile")
+				return nil, nil, err
+			}
+
+			// Ok, take 2 for getting the IDs.
+			if tids, err = cpc.wp.Get(cpc.images); err != nil {
+				fl.Err(err).Msg("WeighterProfile.Get")
+				return nil, nil, err
+			}
+		}
+
+		ids = append(ids, tids...)
+
+		for range tids {
+			from = append(from, cpc.TagProfile)
+		}
+	}
+
+	return ids, from, nil
+} // }}}
+
+// func Render.renderProfileMixed {{{
+
+func (re *Render) renderProfileMixed(prof *confProfileMixed) {
+	fl := re.l.With().Str("func", "renderProfileMixed").Str("OutputFile", prof.OutputFile).Logger()
+
+	// We use an atomic uint32 to let us know if we are already rendering
+	// an image for this profile.
+	if !atomic.CompareAndSwapUint32(&prof.running, 0, 1) {
+		return
+	}
+
+	defer atomic.StoreUint32(&prof.running, 0)
+
+	// Quiet hours replace the normal image entirely, including any prerendered.
+	if prof.Quiet.active(time.Now()) {
+		re.dropNext(prof.OutputFile)
+		prof.next = nil
+
+		ren, err := re.quiet(prof.Quiet, prof.Size, prof.Style.format, func() (*rendered, error) { return re.renderMixed(prof) })
+		if err != nil {
+			fl.Err(err).Msg("quiet")
+			return
+		}
+
+		if ren != nil {
+			if err := re.writeRendered(prof.OutputFile, ren, prof.Perm, prof.Aux); err != nil {
+				fl.Err(err).Msg("writeRendered")
+			}
+		}
+
+		return
+	}
+
+	// If we prerendered the image then just write it out.
+	ren := re.takeNext(prof.OutputFile, prof.next)
+	prof.next = nil
+
+	if ren == nil {
+		var err error
+
+		if ren, err = re.renderMixed(prof); err != nil {
+			if errors.Is(err, types.ErrShutdown) {
+				fl.Info().Msg("in shutdown")
+				return
+			}
+
+			re.renderFailed(prof.OutputFile, prof.Size, prof.Perm, prof.OnError, prof.Style.format, err)
+			re.renderErr(prof.OutputFile, err)
+			return
+		}
+	}
+
+	if err := re.writeRendered(prof.OutputFile, ren, prof.Perm, prof.Aux); err != nil {
+		fl.Err(err).Msg("writeRendered")
+		re.renderErr(prof.OutputFile, err)
+		return
+	}
+
+	re.renderOK(prof.OutputFile)
+
+	// Get the next one ready now, rather then when it is needed.
+	if prof.Prerender && !prof.Quiet.active(time.Now()) {
+		ren, _ := re.renderMixed(prof)
+		prof.next = re.setNext(prof.OutputFile, ren)
+	}
+} // }}}
+
+// func Render.renderMixed {{{
+
+// Selects and renders an image for the mixed profile.
+//
+// Caller must have the running "lock" for the profile.
+func (re *Render) renderMixed(prof *confProfileMixed) (*rendered, error) {
+	fl := re.l.With().Str("func", "renderMixed").Str("OutputFile", prof.OutputFile).Logger()
+
+	_, span := tracer.Start(re.ctx, "render.mixed", trace.WithAttributes(attribute.String("output", prof.OutputFile)))
+	defer span.End()
+
+	var ids []uint64
+	var from []string
+	var err error
+
+	// Carrying on from before a restart?
+	if sel := prof.resume; sel != nil {
+		prof.resume = nil
+		ids, from = sel.IDs, sel.Profiles
+	} else if ids, from, err = re.mixedIDs(prof); err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	ids, from = re.dropMissing(ids, from)
+
+	// For very new profiles this can happen that no IDs are returned.
+	//
+	// Or images being taken disabled/deleted that cause a profile to no longer have any.
+	if len(ids) < 1 {
+		err := errors.New("no images returned, nothing to render")
+		fl.Warn().Err(err).Send()
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("images", len(ids)))
+
+	// Now hand the details off to be rendered.
+	data, used, err := re.renderImage(prof.Size, ids, prof.Style)
+	if err != nil {
+		fl.Err(err).Msg("renderImage")
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	ren := &rendered{data: data}
+
+	for i, id := range ids[:used] {
+		ren.shown = append(ren.shown, types.ShownImage{ID: id, Profile: from[i]})
+	}
+
+	// A missing filmstrip is not worth failing the render over.
+	if prof.Aux.strip > 0 {
+		if ren.strip, err = re.renderStrip(ids[:used], prof.Aux.strip, prof.Style); err != nil {
+			fl.Err(err).Msg("renderStrip")
+		}
+	}
+
+	return ren, nil
+} // }}}
+
+// func Render.profileIDs {{{
+
+// Gets the IDs to render for a profile, along with the TagProfile they came from.
+//
+// Caller must have the running "lock" for the profile.
+func (re *Render) profileIDs(prof *confProfile) ([]uint64, string, error) {
+	fl := re.l.With().Str("func", "profileIDs").Str("OutputFile", prof.OutputFile).Logger()
+
+	// Which profile, if rotating.
+	name, wp := prof.current()
+
+	// Lets get the image IDs we need, up to a max of Depth.
+	ids, err := (*wp).Get(prof.Depth)
+	if err != nil {
+		// If Weighter was shutdown, jut return.
+		if errors.Is(err, types.ErrShutdown) {
+			return nil, "", err
+		}
+
+		// Something went wrong, lets see if we can fix it by getting a new
+		// WeighterProfile.
+		*wp, err = re.getProfile(name)
+		if err != nil {
+			fl.Err(err).Msg("getProfile")
+			return nil, "", err
+		}
+
+		// Ok, take 2 for getting the IDs.
+		if ids, err = (*wp).Get(prof.Depth); err != nil {
+			fl.Err(err).Msg("WeighterProfile.Get")
+			return nil, "", err
+		}
+	}
+
+	return ids, name, nil
+} // }}}
+
+// func Render.renderProfile {{{
+
+func (re *Render) renderProfile(prof *confProfile) {
+	fl := re.l.With().Str("func", "renderProfile").Str("OutputFile", prof.OutputFile).Logger()
+
+	// We use an atomic uint32 to let us know if we are already rendering
+	// an image for this profile.
+	if !atomic.CompareAndSwapUint32(&prof.running, 0, 1) {
+		return
+	}
+
+	defer atomic.StoreUint32(&prof.running, 0)
+
+	// Quiet hours replace the normal image entirely, including any prerendered.
+	if prof.Quiet.active(time.Now()) {
+		re.dropNext(prof.OutputFile)
+		prof.next = nil
+
+		ren, err := re.quiet(prof.Quiet, prof.Size, prof.Style.format, func() (*rendered, error) { return re.renderSingle(prof) })
+		if err != nil {
+			fl.Err(err).Msg("quiet")
+			return
+		}
+
+		if ren != nil {
+			if err := re.writeRendered(prof.OutputFile, ren, prof.Perm, prof.Aux); err != nil {
+				fl.Err(err).Msg("writeRendered")
+			}
+		}
+
+		return
+	}
+
+	// If we prerendered the image then just write it out.
+	ren := re.takeNext(prof.OutputFile, prof.next)
+	prof.next = nil
+
+	if ren == nil {
+		var err error
+
+		if ren, err = re.renderSingle(prof); err != nil {
+			if errors.Is(err, types.ErrShutdown) {
+				fl.Info().Msg("in shutdown")
+				return
+			}
+
+			re.renderFailed(prof.OutputFile, prof.Size, prof.Perm, prof.OnError, prof.Style.format, err)
+			re.renderErr(prof.OutputFile, err)
+			return
+		}
+	}
+
+	if err := re.writeRendered(prof.OutputFile, ren, prof.Perm, prof.Aux); err != nil {
+		fl.Err(err).Msg("writeRendered")
+		re.renderErr(prof.OutputFile, err)
+		return
+	}
+
+	re.renderOK(prof.OutputFile)
+
+	// Get the next one ready now, rather then when it is needed.
+	if prof.Prerender && !prof.Quiet.active(time.Now()) {
+		ren, _ := re.renderSingle(prof)
+		prof.next = re.setNext(prof.OutputFile, ren)
+	}
+} // }}}
+
+// func Render.renderSingle {{{
+
+// Selects and renders an image for the profile.
+//
+// Caller must have the running "lock" for the profile.
+func (re *Render) renderSingle(prof *confProfile) (*rendered, error) {
+	fl := re.l.With().Str("func", "renderSingle").Str("OutputFile", prof.OutputFile).Logger()
+
+	_, span := tracer.Start(re.ctx, "render.single", trace.WithAttributes(attribute.String("profile", prof.TagProfile), attribute.String("output", prof.OutputFile)))
+	defer span.End()
+
+	var ids []uint64
+	var name string
+	var err error
+
+	// Carrying on from before a restart? All from the same TagProfile, as single profiles select from one at a time.
+	if sel := prof.resume; sel != nil {
+		prof.resume = nil
+		ids, name = sel.IDs, sel.Profiles[0]
+	} else if ids, name, err = re.profileIDs(prof); err != nil {
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	ids, _ = re.dropMissing(ids, nil)
+
+	// For very new profiles this can happen that no IDs are returned.
+	//
+	// Or images being taken disabled/deleted that cause a profile to no longer have any.
+	if len(ids) < 1 {
+		err := errors.New("no images returned, nothing to render")
+		fl.Warn().Err(err).Send()
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("images", len(ids)))
+
+	// Now hand the details off to be rendered.
+	data, used, err := re.renderImage(prof.Size, ids, prof.Style)
+	if err != nil {
+		fl.Err(err).Msg("renderImage")
+		tracing.Fail(span, err)
+		return nil, err
+	}
+
+	ren := &rendered{data: data}
+
+	for _, id := range ids[:used] {
+		ren.shown = append(ren.shown, types.ShownImage{ID: id, Profile: name})
+	}
+
+	// A missing filmstrip is not worth failing the render over.
+	if prof.Aux.strip > 0 {
+		if ren.strip, err = re.renderStrip(ids[:used], prof.Aux.strip, prof.Style); err != nil {
+			fl.Err(err).Msg("renderStrip")
+		}
+	}
+
+	return ren, nil
+} // }}}
+
+// func Render.dropMissing {{{
+
+// Removes any IDs no longer in the cache, so a single missing image does not fail the whole render.
+//
+// from, if not nil, is the TagProfile of each ID and is kept matching.
+func (re *Render) dropMissing(ids []uint64, from []string) ([]uint64, []string) {
+	var keepFrom []string
+
+	keep := ids[:0]
+
+	for i, id := range ids {
+		if !re.cm.Has(id) {
+			re.l.Warn().Str("func", "dropMissing").Uint64("id", id).Msg("not cached")
+			re.invalidate(id)
+			continue
+		}
+
+		keep = append(keep, id)
+
+		if from != nil {
+			keepFrom = append(keepFrom, from[i])
+		}
+	}
+
+	return keep, keepFrom
+} // }}}
+
+// func Render.invalidate {{{
+
+// Lets the Weighter know the image is gone, so it stops giving it to us.
+func (re *Render) invalidate(id uint64) {
+	if inv, ok := re.we.(types.WeighterInvalidator); ok {
+		inv.Invalidate(id)
+	}
+} // }}}
+
+// func Render.toRGBA {{{
+
+func (re *Render) toRGBA(img image.Image) *image.RGBA {
+	// First basic check - Is the image already a RGBA?
+	if rgba, ok := img.(*image.RGBA); ok {
+		// Yep, no conversion needed then.
+		return rgba
+	}
+
+	// So we have to convert. Doing this all ourselves is a pain in the ass, so rather we let Go do it.
+	// We create a new image.RGBA of the same size and copy the pixels to it letting Go handle all the converisions.
+	//
+	// Get the size of the original image.
+	bnds := img.Bounds()
+
+	// Now make a new RGBA image with that size.
+	rgba := image.NewRGBA(bnds)
+
+	// Copy the source to the destination.
+	draw.Draw(rgba, bnds, img, image.Point{}, draw.Src)
+
+	return rgba
+} /// }}}
+
+// func Render.fillImage {{{
+
+// Provided an image and an ID, we fill the image as much as possible by resizing the ID to fit.
+//
+// We then return any portion of the image left that we were unable to fill.
+//
+// r provided is expected to be thread safe or the caller otherwise has a lock.
+//
+// If sb is not nil the image is kept there to be drawn later rather then drawn into img.
+func (re *Render) fillImage(img *image.RGBA, id uint64, r *rand.Rand, st outputStyle, sb *scrapbook) (*image.RGBA, error) {
+	var layoutFlip bool
+
+	fl := re.l.With().Str("func", "fillImage").Logger()
+
+	// Lets get the current image size.
+	imgB := img.Bounds()
+	imgS := imgB.Size()
+
+	// Now get the resized ID image.
+	var tmpImg image.Image
+	var err error
+
+	if st.zoom > 0 {
+		tmpImg, err = re.loadZoomed(id, imgS, st.zoom, r)
+	} else {
+		tmpImg, err = re.cm.LoadImage(id, imgS, true)
+	}
+
+	if err != nil {
+		fl.Err(err).Uint64("id", id).Msg("LoadImage")
+
+		// If the image is gone then let the Weighter know, so it stops giving it to us.
+		if errors.Is(err, types.ErrNotFound) {
+			re.invalidate(id)
+		}
+
+		return nil, err
+	}
+
+	// Ensure its an image.RGBA, so all images are consistent.
+	idImg := re.toRGBA(tmpImg)
+
+	// Ok, we asked the image to be resized to fit at least 1 dimension (width or height) fully.
+	// So unless the image is an exact fit, we expect to have some pixels available on one of
+	// those dimensions.
+	//
+	// Lets figure out which has the most pixels?
+	idB := idImg.Bounds()
+	idS := idB.Size()
+
+	// Sometimes there can be an exact match.
+	//
+	// Do we have one here?
+	if imgS == idS {
+		fl.Debug().Stringer("imgS", imgS).Stringer("idS", idS).Msg("perfect fit")
+
+		// Perfect fit.
+		placeCell(img, imgB, idImg, st, sb)
+		return nil, nil
+	}
+
+	// Do we flip the layout or not?
+	//
+	// Meaning, rather then the top/left, we align to bottom/right
+	if r.Intn(2) > 0 {
+		layoutFlip = true
+	}
+
+	// This will be adjusted to whatever area is left over after we figure out where
+	// idImg fits within img.
+	emptySpace := imgB
+
+	// Where idImg will be palced within img.
+	//
+	// We pass this in to draw.Draw() so it can place the image properly.
+	newLoc := imgB
+
+	// Lets figure out the location within img to put idImg.
+	if layoutFlip {
+		// Ok, bottom/left alignment here.
+		//
+		// Is the width the same between the two images?
+		if imgS.X == idS.X {
+			// Ok, width is the same. Not an exact fit, so we expect left over space on the height.
+			//
+			// Since we are flipped, we set the new Min for Y (height) to be from the bottom, leaving any empty
+			// space at the top.
+			//
+			newLoc.Min.Y = imgB.Max.Y - idS.Y
+
+			// Remove the pixels used by the image from the empty space.
+			emptySpace.Max.Y = newLoc.Min.Y
+		} else {
+			// Ok, going by width here.
+			// Pretty much identical to above, except on X (width).
+			newLoc.Min.X = imgB.Max.X - idS.X
+			emptySpace.Max.X = newLoc.Min.X
+		}
+	} else {
+		// We are not flipped.
+		// So rather then placing the image on the bottom/right, we are placing it on the top/left.
+		if imgS.X == idS.X {
+			// We have left over height.
+			newLoc.Max.Y = newLoc.Min.Y + idS.Y
+
+			// Empty space now starts after the image above, so just set its Min to the previous MaxD.
+			emptySpace.Min.Y = newLoc.Max.Y
+		} else {
+			// Left over width.
+			//
+			// Same logic as above, just for X this time.
+			newLoc.Max.X = newLoc.Min.X + idS.X
+			emptySpace.Min.X = newLoc.Max.X
+		}
+	}
+
+	fl.Debug().Stringer("imgS", imgS).Stringer("idS", idS).Stringer("newLoc", newLoc).Stringer("emptySpace", emptySpace).Bool("layoutFlip", layoutFlip).Msg("dimensions")
+
+	// Now copy the image inside out existing one.
+	placeCell(img, newLoc, idImg, st, sb)
+
+	// If emptySpace is too small, we do not return an image.
+	esS := emptySpace.Bounds().Size()
+	if least := st.px(10); esS.X < least || esS.Y < least {
+		return nil, nil
+	}
+
+	// emptySpace is large enough to fit something else, so get it to return.
+	subImg := img.SubImage(emptySpace).(*image.RGBA)
+
+	fl.Debug().Send()
+
+	return subImg, nil
+} // }}}
+
+// func Render.schedule {{{
+
+// Sets up a scheduler task for each distinct WriteInterval.
+//
+// All profiles sharing the same interval are rendered on the same tick, which lets the manifest commit them together.
+//
+// Intervals still in use are left as-is, so a reload does not push back their next render.
+func (re *Render) schedule(co *conf) {
+	fl := re.l.With().Str("func", "schedule").Logger()
+
+	wanted := make(map[time.Duration]bool)
+
+	for _, prof := range co.Profiles {
+		wanted[prof.WriteInterval] = true
+	}
+
+	for _, prof := range co.MixProfiles {
+		wanted[prof.WriteInterval] = true
+	}
+
+	// Remove any no longer used.
+	for dur, _ := range re.sInts {
+		if !wanted[dur] {
+			re.sch.Remove("interval " + dur.String())
+			delete(re.sInts, dur)
+		}
+	}
+
+	for dur, _ := range wanted {
+		if re.sInts[dur] {
+			continue
+		}
+
+		// Copy so each task has its own.
+		wi := dur
+		if err := re.sch.Add("interval "+dur.String(), dur, func() { re.tickInterval(wi) }); err != nil {
+			fl.Err(err).Stringer("interval", dur).Msg("Add")
+			continue
+		}
+
+		re.sInts[dur] = true
+	}
+
+	// Archive housekeeping, left alone if already scheduled for the same reason as the intervals.
+	if co.Archive == nil {
+		re.sch.Remove("archive")
+	} else if _, ok := re.sch.Next("archive"); !ok {
+		if err := re.sch.Add("archive", time.Hour, re.tidyArchive); err != nil {
+			fl.Err(err).Msg("Add")
+		}
+	}
+
+	fl.Debug().Int("intervals", len(re.sInts)).Send()
+} // }}}
+
+// func Render.Hold {{{
+
+// Stops the scheduled renders while hold is true, such as while the display is off and nobody would see them.
+//
+// Unlike pausing the scheduler this only affects Render, so scans and merges carry on.
+func (re *Render) Hold(hold bool) {
+	var v uint32
+	if hold {
+		v = 1
+	}
+
+	atomic.StoreUint32(&re.hold, v)
+} // }}}
+
+// func Render.tickInterval {{{
+
+// Run by the scheduler, renders every profile with the WriteInterval.
+func (re *Render) tickInterval(wi time.Duration) {
+	var profiles []*confProfile
+	var mixed []*confProfileMixed
+
+	fl := re.l.With().Str("func", "tickInterval").Stringer("interval", wi).Logger()
+
+	if atomic.LoadUint32(&re.hold) == 1 {
+		fl.Debug().Msg("held")
+		return
+	}
+
+	co := re.getConf()
+
+	for _, prof := range co.Profiles {
+		if prof.WriteInterval == wi && !re.quarantined(prof.OutputFile) {
+			profiles = append(profiles, prof)
+		}
+	}
+
+	for _, prof := range co.MixProfiles {
+		if prof.WriteInterval == wi && !re.quarantined(prof.OutputFile) {
+			mixed = append(mixed, prof)
+		}
+	}
+
+	// Committing everything together? Then its all done at once.
+	if co.Manifest != "" {
+		go re.renderCommit(profiles, mixed, co.Manifest)
+		return
+	}
+
+	for _, prof := range profiles {
+		fl.Debug().Str("file", prof.OutputFile).Msg("profileTick")
+		go re.renderProfile(prof)
+	}
+
+	for _, prof := range mixed {
+		fl.Debug().Str("file", prof.OutputFile).Msg("mixedTick")
+		go re.renderProfileMixed(prof)
+	}
+} // }}}
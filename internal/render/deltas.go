@@ -0,0 +1,111 @@
+package render
+
+import (
+	"frame/types"
+	"sync/atomic"
+)
+
+// A prerendered image can sit for a full WriteInterval before it is written out, so if the Weighter removes one of
+// the images it shows in the meantime (disabled, deleted, no longer on the whitelist) it is thrown away and a fresh
+// one rendered instead.
+//
+// Only possible when the Weighter provides types.WeighterSubscriber, otherwise prerendered images are always used.
+
+// func Render.subscribe {{{
+
+// Starts watching the Weighter for removed images, if it supports it.
+func (re *Render) subscribe() {
+	ws, ok := re.we.(types.WeighterSubscriber)
+	if !ok {
+		return
+	}
+
+	ch, unsub := ws.Subscribe()
+
+	go func() {
+		defer unsub()
+
+		for {
+			select {
+			case <-re.ctx.Done():
+				return
+			case delta, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				if len(delta.Removed) > 0 {
+					re.removed(delta.Removed)
+				}
+			}
+		}
+	}()
+} // }}}
+
+// func Render.removed {{{
+
+// Marks any prerendered image showing one of ids as stale.
+func (re *Render) removed(ids []uint64) {
+	gone := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		gone[id] = true
+	}
+
+	re.pMut.Lock()
+	defer re.pMut.Unlock()
+
+	for file, ren := range re.pre {
+		for _, si := range ren.shown {
+			if !gone[si.ID] {
+				continue
+			}
+
+			re.l.Debug().Str("func", "removed").Str("OutputFile", file).Uint64("id", si.ID).Msg("prerendered image stale")
+
+			atomic.StoreUint32(&ren.stale, 1)
+			delete(re.pre, file)
+			break
+		}
+	}
+} // }}}
+
+// func Render.setNext {{{
+
+// Keeps track of the image prerendered for the output so removed() can find it, returning it as-is.
+func (re *Render) setNext(file string, ren *rendered) *rendered {
+	if ren == nil {
+		return nil
+	}
+
+	re.pMut.Lock()
+	defer re.pMut.Unlock()
+
+	if re.pre == nil {
+		re.pre = make(map[string]*rendered)
+	}
+
+	re.pre[file] = ren
+	return ren
+} // }}}
+
+// func Render.takeNext {{{
+
+// Stops tracking the image prerendered for the output, returning it or nil if there was none or it is stale.
+func (re *Render) takeNext(file string, ren *rendered) *rendered {
+	re.dropNext(file)
+
+	if ren == nil || atomic.LoadUint32(&ren.stale) == 1 {
+		return nil
+	}
+
+	return ren
+} // }}}
+
+// func Render.dropNext {{{
+
+// Stops tracking the image prerendered for the output.
+func (re *Render) dropNext(file string) {
+	re.pMut.Lock()
+	delete(re.pre, file)
+	re.pMut.Unlock()
+} // }}}
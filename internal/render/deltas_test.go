@@ -0,0 +1,68 @@
+package render
+
+import (
+	"context"
+	"frame/types"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type subWeighter struct {
+	ch    chan types.WeighterDelta
+	unsub chan struct{}
+}
+
+func (sw *subWeighter) GetProfile(string) (types.WeighterProfile, error) {
+	return nil, types.ErrNotFound
+}
+
+func (sw *subWeighter) Subscribe() (<-chan types.WeighterDelta, func()) {
+	return sw.ch, func() { close(sw.unsub) }
+}
+
+func TestPrerenderRemoved(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sw := &subWeighter{ch: make(chan types.WeighterDelta), unsub: make(chan struct{})}
+
+	re := &Render{l: zerolog.Nop(), we: sw, ctx: ctx}
+	re.subscribe()
+
+	a := re.setNext("a.webp", &rendered{shown: []types.ShownImage{{ID: 1}, {ID: 2}}})
+	b := re.setNext("b.webp", &rendered{shown: []types.ShownImage{{ID: 3}}})
+
+	// Sent unbuffered, so once the second is taken the first has been handled.
+	sw.ch <- types.WeighterDelta{Added: []uint64{4}, Removed: []uint64{2}}
+	sw.ch <- types.WeighterDelta{}
+
+	if got := re.takeNext("a.webp", a); got != nil {
+		t.Fatalf("a Expected stale nil != Got %v", got)
+	}
+
+	if got := re.takeNext("b.webp", b); got != b {
+		t.Fatalf("b Expected %p != Got %p", b, got)
+	}
+
+	// Nothing was prerendered.
+	if got := re.takeNext("c.webp", nil); got != nil {
+		t.Fatalf("c Expected nil != Got %v", got)
+	}
+
+	// Taken images are no longer tracked.
+	re.removed([]uint64{3})
+
+	if b.stale != 0 {
+		t.Fatal("b marked stale after being taken")
+	}
+
+	cancel()
+
+	select {
+	case <-sw.unsub:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not unsubscribe on shutdown")
+	}
+}
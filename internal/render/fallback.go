@@ -0,0 +1,120 @@
+package render
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// What to do with the output file when rendering a profile fails, see confProfileYAML.OnError.
+const (
+	onErrorKeep = iota
+	onErrorPlaceholder
+	onErrorDelete
+)
+
+// func parseOnError {{{
+
+func parseOnError(in string) (int, error) {
+	switch in {
+	case "", "keep":
+		return onErrorKeep, nil
+	case "placeholder":
+		return onErrorPlaceholder, nil
+	case "delete":
+		return onErrorDelete, nil
+	}
+
+	return 0, errors.New("invalid onerror")
+} // }}}
+
+// func Render.renderFailed {{{
+
+// Called when rendering a profile failed, does whatever the profile has configured for onerror.
+func (re *Render) renderFailed(file string, size image.Point, perm filePerm, onError, format int, rerr error) {
+	fl := re.l.With().Str("func", "renderFailed").Str("OutputFile", file).Logger()
+
+	switch onError {
+	case onErrorPlaceholder:
+		data, err := placeholder(size, format, rerr)
+		if err != nil {
+			fl.Err(err).Msg("placeholder")
+			return
+		}
+
+		if err := re.writeImage(file, data, perm); err != nil {
+			fl.Err(err).Msg("writeImage")
+			return
+		}
+
+		fl.Info().Msg("placeholder written")
+	case onErrorDelete:
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			fl.Err(err).Msg("Remove")
+			return
+		}
+
+		fl.Info().Msg("removed")
+	}
+} // }}}
+
+// func placeholder {{{
+
+// Creates an image of the given size showing the error and when it happened.
+//
+// Meant to be seen from across the room, so the text is scaled up to a decent size for the image.
+func placeholder(size image.Point, format int, rerr error) ([]byte, error) {
+	// The basic font is 7x13, which would be unreadable on a large display.
+	//
+	// So draw onto a smaller image that we then scale up.
+	scale := size.X / 400
+	if scale < 1 {
+		scale = 1
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, size.X/scale, size.Y/scale))
+	draw.Draw(small, small.Bounds(), image.NewUniform(color.RGBA{40, 40, 40, 255}), image.Point{}, draw.Src)
+
+	msg := "unknown error"
+	if rerr != nil {
+		msg = rerr.Error()
+	}
+
+	lines := []string{"Render failed", time.Now().Format("2006-01-02 15:04:05"), ""}
+
+	// Wrap the error to fit.
+	perLine := (small.Bounds().Dx() - 20) / 7
+	if perLine < 1 {
+		perLine = 1
+	}
+
+	for len(msg) > perLine {
+		lines = append(lines, msg[:perLine])
+		msg = msg[perLine:]
+	}
+
+	lines = append(lines, msg)
+
+	d := &font.Drawer{
+		Dst:  small,
+		Src:  image.NewUniform(color.RGBA{230, 230, 230, 255}),
+		Face: basicfont.Face7x13,
+	}
+
+	for i, line := range lines {
+		d.Dot = fixed.P(10, 20+i*16)
+		d.DrawString(line)
+	}
+
+	img := imaging.Resize(small, size.X, size.Y, imaging.NearestNeighbor)
+
+	return encodeImage(img, format)
+} // }}}
@@ -0,0 +1,98 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	fimg "frame/image"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// The lossy WebP qualities tried in turn by fitImage() before scaling down.
+var fitQualities = []float32{90, 75, 60, 45, 30}
+
+// How many times fitImage() scales down before giving up, and the smallest side it goes to.
+const (
+	fitScaleTries = 6
+	fitMinSide    = 16
+)
+
+// func fitImage {{{
+
+// Encodes the image in the format of the style, no larger then its maxBytes.
+//
+// WebP is first encoded lossy at lower and lower quality (see fitQualities), then scaled down at the lowest. PNG can
+// only be scaled down. Each scale is guessed from how far over the last try was, as the size goes by the pixels.
+//
+// Returns an error if it still does not fit, so the last output is kept rather then one the frame rejects.
+func fitImage(img image.Image, st outputStyle) ([]byte, error) {
+	data, err := encodeImage(img, st.format)
+	if err != nil || st.maxBytes <= 0 || int64(len(data)) <= st.maxBytes {
+		return data, err
+	}
+
+	lossy := st.format == formatWebP && fimg.WebPEncoder
+
+	// The quality used when scaling down, lossless if that is all we have.
+	var quality float32
+
+	if lossy {
+		for _, quality = range fitQualities {
+			if data, err = encodeLossy(img, quality); err != nil {
+				return nil, err
+			}
+
+			if int64(len(data)) <= st.maxBytes {
+				return data, nil
+			}
+		}
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	for i := 0; i < fitScaleTries; i++ {
+		// A little under what should fit, and always at least a tenth smaller.
+		scale := math.Sqrt(float64(st.maxBytes)/float64(len(data))) * 0.95
+		if scale > 0.9 {
+			scale = 0.9
+		}
+
+		w, h = int(float64(w)*scale), int(float64(h)*scale)
+		if w < fitMinSide || h < fitMinSide {
+			break
+		}
+
+		small := imaging.Resize(img, w, h, imaging.Lanczos)
+
+		if lossy {
+			data, err = encodeLossy(small, quality)
+		} else {
+			data, err = encodeImage(small, st.format)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(data)) <= st.maxBytes {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can not fit within maxbytes %d, smallest was %d", st.maxBytes, len(data))
+} // }}}
+
+// func encodeLossy {{{
+
+func encodeLossy(img image.Image, quality float32) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if err := fimg.SaveImageWebPLossy(buf, img, quality); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+} // }}}
@@ -0,0 +1,56 @@
+package render
+
+import (
+	"bytes"
+	fimg "frame/image"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestFitImage(t *testing.T) {
+	// Noise, so it compresses poorly.
+	r := rand.New(rand.NewSource(1))
+
+	img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255})
+		}
+	}
+
+	for _, format := range []int{formatPNG, formatWebP} {
+		full, err := encodeImage(img, format)
+		if err != nil {
+			t.Fatalf("encodeImage(%d): %s", format, err)
+		}
+
+		st := outputStyle{format: format, maxBytes: int64(len(full) / 3)}
+
+		data, err := fitImage(img, st)
+		if err != nil {
+			t.Fatalf("fitImage(%d): %s", format, err)
+		}
+
+		if int64(len(data)) > st.maxBytes {
+			t.Fatalf("fitImage(%d) Expected <= %d != Got %d", format, st.maxBytes, len(data))
+		}
+
+		if _, err := fimg.LoadReader(bytes.NewReader(data)); err != nil {
+			t.Fatalf("fitImage(%d) LoadReader: %s", format, err)
+		}
+
+		// No limit is the same as encodeImage().
+		st.maxBytes = 0
+		if data, _ := fitImage(img, st); len(data) != len(full) {
+			t.Fatalf("fitImage(%d) no limit Expected %d != Got %d", format, len(full), len(data))
+		}
+
+		// Far too small to ever fit.
+		st.maxBytes = 10
+		if _, err := fitImage(img, st); err == nil {
+			t.Fatalf("fitImage(%d) fit within 10 bytes", format)
+		}
+	}
+}
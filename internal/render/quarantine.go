@@ -0,0 +1,163 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// An OutputFile that fails to render QuarantineAfter times in a row is quarantined, and no longer rendered until the
+// configuration changes or its marker file (OutputFile.quarantined) is removed.
+//
+// Otherwise a profile with a bad output path or broken TagProfile logs the same error every WriteInterval forever.
+
+// type quarantine struct {{{
+
+// Written as JSON to the marker file of a quarantined OutputFile.
+type quarantine struct {
+	Since    time.Time `json:"since"`
+	Failures int       `json:"failures"`
+
+	// The last error.
+	Error string `json:"error"`
+} // }}}
+
+// func quarantineFile {{{
+
+// Returns the marker file of the output, removing it lifts the quarantine.
+func quarantineFile(file string) string {
+	return file + ".quarantined"
+} // }}}
+
+// func Render.renderOK {{{
+
+// Called after each successful render of the output, resetting its failures.
+func (re *Render) renderOK(file string) {
+	re.qMut.Lock()
+	delete(re.fails, file)
+	re.qMut.Unlock()
+} // }}}
+
+// func Render.renderErr {{{
+
+// Called after each failed render of the output, quarantining it once it has failed QuarantineAfter times in a row.
+func (re *Render) renderErr(file string, rerr error) {
+	co := re.getConf()
+
+	re.qMut.Lock()
+	defer re.qMut.Unlock()
+
+	if re.fails == nil {
+		re.fails = make(map[string]int)
+	}
+
+	re.fails[file]++
+
+	if co.QuarantineAfter <= 0 || re.fails[file] < co.QuarantineAfter {
+		return
+	}
+
+	if _, ok := re.quar[file]; ok {
+		return
+	}
+
+	q := quarantine{Since: time.Now(), Failures: re.fails[file], Error: rerr.Error()}
+
+	if re.quar == nil {
+		re.quar = make(map[string]quarantine)
+	}
+
+	re.quar[file] = q
+
+	fl := re.l.With().Str("func", "renderErr").Str("OutputFile", file).Logger()
+
+	// Quarantined either way, the marker is only needed to lift it early.
+	if data, err := json.MarshalIndent(&q, "", "  "); err != nil {
+		fl.Err(err).Msg("Marshal")
+	} else if err := ioutil.WriteFile(quarantineFile(file), data, 0644); err != nil {
+		fl.Err(err).Msg("WriteFile")
+	}
+
+	fl.Warn().Err(rerr).Int("failures", q.Failures).Msg("quarantined, remove the .quarantined file or change the configuration to render again")
+} // }}}
+
+// func Render.quarantined {{{
+
+// Returns true if the output is quarantined and should not be rendered.
+//
+// Lifts the quarantine if its marker file was removed.
+func (re *Render) quarantined(file string) bool {
+	re.qMut.Lock()
+	defer re.qMut.Unlock()
+
+	if _, ok := re.quar[file]; !ok {
+		return false
+	}
+
+	if _, err := os.Stat(quarantineFile(file)); !errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+
+	delete(re.quar, file)
+	delete(re.fails, file)
+
+	re.l.Info().Str("OutputFile", file).Msg("quarantine lifted")
+
+	return false
+} // }}}
+
+// func Render.releaseAll {{{
+
+// Lifts every quarantine and forgets all failures, such as when the configuration changes.
+//
+// Also removes any marker files of co left from before a restart.
+func (re *Render) releaseAll(co *conf) {
+	fl := re.l.With().Str("func", "releaseAll").Logger()
+
+	re.qMut.Lock()
+	defer re.qMut.Unlock()
+
+	files := make(map[string]bool, len(re.quar))
+
+	for file := range re.quar {
+		files[file] = true
+	}
+
+	for _, prof := range co.Profiles {
+		files[prof.OutputFile] = true
+	}
+
+	for _, prof := range co.MixProfiles {
+		files[prof.OutputFile] = true
+	}
+
+	for file := range files {
+		if err := os.Remove(quarantineFile(file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fl.Err(err).Str("OutputFile", file).Msg("Remove")
+		}
+	}
+
+	re.quar = nil
+	re.fails = nil
+} // }}}
+
+// func Render.Quarantined {{{
+
+// Returns the OutputFiles currently quarantined, sorted.
+func (re *Render) Quarantined() []string {
+	re.qMut.Lock()
+	defer re.qMut.Unlock()
+
+	files := make([]string, 0, len(re.quar))
+
+	for file := range re.quar {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	return files
+} // }}}
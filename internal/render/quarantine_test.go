@@ -0,0 +1,70 @@
+package render
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "frame.webp")
+
+	co := &conf{
+		QuarantineAfter: 3,
+		Profiles:        []*confProfile{{OutputFile: file}},
+	}
+
+	re := &Render{l: zerolog.Nop()}
+	re.co.Store(co)
+
+	rerr := errors.New("no images")
+
+	// A success in between starts the count again.
+	re.renderErr(file, rerr)
+	re.renderErr(file, rerr)
+	re.renderOK(file)
+	re.renderErr(file, rerr)
+	re.renderErr(file, rerr)
+
+	if re.quarantined(file) {
+		t.Fatal("quarantined after 2 failures in a row")
+	}
+
+	re.renderErr(file, rerr)
+
+	if !re.quarantined(file) {
+		t.Fatal("not quarantined after 3 failures in a row")
+	}
+
+	if quar := re.Quarantined(); len(quar) != 1 || quar[0] != file {
+		t.Fatalf("Quarantined Expected [%s] != Got %v", file, quar)
+	}
+
+	// Removing the marker lifts it.
+	if err := os.Remove(quarantineFile(file)); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+
+	if re.quarantined(file) {
+		t.Fatal("still quarantined without the marker")
+	}
+
+	// As does a configuration change, which also removes the marker.
+	for i := 0; i < 3; i++ {
+		re.renderErr(file, rerr)
+	}
+
+	re.releaseAll(co)
+
+	if re.quarantined(file) || len(re.Quarantined()) != 0 {
+		t.Fatal("still quarantined after releaseAll")
+	}
+
+	if _, err := os.Stat(quarantineFile(file)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("marker not removed: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	fimg "frame/image"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// What to do during quiet hours, see confProfileYAML.QuietMode.
+const (
+	quietSkip = iota
+	quietBlack
+	quietClock
+	quietDim
+)
+
+// type quietHours struct {{{
+
+type quietHours struct {
+	// Minutes after midnight, local time.
+	start int
+	end   int
+
+	mode int
+
+	// How much of the brightness to keep for quietDim, 0 to 1.
+	dim float64
+} // }}}
+
+// func parseQuiet {{{
+
+// Returns nil if the profile has no quiet hours.
+func parseQuiet(start, end, mode string, dim float64) (*quietHours, error) {
+	var err error
+
+	if start == "" && end == "" {
+		return nil, nil
+	}
+
+	q := &quietHours{dim: dim}
+
+	if q.start, err = parseClock(start); err != nil {
+		return nil, errors.New("invalid quietstart")
+	}
+
+	if q.end, err = parseClock(end); err != nil {
+		return nil, errors.New("invalid quietend")
+	}
+
+	if q.start == q.end {
+		return nil, errors.New("quietstart and quietend are the same")
+	}
+
+	switch mode {
+	case "", "skip":
+		q.mode = quietSkip
+	case "black":
+		q.mode = quietBlack
+	case "clock":
+		q.mode = quietClock
+	case "dim":
+		q.mode = quietDim
+	default:
+		return nil, errors.New("invalid quietmode")
+	}
+
+	if q.dim == 0 {
+		q.dim = 0.3
+	}
+
+	if q.dim < 0 || q.dim > 1 {
+		return nil, errors.New("quietdim needs to be between 0 and 1")
+	}
+
+	return q, nil
+} // }}}
+
+// func parseClock {{{
+
+// Parses a time of day such as "23:00", returning the minutes after midnight.
+func parseClock(in string) (int, error) {
+	t, err := time.Parse("15:04", in)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+} // }}}
+
+// func quietHours.active {{{
+
+// Returns true if t is within the quiet hours, always false for nil.
+func (q *quietHours) active(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	m := t.Hour()*60 + t.Minute()
+
+	// Wraps past midnight?
+	if q.start > q.end {
+		return m >= q.start || m < q.end
+	}
+
+	return m >= q.start && m < q.end
+} // }}}
+
+// func Render.quiet {{{
+
+// Returns what to write instead of the normal image during quiet hours, nil if nothing should be.
+//
+// render is only called for quietDim, to get the normal image that is then darkened.
+func (re *Render) quiet(q *quietHours, size image.Point, format int, render func() (*rendered, error)) (*rendered, error) {
+	var err error
+	var data []byte
+
+	switch q.mode {
+	case quietBlack:
+		data, err = quietImage(size, format, "")
+	case quietClock:
+		data, err = quietImage(size, format, time.Now().Format("15:04"))
+	case quietDim:
+		ren, err := render()
+		if err != nil {
+			return nil, err
+		}
+
+		// Still shown, just darker.
+		if ren.data, err = dimImage(ren.data, format, q.dim); err != nil {
+			return nil, err
+		}
+
+		return ren, nil
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &rendered{data: data}, nil
+} // }}}
+
+// func quietImage {{{
+
+// Creates a black image of the given size, with the text drawn dimly in the middle if not empty.
+func quietImage(size image.Point, format int, text string) ([]byte, error) {
+	// Same as placeholder(), draw onto a smaller image and scale it up so the text is readable from across the room.
+	scale := size.X / 80
+	if scale < 1 {
+		scale = 1
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, size.X/scale, size.Y/scale))
+	draw.Draw(small, small.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+
+	if text != "" {
+		d := &font.Drawer{
+			Dst:  small,
+			Src:  image.NewUniform(color.RGBA{50, 50, 50, 255}),
+			Face: basicfont.Face7x13,
+		}
+
+		// The basic font is 7x13.
+		b := small.Bounds()
+		d.Dot = fixed.P((b.Dx()-len(text)*7)/2, (b.Dy()+13)/2)
+		d.DrawString(text)
+	}
+
+	img := imaging.Resize(small, size.X, size.Y, imaging.NearestNeighbor)
+
+	return encodeImage(img, format)
+} // }}}
+
+// func dimImage {{{
+
+// Darkens the encoded image to keep only the fraction of its brightness.
+func dimImage(data []byte, format int, keep float64) ([]byte, error) {
+	img, err := fimg.LoadReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("dim: %s", err)
+	}
+
+	nimg := imaging.Clone(img)
+
+	for i := 0; i < len(nimg.Pix); i += 4 {
+		nimg.Pix[i] = uint8(float64(nimg.Pix[i]) * keep)
+		nimg.Pix[i+1] = uint8(float64(nimg.Pix[i+1]) * keep)
+		nimg.Pix[i+2] = uint8(float64(nimg.Pix[i+2]) * keep)
+	}
+
+	return encodeImage(nimg, format)
+} // }}}
@@ -0,0 +1,68 @@
+package render
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	at := func(clock string) time.Time {
+		c, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return time.Date(2020, 1, 1, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+
+	night, err := parseQuiet("23:00", "07:00", "dim", 0)
+	if err != nil {
+		t.Fatalf("parseQuiet: %s", err)
+	}
+
+	if night.mode != quietDim || night.dim != 0.3 {
+		t.Fatalf("parseQuiet defaults: %+v", night)
+	}
+
+	nap, err := parseQuiet("13:30", "15:00", "", 0)
+	if err != nil {
+		t.Fatalf("parseQuiet: %s", err)
+	}
+
+	tests := []struct {
+		Quiet    *quietHours
+		Clock    string
+		Expected bool
+	}{
+		// Wrapping past midnight.
+		{night, "22:59", false},
+		{night, "23:00", true},
+		{night, "03:00", true},
+		{night, "06:59", true},
+		{night, "07:00", false},
+
+		// Within the same day.
+		{nap, "13:29", false},
+		{nap, "13:30", true},
+		{nap, "15:00", false},
+
+		// No quiet hours.
+		{nil, "03:00", false},
+	}
+
+	for _, test := range tests {
+		if got := test.Quiet.active(at(test.Clock)); got != test.Expected {
+			t.Errorf("active(%s) %+v Expected %v != Got %v", test.Clock, test.Quiet, test.Expected, got)
+		}
+	}
+
+	if q, err := parseQuiet("", "", "black", 0); q != nil || err != nil {
+		t.Errorf("parseQuiet without hours = %v, %v", q, err)
+	}
+
+	for _, bad := range [][3]string{{"25:00", "07:00", ""}, {"23:00", "23:00", ""}, {"23:00", "07:00", "loud"}} {
+		if _, err := parseQuiet(bad[0], bad[1], bad[2], 0); err == nil {
+			t.Errorf("parseQuiet(%q) accepted", bad)
+		}
+	}
+}
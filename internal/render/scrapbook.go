@@ -0,0 +1,164 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+
+	"github.com/disintegration/imaging"
+)
+
+// The default of confProfileYAML.ScrapbookRotate, in degrees.
+const scrapDefaultRotate = 4
+
+// How large each image is drawn compared to its cell, leaving a little of the background around it.
+const scrapScale = 0.92
+
+// How far each image can be moved off the center of its cell, as a fraction of the cell size.
+const scrapJitter = 0.04
+
+// How dark the drop shadow is at its darkest, 0 to 1.
+const scrapShadow = 0.5
+
+// type scrapCell struct {{{
+
+// A single image placed by fillImage(), waiting to be drawn.
+type scrapCell struct {
+	r   image.Rectangle
+	img *image.RGBA
+} // }}}
+
+// type scrapbook struct {{{
+
+// Collects the images of a render in scrapbook style (see confProfileYAML.Scrapbook), drawing them all at once
+// when done.
+//
+// The images are not kept within their cells, so they can not be drawn as they are placed the way drawCell() does.
+// Drawing into the sub image of a cell clips everything outside of it.
+type scrapbook struct {
+	// Most an image is rotated either way, in degrees.
+	rotate float64
+
+	r *rand.Rand
+
+	cells []scrapCell
+} // }}}
+
+// func scrapbook.add {{{
+
+func (sb *scrapbook) add(r image.Rectangle, img *image.RGBA) {
+	sb.cells = append(sb.cells, scrapCell{r: r, img: img})
+} // }}}
+
+// func scrapbook.draw {{{
+
+// Draws every image onto dst in the order they were placed, each slightly smaller then its cell with a white border
+// and drop shadow, rotated and moved slightly at random so they overlap their neighbours.
+func (sb *scrapbook) draw(dst *image.RGBA) {
+	for _, sc := range sb.cells {
+		size := sc.r.Size()
+
+		short := size.X
+		if size.Y < short {
+			short = size.Y
+		}
+
+		// Both scale with the image, so the smallest look the same as the largest.
+		border := short / 40
+		if border < 2 {
+			border = 2
+		}
+
+		blur := float64(short) / 80
+		if blur < 1 {
+			blur = 1
+		}
+
+		var img image.Image = sc.img
+
+		// Too small to bother, shrinking it would only blur it.
+		if short > 50 {
+			img = imaging.Resize(sc.img, int(float64(size.X)*scrapScale), int(float64(size.Y)*scrapScale), imaging.Linear)
+		}
+
+		angle := (sb.r.Float64()*2 - 1) * sb.rotate
+		photo := scrapPhoto(img, border, angle)
+
+		// Centered on the cell, give or take the jitter.
+		center := image.Point{
+			X: sc.r.Min.X + size.X/2 + int((sb.r.Float64()*2-1)*scrapJitter*float64(size.X)),
+			Y: sc.r.Min.Y + size.Y/2 + int((sb.r.Float64()*2-1)*scrapJitter*float64(size.Y)),
+		}
+
+		pb := photo.Bounds()
+		at := center.Sub(image.Point{pb.Dx() / 2, pb.Dy() / 2})
+
+		// The shadow falls down and to the right, as if lit from the top left.
+		shadow, pad := scrapShadowMask(photo, blur)
+		offset := image.Point{int(blur), int(blur)}
+		sr := shadow.Bounds().Add(at).Sub(image.Point{pad, pad}).Add(offset)
+
+		draw.DrawMask(dst, sr, image.NewUniform(color.Black), image.Point{}, shadow, shadow.Bounds().Min, draw.Over)
+		draw.Draw(dst, pb.Add(at), photo, pb.Min, draw.Over)
+	}
+} // }}}
+
+// func scrapPhoto {{{
+
+// Returns the image within a white border, rotated counter-clockwise by angle degrees.
+//
+// Anything outside of the rotated image is transparent, and the edges are interpolated so they do not look jagged.
+func scrapPhoto(img image.Image, border int, angle float64) *image.NRGBA {
+	b := img.Bounds()
+
+	framed := imaging.New(b.Dx()+border*2, b.Dy()+border*2, color.White)
+	draw.Draw(framed, b.Sub(b.Min).Add(image.Point{border, border}), img, b.Min, draw.Over)
+
+	if angle == 0 {
+		return framed
+	}
+
+	return imaging.Rotate(framed, angle, color.Transparent)
+} // }}}
+
+// func scrapShadowMask {{{
+
+// Returns the drop shadow of the photo as a mask, blurred by sigma, along with how much larger it is on each side.
+func scrapShadowMask(photo *image.NRGBA, sigma float64) (*image.Alpha, int) {
+	pad := int(math.Ceil(sigma * 3))
+
+	pb := photo.Bounds()
+
+	// imaging.Blur() only works on colors, so the shape of the photo is carried in the alpha of a black image.
+	shape := imaging.New(pb.Dx()+pad*2, pb.Dy()+pad*2, color.Transparent)
+
+	for y := 0; y < pb.Dy(); y++ {
+		for x := 0; x < pb.Dx(); x++ {
+			a := photo.Pix[y*photo.Stride+x*4+3]
+			shape.Pix[(y+pad)*shape.Stride+(x+pad)*4+3] = uint8(float64(a) * scrapShadow)
+		}
+	}
+
+	blurred := imaging.Blur(shape, sigma)
+
+	m := image.NewAlpha(blurred.Bounds())
+	for i := 0; i < len(m.Pix); i++ {
+		m.Pix[i] = blurred.Pix[i*4+3]
+	}
+
+	return m, pad
+} // }}}
+
+// func placeCell {{{
+
+// Draws src into the area r of img with drawCell(), or if rendering a scrapbook keeps it to be drawn later.
+func placeCell(img *image.RGBA, r image.Rectangle, src *image.RGBA, st outputStyle, sb *scrapbook) {
+	if sb != nil {
+		sb.add(r, src)
+		return
+	}
+
+	drawCell(img, r, src, st)
+} // }}}
@@ -0,0 +1,64 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestScrapbook(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(src, src.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+	photo := scrapPhoto(src, 2, 0)
+	if got := photo.Bounds().Size(); got != (image.Point{44, 24}) {
+		t.Fatalf("scrapPhoto size Expected 44x24 != Got %v", got)
+	}
+
+	if got := photo.NRGBAAt(0, 0); got != (color.NRGBA{255, 255, 255, 255}) {
+		t.Fatalf("scrapPhoto border Expected white != Got %v", got)
+	}
+
+	if got := photo.NRGBAAt(22, 12); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("scrapPhoto center Expected red != Got %v", got)
+	}
+
+	// Rotated leaves the corners transparent.
+	photo = scrapPhoto(src, 2, 30)
+	if got := photo.NRGBAAt(0, 0).A; got != 0 {
+		t.Fatalf("scrapPhoto rotated corner Expected 0 != Got %d", got)
+	}
+
+	// The image ends up around the center of its cell, with a shadow just outside of it.
+	dst := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	sb := &scrapbook{rotate: scrapDefaultRotate, r: rand.New(rand.NewSource(1))}
+	sb.add(image.Rect(30, 40, 70, 60), src)
+	sb.draw(dst)
+
+	if got := dst.RGBAAt(50, 50); got != red {
+		t.Fatalf("draw center Expected %v != Got %v", red, got)
+	}
+
+	if got := dst.RGBAAt(5, 5); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("draw outside Expected white != Got %v", got)
+	}
+
+	st, err := parseStyle(false, "", "", 0, true, 0)
+	if err != nil || st.rotate != scrapDefaultRotate {
+		t.Fatalf("parseStyle scrapbook Expected rotate %d != Got %v, %v", scrapDefaultRotate, st.rotate, err)
+	}
+
+	if _, err := parseStyle(false, "", "circle", 0, true, 0); err == nil {
+		t.Errorf("parseStyle scrapbook circle Expected error")
+	}
+
+	if _, err := parseStyle(false, "", "", 0, true, 90); err == nil {
+		t.Errorf("parseStyle scrapbook 90 Expected error")
+	}
+}
@@ -0,0 +1,159 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"frame/types"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// type selection struct {{{
+
+// The images last written to an OutputFile, kept in the State file so a restart can carry on showing them.
+type selection struct {
+	IDs []uint64 `json:"ids"`
+
+	// The TagProfile of each of IDs.
+	Profiles []string `json:"profiles"`
+
+	At time.Time `json:"at"`
+} // }}}
+
+// func selection.fresh {{{
+
+// Returns true if the selection has not been shown for a full WriteInterval yet.
+func (sel selection) fresh(wi time.Duration, now time.Time) bool {
+	return len(sel.IDs) > 0 && len(sel.IDs) == len(sel.Profiles) && sel.At.Add(wi).After(now)
+} // }}}
+
+// func loadState {{{
+
+// Reads the State file, a missing file is the same as an empty one.
+func loadState(file string) (map[string]selection, error) {
+	sels := make(map[string]selection)
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return sels, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &sels); err != nil {
+		return nil, err
+	}
+
+	return sels, nil
+} // }}}
+
+// func Render.resume {{{
+
+// Called by New() before the first render, returns the OutputFiles that do not need one.
+//
+// For any output whose last selection is still within its WriteInterval there is no reason to show something new
+// just because we restarted. If the OutputFile is still there it is left alone until the next interval, otherwise
+// the same images are rendered again.
+func (re *Render) resume(co *conf) map[string]bool {
+	fl := re.l.With().Str("func", "resume").Logger()
+
+	skip := make(map[string]bool)
+
+	if co.State == "" {
+		return skip
+	}
+
+	sels, err := loadState(co.State)
+	if err != nil {
+		fl.Err(err).Str("file", co.State).Msg("loadState")
+		return skip
+	}
+
+	re.sMut.Lock()
+	re.sels = sels
+	re.sMut.Unlock()
+
+	now := time.Now()
+
+	// Returns the selection to render again if any, a copy as dropMissing() changes it.
+	keep := func(file string, wi time.Duration, quiet *quietHours) *selection {
+		sel, ok := sels[file]
+		if !ok || !sel.fresh(wi, now) || quiet.active(now) {
+			return nil
+		}
+
+		if _, err := os.Stat(file); err == nil {
+			fl.Info().Str("OutputFile", file).Time("at", sel.At).Msg("kept")
+			skip[file] = true
+			return nil
+		}
+
+		fl.Info().Str("OutputFile", file).Time("at", sel.At).Msg("rendering again")
+
+		return &selection{
+			IDs:      append([]uint64(nil), sel.IDs...),
+			Profiles: append([]string(nil), sel.Profiles...),
+			At:       sel.At,
+		}
+	}
+
+	for _, prof := range co.Profiles {
+		prof.resume = keep(prof.OutputFile, prof.WriteInterval, prof.Quiet)
+	}
+
+	for _, prof := range co.MixProfiles {
+		prof.resume = keep(prof.OutputFile, prof.WriteInterval, prof.Quiet)
+	}
+
+	return skip
+} // }}}
+
+// func Render.saveState {{{
+
+// Records what each of the files written now shows, then writes out the State file if configured.
+//
+// A file showing nothing (quiet hours, placeholders) is forgotten, there is nothing to carry on showing.
+func (re *Render) saveState(written map[string][]types.ShownImage, at time.Time) {
+	fl := re.l.With().Str("func", "saveState").Logger()
+
+	co := re.getConf()
+	if co.State == "" {
+		return
+	}
+
+	re.sMut.Lock()
+	defer re.sMut.Unlock()
+
+	if re.sels == nil {
+		re.sels = make(map[string]selection)
+	}
+
+	for file, shown := range written {
+		if len(shown) == 0 {
+			delete(re.sels, file)
+			continue
+		}
+
+		sel := selection{At: at}
+
+		for _, si := range shown {
+			sel.IDs = append(sel.IDs, si.ID)
+			sel.Profiles = append(sel.Profiles, si.Profile)
+		}
+
+		re.sels[file] = sel
+	}
+
+	data, err := json.MarshalIndent(re.sels, "", "  ")
+	if err != nil {
+		fl.Err(err).Msg("Marshal")
+		return
+	}
+
+	if err := re.writeImage(co.State, data, filePerm{UID: -1, GID: -1}); err != nil {
+		fl.Err(err).Str("file", co.State).Msg("writeImage")
+	}
+} // }}}
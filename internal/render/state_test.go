@@ -0,0 +1,78 @@
+package render
+
+import (
+	"frame/types"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestState(t *testing.T) {
+	dir := t.TempDir()
+
+	now := time.Now()
+
+	kept := filepath.Join(dir, "kept.webp")
+	gone := filepath.Join(dir, "gone.webp")
+	stale := filepath.Join(dir, "stale.webp")
+
+	// Only kept is still there.
+	if err := ioutil.WriteFile(kept, []byte("image"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	co := &conf{
+		State: filepath.Join(dir, "state.json"),
+		Profiles: []*confProfile{
+			{OutputFile: kept, WriteInterval: time.Hour},
+			{OutputFile: gone, WriteInterval: time.Hour},
+			{OutputFile: stale, WriteInterval: time.Minute},
+		},
+	}
+
+	re := &Render{l: zerolog.Nop()}
+	re.co.Store(co)
+
+	shown := []types.ShownImage{{ID: 1, Profile: "a"}, {ID: 2, Profile: "a"}}
+
+	re.saveState(map[string][]types.ShownImage{kept: shown, gone: shown, stale: shown}, now.Add(-time.Minute*5))
+
+	// Quiet hours and the like forget the file.
+	re.saveState(map[string][]types.ShownImage{"quiet.webp": shown}, now)
+	re.saveState(map[string][]types.ShownImage{"quiet.webp": nil}, now)
+
+	sels, err := loadState(co.State)
+	if err != nil {
+		t.Fatalf("loadState: %s", err)
+	}
+
+	if len(sels) != 3 {
+		t.Fatalf("loadState Expected 3 != Got %d", len(sels))
+	}
+
+	// As if just started.
+	re = &Render{l: zerolog.Nop()}
+	re.co.Store(co)
+
+	skip := re.resume(co)
+
+	if !skip[kept] || skip[gone] || skip[stale] || len(skip) != 1 {
+		t.Fatalf("resume Expected only %s skipped != Got %v", kept, skip)
+	}
+
+	if sel := co.Profiles[1].resume; sel == nil || len(sel.IDs) != 2 || sel.IDs[1] != 2 || sel.Profiles[0] != "a" {
+		t.Fatalf("resume Expected IDs [1 2] for %s != Got %v", gone, sel)
+	}
+
+	if co.Profiles[0].resume != nil || co.Profiles[2].resume != nil {
+		t.Fatalf("resume Expected nothing to render again for %s or %s", kept, stale)
+	}
+
+	// No file is the same as nothing saved.
+	if sels, err := loadState(filepath.Join(dir, "missing.json")); err != nil || len(sels) != 0 {
+		t.Fatalf("loadState Expected empty != Got %v, %v", sels, err)
+	}
+}
@@ -0,0 +1,249 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	fimg "frame/image"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// The format written to OutputFile, see confProfileYAML.Format.
+const (
+	formatWebP = iota
+	formatPNG
+)
+
+// The mask applied to each image within a render, see confProfileYAML.Mask.
+const (
+	maskNone = iota
+	maskRounded
+	maskCircle
+)
+
+// type outputStyle struct {{{
+
+// How a profile is rendered and encoded, beyond the images within it.
+type outputStyle struct {
+	// Leave the background (and anything masked) transparent rather then black.
+	transparent bool
+
+	format int
+	mask   int
+
+	// Pixels, 0 for the default. Only used by maskRounded.
+	radius int
+
+	// See confProfileYAML.Scrapbook, rotate is the most each image is rotated either way in degrees.
+	scrapbook bool
+	rotate    float64
+
+	// See confProfileYAML.MaxBytes, 0 for no limit.
+	maxBytes int64
+
+	// See confProfileYAML.Scale, always set.
+	scale float64
+
+	// See confProfileYAML.Zoom, 0 for none.
+	zoom float64
+
+	// See confProfileYAML.LUT, nil for none.
+	calib *calibration
+} // }}}
+
+// func parseStyle {{{
+
+func parseStyle(transparent bool, format, mask string, radius int, scrapbook bool, rotate float64) (outputStyle, error) {
+	st := outputStyle{
+		transparent: transparent,
+		radius:      radius,
+		scrapbook:   scrapbook,
+		rotate:      rotate,
+	}
+
+	switch format {
+	case "", "webp":
+		st.format = formatWebP
+	case "png":
+		st.format = formatPNG
+	default:
+		return st, errors.New("invalid format")
+	}
+
+	switch mask {
+	case "", "none":
+		st.mask = maskNone
+	case "rounded":
+		st.mask = maskRounded
+	case "circle":
+		st.mask = maskCircle
+	default:
+		return st, errors.New("invalid mask")
+	}
+
+	if st.radius < 0 {
+		return st, errors.New("maskradius can not be negative")
+	}
+
+	if st.rotate < 0 || st.rotate > 45 {
+		return st, errors.New("scrapbookrotate needs to be between 0 and 45")
+	}
+
+	if st.scrapbook {
+		// The images are already bordered and rotated, a mask on top of that only looks broken.
+		if st.mask != maskNone {
+			return st, errors.New("mask can not be used with scrapbook")
+		}
+
+		if st.rotate == 0 {
+			st.rotate = scrapDefaultRotate
+		}
+	}
+
+	return st, nil
+} // }}}
+
+// func parseScale {{{
+
+func parseScale(scale float64) (float64, error) {
+	if scale == 0 {
+		return 1, nil
+	}
+
+	if scale < 0.1 || scale > 8 {
+		return 0, errors.New("scale needs to be between 0.1 and 8")
+	}
+
+	return scale, nil
+} // }}}
+
+// func outputStyle.px {{{
+
+// Returns the logical pixels (such as from the configuration) in actual pixels of the output, see
+// confProfileYAML.Scale.
+func (st outputStyle) px(n int) int {
+	if st.scale == 0 {
+		return n
+	}
+
+	return int(math.Round(float64(n) * st.scale))
+} // }}}
+
+// func outputStyle.size {{{
+
+// Returns the size of the output for a logical width and height.
+func (st outputStyle) size(w, h int) image.Point {
+	return image.Point{st.px(w), st.px(h)}
+} // }}}
+
+// func encodeImage {{{
+
+// Encodes the image in the format, keeping any transparency.
+func encodeImage(img image.Image, format int) ([]byte, error) {
+	var err error
+
+	buf := &bytes.Buffer{}
+
+	if format == formatPNG {
+		err = fimg.SaveImagePNG(buf, img)
+	} else {
+		err = fimg.SaveImageWebP(buf, img)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+} // }}}
+
+// func cellMask {{{
+
+// Returns the mask an image of the given size is drawn through, or nil if drawn as-is.
+//
+// The edges are anti-aliased by how much of each pixel is within the shape, so curves do not look jagged.
+func cellMask(size image.Point, mask, radius int) *image.Alpha {
+	var cx, cy, r float64
+
+	short := size.X
+	if size.Y < short {
+		short = size.Y
+	}
+
+	switch mask {
+	case maskRounded:
+		r = float64(radius)
+		if radius == 0 {
+			// A tenth of the shorter side looks about right for most sizes.
+			r = float64(short) / 10
+		}
+	case maskCircle:
+		// Centered, as large as fits.
+		r = float64(short) / 2
+		cx, cy = float64(size.X)/2, float64(size.Y)/2
+	default:
+		return nil
+	}
+
+	if r > float64(short)/2 {
+		r = float64(short) / 2
+	}
+
+	m := image.NewAlpha(image.Rect(0, 0, size.X, size.Y))
+
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+
+			var dx, dy float64
+
+			if mask == maskCircle {
+				dx, dy = px-cx, py-cy
+			} else {
+				// Only the corners are rounded, measure from the center of the nearest corner.
+				dx = math.Max(r-px, px-(float64(size.X)-r))
+				dy = math.Max(r-py, py-(float64(size.Y)-r))
+
+				if dx <= 0 || dy <= 0 {
+					m.Pix[y*m.Stride+x] = 0xff
+					continue
+				}
+			}
+
+			cover := r - math.Hypot(dx, dy) + 0.5
+			if cover >= 1 {
+				m.Pix[y*m.Stride+x] = 0xff
+			} else if cover > 0 {
+				m.Pix[y*m.Stride+x] = uint8(cover * 0xff)
+			}
+		}
+	}
+
+	return m
+} // }}}
+
+// func outputStyle.fillBackground {{{
+
+// Fills the image with opaque black, unless the style is transparent.
+func (st outputStyle) fillBackground(img *image.RGBA) {
+	if st.transparent {
+		return
+	}
+
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+} // }}}
+
+// func drawCell {{{
+
+// Draws src into the area r of img, through the mask of the style if it has one.
+func drawCell(img *image.RGBA, r image.Rectangle, src *image.RGBA, st outputStyle) {
+	m := cellMask(r.Size(), st.mask, st.px(st.radius))
+	if m == nil {
+		draw.Draw(img, r, src, src.Bounds().Min, draw.Src)
+		return
+	}
+
+	draw.DrawMask(img, r, src, src.Bounds().Min, m, image.Point{}, draw.Over)
+} // }}}
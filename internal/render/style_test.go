@@ -0,0 +1,86 @@
+package render
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCellMask(t *testing.T) {
+	if m := cellMask(image.Point{100, 50}, maskNone, 0); m != nil {
+		t.Fatalf("maskNone Expected nil != Got %v", m.Bounds())
+	}
+
+	tests := []struct {
+		Mask     int
+		Radius   int
+		At       image.Point
+		Expected uint8
+	}{
+		// Default radius of 5, a tenth of 50.
+		{maskRounded, 0, image.Point{0, 0}, 0},
+		{maskRounded, 0, image.Point{99, 49}, 0},
+		{maskRounded, 0, image.Point{5, 0}, 0xff},
+		{maskRounded, 0, image.Point{0, 25}, 0xff},
+		{maskRounded, 0, image.Point{50, 25}, 0xff},
+
+		{maskRounded, 20, image.Point{5, 5}, 0},
+		{maskRounded, 20, image.Point{20, 0}, 0xff},
+
+		// A circle of radius 25 in the middle.
+		{maskCircle, 0, image.Point{0, 25}, 0},
+		{maskCircle, 0, image.Point{24, 25}, 0},
+		{maskCircle, 0, image.Point{50, 25}, 0xff},
+		{maskCircle, 0, image.Point{50, 2}, 0xff},
+		{maskCircle, 0, image.Point{75, 0}, 0},
+	}
+
+	for _, test := range tests {
+		m := cellMask(image.Point{100, 50}, test.Mask, test.Radius)
+
+		if got := m.AlphaAt(test.At.X, test.At.Y).A; got != test.Expected {
+			t.Errorf("cellMask(%d, %d) at %v Expected %d != Got %d", test.Mask, test.Radius, test.At, test.Expected, got)
+		}
+	}
+
+	if _, err := parseStyle(false, "jpeg", "", 0, false, 0); err == nil {
+		t.Errorf("parseStyle jpeg Expected error")
+	}
+
+	if _, err := parseStyle(true, "png", "oval", 0, false, 0); err == nil {
+		t.Errorf("parseStyle oval Expected error")
+	}
+}
+
+func TestScale(t *testing.T) {
+	for _, test := range []struct {
+		In       float64
+		Expected float64
+		Err      bool
+	}{
+		{0, 1, false},
+		{2, 2, false},
+		{1.5, 1.5, false},
+		{-1, 0, true},
+		{9, 0, true},
+	} {
+		got, err := parseScale(test.In)
+		if (err != nil) != test.Err || got != test.Expected {
+			t.Errorf("parseScale(%v) Expected %v %t != Got %v %v", test.In, test.Expected, test.Err, got, err)
+		}
+	}
+
+	st := outputStyle{scale: 1.5}
+
+	if got := st.size(1920, 1080); got != (image.Point{2880, 1620}) {
+		t.Errorf("size Expected (2880,1620) != Got %v", got)
+	}
+
+	if got := st.px(11); got != 17 {
+		t.Errorf("px(11) Expected 17 != Got %d", got)
+	}
+
+	// The zero value is unscaled.
+	if got := (outputStyle{}).px(10); got != 10 {
+		t.Errorf("unscaled px(10) Expected 10 != Got %d", got)
+	}
+}
@@ -0,0 +1,579 @@
+package render
+
+import (
+	"context"
+	"frame/internal/scheduler"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// type confProfileYAML struct {{{
+
+type confProfileYAML struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+
+	// The maximmum amount of images, or "depth" to show on a single rendered
+	// image. These images get smaller and smaller until eventually we
+	// run out of pixels.
+	//
+	// The default if not set is 6.
+	MaxDepth uint8 `yaml:"maxdepth"`
+
+	// This is the profile name passed to Weighter.GetProfile()
+	//
+	// We do not have any tags ourselves, those are all handled there.
+	TagProfile string `yaml:"tagprofile"`
+
+	// Rotate between these profiles rather then always using TagProfile, moving on to the next every RotateEvery
+	// images written (default 1).
+	//
+	// So one output can cycle between themes, such as with a WriteInterval of 5m and a RotateEvery of 12 the
+	// profile changes hourly.
+	//
+	// If set TagProfile is not needed.
+	Rotate      []string `yaml:"rotate"`
+	RotateEvery int      `yaml:"rotateevery"`
+
+	// How often to write the new output file.
+	//
+	// Default if unset is every 5 minutes, or "5m".
+	WriteInterval yconf.Duration `yaml:"writeinterval"`
+
+	// The full path and name of the file to output when generating a new image.
+	// The file will be written to OutputrFile.tmp and then renamed so
+	// no one gets a partially written file.
+	OutputFile string `yaml:"outputfile"`
+
+	// If set, as soon as an image is written out the next one is selected and rendered in the background
+	// ready for the next interval, so writing it out is nearly instant.
+	//
+	// Helpful when the WriteInterval is short and the device is slow. Costs the memory of holding the next image.
+	//
+	// If any image it shows is removed before it is written out, a fresh one is rendered instead.
+	Prerender bool `yaml:"prerender"`
+
+	// Permissions and ownership of OutputFile.
+	//
+	// Mode is in octal such as "0640", default if not set is 0644. Unlike the default, a set mode is applied
+	// exactly regardless of the umask.
+	//
+	// UID and GID default to whoever we are running as. Changing them generally needs us to be running as root,
+	// or at least within the group being set.
+	Mode string `yaml:"mode"`
+	UID  *int   `yaml:"uid"`
+	GID  *int   `yaml:"gid"`
+
+	// What to do with OutputFile if rendering fails, such as the profile having no images or one failing to load.
+	//
+	// "keep" (the default) leaves the previous image, "placeholder" writes an image showing the error and when,
+	// and "delete" removes OutputFile. The last two make a dead profile visible rather then silently showing the
+	// same image forever.
+	OnError string `yaml:"onerror"`
+
+	// Quiet hours, such as overnight for a frame in a bedroom.
+	//
+	// QuietStart and QuietEnd are the local time of day such as "23:00" and "07:00", wrapping past midnight when
+	// QuietEnd is the earlier.
+	//
+	// QuietMode is what to do during them - "skip" (the default) writes nothing, leaving whatever was last written,
+	// "black" writes a black image, "clock" a black image with a dim clock, and "dim" writes images as normal but
+	// darkened to QuietDim (0 to 1, default 0.3) of their brightness.
+	QuietStart string  `yaml:"quietstart"`
+	QuietEnd   string  `yaml:"quietend"`
+	QuietMode  string  `yaml:"quietmode"`
+	QuietDim   float64 `yaml:"quietdim"`
+
+	// For compositing the output onto something else, such as a MagicMirror dashboard with its own background.
+	//
+	// Transparent leaves any space not covered by an image transparent rather then black, along with whatever
+	// Mask removes. Format is "webp" (the default) or "png", both of which keep the transparency.
+	//
+	// Mask is "rounded" to round the corners of each image by MaskRadius pixels (default a tenth of its shorter
+	// side), or "circle" to crop each to the largest circle that fits.
+	Transparent bool   `yaml:"transparent"`
+	Format      string `yaml:"format"`
+	Mask        string `yaml:"mask"`
+	MaskRadius  int    `yaml:"maskradius"`
+
+	// Lays the images out like prints in a scrapbook, each with a white border and drop shadow, rotated up to
+	// ScrapbookRotate degrees either way (default 4) and overlapping their neighbours a little.
+	//
+	// Can not be used with Mask.
+	Scrapbook       bool    `yaml:"scrapbook"`
+	ScrapbookRotate float64 `yaml:"scrapbookrotate"`
+
+	// The largest the OutputFile can be in bytes, for frames that refuse anything larger. 0 (the default) has no limit.
+	//
+	// If the render is larger it is encoded again as a lossy WebP at lower and lower quality, then scaled down, until
+	// it fits. PNG can only be scaled down. If it still does not fit the render fails, leaving the last OutputFile.
+	MaxBytes int64 `yaml:"maxbytes"`
+
+	// For high DPI displays, the output is Width and Height multiplied by this, such as 2 for a 4K display with the
+	// layout of a 1080p one. Anything else in pixels (MaskRadius for example) is multiplied the same, so a profile
+	// looks the same on both.
+	//
+	// Default is 1, can be up to 8.
+	Scale float64 `yaml:"scale"`
+
+	// Zooms into each image by a random amount up to this (such as 0.1 for 10%), showing a random part of it.
+	//
+	// Different every render, so an image selected again and again does not look exactly the same each time.
+	// Default of 0 shows every image whole, can be up to 0.5.
+	Zoom float64 `yaml:"zoom"`
+
+	// Files written alongside OutputFile each time, for viewers showing what is on screen.
+	//
+	// Filmstrip writes the images shown side by side at FilmstripHeight pixels high (default 120, multiplied by
+	// Scale) named after OutputFile, such as "frame.strip.webp" for "frame.webp".
+	//
+	// Details writes the ID, profile and tags of each image shown as JSON, such as "frame.json".
+	Filmstrip       bool `yaml:"filmstrip"`
+	FilmstripHeight int  `yaml:"filmstripheight"`
+	Details         bool `yaml:"details"`
+
+	// Corrects the colors of each render for the display, as cheap frames tend to be too blue, too dark or washed
+	// out. Applied to the whole render once everything is drawn, before it is encoded.
+	//
+	// LUT is a 3D LUT in the .cube format, such as exported by most photo editors after calibrating against the
+	// display. It is read when the configuration is loaded.
+	//
+	// Gamma above 1 brightens the midtones and below 1 darkens them, Contrast above 1 spreads the colors further
+	// from middle grey and below 1 pulls them in. Both are from 0.1 to 10, default 1 leaves them as is. Applied after
+	// any LUT.
+	LUT      string  `yaml:"lut"`
+	Gamma    float64 `yaml:"gamma"`
+	Contrast float64 `yaml:"contrast"`
+} // }}}
+
+// type confProfileCountsYAML struct {{{
+
+type confProfileCountsYAML struct {
+	TagProfile string `yaml:"tagprofile"`
+	Images     uint8  `yaml:"images"`
+} // }}}
+
+// type confProfileCounts struct {{{
+
+type confProfileCounts struct {
+	TagProfile string
+
+	// The WeighterProfile (from TagProfile above) given by types.Weighter.
+	//
+	// Weighter can invalidate this during runtime, so we may have to
+	// get a new one.
+	//
+	// This value can only be used when you have the "running" advisory lock
+	// above.
+	wp types.WeighterProfile
+
+	// How many images we load from this tagprofile.
+	// Default if not set is 1.
+	images uint8
+} // }}}
+
+// type confProfileMixedYAML struct {{{
+
+type confProfileMixedYAML struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+
+	// Our profiles, order is honored so no "depth", it just gets as many as is configured.
+	Profiles []confProfileCountsYAML `yaml:"profiles"`
+
+	// How often to write the new output file.
+	//
+	// Default if unset is every 5 minutes, or "5m".
+	WriteInterval yconf.Duration `yaml:"writeinterval"`
+
+	// The full path and name of the file to output when generating a new image.
+	// The file will be written to OutputrFile.tmp and then renamed so
+	// no one gets a partially written file.
+	OutputFile string `yaml:"outputfile"`
+
+	// If set, as soon as an image is written out the next one is selected and rendered in the background
+	// ready for the next interval, so writing it out is nearly instant.
+	//
+	// Helpful when the WriteInterval is short and the device is slow. Costs the memory of holding the next image.
+	//
+	// If any image it shows is removed before it is written out, a fresh one is rendered instead.
+	Prerender bool `yaml:"prerender"`
+
+	// Permissions and ownership of OutputFile.
+	//
+	// Mode is in octal such as "0640", default if not set is 0644. Unlike the default, a set mode is applied
+	// exactly regardless of the umask.
+	//
+	// UID and GID default to whoever we are running as. Changing them generally needs us to be running as root,
+	// or at least within the group being set.
+	Mode string `yaml:"mode"`
+	UID  *int   `yaml:"uid"`
+	GID  *int   `yaml:"gid"`
+
+	// What to do with OutputFile if rendering fails, such as the profile having no images or one failing to load.
+	//
+	// "keep" (the default) leaves the previous image, "placeholder" writes an image showing the error and when,
+	// and "delete" removes OutputFile. The last two make a dead profile visible rather then silently showing the
+	// same image forever.
+	OnError string `yaml:"onerror"`
+
+	// Quiet hours, such as overnight for a frame in a bedroom.
+	//
+	// QuietStart and QuietEnd are the local time of day such as "23:00" and "07:00", wrapping past midnight when
+	// QuietEnd is the earlier.
+	//
+	// QuietMode is what to do during them - "skip" (the default) writes nothing, leaving whatever was last written,
+	// "black" writes a black image, "clock" a black image with a dim clock, and "dim" writes images as normal but
+	// darkened to QuietDim (0 to 1, default 0.3) of their brightness.
+	QuietStart string  `yaml:"quietstart"`
+	QuietEnd   string  `yaml:"quietend"`
+	QuietMode  string  `yaml:"quietmode"`
+	QuietDim   float64 `yaml:"quietdim"`
+
+	// For compositing the output onto something else, such as a MagicMirror dashboard with its own background.
+	//
+	// Transparent leaves any space not covered by an image transparent rather then black, along with whatever
+	// Mask removes. Format is "webp" (the default) or "png", both of which keep the transparency.
+	//
+	// Mask is "rounded" to round the corners of each image by MaskRadius pixels (default a tenth of its shorter
+	// side), or "circle" to crop each to the largest circle that fits.
+	Transparent bool   `yaml:"transparent"`
+	Format      string `yaml:"format"`
+	Mask        string `yaml:"mask"`
+	MaskRadius  int    `yaml:"maskradius"`
+
+	// Lays the images out like prints in a scrapbook, each with a white border and drop shadow, rotated up to
+	// ScrapbookRotate degrees either way (default 4) and overlapping their neighbours a little.
+	//
+	// Can not be used with Mask.
+	Scrapbook       bool    `yaml:"scrapbook"`
+	ScrapbookRotate float64 `yaml:"scrapbookrotate"`
+
+	// See confProfileYAML.MaxBytes
+	MaxBytes int64 `yaml:"maxbytes"`
+
+	// See confProfileYAML.Scale
+	Scale float64 `yaml:"scale"`
+
+	// See confProfileYAML.Zoom
+	Zoom float64 `yaml:"zoom"`
+
+	// See confProfileYAML.Filmstrip
+	Filmstrip       bool `yaml:"filmstrip"`
+	FilmstripHeight int  `yaml:"filmstripheight"`
+	Details         bool `yaml:"details"`
+
+	// See confProfileYAML.LUT
+	LUT      string  `yaml:"lut"`
+	Gamma    float64 `yaml:"gamma"`
+	Contrast float64 `yaml:"contrast"`
+} // }}}
+
+// type confProfileMixed struct {{{
+
+type confProfileMixed struct {
+	Size          image.Point
+	WriteInterval time.Duration
+	OutputFile    string
+	Prerender     bool
+	Perm          filePerm
+	OnError       int
+
+	// nil if the profile has no quiet hours.
+	Quiet *quietHours
+
+	Style outputStyle
+	Aux   auxOutputs
+
+	Profiles []confProfileCounts
+
+	// Lets us know if renderProfile() is already running or not,
+	// so we don't try to render the same profile multiple times
+	// concurrently.
+	//
+	// We do not use the mutex for this, because that would lock a goroutine and make them
+	// wait. We do not want to wait, any additional goroutines trying to run the profile should just return.
+	running uint32
+
+	// The next image already rendered and encoded, if Prerender is set.
+	//
+	// Like wp, only used when you have the "running" advisory lock.
+	next *rendered
+
+	// The images to render rather then selecting new ones, once only after a restart. See Render.resume().
+	//
+	// Like wp, only used when you have the "running" advisory lock.
+	resume *selection
+} // }}}
+
+// type rendered struct {{{
+
+// An encoded image ready to be written, along with the images it shows.
+type rendered struct {
+	data []byte
+
+	// Only what was actually drawn, empty for a quiet image.
+	shown []types.ShownImage
+
+	// The encoded filmstrip of shown, nil if not wanted.
+	strip []byte
+
+	// Set once an image in shown is removed by the Weighter, access only with atomics. See removed().
+	stale uint32
+} // }}}
+
+// type confProfile struct {{{
+
+type confProfile struct {
+	Size          image.Point
+	Depth         uint8
+	TagProfile    string
+	WriteInterval time.Duration
+	OutputFile    string
+	Prerender     bool
+	Perm          filePerm
+	OnError       int
+
+	// nil if the profile has no quiet hours.
+	Quiet *quietHours
+
+	Style outputStyle
+	Aux   auxOutputs
+
+	// See confProfileYAML.Rotate, RotateEvery is at least 1.
+	Rotate      []string
+	RotateEvery int
+
+	// Lets us know if renderProfile() is already running or not,
+	// so we don't try to render the same profile multiple times
+	// concurrently.
+	//
+	// We do not use the mutex for this, because that would lock a goroutine and make them
+	// wait. We do not want to wait, any additional goroutines trying to run the profile should just return.
+	running uint32
+
+	// Mutex that controls access to our random number generator.
+	rMut sync.Mutex
+
+	// The WeighterProfile (from TagProfile above) given by types.Weighter.
+	//
+	// Weighter can invalidate this during runtime, so we may have to
+	// get a new one.
+	//
+	// This value can only be used when you have the "running" advisory lock
+	// above.
+	wp types.WeighterProfile
+
+	// The same as wp for each of Rotate, and how many images have been selected so far to know which is next.
+	//
+	// Like wp, only used when you have the "running" advisory lock.
+	wps      []types.WeighterProfile
+	selected uint64
+
+	// The next image already rendered and encoded, if Prerender is set.
+	//
+	// Like wp, only used when you have the "running" advisory lock.
+	next *rendered
+
+	// The images to render rather then selecting new ones, once only after a restart. See Render.resume().
+	//
+	// Like wp, only used when you have the "running" advisory lock.
+	resume *selection
+} // }}}
+
+// func confProfile.current {{{
+
+// Returns the TagProfile to select the next image from, along with where its WeighterProfile is kept.
+//
+// Caller must have the "running" advisory lock.
+func (prof *confProfile) current() (string, *types.WeighterProfile) {
+	if len(prof.Rotate) == 0 {
+		return prof.TagProfile, &prof.wp
+	}
+
+	i := int(prof.selected / uint64(prof.RotateEvery) % uint64(len(prof.Rotate)))
+	prof.selected++
+
+	return prof.Rotate[i], &prof.wps[i]
+} // }}}
+
+// type filePerm struct {{{
+
+type filePerm struct {
+	// 0 if not set, see confProfileYAML.Mode.
+	Mode os.FileMode
+
+	// -1 if not set.
+	UID int
+	GID int
+} // }}}
+
+// type confYAML struct {{{
+
+type confYAML struct {
+	// The individual image profiles.
+	Profiles []confProfileYAML `yaml:"profiles"`
+
+	MixProfiles []confProfileMixedYAML `yaml:"mixprofiles"`
+
+	// If set, every output file rendered on the same tick is committed together, and this manifest file
+	// is written after them.
+	//
+	// Each output is first written to its .tmp, only once all are written are they renamed into place.
+	// The manifest (JSON, see type manifest) is then updated last, so a viewer that reads the manifest
+	// first and sees it change knows the set of files it lists are consistent with each other.
+	Manifest string `yaml:"manifest"`
+
+	// If set, any missing directory for an OutputFile or the Manifest is created when the configuration is loaded.
+	//
+	// Either way every directory is checked to exist and be writable, so a mistake fails the configuration rather
+	// then every render.
+	CreateDirs bool `yaml:"createdirs"`
+
+	// Mode of the directories made by CreateDirs, in octal such as "0750". Default if not set is 0755.
+	//
+	// Subject to the umask.
+	DirMode string `yaml:"dirmode"`
+
+	// Keeps a copy of everything written, see confArchiveYAML.
+	Archive *confArchiveYAML `yaml:"archive"`
+
+	// If set, the images last written to each OutputFile are kept in this file (JSON).
+	//
+	// After a restart any OutputFile whose WriteInterval has not yet passed keeps showing the same images, rather
+	// then a new selection every time the daemon is upgraded. Left as is if the file is still there, rendered again
+	// from the same images if not.
+	State string `yaml:"state"`
+
+	// How many renders of an OutputFile can fail in a row before it is quarantined, no longer rendered until the
+	// configuration changes or OutputFile.quarantined (written with the last error) is removed.
+	//
+	// Default if not set is 10, -1 never quarantines.
+	QuarantineAfter int `yaml:"quarantineafter"`
+} // }}}
+
+// type confArchiveYAML struct {{{
+
+// A time-lapse of what was shown, for looking back at a given day.
+//
+// Every image written to an OutputFile is copied into a directory for the day within Dir, such as
+// "2026-10-16/153000-frame.webp". Quiet hours and placeholders are not copied, only images actually rendered.
+type confArchiveYAML struct {
+	Dir string `yaml:"dir"`
+
+	// How many days to keep, including today. Default if not set is 30.
+	Keep int `yaml:"keep"`
+
+	// Once a day has passed write a contact sheet of it (contact.webp), every image shown that day as a small
+	// thumbnail in a grid.
+	ContactSheet bool `yaml:"contactsheet"`
+} // }}}
+
+// type conf struct {{{
+
+type conf struct {
+	// We copy these all over the place and they are read-only once created.
+	//
+	// So just use a reference to save a bit of memory and copy time.
+	Profiles []*confProfile
+
+	// Our mix profiles, same as above - references.
+	MixProfiles []*confProfileMixed
+
+	// See confYAML.Manifest.
+	Manifest string
+
+	// See confYAML.CreateDirs and DirMode.
+	CreateDirs bool
+	DirMode    os.FileMode
+
+	// nil if not archiving.
+	Archive *confArchive
+
+	// See confYAML.State.
+	State string
+
+	// See confYAML.QuarantineAfter, 0 to never quarantine.
+	QuarantineAfter int
+} // }}}
+
+// type confArchive struct {{{
+
+type confArchive struct {
+	Dir          string
+	Keep         int
+	ContactSheet bool
+} // }}}
+
+// type manifest struct {{{
+
+// Written to the configured manifest file after each commit.
+type manifest struct {
+	// When the last commit was done.
+	Updated time.Time `json:"updated"`
+
+	// Increases by 1 each commit.
+	Seq uint64 `json:"seq"`
+
+	// Every output file that has been committed, and when it last was.
+	Files map[string]time.Time `json:"files"`
+} // }}}
+
+// type Render struct {{{
+
+type Render struct {
+	l zerolog.Logger
+
+	// We use an atomic for the configuration since we might replace it at any time while another goroutine
+	// can be using it.
+	co atomic.Value
+
+	we types.Weighter
+	cm types.CacheManager
+
+	// Our configuration path.
+	//
+	// Can also be a single file if you want to store everything in just one file.
+	cPath string
+
+	// Runs the renders for each WriteInterval, see schedule().
+	sch *scheduler.Scheduler
+
+	// The intervals scheduled with sch, only used by schedule().
+	sInts map[time.Duration]bool
+
+	// Set by Hold(), access only with atomics.
+	hold uint32
+
+	yc *yconf.YConf
+
+	// The last manifest written, only used under mMut.
+	mMut sync.Mutex
+	man  manifest
+
+	// Set while tidyArchive() is running, access only with atomics.
+	aRun uint32
+
+	// The selections kept in the State file, only used under sMut.
+	sMut sync.Mutex
+	sels map[string]selection
+
+	// Failed renders in a row and the quarantined outputs, both by OutputFile. Only used under qMut.
+	qMut  sync.Mutex
+	fails map[string]int
+	quar  map[string]quarantine
+
+	// The prerendered images by OutputFile, only used under pMut. See removed().
+	pMut sync.Mutex
+	pre  map[string]*rendered
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
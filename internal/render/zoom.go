@@ -0,0 +1,73 @@
+package render
+
+import (
+	"errors"
+	fimg "frame/image"
+	"image"
+	"image/draw"
+	"math/rand"
+)
+
+// func parseZoom {{{
+
+func parseZoom(zoom float64) (float64, error) {
+	if zoom < 0 || zoom > 0.5 {
+		return 0, errors.New("zoom needs to be between 0 and 0.5")
+	}
+
+	return zoom, nil
+} // }}}
+
+// func Render.loadZoomed {{{
+
+// Loads the image to fit within size, zoomed in by up to zoom and cropped back to the size it would otherwise be at
+// a random position.
+//
+// So the same image selected again is shown a little differently each time. If the size of the image is not known
+// it is loaded as normal.
+func (re *Render) loadZoomed(id uint64, size image.Point, zoom float64, r *rand.Rand) (image.Image, error) {
+	cs, err := re.cm.Stat(id)
+	if err != nil {
+		return re.cm.LoadImage(id, size, true)
+	}
+
+	// What the image would be without any zoom.
+	fit, _ := fimg.Fit(cs.Size, size, true)
+
+	z := 1 + r.Float64()*zoom
+
+	big := image.Point{X: int(float64(fit.X) * z), Y: int(float64(fit.Y) * z)}
+
+	img, err := re.cm.LoadImage(id, big, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return zoomCrop(img, fit, r), nil
+} // }}}
+
+// func zoomCrop {{{
+
+// Returns size from somewhere within img, the whole of img if it is not larger.
+func zoomCrop(img image.Image, size image.Point, r *rand.Rand) image.Image {
+	b := img.Bounds()
+
+	spare := b.Size().Sub(size)
+	if spare.X < 0 || spare.Y < 0 || spare == (image.Point{}) {
+		return img
+	}
+
+	at := b.Min
+	if spare.X > 0 {
+		at.X += r.Intn(spare.X + 1)
+	}
+
+	if spare.Y > 0 {
+		at.Y += r.Intn(spare.Y + 1)
+	}
+
+	crop := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(crop, crop.Bounds(), img, at, draw.Src)
+
+	return crop
+} // }}}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestZoom(t *testing.T) {
+	for _, zoom := range []float64{-0.1, 0.6} {
+		if _, err := parseZoom(zoom); err == nil {
+			t.Fatalf("parseZoom(%v) Expected error != Got nil", zoom)
+		}
+	}
+
+	if zoom, err := parseZoom(0.1); err != nil || zoom != 0.1 {
+		t.Fatalf("parseZoom Expected 0.1 != Got %v, %v", zoom, err)
+	}
+
+	// Each pixel is unique, so where the crop came from is known by its first pixel.
+	src := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 60; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	size := image.Point{50, 30}
+	seen := make(map[color.RGBA]bool)
+
+	for seed := int64(1); seed <= 10; seed++ {
+		crop := zoomCrop(src, size, rand.New(rand.NewSource(seed)))
+		if got := crop.Bounds().Size(); got != size {
+			t.Fatalf("zoomCrop size Expected %v != Got %v", size, got)
+		}
+
+		first := crop.(*image.RGBA).RGBAAt(0, 0)
+		if first.R > 10 || first.G > 10 {
+			t.Fatalf("zoomCrop Expected offset within 10x10 != Got %v", first)
+		}
+
+		seen[first] = true
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("zoomCrop Expected varying offsets != Got %d", len(seen))
+	}
+
+	// Nothing to spare, the image is kept as is.
+	if got := zoomCrop(src, src.Bounds().Size(), rand.New(rand.NewSource(1))); got != image.Image(src) {
+		t.Fatalf("zoomCrop Expected the same image when nothing to spare")
+	}
+}
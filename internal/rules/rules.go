@@ -0,0 +1,206 @@
+// Applies tag rules (tags.TagRules) the same way for everything using them, currently CMerge and Weighter.
+//
+// Evaluation order -
+//
+//  1. The input tags are copied and fixed (sorted, duplicates removed), the caller's are never modified.
+//  2. Each rule is checked once, in the order they were configured (files loaded in order, rules within a file
+//     in order).
+//  3. A rule sees the tags given by every rule before it, but not those given by any rule after it. So a rule
+//     wanting a tag given by a later rule only matches if the tag was already there.
+//  4. The result is sorted without duplicates, the same as tags.Tags.Fix().
+//
+// As most images share a handful of tag combinations the results are cached, keyed by a hash of the fixed input.
+// Every Engine has its own cache, so changing the rules (a new Engine) never gives results from the old.
+package rules
+
+import (
+	"frame/tags"
+	"hash/fnv"
+	"sync"
+)
+
+// Used by New() when size is 0.
+const DefaultCacheSize = 10000
+
+// type cacheEntry struct {{{
+
+type cacheEntry struct {
+	// The fixed input, as different tags can hash the same.
+	in tags.Tags
+
+	out tags.Tags
+
+	// The tags given by the rules, in the order they were given.
+	given []uint64
+} // }}}
+
+// type Engine struct {{{
+
+// Applies a set of tags.TagRules, see the package documentation for the order.
+//
+// Safe for concurrent use. A nil *Engine has no rules, returning the tags as given.
+type Engine struct {
+	rules tags.TagRules
+
+	// Most tag sets kept in the cache before it is emptied, 0 or less disables it.
+	size int
+
+	mut   sync.Mutex
+	cache map[uint64][]cacheEntry
+	count int
+} // }}}
+
+// func New {{{
+
+// Returns an Engine for the rules, caching results for up to size tag sets (DefaultCacheSize if 0, disabled if
+// negative).
+//
+// The rules are not copied, they must not be changed after.
+func New(rules tags.TagRules, size int) *Engine {
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+
+	return &Engine{
+		rules: rules,
+		size:  size,
+		cache: make(map[uint64][]cacheEntry),
+	}
+} // }}}
+
+// func Engine.Rules {{{
+
+// Returns the rules being applied.
+func (e *Engine) Rules() tags.TagRules {
+	if e == nil {
+		return nil
+	}
+
+	return e.rules
+} // }}}
+
+// func Engine.Apply {{{
+
+// Returns the tags with every rule applied.
+//
+// The returned tags are always a new slice the caller can do with as it wishes.
+func (e *Engine) Apply(t tags.Tags) tags.Tags {
+	out, _ := e.Trace(t)
+	return out
+} // }}}
+
+// func Engine.Trace {{{
+
+// The same as Apply(), also returning the tags each rule gave in the order given, for logging what happened.
+//
+// Only rules that gave a tag not already there are included.
+func (e *Engine) Trace(t tags.Tags) (tags.Tags, []uint64) {
+	in := t.Copy().Fix()
+
+	if e == nil || len(e.rules) == 0 {
+		return in, nil
+	}
+
+	key := hashTags(in)
+
+	if out, given, ok := e.lookup(key, in); ok {
+		return out.Copy(), copyGiven(given)
+	}
+
+	out, given := e.apply(in.Copy())
+
+	e.store(key, cacheEntry{in: in, out: out, given: given})
+
+	return out.Copy(), copyGiven(given)
+} // }}}
+
+// func Engine.apply {{{
+
+// Runs the rules over t, which is modified.
+func (e *Engine) apply(t tags.Tags) (tags.Tags, []uint64) {
+	var given []uint64
+
+	for i := range e.rules {
+		tr := &e.rules[i]
+
+		if t.Has(tr.Tag) || !tr.Give(t) {
+			continue
+		}
+
+		t = t.Add(tr.Tag)
+		given = append(given, tr.Tag)
+	}
+
+	return t, given
+} // }}}
+
+// func Engine.lookup {{{
+
+func (e *Engine) lookup(key uint64, in tags.Tags) (tags.Tags, []uint64, bool) {
+	if e.size <= 0 {
+		return nil, nil, false
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	for _, ce := range e.cache[key] {
+		if ce.in.Equal(in) {
+			return ce.out, ce.given, true
+		}
+	}
+
+	return nil, nil, false
+} // }}}
+
+// func Engine.store {{{
+
+// Adds the result to the cache, emptying it first if full.
+//
+// Emptying it all is crude, but the common tag sets are back after a single pass over the images and it needs no
+// bookkeeping on every lookup the way least recently used would.
+func (e *Engine) store(key uint64, ce cacheEntry) {
+	if e.size <= 0 {
+		return
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if e.count >= e.size {
+		e.cache = make(map[uint64][]cacheEntry)
+		e.count = 0
+	}
+
+	e.cache[key] = append(e.cache[key], ce)
+	e.count++
+} // }}}
+
+// func hashTags {{{
+
+// Returns the FNV-1a hash of the tags.
+func hashTags(t tags.Tags) uint64 {
+	var buf [8]byte
+
+	h := fnv.New64a()
+
+	for _, tag := range t {
+		for i := 0; i < 8; i++ {
+			buf[i] = byte(tag >> (8 * i))
+		}
+
+		h.Write(buf[:])
+	}
+
+	return h.Sum64()
+} // }}}
+
+// func copyGiven {{{
+
+func copyGiven(given []uint64) []uint64 {
+	if len(given) == 0 {
+		return nil
+	}
+
+	return append([]uint64(nil), given...)
+} // }}}
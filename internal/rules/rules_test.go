@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"frame/tags"
+	"testing"
+)
+
+func TestEngine(t *testing.T) {
+	// 30 from 10, then 40 from 30 (so chains), then 20 from 40 (never, already there or given later).
+	give30, err := tags.MakeTagRule(30, tags.Tags{10}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	give40, err := tags.MakeTagRule(40, tags.Tags{30}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	// Wants 50, only given by the rule after it.
+	give60, err := tags.MakeTagRule(60, tags.Tags{50}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	give50, err := tags.MakeTagRule(50, tags.Tags{40}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	trs := tags.TagRules{give30, give40, give60, give50}
+
+	tests := []struct {
+		In       tags.Tags
+		Expected tags.Tags
+		Given    []uint64
+	}{
+		{tags.Tags{10}, tags.Tags{10, 30, 40, 50}, []uint64{30, 40, 50}},
+		{tags.Tags{10, 10, 1}, tags.Tags{1, 10, 30, 40, 50}, []uint64{30, 40, 50}},
+		{tags.Tags{30, 50}, tags.Tags{30, 40, 50, 60}, []uint64{40, 60}},
+		{tags.Tags{2}, tags.Tags{2}, nil},
+	}
+
+	for _, size := range []int{-1, 0} {
+		e := New(trs, size)
+
+		// Twice, so the second comes from the cache when enabled.
+		for pass := 0; pass < 2; pass++ {
+			for _, test := range tests {
+				in := test.In.Copy()
+
+				out, given := e.Trace(in)
+				if !out.Equal(test.Expected) {
+					t.Fatalf("size %d pass %d Trace(%v) Expected %v != Got %v", size, pass, test.In, test.Expected, out)
+				}
+
+				if !tags.Tags(given).Equal(test.Given) {
+					t.Fatalf("size %d pass %d Trace(%v) given Expected %v != Got %v", size, pass, test.In, test.Given, given)
+				}
+
+				// Must match the plain TagRules.Apply().
+				if plain := trs.Apply(test.In.Copy().Fix()); !plain.Equal(out) {
+					t.Fatalf("size %d Apply(%v) Expected %v != Got %v", size, test.In, plain, out)
+				}
+
+				// The input is left alone, and changing the output does not change the cache.
+				if !in.Equal(test.In) {
+					t.Fatalf("size %d input Expected %v != Got %v", size, test.In, in)
+				}
+
+				out[0] = 99
+			}
+		}
+	}
+
+	var e *Engine
+	if out := e.Apply(tags.Tags{3, 1}); !out.Equal(tags.Tags{1, 3}) {
+		t.Fatalf("nil Apply Expected [1 3] != Got %v", out)
+	}
+}
+
+func TestEngineCacheSize(t *testing.T) {
+	give, err := tags.MakeTagRule(100, tags.Tags{1}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	e := New(tags.TagRules{give}, 2)
+
+	for i := uint64(1); i <= 5; i++ {
+		e.Apply(tags.Tags{1, i + 1})
+	}
+
+	if e.count > 2 {
+		t.Fatalf("cache count Expected <= 2 != Got %d", e.count)
+	}
+}
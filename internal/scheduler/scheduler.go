@@ -0,0 +1,397 @@
+// Runs named tasks at regular intervals.
+//
+// ImageProc, Render, Weighter and CMerge all need to run something every so often, with the intervals able
+// to change whenever the configuration does. Each used to keep its own sorted list of next run times and a ticker,
+// this replaces all of those.
+//
+// Every task is run from the single Scheduler goroutine, one after the other. So a task that takes a while will
+// delay any other task due at the same time. Tasks that should not wait on each other (checking multiple bases for
+// example) should start their own goroutine.
+//
+// If a task is running when its next run comes due, it simply runs again as soon as it returns. Missed runs are
+// not queued up, so a task that takes longer then its interval runs back to back rather then building a backlog.
+//
+// All background work can be paused with Pause(), such as while reorganizing the directories of a base. Tasks simply
+// skip their runs until Resume(), the same as any other missed run. Schedulers for housekeeping that should carry on
+// regardless (watching the configuration for example) use IgnorePause().
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotFound = errors.New("task not found")
+var ErrInterval = errors.New("invalid interval")
+
+// Set while every Scheduler is paused, access only with atomics.
+var paused uint32
+
+// How many tasks are running, other then those of a Scheduler using IgnorePause(). Access only with atomics.
+var running int32
+
+// Every Scheduler still running, for Stalled(). Need schedMut to access.
+var schedMut sync.Mutex
+var scheds = make(map[*Scheduler]struct{})
+
+// type task struct {{{
+
+type task struct {
+	name     string
+	interval time.Duration
+	next     time.Time
+	fn       func()
+} // }}}
+
+// type Scheduler struct {{{
+
+type Scheduler struct {
+	// Need mut to access tasks.
+	mut   sync.Mutex
+	tasks map[string]*task
+
+	// Lets the loop know the tasks changed, so it can recalculate how long to wait.
+	wake chan struct{}
+
+	ctx context.Context
+	can context.CancelFunc
+
+	// Closed once the loop returns.
+	done chan struct{}
+
+	// Set by IgnorePause(), access only with atomics.
+	always uint32
+
+	// The task being run and when it started, empty if none. Need mut to access.
+	current string
+	started time.Time
+} // }}}
+
+// func New {{{
+
+// Creates a new Scheduler, which runs until either ctx is done or Stop() is called.
+func New(ctx context.Context) *Scheduler {
+	s := &Scheduler{
+		tasks: make(map[string]*task),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	s.ctx, s.can = context.WithCancel(ctx)
+
+	schedMut.Lock()
+	scheds[s] = struct{}{}
+	schedMut.Unlock()
+
+	go s.loopy()
+
+	return s
+} // }}}
+
+// func Pause {{{
+
+// Pauses every Scheduler, other then those using IgnorePause(), until Resume() is called.
+//
+// Any task already running is left to finish.
+func Pause() {
+	atomic.StoreUint32(&paused, 1)
+} // }}}
+
+// func Resume {{{
+
+// Resumes every Scheduler, with each task running at its next interval.
+func Resume() {
+	atomic.StoreUint32(&paused, 0)
+} // }}}
+
+// func Quiesce {{{
+
+// Pauses every Scheduler the same as Pause(), then waits for any task already running to return.
+//
+// Used to get everything to a consistent state, such as for a backup. Tasks can still have started work in their
+// own goroutines, which is not waited on.
+//
+// If the context is done first, its error is returned and everything is left paused.
+func Quiesce(ctx context.Context) error {
+	Pause()
+
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	for atomic.LoadInt32(&running) > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+} // }}}
+
+// func Stalled {{{
+
+// Returns the name of every task, across every Scheduler, that has been running for longer then limit.
+//
+// As every task of a Scheduler is run one after the other, a task that never returns (such as from a deadlock) stops
+// the others from running as well. Used by bin/frame to stop telling systemd it is alive.
+func Stalled(limit time.Duration) []string {
+	var names []string
+
+	now := time.Now()
+
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	for s := range scheds {
+		s.mut.Lock()
+		if s.current != "" && now.Sub(s.started) > limit {
+			names = append(names, s.current)
+		}
+		s.mut.Unlock()
+	}
+
+	sort.Strings(names)
+
+	return names
+} // }}}
+
+// func Paused {{{
+
+func Paused() bool {
+	return atomic.LoadUint32(&paused) == 1
+} // }}}
+
+// func Scheduler.IgnorePause {{{
+
+// Keeps running the tasks of this Scheduler even when paused, see Pause().
+func (s *Scheduler) IgnorePause() {
+	atomic.StoreUint32(&s.always, 1)
+} // }}}
+
+// func Scheduler.Add {{{
+
+// Adds a task to be run every interval, with the first run one interval from now.
+//
+// If a task with the same name already exists it is replaced.
+func (s *Scheduler) Add(name string, interval time.Duration, fn func()) error {
+	if interval <= 0 {
+		return ErrInterval
+	}
+
+	s.mut.Lock()
+	s.tasks[name] = &task{
+		name:     name,
+		interval: interval,
+		next:     time.Now().Add(interval),
+		fn:       fn,
+	}
+	s.mut.Unlock()
+
+	s.poke()
+
+	return nil
+} // }}}
+
+// func Scheduler.Reschedule {{{
+
+// Changes the interval of an existing task, with the next run one interval from now.
+//
+// If the interval is the same as it already is nothing is changed, so this can be called each time the
+// configuration changes without pushing back the next run.
+func (s *Scheduler) Reschedule(name string, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInterval
+	}
+
+	s.mut.Lock()
+
+	t, ok := s.tasks[name]
+	if !ok {
+		s.mut.Unlock()
+		return ErrNotFound
+	}
+
+	if t.interval == interval {
+		s.mut.Unlock()
+		return nil
+	}
+
+	t.interval = interval
+	t.next = time.Now().Add(interval)
+	s.mut.Unlock()
+
+	s.poke()
+
+	return nil
+} // }}}
+
+// func Scheduler.Remove {{{
+
+// Removes the task, it will not be run again.
+//
+// Does nothing if no task has the name.
+func (s *Scheduler) Remove(name string) {
+	s.mut.Lock()
+	delete(s.tasks, name)
+	s.mut.Unlock()
+
+	s.poke()
+} // }}}
+
+// func Scheduler.Next {{{
+
+// Returns the next time the task will run, and false if no such task exists.
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	t, ok := s.tasks[name]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return t.next, true
+} // }}}
+
+// func Scheduler.Stop {{{
+
+// Stops running any tasks.
+//
+// Does not return until any task currently running has returned.
+func (s *Scheduler) Stop() {
+	s.can()
+	<-s.done
+} // }}}
+
+// func Scheduler.poke {{{
+
+func (s *Scheduler) poke() {
+	// The channel is buffered, so if the loop has not yet noticed the last poke there is no need for another.
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+} // }}}
+
+// func Scheduler.due {{{
+
+// Returns the tasks that are due to run, in the order they were due, updating each to its next run.
+//
+// Also returns how long until the next task is due, or 0 if there are no tasks.
+func (s *Scheduler) due(now time.Time) ([]*task, time.Duration) {
+	var run []*task
+	var wait time.Duration
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, t := range s.tasks {
+		if !t.next.After(now) {
+			// Copy the task, so changes while its running do not matter.
+			ct := *t
+			run = append(run, &ct)
+
+			t.next = now.Add(t.interval)
+		}
+
+		if d := t.next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	sort.Slice(run, func(i, j int) bool {
+		if run[i].next.Equal(run[j].next) {
+			return run[i].name < run[j].name
+		}
+
+		return run[i].next.Before(run[j].next)
+	})
+
+	return run, wait
+} // }}}
+
+// func Scheduler.run {{{
+
+func (s *Scheduler) run(t *task) {
+	always := atomic.LoadUint32(&s.always) == 1
+
+	// Counted before checking if paused, so Quiesce() either sees us running or we see the pause.
+	if !always {
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+	}
+
+	// Paused, so the run is simply missed.
+	if Paused() && !always {
+		return
+	}
+
+	s.mut.Lock()
+	s.current, s.started = t.name, time.Now()
+	s.mut.Unlock()
+
+	t.fn()
+
+	s.mut.Lock()
+	s.current = ""
+	s.mut.Unlock()
+} // }}}
+
+// func Scheduler.loopy {{{
+
+func (s *Scheduler) loopy() {
+	defer close(s.done)
+
+	defer func() {
+		schedMut.Lock()
+		delete(scheds, s)
+		schedMut.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		run, wait := s.due(time.Now())
+
+		for _, t := range run {
+			// Stopped while running the others?
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			s.run(t)
+		}
+
+		// Running the tasks took time, so anything due since then gets run right away.
+		if len(run) > 0 {
+			continue
+		}
+
+		// No tasks at all, so just wait to be poked.
+		if wait == 0 {
+			wait = time.Hour
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+} // }}}
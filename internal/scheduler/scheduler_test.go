@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuns(t *testing.T) {
+	fast := make(chan struct{}, 100)
+	slow := make(chan struct{}, 100)
+
+	// Never blocks, so a full channel can not hold up the scheduler.
+	run := func(ch chan struct{}) func() {
+		return func() {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	s := New(context.Background())
+	defer s.Stop()
+
+	if err := s.Add("fast", 10*time.Millisecond, run(fast)); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	if err := s.Add("slow", 50*time.Millisecond, run(slow)); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	// No upper bound on how long, a loaded machine can be a lot slower then the intervals.
+	wait := func(name string, ch chan struct{}, runs int) {
+		deadline := time.After(10 * time.Second)
+
+		for i := 0; i < runs; i++ {
+			select {
+			case <-ch:
+			case <-deadline:
+				t.Fatalf("%s Expected %d runs != Got %d", name, runs, i)
+			}
+		}
+	}
+
+	// Both keep running on their own, not only once.
+	wait("fast", fast, 5)
+	wait("slow", slow, 2)
+}
+
+func TestInterval(t *testing.T) {
+	s := New(context.Background())
+	defer s.Stop()
+
+	if err := s.Add("bad", 0, func() {}); err != ErrInterval {
+		t.Fatalf("Add Expected ErrInterval != Got %v", err)
+	}
+
+	if err := s.Reschedule("missing", time.Second); err != ErrNotFound {
+		t.Fatalf("Reschedule Expected ErrNotFound != Got %v", err)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	var runs uint32
+
+	s := New(context.Background())
+	defer s.Stop()
+
+	s.Add("task", time.Hour, func() { atomic.AddUint32(&runs, 1) })
+
+	first, ok := s.Next("task")
+	if !ok {
+		t.Fatalf("Next did not find task")
+	}
+
+	// Same interval should not push back the next run.
+	s.Reschedule("task", time.Hour)
+
+	if next, _ := s.Next("task"); !next.Equal(first) {
+		t.Fatalf("Reschedule with the same interval changed next run %s != %s", first, next)
+	}
+
+	// While a new one should take effect right away, not after the hour.
+	s.Reschedule("task", 10*time.Millisecond)
+
+	time.Sleep(55 * time.Millisecond)
+
+	if got := atomic.LoadUint32(&runs); got < 2 {
+		t.Fatalf("Expected at least 2 runs != Got %d", got)
+	}
+}
+
+func TestRemoveStop(t *testing.T) {
+	var runs uint32
+
+	ctx, can := context.WithCancel(context.Background())
+
+	s := New(ctx)
+
+	s.Add("task", 5*time.Millisecond, func() { atomic.AddUint32(&runs, 1) })
+	time.Sleep(30 * time.Millisecond)
+
+	s.Remove("task")
+
+	if _, ok := s.Next("task"); ok {
+		t.Fatalf("task still exists after Remove")
+	}
+
+	got := atomic.LoadUint32(&runs)
+	time.Sleep(30 * time.Millisecond)
+
+	if after := atomic.LoadUint32(&runs); after != got {
+		t.Fatalf("task ran after Remove, %d != %d", got, after)
+	}
+
+	// Cancelling the context stops the Scheduler.
+	s.Add("task", 5*time.Millisecond, func() { atomic.AddUint32(&runs, 1) })
+	can()
+
+	// Stop should still return after the context was cancelled.
+	s.Stop()
+
+	got = atomic.LoadUint32(&runs)
+	time.Sleep(30 * time.Millisecond)
+
+	if after := atomic.LoadUint32(&runs); after != got {
+		t.Fatalf("task ran after Stop, %d != %d", got, after)
+	}
+}
+
+func TestPause(t *testing.T) {
+	var runs, always uint32
+
+	s := New(context.Background())
+	defer s.Stop()
+
+	sa := New(context.Background())
+	defer sa.Stop()
+
+	sa.IgnorePause()
+
+	s.Add("task", 10*time.Millisecond, func() { atomic.AddUint32(&runs, 1) })
+	sa.Add("task", 10*time.Millisecond, func() { atomic.AddUint32(&always, 1) })
+
+	Pause()
+	time.Sleep(55 * time.Millisecond)
+
+	if got := atomic.LoadUint32(&runs); got != 0 {
+		Resume()
+		t.Fatalf("paused task ran %d times", got)
+	}
+
+	if got := atomic.LoadUint32(&always); got < 2 {
+		Resume()
+		t.Fatalf("IgnorePause task Expected ~5 != Got %d", got)
+	}
+
+	Resume()
+	time.Sleep(55 * time.Millisecond)
+
+	if got := atomic.LoadUint32(&runs); got < 2 {
+		t.Fatalf("resumed task Expected ~5 != Got %d", got)
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	var done uint32
+
+	s := New(context.Background())
+	defer s.Stop()
+
+	started := make(chan struct{}, 1)
+
+	s.Add("slow", 10*time.Millisecond, func() {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreUint32(&done, 1)
+	})
+
+	<-started
+
+	defer Resume()
+
+	if err := Quiesce(context.Background()); err != nil {
+		t.Fatalf("Quiesce: %s", err)
+	}
+
+	if atomic.LoadUint32(&done) != 1 {
+		t.Fatalf("Quiesce returned while the task was still running")
+	}
+
+	// Already quiet, so returns right away even with a done context.
+	ctx, can := context.WithCancel(context.Background())
+	can()
+
+	if err := Quiesce(ctx); err != nil {
+		t.Fatalf("Quiesce while quiet: %s", err)
+	}
+}
+
+func TestStalled(t *testing.T) {
+	release := make(chan struct{})
+
+	s := New(context.Background())
+	defer s.Stop()
+
+	s.Add("stuck", 10*time.Millisecond, func() { <-release })
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := Stalled(time.Hour); len(got) != 0 {
+		t.Fatalf("Stalled(1h) Expected [] != Got %v", got)
+	}
+
+	if got := Stalled(20 * time.Millisecond); len(got) != 1 || got[0] != "stuck" {
+		t.Fatalf("Stalled Expected [stuck] != Got %v", got)
+	}
+
+	close(release)
+	s.Remove("stuck")
+
+	time.Sleep(20 * time.Millisecond)
+
+	if got := Stalled(0); len(got) != 0 {
+		t.Fatalf("Stalled after release Expected [] != Got %v", got)
+	}
+}
@@ -0,0 +1,750 @@
+// Screens images for sensitive content (such as nudity), flagging the suspect ones with a tag so they can be kept
+// off public facing frames.
+//
+// Only the bases opted in are screened. Every Interval a batch of images not yet screened is loaded from the
+// CacheManager and handed to the model runner (see confYAML.Command), and any scoring at least the Threshold for one
+// of the Labels is given the Tag. It is saved for the hash as a whole in the files.autotags table the same as the
+// classify module, and CMerge combines it with the tags of the files (see its auto queries). Add the Tag to BlockTags
+// of cachemerge to keep flagged images off every frame.
+//
+// Each image flagged is logged, and recorded by the audit query if set, so what was hidden and why can be reviewed.
+package screen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frame/internal/httpclient"
+	"frame/internal/modelrun"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/internal/scheduler"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"image"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inB.Database != "" {
+		inA.Database = inB.Database
+	}
+
+	if inB.Queries.Pending != "" {
+		inA.Queries.Pending = inB.Queries.Pending
+	}
+
+	if inB.Queries.Save != "" {
+		inA.Queries.Save = inB.Queries.Save
+	}
+
+	if inB.Queries.Audit != "" {
+		inA.Queries.Audit = inB.Queries.Audit
+	}
+
+	if len(inB.Command) > 0 {
+		inA.Command = inB.Command
+	}
+
+	if inB.URL != "" {
+		inA.URL = inB.URL
+	}
+
+	if inB.HTTP != (httpclient.Config{}) {
+		inA.HTTP = inB.HTTP
+	}
+
+	if len(inB.Labels) > 0 {
+		inA.Labels = inB.Labels
+	}
+
+	if len(inB.Bases) > 0 {
+		inA.Bases = inB.Bases
+	}
+
+	// The rest always have a value once converted, so only one not the default replaces it.
+	if inB.Threshold != defThreshold {
+		inA.Threshold = inB.Threshold
+	}
+
+	if inB.Tag != defTag {
+		inA.Tag = inB.Tag
+	}
+
+	if inB.BatchSize != defBatchSize {
+		inA.BatchSize = inB.BatchSize
+	}
+
+	if inB.Size != defSize {
+		inA.Size = inB.Size
+	}
+
+	if inB.Source != defSource {
+		inA.Source = inB.Source
+	}
+
+	if inB.Interval != defInterval {
+		inA.Interval = inB.Interval
+	}
+
+	if inB.Timeout != defTimeout {
+		inA.Timeout = inB.Timeout
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Database != newConf.Database || origConf.Queries != newConf.Queries {
+		return true
+	}
+
+	if strings.Join(origConf.Command, "\x00") != strings.Join(newConf.Command, "\x00") {
+		return true
+	}
+
+	if origConf.URL != newConf.URL || origConf.HTTP != newConf.HTTP {
+		return true
+	}
+
+	if len(origConf.Labels) != len(newConf.Labels) {
+		return true
+	}
+
+	for label := range origConf.Labels {
+		if !newConf.Labels[label] {
+			return true
+		}
+	}
+
+	if len(origConf.Bases) != len(newConf.Bases) {
+		return true
+	}
+
+	for i := range origConf.Bases {
+		if origConf.Bases[i] != newConf.Bases[i] {
+			return true
+		}
+	}
+
+	if origConf.Threshold != newConf.Threshold || origConf.Tag != newConf.Tag || origConf.Source != newConf.Source {
+		return true
+	}
+
+	if origConf.BatchSize != newConf.BatchSize || origConf.Size != newConf.Size {
+		return true
+	}
+
+	if origConf.Interval != newConf.Interval || origConf.Timeout != newConf.Timeout {
+		return true
+	}
+
+	return false
+} // }}}
+
+// Defaults for everything in confYAML not set.
+const (
+	defThreshold = 0.8
+	defTag       = "flagged"
+	defBatchSize = 16
+	defSize      = 224
+	defSource    = "screen"
+	defInterval  = time.Minute
+	defTimeout   = 5 * time.Minute
+)
+
+// func yconfConvert {{{
+
+func yconfConvert(inInt interface{}) (interface{}, error) {
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	out := &conf{
+		Database:  in.Database,
+		Queries:   in.Queries,
+		Threshold: in.Threshold,
+		Tag:       strings.TrimSpace(in.Tag),
+		BatchSize: in.BatchSize,
+		Size:      in.Size,
+		Source:    strings.TrimSpace(in.Source),
+		Interval:  time.Duration(in.Interval),
+		Timeout:   time.Duration(in.Timeout),
+	}
+
+	for _, arg := range in.Command {
+		out.Command = append(out.Command, strings.ReplaceAll(arg, "{model}", in.Model))
+	}
+
+	out.URL = strings.ReplaceAll(strings.TrimSpace(in.URL), "{model}", in.Model)
+	out.HTTP = in.HTTP
+
+	for _, label := range in.Labels {
+		if label = normLabel(label); label != "" {
+			if out.Labels == nil {
+				out.Labels = make(map[string]bool, len(in.Labels))
+			}
+
+			out.Labels[label] = true
+		}
+	}
+
+	seen := make(map[int]bool, len(in.Bases))
+
+	for _, base := range in.Bases {
+		if base < 1 {
+			return nil, errors.New("bases must all be at least 1")
+		}
+
+		if !seen[base] {
+			seen[base] = true
+			out.Bases = append(out.Bases, base)
+		}
+	}
+
+	sort.Ints(out.Bases)
+
+	if out.Threshold == 0 {
+		out.Threshold = defThreshold
+	}
+
+	if out.Threshold < 0 || out.Threshold > 1 {
+		return nil, errors.New("threshold must be between 0 and 1")
+	}
+
+	if out.Tag == "" {
+		out.Tag = defTag
+	}
+
+	if out.BatchSize == 0 {
+		out.BatchSize = defBatchSize
+	}
+
+	if out.BatchSize < 1 || out.BatchSize > 1000 {
+		return nil, errors.New("batchsize must be between 1 and 1000")
+	}
+
+	if out.Size == 0 {
+		out.Size = defSize
+	}
+
+	if out.Size < 16 || out.Size > 4096 {
+		return nil, errors.New("size must be between 16 and 4096")
+	}
+
+	if out.Source == "" {
+		out.Source = defSource
+	}
+
+	if out.Interval == 0 {
+		out.Interval = defInterval
+	}
+
+	if out.Interval < time.Second {
+		return nil, errors.New("interval too short")
+	}
+
+	if out.Timeout == 0 {
+		out.Timeout = defTimeout
+	}
+
+	return out, nil
+} // }}}
+
+// func New {{{
+
+// Loads the configuration at confPath and starts screening every Interval.
+//
+// The CacheManager provides the images, the TagManager the ID of the Tag.
+func New(confPath string, cm types.CacheManager, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (*Screen, error) {
+	var err error
+
+	sc := &Screen{
+		l:     l.With().Str("mod", "screen").Logger(),
+		cPath: confPath,
+		cm:    cm,
+		tm:    tm,
+		ctx:   ctx,
+	}
+
+	fl := sc.l.With().Str("func", "New").Logger()
+
+	sc.db = pgdb.New(&sc.l, ctx)
+
+	if err = sc.loadConf(); err != nil {
+		return nil, err
+	}
+
+	// Start background processing to watch configuration for changes.
+	sc.yc.Start()
+
+	sc.sch = scheduler.New(sc.ctx)
+
+	if err = sc.sch.Add("screen", sc.getConf().Interval, sc.tickScreen); err != nil {
+		sc.close()
+		return nil, err
+	}
+
+	// Close down once we are done.
+	go func() {
+		<-sc.ctx.Done()
+		sc.close()
+	}()
+
+	fl.Debug().Send()
+
+	return sc, nil
+} // }}}
+
+// func Screen.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (sc *Screen) loadConf() error {
+	var err error
+
+	fl := sc.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		sc.notifyConf()
+	}
+
+	if sc.yc, err = yconf.New(sc.cPath, ycc, &sc.l, sc.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = sc.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(sc.yc.Get())).Send()
+
+	co, ok := sc.yc.Get().(*conf)
+	if !ok {
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = checkConf(co); err != nil {
+		fl.Err(err).Send()
+		return err
+	}
+
+	if err = sc.newClient(co, nil); err != nil {
+		fl.Err(err).Msg("newClient")
+		return err
+	}
+
+	if err = sc.dbConnect(co); err != nil {
+		fl.Err(err).Msg("dbConnect")
+		return err
+	}
+
+	sc.co.Store(co)
+
+	return nil
+} // }}}
+
+// func Screen.notifyConf {{{
+
+func (sc *Screen) notifyConf() {
+	fl := sc.l.With().Str("func", "notifyConf").Logger()
+
+	co, ok := sc.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	if err := checkConf(co); err != nil {
+		fl.Warn().Err(err).Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	oldco := sc.getConf()
+
+	if err := sc.newClient(co, oldco); err != nil {
+		fl.Warn().Err(err).Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	// The queries are prepared at connection, so either changing needs a new one.
+	if co.Database != oldco.Database || co.Queries != oldco.Queries {
+		if err := sc.dbConnect(co); err != nil {
+			fl.Err(err).Msg("dbConnect")
+			return
+		}
+	}
+
+	sc.co.Store(co)
+
+	if err := sc.sch.Reschedule("screen", co.Interval); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func checkConf {{{
+
+func checkConf(co *conf) error {
+	switch {
+	case co.Database == "":
+		return errors.New("missing database")
+	case co.Queries.Pending == "":
+		return errors.New("missing queries.pending")
+	case co.Queries.Save == "":
+		return errors.New("missing queries.save")
+	case len(co.Command) == 0 && co.URL == "":
+		return errors.New("missing command or url")
+	case len(co.Command) > 0 && co.URL != "":
+		return errors.New("only one of command and url can be set")
+	case len(co.Labels) == 0:
+		return errors.New("missing labels")
+	case len(co.Bases) == 0:
+		// Opt-in only, screening everything by default is not what anyone expects.
+		return errors.New("missing bases")
+	}
+
+	return nil
+} // }}}
+
+// func Screen.newClient {{{
+
+// Sets the client of co when it uses a URL, see confYAML.URL.
+//
+// The client of old is kept if the HTTP settings are the same, so the rate limit carries on across changes.
+func (sc *Screen) newClient(co, old *conf) error {
+	if co.URL == "" {
+		return nil
+	}
+
+	if old != nil && old.client != nil && old.HTTP == co.HTTP {
+		co.client = old.client
+		return nil
+	}
+
+	c, err := httpclient.New("screen", co.HTTP, &sc.l)
+	if err != nil {
+		return err
+	}
+
+	co.client = c
+
+	return nil
+} // }}}
+
+// func runModel {{{
+
+// Hands the images to the model, either running the Command or sending them to the URL.
+func runModel(ctx context.Context, co *conf, imgs map[uint64]image.Image, fn func(id uint64, line []byte) error) error {
+	if co.URL != "" {
+		return modelrun.Post(ctx, co.client, co.URL, imgs, fn)
+	}
+
+	return modelrun.Run(ctx, co.Command, imgs, fn)
+} // }}}
+
+// func Screen.dbConnect {{{
+
+func (sc *Screen) dbConnect(co *conf) error {
+	return sc.db.Connect(&pgdb.Config{
+		Database: co.Database,
+		Statements: []pgdb.Statement{
+			{Name: "pending", Query: co.Queries.Pending},
+			{Name: "save", Query: co.Queries.Save},
+			{Name: "audit", Query: co.Queries.Audit},
+		},
+	})
+} // }}}
+
+// func Screen.getConf {{{
+
+func (sc *Screen) getConf() *conf {
+	if co, ok := sc.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	sc.l.Warn().Str("func", "getConf").Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func Screen.tickScreen {{{
+
+// Run by the scheduler, screens a single batch.
+func (sc *Screen) tickScreen() {
+	fl := sc.l.With().Str("func", "tickScreen").Logger()
+
+	done, flagged, err := sc.screenBatch(sc.getConf())
+	if err != nil {
+		fl.Err(err).Msg("screenBatch")
+		return
+	}
+
+	if done > 0 {
+		fl.Info().Int("images", done).Int("flagged", flagged).Msg("screened")
+	}
+} // }}}
+
+// func Screen.pending {{{
+
+// Returns the hash IDs of up to BatchSize images not yet screened.
+func (sc *Screen) pending(co *conf) ([]uint64, error) {
+	var ids []uint64
+
+	db, err := sc.db.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(sc.ctx, "pending", co.Source, co.Bases, co.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+} // }}}
+
+// func Screen.screenBatch {{{
+
+// Screens the next batch of pending images, returning how many were saved and how many of those were flagged.
+func (sc *Screen) screenBatch(co *conf) (int, int, error) {
+	var done, flagged, seen int
+
+	fl := sc.l.With().Str("func", "screenBatch").Logger()
+
+	if atomic.LoadUint32(&sc.closed) == 1 {
+		return 0, 0, types.ErrShutdown
+	}
+
+	ids, err := sc.pending(co)
+	if err != nil || len(ids) == 0 {
+		return 0, 0, err
+	}
+
+	tid, err := sc.tm.Get(co.Tag)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imgs := make(map[uint64]image.Image, len(ids))
+
+	for _, id := range ids {
+		img, err := sc.cm.LoadImage(id, image.Point{co.Size, co.Size}, false)
+		if errors.Is(err, types.ErrNotFound) {
+			// Not cached means never shown either, so nothing to hide.
+			fl.Debug().Uint64("id", id).Msg("not cached")
+
+			if err := sc.save(id, co.Source, nil); err != nil {
+				return done, flagged, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			fl.Err(err).Uint64("id", id).Msg("LoadImage")
+			continue
+		}
+
+		imgs[id] = img
+	}
+
+	if len(imgs) == 0 {
+		return done, flagged, nil
+	}
+
+	ctx, can := context.WithTimeout(sc.ctx, co.Timeout)
+	defer can()
+
+	err = runModel(ctx, co, imgs, func(id uint64, line []byte) error {
+		var rl runnerLine
+
+		seen++
+
+		if err := json.Unmarshal(line, &rl); err != nil {
+			fl.Err(err).Uint64("id", id).Msg("Unmarshal")
+			return nil
+		}
+
+		var tgs tags.Tags
+
+		scores := suspect(rl.Labels, co.Labels, co.Threshold)
+		if scores != nil {
+			if err := sc.audit(co, id, scores); err != nil {
+				return err
+			}
+
+			tgs = tags.Tags{tid}
+			flagged++
+		}
+
+		if err := sc.save(id, co.Source, tgs); err != nil {
+			return err
+		}
+
+		done++
+
+		return nil
+	})
+	if err != nil {
+		return done, flagged, err
+	}
+
+	if missing := len(imgs) - seen; missing > 0 {
+		fl.Warn().Int("missing", missing).Msg("runner left out images, they are tried again next time")
+	}
+
+	return done, flagged, nil
+} // }}}
+
+// type runnerLine struct {{{
+
+// A single line of output from the runner, the same as the classify module.
+type runnerLine struct {
+	File   string             `json:"file"`
+	Labels map[string]float64 `json:"labels"`
+} // }}}
+
+// func normLabel {{{
+
+// Labels are trimmed and lower cased, with any spaces replaced by underscores, the same as the classify module.
+func normLabel(label string) string {
+	return strings.Join(strings.Fields(strings.ToLower(label)), "_")
+} // }}}
+
+// func suspect {{{
+
+// Returns the scores of every suspect label at least the threshold, nil if there are none and the image is fine.
+func suspect(scores map[string]float64, labels map[string]bool, threshold float64) map[string]float64 {
+	var found map[string]float64
+
+	for label, score := range scores {
+		if score < threshold {
+			continue
+		}
+
+		if label = normLabel(label); !labels[label] {
+			continue
+		}
+
+		if found == nil {
+			found = make(map[string]float64, 1)
+		}
+
+		found[label] = score
+	}
+
+	return found
+} // }}}
+
+// func Screen.audit {{{
+
+// Logs the image as flagged, and records it with the audit query if set.
+func (sc *Screen) audit(co *conf, id uint64, scores map[string]float64) error {
+	sc.l.Info().Str("func", "audit").Uint64("id", id).Interface("scores", scores).Msg("flagged")
+
+	if co.Queries.Audit == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(scores)
+	if err != nil {
+		return err
+	}
+
+	db, err := sc.db.Get()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(sc.ctx, "audit", id, co.Source, string(data))
+	return err
+} // }}}
+
+// func Screen.save {{{
+
+func (sc *Screen) save(id uint64, source string, tgs tags.Tags) error {
+	db, err := sc.db.Get()
+	if err != nil {
+		return err
+	}
+
+	// Never NULL, an empty array marks it as done.
+	if tgs == nil {
+		tgs = tags.Tags{}
+	}
+
+	_, err = db.Exec(sc.ctx, "save", id, source, tgs)
+	return err
+} // }}}
+
+// func Screen.close {{{
+
+// Stops all background processing and disconnects from the database.
+func (sc *Screen) close() {
+	fl := sc.l.With().Str("func", "close").Logger()
+
+	if !atomic.CompareAndSwapUint32(&sc.closed, 0, 1) {
+		fl.Info().Msg("already closed")
+		return
+	}
+
+	sc.db.Close()
+
+	fl.Info().Msg("closed")
+} // }}}
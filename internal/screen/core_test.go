@@ -0,0 +1,54 @@
+package screen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuspect(t *testing.T) {
+	labels := map[string]bool{"porn": true, "sexy": true}
+
+	scores := map[string]float64{
+		"Porn":    0.91,
+		"sexy":    0.4,
+		"neutral": 0.95,
+	}
+
+	got := suspect(scores, labels, 0.8)
+	if want := map[string]float64{"porn": 0.91}; !reflect.DeepEqual(got, want) {
+		t.Errorf("suspect = %v, want %v", got, want)
+	}
+
+	// Fine, so nothing at all rather then empty.
+	if got := suspect(map[string]float64{"neutral": 0.99}, labels, 0.8); got != nil {
+		t.Errorf("suspect = %v, want nil", got)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	coInt, err := yconfConvert(&confYAML{
+		Labels: []string{" Porn", "hentai", ""},
+		Bases:  []int{3, 1, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	co := coInt.(*conf)
+
+	if want := map[string]bool{"porn": true, "hentai": true}; !reflect.DeepEqual(co.Labels, want) {
+		t.Errorf("labels = %v, want %v", co.Labels, want)
+	}
+
+	if want := []int{1, 3}; !reflect.DeepEqual(co.Bases, want) {
+		t.Errorf("bases = %v, want %v", co.Bases, want)
+	}
+
+	if co.Tag != defTag || co.Threshold != defThreshold {
+		t.Errorf("defaults not set, tag %q threshold %f", co.Tag, co.Threshold)
+	}
+
+	if _, err := yconfConvert(&confYAML{Bases: []int{0}}); err == nil {
+		t.Error("expected an error for base 0")
+	}
+}
@@ -0,0 +1,148 @@
+package screen
+
+import (
+	"context"
+	"frame/internal/httpclient"
+	"frame/internal/pgdb"
+	"frame/internal/scheduler"
+	"frame/types"
+	"frame/yconf"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type confQueries struct {
+	// Returns the hash ID of images not yet screened by the source with a file in one of the bases, given the source,
+	// bases and how many are wanted.
+	Pending string `yaml:"pending"`
+
+	// Saves the tags of a hash, given the hash ID, source and tags.
+	Save string `yaml:"save"`
+
+	// Optional - Records each image flagged, given the hash ID, source and the scores of the suspect labels as JSON.
+	Audit string `yaml:"audit"`
+}
+
+// type confYAML struct {{{
+
+type confYAML struct {
+	Database string `yaml:"database" log:"redact"`
+
+	Queries confQueries `yaml:"queries"`
+
+	// The model runner and its arguments, the same as the classify module (see the modelrun package). This can be a
+	// local model, or a small script handing the images to an external moderation API.
+	//
+	// Any "{model}" is replaced with Model.
+	Command []string `yaml:"command"`
+
+	// Instead of Command, the images are sent to the model behind this web service, see modelrun.Post(). Any "{model}"
+	// is replaced with Model.
+	URL string `yaml:"url" log:"redact"`
+
+	// The rate limit, retries and proxy for URL.
+	HTTP httpclient.Config `yaml:"http"`
+
+	// The model file, such as an NSFW classifier exported as ONNX.
+	Model string `yaml:"model"`
+
+	// The labels of the model that make an image suspect, such as "porn" and "sexy".
+	Labels []string `yaml:"labels"`
+
+	// The lowest score (0 to 1) of any one of the Labels for an image to be flagged. Default if not set is 0.8.
+	Threshold float64 `yaml:"threshold"`
+
+	// The tag given to flagged images, add it to the BlockTags of cachemerge to keep them off every frame (or to a
+	// TagProfile to keep them off only some). Default if not set is "flagged".
+	Tag string `yaml:"tag"`
+
+	// Only images with a file in one of these bases are screened, such as those shared by others.
+	Bases []int `yaml:"bases"`
+
+	// How many images the runner is given at once. Default if not set is 16.
+	BatchSize int `yaml:"batchsize"`
+
+	// The size each image is scaled down to fit within before the runner sees it. Default if not set is 224.
+	Size int `yaml:"size"`
+
+	// Who the tags are saved as, see the files.autotags table. Default if not set is "screen".
+	//
+	// Changing it (such as for a new model) screens every image again.
+	Source string `yaml:"source"`
+
+	// How often a batch is screened. Default if not set is 1 minute.
+	Interval yconf.Duration `yaml:"interval"`
+
+	// The longest the runner can take for a single batch. Default if not set is 5 minutes.
+	Timeout yconf.Duration `yaml:"timeout"`
+} // }}}
+
+// type conf struct {{{
+
+type conf struct {
+	Database string `log:"redact"`
+
+	Queries confQueries
+
+	// See confYAML.Command, with Model already put in place.
+	Command []string
+
+	// See confYAML.URL, with Model already put in place.
+	URL  string `log:"redact"`
+	HTTP httpclient.Config
+
+	// Sends to URL, set once the configuration is loaded, see newClient().
+	client *httpclient.Client
+
+	// Normalized the same as each label returned by the runner.
+	Labels map[string]bool
+
+	Threshold float64
+	Tag       string
+	Bases     []int
+	BatchSize int
+	Size      int
+	Source    string
+	Interval  time.Duration
+	Timeout   time.Duration
+} // }}}
+
+// type Screen struct {{{
+
+type Screen struct {
+	l zerolog.Logger
+
+	// We use an atomic for the configuration since we might replace it at any time while another goroutine
+	// can be using it.
+	co atomic.Value
+
+	// Our configuration path.
+	cPath string
+
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
+
+	cm types.CacheManager
+	tm types.TagManager
+
+	yc *yconf.YConf
+
+	// Runs each batch.
+	sch *scheduler.Scheduler
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+} // }}}
+
+// Notify is set in New()
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Changed: yconfChanged,
+	Convert: yconfConvert,
+}
@@ -0,0 +1,32 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// func splitKeyring {{{
+
+// Splits a keyring reference into the service and account.
+func splitKeyring(ref string) (string, string, error) {
+	slash := strings.LastIndexByte(ref, '/')
+	if slash < 1 || slash == len(ref)-1 {
+		return "", "", errors.New("must be service/account")
+	}
+
+	return ref[:slash], ref[slash+1:], nil
+} // }}}
+
+// func run {{{
+
+// Runs the keyring tool, returning what it printed less the trailing newline.
+func run(cmd *exec.Cmd) (string, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", cmd.Path, err)
+	}
+
+	return strings.TrimRight(string(out), "\r\n"), nil
+} // }}}
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package secrets
+
+import (
+	"os/exec"
+)
+
+// func keyring {{{
+
+// Looks up "service/account" from the login keychain.
+func keyring(ref string) (string, error) {
+	service, account, err := splitKeyring(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return run(exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w"))
+} // }}}
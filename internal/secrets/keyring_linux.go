@@ -0,0 +1,19 @@
+//go:build linux
+
+package secrets
+
+import (
+	"os/exec"
+)
+
+// func keyring {{{
+
+// Looks up "service/account" with secret-tool, from the Secret Service (GNOME Keyring, KWallet and the like).
+func keyring(ref string) (string, error) {
+	service, account, err := splitKeyring(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return run(exec.Command("secret-tool", "lookup", "service", service, "account", account))
+} // }}}
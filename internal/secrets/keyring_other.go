@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package secrets
+
+import (
+	"errors"
+)
+
+// func keyring {{{
+
+func keyring(ref string) (string, error) {
+	return "", errors.New("keyring not supported on this OS")
+} // }}}
@@ -0,0 +1,262 @@
+// Resolving of secret references within the configuration.
+//
+// Rather then putting database passwords and the like directly in the configuration, where anything that can read
+// the configuration directory (or the debug logs) gets them, any string in any configuration can instead reference
+// where to find the secret:
+//
+//	${env:NAME}                The environment variable NAME.
+//	${file:/path/to/file}      The contents of a file, such as a Docker or systemd credential.
+//	${keyring:service/account} The OS keyring, secret-tool (Secret Service) on Linux or the keychain on macOS.
+//	${secret:name}             The entry name from the age encrypted secrets file.
+//
+// References can be anywhere within a string, so a DSN such as "postgres://frame:${secret:dbpass}@db/frame" works.
+//
+// The age encrypted secrets file is a YAML map of names to secrets. Its path is taken from the environment variable
+// FRAME_SECRETS and the identity to decrypt it with from FRAME_SECRETS_IDENTITY.
+//
+// Anything else that looks like a reference, such as "${other}", is left as is.
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables giving the age encrypted secrets file and the identity to decrypt it with.
+const (
+	EnvFile     = "FRAME_SECRETS"
+	EnvIdentity = "FRAME_SECRETS_IDENTITY"
+)
+
+var refRe = regexp.MustCompile(`\$\{(env|file|keyring|secret):([^}]+)\}`)
+
+// type secretsFile struct {{{
+
+// The decrypted secrets file, kept so it is not decrypted again for every reference.
+type secretsFile struct {
+	mut sync.Mutex
+
+	// What was decrypted, so we know to do it again if either changes.
+	path    string
+	modTime time.Time
+
+	values map[string]string
+}
+
+var sf secretsFile // }}}
+
+// func Resolve {{{
+
+// Returns s with every secret reference replaced with the secret.
+//
+// Errors never include the secret itself, only the reference that failed.
+func Resolve(s string) (string, error) {
+	var err error
+
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	out := refRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+
+		m := refRe.FindStringSubmatch(ref)
+
+		var val string
+
+		switch m[1] {
+		case "env":
+			var ok bool
+			if val, ok = os.LookupEnv(m[2]); !ok {
+				err = fmt.Errorf("%s: not set", ref)
+			}
+		case "file":
+			var b []byte
+			if b, err = ioutil.ReadFile(m[2]); err != nil {
+				err = fmt.Errorf("%s: %s", ref, err)
+			}
+			val = strings.TrimRight(string(b), "\r\n")
+		case "keyring":
+			if val, err = keyring(m[2]); err != nil {
+				err = fmt.Errorf("%s: %s", ref, err)
+			}
+		case "secret":
+			if val, err = sf.get(m[2]); err != nil {
+				err = fmt.Errorf("%s: %s", ref, err)
+			}
+		}
+
+		return val
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return out, nil
+} // }}}
+
+// func ResolveStruct {{{
+
+// Resolves every string within v, which must be a pointer.
+//
+// Follows structs, pointers, slices, arrays and maps, though only exported struct fields.
+func ResolveStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return errors.New("not a pointer")
+	}
+
+	return resolveValue(rv)
+} // }}}
+
+// func resolveValue {{{
+
+func resolveValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return resolveValue(v.Elem())
+	case reflect.Struct:
+		vt := v.Type()
+
+		for i := 0; i < v.NumField(); i++ {
+			// Unexported, we can't set it anyway.
+			if vt.Field(i).PkgPath != "" {
+				continue
+			}
+
+			if err := resolveValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// Map values can not be set in place, so resolve a copy and put it back.
+			mv := reflect.New(iter.Value().Type()).Elem()
+			mv.Set(iter.Value())
+
+			if err := resolveValue(mv); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(iter.Key(), mv)
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+
+		s, err := Resolve(v.String())
+		if err != nil {
+			return err
+		}
+
+		v.SetString(s)
+	}
+
+	return nil
+} // }}}
+
+// func secretsFile.get {{{
+
+// Returns the named secret, decrypting the secrets file if needed.
+func (sf *secretsFile) get(name string) (string, error) {
+	path := os.Getenv(EnvFile)
+	if path == "" {
+		return "", errors.New(EnvFile + " not set")
+	}
+
+	sf.mut.Lock()
+	defer sf.mut.Unlock()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if sf.values == nil || sf.path != path || !sf.modTime.Equal(fi.ModTime()) {
+		values, err := decryptFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		sf.path = path
+		sf.modTime = fi.ModTime()
+		sf.values = values
+	}
+
+	val, ok := sf.values[name]
+	if !ok {
+		return "", errors.New("not in secrets file")
+	}
+
+	return val, nil
+} // }}}
+
+// func decryptFile {{{
+
+// Decrypts the age encrypted secrets file using the identity from EnvIdentity.
+func decryptFile(path string) (map[string]string, error) {
+	idPath := os.Getenv(EnvIdentity)
+	if idPath == "" {
+		return nil, errors.New(EnvIdentity + " not set")
+	}
+
+	idf, err := os.Open(idPath)
+	if err != nil {
+		return nil, err
+	}
+	defer idf.Close()
+
+	ids, err := age.ParseIdentities(idf)
+	if err != nil {
+		return nil, fmt.Errorf("identity: %s", err)
+	}
+
+	enc, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(enc), ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %s", err)
+	}
+
+	dec, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %s", err)
+	}
+
+	values := make(map[string]string)
+	if err := yaml.Unmarshal(dec, &values); err != nil {
+		// Not the error itself, yaml errors can quote the secrets.
+		return nil, errors.New("secrets file is not a YAML map of strings")
+	}
+
+	return values, nil
+} // }}}
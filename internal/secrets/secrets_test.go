@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	os.Setenv("FRAME_TEST_PASS", "hunter2")
+	os.Unsetenv("FRAME_TEST_MISSING")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pass")
+	if err := ioutil.WriteFile(file, []byte("fromfile\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in, out string
+		err     bool
+	}{
+		{"plain", "plain", false},
+		{"${env:FRAME_TEST_PASS}", "hunter2", false},
+		{"postgres://frame:${env:FRAME_TEST_PASS}@db/frame", "postgres://frame:hunter2@db/frame", false},
+		{"${file:" + file + "}", "fromfile", false},
+		{"${other} ${HOME", "${other} ${HOME", false},
+		{"${env:FRAME_TEST_MISSING}", "", true},
+		{"${keyring:noaccount}", "", true},
+	}
+
+	for _, tt := range tests {
+		out, err := Resolve(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("Resolve(%q) error %v", tt.in, err)
+			continue
+		}
+
+		if out != tt.out {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestResolveStruct(t *testing.T) {
+	os.Setenv("FRAME_TEST_PASS", "hunter2")
+
+	type inner struct {
+		DSN string
+	}
+
+	v := &struct {
+		Name    string
+		Inner   *inner
+		List    []string
+		Headers map[string]string
+		private string
+	}{
+		Name:    "${env:FRAME_TEST_PASS}",
+		Inner:   &inner{DSN: "a=${env:FRAME_TEST_PASS}"},
+		List:    []string{"${env:FRAME_TEST_PASS}"},
+		Headers: map[string]string{"auth": "Bearer ${env:FRAME_TEST_PASS}"},
+		private: "${env:FRAME_TEST_PASS}",
+	}
+
+	if err := ResolveStruct(v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "hunter2" || v.Inner.DSN != "a=hunter2" || v.List[0] != "hunter2" || v.Headers["auth"] != "Bearer hunter2" {
+		t.Errorf("not resolved: %+v %+v", v, v.Inner)
+	}
+
+	if v.private != "${env:FRAME_TEST_PASS}" {
+		t.Errorf("unexported field changed")
+	}
+}
@@ -3,21 +3,36 @@ package tagmanager
 import (
 	"context"
 	"errors"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/tags"
 	"frame/types"
 	"frame/yconf"
-	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/log/zerologadapter"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
-	"strings"
 	"sync"
 	"sync/atomic"
 )
 
 type conf struct {
-	Database string `yaml:"database"`
+	Database string `yaml:"database" log:"redact"`
+
+	// Optional, see tags.SetTransliterate().
+	Transliterate confTranslit `yaml:"transliterate"`
 }
 
+// type confTranslit struct {{{
+
+// Makes tags written differently by different tools (or people) the same tag, beyond the normalizing always done.
+//
+// StripAccents removes accents so "Café" and "cafe" are the same, Map replaces text such as "æ" with "ae".
+//
+// Tags already in the database are not changed, so set this before adding tags if you can.
+type confTranslit struct {
+	StripAccents bool              `yaml:"stripaccents"`
+	Map          map[string]string `yaml:"map"`
+} // }}}
+
 // type TagManager struct {{{
 
 type TagManager struct {
@@ -30,10 +45,8 @@ type TagManager struct {
 	// Only used when Name() is called, not otherwise populated by other functions such as Get().
 	ncache sync.Map
 
-	// Stores the *pgxpool.Pool
-	//
-	// We use an atomic because we want to be able to replace the connection while we are running.
-	db atomic.Value
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
 
 	cFile string
 
@@ -63,6 +76,8 @@ func New(confFile string, l *zerolog.Logger, ctx context.Context) (*TagManager,
 
 	fl := tm.l.With().Str("func", "New").Logger()
 
+	tm.db = pgdb.New(&tm.l, ctx)
+
 	// Load our configuration.
 	if err = tm.loadConf(); err != nil {
 		return nil, err
@@ -85,67 +100,14 @@ func New(confFile string, l *zerolog.Logger, ctx context.Context) (*TagManager,
 // func TagManager.dbConnect {{{
 
 func (tm *TagManager) dbConnect(uri string) error {
-	var err error
-	var db *pgxpool.Pool
-
-	poolConf, err := pgxpool.ParseConfig(uri)
-	if err != nil {
-		return err
-	}
-
-	// Set the log level properly.
-	cc := poolConf.ConnConfig
-	cc.LogLevel = pgx.LogLevelInfo
-	cc.Logger = zerologadapter.NewLogger(tm.l)
-
-	// So that each connection creates our prepared statements.
-	poolConf.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
-		if _, err := conn.Prepare(ctx, "GetID", "SELECT tags.get_tagid($1)"); err != nil {
-			return err
-		}
-
-		if _, err := conn.Prepare(ctx, "GetName", "SELECT name FROM tags.tags WHERE tid = $1"); err != nil {
-			return err
-		}
-
-		return nil
-	}
-
-	if db, err = pgxpool.ConnectConfig(tm.ctx, poolConf); err != nil {
-		return err
-	}
-
-	// Get the old DB (if it exists, first time it won't be set).
-	oldDB, ok := tm.db.Load().(*pgxpool.Pool)
-
-	// Set the new DB
-	tm.db.Store(db)
-
-	// Close the old DB if it was set, now that the new one has replaced it.
-	if ok {
-		// We do this in the background, as anyone who is using it will block the Close() from returning.
-		go oldDB.Close()
-	}
-
-	return nil
-} // }}}
-
-// func TagManager.getDB {{{
-
-// Returns the current database pool.
-//
-// Loads it from an atomic value so that it can be replaced while running without causing issues.
-func (tm *TagManager) getDB() (*pgxpool.Pool, error) {
-	fl := tm.l.With().Str("func", "getDB").Logger()
-
-	db, ok := tm.db.Load().(*pgxpool.Pool)
-	if !ok {
-		err := errors.New("Not a pool")
-		fl.Warn().Err(err).Send()
-		return nil, err
-	}
-
-	return db, nil
+	return tm.db.Connect(&pgdb.Config{
+		Database: uri,
+		Statements: []pgdb.Statement{
+			{Name: "GetID", Query: "SELECT tags.get_tagid($1)"},
+			{Name: "GetName", Query: "SELECT name FROM tags.tags WHERE tid = $1"},
+			{Name: "LookupID", Query: "SELECT COALESCE(parent, tid) FROM tags.tags WHERE name = $1"},
+		},
+	})
 } // }}}
 
 // func TagManager.loadConf {{{
@@ -164,7 +126,7 @@ func (tm *TagManager) loadConf() error {
 		return err
 	}
 
-	fl.Debug().Interface("conf", yc.Get()).Send()
+	fl.Debug().Interface("conf", redact.Conf(yc.Get())).Send()
 
 	// Get the loaded configuration
 	if co, ok := yc.Get().(*conf); ok {
@@ -177,6 +139,11 @@ func (tm *TagManager) loadConf() error {
 		return err
 	}
 
+	if err := tags.SetTransliterate(tm.co.Transliterate.StripAccents, tm.co.Transliterate.Map); err != nil {
+		fl.Err(err).Msg("SetTransliterate")
+		return err
+	}
+
 	return nil
 } // }}}
 
@@ -194,11 +161,7 @@ func (tm *TagManager) close() {
 
 	fl.Info().Msg("closed")
 
-	if db, err := tm.getDB(); err == nil {
-		if db != nil {
-			db.Close()
-		}
-	}
+	tm.db.Close()
 } // }}}
 
 // func TagManager.Name {{{
@@ -228,9 +191,9 @@ func (tm *TagManager) Name(in uint64) (string, error) {
 		}
 	}
 
-	db, err := tm.getDB()
+	db, err := tm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
 		return "", err
 	}
 
@@ -258,8 +221,9 @@ func (tm *TagManager) Get(in string) (uint64, error) {
 		return 0, types.ErrShutdown
 	}
 
-	in = strings.ToLower(in)
-	in = strings.TrimSpace(in)
+	raw := in
+
+	in = tags.Normalize(in)
 	if in == "" {
 		fl.Debug().Msg("empty")
 		return 0, errors.New("Empty tag")
@@ -274,10 +238,17 @@ func (tm *TagManager) Get(in string) (uint64, error) {
 		}
 	}
 
-	db, err := tm.getDB()
+	db, err := tm.db.Get()
 	if err != nil {
-		fl.Err(err).Msg("getDB")
+		fl.Err(err).Msg("db.Get")
+		return 0, err
+	}
+
+	if id, ok, err := tm.lookupLegacy(db, raw, in); err != nil {
 		return 0, err
+	} else if ok {
+		tm.cache.Store(in, id)
+		return id, nil
 	}
 
 	if err := db.QueryRow(tm.ctx, "GetID", in).Scan(&id); err != nil {
@@ -290,3 +261,92 @@ func (tm *TagManager) Get(in string) (uint64, error) {
 
 	return id, nil
 } // }}}
+
+// func TagManager.Lookup {{{
+
+// Same as Get(), except an unknown tag is never added and returns types.ErrNotFound instead.
+func (tm *TagManager) Lookup(in string) (uint64, error) {
+	var id uint64
+
+	fl := tm.l.With().Str("func", "Lookup").Logger()
+
+	if atomic.LoadUint32(&tm.closed) == 1 {
+		fl.Info().Msg("called after shutdown")
+		return 0, types.ErrShutdown
+	}
+
+	raw := in
+
+	in = tags.Normalize(in)
+	if in == "" {
+		fl.Debug().Msg("empty")
+		return 0, errors.New("Empty tag")
+	}
+
+	fl = fl.With().Str("key", in).Logger()
+
+	if tid, ok := tm.cache.Load(in); ok {
+		if nid, ok := tid.(uint64); ok {
+			fl.Debug().Str("cache", "hit").Uint64("id", nid).Send()
+			return nid, nil
+		}
+	}
+
+	db, err := tm.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return 0, err
+	}
+
+	if id, ok, err := tm.lookupLegacy(db, raw, in); err != nil {
+		return 0, err
+	} else if ok {
+		tm.cache.Store(in, id)
+		return id, nil
+	}
+
+	if err := db.QueryRow(tm.ctx, "LookupID", in).Scan(&id); err != nil {
+		if pgdb.Classify(err) == pgdb.ErrClassNoRows {
+			fl.Debug().Msg("not found")
+			return 0, types.ErrNotFound
+		}
+
+		fl.Err(err).Msg("LookupID")
+		return 0, err
+	}
+
+	fl.Debug().Str("cache", "miss").Uint64("id", id).Send()
+	tm.cache.Store(in, id)
+
+	return id, nil
+} // }}}
+
+// func TagManager.lookupLegacy {{{
+
+// Tags added before tags.Normalize() were stored simply lower cased, which for some names (such as "Straße") is not
+// the same as their normalized form. Rather then adding a second tag for them, the tag under the old form is used.
+//
+// Returns false if the old form is the same as in, or no tag exists under it.
+func (tm *TagManager) lookupLegacy(db *pgxpool.Pool, raw, in string) (uint64, bool, error) {
+	var id uint64
+
+	old := tags.Legacy(raw)
+	if old == in {
+		return 0, false, nil
+	}
+
+	fl := tm.l.With().Str("func", "lookupLegacy").Str("key", in).Str("legacy", old).Logger()
+
+	if err := db.QueryRow(tm.ctx, "LookupID", old).Scan(&id); err != nil {
+		if pgdb.Classify(err) == pgdb.ErrClassNoRows {
+			return 0, false, nil
+		}
+
+		fl.Err(err).Msg("LookupID")
+		return 0, false, err
+	}
+
+	fl.Info().Uint64("id", id).Msg("using tag stored under its old name")
+
+	return id, true, nil
+} // }}}
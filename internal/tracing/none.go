@@ -0,0 +1,26 @@
+//go:build notracing
+
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+// func Start {{{
+
+// Built with the "notracing" tag, so there is nothing to export with.
+//
+// Fails if co.Endpoint is set, rather then quietly not tracing what was asked for.
+func Start(co *Config, l *zerolog.Logger, ctx context.Context) error {
+	if co == nil || co.Endpoint == "" {
+		return nil
+	}
+
+	err := errors.New("built without tracing (notracing tag)")
+	l.Err(err).Str("mod", "tracing").Str("func", "Start").Send()
+
+	return err
+} // }}}
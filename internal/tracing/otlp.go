@@ -0,0 +1,90 @@
+//go:build !notracing
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// func Start {{{
+
+// Starts exporting the spans from every module as configured.
+//
+// Exporting stops once ctx is done, after a final attempt to flush any spans not yet sent.
+//
+// Does nothing if co.Endpoint is not set.
+func Start(co *Config, l *zerolog.Logger, ctx context.Context) error {
+	if co == nil || co.Endpoint == "" {
+		return nil
+	}
+
+	fl := l.With().Str("mod", "tracing").Str("func", "Start").Logger()
+
+	if co.SampleRatio < 0 || co.SampleRatio > 1 {
+		err := errors.New("sampleratio must be between 0 and 1")
+		fl.Err(err).Send()
+		return err
+	}
+
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(co.Endpoint),
+	}
+
+	if co.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	if len(co.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(co.Headers))
+	}
+
+	// The exporter connects in the background, so this does not fail just because the endpoint is down.
+	exp, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		fl.Err(err).Msg("otlptracegrpc.New")
+		return err
+	}
+
+	service := co.Service
+	if service == "" {
+		service = "frame"
+	}
+
+	ratio := co.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	go func() {
+		<-ctx.Done()
+
+		// ctx is already done, so give the flush its own.
+		sctx, can := context.WithTimeout(context.Background(), 5*time.Second)
+		defer can()
+
+		if err := tp.Shutdown(sctx); err != nil {
+			fl.Warn().Err(err).Msg("Shutdown")
+		}
+	}()
+
+	fl.Info().Str("endpoint", co.Endpoint).Str("service", service).Float64("ratio", ratio).Msg("tracing")
+
+	return nil
+} // }}}
@@ -0,0 +1,71 @@
+// Optional OpenTelemetry tracing.
+//
+// Each module creates spans around its slow parts (scans, merges, queries and renders) using Tracer(). Until Start()
+// is called those spans go nowhere and cost next to nothing, so tracing is entirely optional.
+//
+// Once started the spans are exported using OTLP over gRPC, to anything that accepts it such as the OpenTelemetry
+// Collector, Grafana Tempo or Jaeger.
+//
+// Building with the "notracing" tag leaves out the exporter and its gRPC client, which is most of the size, for
+// smaller targets. The spans are then always discarded.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// type Config struct {{{
+
+type Config struct {
+	// The OTLP gRPC endpoint to send the spans to, such as "tempo:4317".
+	//
+	// Tracing is disabled if not set.
+	Endpoint string `yaml:"endpoint"`
+
+	// Connect to the Endpoint without TLS.
+	Insecure bool `yaml:"insecure"`
+
+	// Any headers to send with the spans, such as for authentication.
+	Headers map[string]string `yaml:"headers" log:"redact"`
+
+	// The service name the spans are reported as.
+	//
+	// Defaults to "frame", set it if you run more then one.
+	Service string `yaml:"service"`
+
+	// Fraction of traces to keep, between 0 and 1.
+	//
+	// Defaults to 1, keeping everything. Our traces are few and far between so this rarely needs changing.
+	SampleRatio float64 `yaml:"sampleratio"`
+} // }}}
+
+// func Tracer {{{
+
+// Returns the Tracer for the module, such as "frame/internal/imgproc".
+//
+// Safe to call before Start(), spans are sent once it has been.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+} // }}}
+
+// func Fail {{{
+
+// Marks the span as failed with err, does nothing if err is nil.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+} // }}}
+
+// func End {{{
+
+// Ends the span, marking it failed if err is not nil.
+func End(span trace.Span, err error) {
+	Fail(span, err)
+	span.End()
+} // }}}
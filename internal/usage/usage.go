@@ -0,0 +1,267 @@
+// Keeps track of which images were actually shown, and when.
+//
+// Render reports each output it writes (see types.WeighterUsage), which the Weighter records here so it can favour
+// images not shown recently, and so the history can be looked at later.
+package usage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"frame/types"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ID to when it was last shown and how many times, see lastValue().
+	lastBucket = []byte("last")
+
+	// Every output shown, keyed by when and then a sequence, see showKey().
+	showBucket = []byte("shown")
+)
+
+// type Show struct {{{
+
+// A single output written, with the images within it.
+type Show struct {
+	Output string             `json:"output"`
+	At     time.Time          `json:"at"`
+	Images []types.ShownImage `json:"images"`
+} // }}}
+
+// type last struct {{{
+
+type last struct {
+	at    time.Time
+	count uint32
+} // }}}
+
+// type Store struct {{{
+
+type Store struct {
+	db *bolt.DB
+
+	// Everything in lastBucket, so lookups do not need the database. Need mut to access.
+	mut  sync.RWMutex
+	last map[uint64]last
+} // }}}
+
+// func Open {{{
+
+// Opens the usage database, creating it if needed.
+func Open(file string, mode os.FileMode) (*Store, error) {
+	if mode == 0 {
+		mode = 0644
+	}
+
+	// The timeout is so that a second copy of us using the same file does not hang forever waiting on the lock.
+	db, err := bolt.Open(file, mode, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Store{
+		db:   db,
+		last: make(map[uint64]last),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(showBucket); err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucketIfNotExists(lastBucket)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) == 8 && len(v) == 12 {
+				st.last[binary.BigEndian.Uint64(k)] = last{
+					at:    time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+					count: binary.BigEndian.Uint32(v[8:]),
+				}
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return st, nil
+} // }}}
+
+// func Store.Close {{{
+
+func (st *Store) Close() error {
+	return st.db.Close()
+} // }}}
+
+// func idKey {{{
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+} // }}}
+
+// func showKey {{{
+
+// Sorts by time, with the sequence so outputs shown at the same moment are both kept.
+func showKey(at time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+} // }}}
+
+// func lastValue {{{
+
+func lastValue(l last) []byte {
+	val := make([]byte, 12)
+	binary.BigEndian.PutUint64(val, uint64(l.at.UnixNano()))
+	binary.BigEndian.PutUint32(val[8:], l.count)
+	return val
+} // }}}
+
+// func Store.Record {{{
+
+// Records the images shown together in a single output.
+func (st *Store) Record(output string, images []types.ShownImage, at time.Time) error {
+	data, err := json.Marshal(&Show{Output: output, At: at, Images: images})
+	if err != nil {
+		return err
+	}
+
+	st.mut.Lock()
+	defer st.mut.Unlock()
+
+	// Only changed within the map once the database has them, so the two agree.
+	updated := make(map[uint64]last, len(images))
+
+	err = st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(showBucket)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(showKey(at, seq), data); err != nil {
+			return err
+		}
+
+		lb := tx.Bucket(lastBucket)
+
+		for _, img := range images {
+			l, ok := updated[img.ID]
+			if !ok {
+				l = st.last[img.ID]
+			}
+
+			l.count++
+			if at.After(l.at) {
+				l.at = at
+			}
+
+			if err := lb.Put(idKey(img.ID), lastValue(l)); err != nil {
+				return err
+			}
+
+			updated[img.ID] = l
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for id, l := range updated {
+		st.last[id] = l
+	}
+
+	return nil
+} // }}}
+
+// func Store.LastShown {{{
+
+// Returns when the image was last shown and how many times it has been, a zero time if it never has.
+func (st *Store) LastShown(id uint64) (time.Time, uint32) {
+	st.mut.RLock()
+	defer st.mut.RUnlock()
+
+	l := st.last[id]
+
+	return l.at, l.count
+} // }}}
+
+// func Store.Prune {{{
+
+// Removes every output shown before the time, returning how many were.
+//
+// When each image was last shown is kept, so images not shown in a long time are still known to have been.
+func (st *Store) Prune(before time.Time) (int, error) {
+	var removed int
+
+	end := showKey(before, 0)
+
+	err := st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(showBucket)
+
+		// Deleting while moving the cursor along can skip keys, so find them all first.
+		var keys [][]byte
+
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		removed = len(keys)
+
+		return nil
+	})
+
+	return removed, err
+} // }}}
+
+// func Store.Each {{{
+
+// Calls fn with every output shown from (inclusive) until to (exclusive), oldest first.
+//
+// Stops at the first error returned by fn, which is then returned.
+func (st *Store) Each(from, to time.Time, fn func(*Show) error) error {
+	start, end := showKey(from, 0), showKey(to, 0)
+
+	return st.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(showBucket).Cursor()
+
+		for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			show := &Show{}
+			if err := json.Unmarshal(v, show); err != nil {
+				return err
+			}
+
+			if err := fn(show); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+} // }}}
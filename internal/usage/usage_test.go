@@ -0,0 +1,71 @@
+package usage
+
+import (
+	"frame/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "usage.db")
+
+	st, err := Open(file, 0)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+
+	day1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	if err := st.Record("a.webp", []types.ShownImage{{ID: 1, Profile: "p"}, {ID: 2, Profile: "p"}}, day1); err != nil {
+		t.Fatalf("Record: %s", err)
+	}
+
+	if err := st.Record("a.webp", []types.ShownImage{{ID: 1, Profile: "p"}}, day2); err != nil {
+		t.Fatalf("Record: %s", err)
+	}
+
+	if at, count := st.LastShown(1); !at.Equal(day2) || count != 2 {
+		t.Fatalf("LastShown(1) Expected %s, 2 != Got %s, %d", day2, at, count)
+	}
+
+	if at, count := st.LastShown(3); !at.IsZero() || count != 0 {
+		t.Fatalf("LastShown(3) Expected never != Got %s, %d", at, count)
+	}
+
+	var outputs int
+
+	if err := st.Each(day1, day2.Add(time.Second), func(sh *Show) error {
+		outputs++
+		return nil
+	}); err != nil || outputs != 2 {
+		t.Fatalf("Each Expected 2 != Got %d, %v", outputs, err)
+	}
+
+	if removed, err := st.Prune(day2); err != nil || removed != 1 {
+		t.Fatalf("Prune Expected 1 != Got %d, %v", removed, err)
+	}
+
+	st.Close()
+
+	// When last shown survives both the prune and reopening.
+	if st, err = Open(file, 0); err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+
+	defer st.Close()
+
+	if at, count := st.LastShown(2); !at.Equal(day1) || count != 1 {
+		t.Fatalf("LastShown(2) Expected %s, 1 != Got %s, %d", day1, at, count)
+	}
+}
@@ -0,0 +1,2037 @@
+package weighter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frame/internal/pgdb"
+	"frame/internal/redact"
+	"frame/internal/rules"
+	"frame/internal/scheduler"
+	"frame/internal/tracing"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("frame/internal/weighter")
+
+// func yconfMerge {{{
+
+func yconfMerge(inAInt, inBInt interface{}) (interface{}, error) {
+	// Its important to note that previouisly loaded files are passed in a inA, where as inB is just the most recent.
+	// This means that for our various maps, inA will continue to grow as the number of files we process grow, but inB will always be just the
+	// most recent.
+	//
+	// So merge everything into inA.
+	inA, ok := inAInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	inB, ok := inBInt.(*conf)
+	if !ok {
+		return nil, errors.New("not a *conf")
+	}
+
+	if inA.Database != inB.Database && inB.Database != "" {
+		inA.Database = inB.Database
+	}
+
+	if inA.Queries.Full != inB.Queries.Full && inB.Queries.Full != "" {
+		inA.Queries.Full = inB.Queries.Full
+	}
+
+	if inA.Queries.Poll != inB.Queries.Poll && inB.Queries.Poll != "" {
+		inA.Queries.Poll = inB.Queries.Poll
+	}
+
+	if inA.Queries.PollHashes != inB.Queries.PollHashes && inB.Queries.PollHashes != "" {
+		inA.Queries.PollHashes = inB.Queries.PollHashes
+	}
+
+	if len(inB.TagRules) > 0 && !inA.TagRules.Equal(inB.TagRules) {
+		inA.TagRules = inA.TagRules.Combine(inB.TagRules)
+	}
+
+	if inA.PollInterval != inB.PollInterval && inB.PollInterval > 0 {
+		inA.PollInterval = inB.PollInterval
+	}
+
+	if inA.FullInterval != inB.FullInterval && inB.FullInterval > 0 {
+		inA.FullInterval = inB.FullInterval
+	}
+
+	if inA.InvalidExpire != inB.InvalidExpire && inB.InvalidExpire > 0 {
+		inA.InvalidExpire = inB.InvalidExpire
+	}
+
+	if inB.Usage != "" {
+		inA.Usage = inB.Usage
+	}
+
+	if inB.UsageKeep > 0 {
+		inA.UsageKeep = inB.UsageKeep
+	}
+
+	if inB.MaxImages > 0 {
+		inA.MaxImages = inB.MaxImages
+	}
+
+	// If A has no profiles but B does?
+	// Just copy them over as-is, easy enough.
+	if inA.Profiles == nil && inB.Profiles != nil {
+		inA.Profiles = inB.Profiles
+	} else if inA.Profiles != nil && inB.Profiles != nil {
+		// Copy the profiles, this one is a little more complex.
+		for kb, vb := range inB.Profiles {
+			va, ok := inA.Profiles[kb]
+			if !ok {
+				// Value does not exist in A, so just set it.
+				inA.Profiles[kb] = vb
+				continue
+			}
+
+			// Value exists in both A and B, so we need to combine the weights.
+			va.Weights = va.Weights.Combine(vb.Weights)
+			va.Matches.Combine(&vb.Matches)
+			va.Block = va.Block.Combine(vb.Block)
+
+			if vb.MinPool != 0 {
+				va.MinPool = vb.MinPool
+			}
+
+			if vb.Fallback != "" {
+				va.Fallback = vb.Fallback
+			}
+
+			if len(vb.Mix) > 0 {
+				va.Mix = vb.Mix
+			}
+
+			if vb.Expr != nil {
+				va.Expr = vb.Expr
+			}
+
+			if vb.Strategy != "" {
+				va.Strategy = vb.Strategy
+			}
+
+			if vb.Recency != nil {
+				va.Recency = vb.Recency
+			}
+		}
+	}
+
+	return inA, nil
+} // }}}
+
+// func yconfChanged {{{
+
+func yconfChanged(origConfInt, newConfInt interface{}) bool {
+	// None of these casts should be able to fail, but we like our sanity.
+	origConf, ok := origConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	newConf, ok := newConfInt.(*conf)
+	if !ok {
+		return true
+	}
+
+	if origConf.Database != newConf.Database {
+		return true
+	}
+
+	if origConf.Queries.Full != newConf.Queries.Full {
+		return true
+	}
+
+	if origConf.Queries.Poll != newConf.Queries.Poll || origConf.Queries.PollHashes != newConf.Queries.PollHashes {
+		return true
+	}
+
+	if !origConf.TagRules.Equal(newConf.TagRules) {
+		return true
+	}
+
+	if origConf.PollInterval != newConf.PollInterval {
+		return true
+	}
+
+	if origConf.FullInterval != newConf.FullInterval {
+		return true
+	}
+
+	if origConf.InvalidExpire != newConf.InvalidExpire {
+		return true
+	}
+
+	if origConf.Usage != newConf.Usage || origConf.UsageKeep != newConf.UsageKeep {
+		return true
+	}
+
+	if origConf.MaxImages != newConf.MaxImages {
+		return true
+	}
+
+	if len(origConf.Profiles) != len(newConf.Profiles) {
+		return true
+	}
+
+	for name, oProf := range origConf.Profiles {
+		nProf, ok := newConf.Profiles[name]
+		if !ok {
+			return true
+		}
+
+		if !oProf.Weights.Equal(nProf.Weights) {
+			return true
+		}
+
+		if !oProf.Matches.Equal(nProf.Matches) || !sameExpr(oProf.Expr, nProf.Expr) {
+			return true
+		}
+
+		if !oProf.Block.Equal(nProf.Block) {
+			return true
+		}
+
+		if oProf.MinPool != nProf.MinPool || oProf.Fallback != nProf.Fallback {
+			return true
+		}
+
+		if !sameMix(oProf.Mix, nProf.Mix) || oProf.Strategy != nProf.Strategy {
+			return true
+		}
+
+		if !sameRecency(oProf.Recency, nProf.Recency) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// func New {{{
+
+func New(confPath string, tm types.TagManager, l *zerolog.Logger, ctx context.Context) (*Weighter, error) {
+	var err error
+
+	we := &Weighter{
+		l:     l.With().Str("mod", "weighter").Logger(),
+		tm:    tm,
+		cPath: confPath,
+		ctx:   ctx,
+	}
+
+	// Create our empty cache.
+	we.ca = &cache{
+		images:   make(map[uint64]*cacheImage, 0),
+		profiles: make(map[string]*cacheProfile, 0),
+		invalid:  make(map[uint64]time.Time),
+		hidden:   make(map[uint64]time.Time),
+	}
+
+	fl := we.l.With().Str("func", "New").Logger()
+
+	we.db = pgdb.New(&we.l, ctx)
+
+	// Load our configuration.
+	//
+	// This also handles connecting to the database.
+	if err = we.loadConf(); err != nil {
+		return nil, err
+	}
+
+	if err = we.openUsage(we.getConf()); err != nil {
+		return nil, err
+	}
+
+	// Now run the initial doFull() and ensure things are OK.
+	if err := we.doFull(); err != nil {
+		return nil, err
+	}
+
+	// Start background processing to watch configuration for changes.
+	we.yc.Start()
+
+	// Start the regular database background polls and fulls.
+	co := we.getConf()
+
+	we.sch = scheduler.New(we.ctx)
+
+	if err := we.sch.Add("poll", co.PollInterval, we.tickPoll); err != nil {
+		we.close()
+		return nil, err
+	}
+
+	if err := we.sch.Add("full", co.FullInterval, we.tickFull); err != nil {
+		we.close()
+		return nil, err
+	}
+
+	// Close down once we are done.
+	go func() {
+		<-we.ctx.Done()
+		we.close()
+	}()
+
+	fl.Debug().Send()
+
+	return we, nil
+} // }}}
+
+// func wProfile.loadCP {{{
+
+func (wp *wProfile) loadCP() (*cacheProfile, error) {
+	fl := wp.we.l.With().Str("func", "loadCP").Logger()
+
+	// Attempt to load the existing cacheProfile
+	cp, ok := wp.cp.Load().(*cacheProfile)
+
+	// The one we have stored still good?
+	if ok && atomic.LoadUint32(&cp.closed) == 0 {
+		fl.Debug().Str("profile", cp.profile).Msg("loaded")
+		// Perfect, return away.
+		return cp, nil
+	}
+
+	// The one we have stored is invalid somehow, so lets get a new one.
+	//
+	// Get the cache
+	ca := wp.we.ca
+
+	// Get a lock on the cache
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	// Does the profile exist?
+	//
+	// We do not check if it is closed or not here since we have
+	// a read lock. It can not be closed while we have the lock.
+	if cp, ok := ca.profiles[cp.profile]; ok {
+		fl.Debug().Str("profile", cp.profile).Msg("found")
+
+		// Found a newer one, so replace our stored one.
+		wp.cp.Store(cp)
+		return cp, nil
+	}
+
+	// No valid one can be found.
+	// This can happen if a profile is valid and then the configuration
+	// changes, making the profile now invalid.
+	//
+	// Normal part of operations and should be handled.
+	//
+	// As a result, we do not log your typical error here.
+	err := errors.New("invalid profile")
+	fl.Debug().Err(err).Send()
+	return nil, err
+} // }}}
+
+// func wProfile.Get {{{
+
+func (wp *wProfile) Get(num uint8) ([]uint64, error) {
+	cp, err := wp.loadCP()
+	if err != nil {
+		return nil, err
+	}
+
+	// For sanity we cap the number at 100.
+	if num > 100 {
+		num = 100
+	}
+
+	// Too few images? Then use the fallback.
+	if cp.fallback != nil {
+		cp = cp.fallback
+	}
+
+	// Sanity - Handle empty profiles.
+	if cp.maxRoll == 0 {
+		return nil, errors.New("no images for tagprofile")
+	}
+
+	if len(cp.mix) > 0 {
+		return wp.we.getMixProfile(cp, num), nil
+	}
+
+	ids := wp.we.getRandomProfile(cp, num)
+	return ids, nil
+} // }}}
+
+// func wProfile.GetWithHashes {{{
+
+// Same as Get(), with the hash of each image when the queries return it.
+func (wp *wProfile) GetWithHashes(num uint8) ([]types.WeighterImage, error) {
+	ids, err := wp.Get(num)
+	if err != nil {
+		return nil, err
+	}
+
+	return wp.we.withHashes(ids), nil
+} // }}}
+
+// func Weighter.withHashes {{{
+
+// Looks up the hash of each ID, left empty for any we no longer have.
+func (we *Weighter) withHashes(ids []uint64) []types.WeighterImage {
+	ca := we.ca
+	imgs := make([]types.WeighterImage, len(ids))
+
+	ca.imgMut.RLock()
+	defer ca.imgMut.RUnlock()
+
+	for i, id := range ids {
+		imgs[i].ID = id
+
+		if ci, ok := ca.images[id]; ok {
+			imgs[i].Hash = ci.Hash
+		}
+	}
+
+	return imgs
+} // }}}
+
+// func Weighter.getRandomProfile {{{
+
+func (we *Weighter) getRandomProfile(cp *cacheProfile, num uint8) []uint64 {
+	fl := we.l.With().Str("func", "getRandomProfile").Str("profile", cp.profile).Uint8("num", num).Logger()
+
+	// Mutex for accessing our random number generator.
+	cp.rMut.Lock()
+	defer cp.rMut.Unlock()
+
+	fl.Debug().Int("maxRoll", cp.maxRoll).Send()
+
+	ids := cp.strategy.Select(cp, num)
+	we.applyRecency(cp, ids)
+
+	return ids
+} // }}}
+
+// func Weighter.getMixProfile {{{
+
+// Selects the part of a mix profile for each image, then the images from each part.
+func (we *Weighter) getMixProfile(cp *cacheProfile, num uint8) []uint64 {
+	counts := make([]uint8, len(cp.mix))
+
+	cp.rMut.Lock()
+
+	for i := uint8(0); i < num; i++ {
+		roll := cp.r.Intn(cp.maxRoll)
+
+		for j, part := range cp.mix {
+			// Parts without images are not part of maxRoll.
+			if part.cp.maxRoll == 0 {
+				continue
+			}
+
+			if roll < part.weight {
+				counts[j]++
+				break
+			}
+
+			roll -= part.weight
+		}
+	}
+
+	cp.rMut.Unlock()
+
+	ids := make([]uint64, 0, num)
+
+	for j, part := range cp.mix {
+		if counts[j] > 0 {
+			ids = append(ids, we.getRandomProfile(part.cp, counts[j])...)
+		}
+	}
+
+	// Mixed back up, otherwise the images of each part would always be together in the same order.
+	cp.rMut.Lock()
+	cp.r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	cp.rMut.Unlock()
+
+	return ids
+} // }}}
+
+// func Weighter.GetProfile {{{
+
+func (we *Weighter) GetProfile(pr string) (types.WeighterProfile, error) {
+	fl := we.l.With().Str("func", "GetProfile").Logger()
+
+	if pr == "" {
+		err := errors.New("invalid profile")
+		fl.Err(err)
+		return nil, err
+	}
+
+	ca := we.ca
+
+	// Get a lock on the cache
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	// Does the profile exist?
+	//
+	// We do not check if it is closed or not here since we have
+	// a read lock. It can not be closed while we have the lock.
+	if cp, ok := ca.profiles[pr]; ok {
+		fl.Debug().Str("profile", pr).Msg("found")
+		// Alright, here you go.
+		wp := &wProfile{
+			we: we,
+		}
+
+		// We use atomic.Value to make multiple goroutines a lot easier.
+		wp.cp.Store(cp)
+		return wp, nil
+	}
+
+	err := errors.New("profile not found")
+	fl.Err(err)
+	return nil, err
+} // }}}
+
+// func Weighter.Profiles {{{
+
+// See types.WeighterLister.
+func (we *Weighter) Profiles() []string {
+	ca := we.ca
+
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	names := make([]string, 0, len(ca.profiles))
+	for name := range ca.profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+} // }}}
+
+// func Weighter.ProfileCounts {{{
+
+// See types.WeighterLister.
+func (we *Weighter) ProfileCounts() map[string]int {
+	ca := we.ca
+
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	counts := make(map[string]int, len(ca.profiles))
+	for name, cp := range ca.profiles {
+		counts[name] = cp.count
+	}
+
+	return counts
+} // }}}
+
+// func Weighter.LowProfiles {{{
+
+// Returns the profiles that have fewer images then their configured minpool, along with how many images
+// they do have.
+func (we *Weighter) LowProfiles() map[string]int {
+	ca := we.ca
+
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	low := make(map[string]int, len(ca.low))
+	for name, count := range ca.low {
+		low[name] = count
+	}
+
+	return low
+} // }}}
+
+// func Weighter.Subscribe {{{
+
+// See types.WeighterSubscriber.
+func (we *Weighter) Subscribe() (<-chan types.WeighterDelta, func()) {
+	ch := make(chan types.WeighterDelta, 10)
+
+	we.subMut.Lock()
+	defer we.subMut.Unlock()
+
+	if we.subs == nil {
+		we.subs = make(map[uint64]chan types.WeighterDelta, 1)
+	}
+
+	we.subID++
+	id := we.subID
+	we.subs[id] = ch
+
+	unsub := func() {
+		we.subMut.Lock()
+		defer we.subMut.Unlock()
+
+		// Only close it once, no matter how many times this is called.
+		if _, ok := we.subs[id]; ok {
+			delete(we.subs, id)
+			close(ch)
+		}
+	}
+
+	return ch, unsub
+} // }}}
+
+// func Weighter.publish {{{
+
+// Sends the delta to all subscribers.
+//
+// Never blocks, any subscriber not keeping up misses the delta.
+func (we *Weighter) publish(delta types.WeighterDelta) {
+	fl := we.l.With().Str("func", "publish").Logger()
+
+	// Nothing changed, nothing to say.
+	if len(delta.Added) == 0 && len(delta.Removed) == 0 {
+		return
+	}
+
+	we.subMut.Lock()
+	defer we.subMut.Unlock()
+
+	for id, ch := range we.subs {
+		select {
+		case ch <- delta:
+		default:
+			fl.Warn().Uint64("sub", id).Msg("subscriber full, delta dropped")
+		}
+	}
+} // }}}
+
+// func Weighter.makeProfileWeights {{{
+
+func (we *Weighter) makeProfileWeights(ca *cache) error {
+	var weight int
+
+	fl := we.l.With().Str("func", "makeProfileWeights").Logger()
+
+	co := we.getConf()
+
+	// Basic sanity - No profiles, nothing we can actually do.
+	if len(co.Profiles) < 1 {
+		fl.Warn().Msg("No profiles")
+		return errors.New("No profiles")
+	}
+
+	// We need a temporary profile map to store the weights we are figuring out.
+	tpMap := make(map[string]map[int][]uint64, len(co.Profiles))
+
+	// Expression profiles and the profiles each uses, checked after the others for each image.
+	exprs := make(map[string][]string)
+
+	// Create each profiles temporary weights map
+	for pName, prof := range co.Profiles {
+		// Mix profiles are made from the others below.
+		if len(prof.Mix) > 0 {
+			continue
+		}
+
+		if prof.Expr != nil {
+			exprs[pName] = prof.Expr.names()
+		}
+
+		tpMap[pName] = make(map[int][]uint64, 100)
+	}
+
+	// The weight of the current image in each profile it is in, only kept if there are expression profiles.
+	imgWeights := make(map[string]int)
+	inProfile := func(name string) bool { return imgWeights[name] > 0 }
+
+	// The soonest a hidden image returns.
+	var unhide time.Time
+
+	now := time.Now()
+
+	// We tend to have far less profiles vs. images, so lets just iterate through
+	// the images only 1 time, checking each profile as we go through the images.
+	for id, ci := range ca.images {
+		// Hidden from every profile for now, see hiddenPrefix.
+		if until := we.hiddenUntil(ca, ci.Tags); until.After(now) {
+			if unhide.IsZero() || until.Before(unhide) {
+				unhide = until
+			}
+
+			continue
+		}
+
+		for pName := range imgWeights {
+			delete(imgWeights, pName)
+		}
+
+		for pName, prof := range co.Profiles {
+			if len(prof.Mix) > 0 || prof.Expr != nil {
+				continue
+			}
+
+			// Blocked from this profile no matter what else it matches.
+			if ci.Tags.Contains(prof.Block) {
+				continue
+			}
+
+			// If it doesn't match what the profile wants, skip it.
+			if !prof.Matches.Give(ci.Tags) {
+				continue
+			}
+
+			// Ok, matches - What weight will it be given?
+			weight = prof.Weights.GetWeight(ci.Tags)
+			if weight < 1 {
+				// A negative weight means skip it.
+				continue
+			}
+
+			// Ok, we have a positive weight, so go ahead and add this image to tpMap
+			tpMap[pName][weight] = append(tpMap[pName][weight], id)
+
+			if len(exprs) > 0 {
+				imgWeights[pName] = weight
+			}
+		}
+
+		// Now the expression profiles, from which of the others the image is in.
+		for pName, names := range exprs {
+			prof := co.Profiles[pName]
+
+			if ci.Tags.Contains(prof.Block) || !prof.Expr.eval(inProfile) {
+				continue
+			}
+
+			if len(prof.Weights) > 0 {
+				weight = prof.Weights.GetWeight(ci.Tags)
+			} else {
+				weight = exprWeight(names, imgWeights)
+			}
+
+			if weight < 1 {
+				continue
+			}
+
+			tpMap[pName][weight] = append(tpMap[pName][weight], id)
+		}
+	}
+
+	ca.unhide = unhide
+
+	we.lintTags(ca, co)
+
+	// Ok, so now we are setting the profiles in cache.
+	// We need the lock for this.
+	ca.pMut.Lock()
+	defer ca.pMut.Unlock()
+
+	// The existing profiles map, as we are going to just
+	// create a new one here, but we need to invalidate the old ones
+	// after the new ones are ready.
+	oldProfiles := ca.profiles
+
+	// Create the new profiles map.
+	ca.profiles = make(map[string]*cacheProfile, len(tpMap))
+
+	// Go through each profile with at least 1 image in tpMap and add it properly to the cache.
+	for pName, weightMap := range tpMap {
+		// Already checked by yconfConvert(), so can not fail.
+		strategy, _ := getStrategy(co.Profiles[pName].Strategy)
+
+		start := 0
+		ncp := &cacheProfile{
+			profile: pName,
+
+			strategy: strategy,
+			recency:  co.Profiles[pName].Recency,
+
+			// Used in getRandomProfile().
+			r: rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+
+		ncp.weights = make([]*weightList, 0, len(weightMap))
+
+		// Now run through the weights.
+		for weight, ids := range weightMap {
+			wl := &weightList{
+				Weight: weight,
+				Start:  start,
+				IDs:    ids,
+			}
+
+			ncp.weights = append(ncp.weights, wl)
+
+			// The starting weight for the next
+			start += weight
+
+			// Adjust the maximum weight to roll
+			ncp.maxRoll = start
+
+			ncp.count += len(ids)
+		}
+
+		// Cache the new profile.
+		ca.profiles[pName] = ncp
+	}
+
+	// Now that all the profiles exist, check that each has enough images.
+	low := make(map[string]int)
+
+	for pName, ncp := range ca.profiles {
+		prof := co.Profiles[pName]
+		if prof.MinPool == 0 || ncp.count >= prof.MinPool {
+			continue
+		}
+
+		low[pName] = ncp.count
+
+		// Make this stand out, odds are a typo in a tag somewhere.
+		fl.Error().Str("profile", pName).Int("images", ncp.count).Int("minpool", prof.MinPool).Str("fallback", prof.Fallback).Msg("profile below minpool")
+
+		if prof.Fallback != "" {
+			ncp.fallback = ca.profiles[prof.Fallback]
+		}
+	}
+
+	ca.low = low
+
+	// Now the mix profiles, from the others as they are with their fallbacks.
+	for pName, prof := range co.Profiles {
+		if len(prof.Mix) > 0 {
+			ca.profiles[pName] = makeMixProfile(pName, prof.Mix, ca.profiles)
+		}
+	}
+
+	// We have a lock on the profiles map, however any WeighterProfile
+	// we have given out via Weighter.Get() has a pointer to the individual
+	// cacheProfiles.
+	//
+	// We need to invalidate those, so they will lookup the new cacheProfile
+	// from the map we are updating here.
+	//
+	// Loop through the old ones here and invalidate all of them now that the
+	// new ones are all ready.
+	for _, oldProf := range oldProfiles {
+		atomic.StoreUint32(&oldProf.closed, 1)
+	}
+
+	fl.Debug().Send()
+
+	return nil
+} // }}}
+
+// func makeMixProfile {{{
+
+// Makes the cacheProfile for a mix profile from the others already made.
+func makeMixProfile(name string, mix map[string]int, profiles map[string]*cacheProfile) *cacheProfile {
+	ncp := &cacheProfile{
+		profile: name,
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		mix:     make([]mixPart, 0, len(mix)),
+	}
+
+	// Sorted so the same roll always picks the same part, easier to follow.
+	names := make([]string, 0, len(mix))
+	for part := range mix {
+		names = append(names, part)
+	}
+
+	sort.Strings(names)
+
+	for _, part := range names {
+		pcp, ok := profiles[part]
+		if !ok {
+			continue
+		}
+
+		// Take the fallback now, rather then each time.
+		if pcp.fallback != nil {
+			pcp = pcp.fallback
+		}
+
+		ncp.mix = append(ncp.mix, mixPart{cp: pcp, weight: mix[part]})
+
+		if pcp.maxRoll > 0 {
+			ncp.maxRoll += mix[part]
+			ncp.count += pcp.count
+		}
+	}
+
+	return ncp
+} // }}}
+
+// func sameMix {{{
+
+func sameMix(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, weight := range a {
+		if bw, ok := b[name]; !ok || bw != weight {
+			return false
+		}
+	}
+
+	return true
+} // }}}
+
+// func Weighter.makeWhitelist {{{
+
+// Makes Weighter.white from the given configuration, see makeWhitelist().
+func (we *Weighter) makeWhitelist(co *conf) {
+	fl := we.l.With().Str("func", "makeWhitelist").Logger()
+
+	wl := makeWhitelist(co)
+
+	fl.Debug().Int("tags", len(wl)).Send()
+
+	// And now we set the whitelist, replacing any previously existing one.
+	we.white.Store(wl)
+} // }}}
+
+// func makeWhitelist {{{
+
+// Returns a list of all tags that we care about for filtering out images that can never show up so can be
+// dropped from being tracked.
+//
+// That is every tag a profile weights or matches with its Any and All tags. The whitelist is checked after our
+// TagRules are applied so tags given by them already count, though the tags a rule wants are included as well
+// when the tag it gives is, so nothing depends on the order the two are done in.
+func makeWhitelist(co *conf) tags.Tags {
+	// A temporary map to handle duplicate issues for us.
+	tmap := make(map[uint64]bool, 1)
+
+	// Iterate the profiles.
+	for _, prof := range co.Profiles {
+		for _, tw := range prof.Weights {
+			tmap[tw.Tag] = true
+		}
+
+		for _, tag := range prof.Matches.Wants() {
+			tmap[tag] = true
+		}
+	}
+
+	// Rules can give tags that other rules want, so keep going until no more are added.
+	for added := true; added; {
+		added = false
+
+		for i := range co.TagRules {
+			tr := &co.TagRules[i]
+
+			if !tmap[tr.Tag] {
+				continue
+			}
+
+			for _, tag := range tr.Wants() {
+				if !tmap[tag] {
+					tmap[tag] = true
+					added = true
+				}
+			}
+		}
+	}
+
+	// We now have a unique list of all the tags we care about, so create the new tags.Tags for it.
+	//
+	// We make the capacity the length so we an just append and not worry about the index we are at.
+	tgs := make(tags.Tags, 0, len(tmap))
+
+	for k, _ := range tmap {
+		tgs = append(tgs, k)
+	}
+
+	// This handles sorting for us.
+	return tgs.Fix()
+} // }}}
+
+// func Weighter.Invalidate {{{
+
+// Drops the ID from every profile, for when its image can no longer be loaded.
+//
+// The ID is left out of the queries until InvalidExpire has passed, after which it comes back if still in the database.
+//
+// Implements types.WeighterInvalidator.
+func (we *Weighter) Invalidate(id uint64) {
+	fl := we.l.With().Str("func", "Invalidate").Uint64("id", id).Logger()
+
+	ca := we.ca
+
+	ca.imgMut.Lock()
+
+	// Already done? Render can easily hit the same image a few times before the profiles are rebuilt.
+	if _, ok := ca.invalid[id]; ok {
+		ca.imgMut.Unlock()
+		return
+	}
+
+	ca.invalid[id] = time.Now()
+
+	_, ok := ca.images[id]
+	delete(ca.images, id)
+
+	ca.imgMut.Unlock()
+
+	// Not something we had anyways.
+	if !ok {
+		return
+	}
+
+	fl.Warn().Msg("invalidated")
+
+	we.publish(types.WeighterDelta{Removed: []uint64{id}})
+
+	// Rebuild the profiles in the background so the caller is not held up.
+	//
+	// If a rebuild is already pending then it picks this up as well.
+	if atomic.AddUint32(&we.rebuild, 1) == 1 {
+		go we.rebuildProfiles()
+	}
+} // }}}
+
+// func Weighter.rebuildProfiles {{{
+
+// Rebuilds the profiles after Invalidate() or checkHidden(), until no more are pending.
+func (we *Weighter) rebuildProfiles() {
+	fl := we.l.With().Str("func", "rebuildProfiles").Logger()
+
+	ca := we.ca
+
+	for {
+		pending := atomic.LoadUint32(&we.rebuild)
+
+		ca.imgMut.Lock()
+		err := we.makeProfileWeights(ca)
+		ca.imgMut.Unlock()
+
+		if err != nil {
+			fl.Err(err).Msg("makeProfileWeights")
+		}
+
+		// Anything invalidated while we were running? Then go again.
+		if atomic.CompareAndSwapUint32(&we.rebuild, pending, 0) {
+			return
+		}
+	}
+} // }}}
+
+// func Weighter.isInvalid {{{
+
+// Returns true if the ID was invalidated within expire, clearing it once expired.
+//
+// Need the imgMut lock.
+func (we *Weighter) isInvalid(ca *cache, id uint64, expire time.Duration) bool {
+	when, ok := ca.invalid[id]
+	if !ok {
+		return false
+	}
+
+	if time.Since(when) < expire {
+		return true
+	}
+
+	delete(ca.invalid, id)
+
+	return false
+} // }}}
+
+// func Weighter.doFull {{{
+
+// This does a full query as well as regenerates all the profiles.
+//
+// This is done at startup, periodically if configured to do so, as well as in the event of changes to the profiles.
+func (we *Weighter) doFull() error {
+	delta := types.WeighterDelta{Full: true}
+
+	ctx, span := tracer.Start(we.ctx, "weighter.full")
+	defer span.End()
+
+	// Let any subscribers know what changed, only after we release the lock below.
+	defer func() { we.publish(delta) }()
+
+	// Get the cache
+	ca := we.ca
+
+	// We need a write lock on the images map.
+	//
+	// Note that the images map is only used by queries and when generating profiles, not when asking for profile matches.
+	// So its safe to aquire this lock without worry about us stalling the Weighter.
+	ca.imgMut.Lock()
+	defer ca.imgMut.Unlock()
+
+	// First is the full query.
+	_, qs := tracer.Start(ctx, "query")
+	err := we.fullQuery(ca, &delta)
+	tracing.End(qs, err)
+
+	if err != nil {
+		return err
+	}
+
+	// Now generate the profiles from all the images loaded.
+	_, ps := tracer.Start(ctx, "profiles", trace.WithAttributes(attribute.Int("images", len(ca.images))))
+	err = we.makeProfileWeights(ca)
+	tracing.End(ps, err)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+} // }}}
+
+// func Weighter.PollHashes {{{
+
+// Loads the hashes right away rather then waiting for the next poll, such as new files from the imgproc fast lane.
+//
+// Uses the poll-hashes query, if not set the regular poll is run instead.
+func (we *Weighter) PollHashes(ids []uint64) error {
+	if atomic.LoadUint32(&we.closed) != 0 {
+		return errors.New("weighter closed")
+	}
+
+	if we.getConf().Queries.PollHashes == "" {
+		return we.doPoll("poll")
+	}
+
+	return we.doPoll("poll-hashes", ids)
+} // }}}
+
+// func Weighter.doPoll {{{
+
+// Runs the named poll query with args, either "poll" or "poll-hashes" (see PollHashes()), and updates the profiles.
+func (we *Weighter) doPoll(name string, args ...interface{}) error {
+	var delta types.WeighterDelta
+
+	ctx, span := tracer.Start(we.ctx, "weighter.poll")
+	defer span.End()
+
+	// Let any subscribers know what changed, only after we release the lock below.
+	defer func() { we.publish(delta) }()
+
+	// Get the cache
+	ca := we.ca
+
+	// We need a write lock on the images map.
+	//
+	// Note that the images map is only used by queries and when generating profiles, not when asking for profile matches.
+	// So its safe to aquire this lock without worry about us stalling the Weighter.
+	ca.imgMut.Lock()
+	defer ca.imgMut.Unlock()
+
+	// First is the full query.
+	_, qs := tracer.Start(ctx, "query")
+	changed, err := we.pollQuery(ca, &delta, name, args...)
+	tracing.End(qs, err)
+
+	if err != nil {
+		return err
+	}
+
+	// Any actual changes? No changes, no updating profiles.
+	if changed {
+		// Now generate the profiles from all the images loaded.
+		_, ps := tracer.Start(ctx, "profiles", trace.WithAttributes(attribute.Int("images", len(ca.images))))
+		err = we.makeProfileWeights(ca)
+		tracing.End(ps, err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+} // }}}
+
+// func Weighter.pollQuery {{{
+
+func (we *Weighter) pollQuery(ca *cache, delta *types.WeighterDelta, name string, args ...interface{}) (bool, error) {
+	var id uint64
+	var enabled, changed bool
+	var tgs tags.Tags
+	var hash string
+
+	fl := we.l.With().Str("func", "pollQuery").Logger()
+
+	// Get the whitelist to filter out images we don't care about.
+	wl := we.getWhite()
+
+	co := we.getConf()
+	expire := co.InvalidExpire
+
+	db, err := we.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return changed, err
+	}
+
+	// The query should already be prepared at connection.
+	pollRows, err := db.Query(we.ctx, name, args...)
+	if err != nil {
+		fl.Err(err).Msg(name)
+		return changed, err
+	}
+
+	// The hash is optional, see confQueries.
+	dest := []interface{}{&id, &tgs, &enabled}
+	if len(pollRows.FieldDescriptions()) > len(dest) {
+		dest = append(dest, &hash)
+	}
+
+	for pollRows.Next() {
+		// SELECT hid, tags, enabled FROM files.merged WHERE updated >= NOW() - interval '5 minutes'
+		if err := pollRows.Scan(dest...); err != nil {
+			pollRows.Close()
+			fl.Err(err).Msg("poll-rows-scan")
+			return changed, err
+		}
+
+		// Don't assume the database doesn't have duplicates and is sorted properly.
+		tgs = tgs.Fix()
+
+		// Our own tag rules, before anything looks at the tags.
+		tgs = co.rules.Apply(tgs)
+
+		// Reported as unloadable? Then leave it out until it expires.
+		if we.isInvalid(ca, id, expire) {
+			continue
+		}
+
+		if pollRow(ca, wl, delta, id, tgs, enabled, hash) {
+			changed = true
+		}
+	}
+
+	pollRows.Close()
+
+	// New images can take us over MaxImages.
+	if co.MaxImages > 0 && len(ca.images) > co.MaxImages {
+		we.trimImages(ca, co, co.MaxImages, delta)
+	}
+
+	return changed, nil
+} // }}}
+
+// func pollRow {{{
+
+// Applies a single row of the poll query to the cache, adding the ID to delta if it was added or removed.
+//
+// Returns true if anything changed.
+func pollRow(ca *cache, wl tags.Tags, delta *types.WeighterDelta, id uint64, tgs tags.Tags, enabled bool, hash string) bool {
+	// This image already exist?
+	img, ok := ca.images[id]
+	if !ok {
+		// Nope - Is it enabled?
+		//
+		// New file that is already disabled? Go ahead and skip it.
+		if !enabled {
+			return false
+		}
+
+		// Does it pass the whitelist?
+		if !tgs.Contains(wl) {
+			return false
+		}
+
+		// First file for this ID, go ahead and create it.
+		ca.images[id] = &cacheImage{
+			ID:   id,
+			Hash: hash,
+			Tags: tgs,
+		}
+
+		delta.Added = append(delta.Added, id)
+		return true
+	}
+
+	// Should the file be removed?
+	if !enabled {
+		// Yep, so delete it and move on.
+		delete(ca.images, id)
+		delta.Removed = append(delta.Removed, id)
+		return true
+	}
+
+	// Only the full query might return it.
+	if hash != "" {
+		img.Hash = hash
+	}
+
+	// Tags change?
+	if !tgs.Equal(img.Tags) {
+		img.Tags = tgs
+		return true
+	}
+
+	return false
+} // }}}
+
+// func Weighter.fullQuery {{{
+
+func (we *Weighter) fullQuery(ca *cache, delta *types.WeighterDelta) error {
+	var first bool
+	var id, skipped uint64
+	var tgs tags.Tags
+	var hash string
+
+	fl := we.l.With().Str("func", "fullQuery").Logger()
+
+	// Get the whitelist to filter out images we don't care about.
+	wl := we.getWhite()
+
+	co := we.getConf()
+	expire := co.InvalidExpire
+
+	db, err := we.db.Get()
+	if err != nil {
+		fl.Err(err).Msg("db.Get")
+		return err
+	}
+
+	// Change seen
+	ca.seen += 1
+
+	// Is this the first run?
+	//
+	// Easy way to tell - there are no images.
+	if len(ca.images) == 0 {
+		first = true
+	}
+
+	// Only keeping the best images? See keepHeap.
+	var kh *keepHeap
+	if co.MaxImages > 0 {
+		kh = &keepHeap{limit: co.MaxImages}
+	}
+
+	// The query should already be prepared at connection.
+	fullRows, err := db.Query(we.ctx, "full")
+	if err != nil {
+		fl.Err(err).Msg("full")
+		return err
+	}
+
+	// The hash is optional, see confQueries.
+	dest := []interface{}{&id, &tgs}
+	if len(fullRows.FieldDescriptions()) > len(dest) {
+		dest = append(dest, &hash)
+	}
+
+	for fullRows.Next() {
+		// SELECT hid, tags FROM files.merged WHERE enabled AND NOT blocked
+		if err := fullRows.Scan(dest...); err != nil {
+			fullRows.Close()
+			fl.Err(err).Msg("full-rows-scan")
+			return err
+		}
+
+		// Don't assume the database doesn't have duplicates and is sorted properly.
+		tgs = tgs.Fix()
+
+		// Our own tag rules, before anything looks at the tags.
+		tgs = co.rules.Apply(tgs)
+
+		// Does this contain at least 1 tag that we care about?
+		if !tgs.Contains(wl) {
+			skipped++
+			// Nope, skip this image.
+			continue
+		}
+
+		// Reported as unloadable? Then leave it out until it expires.
+		if we.isInvalid(ca, id, expire) {
+			skipped++
+			continue
+		}
+
+		// Kept aside until every row is seen, replacing the images below.
+		if kh != nil {
+			kh.offer(keepImage{id: id, hash: hash, tags: tgs, weight: keepWeight(co, tgs)})
+			continue
+		}
+
+		// Does this image already exist?
+		img, ok := ca.images[id]
+		if !ok {
+			// Nope, first one - Go ahead and create it.
+			img = &cacheImage{
+				ID:   id,
+				Hash: hash,
+				Tags: tgs,
+				seen: ca.seen,
+			}
+
+			ca.images[id] = img
+			delta.Added = append(delta.Added, id)
+
+			// Image was new, added and marked as changed.
+			continue
+		}
+
+		// Update seen
+		img.seen = ca.seen
+
+		if hash != "" {
+			img.Hash = hash
+		}
+
+		// Tags change?
+		if !tgs.Equal(img.Tags) {
+			img.Tags = tgs
+		}
+	}
+
+	fullRows.Close()
+
+	// Clear out any expired invalidations no longer in the database, so they do not build up forever.
+	for id, when := range ca.invalid {
+		if time.Since(when) >= expire {
+			delete(ca.invalid, id)
+		}
+	}
+
+	if kh != nil {
+		we.keepImages(ca, kh, delta)
+		return nil
+	}
+
+	// If its the first run then no more work to do.
+	if first {
+		return nil
+	}
+
+	// Now iterate images and remove any unseen.
+	for _, img := range ca.images {
+		if img.seen == ca.seen {
+			continue
+		}
+
+		fl.Debug().Uint64("unseen", img.ID).Send()
+		delete(ca.images, img.ID)
+		delta.Removed = append(delta.Removed, img.ID)
+	}
+
+	fl.Debug().Send()
+
+	return nil
+} // }}}
+
+// func Weighter.loadConf {{{
+
+// This is called by New() to load the configuration the first time.
+func (we *Weighter) loadConf() error {
+	var err error
+
+	fl := we.l.With().Str("func", "loadConf").Logger()
+
+	// Copy the default ycCallers, we need to copy this so we can add our own notifications.
+	ycc := ycCallers
+
+	ycc.Notify = func() {
+		we.notifyConf()
+	}
+
+	ycc.Convert = func(in interface{}) (interface{}, error) {
+		return we.yconfConvert(in)
+	}
+
+	if we.yc, err = yconf.New(we.cPath, ycc, &we.l, we.ctx); err != nil {
+		fl.Err(err).Msg("yconf.New")
+		return err
+	}
+
+	// Run a simple once-through check, not the full Start() yet.
+	if err = we.yc.CheckConf(); err != nil {
+		fl.Err(err).Msg("yc.CheckConf")
+		return err
+	}
+
+	// Get the loaded configuration
+	co, ok := we.yc.Get().(*conf)
+	if !ok {
+		// This one should not really be possible, so this error needs to be sent.
+		err := errors.New("invalid config loaded")
+		fl.Err(err).Send()
+		return err
+	}
+
+	fl.Debug().Interface("conf", redact.Conf(co)).Send()
+
+	// Check the configuration sanity first.
+	if good, _ := we.checkConf(co, false); !good {
+		return errors.New("Invalid configuration")
+	}
+
+	// Yep, so go ahead and create a new connection and get it prepared to replace the existing one.
+	if err := we.dbConnect(co); err != nil {
+		fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
+		return err
+	}
+
+	// Looks good, go ahead and store it.
+	we.co.Store(co)
+
+	// Create the new Whitelist of tags.
+	we.makeWhitelist(co)
+
+	return nil
+} // }}}
+
+// func Weighter.notifyConf {{{
+
+func (we *Weighter) notifyConf() {
+	fl := we.l.With().Str("func", "notifyConf").Logger()
+
+	// Update our configuration.
+	co, ok := we.yc.Get().(*conf)
+	if !ok {
+		fl.Warn().Msg("Get failed")
+		return
+	}
+
+	// Check the new configuration before we do anything.
+	good, ucBits := we.checkConf(co, true)
+
+	if !good {
+		fl.Warn().Msg("Invalid configuration, continuing to run with previously loaded configuration")
+		return
+	}
+
+	// Database change at all?
+	//
+	// Even if only the queries change, we do a reconnect.
+	//
+	// Since all our queries are prepared at connection time, this any issues having to rebind them.
+	if ucBits&(ucDBConn|ucDBQuery) != 0 {
+		if err := we.dbConnect(co); err != nil {
+			fl.Err(err).Str("host", redact.Host(co.Database)).Msg("new dbConnect")
+			return
+		}
+	}
+
+	// The whitelist is based off the tags in the profiles and TagRules.
+	// So if any of them changed then we need to regenerate the whitelist.
+	//
+	// From the new configuration, as it is not stored until below.
+	if ucBits&(ucProfiles|ucTagRules) != 0 {
+		// Create the new Whitelist of tags.
+		we.makeWhitelist(co)
+	}
+
+	// Store the new configuration
+	we.co.Store(co)
+
+	// Did anything change that would cause a full to be needed?
+	//
+	// Note that we include changing any queries or reconnecting as needing a full.
+	//
+	// This has the side benefit of allowing us at runtime to connect to a new empty database and just carry
+	// on without issue.
+	//
+	// Obviously changing any of the TagRules or BlockTags would force another full, as skipping a full on these would
+	// mean only updated images would apply these new rules. The same for MaxImages, which images are kept depends on
+	// all of them.
+	if ucBits&(ucDBConn|ucDBQuery|ucTagRules|ucProfiles|ucMaxImg) != 0 {
+		// Something changed that should force a full
+		go we.doFull()
+	}
+
+	// Note - We did not check ucPollInt here, thats handled in tickPoll() and it will adjust on its next poll.
+	fl.Info().Msg("configuration updated")
+} // }}}
+
+// func Weighter.yconfConvert {{{
+
+func (we *Weighter) yconfConvert(inInt interface{}) (interface{}, error) {
+	var err error
+
+	fl := we.l.With().Str("func", "yconfConvert").Logger()
+	fl.Debug().Send()
+
+	in, ok := inInt.(*confYAML)
+	if !ok {
+		return nil, errors.New("not *confYAML")
+	}
+
+	fl.Debug().Interface("yaml", redact.Conf(in)).Send()
+
+	out := &conf{
+		// No conversion needed here.
+		Database: in.Database,
+	}
+
+	// We use the same structure between both, so just copy.
+	out.Queries = in.Queries
+
+	// TagRules
+	if len(in.TagRules) > 0 {
+		if out.TagRules, err = tags.ConfMakeTagRules(in.TagRules, we.tm); err != nil {
+			return nil, err
+		}
+	}
+
+	out.rules = rules.New(out.TagRules, 0)
+
+	// Make the Profiles map if we need it.
+	if len(in.Profiles) > 0 {
+		out.Profiles = make(map[string]*confProfile, len(in.Profiles))
+	}
+
+	// The profiles.
+	for name, cProf := range in.Profiles {
+		// A mix of other profiles, nothing to convert.
+		if len(cProf.Mix) > 0 {
+			if len(cProf.Any) > 0 || len(cProf.All) > 0 || len(cProf.None) > 0 || len(cProf.Block) > 0 || len(cProf.Weights) > 0 || cProf.MinPool != 0 || cProf.Fallback != "" || cProf.Strategy != "" || cProf.Recency != nil || cProf.Expr != "" {
+				return nil, fmt.Errorf("profile %s has a mix, so can not have anything else", name)
+			}
+
+			out.Profiles[name] = &confProfile{
+				Name: name,
+				Mix:  cProf.Mix,
+			}
+
+			continue
+		}
+
+		cp := &confProfile{
+			Name:     name,
+			MinPool:  cProf.MinPool,
+			Fallback: cProf.Fallback,
+			Strategy: cProf.Strategy,
+		}
+
+		if cProf.Expr != "" {
+			// Made from other profiles, so nothing of its own to match.
+			if len(cProf.Any) > 0 || len(cProf.All) > 0 || len(cProf.None) > 0 {
+				return nil, fmt.Errorf("profile %s has an expr, so can not have any, all or none", name)
+			}
+
+			if cp.Expr, err = parseExpr(cProf.Expr); err != nil {
+				return nil, fmt.Errorf("profile %s expr: %w", name, err)
+			}
+		} else {
+			// The Any, All and None we want to convert into a TagRule with the "Tag" given being the profile name.
+			// Note that we will never actually assign this tag, just used for matching.
+			ctr := tags.ConfTagRule{
+				// The name doesn't matter since we never use this to assign any tags, so we just call it "nat" (or Not A Tag).
+				// This way each profile doesn't end up being a new tag name in TagManager.
+				Tag:  "nat",
+				Any:  cProf.Any,
+				All:  cProf.All,
+				None: cProf.None,
+			}
+
+			if cp.Matches, err = tags.ConfMakeTagRule(&ctr, we.tm); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := getStrategy(cp.Strategy); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+
+		if cp.Recency, err = parseRecency(cProf.Recency); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+
+		if cp.Block, err = tags.StringsToTags(cProf.Block, we.tm); err != nil {
+			return nil, err
+		}
+
+		if len(cProf.Weights) > 0 {
+			cp.Weights, err = tags.ConfMakeTagWeights(cProf.Weights, we.tm)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Add the new confProfile to our Profiles.
+		out.Profiles[name] = cp
+	}
+
+	// The various intervals.
+	if in.PollInterval > 0 {
+		out.PollInterval = time.Duration(in.PollInterval)
+
+		// Some basic sanity, force at least 1 second.
+		if out.PollInterval < time.Second {
+			return nil, errors.New("PollInterval too short")
+		}
+	}
+
+	if in.FullInterval > 0 {
+		out.FullInterval = time.Duration(in.FullInterval)
+
+		// Some basic sanity, force at least 1 minute.
+		if out.FullInterval < time.Minute {
+			return nil, errors.New("FullInterval too short")
+		}
+	}
+
+	out.InvalidExpire = time.Duration(in.InvalidExpire)
+	if out.InvalidExpire <= 0 {
+		out.InvalidExpire = time.Hour
+	}
+
+	out.Usage = in.Usage
+
+	out.UsageKeep = time.Duration(in.UsageKeep)
+	if out.UsageKeep <= 0 {
+		out.UsageKeep = 90 * 24 * time.Hour
+	}
+
+	if in.MaxImages < 0 {
+		return nil, errors.New("maximages can not be negative")
+	}
+
+	out.MaxImages = in.MaxImages
+
+	return out, nil
+} // }}}
+
+// func Weighter.checkConf {{{
+
+func (we *Weighter) checkConf(co *conf, reload bool) (bool, uint64) {
+	var ucBits uint64
+
+	fl := we.l.With().Str("func", "checkConf").Bool("reload", reload).Logger()
+
+	if co.Database == "" {
+		fl.Warn().Msg("Missing database")
+		return false, 0
+	}
+
+	if co.Queries.Full == "" {
+		fl.Warn().Msg("Missing queries.Full")
+		return false, 0
+	}
+
+	if co.Queries.Poll == "" {
+		fl.Warn().Msg("Missing queries.Poll")
+		return false, 0
+	}
+
+	if co.PollInterval < time.Second {
+		fl.Warn().Msg("PollInterval missing or too short")
+		return false, 0
+	}
+
+	if co.FullInterval < time.Second {
+		fl.Warn().Msg("FullInterval missing or too short")
+		return false, 0
+	}
+
+	if len(co.Profiles) < 1 {
+		fl.Warn().Msg("Need at least 1 profile")
+		return false, 0
+	}
+
+	for name, prof := range co.Profiles {
+		if len(prof.Mix) > 0 {
+			if !checkMix(&fl, name, prof.Mix, co.Profiles) {
+				return false, 0
+			}
+
+			continue
+		}
+
+		if prof.Expr != nil {
+			if !checkExpr(&fl, name, prof.Expr, co.Profiles) {
+				return false, 0
+			}
+		} else if len(prof.Weights) < 1 {
+			fl.Warn().Msg("Profile needs at least 1 weight")
+			return false, 0
+		}
+
+		if prof.MinPool < 0 {
+			fl.Warn().Str("profile", name).Msg("Invalid minpool")
+			return false, 0
+		}
+
+		if prof.Recency != nil && co.Usage == "" {
+			fl.Warn().Str("profile", name).Msg("Recency needs usage")
+			return false, 0
+		}
+
+		if prof.Fallback == "" {
+			continue
+		}
+
+		if prof.Fallback == name {
+			fl.Warn().Str("profile", name).Msg("Profile can not fallback to itself")
+			return false, 0
+		}
+
+		fb, ok := co.Profiles[prof.Fallback]
+		if !ok {
+			fl.Warn().Str("profile", name).Str("fallback", prof.Fallback).Msg("Fallback profile does not exist")
+			return false, 0
+		}
+
+		if len(fb.Mix) > 0 {
+			fl.Warn().Str("profile", name).Str("fallback", prof.Fallback).Msg("Fallback can not be a mix profile")
+			return false, 0
+		}
+	}
+
+	// If this isn't a reload, then nothing further to do.
+	if !reload {
+		// Basically everything changed.
+		return true, ucDBConn | ucDBQuery | ucTagRules | ucProfiles | ucPollInt | ucFullInt | ucMaxImg
+	}
+
+	// Get the old configuration to compare against and figure out what changed.
+	oldco := we.getConf()
+
+	if co.Database != oldco.Database {
+		ucBits |= ucDBConn
+	}
+
+	if co.Queries.Full != oldco.Queries.Full {
+		ucBits |= ucDBQuery
+	}
+
+	if co.Queries.Poll != oldco.Queries.Poll || co.Queries.PollHashes != oldco.Queries.PollHashes {
+		ucBits |= ucDBQuery
+	}
+
+	if !co.TagRules.Equal(oldco.TagRules) {
+		ucBits |= ucTagRules
+	}
+
+	if co.PollInterval != oldco.PollInterval {
+		ucBits |= ucPollInt
+	}
+
+	if co.FullInterval != oldco.FullInterval {
+		ucBits |= ucFullInt
+	}
+
+	if co.MaxImages != oldco.MaxImages {
+		ucBits |= ucMaxImg
+	}
+
+	// Profile bits, these are a bit more involved but not horribly complex.
+	if len(co.Profiles) != len(oldco.Profiles) {
+		// Simple - The two have a different number of profiles.
+		ucBits |= ucProfiles
+	} else {
+		// Same number of profiles, so run through each and see if there is a difference.
+		for name, oProf := range co.Profiles {
+			nProf, ok := oldco.Profiles[name]
+			if !ok {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if !oProf.Weights.Equal(nProf.Weights) {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if !oProf.Matches.Equal(nProf.Matches) || !sameExpr(oProf.Expr, nProf.Expr) {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if !oProf.Block.Equal(nProf.Block) {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if oProf.MinPool != nProf.MinPool || oProf.Fallback != nProf.Fallback {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if !sameMix(oProf.Mix, nProf.Mix) || oProf.Strategy != nProf.Strategy {
+				ucBits |= ucProfiles
+				break
+			}
+
+			if !sameRecency(oProf.Recency, nProf.Recency) {
+				ucBits |= ucProfiles
+				break
+			}
+		}
+	}
+
+	return true, ucBits
+} // }}}
+
+// func checkMix {{{
+
+// Checks the parts of a mix profile exist, are not mixes themselves and have a positive weight.
+func checkMix(fl *zerolog.Logger, name string, mix map[string]int, profiles map[string]*confProfile) bool {
+	for part, weight := range mix {
+		prof, ok := profiles[part]
+		if !ok {
+			fl.Warn().Str("profile", name).Str("part", part).Msg("Mix profile does not exist")
+			return false
+		}
+
+		if len(prof.Mix) > 0 {
+			fl.Warn().Str("profile", name).Str("part", part).Msg("Mix can not include another mix")
+			return false
+		}
+
+		if weight < 1 {
+			fl.Warn().Str("profile", name).Str("part", part).Int("weight", weight).Msg("Mix weight must be at least 1")
+			return false
+		}
+	}
+
+	return true
+} // }}}
+
+// func checkExpr {{{
+
+// Checks the profiles used by an expression profile exist and are neither mixes nor expressions themselves.
+func checkExpr(fl *zerolog.Logger, name string, pe *profileExpr, profiles map[string]*confProfile) bool {
+	for _, part := range pe.names() {
+		prof, ok := profiles[part]
+		if !ok {
+			fl.Warn().Str("profile", name).Str("part", part).Msg("Expr profile does not exist")
+			return false
+		}
+
+		if len(prof.Mix) > 0 || prof.Expr != nil {
+			fl.Warn().Str("profile", name).Str("part", part).Msg("Expr can only use profiles matching images themselves")
+			return false
+		}
+	}
+
+	return true
+} // }}}
+
+// func Weighter.dbConnect {{{
+
+func (we *Weighter) dbConnect(co *conf) error {
+	qu := &co.Queries
+
+	return we.db.Connect(&pgdb.Config{
+		Database: co.Database,
+		Statements: []pgdb.Statement{
+			{Name: "full", Query: qu.Full},
+			{Name: "poll", Query: qu.Poll},
+			{Name: "poll-hashes", Query: qu.PollHashes},
+		},
+	})
+} // }}}
+
+// func Weighter.getConf {{{
+
+func (we *Weighter) getConf() *conf {
+	fl := we.l.With().Str("func", "getConf").Logger()
+
+	if co, ok := we.co.Load().(*conf); ok {
+		return co
+	}
+
+	// This should really never be able to happen.
+	//
+	// If this does, then there is a deeper issue.
+	fl.Warn().Msg("Missing conf?")
+	return &conf{}
+} // }}}
+
+// func Weighter.getWhite {{{
+
+func (we *Weighter) getWhite() tags.Tags {
+	fl := we.l.With().Str("func", "getWhite").Logger()
+
+	if wl, ok := we.white.Load().(tags.Tags); ok {
+		return wl
+	}
+
+	// This should really never be able to happen.
+	//
+	// If this does, then there is a deeper issue.
+	fl.Warn().Msg("Missing whitelist?")
+	return tags.Tags{}
+} // }}}
+
+// func Weighter.tickPoll {{{
+
+// Run by the scheduler to do the regular poll.
+//
+// On errors we back off on how frequently we poll, for the sanity of those hopefully trying to fix the problem.
+func (we *Weighter) tickPoll() {
+	fl := we.l.With().Str("func", "tickPoll").Logger()
+
+	if err := we.doPoll("poll"); err != nil {
+		fl.Err(err).Msg("doPoll")
+		we.pollErrs++
+	} else {
+		// No error, so reset any possible error count.
+		we.pollErrs = 0
+	}
+
+	// Any hidden images due to return?
+	we.checkHidden()
+
+	// Handles both the PollInterval changing and the backoff.
+	//
+	// Does nothing if the interval is the same.
+	co := we.getConf()
+	if err := we.sch.Reschedule("poll", co.PollInterval*time.Duration(we.pollErrs+1)); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+} // }}}
+
+// func Weighter.tickFull {{{
+
+// Run by the scheduler to do the regular full.
+func (we *Weighter) tickFull() {
+	fl := we.l.With().Str("func", "tickFull").Logger()
+
+	if err := we.doFull(); err != nil {
+		fl.Err(err).Msg("doFull")
+	}
+
+	we.pruneUsage()
+
+	co := we.getConf()
+	if err := we.sch.Reschedule("full", co.FullInterval); err != nil {
+		fl.Err(err).Msg("Reschedule")
+	}
+} // }}}
+
+// func Weighter.close {{{
+
+// Stops all background processing and disconnects from the database.
+func (we *Weighter) close() {
+	fl := we.l.With().Str("func", "close").Logger()
+
+	// Set closed
+	if !atomic.CompareAndSwapUint32(&we.closed, 0, 1) {
+		fl.Info().Msg("already closed")
+		return
+	}
+
+	we.db.Close()
+
+	if we.usage != nil {
+		we.usage.Close()
+	}
+
+	// Close all subscribers, nothing more is coming.
+	we.subMut.Lock()
+	for id, ch := range we.subs {
+		delete(we.subs, id)
+		close(ch)
+	}
+	we.subMut.Unlock()
+
+	fl.Info().Msg("closed")
+} // }}}
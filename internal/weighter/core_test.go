@@ -0,0 +1,163 @@
+package weighter
+
+import (
+	"frame/tags"
+	"math/rand"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMakeWhitelist(t *testing.T) {
+	// Profile matches beach (10) or all of sunset (11), weighting only family (20).
+	matches, err := tags.MakeTagRule(0, tags.Tags{10}, tags.Tags{11}, tags.Tags{12})
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	// Rules give the weighted tag 30 from 40, and 40 from 50.
+	give30, err := tags.MakeTagRule(30, tags.Tags{40}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	give40, err := tags.MakeTagRule(40, tags.Tags{50}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	// A rule giving a tag nothing cares about, so its input is not wanted either.
+	give60, err := tags.MakeTagRule(60, tags.Tags{70}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	co := &conf{
+		TagRules: tags.TagRules{give40, give30, give60},
+		Profiles: map[string]*confProfile{
+			"a": &confProfile{
+				Matches: matches,
+				Weights: tags.TagWeights{{Tag: 20, Weight: 5}, {Tag: 30, Weight: 2}},
+			},
+		},
+	}
+
+	wl := makeWhitelist(co)
+
+	tests := []struct {
+		Tags     tags.Tags
+		Expected bool
+	}{
+		// Weighted tags, as before.
+		{tags.Tags{20}, true},
+		{tags.Tags{30}, true},
+
+		// Any and All tags, previously dropped.
+		{tags.Tags{10}, true},
+		{tags.Tags{11}, true},
+
+		// The inputs of rules giving a wanted tag, also previously dropped.
+		{tags.Tags{40}, true},
+		{tags.Tags{50}, true},
+
+		// None tags and unrelated rules are not wanted.
+		{tags.Tags{12}, false},
+		{tags.Tags{60}, false},
+		{tags.Tags{70}, false},
+		{tags.Tags{99}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.Tags.Contains(wl); got != test.Expected {
+			t.Fatalf("%v in whitelist %v Expected %v != Got %v", test.Tags, wl, test.Expected, got)
+		}
+	}
+}
+
+func TestMixProfile(t *testing.T) {
+	newCP := func(name string, ids ...uint64) *cacheProfile {
+		cp := &cacheProfile{
+			profile:  name,
+			strategy: weightedStrategy{},
+			r:        rand.New(rand.NewSource(1)),
+		}
+
+		if len(ids) > 0 {
+			cp.weights = []*weightList{{Weight: 1, IDs: ids}}
+			cp.maxRoll = 1
+			cp.count = len(ids)
+		}
+
+		return cp
+	}
+
+	profiles := map[string]*cacheProfile{
+		"family":     newCP("family", 1, 2, 3),
+		"landscapes": newCP("landscapes", 10, 11),
+		"empty":      newCP("empty"),
+		"low":        newCP("low", 20),
+	}
+
+	// Low uses its fallback.
+	profiles["low"].fallback = profiles["landscapes"]
+
+	mcp := makeMixProfile("mix", map[string]int{"family": 70, "landscapes": 30, "empty": 50}, profiles)
+
+	// Empty is left out of the roll.
+	if mcp.maxRoll != 100 || mcp.count != 5 {
+		t.Fatalf("makeMixProfile maxRoll %d count %d, Expected 100 and 5", mcp.maxRoll, mcp.count)
+	}
+
+	we := &Weighter{l: zerolog.Nop()}
+
+	var family, landscapes int
+
+	for i := 0; i < 100; i++ {
+		ids := we.getMixProfile(mcp, 10)
+		if len(ids) != 10 {
+			t.Fatalf("getMixProfile Expected 10 != Got %d", len(ids))
+		}
+
+		for _, id := range ids {
+			switch {
+			case id < 10:
+				family++
+			case id < 20:
+				landscapes++
+			default:
+				t.Fatalf("getMixProfile returned %d, not from family or landscapes", id)
+			}
+		}
+	}
+
+	// 70/30 of 1000, give or take.
+	if family < 600 || family > 800 || family+landscapes != 1000 {
+		t.Fatalf("getMixProfile family %d landscapes %d, Expected about 700 and 300", family, landscapes)
+	}
+
+	lcp := makeMixProfile("mix", map[string]int{"low": 1}, profiles)
+	if ids := we.getMixProfile(lcp, 5); len(ids) != 5 || ids[0] < 10 || ids[0] > 11 {
+		t.Fatalf("getMixProfile did not use the fallback: %v", ids)
+	}
+}
+
+func TestWithHashes(t *testing.T) {
+	ca := &cache{images: map[uint64]*cacheImage{
+		1: &cacheImage{ID: 1, Hash: "aa"},
+		2: &cacheImage{ID: 2},
+	}}
+
+	we := &Weighter{l: zerolog.Nop(), ca: ca}
+
+	imgs := we.withHashes([]uint64{1, 2, 3})
+	if len(imgs) != 3 {
+		t.Fatalf("withHashes Expected 3 != Got %d", len(imgs))
+	}
+
+	// 2 has no hash from the queries, and 3 is no longer loaded.
+	for i, hash := range []string{"aa", "", ""} {
+		if imgs[i].ID != uint64(i+1) || imgs[i].Hash != hash {
+			t.Fatalf("withHashes %d Expected %d %q != Got %d %q", i, i+1, hash, imgs[i].ID, imgs[i].Hash)
+		}
+	}
+}
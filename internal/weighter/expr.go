@@ -0,0 +1,291 @@
+package weighter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A profile can be made from others with an expression (see confProfileYAML.Expr), such as -
+//
+//	landscapes AND NOT kids
+//	(family OR friends) AND NOT screenshots
+//
+// NOT binds tightest, then AND, then OR. The keywords are not case sensitive, anything else is the name of a profile.
+
+type exprOp uint8
+
+const (
+	exprName exprOp = iota
+	exprNot
+	exprAnd
+	exprOr
+)
+
+// type profileExpr struct {{{
+
+type profileExpr struct {
+	op exprOp
+
+	// The profile, only for exprName.
+	name string
+
+	// One for exprNot, two for exprAnd and exprOr.
+	args []*profileExpr
+} // }}}
+
+// func parseExpr {{{
+
+// Parses a profile expression, see the top of expr.go.
+func parseExpr(in string) (*profileExpr, error) {
+	toks := exprTokens(in)
+	if len(toks) == 0 {
+		return nil, errors.New("empty expression")
+	}
+
+	ep := &exprParser{toks: toks}
+
+	pe, err := ep.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if ep.pos < len(toks) {
+		return nil, fmt.Errorf("unexpected %q", toks[ep.pos])
+	}
+
+	return pe, nil
+} // }}}
+
+// func exprTokens {{{
+
+// Splits the expression into parentheses and words.
+func exprTokens(in string) []string {
+	var toks []string
+
+	start := -1
+
+	for i, r := range in {
+		if r != '(' && r != ')' && !unicode.IsSpace(r) {
+			if start < 0 {
+				start = i
+			}
+
+			continue
+		}
+
+		if start >= 0 {
+			toks = append(toks, in[start:i])
+			start = -1
+		}
+
+		if r == '(' || r == ')' {
+			toks = append(toks, string(r))
+		}
+	}
+
+	if start >= 0 {
+		toks = append(toks, in[start:])
+	}
+
+	return toks
+} // }}}
+
+// type exprParser struct {{{
+
+type exprParser struct {
+	toks []string
+	pos  int
+} // }}}
+
+// func exprParser.peek {{{
+
+// Returns the next token, upper cased if a keyword, empty at the end.
+func (ep *exprParser) peek() string {
+	if ep.pos >= len(ep.toks) {
+		return ""
+	}
+
+	tok := ep.toks[ep.pos]
+
+	switch up := strings.ToUpper(tok); up {
+	case "AND", "OR", "NOT":
+		return up
+	}
+
+	return tok
+} // }}}
+
+// func exprParser.or {{{
+
+func (ep *exprParser) or() (*profileExpr, error) {
+	left, err := ep.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for ep.peek() == "OR" {
+		ep.pos++
+
+		right, err := ep.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &profileExpr{op: exprOr, args: []*profileExpr{left, right}}
+	}
+
+	return left, nil
+} // }}}
+
+// func exprParser.and {{{
+
+func (ep *exprParser) and() (*profileExpr, error) {
+	left, err := ep.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for ep.peek() == "AND" {
+		ep.pos++
+
+		right, err := ep.not()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &profileExpr{op: exprAnd, args: []*profileExpr{left, right}}
+	}
+
+	return left, nil
+} // }}}
+
+// func exprParser.not {{{
+
+func (ep *exprParser) not() (*profileExpr, error) {
+	tok := ep.peek()
+
+	switch tok {
+	case "":
+		return nil, errors.New("expression ends early")
+	case "NOT":
+		ep.pos++
+
+		arg, err := ep.not()
+		if err != nil {
+			return nil, err
+		}
+
+		return &profileExpr{op: exprNot, args: []*profileExpr{arg}}, nil
+	case "(":
+		ep.pos++
+
+		pe, err := ep.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if ep.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+
+		ep.pos++
+
+		return pe, nil
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+
+	ep.pos++
+
+	return &profileExpr{op: exprName, name: tok}, nil
+} // }}}
+
+// func profileExpr.eval {{{
+
+// Returns if an image is in the expression, in returning if it is in each profile.
+func (pe *profileExpr) eval(in func(string) bool) bool {
+	switch pe.op {
+	case exprNot:
+		return !pe.args[0].eval(in)
+	case exprAnd:
+		return pe.args[0].eval(in) && pe.args[1].eval(in)
+	case exprOr:
+		return pe.args[0].eval(in) || pe.args[1].eval(in)
+	}
+
+	return in(pe.name)
+} // }}}
+
+// func profileExpr.names {{{
+
+// Returns each profile the expression uses, in the order they are first used.
+func (pe *profileExpr) names() []string {
+	var out []string
+
+	seen := make(map[string]bool)
+
+	var walk func(*profileExpr)
+	walk = func(e *profileExpr) {
+		if e.op == exprName {
+			if !seen[e.name] {
+				seen[e.name] = true
+				out = append(out, e.name)
+			}
+
+			return
+		}
+
+		for _, arg := range e.args {
+			walk(arg)
+		}
+	}
+
+	walk(pe)
+
+	return out
+} // }}}
+
+// func profileExpr.String {{{
+
+// The expression with everything in parentheses, so the same expression written with different
+// spacing or case compares the same.
+func (pe *profileExpr) String() string {
+	switch pe.op {
+	case exprNot:
+		return "NOT " + pe.args[0].String()
+	case exprAnd:
+		return "(" + pe.args[0].String() + " AND " + pe.args[1].String() + ")"
+	case exprOr:
+		return "(" + pe.args[0].String() + " OR " + pe.args[1].String() + ")"
+	}
+
+	return pe.name
+} // }}}
+
+// func sameExpr {{{
+
+func sameExpr(a, b *profileExpr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.String() == b.String()
+} // }}}
+
+// func exprWeight {{{
+
+// Returns the weight an image gets in an expression profile without its own weights, the highest it has in any of
+// the profiles (names) the expression uses. An image only in the expression through a NOT (such as "NOT kids") gets 1.
+func exprWeight(names []string, weights map[string]int) int {
+	best := 1
+
+	for _, name := range names {
+		if w := weights[name]; w > best {
+			best = w
+		}
+	}
+
+	return best
+} // }}}
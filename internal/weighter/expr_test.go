@@ -0,0 +1,107 @@
+package weighter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"landscapes", "landscapes"},
+		{"landscapes AND NOT kids", "(landscapes AND NOT kids)"},
+		{"a or b and not c", "(a OR (b AND NOT c))"},
+		{"(a OR b)AND NOT(c)", "((a OR b) AND NOT c)"},
+		{"NOT NOT a", "NOT NOT a"},
+	}
+
+	for _, tt := range tests {
+		pe, err := parseExpr(tt.in)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %s", tt.in, err)
+			continue
+		}
+
+		if got := pe.String(); got != tt.want {
+			t.Errorf("parseExpr(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "a AND", "a b", "(a OR b", "a)", "AND a", "NOT"} {
+		if _, err := parseExpr(in); err == nil {
+			t.Errorf("parseExpr(%q) expected an error", in)
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	pe, err := parseExpr("(family OR friends) AND NOT kids AND NOT family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"family", "friends", "kids"}; !reflect.DeepEqual(pe.names(), want) {
+		t.Errorf("names = %v, want %v", pe.names(), want)
+	}
+
+	tests := []struct {
+		in   map[string]int
+		want bool
+	}{
+		{map[string]int{"friends": 3}, true},
+		{map[string]int{"friends": 3, "kids": 1}, false},
+		{map[string]int{"family": 2}, false},
+		{map[string]int{}, false},
+	}
+
+	for _, tt := range tests {
+		in := func(name string) bool { return tt.in[name] > 0 }
+
+		if got := pe.eval(in); got != tt.want {
+			t.Errorf("eval(%v) = %t, want %t", tt.in, got, tt.want)
+		}
+	}
+
+	names := []string{"landscapes", "kids"}
+
+	if got := exprWeight(names, map[string]int{"landscapes": 5, "beach": 9}); got != 5 {
+		t.Errorf("exprWeight = %d, want 5", got)
+	}
+
+	// Only there through a NOT.
+	if got := exprWeight(names, map[string]int{}); got != 1 {
+		t.Errorf("exprWeight = %d, want 1", got)
+	}
+}
+
+func TestCheckExpr(t *testing.T) {
+	l := zerolog.Nop()
+
+	mix, _ := parseExpr("a AND NOT mixed")
+	self, _ := parseExpr("a OR self")
+
+	profiles := map[string]*confProfile{
+		"a":     &confProfile{},
+		"b":     &confProfile{},
+		"mixed": &confProfile{Mix: map[string]int{"a": 1}},
+		"self":  &confProfile{Expr: self},
+	}
+
+	ok, _ := parseExpr("a AND NOT b")
+
+	if !checkExpr(&l, "ok", ok, profiles) {
+		t.Error("checkExpr a AND NOT b = false")
+	}
+
+	missing, _ := parseExpr("a AND NOT c")
+
+	for name, pe := range map[string]*profileExpr{"missing": missing, "mix": mix, "self": self} {
+		if checkExpr(&l, name, pe, profiles) {
+			t.Errorf("checkExpr %s = true", name)
+		}
+	}
+}
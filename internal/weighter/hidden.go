@@ -0,0 +1,95 @@
+package weighter
+
+import (
+	"frame/tags"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Images with a tag starting with this are left out of every profile until the time following it, such as
+// "hidden-until:2025-01-01" or "hidden-until:2025-01-01t18:00".
+//
+// Once passed the image returns on its own, no need to remove the tag.
+const hiddenPrefix = "hidden-until:"
+
+// The layouts the time of a hidden tag can be in, always in local time.
+//
+// Tag names are lower case, so the "T" of RFC3339 is as well.
+var hiddenLayouts = []string{
+	"2006-01-02",
+	"2006-01-02t15:04",
+	"2006-01-02t15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+}
+
+// func parseHidden {{{
+
+// Returns when the tag name stops hiding an image, zero if it is not a hidden tag or the time can not be parsed.
+func parseHidden(name string) time.Time {
+	if !strings.HasPrefix(name, hiddenPrefix) {
+		return time.Time{}
+	}
+
+	value := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, hiddenPrefix)))
+
+	for _, layout := range hiddenLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+} // }}}
+
+// func Weighter.hiddenUntil {{{
+
+// Returns when the tags stop hiding an image, the latest of any hidden tags within.
+//
+// Zero if none of the tags are hidden tags.
+//
+// The tag names are only looked up once and kept in cache.hidden, so need the imgMut lock.
+func (we *Weighter) hiddenUntil(ca *cache, tgs tags.Tags) time.Time {
+	var until time.Time
+
+	for _, tag := range tgs {
+		t, ok := ca.hidden[tag]
+		if !ok {
+			// Unknown names are not hidden tags, and not looked up again either.
+			if name, err := we.tm.Name(tag); err == nil {
+				t = parseHidden(name)
+			}
+
+			ca.hidden[tag] = t
+		}
+
+		if t.After(until) {
+			until = t
+		}
+	}
+
+	return until
+} // }}}
+
+// func Weighter.checkHidden {{{
+
+// Rebuilds the profiles in the background once the first hidden image is due to return, see hiddenPrefix.
+func (we *Weighter) checkHidden() {
+	ca := we.ca
+
+	ca.imgMut.RLock()
+	unhide := ca.unhide
+	ca.imgMut.RUnlock()
+
+	if unhide.IsZero() || time.Now().Before(unhide) {
+		return
+	}
+
+	we.l.Debug().Str("func", "checkHidden").Time("unhide", unhide).Msg("rebuilding")
+
+	// Same as Invalidate(), if a rebuild is already pending then it picks this up as well.
+	if atomic.AddUint32(&we.rebuild, 1) == 1 {
+		go we.rebuildProfiles()
+	}
+} // }}}
@@ -0,0 +1,29 @@
+package weighter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHidden(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Expected time.Time
+	}{
+		{"hidden-until:2025-01-01", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"hidden-until:2025-01-01t18:30", time.Date(2025, 1, 1, 18, 30, 0, 0, time.Local)},
+		{"hidden-until:2025-01-01 18:30:15", time.Date(2025, 1, 1, 18, 30, 15, 0, time.Local)},
+
+		// Not hidden tags, or not a time we understand.
+		{"beach", time.Time{}},
+		{"hidden-until:", time.Time{}},
+		{"hidden-until:tomorrow", time.Time{}},
+		{"until:2025-01-01", time.Time{}},
+	}
+
+	for _, test := range tests {
+		if got := parseHidden(test.Name); !got.Equal(test.Expected) {
+			t.Fatalf("parseHidden %q Expected %v != Got %v", test.Name, test.Expected, got)
+		}
+	}
+}
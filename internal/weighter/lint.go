@@ -0,0 +1,185 @@
+package weighter
+
+import (
+	"fmt"
+	"frame/tags"
+	"sort"
+	"strings"
+)
+
+// func tagRefs {{{
+
+// Returns where the profiles and TagRules refer to each tag.
+//
+// Mix profiles only refer to other profiles, so have nothing to check.
+func tagRefs(co *conf, name func(uint64) string) tags.TagRefs {
+	refs := make(tags.TagRefs)
+
+	for pName, prof := range co.Profiles {
+		if len(prof.Mix) > 0 {
+			continue
+		}
+
+		where := "profile " + pName
+
+		refs.Add(prof.Matches.Tags(), where)
+		refs.Add(prof.Block, where+" block")
+
+		wt := make(tags.Tags, 0, len(prof.Weights))
+		for _, tw := range prof.Weights {
+			wt = append(wt, tw.Tag)
+		}
+
+		refs.Add(wt, where+" weights")
+	}
+
+	for i := range co.TagRules {
+		tr := &co.TagRules[i]
+		refs.Add(tr.Tags(), "tagrule "+name(tr.Tag))
+	}
+
+	return refs
+} // }}}
+
+// func Weighter.lintTags {{{
+
+// Warns about every tag the configuration refers to that no image has, and profiles wanting tags that are blocked
+// (see SetBlockTags()). Once for each configuration and block tags.
+//
+// Images are only loaded with a tag some profile wants, so a None or Block tag only on images no profile wants is
+// reported as well. Those images could never be in the profile anyway.
+//
+// Needs the imgMut lock.
+func (we *Weighter) lintTags(ca *cache, co *conf) {
+	block := we.getBlockTags()
+
+	if (ca.linted == co && ca.lintBlock.Equal(block)) || len(ca.images) < 1 {
+		return
+	}
+
+	ca.linted, ca.lintBlock = co, block
+
+	fl := we.l.With().Str("func", "lintTags").Logger()
+
+	seen := make(map[uint64]bool, 100)
+	for _, ci := range ca.images {
+		for _, tag := range ci.Tags {
+			seen[tag] = true
+		}
+	}
+
+	has := func(tag uint64) bool { return seen[tag] }
+
+	warnings := tagRefs(co, we.tagName).Missing(has, we.tagName)
+	warnings = append(warnings, blockedWants(co, block, we.tagName)...)
+
+	for _, w := range warnings {
+		fl.Warn().Msg(w)
+	}
+
+	ca.pMut.Lock()
+	ca.warnings = warnings
+	ca.pMut.Unlock()
+} // }}}
+
+// func blockedWants {{{
+
+// Returns a warning for each profile wanting (Any or All) a tag that is blocked, sorted by profile.
+//
+// An All tag being blocked, or every Any tag, leaves the profile with nothing.
+func blockedWants(co *conf, block tags.Tags, name func(uint64) string) []string {
+	var out []string
+
+	if len(block) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(co.Profiles))
+	for pName := range co.Profiles {
+		names = append(names, pName)
+	}
+
+	sort.Strings(names)
+
+	for _, pName := range names {
+		prof := co.Profiles[pName]
+		if len(prof.Mix) > 0 {
+			continue
+		}
+
+		for _, tag := range prof.Matches.All() {
+			if block.Has(tag) {
+				out = append(out, fmt.Sprintf("profile %q needs tag %q which is blocked, so every image it matches is blocked", pName, name(tag)))
+			}
+		}
+
+		anyTags := prof.Matches.Any()
+
+		var blocked []string
+		for _, tag := range anyTags {
+			if block.Has(tag) {
+				blocked = append(blocked, name(tag))
+			}
+		}
+
+		switch {
+		case len(blocked) == 0:
+		case len(blocked) == len(anyTags):
+			out = append(out, fmt.Sprintf("profile %q only wants tags which are blocked (%s), so every image it matches is blocked", pName, strings.Join(blocked, ", ")))
+		default:
+			out = append(out, fmt.Sprintf("profile %q wants tags which are blocked (%s), images with them are never shown", pName, strings.Join(blocked, ", ")))
+		}
+	}
+
+	return out
+} // }}}
+
+// func Weighter.SetBlockTags {{{
+
+// See types.WeighterBlocker, checks the profiles against them right away.
+func (we *Weighter) SetBlockTags(fn func() tags.Tags) {
+	we.blockTags.Store(fn)
+
+	ca := we.ca
+
+	ca.imgMut.Lock()
+	defer ca.imgMut.Unlock()
+
+	we.lintTags(ca, we.getConf())
+} // }}}
+
+// func Weighter.getBlockTags {{{
+
+func (we *Weighter) getBlockTags() tags.Tags {
+	if fn, ok := we.blockTags.Load().(func() tags.Tags); ok && fn != nil {
+		return fn()
+	}
+
+	return nil
+} // }}}
+
+// func Weighter.TagWarnings {{{
+
+// Returns a warning for each tag the profiles or TagRules refer to that no image has (such as from a typo), and each
+// profile wanting tags that are blocked.
+//
+// Made the first time the profiles are made with each configuration.
+func (we *Weighter) TagWarnings() []string {
+	ca := we.ca
+
+	ca.pMut.RLock()
+	defer ca.pMut.RUnlock()
+
+	return append([]string(nil), ca.warnings...)
+} // }}}
+
+// func Weighter.tagName {{{
+
+// Returns the name of the tag, or the ID if that fails.
+func (we *Weighter) tagName(tag uint64) string {
+	if name, err := we.tm.Name(tag); err == nil {
+		return name
+	}
+
+	return fmt.Sprintf("#%d", tag)
+} // }}}
@@ -0,0 +1,111 @@
+package weighter
+
+import (
+	"fmt"
+	"frame/tags"
+	"testing"
+)
+
+func TestTagRefs(t *testing.T) {
+	matches, err := tags.MakeTagRule(0, tags.Tags{1, 2}, nil, tags.Tags{3})
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	give, err := tags.MakeTagRule(10, tags.Tags{1, 4}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	co := &conf{
+		TagRules: tags.TagRules{give},
+		Profiles: map[string]*confProfile{
+			"beach": &confProfile{
+				Matches: matches,
+				Block:   tags.Tags{5},
+				Weights: tags.TagWeights{{Tag: 2, Weight: 5}, {Tag: 6, Weight: 1}},
+			},
+			"mixed": &confProfile{Mix: map[string]int{"beach": 1}},
+		},
+	}
+
+	name := func(tag uint64) string { return fmt.Sprintf("t%d", tag) }
+
+	// Only 1 and 2 are on any image.
+	seen := map[uint64]bool{1: true, 2: true}
+
+	got := tagRefs(co, name).Missing(func(tag uint64) bool { return seen[tag] }, name)
+
+	expected := []string{
+		`tag "t3" is not on any image (profile beach)`,
+		`tag "t4" is not on any image (tagrule t10)`,
+		`tag "t5" is not on any image (profile beach block)`,
+		`tag "t6" is not on any image (profile beach weights)`,
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Missing Expected %q != Got %q", expected, got)
+	}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Missing %d Expected %q != Got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestBlockedWants(t *testing.T) {
+	allBlocked, err := tags.MakeTagRule(0, nil, tags.Tags{1, 2}, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	anyBlocked, err := tags.MakeTagRule(0, tags.Tags{1, 3}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	someBlocked, err := tags.MakeTagRule(0, tags.Tags{1, 4}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	// Only a None tag is blocked, which is fine.
+	noneBlocked, err := tags.MakeTagRule(0, tags.Tags{4}, nil, tags.Tags{1})
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	co := &conf{
+		Profiles: map[string]*confProfile{
+			"a": &confProfile{Matches: allBlocked},
+			"b": &confProfile{Matches: anyBlocked},
+			"c": &confProfile{Matches: someBlocked},
+			"d": &confProfile{Matches: noneBlocked},
+		},
+	}
+
+	name := func(tag uint64) string { return fmt.Sprintf("t%d", tag) }
+
+	got := blockedWants(co, tags.Tags{1, 3}, name)
+
+	expected := []string{
+		`profile "a" needs tag "t1" which is blocked, so every image it matches is blocked`,
+		`profile "b" only wants tags which are blocked (t1, t3), so every image it matches is blocked`,
+		`profile "c" wants tags which are blocked (t1), images with them are never shown`,
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("blockedWants Expected %q != Got %q", expected, got)
+	}
+
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("blockedWants %d Expected %q != Got %q", i, expected[i], got[i])
+		}
+	}
+
+	if got := blockedWants(co, nil, name); len(got) != 0 {
+		t.Fatalf("blockedWants nothing blocked Expected [] != Got %q", got)
+	}
+}
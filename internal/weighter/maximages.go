@@ -0,0 +1,223 @@
+package weighter
+
+import (
+	"container/heap"
+	"frame/tags"
+	"frame/types"
+)
+
+// With confYAML.MaxImages set only that many images are kept in memory, those with the highest weight in any profile.
+//
+// The rows of the full query are streamed from the database, so by keeping only the best seen so far (in keepHeap)
+// the merged table can be far larger then what fits in memory. Images left out are simply never selected, the same
+// as if their weight were too low.
+
+// type keepImage struct {{{
+
+type keepImage struct {
+	id     uint64
+	hash   string
+	tags   tags.Tags
+	weight int
+} // }}}
+
+// type keepHeap struct {{{
+
+// The images being kept, lowest weight (and then lowest ID, so older images go first) at the top so it is the first
+// one dropped.
+type keepHeap struct {
+	limit  int
+	images []keepImage
+
+	// How many were left out, and the highest weight of those.
+	dropped    int
+	dropWeight int
+} // }}}
+
+// func keepHeap.Len {{{
+
+func (kh *keepHeap) Len() int {
+	return len(kh.images)
+} // }}}
+
+// func keepHeap.Less {{{
+
+func (kh *keepHeap) Less(i, j int) bool {
+	a, b := kh.images[i], kh.images[j]
+
+	if a.weight != b.weight {
+		return a.weight < b.weight
+	}
+
+	return a.id < b.id
+} // }}}
+
+// func keepHeap.Swap {{{
+
+func (kh *keepHeap) Swap(i, j int) {
+	kh.images[i], kh.images[j] = kh.images[j], kh.images[i]
+} // }}}
+
+// func keepHeap.Push {{{
+
+func (kh *keepHeap) Push(x interface{}) {
+	kh.images = append(kh.images, x.(keepImage))
+} // }}}
+
+// func keepHeap.Pop {{{
+
+func (kh *keepHeap) Pop() interface{} {
+	last := kh.images[len(kh.images)-1]
+	kh.images = kh.images[:len(kh.images)-1]
+	return last
+} // }}}
+
+// func keepHeap.offer {{{
+
+// Keeps the image if there is still room, or it is better then the worst already kept (which is then dropped).
+func (kh *keepHeap) offer(ki keepImage) {
+	if len(kh.images) < kh.limit {
+		heap.Push(kh, ki)
+		return
+	}
+
+	worst := kh.images[0]
+
+	if worst.weight > ki.weight || (worst.weight == ki.weight && worst.id > ki.id) {
+		kh.drop(ki.weight)
+		return
+	}
+
+	kh.drop(worst.weight)
+	kh.images[0] = ki
+	heap.Fix(kh, 0)
+} // }}}
+
+// func keepHeap.drop {{{
+
+func (kh *keepHeap) drop(weight int) {
+	if kh.dropped == 0 || weight > kh.dropWeight {
+		kh.dropWeight = weight
+	}
+
+	kh.dropped++
+} // }}}
+
+// func keepWeight {{{
+
+// Returns the highest weight the tags are given by any profile, 0 if none would include them.
+//
+// Expression profiles only take images from the others, so are left to them.
+func keepWeight(co *conf, tgs tags.Tags) int {
+	var best int
+
+	for _, prof := range co.Profiles {
+		if len(prof.Mix) > 0 || prof.Expr != nil || tgs.Contains(prof.Block) || !prof.Matches.Give(tgs) {
+			continue
+		}
+
+		if weight := prof.Weights.GetWeight(tgs); weight > best {
+			best = weight
+		}
+	}
+
+	return best
+} // }}}
+
+// func Weighter.keepImages {{{
+
+// Replaces the images with those kept by the full query, adding any new ones and removing any not kept.
+//
+// You need the imgMut lock.
+func (we *Weighter) keepImages(ca *cache, kh *keepHeap, delta *types.WeighterDelta) {
+	images := make(map[uint64]*cacheImage, len(kh.images))
+
+	for _, ki := range kh.images {
+		img, ok := ca.images[ki.id]
+		if !ok {
+			img = &cacheImage{
+				ID:   ki.id,
+				Hash: ki.hash,
+				Tags: ki.tags,
+			}
+
+			delta.Added = append(delta.Added, ki.id)
+		} else if !ki.tags.Equal(img.Tags) {
+			img.Tags = ki.tags
+		}
+
+		if ki.hash != "" {
+			img.Hash = ki.hash
+		}
+
+		img.seen = ca.seen
+		images[ki.id] = img
+	}
+
+	for id := range ca.images {
+		if _, ok := images[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+
+	ca.images = images
+
+	we.logDropped(kh)
+} // }}}
+
+// func Weighter.trimImages {{{
+
+// Drops the lowest weighted images until there are no more then limit, such as after a poll added new ones.
+//
+// You need the imgMut lock.
+func (we *Weighter) trimImages(ca *cache, co *conf, limit int, delta *types.WeighterDelta) {
+	kh := &keepHeap{limit: limit}
+
+	for id, img := range ca.images {
+		kh.offer(keepImage{id: id, hash: img.Hash, tags: img.Tags, weight: keepWeight(co, img.Tags)})
+	}
+
+	kept := make(map[uint64]bool, len(kh.images))
+	for _, ki := range kh.images {
+		kept[ki.id] = true
+	}
+
+	// Anything added by the poll and then dropped was never really added, so is not removed either.
+	added := make(map[uint64]bool, len(delta.Added))
+
+	keep := delta.Added[:0]
+	for _, id := range delta.Added {
+		added[id] = true
+
+		if kept[id] {
+			keep = append(keep, id)
+		}
+	}
+
+	delta.Added = keep
+
+	for id := range ca.images {
+		if kept[id] {
+			continue
+		}
+
+		delete(ca.images, id)
+
+		if !added[id] {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+
+	we.logDropped(kh)
+} // }}}
+
+// func Weighter.logDropped {{{
+
+func (we *Weighter) logDropped(kh *keepHeap) {
+	if kh.dropped == 0 {
+		return
+	}
+
+	we.l.Warn().Int("maximages", kh.limit).Int("dropped", kh.dropped).Int("bestdropped", kh.dropWeight).
+		Msg("maximages reached, the lowest weighted images are left out")
+} // }}}
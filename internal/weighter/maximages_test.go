@@ -0,0 +1,96 @@
+package weighter
+
+import (
+	"frame/tags"
+	"frame/types"
+	"sort"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMaxImages(t *testing.T) {
+	// Anything with beach (10), family (20) weighs 5 and sunset (30) weighs 2. Work (40) is blocked.
+	matches, err := tags.MakeTagRule(0, tags.Tags{10}, nil, nil)
+	if err != nil {
+		t.Fatalf("MakeTagRule: %s", err)
+	}
+
+	co := &conf{
+		Profiles: map[string]*confProfile{
+			"a": &confProfile{
+				Matches: matches,
+				Weights: tags.TagWeights{{Tag: 20, Weight: 5}, {Tag: 30, Weight: 2}},
+				Block:   tags.Tags{40},
+			},
+		},
+	}
+
+	if got := keepWeight(co, tags.Tags{10, 20}); got != 5 {
+		t.Fatalf("keepWeight family Expected 5 != Got %d", got)
+	}
+
+	if got := keepWeight(co, tags.Tags{10, 20, 40}); got != 0 {
+		t.Fatalf("keepWeight blocked Expected 0 != Got %d", got)
+	}
+
+	if got := keepWeight(co, tags.Tags{20}); got != 0 {
+		t.Fatalf("keepWeight no match Expected 0 != Got %d", got)
+	}
+
+	all := map[uint64]tags.Tags{
+		1: {10, 30},
+		2: {10, 20},
+		3: {10, 40},
+		4: {10, 20},
+		5: {10, 30},
+		6: {10},
+	}
+
+	// Offered in any order, the best 3 are kept with the newer of the two sunsets.
+	kh := &keepHeap{limit: 3}
+	for id, tgs := range all {
+		kh.offer(keepImage{id: id, tags: tgs, weight: keepWeight(co, tgs)})
+	}
+
+	var got []uint64
+	for _, ki := range kh.images {
+		got = append(got, ki.id)
+	}
+
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+
+	if len(got) != 3 || got[0] != 2 || got[1] != 4 || got[2] != 5 {
+		t.Fatalf("keepHeap Expected [2 4 5] != Got %v", got)
+	}
+
+	if kh.dropped != 3 || kh.dropWeight != 2 {
+		t.Fatalf("keepHeap Expected 3 dropped, best 2 != Got %d, %d", kh.dropped, kh.dropWeight)
+	}
+
+	// A poll that just added 6, which is dropped again along with 1 and 3.
+	we := &Weighter{l: zerolog.Nop()}
+	ca := &cache{images: make(map[uint64]*cacheImage)}
+
+	for id, tgs := range all {
+		ca.images[id] = &cacheImage{ID: id, Tags: tgs}
+	}
+
+	delta := types.WeighterDelta{Added: []uint64{5, 6}}
+
+	we.trimImages(ca, co, 3, &delta)
+
+	if len(ca.images) != 3 || ca.images[2] == nil || ca.images[4] == nil || ca.images[5] == nil {
+		t.Fatalf("trimImages Expected [2 4 5] != Got %d images", len(ca.images))
+	}
+
+	sort.Slice(delta.Removed, func(i, j int) bool { return delta.Removed[i] < delta.Removed[j] })
+
+	if len(delta.Added) != 1 || delta.Added[0] != 5 {
+		t.Fatalf("trimImages Added Expected [5] != Got %v", delta.Added)
+	}
+
+	if len(delta.Removed) != 2 || delta.Removed[0] != 1 || delta.Removed[1] != 3 {
+		t.Fatalf("trimImages Removed Expected [1 3] != Got %v", delta.Removed)
+	}
+}
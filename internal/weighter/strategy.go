@@ -0,0 +1,155 @@
+package weighter
+
+import (
+	"fmt"
+)
+
+// type SelectionStrategy interface {{{
+
+// How the images are selected from a profile, chosen per profile with its strategy option.
+//
+// Select is always called with the profiles rMut held, so can use its r and keep its own state within the
+// profile.
+type SelectionStrategy interface {
+	Select(cp *cacheProfile, num uint8) []uint64
+} // }}}
+
+// The strategies by name, see confProfileYAML.Strategy.
+var strategies = map[string]SelectionStrategy{
+	"weighted":   weightedStrategy{},
+	"roundrobin": roundRobinStrategy{},
+	"stratified": stratifiedStrategy{},
+}
+
+const defaultStrategy = "weighted"
+
+// func getStrategy {{{
+
+// Returns the named SelectionStrategy, the default if name is empty.
+func getStrategy(name string) (SelectionStrategy, error) {
+	if name == "" {
+		name = defaultStrategy
+	}
+
+	ss, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+
+	return ss, nil
+} // }}}
+
+// type weightedStrategy struct {{{
+
+// Each image is selected randomly by weight, so an image twice the weight is twice as likely.
+//
+// Any number of the images can come from the same weight.
+type weightedStrategy struct{} // }}}
+
+// func weightedStrategy.Select {{{
+
+func (weightedStrategy) Select(cp *cacheProfile, num uint8) []uint64 {
+	ids := make([]uint64, num)
+	for i := uint8(0); i < num; i++ {
+		ids[i] = pickWeighted(cp)
+	}
+
+	return ids
+} // }}}
+
+// func pickWeighted {{{
+
+// Picks a single random image by weight.
+func pickWeighted(cp *cacheProfile) uint64 {
+	// Get the random weight to use.
+	weight := cp.r.Intn(cp.maxRoll)
+
+	// Find the matching weight.
+	for _, wl := range cp.weights {
+		// Is the weight we are looking at less then what we want?
+		if wl.Weight+wl.Start < weight {
+			continue
+		}
+
+		// This one matches. So lets grab a random file within.
+		return wl.IDs[cp.r.Intn(len(wl.IDs))]
+	}
+
+	return 0
+} // }}}
+
+// type roundRobinStrategy struct {{{
+
+// Takes an image from each weight in turn, ignoring how heavy the weight is, carrying on from where the last
+// selection left off.
+//
+// So every weight gets shown equally, such as rare tags with a low weight still coming around regularly.
+type roundRobinStrategy struct{} // }}}
+
+// func roundRobinStrategy.Select {{{
+
+func (roundRobinStrategy) Select(cp *cacheProfile, num uint8) []uint64 {
+	ids := make([]uint64, num)
+	for i := uint8(0); i < num; i++ {
+		wl := cp.weights[cp.next%len(cp.weights)]
+		cp.next++
+
+		ids[i] = wl.IDs[cp.r.Intn(len(wl.IDs))]
+	}
+
+	return ids
+} // }}}
+
+// type stratifiedStrategy struct {{{
+
+// Ensures a single selection has an image from as many different weights as it can.
+//
+// If there are at least as many images wanted as weights one image comes from each, with the rest selected by
+// weight as usual. Otherwise each image comes from a different weight, still chosen by weight.
+//
+// The images are then shuffled, so the heaviest is not always first.
+type stratifiedStrategy struct{} // }}}
+
+// func stratifiedStrategy.Select {{{
+
+func (stratifiedStrategy) Select(cp *cacheProfile, num uint8) []uint64 {
+	ids := make([]uint64, 0, num)
+
+	if int(num) >= len(cp.weights) {
+		for _, wl := range cp.weights {
+			ids = append(ids, wl.IDs[cp.r.Intn(len(wl.IDs))])
+		}
+
+		for len(ids) < int(num) {
+			ids = append(ids, pickWeighted(cp))
+		}
+	} else {
+		// Weighted without replacement, each weight used is taken out of the roll.
+		used := make([]bool, len(cp.weights))
+		left := cp.maxRoll
+
+		for len(ids) < int(num) {
+			roll := cp.r.Intn(left)
+
+			for i, wl := range cp.weights {
+				if used[i] {
+					continue
+				}
+
+				if roll >= wl.Weight {
+					roll -= wl.Weight
+					continue
+				}
+
+				used[i] = true
+				left -= wl.Weight
+				ids = append(ids, wl.IDs[cp.r.Intn(len(wl.IDs))])
+				break
+			}
+		}
+	}
+
+	cp.r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+
+	return ids
+} // }}}
@@ -0,0 +1,82 @@
+package weighter
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Three weights, with the IDs being 100 times the weight so its easy to tell which an ID came from.
+func strategyProfile() *cacheProfile {
+	cp := &cacheProfile{
+		profile: "test",
+		r:       rand.New(rand.NewSource(1)),
+	}
+
+	start := 0
+	for _, weight := range []int{1, 10, 100} {
+		id := uint64(weight * 100)
+
+		cp.weights = append(cp.weights, &weightList{Weight: weight, Start: start, IDs: []uint64{id, id + 1}})
+		start += weight
+	}
+
+	cp.maxRoll = start
+
+	return cp
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	cp := strategyProfile()
+	seen := make(map[uint64]int)
+
+	// Spread over several calls, picking up where the last left off.
+	for i := 0; i < 4; i++ {
+		for _, id := range (roundRobinStrategy{}).Select(cp, 3) {
+			seen[id/100]++
+		}
+	}
+
+	for _, weight := range []uint64{1, 10, 100} {
+		if seen[weight] != 4 {
+			t.Fatalf("roundrobin weight %d Expected 4 != Got %d (%v)", weight, seen[weight], seen)
+		}
+	}
+}
+
+func TestStratifiedStrategy(t *testing.T) {
+	cp := strategyProfile()
+
+	for i := 0; i < 50; i++ {
+		// Enough for every weight, each must be there.
+		seen := make(map[uint64]bool)
+		ids := (stratifiedStrategy{}).Select(cp, 5)
+
+		if len(ids) != 5 {
+			t.Fatalf("stratified Expected 5 != Got %d", len(ids))
+		}
+
+		for _, id := range ids {
+			seen[id/100] = true
+		}
+
+		if !seen[1] || !seen[10] || !seen[100] {
+			t.Fatalf("stratified missing a weight: %v", ids)
+		}
+
+		// Fewer then the weights, each from a different one.
+		ids = (stratifiedStrategy{}).Select(cp, 2)
+		if len(ids) != 2 || ids[0]/100 == ids[1]/100 {
+			t.Fatalf("stratified Expected 2 different weights: %v", ids)
+		}
+	}
+}
+
+func TestGetStrategy(t *testing.T) {
+	if ss, err := getStrategy(""); err != nil || ss != (weightedStrategy{}) {
+		t.Fatalf("getStrategy default Got %v, %v", ss, err)
+	}
+
+	if _, err := getStrategy("nope"); err == nil {
+		t.Fatalf("getStrategy accepted an unknown strategy")
+	}
+}
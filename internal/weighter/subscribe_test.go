@@ -0,0 +1,119 @@
+package weighter
+
+import (
+	"frame/tags"
+	"frame/types"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSubscribeDeltas(t *testing.T) {
+	ca := &cache{images: map[uint64]*cacheImage{
+		1: {ID: 1, Tags: tags.Tags{10}},
+		2: {ID: 2, Tags: tags.Tags{10, 20}},
+	}}
+
+	we := &Weighter{l: zerolog.Nop(), ca: ca}
+
+	ch, unsub := we.Subscribe()
+
+	wl := tags.Tags{10}
+	delta := types.WeighterDelta{}
+
+	rows := []struct {
+		id      uint64
+		tgs     tags.Tags
+		enabled bool
+		changed bool
+	}{
+		// New and passes the whitelist.
+		{3, tags.Tags{10, 30}, true, true},
+		// New but not on the whitelist.
+		{4, tags.Tags{30}, true, false},
+		// New but already disabled.
+		{5, tags.Tags{10}, false, false},
+		// Existing, now disabled.
+		{1, tags.Tags{10}, false, true},
+		// Existing, tags changed.
+		{2, tags.Tags{10}, true, true},
+	}
+
+	for _, r := range rows {
+		if got := pollRow(ca, wl, &delta, r.id, r.tgs, r.enabled, ""); got != r.changed {
+			t.Fatalf("pollRow %d Expected %t != Got %t", r.id, r.changed, got)
+		}
+	}
+
+	we.publish(delta)
+
+	select {
+	case got := <-ch:
+		if !reflect.DeepEqual(got.Added, []uint64{3}) {
+			t.Fatalf("Added Expected [3] != Got %v", got.Added)
+		}
+
+		if !reflect.DeepEqual(got.Removed, []uint64{1}) {
+			t.Fatalf("Removed Expected [1] != Got %v", got.Removed)
+		}
+	default:
+		t.Fatalf("Expected a delta, got none")
+	}
+
+	// A poll that only changed tags has nothing to say.
+	delta = types.WeighterDelta{}
+	pollRow(ca, wl, &delta, 2, tags.Tags{10, 20}, true, "")
+	we.publish(delta)
+
+	select {
+	case got := <-ch:
+		t.Fatalf("Expected no delta != Got %+v", got)
+	default:
+	}
+
+	// Unsubscribing closes the channel, and is safe to do again.
+	unsub()
+	unsub()
+
+	if _, ok := <-ch; ok {
+		t.Fatalf("Expected closed channel")
+	}
+
+	// Nobody left to send to.
+	we.publish(types.WeighterDelta{Removed: []uint64{2}})
+}
+
+func TestSubscribeFull(t *testing.T) {
+	we := &Weighter{l: zerolog.Nop()}
+
+	ch, unsub := we.Subscribe()
+	defer unsub()
+
+	done := make(chan struct{})
+
+	// Nobody reading, so the channel fills and the rest are dropped.
+	go func() {
+		defer close(done)
+
+		for i := uint64(1); i <= 25; i++ {
+			we.publish(types.WeighterDelta{Added: []uint64{i}})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publish blocked on a full subscriber")
+	}
+
+	if got := len(ch); got != cap(ch) {
+		t.Fatalf("len Expected %d != Got %d", cap(ch), got)
+	}
+
+	// The oldest are kept, the newest dropped.
+	if got := <-ch; got.Added[0] != 1 {
+		t.Fatalf("first Expected 1 != Got %d", got.Added[0])
+	}
+}
@@ -0,0 +1,486 @@
+package weighter
+
+import (
+	"context"
+	"frame/internal/pgdb"
+	"frame/internal/rules"
+	"frame/internal/scheduler"
+	"frame/internal/usage"
+	"frame/tags"
+	"frame/types"
+	"frame/yconf"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// type Weighter struct {{{
+
+type Weighter struct {
+	l zerolog.Logger
+
+	// Our image cache
+	//
+	// No lock is needed to use cache, though it has multiple locks within.
+	ca *cache
+
+	// Our database pool, replaceable while running.
+	db *pgdb.DB
+
+	// We use an atomic for the configuration since we might replace it at any time while another goroutine
+	// can be using it.
+	co atomic.Value
+
+	// Our configuration path.
+	//
+	// Can also be a single file if you want to store everything in just one file.
+	cPath string
+
+	// Do not access directly, use atomics.
+	closed uint32
+
+	tm types.TagManager
+
+	yc *yconf.YConf
+
+	// A whitelist of all the tags we care about.
+	//
+	// Any image loaded from the database that does not have at least one
+	// of these tags will be ignored and not loaded into our cache.
+	//
+	// This is updated when the configuration is successfully loaded via doFull()
+	//
+	// This is actually a tags.Tags, stored in an atomic.Value.
+	//
+	// Once created it is read-only, and fully replaced when it changes (not modified).
+	white atomic.Value
+
+	// See SetBlockTags(), a func() tags.Tags stored in an atomic.Value.
+	blockTags atomic.Value
+
+	// Used to control shutting down background goroutines.
+	ctx context.Context
+
+	// Runs the regular poll and full queries.
+	sch *scheduler.Scheduler
+
+	// How many polls in a row have failed, only used by tickPoll().
+	pollErrs uint32
+
+	// Invalidations waiting on the profiles to be rebuilt, see Invalidate().
+	//
+	// Do not access directly, use atomics.
+	rebuild uint32
+
+	// Subscribers to the image deltas, see Subscribe().
+	//
+	// Need subMut to access subs or subID.
+	subMut sync.Mutex
+	subs   map[uint64]chan types.WeighterDelta
+	subID  uint64
+
+	// What has been shown, nil unless confYAML.Usage is set.
+	usage *usage.Store
+} // }}}
+
+// The full query returns the ID and tags of each image, the poll query the same followed by if it is enabled.
+//
+// Either can return the hash of the image as a last column, such as by joining files.hashes, which is then given
+// by wProfile.GetWithHashes().
+//
+// PollHashes is optional, the same as Poll but for just the hash IDs given as its only argument (a bigint[]), see
+// PollHashes().
+type confQueries struct {
+	Full       string `yaml:"full"`
+	Poll       string `yaml:"poll"`
+	PollHashes string `yaml:"poll-hashes"`
+}
+
+// type wProfile struct {{{
+
+type wProfile struct {
+	we *Weighter
+	cp atomic.Value
+} // }}}
+
+// type cacheImage struct {{{
+
+// The images loaded from the merged table in the database.
+//
+// We have a full which pulls all rows, and a poll query which only pulls the most
+// recent changes to keep us in sync with the table.
+type cacheImage struct {
+	// The database ID.
+	ID uint64
+
+	// Empty unless the queries return it, see confQueries.
+	Hash string
+
+	// Our combined tags from all the files with the same hash, as well as our tag rules.
+	Tags tags.Tags
+
+	// Lets us know if the image we seen by the full query or not.
+	//
+	// We do not care if this wraps, as each time fullQuery() is run it changes the number
+	// in cache.seen and only cares if its the same as this or not.
+	seen uint8
+} // }}}
+
+// type weightList struct {{{
+
+// See cacheProfile. Weights for more details on how this structure works.
+type weightList struct {
+	Weight int
+	Start  int
+	IDs    []uint64
+} // }}}
+
+// type cacheProfile struct {{{
+
+type cacheProfile struct {
+	// The profile name
+	profile string
+
+	// So details on how this works -
+	//
+	// This is a sorted list of all the weights for this specific profile.
+	//
+	// All images that have the same weight are stored in the IDs.
+	//
+	// To figure out which image to use, we choose a random number between 0 and MaxRoll.
+	// Then we search (typically binary search) for which weightList matches that number.
+	// It has to be >= that weightList.Start and lower then the next weightList.Start.
+	//
+	// Once we've found the right weightList to use, we just choose a random number between
+	// 0 and len(weightList.IDs) to pick the actual image to use within that weight.
+	weights []*weightList
+
+	maxRoll int
+
+	// How many images are in the profile.
+	count int
+
+	// Set if count is below the profiles MinPool and it has a Fallback configured.
+	//
+	// Images are then taken from this profile instead.
+	fallback *cacheProfile
+
+	// The TagRule that must apply for this image to be considered for inclusion in this profile or not.
+	tagRule tags.TagRule
+
+	// Set for a mix profile (see confProfileYAML.Mix), which has no weights of its own.
+	//
+	// maxRoll is then the total weight of the parts that have images, and count the total of their counts.
+	mix []mixPart
+
+	// How images are selected, see SelectionStrategy.
+	strategy SelectionStrategy
+
+	// nil unless the profile favours images not shown recently, see Weighter.applyRecency().
+	recency *recency
+
+	// Kept by the strategy between selections, such as where roundRobinStrategy is at.
+	//
+	// Need rMut to access, same as r.
+	next int
+
+	// Random number generator for getting random hashes.
+	// See getRandomProfile() for usage.
+	r *rand.Rand
+
+	// Need to get this mutex for accessing r above.
+	rMut sync.Mutex
+
+	// Access only with atomics.
+	// If set to 1, this profile is no longer valid
+	// and you need to load the new one from the cache.
+	closed uint32
+} // }}}
+
+// type mixPart struct {{{
+
+type mixPart struct {
+	cp     *cacheProfile
+	weight int
+} // }}}
+
+// type cache struct {{{
+
+type cache struct {
+	// Our images from the database, the key is the ID assigned by the database.
+	//
+	// imgMut is a mutex locking only the images map, any change to any image (additions, removals, and changing tags)
+	// will happen to a new cacheImage, so anything pointing to any existing cacheImage can continue to do so knowing once created these
+	// are read-only and no locking is needed on reading those.
+	//
+	// The mutex is a RWMutex as we want to allowing reading locks on the map itself during profile generation.
+	// A write lock is only gotten when we run queries to get images from the database.
+	//
+	// Again, the mutex is only needed when accesing the images map. Profiles can save the cacheImage references and access those directly
+	// without the lock.
+	imgMut sync.RWMutex
+	images map[uint64]*cacheImage
+
+	// IDs reported by Invalidate() and when, skipped by the queries until InvalidExpire has passed.
+	//
+	// You need the imgMut lock to access this.
+	invalid map[uint64]time.Time
+
+	// Used by the full query to set cacheImage.seen to know what images were seen or not so they can be removed.
+	// You need the imgMut lock to access this.
+	seen uint8
+
+	// Used by polling to mark all the IDs that were changed.
+	//
+	// Delete/Disabled IDs are also in this list, looking up a disabled ID from images would be how to know it was removed.
+	//
+	// You need the imgMut lock to access this.
+	pollChanged []uint64
+
+	// When each tag stops hiding an image, zero if it is not a hidden tag, see hiddenPrefix.
+	//
+	// You need the imgMut lock to access this.
+	hidden map[uint64]time.Time
+
+	// The soonest any image hidden by makeProfileWeights() returns, zero if none are hidden.
+	//
+	// You need the imgMut lock to access this.
+	unhide time.Time
+
+	// The configuration and block tags lintTags() last checked, so each is only checked once.
+	//
+	// You need the imgMut lock to access these.
+	linted    *conf
+	lintBlock tags.Tags
+
+	// pMut works much the same as imgMut above - Only needed to access the profiles map itself, and again cacheProfile is considered read-only once
+	// it is created. All changes to it will be done to a new cacheProfile and the map will be updated with that.
+	pMut     sync.RWMutex
+	profiles map[string]*cacheProfile
+
+	// Profiles with fewer images then their MinPool, and how many images they do have.
+	//
+	// Replaced (not modified) each time the profiles are made, need pMut to access.
+	low map[string]int
+
+	// See Weighter.TagWarnings(), replaced (not modified) by lintTags(), need pMut to access.
+	warnings []string
+} // }}}
+
+// type confProfile struct {{{
+
+type confProfile struct {
+	Name    string
+	Matches tags.TagRule
+	Weights tags.TagWeights
+
+	// See confProfileYAML.Block
+	Block tags.Tags
+
+	MinPool  int
+	Fallback string
+
+	// See confProfileYAML.Strategy, empty for the default.
+	Strategy string
+
+	// See confProfileYAML.Recency, nil if not set.
+	Recency *recency
+
+	// See confProfileYAML.Mix, if set none of the above are.
+	Mix map[string]int
+
+	// See confProfileYAML.Expr, nil if not set. Matches is then unused.
+	Expr *profileExpr
+} // }}}
+
+// type confProfileYAML struct {{{
+
+type confProfileYAML struct {
+	// Images must have at least 1 of these tags to be included.
+	// This does not assign any weight to the tag itself, only there to allow/disallow an image for consideration
+	// in the profile itself.
+	Any []string `yaml:"any"`
+
+	// Images must have all of these tags to be included.
+	//
+	// Like any above, this does not assign any weight to the tag itself.
+	All []string `yaml:"all"`
+
+	// Image must not have any of these tags to be included in the profile.
+	None []string `yaml:"none"`
+
+	// Images with any of these tags are never included in the profile, regardless of Any, All or Weights.
+	//
+	// Checked after our TagRules are applied, so a tag given by a rule blocks the same as one from the database.
+	//
+	// Unlike the BlockTags of CacheMerge which blocks an image everywhere, this only blocks it from this profile.
+	// Such as keeping work screenshots off the kitchen frame while the office frame still shows them.
+	Block []string `yaml:"block"`
+
+	// The various tags and weights assigned to each tag for the profile.
+	//
+	// A profile must have a minimum of 1 weighted tag that is greater then 1.
+	//
+	// The value of the weights themselves is user-defined. You can assigned small or large numbers, you can also assigned negative numbers.
+	// Negative numbers are useful for lowering the weight of an image based on the existance of less desirable tags, but still included.
+	//
+	// Any image to be included must have a final weight of 1 or higher.
+	//
+	// It is possible to exclude images simply by making their weight less then 1.
+	Weights tags.ConfTagWeights `yaml:"weights"`
+
+	// The minimum number of images the profile should have.
+	//
+	// If fewer images match then a warning is logged each time the profile is built, and it is listed by
+	// Weighter.LowProfiles(). A typo in a tag can otherwise leave a profile showing the same couple images forever.
+	//
+	// Default of 0 disables the check.
+	MinPool int `yaml:"minpool"`
+
+	// If set and the profile has fewer then MinPool images, images are taken from this profile instead.
+	//
+	// Only a single level of fallback is done, the fallback profile is used as-is even if it is low itself.
+	Fallback string `yaml:"fallback"`
+
+	// Makes this a mix of other profiles rather then matching images itself, the profile name to its weight.
+	//
+	// Such as family: 70 and landscapes: 30, each image selected then comes from family 70% of the time. The
+	// profile is picked for each image as it is selected, so Get(5) can return images from both.
+	//
+	// A part without any images is left out, so the others make up for it. The parts fallbacks are used same as
+	// when selecting from them directly.
+	//
+	// If set nothing else can be, and the parts can not be mixes themselves.
+	Mix map[string]int `yaml:"mix"`
+
+	// Makes this the images of other profiles combined with AND, OR, NOT and parentheses, such as
+	// "landscapes AND NOT kids" for the landscapes not also in the kids profile. Saves copying the tag lists of one
+	// profile into the None of another and keeping the two in step.
+	//
+	// Each image is given the highest weight it has in any of the profiles used, unless Weights are set which are
+	// then used instead. An image only included through a NOT is given a weight of 1.
+	//
+	// If set Any, All, None and Mix can not be, and the profiles used can not be mixes or expressions themselves.
+	// Block, MinPool, Fallback, Strategy and Recency work the same as for any other profile.
+	Expr string `yaml:"expr"`
+
+	// How the images are selected from the profile -
+	//
+	// "weighted" (the default) selects each image randomly by weight.
+	//
+	// "roundrobin" takes an image from each weight in turn, so every weight is shown equally often no matter how
+	// heavy it is.
+	//
+	// "stratified" is weighted, but ensures the images selected together (such as for a single Render) come from
+	// as many different weights as possible.
+	Strategy string `yaml:"strategy"`
+
+	// Favours images that have not been shown recently, so over time the whole profile gets shown rather then
+	// whatever the dice happen to like.
+	//
+	// An image just shown is less likely to be selected again until Window has passed, and one never shown more
+	// likely, see confRecencyYAML. Works along with any Strategy.
+	//
+	// Needs the usage database (see confYAML.Usage), which Render keeps up to date with what it shows.
+	Recency *confRecencyYAML `yaml:"recency"`
+} // }}}
+
+// type confYAML struct {{{
+
+type confYAML struct {
+	Database string `yaml:"database" log:"redact"`
+
+	Queries confQueries `yaml:"queries"`
+
+	Profiles map[string]confProfileYAML `yaml:"profile"`
+
+	// Additional tag rules we apply to images before running any of the images through profiles.
+	//
+	// Note that these tagrules are not caches and always run when an image is loaded.
+	//
+	// For best performance put as many of these rules as possible into cmerge rather then here.
+	TagRules tags.ConfTagRules `yaml:"tagrules"`
+
+	// Every interval we run the Poll query
+	PollInterval yconf.Duration `yaml:"pollinterval"`
+
+	// Every interval we run the Full query
+	FullInterval yconf.Duration `yaml:"fullinterval"`
+
+	// How long an image reported as unloadable (see Weighter.Invalidate()) is left out, after which it is
+	// loaded again if still in the database. Gives the image a chance to be cached again.
+	//
+	// Default if not set is 1 hour.
+	InvalidExpire yconf.Duration `yaml:"invalidexpire"`
+
+	// A file to keep what Render has shown in, created if needed. Used by the Recency of profiles.
+	//
+	// Opened at startup, changing it needs a restart.
+	Usage string `yaml:"usage"`
+
+	// How long what was shown is kept, default if not set is 90 days ("2160h").
+	//
+	// When each image was last shown is kept regardless.
+	UsageKeep yconf.Duration `yaml:"usagekeep"`
+
+	// The most images kept in memory, for small devices with a large merged table.
+	//
+	// Only those with the highest weight in any profile are kept, the rest are left out and a warning logged. Rows
+	// from the database are streamed, so the full query never holds more then this many (plus what was already kept).
+	//
+	// Default of 0 keeps every image.
+	MaxImages int `yaml:"maximages"`
+} // }}}
+
+// Updated configuration bits
+const (
+	ucDBConn   = 1 << iota // When the database connection changes
+	ucDBQuery  = 1 << iota // When at least one of the database queries change
+	ucTagRules = 1 << iota // When TagRules change
+	ucProfiles = 1 << iota // When any of the profiles change
+	ucPollInt  = 1 << iota
+	ucFullInt  = 1 << iota
+	ucMaxImg   = 1 << iota // When MaxImages changes
+)
+
+// type conf struct {{{
+
+type conf struct {
+	Database string `log:"redact"`
+
+	Queries confQueries
+
+	TagRules tags.TagRules
+
+	// Applies TagRules, made along with them.
+	rules *rules.Engine
+
+	// Our profiles, main reason for our existance.
+	Profiles map[string]*confProfile
+
+	// Every interval we run the Poll query
+	PollInterval time.Duration
+
+	// Every interval we run the Full query
+	FullInterval time.Duration
+
+	// See confYAML.InvalidExpire
+	InvalidExpire time.Duration
+
+	// See confYAML.Usage and UsageKeep.
+	Usage     string
+	UsageKeep time.Duration
+
+	// See confYAML.MaxImages, 0 for no limit.
+	MaxImages int
+} // }}}
+
+// Convert and Notify are set in New()
+var ycCallers = yconf.Callers{
+	Empty:   func() interface{} { return &confYAML{} },
+	Merge:   yconfMerge,
+	Changed: yconfChanged,
+}
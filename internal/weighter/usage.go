@@ -0,0 +1,336 @@
+package weighter
+
+import (
+	"errors"
+	"fmt"
+	"frame/internal/usage"
+	"frame/types"
+	"frame/yconf"
+	"sort"
+	"time"
+)
+
+// How many times an image can be rejected by its recency before the last is taken anyway.
+//
+// Keeps a profile of nothing but recently shown images from spinning.
+const recencyTries = 10
+
+// The most windows UsageStats() will split into.
+const maxWindows = 1000
+
+// Returned by UsageStats() when there is no usage database, see confYAML.Usage.
+var ErrNoUsage = errors.New("no usage database")
+
+// type confRecencyYAML struct {{{
+
+type confRecencyYAML struct {
+	// How long an image is held back after it is shown, default if not set is 1 week ("168h").
+	Window yconf.Duration `yaml:"window"`
+
+	// The weight of an image just shown is multiplied by this, recovering evenly to its full weight over Window.
+	//
+	// Between 0 and 1, default if not set is 0.1.
+	Shown float64 `yaml:"shown"`
+
+	// The weight of an image never shown is multiplied by this, at least 1. Default if not set is 2.
+	Unshown float64 `yaml:"unshown"`
+} // }}}
+
+// type recency struct {{{
+
+// See confProfileYAML.Recency.
+type recency struct {
+	Window  time.Duration
+	Shown   float64
+	Unshown float64
+} // }}}
+
+// func parseRecency {{{
+
+// Returns nil if the profile does not use it.
+func parseRecency(in *confRecencyYAML) (*recency, error) {
+	if in == nil {
+		return nil, nil
+	}
+
+	rc := &recency{
+		Window:  time.Duration(in.Window),
+		Shown:   in.Shown,
+		Unshown: in.Unshown,
+	}
+
+	if rc.Window == 0 {
+		rc.Window = 7 * 24 * time.Hour
+	}
+
+	if rc.Shown == 0 {
+		rc.Shown = 0.1
+	}
+
+	if rc.Unshown == 0 {
+		rc.Unshown = 2
+	}
+
+	if rc.Window < 0 {
+		return nil, errors.New("recency window can not be negative")
+	}
+
+	if rc.Shown < 0 || rc.Shown > 1 {
+		return nil, errors.New("recency shown needs to be between 0 and 1")
+	}
+
+	if rc.Unshown < 1 {
+		return nil, errors.New("recency unshown needs to be at least 1")
+	}
+
+	return rc, nil
+} // }}}
+
+// func sameRecency {{{
+
+func sameRecency(a, b *recency) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+} // }}}
+
+// func recency.factor {{{
+
+// Returns what the weight of an image last shown at last (zero if never) is multiplied by at now.
+func (rc *recency) factor(last, now time.Time) float64 {
+	if last.IsZero() {
+		return rc.Unshown
+	}
+
+	age := now.Sub(last)
+	if age >= rc.Window {
+		return 1
+	}
+
+	if age < 0 {
+		age = 0
+	}
+
+	return rc.Shown + (1-rc.Shown)*float64(age)/float64(rc.Window)
+} // }}}
+
+// func Weighter.applyRecency {{{
+
+// Replaces selected images by chance, the more recently they were shown the more likely, so over time every image
+// gets its turn rather then being purely random.
+//
+// Each image is kept with a chance of its factor() over the largest factor there can be, otherwise another is
+// selected in its place the same way.
+//
+// Caller must have the profiles rMut held.
+func (we *Weighter) applyRecency(cp *cacheProfile, ids []uint64) {
+	rc := cp.recency
+	if rc == nil || we.usage == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for i := range ids {
+		for try := 0; try < recencyTries; try++ {
+			last, _ := we.usage.LastShown(ids[i])
+
+			if cp.r.Float64()*rc.Unshown < rc.factor(last, now) {
+				break
+			}
+
+			ids[i] = cp.strategy.Select(cp, 1)[0]
+		}
+	}
+} // }}}
+
+// func Weighter.openUsage {{{
+
+// Opens the usage database if one is configured.
+//
+// This is done once at startup, changing the location requires a restart.
+func (we *Weighter) openUsage(co *conf) error {
+	if co.Usage == "" {
+		return nil
+	}
+
+	fl := we.l.With().Str("func", "openUsage").Str("file", co.Usage).Logger()
+
+	st, err := usage.Open(co.Usage, 0)
+	if err != nil {
+		fl.Err(err).Msg("usage.Open")
+		return err
+	}
+
+	we.usage = st
+
+	fl.Debug().Send()
+
+	return nil
+} // }}}
+
+// func Weighter.Shown {{{
+
+// Records the images shown within an output, does nothing if there is no usage database.
+//
+// Implements types.WeighterUsage.
+func (we *Weighter) Shown(output string, images []types.ShownImage, at time.Time) {
+	if we.usage == nil || len(images) == 0 {
+		return
+	}
+
+	fl := we.l.With().Str("func", "Shown").Str("output", output).Logger()
+
+	if err := we.usage.Record(output, images, at); err != nil {
+		fl.Err(err).Msg("Record")
+	}
+} // }}}
+
+// func Weighter.pruneUsage {{{
+
+// Removes outputs shown longer ago then UsageKeep from the usage database.
+func (we *Weighter) pruneUsage() {
+	if we.usage == nil {
+		return
+	}
+
+	fl := we.l.With().Str("func", "pruneUsage").Logger()
+
+	co := we.getConf()
+
+	removed, err := we.usage.Prune(time.Now().Add(-co.UsageKeep))
+	if err != nil {
+		fl.Err(err).Msg("Prune")
+		return
+	}
+
+	fl.Debug().Int("removed", removed).Send()
+} // }}}
+
+// func Weighter.UsageStats {{{
+
+// Implements types.WeighterStats, see there.
+//
+// Returns ErrNoUsage without a usage database.
+func (we *Weighter) UsageStats(from, to time.Time, window time.Duration) ([]types.UsageWindow, error) {
+	fl := we.l.With().Str("func", "UsageStats").Logger()
+
+	if we.usage == nil {
+		return nil, ErrNoUsage
+	}
+
+	windows, err := makeWindows(from, to, window)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tag names of each image, looked up only once.
+	names := make(map[uint64][]string)
+
+	err = we.usage.Each(from, to, func(show *usage.Show) error {
+		// Sorted and not overlapping, so the last window starting at or before is the one.
+		i := sort.Search(len(windows), func(i int) bool { return windows[i].Start.After(show.At) }) - 1
+		if i < 0 {
+			return nil
+		}
+
+		uw := &windows[i]
+		uw.Outputs++
+
+		for _, img := range show.Images {
+			uw.Images[img.ID]++
+			uw.Profiles[img.Profile]++
+
+			tgs, ok := names[img.ID]
+			if !ok {
+				tgs = we.tagNames(img.ID)
+				names[img.ID] = tgs
+			}
+
+			for _, name := range tgs {
+				uw.Tags[name]++
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fl.Err(err).Msg("Each")
+		return nil, err
+	}
+
+	return windows, nil
+} // }}}
+
+// func makeWindows {{{
+
+// Splits from until to into empty windows each lasting window, the last cut short at to.
+func makeWindows(from, to time.Time, window time.Duration) ([]types.UsageWindow, error) {
+	if !from.Before(to) {
+		return nil, errors.New("from needs to be before to")
+	}
+
+	if window <= 0 {
+		window = to.Sub(from)
+	}
+
+	if to.Sub(from)/window >= maxWindows {
+		return nil, fmt.Errorf("more then %d windows", maxWindows)
+	}
+
+	var windows []types.UsageWindow
+
+	for start := from; start.Before(to); start = start.Add(window) {
+		end := start.Add(window)
+		if end.After(to) {
+			end = to
+		}
+
+		windows = append(windows, types.UsageWindow{
+			Start:    start,
+			End:      end,
+			Images:   make(map[uint64]int),
+			Profiles: make(map[string]int),
+			Tags:     make(map[string]int),
+		})
+	}
+
+	return windows, nil
+} // }}}
+
+// func Weighter.tagNames {{{
+
+// Returns the names of the tags the image has, nil if we do not have the image.
+func (we *Weighter) tagNames(id uint64) []string {
+	ca := we.ca
+
+	ca.imgMut.RLock()
+	ci, ok := ca.images[id]
+	ca.imgMut.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(ci.Tags))
+
+	for _, tag := range ci.Tags {
+		if name, err := we.tm.Name(tag); err == nil {
+			names = append(names, name)
+		}
+	}
+
+	return names
+} // }}}
+
+// func Weighter.ImageTags {{{
+
+// Returns the names of the tags the image has sorted, nil if we do not have the image.
+func (we *Weighter) ImageTags(id uint64) []string {
+	names := we.tagNames(id)
+	sort.Strings(names)
+	return names
+} // }}}
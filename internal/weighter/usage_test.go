@@ -0,0 +1,70 @@
+package weighter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecencyFactor(t *testing.T) {
+	rc, err := parseRecency(&confRecencyYAML{})
+	if err != nil {
+		t.Fatalf("parseRecency: %s", err)
+	}
+
+	if rc.Window != 7*24*time.Hour || rc.Shown != 0.1 || rc.Unshown != 2 {
+		t.Fatalf("parseRecency defaults: %+v", rc)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		Last     time.Time
+		Expected float64
+	}{
+		{time.Time{}, 2},
+		{now, 0.1},
+		{now.Add(-rc.Window / 2), 0.55},
+		{now.Add(-rc.Window), 1},
+		{now.Add(-2 * rc.Window), 1},
+	}
+
+	for _, test := range tests {
+		if got := rc.factor(test.Last, now); got < test.Expected-0.0001 || got > test.Expected+0.0001 {
+			t.Errorf("factor(%s) Expected %v != Got %v", now.Sub(test.Last), test.Expected, got)
+		}
+	}
+
+	if _, err := parseRecency(&confRecencyYAML{Shown: 2}); err == nil {
+		t.Errorf("parseRecency shown 2 Expected error")
+	}
+
+	if _, err := parseRecency(&confRecencyYAML{Unshown: 0.5}); err == nil {
+		t.Errorf("parseRecency unshown 0.5 Expected error")
+	}
+}
+
+func TestMakeWindows(t *testing.T) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * 24 * time.Hour)
+
+	windows, err := makeWindows(from, to, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("makeWindows: %s", err)
+	}
+
+	if len(windows) != 2 || !windows[1].Start.Equal(from.Add(7*24*time.Hour)) || !windows[1].End.Equal(to) {
+		t.Fatalf("makeWindows Expected 2 weekly windows != Got %+v", windows)
+	}
+
+	if windows, err = makeWindows(from, to, 0); err != nil || len(windows) != 1 {
+		t.Fatalf("makeWindows 0 Expected 1 window != Got %d, %v", len(windows), err)
+	}
+
+	if _, err = makeWindows(from, to, time.Second); err == nil {
+		t.Errorf("makeWindows too many Expected error")
+	}
+
+	if _, err = makeWindows(to, from, 0); err == nil {
+		t.Errorf("makeWindows backwards Expected error")
+	}
+}
@@ -271,6 +271,9 @@ CREATE TABLE IF NOT EXISTS files (
 	-- A file *must* have at least 1 tag to be added, otherwise there is no way to possibly choose the file.
 	tags bigint[] NOT NULL,
 
+	-- When the image was taken, such as from its EXIF. NULL if not known.
+	taken timestamptz,
+
 	updated timestamptz NOT NULL DEFAULT NOW(),
 
 	UNIQUE( pid, name ),
@@ -286,7 +289,7 @@ CREATE OR REPLACE FUNCTION files_upd() RETURNS trigger
 	LANGUAGE plpgsql SECURITY DEFINER
 	AS $$
 		BEGIN
-			IF NEW.filets != OLD.filets OR NEW.sidets != OLD.sidets OR NEW.sidetags != OLD.sidetags OR NEW.tags != OLD.tags OR NEW.hid != OLD.hid THEN
+			IF NEW.filets != OLD.filets OR NEW.sidets != OLD.sidets OR NEW.sidetags != OLD.sidetags OR NEW.tags != OLD.tags OR NEW.hid != OLD.hid OR NEW.taken IS DISTINCT FROM OLD.taken THEN
 				NEW.updated = NOW();
 			END IF;
 			RETURN NEW;
@@ -304,6 +307,12 @@ CREATE TABLE IF NOT EXISTS merged (
 	-- those files are from.
 	tags bigint[] NOT NULL,
 
+	-- The earliest and latest of when the files with the hash were taken (files.taken), NULL if none are known.
+	--
+	-- Set by the dates query of cmerge.
+	earliest timestamptz,
+	latest timestamptz,
+
 	updated timestamptz NOT NULL DEFAULT NOW(),
 
 	blocked bool NOT NULL DEFAULT false,
@@ -319,7 +328,7 @@ CREATE OR REPLACE FUNCTION merged_upd() RETURNS trigger
 	LANGUAGE plpgsql SECURITY DEFINER
 	AS $$
 		BEGIN
-			IF NEW.blocked != OLD.blocked OR NEW.tags != OLD.tags OR NEW.enabled != OLD.enabled THEN
+			IF NEW.blocked != OLD.blocked OR NEW.tags != OLD.tags OR NEW.enabled != OLD.enabled OR NEW.earliest IS DISTINCT FROM OLD.earliest OR NEW.latest IS DISTINCT FROM OLD.latest THEN
 				NEW.updated = NOW();
 			END IF;
 			RETURN NEW;
@@ -330,5 +339,245 @@ ALTER FUNCTION merged_upd() OWNER TO frame ;
 
 CREATE TRIGGER merged_upd BEFORE INSERT OR UPDATE ON files.merged FOR EACH ROW EXECUTE FUNCTION merged_upd();
 
+-- Progress of the current full scan for each base.
+--
+-- Only used to resume an interrupted full scan, the path is cleared once the scan completes.
+CREATE TABLE IF NOT EXISTS checkpoints (
+	bid bigint PRIMARY KEY,
+
+	-- The loop number of the scan that saved the checkpoint.
+	loop bigint NOT NULL,
+
+	-- The last path completed by the scan, empty when no scan is in progress.
+	path varchar(4096) NOT NULL DEFAULT '',
+
+	updated timestamptz NOT NULL DEFAULT NOW(),
+
+	FOREIGN KEY ( bid ) REFERENCES base
+);
+
+ALTER TABLE IF EXISTS checkpoints OWNER TO frame;
+
+-- A summary of each check run by imgproc.
+--
+-- Useful for spotting trends, such as a mount that went stale and scans suddenly seeing no files.
+CREATE TABLE IF NOT EXISTS scan_runs (
+	rid bigserial PRIMARY KEY,
+	bid bigint NOT NULL,
+
+	started timestamptz NOT NULL,
+
+	-- How long the run took, in milliseconds.
+	took bigint NOT NULL,
+
+	-- A full scan rather then a partial.
+	full_scan boolean NOT NULL,
+
+	seen bigint NOT NULL,
+	added bigint NOT NULL,
+	updated bigint NOT NULL,
+	disabled bigint NOT NULL,
+	errors bigint NOT NULL,
+
+	-- If the run itself failed, why.
+	error text NOT NULL DEFAULT '',
+
+	FOREIGN KEY ( bid ) REFERENCES base
+);
+
+ALTER TABLE IF EXISTS scan_runs OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS scan_runs_bid_started ON scan_runs ( bid, started );
+
+-- Every change imgproc made to a file, when its changes-insert query is set.
+--
+-- Append only, so a mass change (such as a bad sidecar edit cascading through tag rules) can be audited and undone.
+CREATE TABLE IF NOT EXISTS changes (
+	cid bigserial PRIMARY KEY,
+	fid bigint NOT NULL,
+	bid bigint NOT NULL,
+
+	changed timestamptz NOT NULL DEFAULT NOW(),
+
+	-- Either "add", "update" or "disable".
+	action varchar(16) NOT NULL,
+
+	-- The hash and tags before and after, NULL for the side that did not exist (before an add, after a disable).
+	old_hid bigint,
+	new_hid bigint,
+	old_tags bigint[],
+	new_tags bigint[],
+
+	FOREIGN KEY ( fid ) REFERENCES files,
+	FOREIGN KEY ( bid ) REFERENCES base
+);
+
+ALTER TABLE IF EXISTS changes OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS changes_changed ON changes ( changed );
+
+-- Every imgproc instance using workers, its last heartbeat and the bases within its configuration.
+CREATE TABLE IF NOT EXISTS scan_workers (
+	name varchar(256) PRIMARY KEY,
+
+	-- The bases the worker can reach, only these are assigned to it.
+	bases bigint[] NOT NULL DEFAULT '{}',
+
+	seen timestamptz NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE IF EXISTS scan_workers OWNER TO frame;
+
+-- Which worker scans each base, kept up to date by the coordinator.
+CREATE TABLE IF NOT EXISTS scan_assign (
+	bid bigint PRIMARY KEY,
+	worker varchar(256) NOT NULL,
+
+	assigned timestamptz NOT NULL DEFAULT NOW(),
+
+	FOREIGN KEY ( bid ) REFERENCES base
+);
+
+ALTER TABLE IF EXISTS scan_assign OWNER TO frame;
+
+-- Every hash found in more then one enabled file, and where each copy is.
+--
+-- Duplicates are expected (see files.hid), this is only to help find and clean up redundant copies, see "frame dupes".
+--
+-- Each location is "bid:path/name", and tags are the combined tags of every copy.
+CREATE OR REPLACE VIEW duplicates AS
+	SELECT
+		f.hid,
+		h.hash,
+		count(*) AS copies,
+		array_agg(p.bid || ':' || p.name || '/' || f.name ORDER BY p.bid, p.name, f.name) AS locations,
+		(SELECT array_agg(DISTINCT t) FROM files.files f2, unnest(f2.tags) t WHERE f2.hid = f.hid AND f2.enabled) AS tags
+	FROM
+		files.files f
+		JOIN files.paths p ON p.pid = f.pid
+		JOIN files.hashes h ON h.hid = f.hid
+	WHERE
+		f.enabled AND p.enabled
+	GROUP BY
+		f.hid, h.hash
+	HAVING
+		count(*) > 1;
+
+ALTER VIEW duplicates OWNER TO frame;
+
+-- Tags given to a hash as a whole by something other then its files, such as the classify module.
+--
+-- One row per source, cmerge combines them with the tags of the files (see its auto and auto-poll queries).
+-- A hash with a row but no tags was checked and nothing found, so it is not checked again.
+CREATE TABLE IF NOT EXISTS autotags (
+	hid bigint NOT NULL,
+
+	-- Who gave the tags, such as "classify".
+	source varchar(64) NOT NULL,
+
+	tags bigint[] NOT NULL,
+
+	updated timestamptz NOT NULL DEFAULT NOW(),
+
+	FOREIGN KEY ( hid ) REFERENCES hashes,
+	UNIQUE ( hid, source )
+);
+
+ALTER TABLE IF EXISTS autotags OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS autotags_updated ON autotags ( updated );
+
+CREATE OR REPLACE FUNCTION autotags_upd() RETURNS trigger
+	LANGUAGE plpgsql SECURITY DEFINER
+	AS $$
+		BEGIN
+			IF NEW.tags != OLD.tags THEN
+				NEW.updated = NOW();
+			END IF;
+			RETURN NEW;
+		END;
+	$$;
+
+ALTER FUNCTION autotags_upd() OWNER TO frame ;
+
+CREATE TRIGGER autotags_upd BEFORE UPDATE ON files.autotags FOR EACH ROW EXECUTE FUNCTION autotags_upd();
+
+-- The face clusters of the faces module, each (hopefully) a single person.
+--
+-- Give one a label with "frame faces label", every hash with a face in it is then tagged with the label.
+CREATE TABLE IF NOT EXISTS faceclusters (
+	fcid bigserial PRIMARY KEY,
+
+	-- Empty until labeled.
+	label varchar(128) NOT NULL DEFAULT '',
+
+	-- The average embedding of every face in the cluster.
+	centroid real[] NOT NULL,
+	faces integer NOT NULL DEFAULT 0,
+
+	-- When the label last changed.
+	updated timestamptz NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE IF EXISTS faceclusters OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS faceclusters_updated ON faceclusters ( updated );
+
+CREATE OR REPLACE FUNCTION faceclusters_upd() RETURNS trigger
+	LANGUAGE plpgsql SECURITY DEFINER
+	AS $$
+		BEGIN
+			IF NEW.label != OLD.label THEN
+				NEW.updated = NOW();
+			END IF;
+			RETURN NEW;
+		END;
+	$$;
+
+ALTER FUNCTION faceclusters_upd() OWNER TO frame ;
+
+CREATE TRIGGER faceclusters_upd BEFORE UPDATE ON files.faceclusters FOR EACH ROW EXECUTE FUNCTION faceclusters_upd();
+
+-- Every face found by the faces module.
+CREATE TABLE IF NOT EXISTS faces (
+	faid bigserial PRIMARY KEY,
+	hid bigint NOT NULL,
+	fcid bigint NOT NULL,
+
+	embedding real[] NOT NULL,
+
+	added timestamptz NOT NULL DEFAULT NOW(),
+
+	FOREIGN KEY ( hid ) REFERENCES hashes,
+	FOREIGN KEY ( fcid ) REFERENCES faceclusters
+);
+
+ALTER TABLE IF EXISTS faces OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS faces_hid ON faces ( hid );
+CREATE INDEX IF NOT EXISTS faces_fcid ON faces ( fcid );
+CREATE INDEX IF NOT EXISTS faces_added ON faces ( added );
+
+-- Every image flagged by the screen module, when its audit query is set.
+--
+-- Append only, so what was hidden and why can be reviewed even after the tags in autotags change.
+CREATE TABLE IF NOT EXISTS flagged (
+	hid bigint NOT NULL,
+
+	-- Who flagged it, such as "screen".
+	source varchar(64) NOT NULL,
+
+	-- The score of each suspect label, such as {"porn": 0.91}.
+	scores jsonb NOT NULL,
+
+	flagged timestamptz NOT NULL DEFAULT NOW(),
+
+	FOREIGN KEY ( hid ) REFERENCES hashes
+);
+
+ALTER TABLE IF EXISTS flagged OWNER TO frame;
+
+CREATE INDEX IF NOT EXISTS flagged_flagged ON flagged ( flagged );
+
 -- End Files }}}
 
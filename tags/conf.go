@@ -3,7 +3,6 @@ package tags
 import (
 	"errors"
 	"sort"
-	"strings"
 	"sync"
 )
 
@@ -217,8 +216,7 @@ func (tm *TestTM) Get(in string) (uint64, error) {
 	tm.tMut.Lock()
 	defer tm.tMut.Unlock()
 
-	in = strings.ToLower(in)
-	in = strings.TrimSpace(in)
+	in = Normalize(in)
 	if in == "" {
 		return 0, errors.New("Empty tag")
 	}
@@ -0,0 +1,131 @@
+package tags
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Different tools write the same tag in different ways. One uses a precomposed "é" where another uses "e" and a
+// combining accent, one writes "STRASSE" and another "Straße". Lower casing alone keeps each of those a different tag.
+//
+// So every tag name is normalized (see Normalize()) before it is looked up, by the TagManager and anything else that
+// compares tag names.
+
+// type translit struct {{{
+
+// The transliteration set by SetTransliterate().
+type translit struct {
+	stripMarks bool
+
+	// nil if there is no mapping.
+	rep *strings.Replacer
+} // }}}
+
+// Access only with atomics, always a *translit.
+var tlit atomic.Value
+
+func init() {
+	tlit.Store(&translit{})
+}
+
+// func SetTransliterate {{{
+
+// Sets the optional transliteration done by Normalize() after case folding.
+//
+// With stripMarks every accent and other combining mark is removed, so "café" becomes "cafe". mapping replaces any
+// text with another, such as "æ" with "ae", the longest match first. Its keys are normalized the same as a tag first,
+// so are not case sensitive.
+//
+// Changing this changes what every tag name normalizes to, so tags already in the database under the old form are
+// no longer matched.
+func SetTransliterate(stripMarks bool, mapping map[string]string) error {
+	tl := &translit{stripMarks: stripMarks}
+
+	if len(mapping) > 0 {
+		keys := make([]string, 0, len(mapping))
+		folded := make(map[string]string, len(mapping))
+
+		for from, to := range mapping {
+			key := fold(from)
+			if key == "" {
+				return errors.New("empty transliteration")
+			}
+
+			keys = append(keys, key)
+			folded[key] = fold(to)
+		}
+
+		// strings.Replacer prefers the earlier of two matches at the same place, so longest first.
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) > len(keys[j])
+			}
+
+			return keys[i] < keys[j]
+		})
+
+		pairs := make([]string, 0, len(keys)*2)
+		for _, key := range keys {
+			pairs = append(pairs, key, folded[key])
+		}
+
+		tl.rep = strings.NewReplacer(pairs...)
+	}
+
+	tlit.Store(tl)
+
+	return nil
+} // }}}
+
+// func Normalize {{{
+
+// Returns the name of the tag in its normalized form, "" if there is nothing left.
+//
+// Surrounding spaces (and any byte order mark) are removed, the name is put into Unicode NFC and fully case folded
+// rather then simply lower cased, then transliterated if set (see SetTransliterate()).
+func Normalize(name string) string {
+	name = fold(name)
+
+	tl := tlit.Load().(*translit)
+
+	if tl.rep != nil {
+		name = tl.rep.Replace(name)
+	}
+
+	if tl.stripMarks {
+		t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+		if out, _, err := transform.String(t, name); err == nil {
+			name = out
+		}
+	}
+
+	return strings.TrimSpace(name)
+} // }}}
+
+// func Legacy {{{
+
+// Returns the name as it was stored before Normalize() existed, simply lower cased with surrounding spaces removed.
+//
+// For most names this is the same as Normalize(), but not all ("Straße" was "straße" and is now "strasse"), so the
+// TagManager still looks for tags added under this form before adding a new one.
+func Legacy(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+} // }}}
+
+// func fold {{{
+
+func fold(name string) string {
+	name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "\ufeff"))
+
+	// Folding can decompose, so NFC once more after.
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(name)))
+} // }}}
@@ -0,0 +1,92 @@
+package tags
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	same := [][]string{
+		{"Kinder", "kinder", "KINDER", " kinder\t"},
+
+		// Precomposed and combining accent.
+		{"Caf\u00e9", "cafe\u0301", "CAF\u00c9"},
+
+		// Full case folding, not just lower casing.
+		{"Straße", "STRASSE", "strasse", "STRAẞE"},
+		{"ΟΔΟΣ", "οδος", "οδοσ"},
+
+		// A byte order mark from a tag file.
+		{"\ufeffurlaub", "Urlaub"},
+	}
+
+	for _, names := range same {
+		want := Normalize(names[0])
+
+		for _, name := range names[1:] {
+			if got := Normalize(name); got != want {
+				t.Fatalf("Normalize(%q) Expected %q != Got %q", name, want, got)
+			}
+		}
+	}
+
+	// Accents are kept unless stripped.
+	if Normalize("café") == Normalize("cafe") {
+		t.Fatalf("Normalize(café) stripped the accent")
+	}
+
+	if err := SetTransliterate(true, map[string]string{"Æ": "ae", "ø": "o"}); err != nil {
+		t.Fatalf("SetTransliterate: %s", err)
+	}
+
+	defer SetTransliterate(false, nil)
+
+	tests := map[string]string{
+		"Café":         "cafe",
+		"Ærø":          "aero",
+		"Crème Brûlée": "creme brulee",
+		"Straße":       "strasse",
+	}
+
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Fatalf("Normalize(%q) Expected %q != Got %q", in, want, got)
+		}
+	}
+
+	if err := SetTransliterate(false, map[string]string{" ": "x"}); err == nil {
+		t.Fatalf("SetTransliterate accepted an empty key")
+	}
+
+	// The TagManager used for testing normalizes the same.
+	tm := NewTestTM()
+
+	a, _ := tm.Get("KINDER")
+	b, _ := tm.Get("kinder")
+
+	if a != b {
+		t.Fatalf("TestTM.Get Expected %d != Got %d", a, b)
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	tests := map[string]string{
+		" Kinder ": "kinder",
+		"Straße":   "straße",
+		"ΟΔΟΣ":     "οδοσ",
+	}
+
+	for in, want := range tests {
+		if got := Legacy(in); got != want {
+			t.Fatalf("Legacy(%q) Expected %q != Got %q", in, want, got)
+		}
+	}
+
+	// Only differs from Normalize() for some names, which the TagManager checks both of.
+	if Legacy("Kinder") != Normalize("Kinder") {
+		t.Fatalf("Legacy(Kinder) Expected %q != Got %q", Normalize("Kinder"), Legacy("Kinder"))
+	}
+
+	if Legacy("Straße") == Normalize("Straße") {
+		t.Fatalf("Legacy(Straße) Expected != %q", Normalize("Straße"))
+	}
+}
@@ -0,0 +1,49 @@
+package tags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// type TagRefs map {{{
+
+// Where the configuration refers to each tag, such as "profile beach" or "tagrule sunset".
+//
+// Used to warn about tags no image has, as a typo in a tag name otherwise just gives an empty pool without a word
+// about why. See Missing().
+type TagRefs map[uint64][]string // }}}
+
+// func TagRefs.Add {{{
+
+// Adds where for every tag in t.
+func (tr TagRefs) Add(t Tags, where string) {
+	for _, tag := range t {
+		if refs := tr[tag]; len(refs) > 0 && refs[len(refs)-1] == where {
+			continue
+		}
+
+		tr[tag] = append(tr[tag], where)
+	}
+} // }}}
+
+// func TagRefs.Missing {{{
+
+// Returns a warning for each tag has() returns false for, sorted.
+//
+// name gives the name of a tag for the warnings.
+func (tr TagRefs) Missing(has func(uint64) bool, name func(uint64) string) []string {
+	var out []string
+
+	for tag, refs := range tr {
+		if has(tag) {
+			continue
+		}
+
+		out = append(out, fmt.Sprintf("tag %q is not on any image (%s)", name(tag), strings.Join(refs, ", ")))
+	}
+
+	sort.Strings(out)
+
+	return out
+} // }}}
@@ -1,3 +1,6 @@
+// Tags, tag rules and tag normalization, the tag engine the weighter and rules work with.
+//
+// This is public API, see "Public packages" in README.md for what that promises.
 package tags
 
 import (
@@ -767,6 +770,67 @@ func (tr *TagRule) Give(t Tags) bool {
 	return false
 } // }}}
 
+// func TagRule.Wants {{{
+
+// Returns the Any and All tags of the rule, those an image needs at least one of for the rule to apply.
+//
+// A rule with only None tags wants nothing in particular, so returns an empty Tags.
+func (tr *TagRule) Wants() Tags {
+	t := make(Tags, 0, len(tr.trTags))
+
+	for _, trt := range tr.trTags {
+		if trt.flag == trfAny || trt.flag == trfAll {
+			t = append(t, trt.tag)
+		}
+	}
+
+	// trTags are already sorted.
+	return t
+} // }}}
+
+// func TagRule.Any {{{
+
+// Returns the Any tags of the rule.
+func (tr *TagRule) Any() Tags {
+	return tr.flagged(trfAny)
+} // }}}
+
+// func TagRule.All {{{
+
+// Returns the All tags of the rule.
+func (tr *TagRule) All() Tags {
+	return tr.flagged(trfAll)
+} // }}}
+
+// func TagRule.flagged {{{
+
+func (tr *TagRule) flagged(flag int) Tags {
+	var t Tags
+
+	for _, trt := range tr.trTags {
+		if trt.flag == flag {
+			t = append(t, trt.tag)
+		}
+	}
+
+	// trTags are already sorted.
+	return t
+} // }}}
+
+// func TagRule.Tags {{{
+
+// Returns every tag the rule checks, Any, All and None.
+func (tr *TagRule) Tags() Tags {
+	t := make(Tags, 0, len(tr.trTags))
+
+	for _, trt := range tr.trTags {
+		t = append(t, trt.tag)
+	}
+
+	// trTags are already sorted.
+	return t
+} // }}}
+
 // func TagRule.Combine {{{
 
 // This combines the Any, All and None tags from the r TagRule into tr.
@@ -1,14 +1,22 @@
+// Interfaces and types shared between every module of frame, such as WeighterProfile, CacheManager and TagManager.
+//
+// This is public API, see "Public packages" in README.md for what that promises.
 package types
 
 import (
 	"errors"
 	"frame/tags"
 	"image"
+	"image/color"
 	"io"
+	"time"
 )
 
 var ErrShutdown = errors.New("Shutdown")
 
+// Returned when an ID or hash is not known, or its image is no longer in the cache.
+var ErrNotFound = errors.New("not found")
+
 // type WeighterProfile interface {{{
 
 type WeighterProfile interface {
@@ -22,6 +30,26 @@ type WeighterProfile interface {
 	Get(uint8) ([]uint64, error)
 } // }}}
 
+// type WeighterImage struct {{{
+
+// An image selected from a WeighterProfile along with its hash, see WeighterProfileHashes.
+type WeighterImage struct {
+	ID uint64
+
+	// Empty if the Weighter does not know it.
+	Hash string
+} // }}}
+
+// type WeighterProfileHashes interface {{{
+
+// Optional interface a WeighterProfile can provide, giving the hash of each image along with its ID.
+//
+// For those addressing images by their hash, saving asking the IDManager for each one.
+type WeighterProfileHashes interface {
+	// Same as Get(), but with the hash of each image.
+	GetWithHashes(uint8) ([]WeighterImage, error)
+} // }}}
+
 // type Weighter interface {{{
 
 type Weighter interface {
@@ -30,6 +58,125 @@ type Weighter interface {
 	GetProfile(string) (WeighterProfile, error)
 } // }}}
 
+// type WeighterDelta struct {{{
+
+// The images added and removed by a Weighter from a single poll or full.
+//
+// See WeighterSubscriber.
+type WeighterDelta struct {
+	// If this came from a full rather then a poll.
+	Full bool
+
+	Added   []uint64
+	Removed []uint64
+} // }}}
+
+// type WeighterSubscriber interface {{{
+
+// Optional interface a Weighter can provide, letting others know exactly what images were added or
+// removed rather then having to track it themselves.
+type WeighterSubscriber interface {
+	// Returns a channel that receives a WeighterDelta after each poll or full that changed at least one image,
+	// and a function to call when no longer wanted, which closes the channel.
+	//
+	// The channel is buffered, but if the subscriber falls behind deltas are dropped rather then blocking the Weighter.
+	Subscribe() (<-chan WeighterDelta, func())
+} // }}}
+
+// type WeighterInvalidator interface {{{
+
+// Optional interface a Weighter can provide, letting whoever loads the images report IDs that can no longer be
+// loaded (see ErrNotFound), such as when the cached file was removed.
+//
+// The Weighter stops returning the ID from any profile, rather then it continuing to fail until the database
+// catches up.
+type WeighterInvalidator interface {
+	Invalidate(uint64)
+} // }}}
+
+// type ShownImage struct {{{
+
+// A single image within an output, see WeighterUsage.
+type ShownImage struct {
+	ID uint64 `json:"id"`
+
+	// The profile it was selected from.
+	Profile string `json:"profile"`
+} // }}}
+
+// type WeighterUsage interface {{{
+
+// Optional interface a Weighter can provide, letting whoever shows the images report which it actually showed.
+//
+// This is what was written, not everything selected, as a render may not have room for every image or may fail.
+type WeighterUsage interface {
+	// Records the images shown together within the output (such as the file written) at the time.
+	Shown(output string, images []ShownImage, at time.Time)
+} // }}}
+
+// type UsageWindow struct {{{
+
+// How often images were shown within a window of time, see WeighterStats.
+type UsageWindow struct {
+	Start time.Time
+	End   time.Time
+
+	// How many outputs were written, such as each file Render wrote.
+	Outputs int
+
+	// How many times each was shown, by image ID, the profile it was selected from and each of its tags.
+	//
+	// Tags are only known for images the Weighter still has, so an image since removed only counts towards the others.
+	Images   map[uint64]int
+	Profiles map[string]int
+	Tags     map[string]int
+} // }}}
+
+// type WeighterStats interface {{{
+
+// Optional interface a Weighter can provide, reporting what was shown as recorded by WeighterUsage.
+type WeighterStats interface {
+	// Counts what was shown from (inclusive) until to (exclusive), split into windows each lasting window.
+	//
+	// A window of 0 gives a single window covering it all.
+	UsageStats(from, to time.Time, window time.Duration) ([]UsageWindow, error)
+} // }}}
+
+// type WeighterBlocker interface {{{
+
+// Optional interface a Weighter can provide, warning about profiles that want tags whoever merges the images blocks
+// (such as the CMerge BlockTags), as every image with them is left out.
+type WeighterBlocker interface {
+	// fn returns the tags currently blocked, called each time the profiles are checked.
+	SetBlockTags(fn func() tags.Tags)
+} // }}}
+
+// type WeighterHashPoller interface {{{
+
+// Optional interface a Weighter can provide, loading the given hash IDs right away rather then at its next poll.
+type WeighterHashPoller interface {
+	PollHashes(ids []uint64) error
+} // }}}
+
+// type WeighterTags interface {{{
+
+// Optional interface a Weighter can provide, naming the tags of an image it has.
+type WeighterTags interface {
+	// Returns the names of the tags of the image, sorted. nil if the Weighter does not have the image.
+	ImageTags(uint64) []string
+} // }}}
+
+// type WeighterLister interface {{{
+
+// Optional interface a Weighter can provide, listing what profiles exist.
+type WeighterLister interface {
+	// Returns the names of every profile, sorted.
+	Profiles() []string
+
+	// Returns how many images are in each profile.
+	ProfileCounts() map[string]int
+} // }}}
+
 // type TagManager interface {{{
 
 // To do any shutdown work a TagManager should be provided a proper context.Context.
@@ -41,6 +188,15 @@ type TagManager interface {
 	Name(uint64) (string, error)
 } // }}}
 
+// type TagLooker interface {{{
+
+// Optional interface a TagManager can provide, looking up the id of a tag only if it already exists.
+//
+// Unlike Get() an unknown tag is never added, instead ErrNotFound is returned.
+type TagLooker interface {
+	Lookup(string) (uint64, error)
+} // }}}
+
 // type IDManager interface {{{
 
 // Maps between hashes and uint64 (IDs).
@@ -52,6 +208,55 @@ type IDManager interface {
 	GetHash(uint64) (string, error)
 } // }}}
 
+// type IDRehasher interface {{{
+
+// Optional interface an IDManager can provide, changing the hash an ID maps to while keeping the ID itself.
+//
+// Used when moving to a new hashing algorithm, so everything referring to the ID (tags, history) carries on.
+type IDRehasher interface {
+	Rehash(id uint64, hash string) error
+} // }}}
+
+// type IDTokener interface {{{
+
+// Optional interface an IDManager can provide, giving each ID an opaque token.
+//
+// For referring to an image somewhere public (a URL or QR code for example) without giving away the ID, which is
+// sequential, or the hash, which identifies the file itself.
+type IDTokener interface {
+	// Returns the token for the ID, always the same for the same ID.
+	TokenFor(uint64) (string, error)
+
+	// Returns the ID the token was given for, ErrNotFound if it was not one of ours.
+	IDFor(string) (uint64, error)
+} // }}}
+
+// type CacheRehasher interface {{{
+
+// Optional interface a CacheManager can provide while moving to a new hashing algorithm.
+type CacheRehasher interface {
+	// Returns true if the image needs to be given to CacheImageRaw() again so its new hash is known.
+	NeedsRehash(uint64) bool
+} // }}}
+
+// type CacheBaser interface {{{
+
+// Optional interface a CacheManager can provide to cache the images of each ImageProc base differently, such as at
+// a different resolution or within a different directory.
+type CacheBaser interface {
+	// Same as CacheImageRaw(), for an image found within the base.
+	CacheImageRawBase(int, io.Reader) (uint64, error)
+} // }}}
+
+// type CachePixelHasher interface {{{
+
+// Optional interface a CacheManager can provide to identify an image by its decoded pixels rather then the bytes of
+// the file, so the same image saved with different metadata or compression is only cached once.
+type CachePixelHasher interface {
+	// Same as CacheImageRawBase(), hashing the pixels of the image after it is rotated and before it is resized.
+	CacheImagePixelsBase(int, io.Reader) (uint64, error)
+} // }}}
+
 // type CacheManager interface {{{
 
 // Used to handle all our image caching needs.
@@ -69,14 +274,14 @@ type IDManager interface {
 // Pretty much the best way to handle all this is just allowing the callers
 // to provied raw io types back and fourth.
 type CacheManager interface {
-	// Given a raw io.Reader to an image of either JPEG, PNG, GIF or WebP
+	// Given a raw io.Reader to an image of either JPEG, PNG, GIF, WebP, TIFF or BMP
 	// it will hash to the cache using whatever hash method its configured for,
 	// cache it and then return the ID provided by IDManager.
 	//
 	// Note that since this is using an io.Reader, its up to the caller to
 	// call and Close() after it returns if needed (like os.File).
 	//
-	// Only the 4 types above are supported, any other types please use
+	// Only the types above are supported, any other types please use
 	// CacheImage() instead.
 	CacheImageRaw(io.Reader) (uint64, error)
 
@@ -99,6 +304,53 @@ type CacheManager interface {
 	// If the provided image.Point is 0x0 then the original size will
 	// be returned.
 	LoadImage(uint64, image.Point, bool) (image.Image, error)
+
+	// Returns a small thumbnail of the image, for previews and such where decoding the full image would be a waste.
+	LoadThumb(uint64) (image.Image, error)
+
+	// Returns what is known about the image with the provided ID, without having to load the image itself.
+	Metadata(uint64) (*ImageMetadata, error)
+
+	// Returns true if the image with the provided ID is in the cache and can be loaded.
+	Has(uint64) bool
+
+	// Returns details of the cached image with the provided ID without decoding it, ErrNotFound if it is not cached.
+	Stat(uint64) (CacheStat, error)
+} // }}}
+
+// type CacheStat struct {{{
+
+// Details of an image within the cache, see CacheManager.Stat().
+type CacheStat struct {
+	// Dimensions of the image as stored in the cache, what LoadImage() returns when not resized.
+	Size image.Point
+
+	// Size of the cached file in bytes.
+	Bytes int64
+
+	// The format of the original, see ImageMetadata.Format. Empty if not known.
+	Format string
+
+	// When the image was cached.
+	ModTime time.Time
+} // }}}
+
+// type ImageMetadata struct {{{
+
+// Details recorded about an image by the CacheManager when it is cached.
+type ImageMetadata struct {
+	// Dimensions of the original image, and of the image as stored in the cache.
+	Original image.Point
+	Cached   image.Point
+
+	// The format of the original, "jpeg", "png", "gif", "webp", "tiff" or "bmp".
+	Format string
+
+	// The most common colors of the image, most common first.
+	Colors []color.NRGBA
+
+	// How long it took to decode the original.
+	DecodeCost time.Duration
 } // }}}
 
 // type Profile struct {{{
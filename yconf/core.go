@@ -1,10 +1,15 @@
 // YAML configuration for Frame.
+//
+// This is public API, see "Public packages" in README.md for what that promises.
 package yconf
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"frame/internal/redact"
+	"frame/internal/scheduler"
+	"frame/internal/secrets"
 	"github.com/rs/zerolog"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -69,7 +74,21 @@ func (yc *YConf) Start() error {
 		return err
 	}
 
-	go yc.loopy()
+	// Handles automatic checking for new or changed configuration files.
+	yc.sch = scheduler.New(yc.ctx)
+
+	// Configuration changes are still wanted while paused, such as to change what is being paused for.
+	yc.sch.IgnorePause()
+
+	if err := yc.sch.Add("check", time.Minute, yc.tick); err != nil {
+		fl.Err(err).Msg("Add")
+		return err
+	}
+
+	go func() {
+		<-yc.ctx.Done()
+		yc.close()
+	}()
 
 	// Looks like we have everything loaded fine.
 	return nil
@@ -386,7 +405,7 @@ func (yc *YConf) loadConfFile(lo *loaded, file string) error {
 
 	ei := yc.ca.Empty()
 
-	fl.Debug().Interface("empty", ei).Send()
+	fl.Debug().Interface("empty", redact.Conf(ei)).Send()
 
 	// Load the new configuration.
 	if err := yaml.NewDecoder(f).Decode(ei); err != nil {
@@ -394,13 +413,19 @@ func (yc *YConf) loadConfFile(lo *loaded, file string) error {
 		return fmt.Errorf("decode(%s): %s", file, err)
 	}
 
+	// Secrets are resolved here so no module needs to know about them.
+	if err := secrets.ResolveStruct(ei); err != nil {
+		fl.Err(err).Msg("secrets")
+		return fmt.Errorf("secrets(%s): %s", file, err)
+	}
+
 	if yc.ca.Convert != nil {
 		ei, err = yc.ca.Convert(ei)
 		if err != nil {
 			fl.Err(err).Msg("convert")
 			return err
 		}
-		fl.Debug().Interface("converted", ei).Send()
+		fl.Debug().Interface("converted", redact.Conf(ei)).Send()
 	}
 
 	// Something already loaded?
@@ -413,17 +438,17 @@ func (yc *YConf) loadConfFile(lo *loaded, file string) error {
 				fl.Err(err).Msg("merge")
 				return err
 			}
-			fl.Debug().Interface("merged", lo.conf).Send()
+			fl.Debug().Interface("merged", redact.Conf(lo.conf)).Send()
 		} else {
 			fl.Debug().Msg("replace")
 			// No merge, so just replace.
 			lo.conf = ei
 		}
-		fl.Debug().Interface("loaded", lo.conf).Send()
+		fl.Debug().Interface("loaded", redact.Conf(lo.conf)).Send()
 	} else {
 		// Nope, first load - So just set conf.
 		lo.conf = ei
-		fl.Debug().Interface("loaded", lo.conf).Send()
+		fl.Debug().Interface("loaded", redact.Conf(lo.conf)).Send()
 	}
 
 	return nil
@@ -453,29 +478,13 @@ func (yc *YConf) isConf(name string) bool {
 	return false
 } // }}}
 
-// func YConf.loopy {{{
-
-// Handles automatic checking for new or changed configuration files.
-func (yc *YConf) loopy() {
-	fl := yc.l.With().Str("func", "loopy").Logger()
-	ctx := yc.ctx
-
-	// Basic tracking ticker, runs every minute.
-	tick := time.NewTicker(time.Minute)
-	defer tick.Stop()
-
-	for {
-		select {
-		case <-tick.C:
-			fl.Debug().Msg("tick")
-			yc.CheckConf()
-		case _, ok := <-ctx.Done():
-			if !ok {
-				yc.close()
-				return
-			}
-		}
-	}
+// func YConf.tick {{{
+
+// Run by the scheduler every minute to check for new or changed configuration files.
+func (yc *YConf) tick() {
+	fl := yc.l.With().Str("func", "tick").Logger()
+	fl.Debug().Msg("tick")
+	yc.CheckConf()
 } // }}}
 
 type fileSort []os.FileInfo
@@ -0,0 +1,51 @@
+package yconf
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// type Duration int64 {{{
+
+// A time.Duration for the YAML configuration of every module, so they all take the same "90s", "5m" or "1h30m".
+//
+// yaml.v3 can decode a time.Duration itself, but a mistake such as "5 minutes" or a plain 300 only gives a rather
+// unhelpful "cannot unmarshal" error. This says what is wrong, the line it is on and what is wanted instead.
+//
+// Convert with time.Duration(d) to use it.
+type Duration time.Duration // }}}
+
+// func Duration.UnmarshalYAML {{{
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: a duration such as \"90s\" or \"5m\" is needed", n.Line)
+	}
+
+	if n.ShortTag() == "!!int" || n.ShortTag() == "!!float" {
+		return fmt.Errorf("line %d: duration %s has no units, such as \"%ss\" for seconds (see frame config-migrate)", n.Line, n.Value, n.Value)
+	}
+
+	td, err := time.ParseDuration(n.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q, such as \"90s\" or \"5m\" is needed", n.Line, n.Value)
+	}
+
+	*d = Duration(td)
+
+	return nil
+} // }}}
+
+// func Duration.MarshalYAML {{{
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+} // }}}
+
+// func Duration.String {{{
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+} // }}}
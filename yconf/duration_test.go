@@ -0,0 +1,44 @@
+package yconf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		In       string
+		Expected time.Duration
+		Err      string
+	}{
+		{`"5m"`, 5 * time.Minute, ""},
+		{`1h30m`, 90 * time.Minute, ""},
+		{`300`, 0, "no units"},
+		{`1.5`, 0, "no units"},
+		{`5 minutes`, 0, "invalid duration"},
+		{`[5m]`, 0, "a duration"},
+	}
+
+	for _, test := range tests {
+		var out struct {
+			D Duration `yaml:"d"`
+		}
+
+		err := yaml.Unmarshal([]byte("d: "+test.In), &out)
+
+		if test.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Err) || !strings.Contains(err.Error(), "line 1") {
+				t.Errorf("%s Expected error %q != Got %v", test.In, test.Err, err)
+			}
+
+			continue
+		}
+
+		if err != nil || time.Duration(out.D) != test.Expected {
+			t.Errorf("%s Expected %s != Got %s (%v)", test.In, test.Expected, out.D, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package yconf
 
 import (
 	"context"
+	"frame/internal/scheduler"
 	"github.com/rs/zerolog"
 	"sync"
 	"time"
@@ -89,6 +90,9 @@ type YConf struct {
 	// If we are not we do not call Notify.
 	started uint32
 
+	// Runs the regular CheckConf(), once started.
+	sch *scheduler.Scheduler
+
 	loMut sync.RWMutex
 	lo    *loaded
 }